@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cliConfig is stored at ~/.config/expenseowl/cli.json
+type cliConfig struct {
+	Server string `json:"server"`
+	APIKey string `json:"apiKey"`
+}
+
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "expenseowl", "cli.json"), nil
+}
+
+// loadConfig reads the config file, then applies EXPENSEOWL_SERVER and EXPENSEOWL_API_KEY overrides
+func loadConfig() (cliConfig, error) {
+	cfg := cliConfig{Server: "http://localhost:8080"}
+	path, err := configPath()
+	if err != nil {
+		return cfg, err
+	}
+	if content, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(content, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return cfg, err
+	}
+	if server := os.Getenv("EXPENSEOWL_SERVER"); server != "" {
+		cfg.Server = server
+	}
+	if apiKey := os.Getenv("EXPENSEOWL_API_KEY"); apiKey != "" {
+		cfg.APIKey = apiKey
+	}
+	cfg.Server = strings.TrimRight(cfg.Server, "/")
+	return cfg, nil
+}
+
+func saveConfig(cfg cliConfig) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	content, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, content, 0600)
+}
+
+// client talks to a remote ExpenseOwl instance
+type client struct {
+	cfg  cliConfig
+	http *http.Client
+}
+
+func newClient(cfg cliConfig) *client {
+	return &client{cfg: cfg, http: &http.Client{Timeout: 5 * time.Minute}}
+}
+
+func (c *client) do(method string, path string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.cfg.Server+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		content, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(content, &errResp) == nil && errResp.Error != "" {
+			return nil, fmt.Errorf("%s %s: %s (%d)", method, path, errResp.Error, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return resp, nil
+}
+
+// doJSON sends an optional JSON body and decodes the JSON response into out (if not nil)
+func (c *client) doJSON(method string, path string, in any, out any) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		content, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(content)
+		contentType = "application/json"
+	}
+	resp, err := c.do(method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// upload posts a file as the "file" field of a multipart form
+func (c *client) upload(path string, fileName string, out any) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	part, err := writer.CreateFormFile("file", filepath.Base(fileName))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodPost, path, writer.FormDataContentType(), &buf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
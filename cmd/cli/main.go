@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+var version = "dev"
+
+const usage = `expenseowl-cli - manage expenses on an ExpenseOwl server
+
+Usage:
+  expenseowl-cli <command> [flags]
+
+Commands:
+  config      save server URL and API key to the config file
+  add         add an expense or income
+  list        list expenses, optionally filtered
+  import      import expenses from a CSV file
+  export      export all expenses as CSV
+  categories  list, add, or remove categories
+  version     print the CLI version
+
+Run "expenseowl-cli <command> -h" for command flags.
+The server and API key can also be set with EXPENSEOWL_SERVER and EXPENSEOWL_API_KEY.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		fatalf("Failed to load config: %v", err)
+	}
+	c := newClient(cfg)
+	args := os.Args[2:]
+
+	switch os.Args[1] {
+	case "config":
+		err = runConfig(cfg, args)
+	case "add":
+		err = runAdd(c, args)
+	case "list":
+		err = runList(c, args)
+	case "import":
+		err = runImport(c, args)
+	case "export":
+		err = runExport(c, args)
+	case "categories":
+		err = runCategories(c, args)
+	case "version":
+		fmt.Println(version)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+func runConfig(cfg cliConfig, args []string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	server := fs.String("server", cfg.Server, "ExpenseOwl server URL")
+	apiKey := fs.String("api-key", cfg.APIKey, "API key sent as a bearer token")
+	fs.Parse(args)
+	cfg.Server = strings.TrimRight(*server, "/")
+	cfg.APIKey = *apiKey
+	path, err := saveConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	fmt.Printf("Saved config to %s\n", path)
+	return nil
+}
+
+func runAdd(c *client, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	name := fs.String("name", "", "expense name (required)")
+	amount := fs.Float64("amount", 0, "amount as a positive number (required)")
+	category := fs.String("category", "", "category (required)")
+	subCategory := fs.String("subcategory", "", "subcategory")
+	date := fs.String("date", "", "date as YYYY-MM-DD (default today)")
+	tags := fs.String("tags", "", "comma separated tags")
+	income := fs.Bool("income", false, "record as income instead of an expense")
+	fs.Parse(args)
+	if *name == "" || *amount <= 0 || *category == "" {
+		fs.Usage()
+		return fmt.Errorf("name, a positive amount, and category are required")
+	}
+
+	expense := storage.Expense{
+		Name:        *name,
+		Category:    *category,
+		SubCategory: *subCategory,
+		Amount:      -*amount,
+		Date:        time.Now(),
+	}
+	if *income {
+		expense.Amount = *amount
+	}
+	if *date != "" {
+		d, err := time.ParseInLocation("2006-01-02", *date, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid date '%s', expected YYYY-MM-DD", *date)
+		}
+		// keep the current time of day, as the web UI does
+		now := time.Now()
+		expense.Date = time.Date(d.Year(), d.Month(), d.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.Local)
+	}
+	if *tags != "" {
+		expense.Tags = strings.Split(*tags, ",")
+	}
+	var saved storage.Expense
+	if err := c.doJSON(http.MethodPut, "/expense", expense, &saved); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s: %.2f (%s)\n", saved.Name, saved.Amount, saved.Category)
+	return nil
+}
+
+func runList(c *client, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	category := fs.String("category", "", "only show this category")
+	from := fs.String("from", "", "only show expenses on or after YYYY-MM-DD")
+	to := fs.String("to", "", "only show expenses on or before YYYY-MM-DD")
+	search := fs.String("search", "", "case-insensitive text to find in name or tags")
+	limit := fs.Int("limit", 0, "maximum number of rows (0 for all)")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	fs.Parse(args)
+
+	var fromDate, toDate time.Time
+	var err error
+	if *from != "" {
+		if fromDate, err = time.Parse("2006-01-02", *from); err != nil {
+			return fmt.Errorf("invalid from date '%s', expected YYYY-MM-DD", *from)
+		}
+	}
+	if *to != "" {
+		if toDate, err = time.Parse("2006-01-02", *to); err != nil {
+			return fmt.Errorf("invalid to date '%s', expected YYYY-MM-DD", *to)
+		}
+		toDate = toDate.AddDate(0, 0, 1)
+	}
+
+	var expenses []storage.Expense
+	if err := c.doJSON(http.MethodGet, "/expenses", nil, &expenses); err != nil {
+		return err
+	}
+	needle := strings.ToLower(*search)
+	filtered := expenses[:0]
+	for _, exp := range expenses {
+		if *category != "" && !strings.EqualFold(exp.Category, *category) {
+			continue
+		}
+		if !fromDate.IsZero() && exp.Date.Before(fromDate) {
+			continue
+		}
+		if !toDate.IsZero() && !exp.Date.Before(toDate) {
+			continue
+		}
+		if needle != "" && !strings.Contains(strings.ToLower(exp.Name+" "+strings.Join(exp.Tags, " ")), needle) {
+			continue
+		}
+		filtered = append(filtered, exp)
+	}
+	slices.SortFunc(filtered, func(a, b storage.Expense) int {
+		return b.Date.Compare(a.Date)
+	})
+	if *limit > 0 && len(filtered) > *limit {
+		filtered = filtered[:*limit]
+	}
+
+	if *asJSON {
+		return writeIndentedJSON(os.Stdout, filtered)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tNAME\tCATEGORY\tAMOUNT\tTAGS\tID")
+	var total float64
+	for _, exp := range filtered {
+		cat := exp.Category
+		if exp.SubCategory != "" {
+			cat += "/" + exp.SubCategory
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\t%s\t%s\n", exp.Date.Local().Format("2006-01-02"), exp.Name, cat, exp.Amount, strings.Join(exp.Tags, ","), exp.ID)
+		total += exp.Amount
+	}
+	tw.Flush()
+	fmt.Printf("\n%d expenses, net %.2f\n", len(filtered), total)
+	return nil
+}
+
+func runImport(c *client, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	old := fs.Bool("old", false, "file is an export from ExpenseOwl v3.2 or older")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: expenseowl-cli import [-old] <file.csv>")
+	}
+	path := "/import/csv"
+	if *old {
+		path = "/import/csvold"
+	}
+	var result struct {
+		TotalProcessed int      `json:"total_processed"`
+		Imported       int      `json:"imported"`
+		Skipped        int      `json:"skipped"`
+		NewCategories  []string `json:"new_categories"`
+	}
+	if err := c.upload(path, fs.Arg(0), &result); err != nil {
+		return err
+	}
+	fmt.Printf("Processed %d rows: %d imported, %d skipped\n", result.TotalProcessed, result.Imported, result.Skipped)
+	if len(result.NewCategories) > 0 {
+		fmt.Printf("New categories: %s\n", strings.Join(result.NewCategories, ", "))
+	}
+	return nil
+}
+
+func runExport(c *client, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := fs.String("o", "", "output file (default stdout)")
+	fs.Parse(args)
+	resp, err := c.do(http.MethodGet, "/export/csv", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+func runCategories(c *client, args []string) error {
+	var categories []string
+	if err := c.doJSON(http.MethodGet, "/categories", nil, &categories); err != nil {
+		return err
+	}
+	if len(args) == 0 || args[0] == "list" {
+		for _, category := range categories {
+			fmt.Println(category)
+		}
+		return nil
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: expenseowl-cli categories [list | add <name> | remove <name>]")
+	}
+	name := args[1]
+	switch args[0] {
+	case "add":
+		if slices.ContainsFunc(categories, func(c string) bool { return strings.EqualFold(c, name) }) {
+			return fmt.Errorf("category '%s' already exists", name)
+		}
+		categories = append(categories, name)
+	case "remove":
+		idx := slices.IndexFunc(categories, func(c string) bool { return strings.EqualFold(c, name) })
+		if idx < 0 {
+			return fmt.Errorf("category '%s' not found", name)
+		}
+		categories = slices.Delete(categories, idx, idx+1)
+	default:
+		return fmt.Errorf("unknown categories action: %s", args[0])
+	}
+	if err := c.doJSON(http.MethodPut, "/categories/edit", categories, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Categories: %s\n", strings.Join(categories, ", "))
+	return nil
+}
+
+func writeIndentedJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+)
+
+// The server listens on a socket passed by systemd (socket activation), on a Unix domain socket,
+// or on a TCP port, in that order. A Unix socket lets a reverse proxy on the same host reach the
+// server without opening a port, with access limited by the socket file's permissions.
+
+// systemdListenFD is the first file descriptor systemd passes, after stdin, stdout, and stderr
+const systemdListenFD = 3
+
+// listen opens the listener the server is configured for, and describes it for the logs
+func listen(opts serverOptions) (net.Listener, string, error) {
+	if listener, err := systemdListener(); listener != nil || err != nil {
+		return listener, "the socket from systemd", err
+	}
+	if opts.socket != "" {
+		listener, err := listenUnix(opts.socket, opts.socketMode)
+		return listener, "socket " + opts.socket, err
+	}
+	address := opts.listenAddress(opts.port)
+	listener, err := net.Listen("tcp", address)
+	return listener, address, err
+}
+
+// systemdListener returns the socket systemd activated the service with, nil when it didn't
+func systemdListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	if fds > 1 {
+		return nil, fmt.Errorf("systemd passed %d sockets, expected one", fds)
+	}
+	// the variables are meant for this process only
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	file := os.NewFile(systemdListenFD, "systemd-socket")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use the socket from systemd: %v", err)
+	}
+	return listener, nil
+}
+
+// listenUnix listens on a Unix socket at path, replacing one left behind by an unclean stop, and
+// gives it the mode; the socket file is removed when the listener is closed
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/tanq16/expenseowl/internal/api"
 	"github.com/tanq16/expenseowl/internal/storage"
@@ -13,16 +19,217 @@ import (
 
 var version = "dev"
 
-func runServer(port int) {
-	storage, err := storage.InitializeStorage()
+// shutdownTimeout bounds how long in-flight requests get to finish after a stop signal
+const shutdownTimeout = 30 * time.Second
+
+func runServer(opts serverOptions) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if dir := os.Getenv("ASSETS_DIR"); dir != "" {
+		if err := web.UseOverrides(dir); err != nil {
+			log.Fatalf("Failed to use ASSETS_DIR: %v", err)
+		}
+		log.Printf("Serving web UI overrides from %s\n", dir)
+	}
+
+	// Tenants, each a household with its own data; without TENANTS the deployment is one household
+	tenants, err := api.TenantsFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure tenants: %v", err)
+	}
+	var households []*household
+	var server http.Handler
+	if len(tenants) == 0 {
+		home, err := newHousehold("", 0)
+		if err != nil {
+			log.Fatalf("Failed to start: %v", err)
+		}
+		households, server = []*household{home}, home.server
+	} else {
+		servers := make(map[string]http.Handler, len(tenants))
+		for _, tenant := range tenants {
+			home, err := newHousehold(tenant, len(tenants))
+			if err != nil {
+				log.Fatalf("Failed to start tenant %s: %v", tenant, err)
+			}
+			households = append(households, home)
+			servers[tenant] = home.server
+		}
+		server = api.NewTenantRouter(servers)
+		log.Printf("Hosting %d tenants\n", len(tenants))
+	}
+
+	// TLS, when served without a reverse proxy
+	serverTLS, err := tlsFromEnv(opts.port)
+	if err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
+
+	// Bind before starting background jobs so a taken port fails fast
+	listener, address, err := listen(opts)
+	if err != nil {
+		log.Fatalf("Server failed to start: %v", err)
+	}
+	var insecureServer *http.Server
+	if opts.httpPort != 0 && serverTLS != nil {
+		insecureListener, err := net.Listen("tcp", opts.listenAddress(opts.httpPort))
+		if err != nil {
+			log.Fatalf("Server failed to start on HTTP_PORT: %v", err)
+		}
+		insecureServer = &http.Server{Handler: serverTLS.insecure}
+		go func() {
+			if err := insecureServer.Serve(insecureListener); err != http.ErrServerClosed {
+				log.Printf("HTTP redirect server failed: %v\n", err)
+			}
+		}()
+		log.Println("Redirecting HTTP on port", opts.httpPort, "to HTTPS")
+	}
+
+	var jobs sync.WaitGroup
+	startJob := func(run func(context.Context)) {
+		jobs.Add(1)
+		go func() {
+			defer jobs.Done()
+			run(ctx)
+		}()
+	}
+
+	// Telegram Bot, polling one chat for one household
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		if len(tenants) > 0 {
+			log.Println("TELEGRAM_BOT_TOKEN is ignored with TENANTS")
+		} else {
+			bot, err := api.NewTelegramBot(households[0].handler, token, os.Getenv("TELEGRAM_CHAT_IDS"))
+			if err != nil {
+				log.Fatalf("Failed to start the Telegram bot: %v", err)
+			}
+			startJob(bot.Run)
+		}
+	}
+
+	// Security Headers, Request Limits on body size and content type, and CSRF Protection
+	security, err := api.SecurityHeadersFromEnv()
 	if err != nil {
-		log.Fatalf("Failed to initialize storage: %v", err)
+		log.Fatalf("Failed to configure security headers: %v", err)
+	}
+	httpServer := &http.Server{
+		Handler:        security.Middleware(api.LimitRequests(api.CheckCSRF(server))),
+		ReadTimeout:    opts.readTimeout,
+		WriteTimeout:   opts.writeTimeout,
+		MaxHeaderBytes: opts.maxHeaderBytes,
+	}
+	for _, home := range households {
+		handler := home.handler
+
+		// Background Jobs: report emails, saved reports, retention, holding prices, and bank sync
+		startJob(handler.RunJobs)
+
+		httpServer.RegisterOnShutdown(handler.CloseEvents)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if serverTLS != nil {
+			httpServer.TLSConfig = serverTLS.config
+			serveErr <- httpServer.ServeTLS(listener, "", "")
+			return
+		}
+		serveErr <- httpServer.Serve(listener)
+	}()
+	if serverTLS != nil {
+		log.Println("Starting server with TLS on", address, "...")
+	} else {
+		log.Println("Starting server on", address, "...")
 	}
-	defer storage.Close()
-	handler := api.NewHandler(storage)
 
+	select {
+	case err := <-serveErr:
+		log.Fatalf("Server failed: %v", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process immediately
+
+	// Stop accepting connections, drain requests and jobs, then close storage
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to finish in-flight requests: %v\n", err)
+	}
+	if insecureServer != nil {
+		insecureServer.Shutdown(shutdownCtx)
+	}
+	jobs.Wait()
+	for _, home := range households {
+		home.handler.WaitForTasks()
+		if err := home.storage.Close(); err != nil {
+			log.Printf("Failed to close storage: %v\n", err)
+		}
+	}
+	log.Println("Server stopped")
+}
+
+// household is the storage, handler, and routes of one tenant, or of the whole deployment
+// without tenants
+type household struct {
+	storage storage.Storage
+	handler *api.Handler
+	server  http.Handler
+}
+
+func newHousehold(tenant string, tenants int) (*household, error) {
+	var store storage.Storage
+	var err error
+	if tenant == "" {
+		store, err = storage.InitializeStorage()
+	} else {
+		store, err = storage.InitializeTenantStorage(tenant, tenants)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %v", err)
+	}
+	var handler *api.Handler
+	if tenant == "" {
+		handler = api.NewHandler(store)
+	} else {
+		handler = api.NewTenantHandler(tenant, store)
+	}
+	mux := http.NewServeMux()
+	registerRoutes(mux, handler)
+
+	// Access Control
+	var server http.Handler = mux
+	var access *api.AccessControl
+	if tenant == "" {
+		access, err = api.AccessControlFromEnv(store)
+	} else {
+		access, err = api.TenantAccessControlFromEnv(tenant, store)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure access control: %v", err)
+	}
+	if access != nil {
+		// Magic-link Login, not found unless ACCESS_AUTH=magic-link
+		mux.HandleFunc("/login", access.ServeLoginPage)
+		mux.HandleFunc("/api/auth/login", access.RequestLoginLink) // POST to email a link
+		mux.HandleFunc("/api/auth/verify", access.VerifyLoginLink) // POST with the link's token
+		mux.HandleFunc("/api/auth/logout", access.Logout)          // POST
+		mux.HandleFunc("/api/auth/session", access.Session)
+		mux.HandleFunc("/api/auth/sessions", access.Sessions)
+		mux.HandleFunc("/api/auth/sessions/revoke", access.RevokeSession) // DELETE with ?id= or ?others=true
+		mux.HandleFunc("/api/auth/keys", access.APIKeys)                  // GET, or POST to create one
+		mux.HandleFunc("/api/auth/keys/revoke", access.RevokeAPIKey)      // DELETE with ?id=
+		server = access.Middleware(mux)
+		log.Println("Access control enabled")
+	}
+
+	return &household{storage: store, handler: handler, server: server}, nil
+}
+
+// registerRoutes serves the UI and API of a household from mux
+func registerRoutes(mux *http.ServeMux, handler *api.Handler) {
 	// Version Handler
-	http.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -32,84 +239,260 @@ func runServer(port int) {
 	})
 
 	// UI Handlers
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
-			return
-		}
-		w.Header().Set("Content-Type", "text/html")
-		if err := web.ServeTemplate(w, "index.html"); err != nil {
-			log.Printf("HTTP ERROR: Failed to serve template: %v", err)
-			http.Error(w, "Failed to serve template", http.StatusInternalServerError)
-			return
-		}
-	})
-	http.HandleFunc("/table", handler.ServeTableView)
-	http.HandleFunc("/settings", handler.ServeSettingsPage)
-	http.HandleFunc("/monthly-chart", handler.ServeMonthlyChartView)
+	mux.HandleFunc("/", handler.ServeDashboard)
+	mux.HandleFunc("/table", handler.ServeTableView)
+	mux.HandleFunc("/settings", handler.ServeSettingsPage)
+	mux.HandleFunc("/monthly-chart", handler.ServeMonthlyChartView)
+	mux.HandleFunc("/basic/table", handler.ServeBasicTable)
+	mux.HandleFunc("/basic/summary", handler.ServeBasicSummary)
+	mux.HandleFunc("/basic/edit", handler.ServeBasicEdit)     // POST to save
+	mux.HandleFunc("/basic/delete", handler.ServeBasicDelete) // POST to delete
 
 	// Static File Handlers
-	http.HandleFunc("/functions.js", handler.ServeStaticFile)
-	http.HandleFunc("/manifest.json", handler.ServeStaticFile)
-	http.HandleFunc("/sw.js", handler.ServeStaticFile)
-	http.HandleFunc("/pwa/", handler.ServeStaticFile)
-	http.HandleFunc("/style.css", handler.ServeStaticFile)
-	http.HandleFunc("/favicon.ico", handler.ServeStaticFile)
-	http.HandleFunc("/chart.min.js", handler.ServeStaticFile)
-	http.HandleFunc("/fa.min.css", handler.ServeStaticFile)
-	http.HandleFunc("/webfonts/", handler.ServeStaticFile)
+	mux.HandleFunc("/functions.js", handler.ServeStaticFile)
+	mux.HandleFunc("/manifest.json", handler.ServeStaticFile)
+	mux.HandleFunc("/sw.js", handler.ServeStaticFile)
+	mux.HandleFunc("/pwa/", handler.ServeStaticFile)
+	mux.HandleFunc("/style.css", handler.ServeStaticFile)
+	mux.HandleFunc("/theme.css", handler.ServeStaticFile) // generated from the theme and palette settings
+	mux.HandleFunc("/favicon.ico", handler.ServeStaticFile)
+	mux.HandleFunc("/chart.min.js", handler.ServeStaticFile)
+	mux.HandleFunc("/fa.min.css", handler.ServeStaticFile)
+	mux.HandleFunc("/webfonts/", handler.ServeStaticFile)
+	mux.HandleFunc("/i18n/", handler.ServeTranslations)
 
 	// Config
-	http.HandleFunc("/config", handler.GetConfig)
-	http.HandleFunc("/categories", handler.GetCategories)
-	http.HandleFunc("/categories/edit", handler.UpdateCategories)
-	http.HandleFunc("/currency", handler.GetCurrency)
-	http.HandleFunc("/currency/edit", handler.UpdateCurrency)
-	http.HandleFunc("/startdate", handler.GetStartDate)
-	http.HandleFunc("/startdate/edit", handler.UpdateStartDate)
-	// http.HandleFunc("/tags", handler.GetTags)
-	// http.HandleFunc("/tags/edit", handler.UpdateTags)
+	mux.HandleFunc("/config", handler.GetConfig)
+	mux.HandleFunc("/api/config", handler.GetConfig)
+	mux.HandleFunc("/categories", handler.GetCategories)
+	mux.HandleFunc("/categories/edit", handler.UpdateCategories)
+	mux.HandleFunc("/categories/archived", handler.GetArchivedCategories)
+	mux.HandleFunc("/categories/archive", handler.ArchiveCategory) // PUT to archive or restore
+	mux.HandleFunc("/categories/meta", handler.GetCategoryMeta)
+	mux.HandleFunc("/api/categories/rename", handler.RenameCategory) // POST
+	mux.HandleFunc("/api/categories/merge", handler.MergeCategories) // POST
+	mux.HandleFunc("/categories/meta/edit", handler.UpdateCategoryMeta)
+	mux.HandleFunc("/currency", handler.GetCurrency)
+	mux.HandleFunc("/currency/edit", handler.UpdateCurrency)
+	mux.HandleFunc("/currencies", handler.GetCurrencies)               // GET ISO 4217 and custom currencies
+	mux.HandleFunc("/currencies/edit", handler.UpdateCustomCurrencies) // PUT to replace the custom currencies
+	mux.HandleFunc("/startdate", handler.GetStartDate)
+	mux.HandleFunc("/startdate/edit", handler.UpdateStartDate)
+	mux.HandleFunc("/budgets", handler.GetBudgets)
+	mux.HandleFunc("/budgets/edit", handler.UpdateBudgets)
+	mux.HandleFunc("/budgets/status", handler.GetBudgetStatus)
+	mux.HandleFunc("/api/planned", handler.GetPlannedExpenses)
+	mux.HandleFunc("/api/planned/edit", handler.UpdatePlannedExpenses) // PUT to replace the planned expenses
+
+	// Projects
+	mux.HandleFunc("/api/projects", handler.GetProjects)
+	mux.HandleFunc("/api/projects/edit", handler.UpdateProjects) // PUT to replace the projects
+	mux.HandleFunc("/api/reports/projects", handler.GetProjectReport)
+	mux.HandleFunc("/period", handler.GetPeriod)
+	mux.HandleFunc("/period/edit", handler.UpdatePeriod)
+	mux.HandleFunc("/travel-rates", handler.GetTravelRates)
+	mux.HandleFunc("/travel-rates/edit", handler.UpdateTravelRates)
+	mux.HandleFunc("/fiscal-year", handler.GetFiscalYearStart)
+	mux.HandleFunc("/fiscal-year/edit", handler.UpdateFiscalYearStart)
+	mux.HandleFunc("/retention", handler.GetRetention)
+	mux.HandleFunc("/retention/edit", handler.UpdateRetention)
+	// mux.HandleFunc("/tags", handler.GetTags)
+	// mux.HandleFunc("/tags/edit", handler.UpdateTags)
 
 	// SubCategories
-	http.HandleFunc("/subcategories", handler.GetSubCategories)           // GET with ?category=
-	http.HandleFunc("/subcategory", handler.AddSubCategory)               // PUT for add
-	http.HandleFunc("/subcategory/delete", handler.RemoveSubCategory)     // DELETE
-	http.HandleFunc("/subcategory/rename", handler.RenameSubCategory)     // PUT for rename
-	http.HandleFunc("/subcategory-mappings", handler.GetSubCategoryMappings)    // GET
-	http.HandleFunc("/subcategory-mappings/edit", handler.UpdateSubCategoryMappings) // PUT
+	mux.HandleFunc("/subcategories", handler.GetSubCategories)           // GET with ?category=
+	mux.HandleFunc("/subcategory", handler.AddSubCategory)               // PUT for add
+	mux.HandleFunc("/subcategory/delete", handler.RemoveSubCategory)     // DELETE
+	mux.HandleFunc("/subcategory/rename", handler.RenameSubCategory)     // PUT for rename
+	mux.HandleFunc("/subcategory/merge", handler.MergeSubCategory)       // PUT to merge into another
+	mux.HandleFunc("/subcategory-mappings", handler.GetSubCategoryMappings)    // GET
+	mux.HandleFunc("/subcategory-mappings/edit", handler.UpdateSubCategoryMappings) // PUT
 
 	// Expenses
-	http.HandleFunc("/expense", handler.AddExpense)                     // PUT for add
-	http.HandleFunc("/expenses", handler.GetExpenses)                   // GET all
-	http.HandleFunc("/expense/edit", handler.EditExpense)               // PUT for edit
-	http.HandleFunc("/expense/delete", handler.DeleteExpense)           // DELETE for single
-	http.HandleFunc("/expenses/delete", handler.DeleteMultipleExpenses) // DELETE for multiple
+	mux.HandleFunc("/expense", handler.AddExpense)                     // PUT for add
+	mux.HandleFunc("/expenses", handler.GetExpenses)                   // GET all
+	mux.HandleFunc("/expense/edit", handler.EditExpense)               // PUT for edit
+	mux.HandleFunc("/expense/delete", handler.DeleteExpense)           // DELETE for single
+	mux.HandleFunc("/expenses/delete", handler.DeleteMultipleExpenses) // DELETE for multiple
+	mux.HandleFunc("/expenses/move", handler.MoveExpenses)             // PUT to change category for multiple
 
 	// Recurring Expenses
-	http.HandleFunc("/recurring-expense", handler.AddRecurringExpense)           // PUT for add
-	http.HandleFunc("/recurring-expenses", handler.GetRecurringExpenses)         // GET all
-	http.HandleFunc("/recurring-expense/edit", handler.UpdateRecurringExpense)   // PUT for edit
-	http.HandleFunc("/recurring-expense/delete", handler.DeleteRecurringExpense) // DELETE
+	mux.HandleFunc("/recurring-expense", handler.AddRecurringExpense)                    // PUT for add
+	mux.HandleFunc("/recurring-expenses", handler.GetRecurringExpenses)                  // GET all
+	mux.HandleFunc("/recurring-expense/edit", handler.UpdateRecurringExpense)            // PUT for edit
+	mux.HandleFunc("/recurring-expense/delete", handler.DeleteRecurringExpense)          // DELETE
+	mux.HandleFunc("/recurring-expense/occurrence", handler.OverrideRecurringOccurrence) // PUT to skip or change one occurrence
+	mux.HandleFunc("/api/recurring/", handler.BackfillRecurringExpense)                  // POST to /api/recurring/{id}/backfill, ?dryRun=true to preview
 
 	// Import/Export
-	http.HandleFunc("/export/csv", handler.ExportCSV)
-	http.HandleFunc("/import/csv", handler.ImportCSV)
-	http.HandleFunc("/import/csvold", handler.ImportOldCSV)
+	mux.HandleFunc("/export/csv", handler.ExportCSV)
+	mux.HandleFunc("/import/csv", handler.ImportCSV)
+	mux.HandleFunc("/import/csvold", handler.ImportOldCSV)
+	mux.HandleFunc("/api/import/firefly", handler.ImportFirefly) // POST, ?dryRun=true to preview
+	mux.HandleFunc("/api/import/actual", handler.ImportActual)
+	mux.HandleFunc("/api/import/cashew", handler.ImportCashew)
+	mux.HandleFunc("/api/import/paypal", handler.ImportPayPal) // ?fees=separate|embedded
+	mux.HandleFunc("/api/import/stripe", handler.ImportStripe)
+	mux.HandleFunc("/api/ingest/email", handler.IngestEmail) // POST from mail webhook
+
+	// Import History
+	mux.HandleFunc("/api/imports", handler.GetImports)              // batches, newest first
+	mux.HandleFunc("/api/imports/rollback", handler.RollbackImport) // DELETE with ?batch=
+
+	// Client Sync (mobile and offline apps)
+	mux.HandleFunc("/api/sync/pull", handler.SyncPull) // ?since= revision
+	mux.HandleFunc("/api/sync/push", handler.SyncPush) // POST changes, ?confirm=true skips amount limits
+
+	// Change Feed
+	mux.HandleFunc("/api/changes", handler.GetChanges) // ?since= cursor
+
+	// Bank Sync (Plaid)
+	mux.HandleFunc("/api/plaid/items", handler.GetPlaidItems)
+	mux.HandleFunc("/api/plaid/link-token", handler.CreatePlaidLinkToken) // POST, starts Plaid Link
+	mux.HandleFunc("/api/plaid/exchange", handler.ExchangePlaidToken)     // POST the public token from Link
+	mux.HandleFunc("/api/plaid/sync", handler.SyncPlaid)                  // POST, ?item= for one bank
+	mux.HandleFunc("/api/plaid/items/remove", handler.RemovePlaidItem)    // DELETE with ?item=
+	mux.HandleFunc("/api/plaid/webhook", handler.PlaidWebhook)            // POST from Plaid
 
 	// TRMNL Integration
-	http.HandleFunc("/api/trmnl", handler.GetTRMNLData)
+	mux.HandleFunc("/api/trmnl", handler.GetTRMNLData)
+	mux.HandleFunc("/api/trmnl/profiles", handler.TRMNLProfiles)             // GET to list, POST to create
+	mux.HandleFunc("/api/trmnl/profiles/edit", handler.UpdateTRMNLProfile)   // PUT
+	mux.HandleFunc("/api/trmnl/profiles/remove", handler.RemoveTRMNLProfile) // DELETE with ?name=
+	mux.HandleFunc("/api/trmnl/profile", handler.GetTRMNLProfileData)        // GET with ?token= of a profile
 
-	// Monthly Expense Chart API
-	http.HandleFunc("/api/expenses/monthly", handler.GetMonthlyExpenses)
+	// Widget Summary (ESPHome and other small displays)
+	mux.HandleFunc("/api/widgets/summary", handler.GetWidgetSummary)
+	mux.HandleFunc("/api/widgets/summary.png", handler.GetWidgetImage) // for e-ink displays that only show images
+	mux.HandleFunc("/api/widgets/summary.bmp", handler.GetWidgetImage)
 
-	log.Println("Starting server on port", port, "...")
-	if err := http.ListenAndServe(fmt.Sprint(":", port), nil); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
-	}
+	// Spoken Summary (Siri Shortcuts, Tasker, and voice assistants)
+	mux.HandleFunc("/api/summary/today", handler.GetSpokenSummary)
+	mux.HandleFunc("/api/summary/week", handler.GetSpokenSummary)
+	mux.HandleFunc("/api/summary/month", handler.GetSpokenSummary)
+
+	// Voice Assistants (Alexa skills and Google Assistant actions)
+	mux.HandleFunc("/api/voice/alexa", handler.AlexaSkill)
+	mux.HandleFunc("/api/voice/google", handler.GoogleAction)
+	mux.HandleFunc("/api/voice/link", handler.LinkVoiceAssistant)    // account linking page of an implicit grant
+	mux.HandleFunc("/api/voice/keys", handler.VoiceKeys)             // GET to list, POST to create
+	mux.HandleFunc("/api/voice/keys/remove", handler.RemoveVoiceKey) // DELETE with ?name=
+
+	// Apple Wallet Budget Pass
+	mux.HandleFunc("/api/wallet/pass", handler.GetWalletPass)
+	mux.HandleFunc("/api/wallet/v1/", handler.WalletService) // the pass web service Wallet registers with for updates
+
+	// Home Assistant Integration
+	mux.HandleFunc("/api/integrations/homeassistant", handler.GetHomeAssistantData)
+
+	// Atom Feed
+	mux.HandleFunc("/api/feed.atom", handler.GetFeed)
+
+	// Live Updates
+	mux.HandleFunc("/api/events", handler.StreamEvents)
+
+	// Report Emails
+	mux.HandleFunc("/reports", handler.GetReportSettings)
+	mux.HandleFunc("/reports/edit", handler.UpdateReportSettings)
+	mux.HandleFunc("/reports/preview", handler.PreviewReport)
+	mux.HandleFunc("/reports/send", handler.SendReport) // POST
+
+	// Yearly Reports
+	mux.HandleFunc("/api/reports/yearly", handler.GetYearlyReport)
+
+	// Category Trend
+	mux.HandleFunc("/api/reports/category-trend", handler.GetCategoryTrend) // ?category=, ?months=
+
+	// Tax Report
+	mux.HandleFunc("/api/reports/tax", handler.GetTaxReport) // ?year=, ?calendar=true
+
+	// Spending Patterns
+	mux.HandleFunc("/api/insights/patterns", handler.GetSpendingPatterns)
+
+	// Reimbursements
+	mux.HandleFunc("/api/reports/reimbursements", handler.GetReimbursementReport)
+	mux.HandleFunc("/api/reports/reimbursements/settle", handler.SettleReimbursements) // PUT with ids to mark as reimbursed
+
+	// Reconciliation
+	mux.HandleFunc("/api/reconcile", handler.GetReconciliation)  // ?account=, ?from=, ?to=, ?balance=
+	mux.HandleFunc("/api/reconcile/mark", handler.MarkReconciled) // PUT with ids to mark as reconciled
+
+	// Account Statements
+	mux.HandleFunc("/api/reports/statements", handler.GetStatementReport) // ?account=, ?months=
+
+	// Saved Reports
+	mux.HandleFunc("/api/reports/saved", handler.GetSavedReports)
+	mux.HandleFunc("/api/reports/saved/edit", handler.UpdateSavedReports)    // PUT to replace the saved reports
+	mux.HandleFunc("/api/reports/saved/preview", handler.PreviewSavedReport) // GET with ?name=
+	mux.HandleFunc("/api/reports/saved/run", handler.RunSavedReport)         // POST with ?name= to deliver now
+
+	// Net Worth
+	mux.HandleFunc("/api/accounts", handler.GetAccounts)
+	mux.HandleFunc("/api/accounts/edit", handler.UpdateAccounts) // PUT to replace the accounts
+	mux.HandleFunc("/api/reports/networth", handler.GetNetWorthReport)
+	mux.HandleFunc("/api/holdings", handler.GetHoldings)
+	mux.HandleFunc("/api/holdings/edit", handler.UpdateHoldings)   // PUT to replace the holdings
+	mux.HandleFunc("/api/holdings/refresh", handler.RefreshPrices) // POST to quote the prices now
+
+	// Share Links
+	mux.HandleFunc("/api/shares", handler.Shares)             // GET to list, POST to create
+	mux.HandleFunc("/api/shares/revoke", handler.RevokeShare) // DELETE with ?token=
+	mux.HandleFunc("/share/", handler.ViewShare)              // read-only report
+
+	// Grafana JSON Datasource
+	mux.HandleFunc("/api/grafana/", handler.GrafanaHealth)                 // connection test
+	mux.HandleFunc("/api/grafana/search", handler.GrafanaSearch)           // POST
+	mux.HandleFunc("/api/grafana/query", handler.GrafanaQuery)             // POST
+	mux.HandleFunc("/api/grafana/annotations", handler.GrafanaAnnotations) // POST
+
+	// Spending Map
+	mux.HandleFunc("/api/expenses/geojson", handler.GetExpensesGeoJSON)
+
+	// Name Autocomplete
+	mux.HandleFunc("/api/expenses/suggest", handler.SuggestExpenseNames) // GET with ?q=
+
+	// Quick Entry
+	mux.HandleFunc("/api/expenses/parse-and-add", handler.ParseAndAddExpense) // POST "name;amount;category;date", ?dryRun=true to preview
+
+	// Expense Metadata and Refunds
+	mux.HandleFunc("/api/expenses/", handler.ExpenseResource) // /api/expenses/{id}/metadata (GET, PUT, DELETE) and /api/expenses/{id}/refunds (GET, POST, DELETE)
+
+	// Receipt Scanning
+	mux.HandleFunc("/api/receipts/scan", handler.ScanReceipt) // POST, returns a draft without saving
+
+	// Merchants
+	mux.HandleFunc("/api/reports/merchants", handler.GetMerchantReport)
+	mux.HandleFunc("/api/merchants/aliases", handler.GetMerchantAliases)
+	mux.HandleFunc("/api/merchants/aliases/edit", handler.UpdateMerchantAliases) // PUT
+	mux.HandleFunc("/api/merchants/merge", handler.MergeMerchants)               // POST
+
+	// Runtime Settings
+	mux.HandleFunc("/api/admin/settings", handler.AdminSettings) // GET, PUT
+
+	// Maintenance
+	mux.HandleFunc("/api/admin/maintenance", handler.Maintenance) // GET to check, POST with tasks to fix
+
+	// Background Jobs
+	mux.HandleFunc("/api/admin/jobs", handler.GetJobs)
+	mux.HandleFunc("/api/admin/jobs/run", handler.RunJobNow) // POST with ?name=
+
+	// User Preferences
+	mux.HandleFunc("/api/preferences", handler.GetPreferences)
+	mux.HandleFunc("/api/preferences/edit", handler.UpdatePreferences) // PUT
+	mux.HandleFunc("/api/dashboard/layout", handler.DashboardLayout)   // GET, PUT, or DELETE to reset
+	mux.HandleFunc("/api/languages", handler.GetLanguages)
+
+	// Versioned API, served by the routes above
+	mux.Handle("/api/v1/", api.APIv1(mux))
+
+	// Monthly Expense Chart API
+	mux.HandleFunc("/api/expenses/monthly", handler.GetMonthlyExpenses)
 }
 
 func main() {
-	port := flag.Int("port", 8080, "Port to serve from")
-	flag.Parse()
-	runServer(*port)
+	opts, err := parseOptions(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to start: %v", err)
+	}
+	runServer(opts)
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serverOptions are how the server listens and how long it waits on clients. Each is a flag,
+// defaulting to an environment variable, so they can be set either way, e.g., in Docker.
+type serverOptions struct {
+	address        string // to bind to, all interfaces when empty
+	port           int
+	httpPort       int    // plain HTTP redirecting to HTTPS, with built-in TLS; 0 for none
+	socket         string // Unix socket to listen on instead of the port
+	socketMode     fs.FileMode
+	readTimeout    time.Duration // to read a request, body included; 0 for none
+	writeTimeout   time.Duration // to write a response; 0 for none
+	maxHeaderBytes int
+}
+
+const (
+	defaultPort        = 8080
+	defaultReadTimeout = 2 * time.Minute
+	maxPort            = 65535
+	minHeaderBytes     = 4 << 10
+	maxHeaderBytes     = 16 << 20
+)
+
+// parseOptions reads the options from the command line and the environment
+func parseOptions(args []string) (serverOptions, error) {
+	var opts serverOptions
+	port, err := envInt("PORT", defaultPort)
+	if err != nil {
+		return opts, err
+	}
+	httpPort, err := envInt("HTTP_PORT", 0)
+	if err != nil {
+		return opts, err
+	}
+	readTimeout, err := envDuration("READ_TIMEOUT", defaultReadTimeout)
+	if err != nil {
+		return opts, err
+	}
+	writeTimeout, err := envDuration("WRITE_TIMEOUT", 0)
+	if err != nil {
+		return opts, err
+	}
+	headerBytes, err := envInt("MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes)
+	if err != nil {
+		return opts, err
+	}
+
+	flags := flag.NewFlagSet("expenseowl", flag.ExitOnError)
+	flags.StringVar(&opts.address, "address", os.Getenv("BIND_ADDRESS"), "Address to bind to, e.g., 127.0.0.1; all interfaces by default (BIND_ADDRESS)")
+	flags.IntVar(&opts.port, "port", port, "Port to serve from (PORT)")
+	flags.IntVar(&opts.httpPort, "http-port", httpPort, "Port to redirect plain HTTP to HTTPS from, with built-in TLS (HTTP_PORT)")
+	flags.StringVar(&opts.socket, "socket", os.Getenv("SOCKET"), "Unix socket to serve from instead of the port (SOCKET)")
+	socketMode := flags.String("socket-mode", cmp.Or(os.Getenv("SOCKET_MODE"), "0660"), "Permissions of the Unix socket (SOCKET_MODE)")
+	flags.DurationVar(&opts.readTimeout, "read-timeout", readTimeout, "Time to read a request, body included; 0 for none (READ_TIMEOUT)")
+	flags.DurationVar(&opts.writeTimeout, "write-timeout", writeTimeout, "Time to write a response; 0 for none (WRITE_TIMEOUT)")
+	flags.IntVar(&opts.maxHeaderBytes, "max-header-bytes", headerBytes, "Largest request headers accepted, in bytes (MAX_HEADER_BYTES)")
+	flags.Parse(args)
+
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return opts, fmt.Errorf("invalid socket mode '%s': must be octal permissions, e.g., 0660", *socketMode)
+	}
+	opts.socketMode = fs.FileMode(mode)
+	return opts, opts.validate()
+}
+
+func (o serverOptions) validate() error {
+	if o.address != "" && net.ParseIP(strings.Trim(o.address, "[]")) == nil && !validHostname(o.address) {
+		return fmt.Errorf("invalid address '%s': must be an IP address or a host name", o.address)
+	}
+	if o.port < 1 || o.port > maxPort {
+		return fmt.Errorf("invalid port %d: must be between 1 and %d", o.port, maxPort)
+	}
+	if o.httpPort < 0 || o.httpPort > maxPort || o.httpPort == o.port {
+		return fmt.Errorf("invalid HTTP port %d: must be 0 for none or between 1 and %d, and not the port", o.httpPort, maxPort)
+	}
+	if o.readTimeout < 0 || o.writeTimeout < 0 {
+		return fmt.Errorf("invalid timeout: must not be negative")
+	}
+	if o.maxHeaderBytes < minHeaderBytes || o.maxHeaderBytes > maxHeaderBytes {
+		return fmt.Errorf("invalid max header bytes %d: must be between %d and %d", o.maxHeaderBytes, minHeaderBytes, maxHeaderBytes)
+	}
+	return nil
+}
+
+// listenAddress is the TCP address to bind the port on
+func (o serverOptions) listenAddress(port int) string {
+	return net.JoinHostPort(strings.Trim(o.address, "[]"), strconv.Itoa(port))
+}
+
+// validHostname accepts names like localhost or expenseowl.internal
+func validHostname(name string) bool {
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// envInt reads a whole number from the environment, the fallback when it's unset
+func envInt(name string, fallback int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s': must be a whole number", name, value)
+	}
+	return n, nil
+}
+
+// envDuration reads a duration, like 30s or 5m, or a number of seconds, from the environment
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return fallback, nil
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s '%s': must be a duration like 30s or 5m, or seconds", name, value)
+	}
+	return d, nil
+}
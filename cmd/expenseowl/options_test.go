@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseOptions checks the defaults, the environment and flags, and the startup errors for
+// invalid options
+func TestParseOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		args []string
+		want func(serverOptions) bool
+		err  string // part of the error, none when empty
+	}{
+		{name: "defaults", want: func(o serverOptions) bool {
+			return o.port == defaultPort && o.httpPort == 0 && o.socketMode == 0660 && o.readTimeout == defaultReadTimeout && o.writeTimeout == 0
+		}},
+		{name: "flags over the environment", env: map[string]string{"PORT": "9000"}, args: []string{"-port", "9100", "-address", "127.0.0.1"}, want: func(o serverOptions) bool {
+			return o.port == 9100 && o.address == "127.0.0.1"
+		}},
+		{name: "host name", args: []string{"-address", "expenseowl.internal"}, want: func(o serverOptions) bool {
+			return o.address == "expenseowl.internal"
+		}},
+		{name: "IPv6 address", args: []string{"-address", "[::1]"}, want: func(o serverOptions) bool {
+			return o.listenAddress(8080) == "[::1]:8080"
+		}},
+		{name: "duration as seconds", env: map[string]string{"READ_TIMEOUT": "45", "WRITE_TIMEOUT": "2m"}, want: func(o serverOptions) bool {
+			return o.readTimeout == 45*time.Second && o.writeTimeout == 2*time.Minute
+		}},
+		{name: "socket mode", env: map[string]string{"SOCKET_MODE": "0600"}, want: func(o serverOptions) bool {
+			return o.socketMode == fs.FileMode(0600)
+		}},
+		{name: "HTTP port", env: map[string]string{"HTTP_PORT": "80"}, args: []string{"-port", "443"}, want: func(o serverOptions) bool {
+			return o.httpPort == 80 && o.port == 443
+		}},
+
+		{name: "port out of range", args: []string{"-port", "70000"}, err: "invalid port 70000: must be between 1 and 65535"},
+		{name: "port zero", env: map[string]string{"PORT": "0"}, err: "invalid port 0"},
+		{name: "port not a number", env: map[string]string{"PORT": "eighty"}, err: "invalid PORT 'eighty': must be a whole number"},
+		{name: "HTTP port on the port", env: map[string]string{"HTTP_PORT": "8080"}, err: "must be 0 for none or between 1 and 65535, and not the port"},
+		{name: "negative HTTP port", env: map[string]string{"HTTP_PORT": "-1"}, err: "invalid HTTP port -1"},
+		{name: "socket mode not octal", env: map[string]string{"SOCKET_MODE": "0999"}, err: "invalid socket mode '0999'"},
+		{name: "socket mode too wide", args: []string{"-socket-mode", "1777"}, err: "invalid socket mode '1777'"},
+		{name: "host name with an underscore", args: []string{"-address", "expense_owl.local"}, err: "invalid address 'expense_owl.local'"},
+		{name: "host name with an empty label", args: []string{"-address", "expenseowl..internal"}, err: "invalid address"},
+		{name: "negative read timeout", env: map[string]string{"READ_TIMEOUT": "-5"}, err: "must not be negative"},
+		{name: "negative write timeout", args: []string{"-write-timeout", "-1s"}, err: "must not be negative"},
+		{name: "duration without a unit or number", env: map[string]string{"WRITE_TIMEOUT": "soon"}, err: "invalid WRITE_TIMEOUT 'soon'"},
+		{name: "header bytes too small", env: map[string]string{"MAX_HEADER_BYTES": "100"}, err: "invalid max header bytes 100"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, name := range []string{"BIND_ADDRESS", "PORT", "HTTP_PORT", "SOCKET", "SOCKET_MODE", "READ_TIMEOUT", "WRITE_TIMEOUT", "MAX_HEADER_BYTES"} {
+				t.Setenv(name, tc.env[name])
+			}
+			opts, err := parseOptions(tc.args)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("Expected an error with %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !tc.want(opts) {
+				t.Errorf("Unexpected options: %+v", opts)
+			}
+		})
+	}
+}
+
+// TestValidHostname checks the host names accepted as a bind address
+func TestValidHostname(t *testing.T) {
+	for name, want := range map[string]bool{
+		"localhost":             true,
+		"expenseowl.internal":   true,
+		"budget-01.example.com": true,
+		"":                      false,
+		"-budget.example.com":   false,
+		"budget-.example.com":   false,
+		"budget.example.com.":   false,
+		"bud get":               false,
+		strings.Repeat("a", 64): false,
+	} {
+		if got := validHostname(name); got != want {
+			t.Errorf("validHostname(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"cmp"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// For deployments without a reverse proxy, the server can terminate TLS itself, with a
+// certificate and key from files (reloaded when they change, e.g., after certbot renews them) or
+// with certificates issued and renewed automatically over ACME, e.g., by Let's Encrypt.
+
+// serverTLS is how the server terminates TLS
+type serverTLS struct {
+	config *tls.Config
+	// insecure serves plain HTTP on HTTP_PORT, redirecting to HTTPS and answering ACME challenges
+	insecure http.Handler
+}
+
+// tlsFromEnv configures TLS from TLS_CERT_FILE and TLS_KEY_FILE, or from ACME_DOMAINS with
+// ACME_EMAIL, ACME_CACHE_DIR, and ACME_DIRECTORY_URL; it returns nil without either
+func tlsFromEnv(httpsPort int) (*serverTLS, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	domains := splitList(os.Getenv("ACME_DOMAINS"))
+	redirect := redirectToHTTPS(httpsPort)
+	switch {
+	case certFile != "" && len(domains) > 0:
+		return nil, fmt.Errorf("TLS_CERT_FILE and ACME_DOMAINS can't both be set")
+	case (certFile == "") != (keyFile == ""):
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	case certFile != "":
+		cert := &certificateFile{certFile: certFile, keyFile: keyFile}
+		if _, err := cert.load(); err != nil {
+			return nil, err
+		}
+		config := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: cert.getCertificate}
+		return &serverTLS{config: config, insecure: redirect}, nil
+	case len(domains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(cmp.Or(os.Getenv("ACME_CACHE_DIR"), "data/certs")),
+			Email:      os.Getenv("ACME_EMAIL"),
+		}
+		if directory := os.Getenv("ACME_DIRECTORY_URL"); directory != "" {
+			manager.Client = &acme.Client{DirectoryURL: directory}
+		}
+		config := manager.TLSConfig()
+		config.MinVersion = tls.VersionTLS12
+		return &serverTLS{config: config, insecure: manager.HTTPHandler(redirect)}, nil
+	}
+	return nil, nil
+}
+
+// certificateFile is a certificate and key on disk, loaded again when either changes
+type certificateFile struct {
+	certFile, keyFile string
+	mu                sync.Mutex
+	cert              *tls.Certificate
+	modTime           time.Time // of the newer of the two files when they were loaded
+}
+
+func (c *certificateFile) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cert, err := c.load()
+	if err != nil && c.cert != nil {
+		// a renewal may be halfway through writing the files, keep serving the last certificate
+		log.Printf("Failed to reload the TLS certificate: %v\n", err)
+		return c.cert, nil
+	}
+	return cert, err
+}
+
+// load reads the certificate and key when they changed since they were last read
+func (c *certificateFile) load() (*tls.Certificate, error) {
+	var modTime time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS certificate: %v", err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	if c.cert != nil && modTime.Equal(c.modTime) {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	if c.cert != nil {
+		log.Println("Reloaded the TLS certificate")
+	}
+	c.cert, c.modTime = &cert, modTime
+	return c.cert, nil
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL over HTTPS
+func redirectToHTTPS(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, fmt.Sprint(httpsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
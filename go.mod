@@ -5,3 +5,10 @@ go 1.23.2
 require github.com/google/uuid v1.6.0
 
 require github.com/lib/pq v1.10.9
+
+require (
+	golang.org/x/crypto v0.41.0
+	golang.org/x/image v0.29.0
+	golang.org/x/net v0.42.0 // indirect
+	golang.org/x/text v0.28.0
+)
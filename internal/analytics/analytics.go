@@ -0,0 +1,68 @@
+// Package analytics holds the ranking helpers shared by the dashboard, reports, and integrations.
+// They work on pre-aggregated data (e.g., totals per category from summaries or monthly
+// aggregates) as well as on lists of expenses or merchants.
+package analytics
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+)
+
+// Total is an aggregated amount, e.g., the spending in a category
+type Total struct {
+	Name   string
+	Amount float64
+}
+
+// TopTotals returns the n largest totals, largest first with ties by name; n < 0 returns all
+func TopTotals(totals map[string]float64, n int) []Total {
+	list := make([]Total, 0, len(totals))
+	for name, amount := range totals {
+		list = append(list, Total{Name: name, Amount: amount})
+	}
+	return TopN(list, n, func(a, b Total) int {
+		return cmp.Or(cmp.Compare(b.Amount, a.Amount), cmp.Compare(a.Name, b.Name))
+	})
+}
+
+// TopN returns the n items that come first by compare, in order; n < 0 returns all of them
+// sorted. Only n items are kept while scanning, so picking a few from a long list doesn't sort
+// all of it. The items aren't modified. Ties come out in any order, so compare should break them.
+func TopN[T any](items []T, n int, compare func(a, b T) int) []T {
+	if n < 0 || n >= len(items) {
+		sorted := slices.Clone(items)
+		slices.SortFunc(sorted, compare)
+		return sorted
+	}
+	if n == 0 {
+		return []T{}
+	}
+	// max-heap of the best n so far, with the worst of them on top
+	h := &topHeap[T]{items: make([]T, 0, n), compare: compare}
+	for _, item := range items {
+		if h.Len() < n {
+			heap.Push(h, item)
+		} else if compare(item, h.items[0]) < 0 {
+			h.items[0] = item
+			heap.Fix(h, 0)
+		}
+	}
+	slices.SortFunc(h.items, compare)
+	return h.items
+}
+
+type topHeap[T any] struct {
+	items   []T
+	compare func(a, b T) int
+}
+
+func (h *topHeap[T]) Len() int           { return len(h.items) }
+func (h *topHeap[T]) Less(i, j int) bool { return h.compare(h.items[i], h.items[j]) > 0 }
+func (h *topHeap[T]) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *topHeap[T]) Push(x any)         { h.items = append(h.items, x.(T)) }
+func (h *topHeap[T]) Pop() any {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
@@ -0,0 +1,46 @@
+package analytics
+
+import (
+	"cmp"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestTopTotals(t *testing.T) {
+	totals := map[string]float64{"Food": 300, "Rent": 900, "Fun": 300, "Travel": 50}
+	top := TopTotals(totals, 3)
+	want := []Total{{"Rent", 900}, {"Food", 300}, {"Fun", 300}}
+	if !slices.Equal(top, want) {
+		t.Errorf("Expected %v, got %v", want, top)
+	}
+	if all := TopTotals(totals, -1); len(all) != 4 || all[3].Name != "Travel" {
+		t.Errorf("Expected all totals sorted, got %v", all)
+	}
+	if none := TopTotals(totals, 0); len(none) != 0 {
+		t.Errorf("Expected no totals, got %v", none)
+	}
+	if empty := TopTotals(nil, 5); len(empty) != 0 {
+		t.Errorf("Expected no totals, got %v", empty)
+	}
+}
+
+func TestTopN(t *testing.T) {
+	items := rand.New(rand.NewSource(1)).Perm(1000)
+	original := slices.Clone(items)
+	for _, n := range []int{1, 5, 999, 1000, 2000} {
+		top := TopN(items, n, cmp.Compare[int])
+		want := min(n, len(items))
+		if len(top) != want {
+			t.Fatalf("n=%d: expected %d items, got %d", n, want, len(top))
+		}
+		for i, v := range top {
+			if v != i {
+				t.Fatalf("n=%d: expected %d at %d, got %d", n, i, i, v)
+			}
+		}
+	}
+	if !slices.Equal(items, original) {
+		t.Error("Expected the input to be left unchanged")
+	}
+}
@@ -0,0 +1,286 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Access control is opt-in. ExpenseOwl has no accounts of its own, so it trusts an authenticating
+// reverse proxy (e.g., Authelia or Authentik) to say who the user is in a header, and ACCESS_ROLES
+// maps those users to roles, e.g., "alice:admin,bob:editor,grandma:viewer". Without a proxy,
+// ACCESS_AUTH=magic-link signs users in by email instead (see login.go).
+
+// Role is what a user may do; each role includes the ones below it
+type Role int
+
+const (
+	RolePublic Role = iota // no user needed, e.g., routes that check their own token
+	RoleViewer             // read-only
+	RoleEditor             // add, edit, delete, and import expenses
+	RoleAdmin              // configuration, categories, and share links
+)
+
+var roleNames = map[Role]string{RolePublic: "public", RoleViewer: "viewer", RoleEditor: "editor", RoleAdmin: "admin"}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+func parseRole(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if role != RolePublic && strings.EqualFold(name, roleName) {
+			return role, nil
+		}
+	}
+	return RolePublic, fmt.Errorf("unknown role '%s', must be viewer, editor, or admin", name)
+}
+
+// routePermissions declares the routes that don't use the default of viewer for GET and editor otherwise
+var routePermissions = map[string]Role{
+	// Own tokens
+	"/api/feed.atom":     RolePublic,
+	"/api/ingest/email":  RolePublic,
+	"/api/plaid/webhook": RolePublic,
+	"/api/trmnl/profile": RolePublic,
+	"/api/voice/alexa":   RolePublic,
+	"/api/voice/google":  RolePublic,
+	"/api/voice/link":    RolePublic,
+
+	// Signing in
+	"/login":            RolePublic,
+	"/api/auth/login":   RolePublic,
+	"/api/auth/verify":  RolePublic,
+	"/api/auth/logout":  RolePublic,
+	"/api/auth/session": RolePublic,
+
+	// Own sessions, admins can see and revoke everyone's
+	"/api/auth/sessions":        RoleViewer,
+	"/api/auth/sessions/revoke": RoleViewer,
+	"/api/auth/keys":            RoleViewer, // each user manages their own
+	"/api/auth/keys/revoke":     RoleViewer,
+
+	// Own preferences
+	"/api/preferences/edit": RoleViewer,
+	"/api/dashboard/layout": RoleViewer,
+
+	// Grafana reads with POST
+	"/api/grafana/search":      RoleViewer,
+	"/api/grafana/query":       RoleViewer,
+	"/api/grafana/annotations": RoleViewer,
+
+	// Static files, for the login page
+	"/style.css":     RolePublic,
+	"/functions.js":  RolePublic,
+	"/fa.min.css":    RolePublic,
+	"/favicon.ico":   RolePublic,
+	"/manifest.json": RolePublic,
+
+	// Configuration
+	"/categories/edit":            RoleAdmin,
+	"/categories/archive":         RoleAdmin,
+	"/categories/meta/edit":       RoleAdmin,
+	"/api/categories/rename":      RoleAdmin,
+	"/api/categories/merge":       RoleAdmin,
+	"/currency/edit":              RoleAdmin,
+	"/currencies/edit":            RoleAdmin,
+	"/startdate/edit":             RoleAdmin,
+	"/budgets/edit":               RoleAdmin,
+	"/api/planned/edit":           RoleAdmin,
+	"/api/projects/edit":          RoleAdmin,
+	"/period/edit":                RoleAdmin,
+	"/travel-rates/edit":          RoleAdmin,
+	"/fiscal-year/edit":           RoleAdmin,
+	"/retention/edit":             RoleAdmin,
+	"/api/admin/settings":         RoleAdmin,
+	"/api/admin/maintenance":      RoleAdmin,
+	"/api/admin/jobs":             RoleAdmin,
+	"/api/admin/jobs/run":         RoleAdmin,
+	"/subcategory":                RoleAdmin,
+	"/subcategory/delete":         RoleAdmin,
+	"/subcategory/rename":         RoleAdmin,
+	"/subcategory/merge":          RoleAdmin,
+	"/subcategory-mappings/edit":  RoleAdmin,
+	"/reports":                    RoleAdmin, // the recipients' addresses
+	"/reports/edit":               RoleAdmin,
+	"/reports/send":               RoleAdmin,
+	"/api/reports/saved":          RoleAdmin, // webhook URLs often carry keys
+	"/api/reports/saved/edit":     RoleAdmin,
+	"/api/reports/saved/run":      RoleAdmin,
+	"/api/merchants/aliases/edit": RoleAdmin,
+	"/api/merchants/merge":        RoleAdmin,
+	"/api/accounts/edit":          RoleAdmin,
+	"/api/holdings/edit":          RoleAdmin,
+	"/api/holdings/refresh":       RoleAdmin,
+	"/api/shares":                 RoleAdmin,
+	"/api/shares/revoke":          RoleAdmin,
+	"/api/plaid/items":            RoleAdmin,
+	"/api/plaid/link-token":       RoleAdmin,
+	"/api/plaid/exchange":         RoleAdmin,
+	"/api/plaid/sync":             RoleAdmin,
+	"/api/plaid/items/remove":     RoleAdmin,
+	"/api/trmnl/profiles":         RoleAdmin, // lists the displays
+	"/api/trmnl/profiles/edit":    RoleAdmin,
+	"/api/trmnl/profiles/remove":  RoleAdmin,
+	"/api/voice/keys":             RoleAdmin,
+	"/api/voice/keys/remove":      RoleAdmin,
+}
+
+// routePrefixPermissions are like routePermissions, for routes with a path parameter
+var routePrefixPermissions = map[string]Role{
+	"/share/":    RolePublic, // the token in the path is the access check
+	"/webfonts/": RolePublic,
+	"/pwa/":      RolePublic,
+	"/i18n/":     RolePublic, // catalogs for the login page
+
+	"/api/wallet/v1/": RolePublic, // the pass web service checks the pass's authentication token
+}
+
+// AccessControl checks the role of the proxy-authenticated or signed-in user against the route
+type AccessControl struct {
+	header      string          // header with the authenticated user name
+	users       map[string]Role // user -> role
+	defaultRole Role            // for users not in users; RolePublic denies them
+	magicLinks  *magicLinks     // set for ACCESS_AUTH=magic-link, where users come from sessions
+	tokens      storage.Storage // API keys, besides the login tokens of magic links
+}
+
+// AccessControlFromEnv reads ACCESS_ROLES, ACCESS_USER_HEADER (default Remote-User), and
+// ACCESS_DEFAULT_ROLE (for users without a role, denied when empty); nil when ACCESS_ROLES is unset.
+// With ACCESS_AUTH=magic-link, login tokens and sessions are kept in tokens.
+func AccessControlFromEnv(tokens storage.Storage) (*AccessControl, error) {
+	return accessControlFromEnv(os.Getenv("ACCESS_ROLES"), os.Getenv("ACCESS_DEFAULT_ROLE"), os.Getenv("PUBLIC_URL"), tokens)
+}
+
+// TenantAccessControlFromEnv is AccessControlFromEnv for a tenant, with the users of
+// ACCESS_ROLES_<TENANT> (e.g., ACCESS_ROLES_SMITHS) and ACCESS_DEFAULT_ROLE_<TENANT>. The tenant of
+// a request comes from its host or path, which the client picks, so tenants never share users or
+// a default role, and a tenant without ACCESS_ROLES_<TENANT> is an error rather than open.
+func TenantAccessControlFromEnv(tenant string, tokens storage.Storage) (*AccessControl, error) {
+	rolesName := tenantEnvName(tenant, "ACCESS_ROLES")
+	rolesEnv := os.Getenv(rolesName)
+	if rolesEnv == "" {
+		return nil, fmt.Errorf("%s is required with TENANTS, as tenants don't share users", rolesName)
+	}
+	return accessControlFromEnv(rolesEnv, os.Getenv(tenantEnvName(tenant, "ACCESS_DEFAULT_ROLE")), tenantPublicURL(tenant), tokens)
+}
+
+func accessControlFromEnv(rolesEnv string, defaultRoleEnv string, publicURL string, tokens storage.Storage) (*AccessControl, error) {
+	if rolesEnv == "" {
+		return nil, nil
+	}
+	access := &AccessControl{header: os.Getenv("ACCESS_USER_HEADER"), users: make(map[string]Role), tokens: tokens}
+	if access.header == "" {
+		access.header = "Remote-User"
+	}
+	switch auth := os.Getenv("ACCESS_AUTH"); auth {
+	case "", authProxy:
+	case authMagicLink:
+		links, err := magicLinksFromEnv(publicURL, tokens)
+		if err != nil {
+			return nil, err
+		}
+		access.magicLinks = links
+	default:
+		return nil, fmt.Errorf("invalid ACCESS_AUTH '%s', must be proxy or magic-link", auth)
+	}
+	for _, entry := range splitAndTrim(rolesEnv, ",") {
+		user, roleName, ok := strings.Cut(entry, ":")
+		user = strings.TrimSpace(user)
+		if access.magicLinks != nil {
+			user = strings.ToLower(user) // email addresses
+		}
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid ACCESS_ROLES entry '%s', expected user:role", entry)
+		}
+		role, err := parseRole(strings.TrimSpace(roleName))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCESS_ROLES entry '%s': %v", entry, err)
+		}
+		access.users[user] = role
+	}
+	if defaultRoleEnv != "" {
+		role, err := parseRole(defaultRoleEnv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACCESS_DEFAULT_ROLE: %v", err)
+		}
+		access.defaultRole = role
+	}
+	return access, nil
+}
+
+type userContextKey struct{}
+
+// requestUser returns the user the access control let through, empty when access control is off
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(userContextKey{}).(string)
+	return user
+}
+
+// role returns the role of a user, RolePublic when they have none
+func (a *AccessControl) role(user string) Role {
+	if role, ok := a.users[user]; ok {
+		return role
+	}
+	return a.defaultRole
+}
+
+// requiredRole returns the role a request needs; /api/v1 routes need the same as their unversioned route
+func requiredRole(r *http.Request) Role {
+	path := unversionedPath(r.URL.Path)
+	if role, ok := routePermissions[path]; ok {
+		return role
+	}
+	for prefix, role := range routePrefixPermissions {
+		if strings.HasPrefix(path, prefix) {
+			return role
+		}
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return RoleViewer
+	}
+	return RoleEditor
+}
+
+// Middleware rejects requests from unknown users (401) and users without the route's role (403);
+// with magic links, pages redirect to the login page instead
+func (a *AccessControl) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		required := requiredRole(r)
+		if required == RolePublic {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var user string
+		if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if user = a.apiKeyUser(r, key); user == "" {
+				writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid or expired API key"})
+				return
+			}
+		} else if a.magicLinks != nil {
+			user = a.sessionUser(w, r)
+		} else {
+			user = strings.TrimSpace(r.Header.Get(a.header))
+		}
+		if user == "" {
+			if a.magicLinks != nil && r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+				http.Redirect(w, r, tenantPrefix(r)+"/login", http.StatusSeeOther)
+				return
+			}
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Not authenticated"})
+			return
+		}
+		role := a.role(user)
+		if role < required {
+			log.Printf("HTTP: Denied %s %s to %s (%s)\n", r.Method, r.URL.Path, user, role)
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: fmt.Sprintf("This requires the %s role", required)})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	})
+}
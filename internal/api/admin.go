@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Runtime settings cover toggles that used to need a restart: which notification channels are
+// on, how often the background jobs run, and UI defaults. Connection details (tokens, brokers,
+// SMTP) stay in environment variables and are never exposed here.
+
+// runtimeSettings reads the settings fresh so changes apply on the next use, falling back to the defaults
+func (h *Handler) runtimeSettings() storage.RuntimeSettings {
+	settings, err := h.storage.GetRuntimeSettings()
+	if err != nil {
+		log.Printf("API ERROR: Failed to get runtime settings, using defaults: %v\n", err)
+		return storage.DefaultRuntimeSettings()
+	}
+	return settings
+}
+
+// AdminSettings reads (GET) or replaces (PUT) the runtime settings
+func (h *Handler) AdminSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		settings, err := h.storage.GetRuntimeSettings()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get settings"})
+			log.Printf("API ERROR: Failed to get runtime settings: %v\n", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, settings)
+	case http.MethodPut:
+		var settings storage.RuntimeSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+			return
+		}
+		if err := storage.ValidateRuntimeSettings(&settings); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		if name, ok := unknownJob(settings.Schedules); ok {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("unknown job '%s' in schedules", name)})
+			return
+		}
+		if err := h.storage.UpdateRuntimeSettings(settings); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update settings"})
+			log.Printf("API ERROR: Failed to update runtime settings: %v\n", err)
+			return
+		}
+		h.jobs.reschedule()
+		writeJSON(w, http.StatusOK, settings)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+	}
+}
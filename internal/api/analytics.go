@@ -1,34 +1,125 @@
 package api
 
 import (
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
 	"time"
 
+	"github.com/tanq16/expenseowl/internal/analytics"
+	"github.com/tanq16/expenseowl/internal/periods"
 	"github.com/tanq16/expenseowl/internal/storage"
 )
 
 // TRMNLResponse represents the data structure for TRMNL polling
 type TRMNLResponse struct {
-	Month          string              `json:"month"`           // e.g., "January 2026"
-	TotalIncome    float64             `json:"total_income"`    // positive amounts
-	TotalExpenses  float64             `json:"total_expenses"`  // negative amounts (absolute value)
-	Balance        float64             `json:"balance"`         // income - expenses
-	Currency       string              `json:"currency"`        // e.g., "usd"
-	TopCategories  []CategorySummary   `json:"top_categories"`  // top 5 expense categories
-	AllCategories  []CategorySummary   `json:"all_categories"`  // all categories with amounts and percentages
-	MonthlyTrend   []MonthlyData       `json:"monthly_trend"`   // last 12 months trend
-	LastUpdated    string              `json:"last_updated"`    // ISO timestamp
+	Month          string              `json:"month"`                    // current period, e.g., "January 2026" or "Mar 9 - Mar 15, 2026"
+	TotalIncome    *float64            `json:"total_income,omitempty"`   // positive amounts (omitted with income=false)
+	TotalExpenses  float64             `json:"total_expenses"`           // negative amounts (absolute value)
+	Balance        *float64            `json:"balance,omitempty"`        // income - expenses (omitted with income=false)
+	Currency       string              `json:"currency"`                 // e.g., "usd"
+	TopCategories  []CategorySummary   `json:"top_categories"`           // top N expense categories (default 5)
+	AllCategories  []CategorySummary   `json:"all_categories,omitempty"` // all categories (omitted in compact mode)
+	MonthlyTrend   []MonthlyData       `json:"monthly_trend,omitempty"`  // last N periods trend (default 12)
+	Budgets        []TRMNLBudget       `json:"budgets,omitempty"`        // per-category budgets (with budgets=true)
+	LastUpdated    string              `json:"last_updated"`             // ISO timestamp
+}
+
+// TRMNLBudget is a budget line for the TRMNL budget section
+type TRMNLBudget struct {
+	Name       string  `json:"name"`
+	Budget     float64 `json:"budget"`
+	Carried    float64 `json:"carried,omitempty"` // from earlier periods with a rollover mode
+	Spent      float64 `json:"spent"`
+	Remaining  float64 `json:"remaining"`
+	Percentage float64 `json:"percentage"` // share of the budget spent
+}
+
+// trmnlOptions customizes the TRMNL payload, mostly to fit TRMNL's payload size limits
+type trmnlOptions struct {
+	Period     string   // "week" or "month" instead of the budgeting period, empty for it
+	Categories []string // only these categories, all when empty
+	Top        int      // number of top categories
+	Trend      int      // number of periods in the trend, 0 to omit it
+	Fiscal     bool     // trend covers the fiscal year to date instead
+	Income     bool     // include income and balance
+	Budgets    bool     // include the budget section
+	Compact    bool     // omit all_categories
+}
+
+const (
+	maxTRMNLTop   = 50
+	maxTRMNLTrend = 36
+)
+
+// parseTRMNLOptions reads the options from query parameters, e.g. ?period=week&categories=Food,Home&top=3&trend=6&income=false&budgets=true&compact=true&fiscal=true
+func parseTRMNLOptions(query url.Values) (trmnlOptions, error) {
+	options := trmnlOptions{Top: 5, Trend: 12, Income: true}
+	switch options.Period = query.Get("period"); options.Period {
+	case "", "week", "month":
+	default:
+		return options, fmt.Errorf("invalid 'period': must be week or month")
+	}
+	if categories := splitAndTrim(query.Get("categories"), ","); len(categories) > 0 {
+		options.Categories = categories
+	}
+	intParams := []struct {
+		name  string
+		value *int
+		min   int
+		max   int
+	}{
+		{"top", &options.Top, 1, maxTRMNLTop},
+		{"trend", &options.Trend, 0, maxTRMNLTrend},
+	}
+	for _, p := range intParams {
+		raw := query.Get(p.name)
+		if raw == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < p.min || parsed > p.max {
+			return options, fmt.Errorf("invalid '%s': must be a number from %d to %d", p.name, p.min, p.max)
+		}
+		*p.value = parsed
+	}
+	boolParams := []struct {
+		name  string
+		value *bool
+	}{
+		{"income", &options.Income},
+		{"budgets", &options.Budgets},
+		{"compact", &options.Compact},
+		{"fiscal", &options.Fiscal},
+	}
+	for _, p := range boolParams {
+		raw := query.Get(p.name)
+		if raw == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return options, fmt.Errorf("invalid '%s': must be true or false", p.name)
+		}
+		*p.value = parsed
+	}
+	return options, nil
 }
 
 type CategorySummary struct {
 	Name       string  `json:"name"`
-	Amount     float64 `json:"amount"`     // absolute value
-	Percentage float64 `json:"percentage"` // percentage of total expenses
+	Amount     float64 `json:"amount"`          // absolute value
+	Percentage float64 `json:"percentage"`      // percentage of total expenses
+	Color      string  `json:"color,omitempty"` // configured category color
+	Icon       string  `json:"icon,omitempty"`  // configured category icon
 }
 
 type MonthlyData struct {
-	Month         string  `json:"month"`          // e.g., "2026-01" or "Jan 2026"
+	Month         string  `json:"month"`          // period label, e.g., "Jan 2026" or "Mar 6"
 	TotalIncome   float64 `json:"total_income"`   // income for the month
 	TotalExpenses float64 `json:"total_expenses"` // expenses for the month
 	Balance       float64 `json:"balance"`        // net balance
@@ -40,163 +131,274 @@ func (h *Handler) GetTRMNLData(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
 		return
 	}
-
-	// Get all expenses
-	expenses, err := h.storage.GetAllExpenses()
+	options, err := parseTRMNLOptions(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := h.trmnlData(options)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
 		log.Printf("API ERROR: Failed to retrieve expenses for TRMNL: %v\n", err)
 		return
 	}
+	writeJSON(w, http.StatusOK, response)
+	log.Println("HTTP: Served TRMNL data")
+}
 
+// trmnlData builds the TRMNL payload for the options
+func (h *Handler) trmnlData(options trmnlOptions) (*TRMNLResponse, error) {
 	// Get currency
 	currency, err := h.storage.GetCurrency()
 	if err != nil {
 		currency = "usd" // default fallback
 	}
 
-	// Get start date
-	startDate, err := h.storage.GetStartDate()
+	// Calculate current period and trend periods from the configured period type, or weeks or months
+	budgetConfig := h.periodConfig()
+	periodConfig := budgetConfig
+	switch {
+	case options.Period == "week" && budgetConfig.Type != periods.Weekly:
+		// weeks start on the day weekly and biweekly periods do, Monday otherwise
+		periodConfig = periods.Config{Type: periods.Weekly, Anchor: budgetConfig.Anchor}
+	case options.Period == "month" && budgetConfig.Type != periods.Monthly:
+		periodConfig = periods.Default()
+	}
+	period := periodConfig.Current(time.Now())
+	var trendPeriods []periods.Period
+	if options.Fiscal {
+		fiscalYear := periods.FiscalYear(time.Now(), h.fiscalYearStart())
+		trendPeriods = periodConfig.Since(fiscalYear.Start, time.Now())
+	} else if options.Trend > 0 {
+		trendPeriods = periodConfig.Last(options.Trend, time.Now())
+	}
+	summarize, err := h.summarizerFor(append(trendPeriods, period), options.Categories)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate totals and category breakdown for current period
+	totalIncome, totalExpenses, categoryTotals := summarize(period)
+
+	// Get top N categories by spending
+	topCategories := getTopCategories(categoryTotals, totalExpenses, options.Top)
+
+	// Get all categories sorted by amount, unless compact
+	var allCategories []CategorySummary
+	if !options.Compact {
+		allCategories = getTopCategories(categoryTotals, totalExpenses, len(categoryTotals))
+	}
+
+	// Use configured colors and icons so TRMNL matches the dashboard
+	if meta, err := h.storage.GetCategoryMeta(); err == nil {
+		applyCategoryMeta(topCategories, meta)
+		applyCategoryMeta(allCategories, meta)
+	}
+
+	response := &TRMNLResponse{
+		Month:         period.Label(),
+		TotalExpenses: totalExpenses,
+		Currency:      currency,
+		TopCategories: topCategories,
+		AllCategories: allCategories,
+		LastUpdated:   time.Now().UTC().Format(time.RFC3339),
+	}
+	if options.Income {
+		balance := totalIncome - totalExpenses
+		response.TotalIncome = &totalIncome
+		response.Balance = &balance
+	}
+	if trendPeriods != nil {
+		response.MonthlyTrend = calculatePeriodTrend(summarize, trendPeriods)
+	}
+	if options.Budgets {
+		// the same amounts as /budgets/status, so rollover categories show what carried over; limits
+		// are per budgeting period, so they cover it whatever the period of the rest of the payload
+		if expenses, err := h.storage.GetAllExpenses(); err == nil {
+			if budgets, err := h.budgetStatus(expenses, budgetConfig.Current(time.Now())); err == nil {
+				if len(options.Categories) > 0 {
+					budgets = slices.DeleteFunc(budgets, func(b CategoryBudget) bool { return !slices.Contains(options.Categories, b.Category) })
+				}
+				response.Budgets = getTRMNLBudgets(budgets)
+			}
+		}
+	}
+	return response, nil
+}
+
+// periodConfig returns the configured budgeting period, falling back to calendar months
+func (h *Handler) periodConfig() periods.Config {
+	period, err := h.storage.GetPeriod()
 	if err != nil {
-		startDate = 1 // default fallback
+		return periods.Default()
+	}
+	return period
+}
+
+// summarizer totals income and expenses within a period, with expenses broken down by category
+type summarizer func(period periods.Period) (float64, float64, map[string]float64)
+
+// expenseSummarizer summarizes periods by scanning the expenses
+func expenseSummarizer(expenses []storage.Expense) summarizer {
+	return func(period periods.Period) (float64, float64, map[string]float64) {
+		return summarizePeriod(expenses, period)
+	}
+}
+
+// aggregateSummarizer summarizes periods made of whole calendar months from the monthly aggregates
+func aggregateSummarizer(aggregates []storage.MonthlyAggregate) summarizer {
+	return func(period periods.Period) (float64, float64, map[string]float64) {
+		var totalIncome, totalExpenses float64
+		categoryTotals := make(map[string]float64)
+		for _, aggregate := range aggregates {
+			month, err := time.Parse("2006-01", aggregate.Period)
+			if err != nil || !period.Contains(month) {
+				continue
+			}
+			totalIncome += aggregate.Income
+			if aggregate.Expenses != 0 { // negative for refunds alone in their month
+				totalExpenses += aggregate.Expenses
+				categoryTotals[aggregate.Category] += aggregate.Expenses
+			}
+		}
+		return totalIncome, totalExpenses, categoryTotals
 	}
+}
 
-	// Calculate current month range based on start date
-	now := time.Now()
-	var monthStart, monthEnd time.Time
+// wholeMonths reports whether a period starts and ends on the 1st of a month, so monthly aggregates can sum it
+func wholeMonths(period periods.Period) bool {
+	isFirst := func(t time.Time) bool {
+		t = t.UTC()
+		return t.Day() == 1 && t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+	}
+	return isFirst(period.Start) && isFirst(period.End)
+}
 
-	if now.Day() >= startDate {
-		// Current period: startDate of this month to startDate-1 of next month
-		monthStart = time.Date(now.Year(), now.Month(), startDate, 0, 0, 0, 0, time.UTC)
-		monthEnd = time.Date(now.Year(), now.Month()+1, startDate, 0, 0, 0, 0, time.UTC)
-	} else {
-		// Current period: startDate of last month to startDate-1 of this month
-		monthStart = time.Date(now.Year(), now.Month()-1, startDate, 0, 0, 0, 0, time.UTC)
-		monthEnd = time.Date(now.Year(), now.Month(), startDate, 0, 0, 0, 0, time.UTC)
+// summarizerFor reads the monthly aggregates when all periods are whole calendar months (e.g.,
+// monthly periods starting on the 1st, or years), and falls back to all expenses otherwise;
+// categories limits either to those categories
+func (h *Handler) summarizerFor(periodList []periods.Period, categories []string) (summarizer, error) {
+	if !slices.ContainsFunc(periodList, func(p periods.Period) bool { return !wholeMonths(p) }) {
+		aggregates, err := h.storage.GetMonthlyAggregates()
+		if err != nil {
+			return nil, err
+		}
+		if len(categories) > 0 {
+			aggregates = slices.DeleteFunc(aggregates, func(a storage.MonthlyAggregate) bool { return !slices.Contains(categories, a.Category) })
+		}
+		// the aggregates leave out excluded expenses, but not excluded categories
+		if meta, err := h.storage.GetCategoryMeta(); err == nil {
+			aggregates = slices.DeleteFunc(aggregates, func(a storage.MonthlyAggregate) bool { return meta[a.Category].Excluded })
+		}
+		return aggregateSummarizer(aggregates), nil
 	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		return nil, err
+	}
+	return expenseSummarizer(filterExpensesByCategories(h.reportable(expenses), categories)), nil
+}
 
-	// Calculate totals and category breakdown for current month
+// summarizePeriod totals income and expenses within a period, with expenses broken down by category
+func summarizePeriod(expenses []storage.Expense, period periods.Period) (float64, float64, map[string]float64) {
 	var totalIncome, totalExpenses float64
 	categoryTotals := make(map[string]float64)
-
 	for _, expense := range expenses {
-		// Check if expense is in current period
-		if expense.Date.Before(monthStart) || expense.Date.After(monthEnd) {
+		// Check if expense is in the period
+		if !period.Contains(expense.Date) {
 			continue
 		}
-
-		if expense.Amount >= 0 {
+		if expense.Amount >= 0 && !expense.IsRefund() {
 			// Income
 			totalIncome += expense.Amount
 		} else {
-			// Expense
+			// Expense, or a refund taken off the expenses
 			absAmount := -expense.Amount
 			totalExpenses += absAmount
 			categoryTotals[expense.Category] += absAmount
 		}
 	}
-
-	// Get top 5 categories by spending
-	topCategories := getTopCategories(categoryTotals, totalExpenses, 5)
-
-	// Get all categories sorted by amount
-	allCategories := getTopCategories(categoryTotals, totalExpenses, len(categoryTotals))
-
-	// Calculate last 12 months trend
-	monthlyTrend := calculateMonthlyTrend(expenses, startDate, 12)
-
-	response := TRMNLResponse{
-		Month:         monthStart.Format("January 2006"),
-		TotalIncome:   totalIncome,
-		TotalExpenses: totalExpenses,
-		Balance:       totalIncome - totalExpenses,
-		Currency:      currency,
-		TopCategories: topCategories,
-		AllCategories: allCategories,
-		MonthlyTrend:  monthlyTrend,
-		LastUpdated:   time.Now().UTC().Format(time.RFC3339),
-	}
-
-	writeJSON(w, http.StatusOK, response)
-	log.Println("HTTP: Served TRMNL data")
+	return totalIncome, totalExpenses, categoryTotals
 }
 
-// getTopCategories returns top N categories sorted by amount with percentages
+// getTopCategories returns top N categories sorted by amount (ties by name) with percentages
 func getTopCategories(categoryTotals map[string]float64, totalExpenses float64, limit int) []CategorySummary {
-	// Convert map to slice
-	categories := make([]CategorySummary, 0, len(categoryTotals))
-	for name, amount := range categoryTotals {
+	top := analytics.TopTotals(categoryTotals, limit)
+	categories := make([]CategorySummary, 0, len(top))
+	for _, total := range top {
 		percentage := 0.0
 		if totalExpenses > 0 {
-			percentage = (amount / totalExpenses) * 100
+			percentage = (total.Amount / totalExpenses) * 100
 		}
 		categories = append(categories, CategorySummary{
-			Name:       name,
-			Amount:     amount,
+			Name:       total.Name,
+			Amount:     total.Amount,
 			Percentage: percentage,
 		})
 	}
+	return categories
+}
 
-	// Simple bubble sort (good enough for small datasets)
-	for i := 0; i < len(categories); i++ {
-		for j := i + 1; j < len(categories); j++ {
-			if categories[j].Amount > categories[i].Amount {
-				categories[i], categories[j] = categories[j], categories[i]
-			}
+// applyCategoryMeta fills in the configured color and icon of each category
+func applyCategoryMeta(categories []CategorySummary, meta map[string]storage.CategoryMeta) {
+	for i := range categories {
+		if m, ok := meta[categories[i].Name]; ok {
+			categories[i].Color = m.Color
+			categories[i].Icon = m.Icon
 		}
 	}
+}
 
-	// Return top N
-	if len(categories) > limit {
-		return categories[:limit]
+// getBudgetSummaries compares each budget with the spending in its category, sorted by name
+func getBudgetSummaries(budgets map[string]float64, categoryTotals map[string]float64) []TRMNLBudget {
+	summaries := make([]TRMNLBudget, 0, len(budgets))
+	for name, limit := range budgets {
+		spent := categoryTotals[name]
+		summaries = append(summaries, TRMNLBudget{
+			Name:       name,
+			Budget:     limit,
+			Spent:      spent,
+			Remaining:  limit - spent,
+			Percentage: (spent / limit) * 100,
+		})
 	}
-	return categories
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
 }
 
-// calculateMonthlyTrend calculates income, expenses, and balance for the last N months
-func calculateMonthlyTrend(expenses []storage.Expense, startDate int, months int) []MonthlyData {
-	now := time.Now()
-	trend := make([]MonthlyData, 0, months)
-
-	// Calculate for each of the last N months
-	for i := months - 1; i >= 0; i-- {
-		var monthStart, monthEnd time.Time
-
-		// Calculate the target month (going back i months from now)
-		targetDate := now.AddDate(0, -i, 0)
-
-		if targetDate.Day() >= startDate {
-			// Period: startDate of target month to startDate-1 of next month
-			monthStart = time.Date(targetDate.Year(), targetDate.Month(), startDate, 0, 0, 0, 0, time.UTC)
-			monthEnd = time.Date(targetDate.Year(), targetDate.Month()+1, startDate, 0, 0, 0, 0, time.UTC)
-		} else {
-			// Period: startDate of previous month to startDate-1 of target month
-			monthStart = time.Date(targetDate.Year(), targetDate.Month()-1, startDate, 0, 0, 0, 0, time.UTC)
-			monthEnd = time.Date(targetDate.Year(), targetDate.Month(), startDate, 0, 0, 0, 0, time.UTC)
+// getTRMNLBudgets turns the budgets of a period into TRMNL budget lines, the percentage being the
+// share of the available budget spent (100 once nothing is available)
+func getTRMNLBudgets(budgets []CategoryBudget) []TRMNLBudget {
+	lines := make([]TRMNLBudget, len(budgets))
+	for i, budget := range budgets {
+		percentage := 100.0
+		if budget.Available > 0 {
+			percentage = (budget.Spent / budget.Available) * 100
 		}
-
-		// Calculate totals for this month
-		var income, expenseTotal float64
-		for _, expense := range expenses {
-			if expense.Date.Before(monthStart) || expense.Date.After(monthEnd) {
-				continue
-			}
-
-			if expense.Amount >= 0 {
-				income += expense.Amount
-			} else {
-				expenseTotal += -expense.Amount
-			}
+		lines[i] = TRMNLBudget{
+			Name:       budget.Category,
+			Budget:     budget.Budget,
+			Carried:    budget.Carried,
+			Spent:      budget.Spent,
+			Remaining:  budget.Remaining,
+			Percentage: percentage,
 		}
+	}
+	return lines
+}
 
+// calculatePeriodTrend calculates income, expenses, and balance for each of the periods
+func calculatePeriodTrend(summarize summarizer, periodList []periods.Period) []MonthlyData {
+	trend := make([]MonthlyData, 0, len(periodList))
+	for _, period := range periodList {
+		income, expenseTotal, _ := summarize(period)
 		trend = append(trend, MonthlyData{
-			Month:         monthStart.Format("Jan 2006"),
+			Month:         period.ShortLabel(),
 			TotalIncome:   income,
 			TotalExpenses: expenseTotal,
 			Balance:       income - expenseTotal,
 		})
 	}
-
 	return trend
 }
 
@@ -222,3 +424,24 @@ func filterExpensesByCategories(expenses []storage.Expense, categories []string)
 
 	return filtered
 }
+
+// reportable leaves out the expenses excluded from reports, by themselves or by their category;
+// they are still stored and listed, but don't count towards totals or budgets
+func (h *Handler) reportable(expenses []storage.Expense) []storage.Expense {
+	meta, err := h.storage.GetCategoryMeta()
+	if err != nil {
+		meta = map[string]storage.CategoryMeta{}
+	}
+	return reportableExpenses(expenses, meta)
+}
+
+// reportableExpenses leaves out the expenses excluded by themselves or by their category's meta
+func reportableExpenses(expenses []storage.Expense, meta map[string]storage.CategoryMeta) []storage.Expense {
+	filtered := make([]storage.Expense, 0, len(expenses))
+	for _, expense := range expenses {
+		if !expense.Excluded && !meta[expense.Category].Excluded {
+			filtered = append(filtered, expense)
+		}
+	}
+	return filtered
+}
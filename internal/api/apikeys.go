@@ -0,0 +1,204 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// API keys let scripts and the CLI act as a user with an Authorization: Bearer header, next to the
+// proxy header or session of the browser. A signed-in user creates them, and sees the key only
+// once; the store keeps its hash, like login tokens. A key has the user's current role in
+// ACCESS_ROLES, so it stops working when the user loses access.
+
+const (
+	defaultAPIKeyDays = 365
+	maxAPIKeyDays     = 5 * 365
+)
+
+// CreateAPIKeyRequest names a new API key, and how long it lasts (a year when zero)
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+	Days int    `json:"days"`
+}
+
+// APIKeyInfo describes an API key, without the key itself
+type APIKeyInfo struct {
+	ID        string    `json:"id"` // for revoking it
+	User      string    `json:"user"`
+	Name      string    `json:"name"`
+	IP        string    `json:"ip"` // address it was last used from
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse is the new key, which can't be shown again
+type CreateAPIKeyResponse struct {
+	Key string `json:"key"`
+	APIKeyInfo
+}
+
+func apiKeyInfo(key storage.LoginToken) APIKeyInfo {
+	return APIKeyInfo{
+		ID:        key.Hash,
+		User:      key.User,
+		Name:      key.Device,
+		IP:        key.IP,
+		CreatedAt: key.CreatedAt,
+		LastUsed:  key.LastUsedAt,
+		ExpiresAt: key.ExpiresAt,
+	}
+}
+
+// apiKeyUser returns the user of a bearer API key, empty when it isn't a valid one. Like sessions,
+// it records when and where the key was used.
+func (a *AccessControl) apiKeyUser(r *http.Request, key string) string {
+	if a.tokens == nil || key == "" {
+		return ""
+	}
+	apiKey, err := a.tokens.GetLoginToken(hashLoginToken(key))
+	if err != nil || apiKey.Kind != storage.LoginAPIKey {
+		return ""
+	}
+	if time.Since(apiKey.LastUsedAt) > sessionTouchInterval {
+		apiKey.LastUsedAt = time.Now().UTC()
+		apiKey.IP = clientIP(r)
+		if err := a.tokens.UpdateLoginToken(apiKey); err != nil {
+			log.Printf("HTTP ERROR: Failed to update API key: %v\n", err)
+		}
+	}
+	return apiKey.User
+}
+
+// apiKeysOf returns the active API keys of a user, or everyone's when user is empty
+func (a *AccessControl) apiKeysOf(user string) ([]storage.LoginToken, error) {
+	tokens, err := a.tokens.GetLoginTokens(user)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(tokens, func(t storage.LoginToken) bool {
+		return t.Kind != storage.LoginAPIKey
+	}), nil
+}
+
+// APIKeys lists the user's API keys on GET, admins can list everyone's with ?all=true, and creates
+// one on POST
+func (a *AccessControl) APIKeys(w http.ResponseWriter, r *http.Request) {
+	if a.tokens == nil {
+		http.NotFound(w, r)
+		return
+	}
+	user := requestUser(r)
+	if user == "" {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Not authenticated"})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if all, _ := strconv.ParseBool(r.URL.Query().Get("all")); all {
+			if a.role(user) < RoleAdmin {
+				writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "Only admins can see other users' API keys"})
+				return
+			}
+			user = ""
+		}
+		keys, err := a.apiKeysOf(user)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get API keys"})
+			log.Printf("API ERROR: Failed to get API keys: %v\n", err)
+			return
+		}
+		response := []APIKeyInfo{}
+		for _, key := range keys {
+			response = append(response, apiKeyInfo(key))
+		}
+		writeJSON(w, http.StatusOK, response)
+	case http.MethodPost:
+		var req CreateAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "'name' is required"})
+			return
+		}
+		if req.Days == 0 {
+			req.Days = defaultAPIKeyDays
+		}
+		if req.Days < 1 || req.Days > maxAPIKeyDays {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "'days' must be between 1 and " + strconv.Itoa(maxAPIKeyDays)})
+			return
+		}
+		key, apiKey, err := newLoginToken(a.tokens, storage.LoginToken{
+			Kind:   storage.LoginAPIKey,
+			User:   user,
+			Role:   a.role(user).String(),
+			Device: req.Name,
+			IP:     clientIP(r),
+		}, time.Duration(req.Days)*24*time.Hour)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to create API key"})
+			log.Printf("API ERROR: Failed to create API key: %v\n", err)
+			return
+		}
+		log.Printf("Created API key '%s' for %s\n", req.Name, user)
+		writeJSON(w, http.StatusOK, CreateAPIKeyResponse{Key: key, APIKeyInfo: apiKeyInfo(apiKey)})
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+	}
+}
+
+// RevokeAPIKey deletes the API key with ?id=; admins can revoke any user's keys
+func (a *AccessControl) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if a.tokens == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	user := requestUser(r)
+	if user == "" {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Not authenticated"})
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "'id' is required"})
+		return
+	}
+	keys, err := a.apiKeysOf("")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get API keys"})
+		log.Printf("API ERROR: Failed to get API keys: %v\n", err)
+		return
+	}
+	i := slices.IndexFunc(keys, func(k storage.LoginToken) bool {
+		return k.Hash == id
+	})
+	if i < 0 {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "API key not found"})
+		return
+	}
+	if keys[i].User != user && a.role(user) < RoleAdmin {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "Only admins can revoke other users' API keys"})
+		return
+	}
+	if _, err := a.tokens.RemoveLoginToken(id); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke API key"})
+		log.Printf("API ERROR: Failed to revoke API key: %v\n", err)
+		return
+	}
+	log.Printf("Revoked API key '%s' of %s\n", keys[i].Device, keys[i].User)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
@@ -0,0 +1,401 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/storage"
+	"github.com/tanq16/expenseowl/internal/web"
+)
+
+// The basic pages under /basic are a server-rendered fallback of the table and summary for screen
+// readers and browsers without JavaScript. Their forms post back to them and save through the same
+// handlers as the web UI, so rules, limits, and strict categories apply the same way.
+
+const basicDateLayout = "2006-01-02"
+
+// basicPage is the data every basic page has
+type basicPage struct {
+	web.Branding
+	Title string
+	Page  string // table, summary, or edit, marks the page in the navigation
+}
+
+// basicPeriodPage is a basic page that shows one period, with links to its neighbours
+type basicPeriodPage struct {
+	basicPage
+	Period string
+	Prev   string // a date in the previous period
+	Next   string // a date in the next period
+	Spent  string
+	Income string
+}
+
+// basicRow is an expense formatted for the basic pages
+type basicRow struct {
+	ID       string
+	Date     string
+	Name     string
+	Category string
+	Amount   string // without its sign
+	Income   bool
+}
+
+// basicForm is the content of the add and edit form
+type basicForm struct {
+	Name     string
+	Category string
+	Amount   string
+	Income   bool
+	Date     string
+	Tags     string
+	Notes    string
+}
+
+type basicFormPage struct {
+	basicPage
+	ID           string // empty when adding
+	Form         basicForm
+	Categories   []string
+	Error        string
+	NeedsConfirm bool // the amount is above its category's limit
+}
+
+type basicDeletePage struct {
+	basicPage
+	Row   basicRow
+	Error string
+}
+
+type basicTablePage struct {
+	basicPeriodPage
+	Rows []basicRow
+}
+
+type basicSummaryPage struct {
+	basicPeriodPage
+	Balance    string
+	Categories []struct{ Name, Amount, Share string }
+	History    []struct{ Name, Date, Spent, Income string }
+}
+
+// renderBasic writes a basic page with the given status
+func renderBasic(w http.ResponseWriter, status int, templateName string, data any) {
+	var buf bytes.Buffer
+	if err := web.RenderTemplate(&buf, templateName, data); err != nil {
+		log.Printf("HTTP ERROR: Failed to render %s: %v\n", templateName, err)
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// sameOrigin rejects form posts from other sites; browsers send Origin with them
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// formResponse keeps what an API handler writes, so a form can show its error
+type formResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (f *formResponse) Header() http.Header         { return f.header }
+func (f *formResponse) Write(b []byte) (int, error) { return f.body.Write(b) }
+func (f *formResponse) WriteHeader(status int)      { f.status = status }
+
+// callAPI runs an API handler for a form and returns its status, with its error message when it failed
+func callAPI(r *http.Request, handler http.HandlerFunc, method, target string, body any) (int, string) {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+	}
+	req, err := http.NewRequestWithContext(r.Context(), method, target, &payload)
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	response := &formResponse{header: http.Header{}, status: http.StatusOK}
+	handler(response, req)
+	if response.status < 300 {
+		return response.status, ""
+	}
+	var apiError ErrorResponse
+	json.Unmarshal(response.body.Bytes(), &apiError)
+	return response.status, cmp.Or(apiError.Error, http.StatusText(response.status))
+}
+
+// basicPeriod is the period containing ?date=, the current one without it
+func (h *Handler) basicPeriod(r *http.Request) (periods.Period, basicPeriodPage) {
+	periodConfig := h.periodConfig()
+	date, err := time.Parse(basicDateLayout, r.URL.Query().Get("date"))
+	if err != nil {
+		date = time.Now()
+	}
+	period := periodConfig.Containing(date)
+	return period, basicPeriodPage{
+		Period: period.Label(),
+		Prev:   periodConfig.Previous(period).Start.Format(basicDateLayout),
+		Next:   periodConfig.Next(period).Start.Format(basicDateLayout),
+	}
+}
+
+// basicFormat is the number format of the display currency
+func (h *Handler) basicFormat() numberFormat {
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+	return currencyFormat(currency)
+}
+
+func toBasicRow(expense storage.Expense, format numberFormat) basicRow {
+	return basicRow{
+		ID:       expense.ID,
+		Date:     expense.Date.Format(basicDateLayout),
+		Name:     expense.Name,
+		Category: expense.Category,
+		Amount:   formatAmount(math.Abs(expense.Amount), format),
+		Income:   expense.Amount > 0,
+	}
+}
+
+// ServeBasicTable lists the expenses of a period, newest first
+func (h *Handler) ServeBasicTable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		http.Error(w, "Failed to retrieve expenses", http.StatusInternalServerError)
+		log.Printf("API ERROR: Failed to retrieve expenses: %v\n", err)
+		return
+	}
+	period, page := h.basicPeriod(r)
+	format := h.basicFormat()
+	totalIncome, totalExpenses, _ := summarizePeriod(h.reportable(expenses), period)
+	page.basicPage = basicPage{Branding: brandingOf(r, h.storage), Title: "Expenses", Page: "table"}
+	page.Spent, page.Income = formatAmount(totalExpenses, format), formatAmount(totalIncome, format)
+	data := basicTablePage{basicPeriodPage: page}
+	for _, expense := range expenses {
+		if period.Contains(expense.Date) {
+			data.Rows = append(data.Rows, toBasicRow(expense, format))
+		}
+	}
+	slices.SortStableFunc(data.Rows, func(a, b basicRow) int {
+		return strings.Compare(b.Date, a.Date)
+	})
+	renderBasic(w, http.StatusOK, "table.html", data)
+}
+
+// ServeBasicSummary shows the totals and category spending of a period, and the recent periods
+func (h *Handler) ServeBasicSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		http.Error(w, "Failed to retrieve expenses", http.StatusInternalServerError)
+		log.Printf("API ERROR: Failed to retrieve expenses: %v\n", err)
+		return
+	}
+	expenses = h.reportable(expenses)
+	period, page := h.basicPeriod(r)
+	format := h.basicFormat()
+	totalIncome, totalExpenses, categoryTotals := summarizePeriod(expenses, period)
+	page.basicPage = basicPage{Branding: brandingOf(r, h.storage), Title: "Summary", Page: "summary"}
+	page.Spent, page.Income = formatAmount(totalExpenses, format), formatAmount(totalIncome, format)
+	data := basicSummaryPage{basicPeriodPage: page}
+	data.Balance = formatAmount(totalIncome-totalExpenses, format)
+	for _, category := range getTopCategories(categoryTotals, totalExpenses, len(categoryTotals)) {
+		data.Categories = append(data.Categories, struct{ Name, Amount, Share string }{
+			category.Name, formatAmount(category.Amount, format), fmt.Sprintf("%.0f%%", category.Percentage),
+		})
+	}
+	history := h.periodConfig().Last(6, period.Start)
+	slices.Reverse(history)
+	for _, p := range history {
+		income, spent, _ := summarizePeriod(expenses, p)
+		data.History = append(data.History, struct{ Name, Date, Spent, Income string }{
+			p.Label(), p.Start.Format(basicDateLayout), formatAmount(spent, format), formatAmount(income, format),
+		})
+	}
+	renderBasic(w, http.StatusOK, "summary.html", data)
+}
+
+// basicFormPage builds the add or edit form, prefilled from an expense
+func (h *Handler) basicFormPage(r *http.Request, id string, expense storage.Expense) (basicFormPage, error) {
+	categories, err := h.storage.GetCategories()
+	if err != nil {
+		return basicFormPage{}, err
+	}
+	if expense.Category != "" && !slices.Contains(categories, expense.Category) {
+		categories = append(categories, expense.Category)
+	}
+	page := basicFormPage{
+		basicPage:  basicPage{Branding: brandingOf(r, h.storage), Title: "Add expense", Page: "edit"},
+		ID:         id,
+		Categories: categories,
+		Form: basicForm{
+			Name:     expense.Name,
+			Category: expense.Category,
+			Income:   expense.Amount > 0,
+			Date:     expense.Date.Format(basicDateLayout),
+			Tags:     strings.Join(expense.Tags, ", "),
+			Notes:    expense.Notes,
+		},
+	}
+	if id != "" {
+		page.Title, page.Page = "Edit expense", ""
+	}
+	if expense.Amount != 0 {
+		page.Form.Amount = strconv.FormatFloat(math.Abs(expense.Amount), 'f', -1, 64)
+	}
+	return page, nil
+}
+
+// ServeBasicEdit shows the form to add an expense, or to edit one with ?id=, and saves it when posted
+func (h *Handler) ServeBasicEdit(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		id := r.URL.Query().Get("id")
+		expense := storage.Expense{Date: time.Now()}
+		if id != "" {
+			var err error
+			if expense, err = h.storage.GetExpense(id); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		page, err := h.basicFormPage(r, id, expense)
+		if err != nil {
+			http.Error(w, "Failed to retrieve categories", http.StatusInternalServerError)
+			log.Printf("API ERROR: Failed to get categories: %v\n", err)
+			return
+		}
+		renderBasic(w, http.StatusOK, "edit.html", page)
+	case http.MethodPost:
+		h.saveBasicForm(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+	}
+}
+
+// saveBasicForm adds or edits the posted expense, and shows the form again with the error if it fails
+func (h *Handler) saveBasicForm(w http.ResponseWriter, r *http.Request) {
+	if !sameOrigin(r) {
+		http.Error(w, "Cross-site form submissions are not allowed", http.StatusForbidden)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	id := r.PostForm.Get("id")
+	var expense storage.Expense
+	if id != "" {
+		var err error
+		if expense, err = h.storage.GetExpense(id); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+	expense.Name = strings.TrimSpace(r.PostForm.Get("name"))
+	expense.Category = r.PostForm.Get("category")
+	expense.Tags = splitAndTrim(r.PostForm.Get("tags"), ",")
+	expense.Notes = strings.TrimSpace(r.PostForm.Get("notes"))
+	var formErr string
+	amount, err := strconv.ParseFloat(r.PostForm.Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		formErr = "amount must be a positive number"
+	}
+	expense.Amount = -amount
+	if r.PostForm.Get("type") == "income" {
+		expense.Amount = amount
+	}
+	date, err := time.Parse(basicDateLayout, r.PostForm.Get("date"))
+	if err != nil {
+		formErr = cmp.Or(formErr, "date must be a valid date")
+	}
+	expense.Date = date
+
+	status := http.StatusBadRequest
+	if formErr == "" {
+		confirm := ""
+		if confirmed, _ := strconv.ParseBool(r.PostForm.Get("confirm")); confirmed {
+			confirm = "confirm=true"
+		}
+		if id == "" {
+			status, formErr = callAPI(r, h.AddExpense, http.MethodPut, "/expense?"+confirm, expense)
+		} else {
+			status, formErr = callAPI(r, h.EditExpense, http.MethodPut, "/expense/edit?id="+url.QueryEscape(id)+"&"+confirm, expense)
+		}
+		if formErr == "" {
+			http.Redirect(w, r, "/basic/table?date="+expense.Date.Format(basicDateLayout), http.StatusSeeOther)
+			return
+		}
+	}
+	page, err := h.basicFormPage(r, id, expense)
+	if err != nil {
+		http.Error(w, "Failed to retrieve categories", http.StatusInternalServerError)
+		log.Printf("API ERROR: Failed to get categories: %v\n", err)
+		return
+	}
+	page.Form.Amount, page.Form.Date = r.PostForm.Get("amount"), r.PostForm.Get("date")
+	page.Error, page.NeedsConfirm = formErr, status == http.StatusConflict
+	renderBasic(w, status, "edit.html", page)
+}
+
+// ServeBasicDelete asks to confirm deleting the expense with ?id=, and deletes it when posted
+func (h *Handler) ServeBasicDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if r.Method == http.MethodPost && !sameOrigin(r) {
+		http.Error(w, "Cross-site form submissions are not allowed", http.StatusForbidden)
+		return
+	}
+	id := r.FormValue("id")
+	expense, err := h.storage.GetExpense(id)
+	if id == "" || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	page := basicDeletePage{
+		basicPage: basicPage{Branding: brandingOf(r, h.storage), Title: "Delete expense"},
+		Row:       toBasicRow(expense, h.basicFormat()),
+	}
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		status, page.Error = callAPI(r, h.DeleteExpense, http.MethodDelete, "/expense/delete?id="+url.QueryEscape(id), nil)
+		if page.Error == "" {
+			http.Redirect(w, r, "/basic/table?date="+page.Row.Date, http.StatusSeeOther)
+			return
+		}
+	}
+	renderBasic(w, status, "delete.html", page)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"cmp"
+	"net/http"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+	"github.com/tanq16/expenseowl/internal/web"
+)
+
+const (
+	defaultInstanceName = "ExpenseOwl"
+	defaultLogoPath     = "/pwa/icon-192.png"
+)
+
+// brandingOf reads the branding from the runtime settings, with the defaults for what's unset, and
+// the CSRF token of the request's browser
+func brandingOf(r *http.Request, s storage.Storage) web.Branding {
+	ui := storage.DefaultRuntimeSettings().UI
+	if settings, err := s.GetRuntimeSettings(); err == nil {
+		ui = settings.UI
+	}
+	currency, err := s.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+	return web.Branding{
+		InstanceName:   cmp.Or(ui.InstanceName, defaultInstanceName),
+		CurrencySymbol: currencyFormat(currency).Symbol,
+		LogoPath:       cmp.Or(ui.LogoPath, defaultLogoPath),
+		FooterText:     ui.FooterText,
+		CSRFToken:      csrfToken(r),
+	}
+}
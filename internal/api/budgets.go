@@ -0,0 +1,133 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// CategoryBudget is the state of one category's budget in a period
+type CategoryBudget struct {
+	Category  string  `json:"category"`
+	Budget    float64 `json:"budget"`             // the configured limit
+	Rollover  string  `json:"rollover,omitempty"` // the category's rollover mode
+	Carried   float64 `json:"carried"`            // brought over from earlier periods, negative for overspending
+	Available float64 `json:"available"`          // budget plus carried
+	Spent     float64 `json:"spent"`
+	Remaining float64 `json:"remaining"` // available minus spent
+}
+
+// BudgetStatusResponse lists the budgets of one period, sorted by category
+type BudgetStatusResponse struct {
+	Period  string           `json:"period"`
+	Start   string           `json:"start"`
+	End     string           `json:"end"` // last day of the period
+	Budgets []CategoryBudget `json:"budgets"`
+}
+
+// GetBudgetStatus returns the budgets of the current period (or the one containing ?date=YYYY-MM-DD)
+// with what was spent and, for categories with a rollover mode, what carried over from earlier periods
+func (h *Handler) GetBudgetStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	date := time.Now()
+	if value := r.URL.Query().Get("date"); value != "" {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'date': expected YYYY-MM-DD"})
+			return
+		}
+		date = parsed
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for budget status: %v\n", err)
+		return
+	}
+	period := h.periodConfig().Containing(date)
+	budgets, err := h.budgetStatus(expenses, period)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get budgets"})
+		log.Printf("API ERROR: Failed to get budgets: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, BudgetStatusResponse{
+		Period:  period.Label(),
+		Start:   period.Start.Format("2006-01-02"),
+		End:     period.End.AddDate(0, 0, -1).Format("2006-01-02"),
+		Budgets: budgets,
+	})
+}
+
+// budgetStatus computes every budget in the period; with a rollover mode, the carried amount is
+// worked out period by period from the category's first expense
+func (h *Handler) budgetStatus(expenses []storage.Expense, period periods.Period) ([]CategoryBudget, error) {
+	budgets, err := h.storage.GetBudgets()
+	if err != nil {
+		return nil, err
+	}
+	meta, err := h.storage.GetCategoryMeta()
+	if err != nil {
+		meta = map[string]storage.CategoryMeta{}
+	}
+	expenses = reportableExpenses(expenses, meta)
+	periodConfig := h.periodConfig()
+
+	// spending per period start and category, up to the end of the period
+	spent := make(map[time.Time]map[string]float64)
+	first := make(map[string]time.Time)
+	for _, expense := range expenses {
+		if expense.Amount >= 0 || !expense.Date.Before(period.End) {
+			continue
+		}
+		start := periodConfig.Containing(expense.Date).Start
+		if spent[start] == nil {
+			spent[start] = make(map[string]float64)
+		}
+		spent[start][expense.Category] += -expense.Amount
+		if earliest, ok := first[expense.Category]; !ok || expense.Date.Before(earliest) {
+			first[expense.Category] = expense.Date
+		}
+	}
+
+	result := make([]CategoryBudget, 0, len(budgets))
+	for category, limit := range budgets {
+		mode := meta[category].Rollover
+		var carried float64
+		if earliest, ok := first[category]; ok && mode != storage.RolloverNone {
+			for p := periodConfig.Containing(earliest); p.Start.Before(period.Start); p = periodConfig.Next(p) {
+				carried = rollover(mode, limit+carried-spent[p.Start][category])
+			}
+		}
+		status := CategoryBudget{
+			Category:  category,
+			Budget:    limit,
+			Rollover:  mode,
+			Carried:   carried,
+			Available: limit + carried,
+			Spent:     spent[period.Start][category],
+		}
+		status.Remaining = status.Available - status.Spent
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Category < result[j].Category })
+	return result, nil
+}
+
+// rollover is what a period's leftover (negative when overspent) carries into the next one
+func rollover(mode string, left float64) float64 {
+	switch {
+	case left > 0 && (mode == storage.RolloverUnused || mode == storage.RolloverBoth):
+		return left
+	case left < 0 && (mode == storage.RolloverOverspend || mode == storage.RolloverBoth):
+		return left
+	}
+	return 0
+}
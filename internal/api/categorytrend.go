@@ -0,0 +1,139 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/periods"
+)
+
+// The category trend follows one category's spending month by month, smoothed with a moving
+// average and put against the same month a year earlier, for charts that look past a single
+// unusual month.
+
+// movingAverageMonths is how many months, up to and including each one, the moving average spans
+const movingAverageMonths = 3
+
+// CategoryTrend is the response of /api/reports/category-trend
+type CategoryTrend struct {
+	Category    string               `json:"category"`
+	Currency    string               `json:"currency"`
+	Months      []CategoryTrendMonth `json:"months"` // oldest first, ending with the current month
+	Total       float64              `json:"total"`
+	Average     float64              `json:"average"`     // spent per month
+	Seasonality []SeasonalMonth      `json:"seasonality"` // January first
+}
+
+// CategoryTrendMonth is the spending in the category in one month
+type CategoryTrendMonth struct {
+	Month         string   `json:"month"` // e.g., "Jan 2026"
+	Start         string   `json:"start"` // YYYY-MM-DD
+	Spent         float64  `json:"spent"`
+	MovingAverage float64  `json:"movingAverage"`    // over this and the 2 months before it
+	LastYear      float64  `json:"lastYear"`         // spent in the same month a year earlier
+	Change        *float64 `json:"change,omitempty"` // percentage over last year, omitted without spending then
+}
+
+// SeasonalMonth compares a calendar month to the average month of the trend
+type SeasonalMonth struct {
+	Month   string  `json:"month"`   // e.g., "Jan"
+	Average float64 `json:"average"` // spent in this calendar month, averaged over the years shown
+	Index   float64 `json:"index"`   // average over the monthly average, e.g., 1.5 for 50% above; 0 when not shown
+}
+
+// buildCategoryTrend works out the trend of the last n of the monthly periods, which have to
+// reach 12 months further back for the year-over-year comparison
+func buildCategoryTrend(summarize summarizer, category string, periodList []periods.Period, n int) CategoryTrend {
+	round := func(value float64) float64 { return math.Round(value*100) / 100 }
+	spent := make([]float64, len(periodList))
+	for i, period := range periodList {
+		_, _, categoryTotals := summarize(period)
+		spent[i] = categoryTotals[category]
+	}
+
+	trend := CategoryTrend{Category: category, Months: make([]CategoryTrendMonth, 0, n)}
+	var seasonTotals [12]float64
+	var seasonCounts [12]int
+	for i := len(periodList) - n; i < len(periodList); i++ {
+		month := CategoryTrendMonth{
+			Month: periodList[i].ShortLabel(),
+			Start: periodList[i].Start.Format("2006-01-02"),
+			Spent: round(spent[i]),
+		}
+		var window float64
+		for j := i - movingAverageMonths + 1; j <= i; j++ {
+			window += spent[j]
+		}
+		month.MovingAverage = round(window / movingAverageMonths)
+		if lastYear := spent[i-12]; lastYear > 0 {
+			month.LastYear = round(lastYear)
+			change := math.Round((spent[i]-lastYear)/lastYear*1000) / 10
+			month.Change = &change
+		}
+		trend.Months = append(trend.Months, month)
+		trend.Total += spent[i]
+		// periods starting late in a month mostly fall in the next one
+		calendarMonth := periodList[i].Start.AddDate(0, 0, 15).Month() - 1
+		seasonTotals[calendarMonth] += spent[i]
+		seasonCounts[calendarMonth]++
+	}
+	trend.Average = round(trend.Total / float64(n))
+	trend.Total = round(trend.Total)
+
+	trend.Seasonality = make([]SeasonalMonth, 12)
+	for i := range trend.Seasonality {
+		season := SeasonalMonth{Month: time.Month(i + 1).String()[:3]}
+		if seasonCounts[i] > 0 {
+			season.Average = round(seasonTotals[i] / float64(seasonCounts[i]))
+			if trend.Average > 0 {
+				season.Index = math.Round(season.Average/trend.Average*100) / 100
+			}
+		}
+		trend.Seasonality[i] = season
+	}
+	return trend
+}
+
+// GetCategoryTrend returns the monthly spending in ?category= over the last ?months= months
+// (default 12); months follow the budget period when it is monthly, and calendar months otherwise
+func (h *Handler) GetCategoryTrend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	query := r.URL.Query()
+	category := query.Get("category")
+	if category == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "category is required"})
+		return
+	}
+	months := 12
+	if value := query.Get("months"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 120 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'months': must be between 1 and 120"})
+			return
+		}
+		months = parsed
+	}
+
+	config := h.periodConfig()
+	if config.Type != periods.Monthly && config.Type != "" {
+		config = periods.Default()
+	}
+	periodList := config.Last(months+12, time.Now())
+	summarize, err := h.summarizerFor(periodList, []string{category})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for category trend: %v\n", err)
+		return
+	}
+	trend := buildCategoryTrend(summarize, category, periodList, months)
+	if trend.Currency, err = h.storage.GetCurrency(); err != nil {
+		trend.Currency = "usd"
+	}
+	writeJSON(w, http.StatusOK, trend)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// The change feed lists what was created, updated, or deleted since a cursor, for expenses and
+// config records (a top-level field of the config, such as "categories" or "budgets") alike.
+// Stores number every change from one counter, so the cursor is the latest revision a client
+// has seen, the same as the revision of /api/sync/pull.
+
+// ChangeFeed is a page of the changes after ?since=
+type ChangeFeed struct {
+	Cursor  int64    `json:"cursor"`  // pass as ?since= on the next request
+	HasMore bool     `json:"hasMore"` // request again right away for the rest
+	Changes []Change `json:"changes"` // oldest first
+}
+
+// Change is the latest change of an expense or config record
+type Change struct {
+	Type      string     `json:"type"`   // expense or config
+	ID        string     `json:"id"`     // expense ID or config key
+	Action    string     `json:"action"` // created, updated, or deleted since the cursor
+	Revision  int64      `json:"revision"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	Data      any        `json:"data,omitempty"` // the current expense or config value, left out once deleted
+}
+
+// redactedConfigRecords are left out of the change feed, as redactConfig leaves them out of the config
+var redactedConfigRecords = []string{"shares", "savedReports", "walletDevices", "preferences"}
+
+// changeAction tells whether a record was created, updated, or deleted after since
+func changeAction(since, created int64, deletedAt *time.Time) string {
+	switch {
+	case deletedAt != nil:
+		return "deleted"
+	case created > since:
+		return "created"
+	default:
+		return "updated"
+	}
+}
+
+// GetChanges returns the expense and config changes after the cursor in ?since=; ?limit= bounds
+// the expenses, while the config changes up to the returned cursor are always included
+func (h *Handler) GetChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var since int64
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		var err error
+		if since, err = strconv.ParseInt(raw, 10, 64); err != nil || since < 0 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "'since' must be a cursor returned by this endpoint"})
+			return
+		}
+	}
+	limit := maxPerPage
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > maxPerPage {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("'limit' must be between 1 and %d", maxPerPage)})
+			return
+		}
+	}
+	expenseChanges, latest, err := h.storage.GetExpenseChanges(since, limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve changes"})
+		log.Printf("API ERROR: Failed to retrieve expense changes: %v\n", err)
+		return
+	}
+	configChanges, err := h.storage.GetConfigChanges(since)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve changes"})
+		log.Printf("API ERROR: Failed to retrieve config changes: %v\n", err)
+		return
+	}
+	feed := ChangeFeed{Cursor: max(since, latest), Changes: []Change{}}
+	if n := len(expenseChanges); n == limit && expenseChanges[n-1].Revision < latest {
+		feed.Cursor, feed.HasMore = expenseChanges[n-1].Revision, true
+	}
+	for _, change := range expenseChanges {
+		record := Change{Type: "expense", ID: change.ID, Action: changeAction(since, change.CreatedRevision, change.DeletedAt), Revision: change.Revision, UpdatedAt: change.UpdatedAt, DeletedAt: change.DeletedAt}
+		if change.Expense != nil {
+			record.Data = change.Expense
+		}
+		feed.Changes = append(feed.Changes, record)
+	}
+	if len(configChanges) > 0 {
+		values, err := h.configRecords()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get config"})
+			log.Printf("API ERROR: Failed to get config for changes: %v\n", err)
+			return
+		}
+		for _, change := range configChanges {
+			if change.Revision > feed.Cursor {
+				break
+			}
+			if slices.Contains(redactedConfigRecords, change.Key) {
+				continue
+			}
+			record := Change{Type: "config", ID: change.Key, Action: changeAction(since, change.CreatedRevision, change.DeletedAt), Revision: change.Revision, UpdatedAt: change.UpdatedAt, DeletedAt: change.DeletedAt}
+			if change.DeletedAt == nil {
+				record.Data = values[change.Key]
+			}
+			feed.Changes = append(feed.Changes, record)
+		}
+	}
+	slices.SortStableFunc(feed.Changes, func(a, b Change) int {
+		return cmp.Compare(a.Revision, b.Revision)
+	})
+	writeJSON(w, http.StatusOK, feed)
+}
+
+// configRecords returns the current config by record, redacted like GetConfig
+func (h *Handler) configRecords() (map[string]json.RawMessage, error) {
+	config, err := h.storage.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	redactConfig(config)
+	content, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var records map[string]json.RawMessage
+	err = json.Unmarshal(content, &records)
+	return records, err
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"mime"
+	"net/http"
+)
+
+// A signed-in browser sends its session cookie with every request, including ones another site
+// makes it send, so writes with the cookie also need a token that only our pages can read. The
+// token is kept in its own cookie and rendered into the pages, which send it back in the
+// X-CSRF-Token header, or the csrf_token field of a form. Scripts and webhooks without the session
+// cookie don't need it.
+
+const (
+	csrfCookie = "expenseowl_csrf"
+	csrfHeader = "X-CSRF-Token"
+	csrfField  = "csrf_token"
+)
+
+type csrfContextKey struct{}
+
+// csrfToken returns the CSRF token of the request's browser, for rendering into a page
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+// CheckCSRF issues the CSRF token cookie to browsers without one, and rejects writes from other
+// sites, and writes with a session cookie but without a matching token, with a 403
+func CheckCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if cookie, err := r.Cookie(csrfCookie); err == nil && len(cookie.Value) == 64 {
+			token = cookie.Value
+		}
+		if token == "" {
+			b := make([]byte, 32)
+			rand.Read(b)
+			token = hex.EncodeToString(b)
+			http.SetCookie(w, &http.Cookie{
+				Name:     csrfCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token))
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !sameOrigin(r) || r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "Cross-site requests are not allowed", Code: CodeCSRFFailed})
+			return
+		}
+		if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+			if subtle.ConstantTimeCompare([]byte(sentCSRFToken(r)), []byte(token)) != 1 {
+				writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "Missing or invalid CSRF token, reload the page", Code: CodeCSRFFailed})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sentCSRFToken is the token in the header, or in the form of a form post
+func sentCSRFToken(r *http.Request) string {
+	if token := r.Header.Get(csrfHeader); token != "" {
+		return token
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data" {
+		return r.FormValue(csrfField)
+	}
+	return ""
+}
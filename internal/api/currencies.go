@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// GetCurrencies lists the ISO 4217 currencies followed by the custom ones
+func (h *Handler) GetCurrencies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	custom, err := h.storage.GetCustomCurrencies()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get custom currencies"})
+		log.Printf("API ERROR: Failed to get custom currencies: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, storage.SupportedCurrencies(custom))
+}
+
+// UpdateCustomCurrencies replaces the custom currencies; the display currency can't be removed
+func (h *Handler) UpdateCustomCurrencies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var currencies []storage.Currency
+	if err := json.NewDecoder(r.Body).Decode(&currencies); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if currencies == nil {
+		currencies = []storage.Currency{}
+	}
+	if err := storage.ValidateCustomCurrencies(currencies); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get currency"})
+		log.Printf("API ERROR: Failed to get currency: %v\n", err)
+		return
+	}
+	if _, ok := storage.LookupCurrency(currency, currencies); !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("'%s' is the display currency and can't be removed", currency)})
+		return
+	}
+	if err := h.storage.UpdateCustomCurrencies(currencies); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update custom currencies"})
+		log.Printf("API ERROR: Failed to update custom currencies: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// checkCurrency normalizes an expense's currency and writes a 400 if it's neither ISO 4217 nor custom;
+// an empty currency is left for the store to fill in with the configured one
+func (h *Handler) checkCurrency(w http.ResponseWriter, expense *storage.Expense) bool {
+	if expense.Currency == "" {
+		return true
+	}
+	expense.Currency = strings.ToLower(strings.TrimSpace(expense.Currency))
+	custom, err := h.storage.GetCustomCurrencies()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get custom currencies"})
+		log.Printf("API ERROR: Failed to get custom currencies: %v\n", err)
+		return false
+	}
+	if _, ok := storage.LookupCurrency(expense.Currency, custom); !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("unsupported currency '%s'", expense.Currency)})
+		return false
+	}
+	return true
+}
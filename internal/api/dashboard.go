@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Each user arranges their own dashboard: a grid of widgets kept with their preferences, which the
+// dashboard page lays out in order. Users who haven't arranged one get the default layout.
+
+// DashboardLayoutResponse is the layout of the requesting user, along with the widgets to pick from
+type DashboardLayoutResponse struct {
+	storage.DashboardLayout
+	Custom    bool     `json:"custom"`    // false for the default layout
+	Available []string `json:"available"` // widget types
+}
+
+// DashboardLayout returns (GET), replaces (PUT), or resets to the default (DELETE) the dashboard
+// layout of the requesting user
+func (h *Handler) DashboardLayout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	user := requestUser(r)
+	preferences, err := h.storage.GetPreferences(user)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get preferences"})
+		log.Printf("API ERROR: Failed to get preferences: %v\n", err)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var layout storage.DashboardLayout
+		if err := json.NewDecoder(r.Body).Decode(&layout); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+			return
+		}
+		if err := storage.ValidateDashboardLayout(&layout); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+		preferences.Dashboard = &layout
+	case http.MethodDelete:
+		preferences.Dashboard = nil
+	}
+	if r.Method != http.MethodGet {
+		if err := h.storage.UpdatePreferences(user, preferences); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save dashboard layout"})
+			log.Printf("API ERROR: Failed to save dashboard layout: %v\n", err)
+			return
+		}
+	}
+	response := DashboardLayoutResponse{DashboardLayout: storage.DefaultDashboardLayout(), Available: storage.DashboardWidgets}
+	if preferences.Dashboard != nil {
+		response.DashboardLayout, response.Custom = *preferences.Dashboard, true
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// InboundEmail is the normalized form of a forwarded receipt email
+type InboundEmail struct {
+	From        string   `json:"from"`
+	Subject     string   `json:"subject"`
+	Text        string   `json:"text"`
+	Date        string   `json:"date"`
+	Attachments []string `json:"attachments"` // attachment file names, recorded in the expense's metadata
+}
+
+// receiptParser extracts the total from a known sender's receipts
+type receiptParser struct {
+	name          string         // merchant name used for the expense
+	senderPattern *regexp.Regexp // matched against the sender address
+	totalPattern  *regexp.Regexp // first capture group is the amount
+}
+
+var receiptParsers = []receiptParser{
+	{
+		name:          "Amazon",
+		senderPattern: regexp.MustCompile(`(?i)amazon\.`),
+		totalPattern:  regexp.MustCompile(`(?i)(?:order|grand)\s+total:?\s*[^\d\s]{0,3}\s*([\d,]+\.\d{2})`),
+	},
+	{
+		name:          "Uber",
+		senderPattern: regexp.MustCompile(`(?i)uber\.com`),
+		totalPattern:  regexp.MustCompile(`(?i)total\s*[^\d\s]{0,3}\s*([\d,]+\.\d{2})`),
+	},
+}
+
+// generic patterns for utility bills and other receipts, tried in order
+var genericTotalPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(?:amount|balance)\s+due:?\s*[^\d\s]{0,3}\s*([\d,]+\.\d{2})`),
+	regexp.MustCompile(`(?i)(?:grand\s+)?total(?:\s+charged|\s+paid)?:?\s*[^\d\s]{0,3}\s*([\d,]+\.\d{2})`),
+	regexp.MustCompile(`(?i)amount\s+(?:charged|paid):?\s*[^\d\s]{0,3}\s*([\d,]+\.\d{2})`),
+}
+
+// IngestEmail creates an expense from a forwarded receipt.
+// Accepts a Mailgun inbound route (form fields) or a JSON InboundEmail body.
+func (h *Handler) IngestEmail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if !validToken(r, "X-Ingest-Token", h.emailIngestToken) {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid or missing ingest token"})
+		return
+	}
+
+	var email InboundEmail
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&email); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+			return
+		}
+	} else {
+		if err := r.ParseMultipartForm(10 << 20); err != nil && err != http.ErrNotMultipart {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Could not parse form"})
+			return
+		}
+		if r.Form == nil {
+			r.ParseForm()
+		}
+		email = InboundEmail{
+			From:    r.FormValue("sender"),
+			Subject: r.FormValue("subject"),
+			Text:    r.FormValue("body-plain"),
+			Date:    r.FormValue("Date"),
+		}
+		if email.From == "" {
+			email.From = r.FormValue("from")
+		}
+		if r.MultipartForm != nil {
+			for _, files := range r.MultipartForm.File {
+				for _, file := range files {
+					email.Attachments = append(email.Attachments, file.Filename)
+				}
+			}
+		}
+	}
+
+	expense, err := h.expenseFromEmail(email)
+	if err != nil {
+		log.Printf("API ERROR: Could not parse receipt email from %s: %v\n", email.From, err)
+		writeJSON(w, http.StatusUnprocessableEntity, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.AddExpense(expense); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save expense"})
+		log.Printf("API ERROR: Failed to save expense from email: %v\n", err)
+		return
+	}
+	h.notifyExpenseAdded(expense)
+	writeJSON(w, http.StatusOK, expense)
+	log.Printf("HTTP: Ingested receipt email from %s\n", email.From)
+}
+
+// expenseFromEmail parses the receipt total and merchant and applies mapping rules
+func (h *Handler) expenseFromEmail(email InboundEmail) (storage.Expense, error) {
+	name, amount, err := parseReceipt(email)
+	if err != nil {
+		return storage.Expense{}, err
+	}
+	date := time.Now()
+	if email.Date != "" {
+		if d, err := mail.ParseDate(email.Date); err == nil {
+			date = d.UTC()
+		}
+	}
+	categories, err := h.storage.GetCategories()
+	if err != nil {
+		return storage.Expense{}, fmt.Errorf("failed to retrieve categories")
+	}
+	expense := storage.Expense{
+		Name:   name,
+		Amount: -amount,
+		Date:   date,
+		Tags:   []string{"email"},
+	}
+	if attachments := emailAttachments(email.Attachments); attachments != "" {
+		expense.Metadata = storage.Metadata{"email": {"attachments": attachments}}
+	}
+	h.resolveCategory(&expense, categories)
+	if err := expense.Validate(); err != nil {
+		return storage.Expense{}, err
+	}
+	return expense, nil
+}
+
+// emailAttachments lists attachment file names for the expense's metadata, leaving out the ones
+// past the metadata length limit
+func emailAttachments(names []string) string {
+	var list string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		next := name
+		if list != "" {
+			next = list + ", " + name
+		}
+		if utf8.RuneCountInString(next) > storage.MaxMetadataValueLength {
+			break
+		}
+		list = next
+	}
+	return list
+}
+
+// parseReceipt returns the merchant name and total amount of a receipt email
+func parseReceipt(email InboundEmail) (string, float64, error) {
+	sender := email.From
+	if addr, err := mail.ParseAddress(email.From); err == nil {
+		sender = addr.Address
+	}
+	body := email.Subject + "\n" + email.Text
+
+	for _, parser := range receiptParsers {
+		if !parser.senderPattern.MatchString(sender) && !parser.senderPattern.MatchString(body) {
+			continue
+		}
+		if amount, ok := matchAmount(parser.totalPattern, body); ok {
+			return parser.name, amount, nil
+		}
+	}
+	for _, pattern := range genericTotalPatterns {
+		if amount, ok := matchAmount(pattern, body); ok {
+			return receiptMerchantName(email.Subject, sender), amount, nil
+		}
+	}
+	return "", 0, fmt.Errorf("no receipt total found in email")
+}
+
+func matchAmount(pattern *regexp.Regexp, text string) (float64, bool) {
+	match := pattern.FindStringSubmatch(text)
+	if match == nil {
+		return 0, false
+	}
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(match[1], ",", ""), 64)
+	if err != nil || amount <= 0 {
+		return 0, false
+	}
+	return amount, true
+}
+
+var reForwardPrefix = regexp.MustCompile(`(?i)^((fwd?|re):\s*)+`)
+
+// receiptMerchantName uses the subject (without forwarding prefixes), or the sender domain
+func receiptMerchantName(subject string, sender string) string {
+	name := strings.TrimSpace(reForwardPrefix.ReplaceAllString(subject, ""))
+	if name != "" {
+		return name
+	}
+	if _, domain, ok := strings.Cut(sender, "@"); ok {
+		return domain
+	}
+	return "Email receipt"
+}
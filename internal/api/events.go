@@ -0,0 +1,312 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Event types sent on the event stream
+const (
+	EventExpenses = "expenses" // expenses or recurring expenses changed
+	EventConfig   = "config"   // categories, currency, budgets, or other settings changed
+)
+
+// Event notifies clients that data changed, so they can refetch it
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+}
+
+const eventHeartbeat = 25 * time.Second
+
+// eventBroker fans events out to connected stream clients
+type eventBroker struct {
+	mu        sync.Mutex
+	clients   map[chan Event]struct{}
+	done      chan struct{} // closed on shutdown to end the streams
+	closeOnce sync.Once
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{clients: make(map[chan Event]struct{}), done: make(chan struct{})}
+}
+
+func (b *eventBroker) close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
+
+func (b *eventBroker) subscribe() chan Event {
+	ch := make(chan Event, 16)
+	b.mu.Lock()
+	b.clients[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	delete(b.clients, ch)
+	b.mu.Unlock()
+}
+
+// publish never blocks; slow clients drop events and catch up on the next one
+func (b *eventBroker) publish(eventType string) {
+	event := Event{Type: eventType, Time: time.Now().UTC()}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.clients {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// StreamEvents serves server-sent events for live updates of the dashboard and table views
+func (h *Handler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Streaming not supported"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering (nginx)
+	// the stream stays open, past the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	events := h.events.subscribe()
+	defer h.events.unsubscribe(events)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.events.done:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("API ERROR: Failed to marshal event: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
+
+// CloseEvents ends the open event streams, which would otherwise hold up a graceful shutdown;
+// clients reconnect to the next instance on their own
+func (h *Handler) CloseEvents() {
+	h.events.close()
+}
+
+// eventStorage wraps a Storage and publishes an event after each successful change, so updates
+// from the API, imports, email, and Telegram all reach connected clients
+type eventStorage struct {
+	storage.Storage
+	events *eventBroker
+}
+
+func (s *eventStorage) notify(eventType string, err error) error {
+	if err == nil {
+		s.events.publish(eventType)
+	}
+	return err
+}
+
+// Config changes
+
+func (s *eventStorage) UpdateCategories(categories []string) error {
+	return s.notify(EventConfig, s.Storage.UpdateCategories(categories))
+}
+
+func (s *eventStorage) RenameCategory(oldName string, newName string) error {
+	return s.notify(EventConfig, s.Storage.RenameCategory(oldName, newName))
+}
+
+func (s *eventStorage) MergeCategories(source string, target string) error {
+	return s.notify(EventConfig, s.Storage.MergeCategories(source, target))
+}
+
+func (s *eventStorage) ArchiveCategory(category string, archived bool) error {
+	return s.notify(EventConfig, s.Storage.ArchiveCategory(category, archived))
+}
+
+func (s *eventStorage) UpdateCategoryMeta(meta map[string]storage.CategoryMeta) error {
+	return s.notify(EventConfig, s.Storage.UpdateCategoryMeta(meta))
+}
+
+func (s *eventStorage) UpdateCurrency(currency string) error {
+	return s.notify(EventConfig, s.Storage.UpdateCurrency(currency))
+}
+
+func (s *eventStorage) UpdateCustomCurrencies(currencies []storage.Currency) error {
+	return s.notify(EventConfig, s.Storage.UpdateCustomCurrencies(currencies))
+}
+
+func (s *eventStorage) UpdateStartDate(startDate int) error {
+	return s.notify(EventConfig, s.Storage.UpdateStartDate(startDate))
+}
+
+func (s *eventStorage) UpdateBudgets(budgets map[string]float64) error {
+	return s.notify(EventConfig, s.Storage.UpdateBudgets(budgets))
+}
+
+func (s *eventStorage) UpdatePeriod(period periods.Config) error {
+	return s.notify(EventConfig, s.Storage.UpdatePeriod(period))
+}
+
+func (s *eventStorage) UpdateReportSettings(settings storage.ReportSettings) error {
+	return s.notify(EventConfig, s.Storage.UpdateReportSettings(settings))
+}
+
+func (s *eventStorage) UpdateMerchantAliases(aliases map[string]string) error {
+	return s.notify(EventConfig, s.Storage.UpdateMerchantAliases(aliases))
+}
+
+func (s *eventStorage) UpdateRuntimeSettings(settings storage.RuntimeSettings) error {
+	return s.notify(EventConfig, s.Storage.UpdateRuntimeSettings(settings))
+}
+
+func (s *eventStorage) UpdatePreferences(user string, preferences storage.Preferences) error {
+	return s.notify(EventConfig, s.Storage.UpdatePreferences(user, preferences))
+}
+
+func (s *eventStorage) UpdateAccounts(accounts []storage.Account) error {
+	return s.notify(EventConfig, s.Storage.UpdateAccounts(accounts))
+}
+
+func (s *eventStorage) UpdateHoldings(holdings []storage.Holding) error {
+	return s.notify(EventConfig, s.Storage.UpdateHoldings(holdings))
+}
+
+func (s *eventStorage) UpdatePlannedExpenses(planned []storage.PlannedExpense) error {
+	return s.notify(EventConfig, s.Storage.UpdatePlannedExpenses(planned))
+}
+
+func (s *eventStorage) UpdateSavedReports(reports []storage.SavedReport) error {
+	return s.notify(EventConfig, s.Storage.UpdateSavedReports(reports))
+}
+
+func (s *eventStorage) UpdateTravelRates(rates storage.TravelRates) error {
+	return s.notify(EventConfig, s.Storage.UpdateTravelRates(rates))
+}
+
+func (s *eventStorage) UpdateFiscalYearStart(month int) error {
+	return s.notify(EventConfig, s.Storage.UpdateFiscalYearStart(month))
+}
+
+func (s *eventStorage) UpdatePlaidItems(items []storage.PlaidItem) error {
+	return s.notify(EventConfig, s.Storage.UpdatePlaidItems(items))
+}
+
+func (s *eventStorage) UpdateProjects(projects []storage.Project) error {
+	return s.notify(EventConfig, s.Storage.UpdateProjects(projects))
+}
+
+func (s *eventStorage) UpdateTRMNLProfiles(profiles []storage.TRMNLProfile) error {
+	return s.notify(EventConfig, s.Storage.UpdateTRMNLProfiles(profiles))
+}
+
+func (s *eventStorage) UpdateVoiceKeys(keys []storage.VoiceKey) error {
+	return s.notify(EventConfig, s.Storage.UpdateVoiceKeys(keys))
+}
+
+func (s *eventStorage) UpdateWalletDevices(devices []storage.WalletDevice) error {
+	return s.notify(EventConfig, s.Storage.UpdateWalletDevices(devices))
+}
+
+func (s *eventStorage) UpdateShares(shares []storage.Share) error {
+	return s.notify(EventConfig, s.Storage.UpdateShares(shares))
+}
+
+func (s *eventStorage) UpdateRetentionYears(years int) error {
+	return s.notify(EventConfig, s.Storage.UpdateRetentionYears(years))
+}
+
+func (s *eventStorage) AddSubCategory(category string, subCategory string) error {
+	return s.notify(EventConfig, s.Storage.AddSubCategory(category, subCategory))
+}
+
+func (s *eventStorage) RemoveSubCategory(category string, subCategory string) error {
+	return s.notify(EventConfig, s.Storage.RemoveSubCategory(category, subCategory))
+}
+
+func (s *eventStorage) RenameSubCategory(category string, oldName string, newName string) error {
+	return s.notify(EventConfig, s.Storage.RenameSubCategory(category, oldName, newName))
+}
+
+func (s *eventStorage) MergeSubCategory(category string, source string, target string) error {
+	return s.notify(EventConfig, s.Storage.MergeSubCategory(category, source, target))
+}
+
+func (s *eventStorage) UpdateSubCategoryMappings(rules []storage.SubCategoryMappingRule) error {
+	return s.notify(EventConfig, s.Storage.UpdateSubCategoryMappings(rules))
+}
+
+// Expense changes
+
+func (s *eventStorage) AddRecurringExpense(recurringExpense storage.RecurringExpense) error {
+	return s.notify(EventExpenses, s.Storage.AddRecurringExpense(recurringExpense))
+}
+
+func (s *eventStorage) RemoveRecurringExpense(id string, removeAll bool) error {
+	return s.notify(EventExpenses, s.Storage.RemoveRecurringExpense(id, removeAll))
+}
+
+func (s *eventStorage) UpdateRecurringExpense(id string, recurringExpense storage.RecurringExpense, updateAll bool) error {
+	return s.notify(EventExpenses, s.Storage.UpdateRecurringExpense(id, recurringExpense, updateAll))
+}
+
+func (s *eventStorage) AddExpense(expense storage.Expense) error {
+	return s.notify(EventExpenses, s.Storage.AddExpense(expense))
+}
+
+func (s *eventStorage) RemoveExpense(id string) error {
+	return s.notify(EventExpenses, s.Storage.RemoveExpense(id))
+}
+
+func (s *eventStorage) AddMultipleExpenses(expenses []storage.Expense) error {
+	return s.notify(EventExpenses, s.Storage.AddMultipleExpenses(expenses))
+}
+
+func (s *eventStorage) RemoveMultipleExpenses(ids []string) error {
+	return s.notify(EventExpenses, s.Storage.RemoveMultipleExpenses(ids))
+}
+
+func (s *eventStorage) UpdateExpense(id string, expense storage.Expense) error {
+	return s.notify(EventExpenses, s.Storage.UpdateExpense(id, expense))
+}
+
+func (s *eventStorage) ArchiveExpenses(before time.Time) (int, error) {
+	archived, err := s.Storage.ArchiveExpenses(before)
+	if archived == 0 {
+		return archived, err
+	}
+	return archived, s.notify(EventExpenses, err)
+}
+
+func (s *eventStorage) MoveExpenses(ids []string, category string, subCategory string) error {
+	return s.notify(EventExpenses, s.Storage.MoveExpenses(ids, category, subCategory))
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/analytics"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+const defaultFeedLimit = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID       string         `xml:"id"`
+	Title    string         `xml:"title"`
+	Updated  string         `xml:"updated"`
+	Summary  string         `xml:"summary"`
+	Category []atomCategory `xml:"category"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// GetFeed serves the latest expenses as an Atom feed, protected by the feed token
+func (h *Handler) GetFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if !validToken(r, "X-Feed-Token", h.feedToken) {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid or missing feed token"})
+		return
+	}
+	limit := defaultFeedLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for feed: %v\n", err)
+		return
+	}
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+
+	feed := buildAtomFeed(expenses, currency, limit, time.Now())
+	feed.Link = atomLink{Href: r.URL.Path, Rel: "self"}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		log.Printf("API ERROR: Failed to write feed: %v\n", err)
+		return
+	}
+	log.Println("HTTP: Served Atom feed")
+}
+
+// buildAtomFeed lists the most recent expenses (by date, excluding future ones) as feed entries
+func buildAtomFeed(expenses []storage.Expense, currency string, limit int, now time.Time) atomFeed {
+	recent := make([]storage.Expense, 0, len(expenses))
+	for _, expense := range expenses {
+		// recurring instances are stored ahead of time; only list what has happened
+		if !expense.Date.After(now) {
+			recent = append(recent, expense)
+		}
+	}
+	recent = analytics.TopN(recent, limit, func(a, b storage.Expense) int {
+		return cmp.Or(b.Date.Compare(a.Date), cmp.Compare(a.ID, b.ID))
+	})
+
+	feed := atomFeed{
+		ID:      "urn:expenseowl:feed",
+		Title:   "ExpenseOwl - Recent Transactions",
+		Updated: now.UTC().Format(time.RFC3339),
+	}
+	if len(recent) > 0 {
+		feed.Updated = recent[0].Date.UTC().Format(time.RFC3339)
+	}
+	for _, expense := range recent {
+		kind := "Expense"
+		if expense.Amount >= 0 {
+			kind = "Income"
+		}
+		category := expense.Category
+		if expense.SubCategory != "" {
+			category += " / " + expense.SubCategory
+		}
+		summary := fmt.Sprintf("%s of %.2f %s in %s on %s", kind, expense.Amount, strings.ToUpper(currency), category, expense.Date.Format("2006-01-02"))
+		if len(expense.Tags) > 0 {
+			summary += " (tags: " + strings.Join(expense.Tags, ", ") + ")"
+		}
+		entry := atomEntry{
+			ID:       "urn:expenseowl:expense:" + expense.ID,
+			Title:    fmt.Sprintf("%s: %.2f", expense.Name, expense.Amount),
+			Updated:  expense.Date.UTC().Format(time.RFC3339),
+			Summary:  summary,
+			Category: []atomCategory{{Term: expense.Category}},
+		}
+		for _, tag := range expense.Tags {
+			entry.Category = append(entry.Category, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
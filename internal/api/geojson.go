@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GeoJSON types for the spending map, see RFC 7946
+
+// GeoJSONFeatureCollection is the response of /api/expenses/geojson
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"` // always "FeatureCollection"
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// GeoJSONFeature is one expense with coordinates
+type GeoJSONFeature struct {
+	Type       string            `json:"type"` // always "Feature"
+	Geometry   GeoJSONPoint      `json:"geometry"`
+	Properties GeoJSONProperties `json:"properties"`
+}
+
+// GeoJSONPoint holds coordinates as [longitude, latitude]
+type GeoJSONPoint struct {
+	Type        string     `json:"type"` // always "Point"
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+// GeoJSONProperties describe the expense at a point
+type GeoJSONProperties struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Category    string  `json:"category"`
+	SubCategory string  `json:"subcategory,omitempty"`
+	Amount      float64 `json:"amount"` // negative for expenses, as stored
+	Currency    string  `json:"currency"`
+	Date        string  `json:"date"` // RFC 3339
+	Place       string  `json:"place,omitempty"`
+}
+
+// GetExpensesGeoJSON returns expenses with coordinates as GeoJSON points for rendering a spending map
+func (h *Handler) GetExpensesGeoJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	query := r.URL.Query()
+	start, end, err := h.dateRange(query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	includeIncome := false
+	if raw := query.Get("income"); raw != "" {
+		if includeIncome, err = strconv.ParseBool(raw); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'income': must be true or false"})
+			return
+		}
+	}
+	categories := splitAndTrim(query.Get("categories"), ",")
+
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for GeoJSON: %v\n", err)
+		return
+	}
+	expenses = filterExpensesByCategories(expenses, categories)
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+
+	collection := GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []GeoJSONFeature{}}
+	for _, expense := range expenses {
+		if !expense.Location.HasCoordinates() || (expense.Amount > 0 && !includeIncome) {
+			continue
+		}
+		if expense.Date.Before(start) || !expense.Date.Before(end) {
+			continue
+		}
+		expenseCurrency := expense.Currency
+		if expenseCurrency == "" {
+			expenseCurrency = currency
+		}
+		collection.Features = append(collection.Features, GeoJSONFeature{
+			Type: "Feature",
+			Geometry: GeoJSONPoint{
+				Type:        "Point",
+				Coordinates: [2]float64{*expense.Location.Longitude, *expense.Location.Latitude},
+			},
+			Properties: GeoJSONProperties{
+				ID:          expense.ID,
+				Name:        expense.Name,
+				Category:    expense.Category,
+				SubCategory: expense.SubCategory,
+				Amount:      expense.Amount,
+				Currency:    strings.ToLower(expenseCurrency),
+				Date:        expense.Date.Format(time.RFC3339),
+				Place:       expense.Location.Place,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(collection); err != nil {
+		log.Printf("API ERROR: Failed to write GeoJSON: %v\n", err)
+	}
+	log.Printf("HTTP: Served expense GeoJSON (%d points)\n", len(collection.Features))
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// The Grafana JSON datasource (https://grafana.com/grafana/plugins/simpod-json-datasource/) is
+// pointed at /api/grafana and reads spending as time series. Metrics are "spent", "income",
+// "category:<name>", and "tag:<name>"; spending counts expenses only, like the dashboard.
+
+const (
+	metricSpent          = "spent"
+	metricIncome         = "income"
+	metricCategoryPrefix = "category:"
+	metricTagPrefix      = "tag:"
+	maxGrafanaBuckets    = 5000
+	maxGrafanaAnnotation = 500
+)
+
+// GrafanaRange is the dashboard time range of a Grafana request
+type GrafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// GrafanaSearchRequest is the body of /api/grafana/search
+type GrafanaSearchRequest struct {
+	Target string `json:"target"` // part of the metric name, all metrics when empty
+}
+
+// GrafanaQueryRequest is the body of /api/grafana/query
+type GrafanaQueryRequest struct {
+	Range      GrafanaRange `json:"range"`
+	IntervalMs int64        `json:"intervalMs"` // picks daily, weekly, or monthly points
+	Targets    []struct {
+		Target string `json:"target"`
+		RefID  string `json:"refId"`
+		Hide   bool   `json:"hide"`
+	} `json:"targets"`
+}
+
+// GrafanaSeries is one metric of a /api/grafana/query response
+type GrafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"` // [value, unix milliseconds] at the start of each day, week, or month
+}
+
+// GrafanaAnnotationRequest is the body of /api/grafana/annotations
+type GrafanaAnnotationRequest struct {
+	Range      GrafanaRange `json:"range"`
+	Annotation struct {
+		Name  string `json:"name"`
+		Query string `json:"query"` // a metric, e.g., "category:Travel"; all expenses when empty
+	} `json:"annotation"`
+}
+
+// GrafanaAnnotation marks an expense on Grafana graphs
+type GrafanaAnnotation struct {
+	Annotation any      `json:"annotation"` // the annotation of the request, echoed back
+	Time       int64    `json:"time"`       // unix milliseconds
+	Title      string   `json:"title"`
+	Text       string   `json:"text"`
+	Tags       []string `json:"tags"`
+}
+
+// grafanaBucket is the start of the day, week (from Monday), or month of t for the query interval
+func grafanaBucket(t time.Time, interval time.Duration) time.Time {
+	t = t.In(time.Local)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+	switch {
+	case interval >= 28*24*time.Hour:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	case interval >= 7*24*time.Hour:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// nextGrafanaBucket is the start of the bucket after the one starting at bucket
+func nextGrafanaBucket(bucket time.Time, interval time.Duration) time.Time {
+	switch {
+	case interval >= 28*24*time.Hour:
+		return bucket.AddDate(0, 1, 0)
+	case interval >= 7*24*time.Hour:
+		return bucket.AddDate(0, 0, 7)
+	}
+	return bucket.AddDate(0, 0, 1)
+}
+
+// grafanaMetricValue is how much an expense adds to a metric, false when it isn't part of it
+func grafanaMetricValue(metric string, expense storage.Expense) (float64, bool) {
+	switch {
+	case metric == metricIncome:
+		return expense.Amount, expense.Amount > 0
+	case metric == metricSpent:
+		return -expense.Amount, expense.Amount < 0
+	case strings.HasPrefix(metric, metricCategoryPrefix):
+		return -expense.Amount, expense.Amount < 0 && expense.Category == strings.TrimPrefix(metric, metricCategoryPrefix)
+	case strings.HasPrefix(metric, metricTagPrefix):
+		return -expense.Amount, expense.Amount < 0 && slices.Contains(expense.Tags, strings.TrimPrefix(metric, metricTagPrefix))
+	}
+	return 0, false
+}
+
+// validGrafanaMetric checks the metric name, as unknown ones would silently read as no spending
+func validGrafanaMetric(metric string) bool {
+	return metric == metricSpent || metric == metricIncome ||
+		strings.HasPrefix(metric, metricCategoryPrefix) && len(metric) > len(metricCategoryPrefix) ||
+		strings.HasPrefix(metric, metricTagPrefix) && len(metric) > len(metricTagPrefix)
+}
+
+// GrafanaHealth answers the datasource connection test
+func (h *Handler) GrafanaHealth(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/grafana/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// GrafanaSearch lists the metrics containing the target, for the query editor
+func (h *Handler) GrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var req GrafanaSearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	config, err := h.storage.GetConfig()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get config"})
+		log.Printf("API ERROR: Failed to get config for Grafana: %v\n", err)
+		return
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for Grafana: %v\n", err)
+		return
+	}
+	categories := append(slices.Clone(config.Categories), config.Archived...)
+	var tags []string
+	for _, expense := range expenses {
+		tags = append(tags, expense.Tags...)
+	}
+	slices.Sort(categories)
+	slices.Sort(tags)
+	metrics := []string{metricSpent, metricIncome}
+	for _, category := range slices.Compact(categories) {
+		metrics = append(metrics, metricCategoryPrefix+category)
+	}
+	for _, tag := range slices.Compact(tags) {
+		metrics = append(metrics, metricTagPrefix+tag)
+	}
+	target := strings.ToLower(strings.TrimSpace(req.Target))
+	metrics = slices.DeleteFunc(metrics, func(metric string) bool {
+		return !strings.Contains(strings.ToLower(metric), target)
+	})
+	writeJSON(w, http.StatusOK, metrics)
+}
+
+// GrafanaQuery returns each target as a series of totals per day, week, or month over the range
+func (h *Handler) GrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var req GrafanaQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if !req.Range.To.After(req.Range.From) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid range: 'to' must be after 'from'"})
+		return
+	}
+	interval := time.Duration(req.IntervalMs) * time.Millisecond
+	var buckets []time.Time
+	for bucket := grafanaBucket(req.Range.From, interval); bucket.Before(req.Range.To); bucket = nextGrafanaBucket(bucket, interval) {
+		if len(buckets) == maxGrafanaBuckets {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "range has too many points, zoom out or raise the interval"})
+			return
+		}
+		buckets = append(buckets, bucket)
+	}
+	for _, target := range req.Targets {
+		if !target.Hide && !validGrafanaMetric(target.Target) {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("unknown metric '%s'", target.Target)})
+			return
+		}
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for Grafana: %v\n", err)
+		return
+	}
+	expenses = h.reportable(expenses)
+	response := []GrafanaSeries{}
+	for _, target := range req.Targets {
+		if target.Hide {
+			continue
+		}
+		totals := make(map[int64]float64, len(buckets))
+		for _, expense := range expenses {
+			if expense.Date.Before(req.Range.From) || !expense.Date.Before(req.Range.To) {
+				continue
+			}
+			if value, ok := grafanaMetricValue(target.Target, expense); ok {
+				totals[grafanaBucket(expense.Date, interval).UnixMilli()] += value
+			}
+		}
+		series := GrafanaSeries{Target: target.Target, Datapoints: make([][2]float64, 0, len(buckets))}
+		for _, bucket := range buckets {
+			series.Datapoints = append(series.Datapoints, [2]float64{totals[bucket.UnixMilli()], float64(bucket.UnixMilli())})
+		}
+		response = append(response, series)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// GrafanaAnnotations marks the biggest expenses of the annotation's metric within the range
+func (h *Handler) GrafanaAnnotations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	var req GrafanaAnnotationRequest
+	var echo struct {
+		Annotation any `json:"annotation"`
+	}
+	if json.Unmarshal(raw, &req) != nil || json.Unmarshal(raw, &echo) != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	metric := strings.TrimSpace(req.Annotation.Query)
+	if metric != "" && !validGrafanaMetric(metric) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("unknown metric '%s'", metric)})
+		return
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for Grafana: %v\n", err)
+		return
+	}
+	expenses = h.reportable(expenses)
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+	format := currencyFormat(currency)
+	var matches []storage.Expense
+	for _, expense := range expenses {
+		if expense.Date.Before(req.Range.From) || !expense.Date.Before(req.Range.To) {
+			continue
+		}
+		if _, ok := grafanaMetricValue(metric, expense); ok || metric == "" {
+			matches = append(matches, expense)
+		}
+	}
+	// keep the biggest ones, so a long range doesn't bury the graph in markers
+	slices.SortFunc(matches, func(a, b storage.Expense) int {
+		return cmp.Or(cmp.Compare(math.Abs(b.Amount), math.Abs(a.Amount)), cmp.Compare(a.ID, b.ID))
+	})
+	annotations := make([]GrafanaAnnotation, 0, min(len(matches), maxGrafanaAnnotation))
+	for _, expense := range matches[:min(len(matches), maxGrafanaAnnotation)] {
+		annotations = append(annotations, GrafanaAnnotation{
+			Annotation: echo.Annotation,
+			Time:       expense.Date.UnixMilli(),
+			Title:      expense.Name,
+			Text:       formatAmount(expense.Amount, format),
+			Tags:       append([]string{expense.Category}, expense.Tags...),
+		})
+	}
+	writeJSON(w, http.StatusOK, annotations)
+}
@@ -1,36 +1,170 @@
 package api
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/tanq16/expenseowl/internal/mailer"
+	"github.com/tanq16/expenseowl/internal/mqtt"
+	"github.com/tanq16/expenseowl/internal/ocr"
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/prices"
 	"github.com/tanq16/expenseowl/internal/storage"
 	"github.com/tanq16/expenseowl/internal/web"
 )
 
 // Handler holds the storage interface
 type Handler struct {
-	storage storage.Storage
+	storage          storage.Storage
+	emailIngestToken string // required by the inbound email webhook, disabled when empty
+	feedToken        string // required by the Atom feed, disabled when empty
+	mqtt             *mqtt.Config
+	mqttTopic        string
+	strictCategories bool // reject expenses whose category isn't configured
+	events           *eventBroker
+	mailer           *mailer.Config  // SMTP for report emails, disabled when nil
+	ocr              ocr.Engine      // receipt scanning, disabled when nil
+	prices           prices.Provider // holding prices, entered by hand when nil
+	plaid            *plaidConnector // bank sync, disabled when nil
+	wallet           *walletPasses   // the Apple Wallet budget pass, disabled when nil
+	reportsDir       string          // where saved reports are written, file output disabled when empty
+	tasks            sync.WaitGroup  // fire-and-forget work such as MQTT publishes
+	syncMu           sync.Mutex      // serializes sync pushes, which check revisions before writing
+	jobs             *jobScheduler
 }
 
 // NewHandler creates a new API handler
 func NewHandler(s storage.Storage) *Handler {
+	mqttTopic := os.Getenv("MQTT_TOPIC")
+	if mqttTopic == "" {
+		mqttTopic = defaultMQTTTopic
+	}
+	strictCategories, _ := strconv.ParseBool(os.Getenv("STRICT_CATEGORIES"))
+	events := newEventBroker()
 	return &Handler{
-		storage: s,
+		storage:          &eventStorage{Storage: s, events: events},
+		emailIngestToken: os.Getenv("EMAIL_INGEST_TOKEN"),
+		feedToken:        os.Getenv("FEED_TOKEN"),
+		mqtt:             mqttConfigFromEnv(),
+		mqttTopic:        mqttTopic,
+		strictCategories: strictCategories,
+		events:           events,
+		mailer:           mailerConfigFromEnv(),
+		ocr:              ocrEngineFromEnv(),
+		prices:           priceProviderFromEnv(),
+		plaid:            plaidConnectorFromEnv(),
+		wallet:           walletPassesFromEnv(),
+		reportsDir:       os.Getenv("REPORTS_DIR"),
+		jobs:             newJobScheduler(),
+	}
+}
+
+// WaitForTasks blocks until background work started by requests has finished
+func (h *Handler) WaitForTasks() {
+	h.tasks.Wait()
+}
+
+// validToken checks a shared secret sent in the given header or the token query parameter
+func validToken(r *http.Request, header string, expected string) bool {
+	if expected == "" {
+		return false
 	}
+	token := r.Header.Get(header)
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+// Error codes, for clients to branch on instead of the message; an ErrorResponse without one gets
+// the code of its status
+const (
+	CodeBadRequest           = "BAD_REQUEST"
+	CodeValidationFailed     = "VALIDATION_FAILED" // with the invalid fields
+	CodeUnauthorized         = "UNAUTHORIZED"
+	CodeForbidden            = "FORBIDDEN"
+	CodeCSRFFailed           = "CSRF_FAILED" // a write without the page's CSRF token
+	CodeNotFound             = "NOT_FOUND"
+	CodeExpenseNotFound      = "EXPENSE_NOT_FOUND"
+	CodeRecurringNotFound    = "RECURRING_EXPENSE_NOT_FOUND"
+	CodeMethodNotAllowed     = "METHOD_NOT_ALLOWED"
+	CodeConflict             = "CONFLICT"
+	CodePayloadTooLarge      = "PAYLOAD_TOO_LARGE"
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE"
+	CodeDuplicate            = "DUPLICATE"        // an expense with the ID exists
+	CodeExpenseChanged       = "EXPENSE_CHANGED"  // edited since it was read
+	CodeConfirmRequired      = "CONFIRM_REQUIRED" // saved with ?confirm=true
+	CodeCategoryNotAllowed   = "CATEGORY_NOT_ALLOWED"
+	CodeUnprocessable        = "UNPROCESSABLE"
+	CodeInternal             = "INTERNAL_ERROR"
+	CodeUpstreamFailed       = "UPSTREAM_FAILED" // a service the request depends on, like Plaid
+	CodeServiceUnavailable   = "SERVICE_UNAVAILABLE"
+)
+
+// statusCodes are the codes of error statuses
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeBadRequest,
+	http.StatusUnauthorized:          CodeUnauthorized,
+	http.StatusForbidden:             CodeForbidden,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusUnprocessableEntity:   CodeUnprocessable,
+	http.StatusInternalServerError:   CodeInternal,
+	http.StatusBadGateway:            CodeUpstreamFailed,
+	http.StatusServiceUnavailable:    CodeServiceUnavailable,
 }
 
 // ErrorResponse is a generic JSON error response
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error  string            `json:"error"`
+	Code   string            `json:"code,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"` // invalid field -> what's wrong with it
+}
+
+// CategoryErrorResponse lists the accepted categories when strict category checking rejects an expense
+type CategoryErrorResponse struct {
+	Error   string   `json:"error"`
+	Code    string   `json:"code"`
+	Allowed []string `json:"allowed"`
+}
+
+// LimitErrorResponse asks to confirm an amount above its category's single-transaction limit
+type LimitErrorResponse struct {
+	Error string  `json:"error"`
+	Code  string  `json:"code"`
+	Limit float64 `json:"limit"`
+}
+
+// ExpenseConflictResponse returns the server's copy of an expense that was changed since the client read it
+type ExpenseConflictResponse struct {
+	Error   string          `json:"error"`
+	Code    string          `json:"code"`
+	Current storage.Expense `json:"current"`
 }
 
 // writeJSON is a helper to write JSON responses
 func writeJSON(w http.ResponseWriter, status int, v any) {
+	if e, ok := v.(ErrorResponse); ok && e.Code == "" {
+		if e.Code = statusCodes[status]; e.Code == "" {
+			e.Code = strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+		}
+		v = e
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	if v != nil {
@@ -38,6 +172,16 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 	}
 }
 
+// writeValidationError writes a 400 for a record that failed validation, naming the invalid field
+func writeValidationError(w http.ResponseWriter, err error) {
+	response := ErrorResponse{Error: err.Error(), Code: CodeValidationFailed}
+	var invalid *storage.ValidationError
+	if errors.As(err, &invalid) {
+		response.Fields = map[string]string{invalid.Field: invalid.Error()}
+	}
+	writeJSON(w, http.StatusBadRequest, response)
+}
+
 // ------------------------------------------------------------
 // Config Handlers
 // ------------------------------------------------------------
@@ -53,7 +197,23 @@ func (h *Handler) GetConfig(w http.ResponseWriter, r *http.Request) {
 		log.Printf("API ERROR: Failed to get config: %v\n", err)
 		return
 	}
-	writeJSON(w, http.StatusOK, config)
+	preferences := config.Preferences[requestUser(r)]
+	redactConfig(config)
+	writeJSON(w, http.StatusOK, ConfigResponse{Config: config, Preferences: preferences, ChartPalette: chartPalette(config, preferences)})
+}
+
+// redactConfig leaves out of the config what stays on the server, like bank access tokens, and
+// what only admins see through the routes that manage it: share tokens, saved reports (webhook
+// URLs often carry keys), Wallet push tokens, report recipients, and everyone's preferences
+func redactConfig(config *storage.Config) {
+	for i := range config.PlaidItems {
+		config.PlaidItems[i].AccessToken = ""
+	}
+	config.Reports.Recipients = nil
+	config.Shares = nil
+	config.SavedReports = nil
+	config.WalletDevices = nil
+	config.Preferences = nil
 }
 
 func (h *Handler) GetCategories(w http.ResponseWriter, r *http.Request) {
@@ -98,6 +258,146 @@ func (h *Handler) UpdateCategories(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
 }
 
+type categoryReassignRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RenameCategory renames a category and rewrites it on all expenses, rules, budgets, and recurring expenses
+func (h *Handler) RenameCategory(w http.ResponseWriter, r *http.Request) {
+	h.reassignCategory(w, r, false)
+}
+
+// MergeCategories folds one category into another existing one, rewriting everything that referenced it
+func (h *Handler) MergeCategories(w http.ResponseWriter, r *http.Request) {
+	h.reassignCategory(w, r, true)
+}
+
+func (h *Handler) reassignCategory(w http.ResponseWriter, r *http.Request, merge bool) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var req categoryReassignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.From == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	to, err := storage.ValidateCategory(req.To)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Invalid category '%s': %v", req.To, err)})
+		return
+	}
+	config, err := h.storage.GetConfig()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve categories"})
+		log.Printf("API ERROR: Failed to get config: %v\n", err)
+		return
+	}
+	known := func(category string) bool {
+		return slices.Contains(config.Categories, category) || slices.Contains(config.Archived, category)
+	}
+	switch {
+	case req.From == to:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Source and target category are the same"})
+		return
+	case !known(req.From):
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("Category '%s' not found", req.From)})
+		return
+	case merge && !known(to):
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("Category '%s' not found", to)})
+		return
+	case !merge && known(to):
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: fmt.Sprintf("Category '%s' already exists, merge instead", to)})
+		return
+	}
+
+	if merge {
+		err = h.storage.MergeCategories(req.From, to)
+	} else {
+		err = h.storage.RenameCategory(req.From, to)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update category"})
+		log.Printf("API ERROR: Failed to reassign category %s to %s: %v\n", req.From, to, err)
+		return
+	}
+	log.Printf("HTTP: Reassigned category %s to %s (merge=%v)\n", req.From, to, merge)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success", "category": to})
+}
+
+func (h *Handler) GetArchivedCategories(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	archived, err := h.storage.GetArchivedCategories()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get archived categories"})
+		log.Printf("API ERROR: Failed to get archived categories: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, archived)
+}
+
+// ArchiveCategory hides a category from pickers (or restores it) without touching its expenses
+func (h *Handler) ArchiveCategory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var req struct {
+		Category string `json:"category"`
+		Archived bool   `json:"archived"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Category == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if err := h.storage.ArchiveCategory(req.Category, req.Archived); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		log.Printf("API ERROR: Failed to archive category: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+func (h *Handler) GetCategoryMeta(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	meta, err := h.storage.GetCategoryMeta()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get category metadata"})
+		log.Printf("API ERROR: Failed to get category metadata: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meta)
+}
+
+func (h *Handler) UpdateCategoryMeta(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var meta map[string]storage.CategoryMeta
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if err := storage.ValidateCategoryMeta(meta); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.UpdateCategoryMeta(meta); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update category metadata"})
+		log.Printf("API ERROR: Failed to update category metadata: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
 func (h *Handler) GetCurrency(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
@@ -162,6 +462,81 @@ func (h *Handler) UpdateStartDate(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
 }
 
+func (h *Handler) GetPeriod(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	period, err := h.storage.GetPeriod()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get period"})
+		log.Printf("API ERROR: Failed to get period: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"config":  period,
+		"current": period.Current(time.Now()),
+	})
+}
+
+func (h *Handler) UpdatePeriod(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var period periods.Config
+	if err := json.NewDecoder(r.Body).Decode(&period); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if err := period.Validate(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := h.storage.UpdatePeriod(period); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		log.Printf("API ERROR: Failed to update period: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+func (h *Handler) GetBudgets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	budgets, err := h.storage.GetBudgets()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get budgets"})
+		log.Printf("API ERROR: Failed to get budgets: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, budgets)
+}
+
+func (h *Handler) UpdateBudgets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var budgets map[string]float64
+	if err := json.NewDecoder(r.Body).Decode(&budgets); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if err := storage.ValidateBudgets(budgets); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.UpdateBudgets(budgets); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update budgets"})
+		log.Printf("API ERROR: Failed to update budgets: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
 // ------------------------------------------------------------
 // Expense Handlers
 // ------------------------------------------------------------
@@ -176,35 +551,189 @@ func (h *Handler) AddExpense(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
 		return
 	}
+	h.applyRules(&expense)
+	h.applyTravelRates(&expense)
+	h.applyTaxRate(&expense)
 	if err := expense.Validate(); err != nil {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		writeValidationError(w, err)
+		return
+	}
+	if !h.checkCategory(w, expense.Category, "") || !h.checkProject(w, &expense, "") || !h.checkRefund(w, &expense, "") || !h.checkCurrency(w, &expense) || !h.checkAmountLimit(w, r, expense) {
 		return
 	}
 	if expense.Date.IsZero() {
 		expense.Date = time.Now()
 	}
-	if err := h.storage.AddExpense(expense); err != nil {
+	if err := h.storage.AddExpense(expense); errors.Is(err, storage.ErrAlreadyExists) {
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: fmt.Sprintf("An expense with ID '%s' already exists", expense.ID), Code: CodeDuplicate})
+		return
+	} else if err != nil {
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save expense"})
 		log.Printf("API ERROR: Failed to save expense: %v\n", err)
 		return
 	}
+	h.notifyExpenseAdded(expense)
 	writeJSON(w, http.StatusOK, expense)
 }
 
+// checkCategory enforces STRICT_CATEGORIES, writing a 422 with the allowed list when the category is unknown.
+// An edited expense (existingID set) may keep its current category even if that category was archived.
+func (h *Handler) checkCategory(w http.ResponseWriter, category string, existingID string) bool {
+	if !h.strictCategories {
+		return true
+	}
+	categories, err := h.storage.GetCategories()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve categories"})
+		log.Printf("API ERROR: Failed to get categories: %v\n", err)
+		return false
+	}
+	if slices.Contains(categories, category) {
+		return true
+	}
+	if existingID != "" {
+		if existing, err := h.storage.GetExpense(existingID); err == nil && existing.Category == category {
+			return true
+		}
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, CategoryErrorResponse{
+		Error:   fmt.Sprintf("category '%s' is not configured", category),
+		Code:    CodeCategoryNotAllowed,
+		Allowed: categories,
+	})
+	return false
+}
+
+// checkAmountLimit writes a 409 when an amount is above its category's single-transaction limit, unless
+// ?confirm=true says it's intended
+func (h *Handler) checkAmountLimit(w http.ResponseWriter, r *http.Request, expense storage.Expense) bool {
+	if confirmed, _ := strconv.ParseBool(r.URL.Query().Get("confirm")); confirmed {
+		return true
+	}
+	meta, err := h.storage.GetCategoryMeta()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get category metadata"})
+		log.Printf("API ERROR: Failed to get category metadata: %v\n", err)
+		return false
+	}
+	limit := meta[expense.Category].MaxAmount
+	if limit <= 0 || math.Abs(expense.Amount) <= limit {
+		return true
+	}
+	writeJSON(w, http.StatusConflict, LimitErrorResponse{
+		Error: fmt.Sprintf("%.2f is above the %.2f limit for a single transaction in '%s', confirm to save it", math.Abs(expense.Amount), limit, expense.Category),
+		Code:  CodeConfirmRequired,
+		Limit: limit,
+	})
+	return false
+}
+
 func (h *Handler) GetExpenses(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
 		return
 	}
-	expenses, err := h.storage.GetAllExpenses()
+	filter, err := expenseFilter(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	sortBy := r.URL.Query().Get("sort")
+	if !slices.Contains([]string{"", "date", "created", "updated"}, sortBy) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'sort': must be date, created, or updated"})
+		return
+	}
+	var expenses []storage.Expense
+	if filter.IsEmpty() {
+		expenses, err = h.storage.GetAllExpenses()
+	} else {
+		expenses, err = h.storage.FindExpenses(filter)
+	}
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
 		log.Printf("API ERROR: Failed to retrieve expenses: %v\n", err)
 		return
 	}
+	sortExpensesByTime(expenses, sortBy)
 	writeJSON(w, http.StatusOK, expenses)
 }
 
+// sortExpensesByTime orders expenses newest first by when they were created or last updated
+// (?sort=created or ?sort=updated); expenses recorded before the times were kept go last
+func sortExpensesByTime(expenses []storage.Expense, sortBy string) {
+	if sortBy == "" || sortBy == "date" {
+		return
+	}
+	at := func(e storage.Expense) *time.Time {
+		if sortBy == "created" {
+			return e.CreatedAt
+		}
+		if e.UpdatedAt != nil {
+			return e.UpdatedAt
+		}
+		return e.CreatedAt
+	}
+	slices.SortStableFunc(expenses, func(a, b storage.Expense) int {
+		ta, tb := at(a), at(b)
+		switch {
+		case ta == nil && tb == nil:
+			return 0
+		case ta == nil:
+			return 1
+		case tb == nil:
+			return -1
+		}
+		return tb.Compare(*ta)
+	})
+}
+
+// expenseFilter reads the search filters of /expenses; they all combine, and tags match any
+// of the comma-separated tags unless tagMode=all
+func expenseFilter(query url.Values) (storage.ExpenseFilter, error) {
+	var filter storage.ExpenseFilter
+	for _, bound := range []struct {
+		name  string
+		value **float64
+	}{{"minAmount", &filter.MinAmount}, {"maxAmount", &filter.MaxAmount}} {
+		raw := query.Get(bound.name)
+		if raw == "" {
+			continue
+		}
+		amount, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid '%s': must be a number", bound.name)
+		}
+		*bound.value = &amount
+	}
+	filter.Tags = splitAndTrim(query.Get("tags"), ",")
+	switch query.Get("tagMode") {
+	case "", "any":
+	case "all":
+		filter.AllTags = true
+	default:
+		return filter, fmt.Errorf("invalid 'tagMode': must be any or all")
+	}
+	filter.SubCategory = query.Get("subcategory")
+	filter.Account = query.Get("account")
+	filter.Class = query.Get("class")
+	filter.Project = query.Get("project")
+	for _, flag := range []struct {
+		name  string
+		value *bool
+	}{{"recurring", &filter.RecurringOnly}, {"untagged", &filter.UntaggedOnly}, {"uncategorized", &filter.Uncategorized}} {
+		raw := query.Get(flag.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, fmt.Errorf("invalid '%s': must be true or false", flag.name)
+		}
+		*flag.value = value
+	}
+	return filter, storage.ValidateExpenseFilter(&filter)
+}
+
 func (h *Handler) EditExpense(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
 		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
@@ -220,15 +749,44 @@ func (h *Handler) EditExpense(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
 		return
 	}
+	h.applyTravelRates(&expense)
+	h.applyTaxRate(&expense)
 	if err := expense.Validate(); err != nil {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		writeValidationError(w, err)
+		return
+	}
+	if !h.checkCategory(w, expense.Category, id) || !h.checkProject(w, &expense, id) || !h.checkRefund(w, &expense, id) || !h.checkCurrency(w, &expense) || !h.checkAmountLimit(w, r, expense) {
 		return
 	}
-	if err := h.storage.UpdateExpense(id, expense); err != nil {
+	// an instance of a recurring expense stays part of its rule, and is kept as is when the rule is edited
+	if existing, err := h.storage.GetExpense(id); err == nil && existing.RecurringID != "" {
+		expense.RecurringID, expense.Occurrence, expense.Edited = existing.RecurringID, existing.Occurrence, true
+		if rule, err := h.storage.GetRecurringExpense(existing.RecurringID); err == nil {
+			expense.Occurrence = rule.OccurrenceOf(existing)
+		}
+	}
+	// an updatedAt from the client is a precondition, so edits from two devices don't overwrite each other
+	if err := h.storage.UpdateExpense(id, expense); errors.Is(err, storage.ErrExpenseChanged) {
+		current, err := h.storage.GetExpense(id)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expense"})
+			log.Printf("API ERROR: Failed to get changed expense %s: %v\n", id, err)
+			return
+		}
+		writeJSON(w, http.StatusConflict, ExpenseConflictResponse{Error: "The expense was changed since it was loaded", Code: CodeExpenseChanged, Current: current})
+		return
+	} else if errors.Is(err, storage.ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Expense not found", Code: CodeExpenseNotFound})
+		return
+	} else if err != nil {
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to edit expense"})
 		log.Printf("API ERROR: Failed to edit expense: %v\n", err)
 		return
 	}
+	// the stored copy carries the new updatedAt for the next edit
+	if saved, err := h.storage.GetExpense(id); err == nil {
+		expense = saved
+	}
 	writeJSON(w, http.StatusOK, expense)
 }
 
@@ -242,7 +800,10 @@ func (h *Handler) DeleteExpense(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "ID parameter is required"})
 		return
 	}
-	if err := h.storage.RemoveExpense(id); err != nil {
+	if err := h.storage.RemoveExpense(id); errors.Is(err, storage.ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Expense not found", Code: CodeExpenseNotFound})
+		return
+	} else if err != nil {
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete expense"})
 		log.Printf("API ERROR: Failed to delete expense: %v\n", err)
 		return
@@ -270,6 +831,48 @@ func (h *Handler) DeleteMultipleExpenses(w http.ResponseWriter, r *http.Request)
 	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
 }
 
+// MoveExpenses reassigns a set of expenses to a category and optional subcategory
+func (h *Handler) MoveExpenses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var payload struct {
+		IDs         []string `json:"ids"`
+		Category    string   `json:"category"`
+		SubCategory string   `json:"subCategory"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	payload.Category, payload.SubCategory = storage.SanitizeString(payload.Category), storage.SanitizeString(payload.SubCategory)
+	if len(payload.IDs) == 0 || payload.Category == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "ids and category are required"})
+		return
+	}
+	categories, err := h.storage.GetCategories()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve categories"})
+		log.Printf("API ERROR: Failed to get categories: %v\n", err)
+		return
+	}
+	if !slices.Contains(categories, payload.Category) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("category '%s' not found", payload.Category)})
+		return
+	}
+	if err := storage.ValidateSubCategory(h.storage, payload.Category, payload.SubCategory); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.MoveExpenses(payload.IDs, payload.Category, payload.SubCategory); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to move expenses"})
+		log.Printf("API ERROR: Failed to move expenses: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
 // ------------------------------------------------------------
 // Recurring Expense Handlers
 // ------------------------------------------------------------
@@ -285,7 +888,7 @@ func (h *Handler) AddRecurringExpense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err := re.Validate(); err != nil {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		writeValidationError(w, err)
 		return
 	}
 	if err := h.storage.AddRecurringExpense(re); err != nil {
@@ -328,10 +931,13 @@ func (h *Handler) UpdateRecurringExpense(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if err := re.Validate(); err != nil {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		writeValidationError(w, err)
 		return
 	}
-	if err := h.storage.UpdateRecurringExpense(id, re, updateAll); err != nil {
+	if err := h.storage.UpdateRecurringExpense(id, re, updateAll); errors.Is(err, storage.ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Recurring expense not found", Code: CodeRecurringNotFound})
+		return
+	} else if err != nil {
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update recurring expense"})
 		log.Printf("API ERROR: Failed to update recurring expense: %v\n", err)
 		return
@@ -351,7 +957,10 @@ func (h *Handler) DeleteRecurringExpense(w http.ResponseWriter, r *http.Request)
 	}
 	removeAll, _ := strconv.ParseBool(r.URL.Query().Get("removeAll"))
 
-	if err := h.storage.RemoveRecurringExpense(id, removeAll); err != nil {
+	if err := h.storage.RemoveRecurringExpense(id, removeAll); errors.Is(err, storage.ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Recurring expense not found", Code: CodeRecurringNotFound})
+		return
+	} else if err != nil {
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete recurring expense"})
 		log.Printf("API ERROR: Failed to delete recurring expense: %v\n", err)
 		return
@@ -359,6 +968,166 @@ func (h *Handler) DeleteRecurringExpense(w http.ResponseWriter, r *http.Request)
 	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
 }
 
+// OverrideRecurringOccurrence skips or changes one occurrence of a recurring expense, which stays part of
+// the rule and keeps the change when the rule is edited; an override without changes restores the occurrence
+func (h *Handler) OverrideRecurringOccurrence(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "ID parameter is required"})
+		return
+	}
+	var override storage.RecurringOverride
+	if err := json.NewDecoder(r.Body).Decode(&override); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	rules, err := h.storage.GetRecurringExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get recurring expenses"})
+		log.Printf("API ERROR: Failed to get recurring expenses: %v\n", err)
+		return
+	}
+	index := slices.IndexFunc(rules, func(rule storage.RecurringExpense) bool { return rule.ID == id })
+	if index < 0 {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Recurring expense not found", Code: CodeRecurringNotFound})
+		return
+	}
+	rule := rules[index]
+	if _, scheduled, _ := storage.RecurringOccurrence(rule, override.Date); !scheduled {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("No occurrence is scheduled on '%s'", override.Date)})
+		return
+	}
+	rule.Overrides = slices.DeleteFunc(slices.Clone(rule.Overrides), func(o storage.RecurringOverride) bool {
+		return o.Date == override.Date
+	})
+	if override.Skip || override.Amount != nil || override.MoveTo != nil {
+		rule.Overrides = append(rule.Overrides, override)
+	}
+	if rule.Overrides == nil {
+		rule.Overrides = []storage.RecurringOverride{} // saves the removal of the last override
+	}
+	if err := rule.Validate(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if err := h.storage.UpdateRecurringExpense(id, rule, false); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update recurring expense"})
+		log.Printf("API ERROR: Failed to update recurring expense: %v\n", err)
+		return
+	}
+
+	// upcoming occurrences were regenerated with the override, a past one is changed in place
+	after, _, skipped := storage.RecurringOccurrence(rule, override.Date)
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get expenses"})
+		log.Printf("API ERROR: Failed to get expenses: %v\n", err)
+		return
+	}
+	instance := slices.IndexFunc(expenses, func(e storage.Expense) bool {
+		return e.RecurringID == id && rule.OccurrenceOf(e) == override.Date
+	})
+	switch {
+	case skipped && instance >= 0:
+		err = h.storage.RemoveExpense(expenses[instance].ID)
+	case !skipped && instance < 0:
+		err = h.storage.AddExpense(after)
+	case !skipped && (expenses[instance].Amount != after.Amount || !expenses[instance].Date.Equal(after.Date)):
+		expense := expenses[instance]
+		expense.Amount, expense.Date = after.Amount, after.Date
+		err = h.storage.UpdateExpense(expense.ID, expense)
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update the occurrence"})
+		log.Printf("API ERROR: Failed to apply override to recurring expense %s: %v\n", id, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// BackfillResult lists the occurrences of a recurring expense that were missing, and created unless it's a dry run
+type BackfillResult struct {
+	DryRun   bool              `json:"dry_run"`
+	Missing  int               `json:"missing"`
+	Expenses []storage.Expense `json:"expenses"`
+}
+
+// missingOccurrences returns the occurrences of a rule without an expense from it for the same occurrence,
+// e.g., after the number of occurrences was raised or an instance was deleted by mistake
+func missingOccurrences(rule storage.RecurringExpense, existing []storage.Expense) []storage.Expense {
+	have := make(map[string]int)
+	for _, expense := range existing {
+		if expense.RecurringID == rule.ID {
+			have[rule.OccurrenceOf(expense)]++
+		}
+	}
+	missing := []storage.Expense{}
+	for _, occurrence := range storage.RecurringOccurrences(rule) {
+		if have[occurrence.Occurrence] > 0 {
+			have[occurrence.Occurrence]--
+			continue
+		}
+		missing = append(missing, occurrence)
+	}
+	return missing
+}
+
+// BackfillRecurringExpense creates the missing occurrences of a recurring expense, for
+// POST /api/recurring/{id}/backfill; ?dryRun=true lists them without creating anything
+func (h *Handler) BackfillRecurringExpense(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/recurring/"), "/backfill")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Not found"})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+	rules, err := h.storage.GetRecurringExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get recurring expenses"})
+		log.Printf("API ERROR: Failed to get recurring expenses: %v\n", err)
+		return
+	}
+	index := slices.IndexFunc(rules, func(rule storage.RecurringExpense) bool { return rule.ID == id })
+	if index < 0 {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Recurring expense not found", Code: CodeRecurringNotFound})
+		return
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get expenses"})
+		log.Printf("API ERROR: Failed to get expenses: %v\n", err)
+		return
+	}
+	// archived occurrences aren't missing, they were moved out by the retention policy
+	archived, err := h.storage.GetArchivedExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get archived expenses"})
+		log.Printf("API ERROR: Failed to get archived expenses: %v\n", err)
+		return
+	}
+
+	missing := missingOccurrences(rules[index], append(expenses, archived...))
+	if !dryRun && len(missing) > 0 {
+		if err := h.storage.AddMultipleExpenses(missing); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to add missing occurrences"})
+			log.Printf("API ERROR: Failed to backfill recurring expense %s: %v\n", id, err)
+			return
+		}
+		log.Printf("Backfilled %d occurrences of recurring expense %s\n", len(missing), id)
+	}
+	writeJSON(w, http.StatusOK, BackfillResult{DryRun: dryRun, Missing: len(missing), Expenses: missing})
+}
+
 // ------------------------------------------------------------
 // SubCategory Handlers
 // ------------------------------------------------------------
@@ -486,6 +1255,43 @@ func (h *Handler) RenameSubCategory(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
 }
 
+// MergeSubCategory folds one subcategory into another, updating expenses and mapping rules
+func (h *Handler) MergeSubCategory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var payload struct {
+		Category string `json:"category"`
+		Source   string `json:"source"`
+		Target   string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if payload.Category == "" || payload.Source == "" || payload.Target == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "category, source, and target are required"})
+		return
+	}
+	if payload.Source == payload.Target {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "source and target subcategory are the same"})
+		return
+	}
+	for _, name := range []string{payload.Source, payload.Target} {
+		if err := storage.ValidateSubCategory(h.storage, payload.Category, name); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			return
+		}
+	}
+	if err := h.storage.MergeSubCategory(payload.Category, payload.Source, payload.Target); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to merge subcategory"})
+		log.Printf("API ERROR: Failed to merge subcategory: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
 func (h *Handler) GetSubCategoryMappings(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
@@ -512,24 +1318,8 @@ func (h *Handler) UpdateSubCategoryMappings(w http.ResponseWriter, r *http.Reque
 	}
 	// Validate mapping rules
 	for i, rule := range rules {
-		if rule.Pattern == "" {
-			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("mapping rule %d: pattern is required", i)})
-			return
-		}
-		if rule.MatchType == "" {
-			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("mapping rule %d: matchType is required", i)})
-			return
-		}
-		if rule.MatchType != "exact" && rule.MatchType != "contains" {
-			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("mapping rule %d: invalid match type '%s', must be 'exact' or 'contains'", i, rule.MatchType)})
-			return
-		}
-		if rule.Category == "" {
-			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("mapping rule %d: category is required", i)})
-			return
-		}
-		if rule.SubCategory == "" {
-			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("mapping rule %d: subCategory is required", i)})
+		if err := validateMappingRule(rule); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("mapping rule %d: %v", i, err)})
 			return
 		}
 	}
@@ -545,13 +1335,26 @@ func (h *Handler) UpdateSubCategoryMappings(w http.ResponseWriter, r *http.Reque
 // Static and UI Handlers
 // ------------------------------------------------------------
 
+// ServeDashboard serves the dashboard at the root, and 404s for unknown paths
+func (h *Handler) ServeDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := web.ServeTemplate(w, "index.html", brandingOf(r, h.storage)); err != nil {
+		log.Printf("HTTP ERROR: Failed to serve template: %v", err)
+		http.Error(w, "Failed to serve template", http.StatusInternalServerError)
+	}
+}
+
 func (h *Handler) ServeTableView(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
 		return
 	}
 	w.Header().Set("Content-Type", "text/html")
-	if err := web.ServeTemplate(w, "table.html"); err != nil {
+	if err := web.ServeTemplate(w, "table.html", brandingOf(r, h.storage)); err != nil {
 		http.Error(w, "Failed to serve template", http.StatusInternalServerError)
 	}
 }
@@ -562,7 +1365,7 @@ func (h *Handler) ServeSettingsPage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	w.Header().Set("Content-Type", "text/html")
-	if err := web.ServeTemplate(w, "settings.html"); err != nil {
+	if err := web.ServeTemplate(w, "settings.html", brandingOf(r, h.storage)); err != nil {
 		http.Error(w, "Failed to serve template", http.StatusInternalServerError)
 	}
 }
@@ -573,7 +1376,7 @@ func (h *Handler) ServeMonthlyChartView(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	w.Header().Set("Content-Type", "text/html")
-	if err := web.ServeTemplate(w, "monthly-chart.html"); err != nil {
+	if err := web.ServeTemplate(w, "monthly-chart.html", brandingOf(r, h.storage)); err != nil {
 		http.Error(w, "Failed to serve template", http.StatusInternalServerError)
 	}
 }
@@ -583,6 +1386,10 @@ func (h *Handler) ServeStaticFile(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
 		return
 	}
+	if r.URL.Path == "/theme.css" {
+		h.serveThemeCSS(w, r)
+		return
+	}
 	if err := web.ServeStatic(w, r.URL.Path); err != nil {
 		http.Error(w, "Failed to serve static file", http.StatusInternalServerError)
 	}
@@ -619,28 +1426,17 @@ func (h *Handler) GetMonthlyExpenses(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Get all expenses from storage
-	expenses, err := h.storage.GetAllExpenses()
+	// Summarize from the monthly aggregates when possible, with category filtering if specified
+	periodList := h.periodConfig().Last(months, time.Now())
+	summarize, err := h.summarizerFor(periodList, filterCategories)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
 		log.Printf("API ERROR: Failed to retrieve expenses: %v\n", err)
 		return
 	}
 
-	// Get start date from config
-	startDate, err := h.storage.GetStartDate()
-	if err != nil {
-		startDate = 1 // default fallback
-	}
-
-	// Apply category filtering if specified
-	filteredExpenses := expenses
-	if len(filterCategories) > 0 {
-		filteredExpenses = filterExpensesByCategories(expenses, filterCategories)
-	}
-
 	// Calculate monthly trend
-	monthlyData := calculateMonthlyTrend(filteredExpenses, startDate, months)
+	monthlyData := calculatePeriodTrend(summarize, periodList)
 
 	writeJSON(w, http.StatusOK, monthlyData)
 	log.Printf("HTTP: Served monthly expenses data (months=%d, categories=%v)\n", months, filterCategories)
@@ -1,154 +1,96 @@
 package api
 
 import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"maps"
+	"math"
+	"math/big"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/tanq16/expenseowl/internal/i18n"
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/plaid"
+	"github.com/tanq16/expenseowl/internal/prices"
 	"github.com/tanq16/expenseowl/internal/storage"
+	"github.com/tanq16/expenseowl/internal/wallet"
+	"github.com/tanq16/expenseowl/internal/web"
+	"golang.org/x/image/bmp"
 )
 
-// mockStorage is a simple mock implementation for testing
-type mockStorage struct {
-	expenses  []storage.Expense
-	startDate int
-}
-
-func (m *mockStorage) GetAllExpenses() ([]storage.Expense, error) {
-	return m.expenses, nil
-}
-
-func (m *mockStorage) GetStartDate() (int, error) {
-	return m.startDate, nil
-}
-
-func (m *mockStorage) GetConfig() (*storage.Config, error) {
-	return &storage.Config{}, nil
-}
-
-func (m *mockStorage) GetExpense(string) (storage.Expense, error) {
-	return storage.Expense{}, nil
-}
-
-func (m *mockStorage) GetRecurringExpense(string) (storage.RecurringExpense, error) {
-	return storage.RecurringExpense{}, nil
-}
-
-func (m *mockStorage) FindDuplicateExpense(string, string, float64, time.Time) (bool, error) {
-	return false, nil
-}
-
-func (m *mockStorage) AddMultipleExpenses([]storage.Expense) error {
-	return nil
-}
-
-func (m *mockStorage) GetCategories() ([]string, error) {
-	return []string{}, nil
-}
-
-func (m *mockStorage) GetCurrency() (string, error) {
-	return "usd", nil
-}
-
-func (m *mockStorage) UpdateCategories([]string) error {
-	return nil
-}
-
-func (m *mockStorage) UpdateCurrency(string) error {
-	return nil
-}
-
-func (m *mockStorage) UpdateStartDate(int) error {
-	return nil
-}
-
-func (m *mockStorage) AddExpense(storage.Expense) error {
-	return nil
-}
-
-func (m *mockStorage) UpdateExpense(string, storage.Expense) error {
-	return nil
-}
-
-func (m *mockStorage) RemoveExpense(string) error {
-	return nil
-}
-
-func (m *mockStorage) RemoveMultipleExpenses([]string) error {
-	return nil
-}
-
-func (m *mockStorage) AddRecurringExpense(storage.RecurringExpense) error {
-	return nil
-}
-
-func (m *mockStorage) GetRecurringExpenses() ([]storage.RecurringExpense, error) {
-	return []storage.RecurringExpense{}, nil
-}
-
-func (m *mockStorage) UpdateRecurringExpense(string, storage.RecurringExpense, bool) error {
-	return nil
-}
-
-func (m *mockStorage) RemoveRecurringExpense(string, bool) error {
-	return nil
-}
-
-func (m *mockStorage) GetSubCategories(string) ([]string, error) {
-	return []string{}, nil
-}
-
-func (m *mockStorage) AddSubCategory(string, string) error {
-	return nil
-}
-
-func (m *mockStorage) RemoveSubCategory(string, string) error {
-	return nil
-}
-
-func (m *mockStorage) RenameSubCategory(string, string, string) error {
-	return nil
-}
-
-func (m *mockStorage) GetSubCategoryMappings() ([]storage.SubCategoryMappingRule, error) {
-	return []storage.SubCategoryMappingRule{}, nil
-}
-
-func (m *mockStorage) UpdateSubCategoryMappings([]storage.SubCategoryMappingRule) error {
-	return nil
+// newTestStore returns an in-memory store with the default config holding the given expenses
+func newTestStore(t *testing.T, expenses ...storage.Expense) storage.Storage {
+	t.Helper()
+	store, err := storage.NewMemoryStore()
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	if err := store.AddMultipleExpenses(expenses); err != nil {
+		t.Fatalf("Failed to add expenses: %v", err)
+	}
+	return store
 }
 
-func (m *mockStorage) Close() error {
-	return nil
+// newTestStoreWithCategories is newTestStore with only the given categories configured
+func newTestStoreWithCategories(t *testing.T, categories ...string) storage.Storage {
+	t.Helper()
+	store := newTestStore(t)
+	if err := store.UpdateCategories(categories); err != nil {
+		t.Fatalf("Failed to set categories: %v", err)
+	}
+	return store
 }
 
 // TestGetMonthlyExpenses_DefaultMonths tests the default behavior (12 months)
 func TestGetMonthlyExpenses_DefaultMonths(t *testing.T) {
-	// Create mock storage with sample expenses
+	// Create storage with sample expenses
 	now := time.Now()
-	mock := &mockStorage{
-		expenses: []storage.Expense{
-			{
-				ID:       "1",
-				Date:     now.AddDate(0, -1, 0),
-				Amount:   -100.0,
-				Category: "Food",
-				Name:     "Test expense",
-			},
-			{
-				ID:       "2",
-				Date:     now.AddDate(0, -2, 0),
-				Amount:   -200.0,
-				Category: "Transport",
-				Name:     "Test expense 2",
-			},
+	store := newTestStore(t,
+		storage.Expense{
+			ID:       "1",
+			Date:     now.AddDate(0, -1, 0),
+			Amount:   -100.0,
+			Category: "Food",
+			Name:     "Test expense",
 		},
-		startDate: 1,
-	}
+		storage.Expense{
+			ID:       "2",
+			Date:     now.AddDate(0, -2, 0),
+			Amount:   -200.0,
+			Category: "Transport",
+			Name:     "Test expense 2",
+		},
+	)
 
-	handler := NewHandler(mock)
+	handler := NewHandler(store)
 
 	// Create request
 	req := httptest.NewRequest(http.MethodGet, "/api/expenses/monthly", nil)
@@ -176,12 +118,7 @@ func TestGetMonthlyExpenses_DefaultMonths(t *testing.T) {
 
 // TestGetMonthlyExpenses_CustomMonths tests custom month parameter
 func TestGetMonthlyExpenses_CustomMonths(t *testing.T) {
-	mock := &mockStorage{
-		expenses:  []storage.Expense{},
-		startDate: 1,
-	}
-
-	handler := NewHandler(mock)
+	handler := NewHandler(newTestStore(t))
 
 	// Create request with months=6
 	req := httptest.NewRequest(http.MethodGet, "/api/expenses/monthly?months=6", nil)
@@ -209,12 +146,7 @@ func TestGetMonthlyExpenses_CustomMonths(t *testing.T) {
 
 // TestGetMonthlyExpenses_InvalidMethod tests that non-GET methods are rejected
 func TestGetMonthlyExpenses_InvalidMethod(t *testing.T) {
-	mock := &mockStorage{
-		expenses:  []storage.Expense{},
-		startDate: 1,
-	}
-
-	handler := NewHandler(mock)
+	handler := NewHandler(newTestStore(t))
 
 	// Create POST request
 	req := httptest.NewRequest(http.MethodPost, "/api/expenses/monthly", nil)
@@ -262,3 +194,4670 @@ func TestFilterExpensesByCategories(t *testing.T) {
 		t.Errorf("Expected 0 expenses for non-existent category, got %d", len(filtered))
 	}
 }
+
+// TestTelegramParseExpense tests parsing of chat messages into expenses
+func TestTelegramParseExpense(t *testing.T) {
+	for _, chatIDs := range []string{"", " , ", "12345,me"} {
+		if _, err := NewTelegramBot(NewHandler(newTestStore(t)), "token", chatIDs); err == nil {
+			t.Errorf("Expected the chat IDs %q to be rejected", chatIDs)
+		}
+	}
+	bot, err := NewTelegramBot(NewHandler(newTestStore(t)), "token", "12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expense, err := bot.parseExpense("12.50 lunch with team")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expense.Amount != -12.50 || expense.Name != "lunch with team" || expense.Category != "Miscellaneous" {
+		t.Errorf("Unexpected expense: %+v", expense)
+	}
+
+	income, err := bot.parseExpense("+100 refund")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if income.Amount != 100 {
+		t.Errorf("Expected income of 100, got %.2f", income.Amount)
+	}
+
+	if _, err := bot.parseExpense("lunch 12.50"); err == nil {
+		t.Error("Expected error for message without a leading amount")
+	}
+}
+
+// TestParseReceipt tests merchant and total extraction from receipt emails
+func TestParseReceipt(t *testing.T) {
+	tests := []struct {
+		email  InboundEmail
+		name   string
+		amount float64
+	}{
+		{InboundEmail{From: "Amazon.com <auto-confirm@amazon.com>", Subject: "Your order", Text: "Item subtotal: $10.00\nOrder Total: $1,234.56"}, "Amazon", 1234.56},
+		{InboundEmail{From: "noreply@uber.com", Subject: "Your Thursday trip", Text: "Total $23.10"}, "Uber", 23.10},
+		{InboundEmail{From: "billing@cityutilities.example", Subject: "Fwd: Water Bill", Text: "Amount Due: $45.00"}, "Water Bill", 45.00},
+	}
+	for _, tc := range tests {
+		name, amount, err := parseReceipt(tc.email)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", tc.email.From, err)
+			continue
+		}
+		if name != tc.name || amount != tc.amount {
+			t.Errorf("Expected %s %.2f, got %s %.2f", tc.name, tc.amount, name, amount)
+		}
+	}
+	if _, _, err := parseReceipt(InboundEmail{Subject: "Hello", Text: "No totals here"}); err == nil {
+		t.Error("Expected error for email without a total")
+	}
+}
+
+// TestExpenseFromEmail checks that attachment names go in the metadata rather than the tags
+func TestExpenseFromEmail(t *testing.T) {
+	handler := NewHandler(newTestStore(t))
+	expense, err := handler.expenseFromEmail(InboundEmail{From: "noreply@uber.com", Text: "Total $23.10", Attachments: []string{"receipt.pdf", "map.png"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(expense.Tags, []string{"email"}) || expense.Metadata["email"]["attachments"] != "receipt.pdf, map.png" {
+		t.Errorf("Expected the attachments in the email metadata, got tags %v and %v", expense.Tags, expense.Metadata)
+	}
+	expense, _ = handler.expenseFromEmail(InboundEmail{From: "noreply@uber.com", Text: "Total $23.10"})
+	if expense.Metadata != nil {
+		t.Errorf("Expected no metadata without attachments, got %v", expense.Metadata)
+	}
+}
+
+// TestReassignCategory checks that merging moves rules, budgets, and recurring expenses to the target
+func TestReassignCategory(t *testing.T) {
+	config := &storage.Config{}
+	config.SetBaseConfig()
+	config.SubCategories = map[string][]string{"Food": {"Lunch"}, "Groceries": {"Produce"}}
+	config.SubCategoryMap = []storage.SubCategoryMappingRule{{Pattern: "market", MatchType: "contains", Category: "Groceries"}}
+	config.Budgets = map[string]float64{"Food": 300, "Groceries": 400}
+	config.RecurringExpenses = []storage.RecurringExpense{{ID: "r1", Category: "Groceries"}}
+
+	if err := config.ReassignCategory("Groceries", "Food", true); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	for _, c := range config.Categories {
+		if c == "Groceries" {
+			t.Errorf("expected Groceries to be removed after merge")
+		}
+	}
+	if got := config.SubCategories["Food"]; len(got) != 2 {
+		t.Errorf("expected subcategories to be combined, got %v", got)
+	}
+	if config.SubCategoryMap[0].Category != "Food" || config.RecurringExpenses[0].Category != "Food" {
+		t.Errorf("expected rules and recurring expenses to point at Food")
+	}
+	if config.Budgets["Food"] != 300 || len(config.Budgets) != 1 {
+		t.Errorf("expected target budget to be kept, got %v", config.Budgets)
+	}
+
+	if err := config.ReassignCategory("Food", "Rent", false); err == nil {
+		t.Errorf("expected rename onto an existing category to fail")
+	}
+	if err := config.ReassignCategory("Food", "Dining", false); err != nil || config.Categories[0] != "Dining" {
+		t.Errorf("expected rename in place, got %v (%v)", config.Categories, err)
+	}
+}
+
+// TestAddExpense_StrictCategories checks that unknown categories are rejected with the allowed list
+func TestAddExpense_StrictCategories(t *testing.T) {
+	handler := NewHandler(newTestStoreWithCategories(t, "Food", "Rent"))
+	handler.strictCategories = true
+
+	body := `{"name": "Coffee", "category": "Fod", "amount": -4.5, "date": "2026-03-01T10:00:00Z"}`
+	req := httptest.NewRequest(http.MethodPut, "/expense", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.AddExpense(w, req)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status 422, got %d", w.Code)
+	}
+	var resp CategoryErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Allowed) != 2 {
+		t.Errorf("Expected 2 allowed categories, got %v", resp.Allowed)
+	}
+
+	body = `{"name": "Coffee", "category": "Food", "amount": -4.5, "date": "2026-03-01T10:00:00Z"}`
+	req = httptest.NewRequest(http.MethodPut, "/expense", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.AddExpense(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for a configured category, got %d", w.Code)
+	}
+}
+
+// TestGetTRMNLData_Options checks the payload customization query parameters
+func TestGetTRMNLData_Options(t *testing.T) {
+	now := time.Now()
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "1", Date: now, Amount: -100.0, Category: "Food", Name: "Groceries"},
+		storage.Expense{ID: "2", Date: now, Amount: -50.0, Category: "Transport", Name: "Bus pass"},
+		storage.Expense{ID: "3", Date: now, Amount: 1000.0, Category: "Income", Name: "Salary"},
+	))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/trmnl?top=1&trend=3&income=false&compact=true", nil)
+	w := httptest.NewRecorder()
+	handler.GetTRMNLData(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &raw); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	for _, field := range []string{"all_categories", "total_income", "balance"} {
+		if _, ok := raw[field]; ok {
+			t.Errorf("Expected %s to be omitted", field)
+		}
+	}
+	var resp TRMNLResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.TopCategories) != 1 || resp.TopCategories[0].Name != "Food" {
+		t.Errorf("Expected only Food as top category, got %v", resp.TopCategories)
+	}
+	if len(resp.MonthlyTrend) != 3 {
+		t.Errorf("Expected 3 trend periods, got %d", len(resp.MonthlyTrend))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/trmnl?period=week", nil)
+	w = httptest.NewRecorder()
+	handler.GetTRMNLData(w, req)
+	resp = TRMNLResponse{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	week := periods.Config{Type: periods.Weekly}.Current(now)
+	if resp.Month != week.Label() || resp.TotalExpenses != 150 || len(resp.MonthlyTrend) != 12 || resp.MonthlyTrend[11].Month != week.ShortLabel() {
+		t.Errorf("Expected this week's totals and a 12-week trend, got %+v", resp)
+	}
+
+	for _, query := range []string{"top=0", "trend=100", "compact=maybe", "period=day"} {
+		req = httptest.NewRequest(http.MethodGet, "/api/trmnl?"+query, nil)
+		w = httptest.NewRecorder()
+		handler.GetTRMNLData(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, w.Code)
+		}
+	}
+}
+
+// TestTRMNLProfiles checks that each display's token serves the payload of its own profile
+func TestTRMNLProfiles(t *testing.T) {
+	now := time.Now()
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "1", Date: now, Amount: -100.0, Category: "Food", Name: "Groceries"},
+		storage.Expense{ID: "2", Date: now, Amount: -50.0, Category: "Transport", Name: "Bus pass"},
+	))
+	request := func(call http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		call(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	w := request(handler.TRMNLProfiles, http.MethodPost, "/api/trmnl/profiles", `{"name": "Kitchen", "options": "period=week&categories=Food&compact=true"}`)
+	var created TRMNLProfileResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || w.Code != http.StatusOK || created.Token == "" {
+		t.Fatalf("Expected a token for the new profile, got %d: %+v", w.Code, created)
+	}
+	for _, body := range []string{`{"name": "kitchen"}`, `{"name": "Office", "options": "period=day"}`, `{"name": ""}`} {
+		if w := request(handler.TRMNLProfiles, http.MethodPost, "/api/trmnl/profiles", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	w = request(handler.GetTRMNLProfileData, http.MethodGet, created.URL, "")
+	var resp TRMNLResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected the profile's payload, got %d", w.Code)
+	}
+	if resp.TotalExpenses != 100 || len(resp.TopCategories) != 1 || resp.AllCategories != nil || resp.Month != (periods.Config{Type: periods.Weekly}).Current(now).Label() {
+		t.Errorf("Expected this week's Food only, got %+v", resp)
+	}
+	if w := request(handler.GetTRMNLProfileData, http.MethodGet, "/api/trmnl/profile?token=guess", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for an unknown token, got %d", w.Code)
+	}
+
+	if w := request(handler.UpdateTRMNLProfile, http.MethodPut, "/api/trmnl/profiles/edit", `{"name": "Kitchen", "options": "top=1"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	w = request(handler.TRMNLProfiles, http.MethodGet, "/api/trmnl/profiles", "")
+	var profiles []TRMNLProfileResponse
+	json.NewDecoder(w.Body).Decode(&profiles)
+	if len(profiles) != 1 || profiles[0].Options != "top=1" || profiles[0].Token != "" {
+		t.Errorf("Expected the new options without the token, got %+v", profiles)
+	}
+	resp = TRMNLResponse{}
+	json.NewDecoder(request(handler.GetTRMNLProfileData, http.MethodGet, created.URL, "").Body).Decode(&resp)
+	if resp.TotalExpenses != 150 || len(resp.TopCategories) != 1 {
+		t.Errorf("Expected the same token to serve the new options, got %+v", resp)
+	}
+
+	if w := request(handler.RemoveTRMNLProfile, http.MethodDelete, "/api/trmnl/profiles/remove?name=kitchen", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if w := request(handler.GetTRMNLProfileData, http.MethodGet, created.URL, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the removed profile's token to be revoked, got %d", w.Code)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount float64
+		format numberFormat
+		want   string
+	}{
+		{1234.567, currencyFormat("usd"), "$1,234.57"},
+		{-1234567.5, currencyFormat("eur"), "-€1.234.567,50"},
+		{99.5, currencyFormat("jpy"), "¥100"},
+		{1234.5, currencyFormat("chf"), "1,234.50 Fr"},
+		{-0.001, currencyFormat("usd"), "$0.00"},
+		{1234.5, numberFormat{Thousands: "'", Decimal: ".", Decimals: 1}, "1'234.5"},
+	}
+	for _, tt := range tests {
+		if got := formatAmount(tt.amount, tt.format); got != tt.want {
+			t.Errorf("formatAmount(%v) = %q, want %q", tt.amount, got, tt.want)
+		}
+	}
+}
+
+// TestGetWidgetImage checks that the summary is drawn at the display's size in black and white
+func TestGetWidgetImage(t *testing.T) {
+	now := time.Now()
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "1", Date: now, Amount: -100.0, Category: "Café", Name: "Coffee"},
+		storage.Expense{ID: "2", Date: now, Amount: -50.0, Category: "Transport", Name: "Bus pass"},
+	))
+	tests := []struct {
+		target string
+		decode func(io.Reader) (image.Image, error)
+		size   image.Point
+	}{
+		{"/api/widgets/summary.png", png.Decode, image.Pt(800, 480)},
+		{"/api/widgets/summary.png?size=waveshare-2in9&top=5", png.Decode, image.Pt(296, 128)},
+		{"/api/widgets/summary.bmp?size=kindle&width=758&height=1024", bmp.Decode, image.Pt(758, 1024)},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.GetWidgetImage(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", tt.target, w.Code, w.Body.String())
+		}
+		img, err := tt.decode(w.Body)
+		if err != nil {
+			t.Fatalf("%s: failed to decode the image: %v", tt.target, err)
+		}
+		if img.Bounds().Size() != tt.size {
+			t.Errorf("%s: expected %v, got %v", tt.target, tt.size, img.Bounds().Size())
+		}
+		black := 0
+		for y := 0; y < tt.size.Y; y++ {
+			for x := 0; x < tt.size.X; x++ {
+				if r, _, _, _ := img.At(x, y).RGBA(); r == 0 {
+					black++
+				}
+			}
+		}
+		if black == 0 || black > tt.size.X*tt.size.Y/2 {
+			t.Errorf("%s: expected black text and bars on white, got %d black pixels", tt.target, black)
+		}
+	}
+
+	for _, query := range []string{"size=poster", "width=50", "top=20"} {
+		w := httptest.NewRecorder()
+		handler.GetWidgetImage(w, httptest.NewRequest(http.MethodGet, "/api/widgets/summary.png?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, w.Code)
+		}
+	}
+	if got := truncateText("Café and Restaurants", 10); got != "Cafe an..." {
+		t.Errorf("Expected the name without its accent and cut to 10 characters, got %q", got)
+	}
+}
+
+// TestVoiceAssistant checks voice keys, account linking, and the Alexa and Google intents
+func TestVoiceAssistant(t *testing.T) {
+	store := newTestStore(t)
+	handler := NewHandler(store)
+	request := func(call http.HandlerFunc, method, target, body string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		call(w, r)
+		return w
+	}
+	alexa := func(token, intent, slots string) alexaResponse {
+		t.Helper()
+		body := fmt.Sprintf(`{"session": {"user": {"accessToken": %q}}, "request": {"type": "IntentRequest", "locale": "en-US", "intent": {"name": %q, "slots": {%s}}}}`, token, intent, slots)
+		w := request(handler.AlexaSkill, http.MethodPost, "/api/voice/alexa", body)
+		var response alexaResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil || w.Code != http.StatusOK || response.Response.OutputSpeech == nil {
+			t.Fatalf("Expected an Alexa response, got %d: %v", w.Code, err)
+		}
+		return response
+	}
+
+	w := request(handler.VoiceKeys, http.MethodPost, "/api/voice/keys", `{"name": "Kitchen Echo"}`)
+	var created VoiceKeyResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || w.Code != http.StatusOK || created.Key == "" {
+		t.Fatalf("Expected a key for the new voice key, got %d: %+v", w.Code, created)
+	}
+	if w := request(handler.VoiceKeys, http.MethodPost, "/api/voice/keys", `{"name": "kitchen echo"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a duplicate name, got %d", w.Code)
+	}
+
+	if response := alexa("guess", IntentSpending, ""); response.Response.Card == nil || response.Response.Card.Type != "LinkAccount" {
+		t.Errorf("Expected an unknown key to be asked to link, got %+v", response.Response)
+	}
+	response := alexa(created.Key, IntentAddExpense, `"Amount": {"value": "12"}, "Cents": {"value": "50"}, "Name": {"value": "lunch"}, "Category": {"value": "food"}`)
+	if got := response.Response.OutputSpeech.Text; got != "Added $12.50 for lunch to Food." || !response.Response.EndSession {
+		t.Errorf("Unexpected reply to adding an expense: %q", got)
+	}
+	expenses, _ := store.GetAllExpenses()
+	if len(expenses) != 1 || expenses[0].Amount != -12.5 || expenses[0].Metadata["voice"]["key"] != "Kitchen Echo" {
+		t.Fatalf("Expected the expense to be saved with its voice key, got %+v", expenses)
+	}
+	if got := alexa(created.Key, IntentAddExpense, `"Name": {"value": "lunch"}`).Response.OutputSpeech.Text; !strings.HasPrefix(got, "How much") {
+		t.Errorf("Expected to be asked for the amount, got %q", got)
+	}
+	if got := alexa(created.Key, IntentSpending, `"Category": {"value": "Food"}, "Period": {"value": "this week"}`).Response.OutputSpeech.Text; got != "You've spent $12.50 on Food this week." {
+		t.Errorf("Unexpected reply to the spending question: %q", got)
+	}
+
+	body := `{"intent": {"name": "SpendingIntent", "params": {}}, "session": {"id": "abc"}, "user": {"locale": "de-DE"}}`
+	w = request(handler.GoogleAction, http.MethodPost, "/api/voice/google", body, "Authorization", "Bearer "+created.Key)
+	var google googleResponse
+	if err := json.NewDecoder(w.Body).Decode(&google); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected a Google response, got %d", w.Code)
+	}
+	if google.Prompt.FirstSimple.Speech != "Du hast diesen Monat $12,50 ausgegeben." || google.Session.ID != "abc" || google.Scene == nil {
+		t.Errorf("Unexpected Google response: %+v", google)
+	}
+
+	if w := request(handler.LinkVoiceAssistant, http.MethodGet, "/api/voice/link?redirect_uri=https://evil.example.com/", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for another redirect, got %d", w.Code)
+	}
+	form := url.Values{"key": {created.Key}, "redirect_uri": {"https://pitangui.amazon.com/api/skill/link/M2"}, "state": {"xyz"}}
+	if w := request(handler.LinkVoiceAssistant, http.MethodGet, "/api/voice/link?"+form.Encode(), ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `name="state" value="xyz"`) {
+		t.Errorf("Expected the linking form, got %d", w.Code)
+	}
+	w = request(handler.LinkVoiceAssistant, http.MethodPost, "/api/voice/link", form.Encode(), "Content-Type", "application/x-www-form-urlencoded")
+	if location := w.Header().Get("Location"); w.Code != http.StatusFound || !strings.Contains(location, "#access_token="+created.Key) || !strings.Contains(location, "state=xyz") {
+		t.Errorf("Expected a redirect with the key as the access token, got %d: %s", w.Code, location)
+	}
+	form.Set("key", "guess")
+	if w := request(handler.LinkVoiceAssistant, http.MethodPost, "/api/voice/link", form.Encode(), "Content-Type", "application/x-www-form-urlencoded"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for an unknown key, got %d", w.Code)
+	}
+
+	if w := request(handler.RemoveVoiceKey, http.MethodDelete, "/api/voice/keys/remove?name=kitchen%20echo", ""); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if response := alexa(created.Key, IntentSpending, ""); response.Response.Card == nil {
+		t.Error("Expected a removed key to be unlinked")
+	}
+}
+
+// TestGetSpokenSummary checks the sentences of the spoken summary, with and without budgets
+func TestGetSpokenSummary(t *testing.T) {
+	now := time.Now()
+	store := newTestStore(t,
+		storage.Expense{ID: "1", Date: now, Amount: -143, Category: "Groceries", Name: "Market"},
+		storage.Expense{ID: "2", Date: now, Amount: -20, Category: "Food", Name: "Lunch"},
+	)
+	if err := store.UpdateBudgets(map[string]float64{"Groceries": 600, "Food": 10}); err != nil {
+		t.Fatalf("Failed to save budgets: %v", err)
+	}
+	handler := NewHandler(store)
+
+	tests := []struct {
+		target string
+		text   string
+	}{
+		{"/api/summary/today?decimals=0", "You've spent $163 today."},
+		{"/api/summary/week?decimals=0", "You've spent $163 this week."},
+		{"/api/summary/month?decimals=0", "You've spent $163 of your $610 budget this month, with $447 left."},
+		{"/api/summary/month?category=groceries&decimals=0", "You've spent $143 of your $600 Groceries budget this month, with $457 left."},
+		{"/api/summary/month?category=Food", "You've spent $20.00 of your $10.00 Food budget this month, $10.00 over."},
+		{"/api/summary/today?category=Food&lang=de", "Du hast heute $20,00 für Food ausgegeben."},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.GetSpokenSummary(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", tt.target, w.Code, w.Body.String())
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+			t.Errorf("%s: expected plain text, got %s", tt.target, w.Header().Get("Content-Type"))
+		}
+		if got := strings.TrimSpace(w.Body.String()); got != tt.text {
+			t.Errorf("%s: expected %q, got %q", tt.target, tt.text, got)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.GetSpokenSummary(w, httptest.NewRequest(http.MethodGet, "/api/summary/month?format=json&category=Groceries", nil))
+	var response SpokenSummary
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Period != "month" || response.Spent != 143 || response.Budget == nil || *response.Budget != 600 || response.Remaining == nil || *response.Remaining != 457 {
+		t.Errorf("Unexpected JSON summary: %+v", response)
+	}
+
+	for target, status := range map[string]int{
+		"/api/summary/month?category=Unknown": http.StatusBadRequest,
+		"/api/summary/month?format=xml":       http.StatusBadRequest,
+		"/api/summary/year":                   http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		handler.GetSpokenSummary(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != status {
+			t.Errorf("%s: expected status %d, got %d", target, status, w.Code)
+		}
+	}
+}
+
+// TestGetWidgetSummary_Fields checks field selection and formatting options
+// newTestWalletPasses signs the budget pass with a made-up Pass Type ID certificate
+func newTestWalletPasses(t *testing.T) *walletPasses {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			OrganizationalUnit: []string{"TEAM123"},
+			ExtraNames:         []pkix.AttributeTypeAndValue{{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, Value: "pass.com.example.budget"}},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...)
+	signer, err := wallet.NewSigner(certPEM, certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := walletImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &walletPasses{signer: signer, images: images, serialNumber: walletSerialNumber, webServiceURL: "https://owl.example.com/api/wallet"}
+}
+
+func TestWalletPass(t *testing.T) {
+	store := newTestStore(t, storage.Expense{ID: "1", Date: time.Now(), Amount: -143, Category: "Groceries", Name: "Market"})
+	if err := store.UpdateBudgets(map[string]float64{"Groceries": 600}); err != nil {
+		t.Fatalf("Failed to save budgets: %v", err)
+	}
+	handler := NewHandler(store)
+	request := func(call http.HandlerFunc, method, target, body string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		call(w, r)
+		return w
+	}
+	if w := request(handler.GetWalletPass, http.MethodGet, "/api/wallet/pass", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without a pass certificate, got %d", w.Code)
+	}
+	handler.wallet = newTestWalletPasses(t)
+
+	pass, err := handler.budgetPass(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pass.Generic.PrimaryFields[0]; got.Key != "remaining" || got.Value != "$457.00" {
+		t.Errorf("Expected $457.00 left of the budget, got %+v", got)
+	}
+	if len(pass.Generic.BackFields) != 1 || pass.Generic.BackFields[0].Value != "$457.00 of $600.00 left" {
+		t.Errorf("Expected the Groceries budget on the back, got %+v", pass.Generic.BackFields)
+	}
+	w := request(handler.GetWalletPass, http.MethodGet, "/api/wallet/pass", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != wallet.ContentType {
+		t.Fatalf("Expected a pkpass, got %d: %s", w.Code, w.Body.String())
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Expected a zip: %v", err)
+	}
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	for _, name := range []string{"pass.json", "manifest.json", "signature", "icon.png", "icon@2x.png", "logo.png"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("Expected %s in the pass, got %v", name, names)
+		}
+	}
+
+	registration := "/api/wallet/v1/devices/phone/registrations/pass.com.example.budget/budget"
+	auth := "ApplePass " + handler.wallet.signer.AuthenticationToken("budget")
+	if w := request(handler.WalletService, http.MethodPost, registration, `{"pushToken": "abc"}`, "Authorization", "ApplePass guess"); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong token, got %d", w.Code)
+	}
+	if w := request(handler.WalletService, http.MethodPost, registration, `{"pushToken": "abc"}`, "Authorization", auth); w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201 for a new registration, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := request(handler.WalletService, http.MethodPost, registration, `{"pushToken": "abc"}`, "Authorization", auth); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for an existing registration, got %d", w.Code)
+	}
+	devices, _ := store.GetWalletDevices()
+	if len(devices) != 1 || devices[0].PushToken != "abc" || devices[0].Tag != walletTag(pass) {
+		t.Fatalf("Expected the device to be registered with the current pass, got %+v", devices)
+	}
+	// the pass is up to date, so there's nothing to push
+	if err := handler.pushWalletUpdates(context.Background(), time.Now()); err != nil {
+		t.Errorf("Expected no pushes, got %v", err)
+	}
+
+	w = request(handler.WalletService, http.MethodGet, "/api/wallet/v1/devices/phone/registrations/pass.com.example.budget", "")
+	var updates WalletPassUpdates
+	if err := json.NewDecoder(w.Body).Decode(&updates); err != nil || w.Code != http.StatusOK || !slices.Equal(updates.SerialNumbers, []string{"budget"}) {
+		t.Fatalf("Expected the pass to be listed, got %d: %+v", w.Code, updates)
+	}
+	if w := request(handler.WalletService, http.MethodGet, "/api/wallet/v1/devices/phone/registrations/pass.com.example.budget?passesUpdatedSince="+updates.LastUpdated, ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204 when the pass hasn't changed, got %d", w.Code)
+	}
+	store.AddExpense(storage.Expense{ID: "2", Date: time.Now(), Amount: -7, Category: "Groceries", Name: "Bread"})
+	if w := request(handler.WalletService, http.MethodGet, "/api/wallet/v1/devices/phone/registrations/pass.com.example.budget?passesUpdatedSince="+updates.LastUpdated, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the pass to be listed after an expense, got %d", w.Code)
+	}
+	if w := request(handler.WalletService, http.MethodGet, "/api/wallet/v1/passes/pass.com.example.budget/budget", "", "Authorization", auth); w.Code != http.StatusOK || w.Header().Get("Last-Modified") == "" {
+		t.Errorf("Expected the updated pass, got %d", w.Code)
+	}
+	if w := request(handler.WalletService, http.MethodGet, "/api/wallet/v1/passes/pass.com.example.budget/other", "", "Authorization", auth); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for another pass, got %d", w.Code)
+	}
+
+	if w := request(handler.WalletService, http.MethodDelete, registration, "", "Authorization", auth); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 when unregistering, got %d", w.Code)
+	}
+	if devices, _ := store.GetWalletDevices(); len(devices) != 0 {
+		t.Errorf("Expected the device to be unregistered, got %+v", devices)
+	}
+	if w := request(handler.WalletService, http.MethodGet, "/api/wallet/v1/devices/phone/registrations/pass.com.example.budget", ""); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204 for an unregistered device, got %d", w.Code)
+	}
+}
+
+func TestGetWidgetSummary_Fields(t *testing.T) {
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "1", Date: time.Now(), Amount: -1234.5, Category: "Rent", Name: "Rent"},
+	))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/widgets/summary?fields=spent,top&top=1&locale=de&decimals=0", nil)
+	w := httptest.NewRecorder()
+	handler.GetWidgetSummary(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var resp map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp) != 2 || resp["spent"] != "$1.235" {
+		t.Errorf("Expected only spent and top with spent $1.235, got %v", resp)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/widgets/summary?fields=spent,bogus", nil)
+	w = httptest.NewRecorder()
+	handler.GetWidgetSummary(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown field, got %d", w.Code)
+	}
+}
+
+// TestRenderReport checks that the report email includes totals, budgets, and the biggest expenses
+func TestRenderReport(t *testing.T) {
+	period := periods.Default().Current(time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC))
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "1", Date: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), Amount: -1200, Category: "Rent", Name: "March rent"},
+		storage.Expense{ID: "2", Date: time.Date(2026, 3, 5, 0, 0, 0, 0, time.UTC), Amount: -45.5, Category: "Food", Name: "<Groceries>"},
+		storage.Expense{ID: "3", Date: time.Date(2026, 2, 5, 0, 0, 0, 0, time.UTC), Amount: -999, Category: "Food", Name: "Last month"},
+	))
+	report, err := handler.buildReport(period, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if report.Spent != 1245.5 || len(report.BiggestExpenses) != 2 || report.BiggestExpenses[0].Name != "March rent" {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	body, err := renderReport(report, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, want := range []string{"March 2026", "$1,245.50", "&lt;Groceries&gt;"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected report to contain %q", want)
+		}
+	}
+	if strings.Contains(body, "Last month") {
+		t.Error("Expected expenses outside the period to be left out")
+	}
+}
+
+// TestLocalizedReport checks that reports use the language's text, month names, and separators,
+// and that every catalog translates every key
+func TestLocalizedReport(t *testing.T) {
+	for _, language := range i18n.Languages {
+		if missing := i18n.Missing(language.Code); len(missing) > 0 {
+			t.Errorf("Expected the %s catalog to translate every key, missing %v", language.Code, missing)
+		}
+	}
+	period := periods.Default().Current(time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC))
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "1", Date: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), Amount: -1200, Category: "Rent", Name: "March rent"},
+	))
+	report, err := handler.buildReport(period, "de")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, err := renderReport(report, "de")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, want := range []string{"März 2026", "Ausgegeben", "$1.200,00", "2. März"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected German report to contain %q", want)
+		}
+	}
+
+	if err := handler.storage.UpdateReportSettings(storage.ReportSettings{Language: "ko"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/reports/preview", nil)
+	w := httptest.NewRecorder()
+	handler.PreviewReport(w, req)
+	if !strings.Contains(w.Body.String(), "ExpenseOwl 요약") {
+		t.Errorf("Expected the preview in the report language, got %s", w.Body.String())
+	}
+	req = httptest.NewRequest(http.MethodGet, "/reports/preview?lang=xx", nil)
+	w = httptest.NewRecorder()
+	handler.PreviewReport(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unsupported language, got %d", w.Code)
+	}
+	if err := storage.ValidatePreferences(storage.Preferences{Language: "xx"}); err == nil {
+		t.Error("Expected an unsupported language preference to be rejected")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/i18n/fr.json", nil)
+	w = httptest.NewRecorder()
+	handler.ServeTranslations(w, req)
+	var catalog map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&catalog); err != nil || catalog["nav.settings"] != "Paramètres" {
+		t.Errorf("Expected the French catalog, got %v (%v)", catalog, err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/i18n/xx.json", nil)
+	w = httptest.NewRecorder()
+	handler.ServeTranslations(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unsupported language, got %d", w.Code)
+	}
+}
+
+// TestImportFirefly_DryRun checks Firefly type mapping and that a dry run writes nothing
+func TestImportFirefly_DryRun(t *testing.T) {
+	handler := NewHandler(newTestStoreWithCategories(t, "Food", "Income"))
+	events := handler.events.subscribe()
+	defer handler.events.unsubscribe(events)
+
+	csvData := "type,amount,currency_code,description,date,source_name,destination_name,category,tags\n" +
+		"Withdrawal,-12.50,USD,Coffee,2026-03-01T09:00:00+00:00,Checking,Cafe,food,morning\n" +
+		"Deposit,2000,USD,Salary,2026-03-01T00:00:00+00:00,Employer,Checking,Income,\n" +
+		"Transfer,-100,USD,To savings,2026-03-02T00:00:00+00:00,Checking,Savings,,\n"
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", "firefly.csv")
+	part.Write([]byte(csvData))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/import/firefly?dryRun=true", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	handler.ImportFirefly(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result ImportResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !result.DryRun || result.Imported != 2 || result.Skipped != 1 || len(result.Expenses) != 2 {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	coffee := result.Expenses[0]
+	if coffee.Amount != -12.5 || coffee.Category != "Food" || coffee.Currency != "usd" || !slices.Contains(coffee.Tags, "Checking") {
+		t.Errorf("Unexpected expense: %+v", coffee)
+	}
+	if result.Expenses[1].Amount != 2000 {
+		t.Errorf("Expected deposit to be income, got %v", result.Expenses[1].Amount)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Expected a dry run not to write anything, got %s event", event.Type)
+	default:
+	}
+}
+
+// TestImportCSV_DryRun checks that a CSV dry run reports warnings without writing anything
+func TestImportCSV_DryRun(t *testing.T) {
+	handler := NewHandler(newTestStoreWithCategories(t, "Food"))
+	events := handler.events.subscribe()
+	defer handler.events.unsubscribe(events)
+
+	csvData := "name,category,amount,date\n" +
+		"Lunch,Food,-15,2026-03-01\n" +
+		"Train,Travel,-40,2026-03-02\n" +
+		"Mystery,,-5,2026-03-03\n" +
+		"Broken,Food,abc,2026-03-04\n"
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", "expenses.csv")
+	part.Write([]byte(csvData))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/import/csv?dryRun=true", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	handler.ImportCSV(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result ImportResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if result.Imported != 2 || result.Skipped != 2 || len(result.Warnings) != 2 {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	if len(result.NewCategories) != 1 || result.NewCategories[0] != "Travel" {
+		t.Errorf("Expected Travel as the only new category, got %v", result.NewCategories)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("Expected a dry run not to write anything, got %s event", event.Type)
+	default:
+	}
+}
+
+// TestEventStorage_PublishesChanges checks that successful changes reach subscribed clients
+func TestEventStorage_PublishesChanges(t *testing.T) {
+	handler := NewHandler(newTestStore(t))
+	events := handler.events.subscribe()
+	defer handler.events.unsubscribe(events)
+
+	if err := handler.storage.AddExpense(storage.Expense{Name: "Coffee", Category: "Food", Amount: -4.5}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := handler.storage.UpdateCurrency("eur"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, want := range []string{EventExpenses, EventConfig} {
+		select {
+		case event := <-events:
+			if event.Type != want {
+				t.Errorf("Expected %s event, got %s", want, event.Type)
+			}
+		default:
+			t.Fatalf("Expected %s event, got none", want)
+		}
+	}
+}
+
+// TestEventStorage_WrapsMutators checks that every change of the storage interface publishes an
+// event, so a new mutator can't be added without one; methods only promoted from the wrapped
+// store are generated by the compiler
+func TestEventStorage_WrapsMutators(t *testing.T) {
+	notPublished := map[string]bool{ // login tokens aren't shown to clients
+		"AddLoginToken":    true,
+		"UpdateLoginToken": true,
+		"RemoveLoginToken": true,
+	}
+	storageType := reflect.TypeOf((*storage.Storage)(nil)).Elem()
+	wrapperType := reflect.TypeOf(&eventStorage{})
+	for i := range storageType.NumMethod() {
+		name := storageType.Method(i).Name
+		if notPublished[name] || !slices.ContainsFunc([]string{"Update", "Add", "Remove", "Rename", "Merge", "Archive", "Move"}, func(prefix string) bool {
+			return strings.HasPrefix(name, prefix)
+		}) {
+			continue
+		}
+		method, _ := wrapperType.MethodByName(name)
+		file, _ := runtime.FuncForPC(method.Func.Pointer()).FileLine(method.Func.Pointer())
+		if filepath.Base(file) != "events.go" {
+			t.Errorf("Expected eventStorage to wrap %s, it's promoted from the store", name)
+		}
+	}
+}
+
+// TestMappingEngine_Apply checks rule priority, disabled rules, renames, tags, and accounts
+func TestMappingEngine_Apply(t *testing.T) {
+	rules := []storage.SubCategoryMappingRule{
+		{Pattern: "amzn", MatchType: "contains", Category: "Shopping", Tags: []string{"online"}},
+		{Pattern: `^AMZN Mktp`, MatchType: "regex", Rename: "Amazon", Account: "Visa", Priority: 10},
+		{Pattern: "AMZN", MatchType: "contains", Rename: "Ignored", Disabled: true, Priority: 20},
+		{Pattern: "mktp", MatchType: "contains", Tags: []string{"online", "marketplace"}},
+	}
+	engine, err := NewMappingEngine(rules)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expense := storage.Expense{Name: "AMZN Mktp US*2A4", Amount: -25}
+	engine.Apply(&expense)
+	if expense.Name != "Amazon" || expense.Account != "Visa" || expense.Category != "Shopping" {
+		t.Errorf("Expected renamed Shopping expense on Visa, got %+v", expense)
+	}
+	if !slices.Equal(expense.Tags, []string{"online", "marketplace"}) {
+		t.Errorf("Expected tags from all matching rules without duplicates, got %v", expense.Tags)
+	}
+
+	// an expense that already has a category keeps it
+	expense = storage.Expense{Name: "amzn order", Category: "Gifts", Amount: -10}
+	engine.Apply(&expense)
+	if expense.Category != "Gifts" || expense.Name != "amzn order" {
+		t.Errorf("Expected category and name to be kept, got %+v", expense)
+	}
+
+	for _, invalid := range []storage.SubCategoryMappingRule{
+		{Pattern: "x", MatchType: "contains"},
+		{Pattern: "(", MatchType: "regex", Rename: "X"},
+		{Pattern: "x", MatchType: "contains", SubCategory: "Coffee"},
+	} {
+		if err := validateMappingRule(invalid); err == nil {
+			t.Errorf("Expected rule %+v to be invalid", invalid)
+		}
+	}
+}
+
+// TestSummarizeMerchants checks normalization, aliases, and per-merchant totals
+func TestSummarizeMerchants(t *testing.T) {
+	for name, want := range map[string]string{
+		"STARBUCKS #1234":  "starbucks",
+		"SQ *Blue Bottle":  "blue bottle",
+		"AMZN Mktp US*2A4": "amzn mktp us",
+		"7-Eleven":         "7 eleven",
+	} {
+		if got := storage.NormalizeMerchant(name); got != want {
+			t.Errorf("NormalizeMerchant(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 61) // about two months
+	expenses := []storage.Expense{
+		{Name: "Starbucks #1234", Category: "Food", Amount: -5, Date: start.AddDate(0, 0, 1)},
+		{Name: "STARBUCKS 0042", Category: "Food", Amount: -7, Date: start.AddDate(0, 0, 20)},
+		{Name: "AMZN Mktp US*2A4", Category: "Shopping", Amount: -30, Date: start.AddDate(0, 0, 3)},
+		{Name: "Amazon", Category: "Shopping", Amount: -20, Date: start.AddDate(0, 0, 40)},
+		{Name: "Amazon", Category: "Shopping", Amount: 20, Date: start.AddDate(0, 0, 41)}, // refund, not a visit
+		{Name: "Starbucks", Category: "Food", Amount: -4, Date: start.AddDate(0, 0, -3)},  // outside the range
+	}
+	aliases := map[string]string{"amzn mktp us": "Amazon"}
+	merchants, _ := summarizeMerchants(expenses, aliases, start, end)
+	if len(merchants) != 2 {
+		t.Fatalf("Expected 2 merchants, got %+v", merchants)
+	}
+	amazon, starbucks := merchants[0], merchants[1]
+	if amazon.Name != "Amazon" || amazon.Spent != 50 || amazon.Visits != 2 || amazon.AverageTicket != 25 {
+		t.Errorf("Unexpected Amazon summary: %+v", amazon)
+	}
+	if !slices.Equal(amazon.Names, []string{"AMZN Mktp US*2A4", "Amazon"}) {
+		t.Errorf("Expected both names grouped under Amazon, got %v", amazon.Names)
+	}
+	if starbucks.Spent != 12 || starbucks.Visits != 2 || starbucks.Category != "Food" || starbucks.LastVisit != "2026-01-21" {
+		t.Errorf("Unexpected Starbucks summary: %+v", starbucks)
+	}
+	if starbucks.VisitsPerMonth != 1 {
+		t.Errorf("Expected 1 visit per month, got %v", starbucks.VisitsPerMonth)
+	}
+
+	if _, err := storage.ValidateMerchantAliases(map[string]string{"a": "B", "b": "A"}); err == nil {
+		t.Error("Expected looping aliases to be rejected")
+	}
+}
+
+// TestAddExpense_Notes checks that notes are kept as free text and limited in length
+func TestAddExpense_Notes(t *testing.T) {
+	handler := NewHandler(newTestStoreWithCategories(t, "Food"))
+
+	body := `{"name": "Dinner", "category": "Food", "amount": -60, "date": "2026-03-01T19:00:00Z", "notes": "  split with roommate, awaiting Venmo ($30)  "}`
+	req := httptest.NewRequest(http.MethodPut, "/expense", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.AddExpense(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var expense storage.Expense
+	if err := json.NewDecoder(w.Body).Decode(&expense); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if expense.Notes != "split with roommate, awaiting Venmo ($30)" || expense.Name != "Dinner" {
+		t.Errorf("Expected trimmed notes separate from the name, got %+v", expense)
+	}
+
+	body = fmt.Sprintf(`{"name": "Dinner", "category": "Food", "amount": -60, "date": "2026-03-01T19:00:00Z", "notes": "%s"}`, strings.Repeat("a", storage.MaxNotesLength+1))
+	req = httptest.NewRequest(http.MethodPut, "/expense", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.AddExpense(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for notes that are too long, got %d", w.Code)
+	}
+}
+
+// TestAddExpense_Travel checks that mileage and per-diem amounts are computed from the default rates
+func TestAddExpense_Travel(t *testing.T) {
+	store := newTestStoreWithCategories(t, "Travel")
+	if err := store.UpdateTravelRates(storage.TravelRates{MileageRate: 0.3, DistanceUnit: "km", PerDiemRate: 45}); err != nil {
+		t.Fatalf("Failed to set travel rates: %v", err)
+	}
+	handler := NewHandler(store)
+
+	tests := []struct {
+		name       string
+		travel     string
+		wantStatus int
+		wantAmount float64
+		wantRate   float64
+	}{
+		{"mileage", `{"type": "mileage", "distance": 100}`, http.StatusOK, -30, 0.3},
+		{"mileage with own rate", `{"type": "mileage", "distance": 12.5, "unit": "mi", "rate": 0.67}`, http.StatusOK, -8.38, 0.67},
+		{"per diem", `{"type": "perdiem", "days": 2}`, http.StatusOK, -90, 45},
+		{"missing distance", `{"type": "mileage"}`, http.StatusBadRequest, 0, 0},
+		{"unknown type", `{"type": "lodging", "days": 1}`, http.StatusBadRequest, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"name": "Client visit", "category": "Travel", "date": "2026-03-01T09:00:00Z", "travel": %s}`, tt.travel)
+			req := httptest.NewRequest(http.MethodPut, "/expense", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			handler.AddExpense(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var expense storage.Expense
+			if err := json.NewDecoder(w.Body).Decode(&expense); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if expense.Amount != tt.wantAmount || expense.Travel == nil || expense.Travel.Rate != tt.wantRate {
+				t.Errorf("Expected amount %v at rate %v, got %+v (%+v)", tt.wantAmount, tt.wantRate, expense, expense.Travel)
+			}
+		})
+	}
+}
+
+// TestBuildYearlyReport checks that an April fiscal year in progress is split into months and forecast
+func TestBuildYearlyReport(t *testing.T) {
+	expenses := []storage.Expense{
+		{Name: "Rent", Category: "Rent", Amount: -1000, Date: time.Date(2026, 4, 1, 12, 0, 0, 0, time.UTC)},
+		{Name: "Salary", Category: "Income", Amount: 3000, Date: time.Date(2026, 4, 30, 12, 0, 0, 0, time.UTC)},
+		{Name: "Rent", Category: "Rent", Amount: -1000, Date: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)}, // previous fiscal year
+	}
+	year := periods.FiscalYear(time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC), time.April)
+	now := time.Date(2026, 7, 1, 10, 0, 0, 0, time.UTC) // 92 of 365 days
+	report := buildYearlyReport(expenseSummarizer(expenses), year, now)
+
+	if report.Year != "FY 2026-27" || report.Start != "2026-04-01" || report.End != "2027-03-31" {
+		t.Errorf("Expected FY 2026-27 from 2026-04-01 to 2027-03-31, got %s from %s to %s", report.Year, report.Start, report.End)
+	}
+	if report.TotalExpenses != 1000 || report.TotalIncome != 3000 {
+		t.Errorf("Expected only this fiscal year's totals, got income %v and expenses %v", report.TotalIncome, report.TotalExpenses)
+	}
+	if len(report.Months) != 12 || report.Months[0].Month != "Apr 2026" || report.Months[11].Month != "Mar 2027" {
+		t.Errorf("Expected 12 months from Apr 2026 to Mar 2027, got %+v", report.Months)
+	}
+	if report.Forecast == nil || math.Abs(report.Forecast.ProjectedExpenses-1000*365.0/92) > 0.01 {
+		t.Errorf("Expected expenses projected at the run rate, got %+v", report.Forecast)
+	}
+
+	past := buildYearlyReport(expenseSummarizer(expenses), periods.FiscalYear(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), time.April), now)
+	if past.Forecast != nil || past.TotalExpenses != 1000 {
+		t.Errorf("Expected a finished year without a forecast, got %+v", past)
+	}
+}
+
+// TestShares checks that a trip share shows only the trip's expenses and stops working once revoked or expired
+func TestShares(t *testing.T) {
+	now := time.Now()
+	store := newTestStore(t,
+		storage.Expense{ID: "1", Name: "Hotel Avenida", Category: "Travel", Amount: -320, Date: now, Tags: []string{"lisbon"}},
+		storage.Expense{ID: "2", Name: "Groceries", Category: "Food", Amount: -80, Date: now},
+	)
+	handler := NewHandler(store)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shares", strings.NewReader(`{"report": "trip", "tag": "lisbon", "expires_in_days": 3}`))
+	w := httptest.NewRecorder()
+	handler.Shares(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var share ShareResponse
+	if err := json.NewDecoder(w.Body).Decode(&share); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(share.Token) != 32 || share.URL != "/share/"+share.Token || share.Title != "Trip: lisbon" {
+		t.Errorf("Unexpected share: %+v", share)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, share.URL, nil)
+	w = httptest.NewRecorder()
+	handler.ViewShare(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Hotel Avenida") || strings.Contains(w.Body.String(), "Groceries") {
+		t.Errorf("Expected the trip report with only tagged expenses, got %d: %s", w.Code, w.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/share/"+strings.Repeat("0", 32), nil)
+	w = httptest.NewRecorder()
+	handler.ViewShare(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown token, got %d", w.Code)
+	}
+
+	shares, _ := store.GetShares()
+	shares[0].ExpiresAt = now.Add(-time.Minute)
+	if err := store.UpdateShares(shares); err != nil {
+		t.Fatalf("Failed to expire the share: %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, share.URL, nil)
+	w = httptest.NewRecorder()
+	handler.ViewShare(w, req)
+	if w.Code != http.StatusGone {
+		t.Errorf("Expected status 410 for an expired link, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/shares/revoke?token="+share.Token, nil)
+	w = httptest.NewRecorder()
+	handler.RevokeShare(w, req)
+	if shares, _ := store.GetShares(); w.Code != http.StatusOK || len(shares) != 0 {
+		t.Errorf("Expected the share to be revoked, got %d with %d shares left", w.Code, len(shares))
+	}
+
+	for _, body := range []string{`{"report": "trip"}`, `{"report": "everything"}`, `{"report": "period", "expires_in_days": 400}`} {
+		req = httptest.NewRequest(http.MethodPost, "/api/shares", strings.NewReader(body))
+		w = httptest.NewRecorder()
+		handler.Shares(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+}
+
+// TestAccessControl checks that roles from the proxy header are enforced per route
+func TestAccessControl(t *testing.T) {
+	t.Setenv("ACCESS_ROLES", "alice:admin, bob:editor, grandma:Viewer")
+	t.Setenv("ACCESS_USER_HEADER", "X-Forwarded-User")
+	access, err := AccessControlFromEnv(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	handler := access.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		user   string
+		method string
+		path   string
+		want   int
+	}{
+		{"grandma", http.MethodGet, "/expenses", http.StatusOK},
+		{"grandma", http.MethodPut, "/expense", http.StatusForbidden},
+		{"bob", http.MethodPut, "/expense", http.StatusOK},
+		{"bob", http.MethodPut, "/categories/edit", http.StatusForbidden},
+		{"alice", http.MethodPut, "/categories/edit", http.StatusOK},
+		{"bob", http.MethodPut, "/api/v1/categories/edit", http.StatusForbidden},
+		{"bob", http.MethodPut, "/api/v1/expense", http.StatusOK},
+		{"mallory", http.MethodGet, "/expenses", http.StatusForbidden},
+		{"", http.MethodGet, "/expenses", http.StatusUnauthorized},
+		{"", http.MethodGet, "/share/abc", http.StatusOK},
+		{"", http.MethodPost, "/api/ingest/email", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.user != "" {
+			req.Header.Set("X-Forwarded-User", tt.user)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s %s as %q: expected status %d, got %d", tt.method, tt.path, tt.user, tt.want, w.Code)
+		}
+	}
+
+	t.Setenv("ACCESS_ROLES", "alice:owner")
+	if _, err := AccessControlFromEnv(nil); err == nil {
+		t.Error("Expected an error for an unknown role")
+	}
+}
+
+// TestGetExpensesGeoJSON checks that only expenses with coordinates become points, as [lon, lat]
+func TestGetExpensesGeoJSON(t *testing.T) {
+	lat, lon := 38.7223, -9.1393
+	now := time.Now()
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "1", Name: "Pastéis", Category: "Food", Amount: -6, Date: now, Location: &storage.Location{Latitude: &lat, Longitude: &lon, Place: "Lisbon"}},
+		storage.Expense{ID: "2", Name: "Hotel", Category: "Travel", Amount: -120, Date: now, Location: &storage.Location{Place: "Porto"}},
+		storage.Expense{ID: "3", Name: "Refund", Category: "Food", Amount: 6, Date: now, Location: &storage.Location{Latitude: &lat, Longitude: &lon}},
+		storage.Expense{ID: "4", Name: "Groceries", Category: "Food", Amount: -30, Date: now},
+	))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/expenses/geojson", nil)
+	w := httptest.NewRecorder()
+	handler.GetExpensesGeoJSON(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/geo+json" {
+		t.Errorf("Expected GeoJSON content type, got %s", ct)
+	}
+	var collection GeoJSONFeatureCollection
+	if err := json.NewDecoder(w.Body).Decode(&collection); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if collection.Type != "FeatureCollection" || len(collection.Features) != 1 {
+		t.Fatalf("Expected one feature, got %+v", collection)
+	}
+	feature := collection.Features[0]
+	if feature.Geometry.Coordinates != [2]float64{lon, lat} || feature.Properties.Place != "Lisbon" {
+		t.Errorf("Unexpected feature: %+v", feature)
+	}
+
+	invalid := storage.Expense{Name: "X", Category: "Food", Amount: -1, Date: now, Location: &storage.Location{Latitude: &lat}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected a latitude without a longitude to be rejected")
+	}
+}
+
+func TestRetention(t *testing.T) {
+	now := time.Now()
+	store := newTestStore(t,
+		storage.Expense{ID: "1", Name: "Old Rent", Category: "Housing", Amount: -900, Date: now.AddDate(-3, 0, 0)},
+		storage.Expense{ID: "2", Name: "Groceries", Category: "Food", Amount: -80, Date: now},
+	)
+	handler := NewHandler(store)
+
+	req := httptest.NewRequest(http.MethodPut, "/retention/edit", strings.NewReader(`101`))
+	w := httptest.NewRecorder()
+	handler.UpdateRetention(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for too many years, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/retention/edit", strings.NewReader(`2`))
+	w = httptest.NewRecorder()
+	handler.UpdateRetention(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"archived":"1"`) {
+		t.Fatalf("Expected one archived expense, got %d: %s", w.Code, w.Body.String())
+	}
+	expenses, _ := store.GetAllExpenses()
+	archived, _ := store.GetArchivedExpenses()
+	if len(expenses) != 1 || expenses[0].ID != "2" || len(archived) != 1 {
+		t.Errorf("Expected only the old expense to be archived, got %+v and %+v", expenses, archived)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/export/csv", nil)
+	w = httptest.NewRecorder()
+	handler.ExportCSV(w, req)
+	if strings.Contains(w.Body.String(), "Old Rent") {
+		t.Error("Expected archived expenses to be left out of the default export")
+	}
+	req = httptest.NewRequest(http.MethodGet, "/export/csv?archived=true", nil)
+	w = httptest.NewRecorder()
+	handler.ExportCSV(w, req)
+	if !strings.Contains(w.Body.String(), "Old Rent") || !strings.Contains(w.Body.String(), "Groceries") {
+		t.Errorf("Expected the export to include archived expenses, got %s", w.Body.String())
+	}
+}
+
+func TestAdminSettings(t *testing.T) {
+	store := newTestStore(t)
+	handler := NewHandler(store)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/settings", nil)
+	w := httptest.NewRecorder()
+	handler.AdminSettings(w, req)
+	var settings storage.RuntimeSettings
+	if err := json.NewDecoder(w.Body).Decode(&settings); err != nil {
+		t.Fatalf("Failed to decode settings: %v", err)
+	}
+	if !settings.Notifications.MQTT || settings.Schedules.ArchiveHours != 24 || settings.UI.DefaultTheme != "system" {
+		t.Errorf("Expected the defaults before anything is saved, got %+v", settings)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/api/admin/settings", strings.NewReader(`{"ui": {"defaultTheme": "sepia"}}`))
+	w = httptest.NewRecorder()
+	handler.AdminSettings(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown theme, got %d", w.Code)
+	}
+
+	body := `{"notifications": {"mqtt": false, "telegram": true}, "schedules": {"reportCheckMinutes": 15}, "ui": {"defaultTheme": "dark"}}`
+	req = httptest.NewRequest(http.MethodPut, "/api/admin/settings", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	handler.AdminSettings(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if saved, _ := store.GetRuntimeSettings(); saved.Notifications.MQTT || saved.Schedules.ArchiveHours != 24 || saved.UI.DefaultTheme != "dark" {
+		t.Errorf("Expected MQTT off and the archive schedule defaulted, got %+v", saved)
+	}
+	reportEmails, _ := findJob(JobReportEmails)
+	if got, _ := jobSchedule(reportEmails, handler.runtimeSettings().Schedules); got != "@every 15m" {
+		t.Errorf("Expected the report check interval to apply without a restart, got %v", got)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	handler := NewHandler(newTestStore(t))
+	runJob := func(name string) (int, JobStatus) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.RunJobNow(w, httptest.NewRequest(http.MethodPost, "/api/admin/jobs/run?name="+name, nil))
+		var status JobStatus
+		json.NewDecoder(w.Body).Decode(&status)
+		return w.Code, status
+	}
+
+	w := httptest.NewRecorder()
+	handler.GetJobs(w, httptest.NewRequest(http.MethodGet, "/api/admin/jobs", nil))
+	var statuses []JobStatus
+	if err := json.NewDecoder(w.Body).Decode(&statuses); err != nil {
+		t.Fatalf("Failed to decode jobs: %v", err)
+	}
+	if len(statuses) != len(jobs) || statuses[0].Name != JobReportEmails || statuses[0].Enabled || statuses[2].Schedule != "@every 24h" || !statuses[2].Enabled {
+		t.Errorf("Expected every job with report emails off without SMTP, got %+v", statuses)
+	}
+
+	if code, status := runJob(JobRetention); code != http.StatusOK || status.LastRun == nil || status.Failures != 0 || status.Running {
+		t.Errorf("Expected retention to run on request, got %d: %+v", code, status)
+	}
+	if code, _ := runJob("backups"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown job, got %d", code)
+	}
+	if code, _ := runJob(JobPriceRefresh); code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a job that isn't configured, got %d", code)
+	}
+
+	for _, body := range []string{`{"schedules": {"jobs": {"retention": "0 25 * * *"}}}`, `{"schedules": {"jobs": {"backups": "@daily"}}}`} {
+		w = httptest.NewRecorder()
+		handler.AdminSettings(w, httptest.NewRequest(http.MethodPut, "/api/admin/settings", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+	w = httptest.NewRecorder()
+	handler.AdminSettings(w, httptest.NewRequest(http.MethodPut, "/api/admin/settings", strings.NewReader(`{"schedules": {"jobs": {"retention": "0 3 * * *"}}}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	retention, _ := findJob(JobRetention)
+	spec, schedule := jobSchedule(retention, handler.runtimeSettings().Schedules)
+	if spec != "0 3 * * *" {
+		t.Errorf("Expected the cron schedule to replace the default, got %v", spec)
+	}
+
+	// a failed run is retried with a backoff, unless the schedule comes first
+	at := time.Date(2026, 3, 12, 2, 0, 0, 0, time.UTC)
+	s := newJobScheduler()
+	s.started = at
+	s.runs[JobRetention] = &jobRun{at: at, failures: 3}
+	if next := s.nextRun(retention, schedule); !next.Equal(at.Add(4 * time.Minute)) {
+		t.Errorf("Expected a retry after 4m, got %v", next)
+	}
+	s.runs[JobRetention].failures = 8
+	if next := s.nextRun(retention, schedule); !next.Equal(time.Date(2026, 3, 12, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the scheduled run before the backoff, got %v", next)
+	}
+	if jobBackoff(20) != maxJobBackoff {
+		t.Errorf("Expected the backoff to be capped at %v, got %v", maxJobBackoff, jobBackoff(20))
+	}
+}
+
+func TestMonthlyAggregates(t *testing.T) {
+	newYork := time.FixedZone("EST", -5*60*60)
+	expenses := []storage.Expense{
+		{Name: "Rent", Category: "Housing", Amount: -900, Date: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "Late Dinner", Category: "Food", Amount: -40, Date: time.Date(2026, 1, 31, 20, 0, 0, 0, newYork)}, // Feb 1 in UTC
+		{Name: "Refund", Category: "Food", Amount: 15, Date: time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC)},
+		{Name: "Groceries", Category: "Food", SubCategory: "Supermarket", Amount: -60, Date: time.Date(2026, 2, 11, 0, 0, 0, 0, time.UTC)},
+		{Name: "Salary", Category: "Income", Amount: 3000, Date: time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC)},
+		{Name: "Returned Shoes", Category: "Shopping", Amount: 80, RefundOf: "shoes", Date: time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)}, // alone in March
+	}
+	aggregates := storage.AggregateMonthly(expenses)
+	if len(aggregates) != 5 || aggregates[1].Period != "2026-02" || aggregates[1].Income != 15 || aggregates[1].Expenses != 40 {
+		t.Errorf("Expected the late dinner and refund in one February aggregate, got %+v", aggregates)
+	}
+	if aggregates[4].Period != "2026-03" || aggregates[4].Expenses != -80 {
+		t.Errorf("Expected the March refund as negative spending, got %+v", aggregates[4])
+	}
+
+	fromExpenses, fromAggregates := expenseSummarizer(expenses), aggregateSummarizer(aggregates)
+	monthly := periods.Default()
+	for _, period := range []periods.Period{
+		monthly.Containing(time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)),
+		monthly.Containing(time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC)),
+		monthly.Containing(time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)),
+		periods.FiscalYear(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.January),
+	} {
+		if !wholeMonths(period) {
+			t.Errorf("Expected %s to be whole months", period.Label())
+		}
+		income, spent, categories := fromExpenses(period)
+		aggIncome, aggSpent, aggCategories := fromAggregates(period)
+		if math.Abs(income-aggIncome) > 0.001 || math.Abs(spent-aggSpent) > 0.001 || len(categories) != len(aggCategories) {
+			t.Errorf("%s: expected %v, %v, %v from aggregates, got %v, %v, %v", period.Label(), income, spent, categories, aggIncome, aggSpent, aggCategories)
+		}
+		for name, total := range categories {
+			if math.Abs(aggCategories[name]-total) > 0.001 {
+				t.Errorf("%s: expected %s to total %v, got %v", period.Label(), name, total, aggCategories[name])
+			}
+		}
+	}
+
+	midMonth := periods.Config{Type: periods.Monthly, StartDay: 15}.Containing(time.Date(2026, 2, 20, 0, 0, 0, 0, time.UTC))
+	weekly := periods.Config{Type: periods.Weekly}.Containing(time.Date(2026, 2, 20, 0, 0, 0, 0, time.UTC))
+	if wholeMonths(midMonth) || wholeMonths(weekly) {
+		t.Error("Expected periods not starting on the 1st to need all expenses")
+	}
+}
+
+// TestAPIv1 checks that versioned routes wrap responses in the envelope and paginate lists
+func TestAPIv1(t *testing.T) {
+	now := time.Now()
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "1", Name: "Coffee", Category: "Food", Amount: -4, Date: now},
+		storage.Expense{ID: "2", Name: "Lunch", Category: "Food", Amount: -12, Date: now},
+		storage.Expense{ID: "3", Name: "Bus", Category: "Travel", Amount: -3, Date: now},
+	))
+	routes := http.NewServeMux()
+	routes.HandleFunc("/expenses", handler.GetExpenses)
+	routes.HandleFunc("/expense", handler.AddExpense)
+	v1 := APIv1(routes)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/expenses?page=2&per_page=2", nil)
+	w := httptest.NewRecorder()
+	v1.ServeHTTP(w, req)
+	var page struct {
+		Data       []storage.Expense `json:"data"`
+		Pagination Pagination        `json:"pagination"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if w.Code != http.StatusOK || len(page.Data) != 1 || page.Pagination != (Pagination{Page: 2, PerPage: 2, Total: 3, TotalPages: 2}) {
+		t.Errorf("Expected the last of two pages, got %d: %+v", w.Code, page)
+	}
+
+	// a page far past the end, where (page-1)*per_page would overflow, is empty
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/expenses?page=9223372036854775807&per_page=100", nil)
+	w = httptest.NewRecorder()
+	v1.ServeHTTP(w, req)
+	page.Data = nil
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if w.Code != http.StatusOK || page.Data == nil || len(page.Data) != 0 || page.Pagination.Total != 3 {
+		t.Errorf("Expected an empty page, got %d: %+v", w.Code, page)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/api/v1/expense", strings.NewReader(`{"name": "Tea"}`))
+	w = httptest.NewRecorder()
+	v1.ServeHTTP(w, req)
+	var envelope map[string]json.RawMessage
+	if err := json.NewDecoder(w.Body).Decode(&envelope); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if w.Code != http.StatusBadRequest || string(envelope["code"]) != `"VALIDATION_FAILED"` || len(envelope["error"]) < 3 || !strings.Contains(string(envelope["data"]), `"category"`) {
+		t.Errorf("Expected an error envelope with the invalid field, got %d: %v", w.Code, envelope)
+	}
+
+	for path, want := range map[string]int{
+		"/api/v1/expenses?per_page=1000": http.StatusBadRequest,
+		"/api/v1/feed.atom":              http.StatusNotFound,
+	} {
+		w = httptest.NewRecorder()
+		v1.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("Expected status %d for %s, got %d", want, path, w.Code)
+		}
+	}
+}
+
+// TestBackfillRecurringExpense checks that a deleted occurrence is listed in a dry run and recreated otherwise
+func TestBackfillRecurringExpense(t *testing.T) {
+	store := newTestStore(t)
+	rule := storage.RecurringExpense{ID: "rent", Name: "Rent", Category: "Rent", Amount: -900, StartDate: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Interval: "monthly", Occurrences: 3}
+	if err := store.AddRecurringExpense(rule); err != nil {
+		t.Fatalf("Failed to add recurring expense: %v", err)
+	}
+	expenses, _ := store.GetAllExpenses()
+	if len(expenses) != 3 {
+		t.Fatalf("Expected 3 occurrences, got %d", len(expenses))
+	}
+	if err := store.RemoveExpense(expenses[1].ID); err != nil {
+		t.Fatalf("Failed to remove occurrence: %v", err)
+	}
+	handler := NewHandler(store)
+
+	backfill := func(path string) (int, BackfillResult) {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		w := httptest.NewRecorder()
+		handler.BackfillRecurringExpense(w, req)
+		var result BackfillResult
+		json.NewDecoder(w.Body).Decode(&result)
+		return w.Code, result
+	}
+	code, result := backfill("/api/recurring/rent/backfill?dryRun=true")
+	if code != http.StatusOK || !result.DryRun || result.Missing != 1 || !result.Expenses[0].Date.Equal(expenses[1].Date) {
+		t.Fatalf("Expected the February occurrence to be missing, got %d: %+v", code, result)
+	}
+	if expenses, _ := store.GetAllExpenses(); len(expenses) != 2 {
+		t.Errorf("Expected a dry run not to add anything, got %d expenses", len(expenses))
+	}
+
+	if code, result = backfill("/api/recurring/rent/backfill"); code != http.StatusOK || result.Missing != 1 {
+		t.Fatalf("Expected one occurrence to be created, got %d: %+v", code, result)
+	}
+	if code, result = backfill("/api/recurring/rent/backfill"); result.Missing != 0 {
+		t.Errorf("Expected nothing missing after the backfill, got %+v", result)
+	}
+	if code, _ = backfill("/api/recurring/nope/backfill"); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown recurring expense, got %d", code)
+	}
+}
+
+func TestOverrideRecurringOccurrence(t *testing.T) {
+	store := newTestStore(t)
+	rule := storage.RecurringExpense{ID: "rent", Name: "Rent", Category: "Rent", Amount: -900, StartDate: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Interval: "monthly", Occurrences: 3}
+	if err := store.AddRecurringExpense(rule); err != nil {
+		t.Fatalf("Failed to add recurring expense: %v", err)
+	}
+	handler := NewHandler(store)
+	override := func(body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/recurring-expense/occurrence?id=rent", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.OverrideRecurringOccurrence(w, req)
+		return w.Code
+	}
+	amounts := func() map[string]float64 {
+		expenses, _ := store.GetAllExpenses()
+		amounts := make(map[string]float64)
+		for _, expense := range expenses {
+			amounts[expense.Date.Format("2006-01-02")] = expense.Amount
+		}
+		return amounts
+	}
+
+	before, _ := store.GetAllExpenses()
+	if code := override(`{"date": "2026-02-01", "skip": true}`); code != http.StatusOK {
+		t.Fatalf("Expected status 200 for a skip, got %d", code)
+	}
+	if code := override(`{"date": "2026-03-01", "amount": -950}`); code != http.StatusOK {
+		t.Fatalf("Expected status 200 for an amount override, got %d", code)
+	}
+	if got := amounts(); len(got) != 2 || got["2026-01-01"] != -900 || got["2026-03-01"] != -950 {
+		t.Fatalf("Expected February skipped and March at -950, got %v", got)
+	}
+	after, _ := store.GetAllExpenses()
+	if !slices.ContainsFunc(before, func(e storage.Expense) bool { return e.ID == after[1].ID }) {
+		t.Error("Expected the overridden occurrence to be changed in place")
+	}
+
+	// editing the rule without overrides keeps them
+	rule.Name = "Apartment"
+	if err := store.UpdateRecurringExpense("rent", rule, true); err != nil {
+		t.Fatalf("Failed to update recurring expense: %v", err)
+	}
+	if got := amounts(); len(got) != 2 || got["2026-03-01"] != -950 {
+		t.Errorf("Expected the overrides to survive an edit of the rule, got %v", got)
+	}
+	if expenses, _ := store.GetAllExpenses(); expenses[0].Name != "Apartment" || expenses[0].RecurringID != "rent" {
+		t.Errorf("Expected the occurrences to follow the edited rule, got %+v", expenses[0])
+	}
+
+	if code := override(`{"date": "2026-02-01"}`); code != http.StatusOK {
+		t.Fatalf("Expected status 200 when restoring an occurrence, got %d", code)
+	}
+	if got := amounts(); len(got) != 3 || got["2026-02-01"] != -900 {
+		t.Errorf("Expected February to be restored, got %v", got)
+	}
+	if code := override(`{"date": "2026-02-15", "skip": true}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a day without an occurrence, got %d", code)
+	}
+}
+
+func TestEditedRecurringInstanceSurvivesRuleUpdate(t *testing.T) {
+	store := newTestStore(t)
+	rule := storage.RecurringExpense{ID: "gym", Name: "Gym", Category: "Health", Amount: -40, StartDate: time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC), Interval: "monthly", Occurrences: 3}
+	if err := store.AddRecurringExpense(rule); err != nil {
+		t.Fatalf("Failed to add recurring expense: %v", err)
+	}
+	expenses, _ := store.GetAllExpenses()
+	var february storage.Expense
+	for _, expense := range expenses {
+		if expense.Date.Month() == time.February {
+			february = expense
+		}
+	}
+	handler := NewHandler(store)
+
+	// the web UI doesn't send the recurring ID when editing
+	body := `{"name": "Gym", "category": "Health", "amount": -55, "date": "2026-02-07T00:00:00Z", "notes": "with sauna"}`
+	req := httptest.NewRequest(http.MethodPut, "/expense/edit?id="+february.ID, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.EditExpense(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	rule.Amount = -45
+	if err := store.UpdateRecurringExpense("gym", rule, true); err != nil {
+		t.Fatalf("Failed to update recurring expense: %v", err)
+	}
+	expenses, _ = store.GetAllExpenses()
+	if len(expenses) != 3 {
+		t.Fatalf("Expected the edited instance to hold its occurrence, got %d expenses", len(expenses))
+	}
+	for _, expense := range expenses {
+		if expense.RecurringID != "gym" {
+			t.Errorf("Expected every instance to stay part of the rule, got %+v", expense)
+		}
+		switch {
+		case expense.ID == february.ID:
+			if expense.Amount != -55 || expense.Notes != "with sauna" || expense.Date.Day() != 7 {
+				t.Errorf("Expected the edited instance to be kept, got %+v", expense)
+			}
+		case expense.Amount != -45:
+			t.Errorf("Expected the other instances to follow the rule, got %+v", expense)
+		}
+	}
+}
+
+func TestCurrencies(t *testing.T) {
+	store := newTestStore(t)
+	handler := NewHandler(store)
+	do := func(h http.HandlerFunc, method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+
+	if w := do(handler.UpdateCustomCurrencies, http.MethodPut, "/currencies/edit", `[{"code": "usd", "name": "Dollars"}]`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 when redefining an ISO currency, got %d", w.Code)
+	}
+	if w := do(handler.UpdateCustomCurrencies, http.MethodPut, "/currencies/edit", `[{"code": "PTS", "name": "Airline Points", "symbol": "pts", "decimals": 0}]`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var currencies []storage.Currency
+	json.NewDecoder(do(handler.GetCurrencies, http.MethodGet, "/currencies", "").Body).Decode(&currencies)
+	for _, code := range []string{"nok", "kwd", "pts"} {
+		if !slices.ContainsFunc(currencies, func(c storage.Currency) bool { return c.Code == code }) {
+			t.Errorf("Expected %s among the currencies", code)
+		}
+	}
+
+	expense := `{"name": "Lounge", "category": "Food", "amount": -2500, "currency": "%s", "date": "2026-03-01T00:00:00Z"}`
+	if w := do(handler.AddExpense, http.MethodPut, "/expense", fmt.Sprintf(expense, "PTS")); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for a custom currency, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(handler.AddExpense, http.MethodPut, "/expense", fmt.Sprintf(expense, "zzz")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown currency, got %d", w.Code)
+	}
+
+	if w := do(handler.UpdateCurrency, http.MethodPut, "/currency/edit", `"pts"`); w.Code != http.StatusOK {
+		t.Fatalf("Expected a custom currency to be the display currency, got %d", w.Code)
+	}
+	if w := do(handler.UpdateCustomCurrencies, http.MethodPut, "/currencies/edit", `[]`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 when removing the display currency, got %d", w.Code)
+	}
+}
+
+func TestCategoryAmountLimit(t *testing.T) {
+	store := newTestStore(t)
+	if err := store.UpdateCategoryMeta(map[string]storage.CategoryMeta{"Food": {MaxAmount: 100}}); err != nil {
+		t.Fatalf("Failed to set the limit: %v", err)
+	}
+	handler := NewHandler(store)
+	add := func(path string, amount float64) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"name": "Dinner", "category": "Food", "amount": %v, "date": "2026-03-01T00:00:00Z"}`, amount)
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.AddExpense(w, req)
+		return w
+	}
+
+	if w := add("/expense", -45); w.Code != http.StatusOK {
+		t.Errorf("Expected an amount within the limit to be saved, got %d", w.Code)
+	}
+	w := add("/expense", -4500)
+	var limitErr LimitErrorResponse
+	json.NewDecoder(w.Body).Decode(&limitErr)
+	if w.Code != http.StatusConflict || limitErr.Limit != 100 {
+		t.Errorf("Expected status 409 with the limit, got %d: %+v", w.Code, limitErr)
+	}
+	if w := add("/expense?confirm=true", -4500); w.Code != http.StatusOK {
+		t.Errorf("Expected a confirmed amount to be saved, got %d", w.Code)
+	}
+	if expenses, _ := store.GetAllExpenses(); len(expenses) != 2 {
+		t.Errorf("Expected 2 expenses, got %d", len(expenses))
+	}
+	if err := storage.ValidateCategoryMeta(map[string]storage.CategoryMeta{"Food": {MaxAmount: -1}}); err == nil {
+		t.Error("Expected a negative limit to be rejected")
+	}
+}
+
+// fakeOCR returns fixed text for any image
+type fakeOCR string
+
+func (f fakeOCR) Recognize(ctx context.Context, image []byte, contentType string) (string, error) {
+	return string(f), nil
+}
+
+func TestScanReceipt(t *testing.T) {
+	store := newTestStoreWithCategories(t, "Food", "Groceries")
+	handler := NewHandler(store)
+	scan := func() (*httptest.ResponseRecorder, ReceiptScan) {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, _ := writer.CreateFormFile("receipt", "receipt.jpg")
+		part.Write([]byte("\xff\xd8\xff\xe0 not really a jpeg"))
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/receipts/scan", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		handler.ScanReceipt(w, req)
+		var result ReceiptScan
+		json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&result)
+		return w, result
+	}
+
+	if w, _ := scan(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without an OCR engine, got %d", w.Code)
+	}
+
+	handler.ocr = fakeOCR("  **\nCorner Grocery\n12 Main St\n14.03.2026 10:42\nMilk 2.49\nBread 3.10\nSubtotal 5.59\nTax 0.45\nTOTAL 6.04\nCash 10.00\n")
+	w, result := scan()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	draft := result.Expense
+	if draft.Name != "Corner Grocery" || draft.Amount != -6.04 || !draft.Date.Equal(time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)) || len(result.Missing) != 0 {
+		t.Errorf("Unexpected draft: %+v, missing %v", draft, result.Missing)
+	}
+	if expenses, _ := store.GetAllExpenses(); len(expenses) != 0 {
+		t.Errorf("Expected the draft not to be saved, got %d expenses", len(expenses))
+	}
+
+	handler.ocr = fakeOCR("Cafe Blue\n2 x Latte 9.00\nThank you\n")
+	if _, result := scan(); result.Expense.Amount != -9 || !slices.Equal(result.Missing, []string{"date"}) {
+		t.Errorf("Expected the largest amount and a missing date, got %+v, missing %v", result.Expense, result.Missing)
+	}
+
+	for text, want := range map[string]string{
+		"Date: 2026-03-14":     "2026-03-14",
+		"03/14/2026":           "2026-03-14",
+		"14/03/26":             "2026-03-14",
+		"Mar 14, 2026":         "2026-03-14",
+		"14 March 2026":        "2026-03-14",
+		"Receipt #1234 no day": "0001-01-01",
+	} {
+		if got := parseReceiptDate(text).Format("2006-01-02"); got != want {
+			t.Errorf("parseReceiptDate(%q) = %s, want %s", text, got, want)
+		}
+	}
+}
+
+// TestMagicLinkLogin checks that a login link works once and starts a session with the user's role
+func TestMagicLinkLogin(t *testing.T) {
+	t.Setenv("ACCESS_ROLES", "Mom@Example.com:editor")
+	t.Setenv("ACCESS_AUTH", "magic-link")
+	t.Setenv("SMTP_HOST", "smtp.invalid")
+	store := newTestStore(t)
+	// links aren't built from the Host header, which the requester picks
+	for _, publicURL := range []string{"", "budget.example.com", "ftp://budget.example.com"} {
+		t.Setenv("PUBLIC_URL", publicURL)
+		if _, err := AccessControlFromEnv(store); err == nil {
+			t.Errorf("Expected an error for PUBLIC_URL %q", publicURL)
+		}
+	}
+	t.Setenv("PUBLIC_URL", "https://budget.example.com/")
+	access, err := AccessControlFromEnv(store)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth/login", access.RequestLoginLink)
+	mux.HandleFunc("/api/auth/verify", access.VerifyLoginLink)
+	mux.HandleFunc("/api/auth/logout", access.Logout)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	server := access.Middleware(mux)
+	do := func(method string, path string, body string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodGet, "/expenses", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a session, got %d", w.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/table", nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+		t.Errorf("Expected pages to redirect to the login page, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), tenantPrefixContextKey{}, "/t/smiths")))
+	if w.Header().Get("Location") != "/t/smiths/login" {
+		t.Errorf("Expected the redirect to keep the tenant prefix, got %q", w.Header().Get("Location"))
+	}
+	if w := do(http.MethodPost, "/api/auth/login", `{"email": "stranger@example.com"}`, nil); w.Code != http.StatusOK {
+		t.Errorf("Expected unknown addresses to get the same response, got %d", w.Code)
+	}
+
+	link := storage.LoginToken{Hash: hashLoginToken("emailed-token"), Kind: storage.LoginLink, User: "mom@example.com", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Minute)}
+	if err := store.AddLoginToken(link); err != nil {
+		t.Fatalf("Failed to add login link: %v", err)
+	}
+	w = do(http.MethodPost, "/api/auth/verify", `{"token": "emailed-token"}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HTTP-only session cookie, got %+v", cookies)
+	}
+	session := cookies[0]
+	if w := do(http.MethodPost, "/api/auth/verify", `{"token": "emailed-token"}`, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a used link to be rejected, got %d", w.Code)
+	}
+
+	// a link opened twice at once still starts one session
+	link.Hash = hashLoginToken("double-clicked")
+	if err := store.AddLoginToken(link); err != nil {
+		t.Fatalf("Failed to add login link: %v", err)
+	}
+	var signedIn atomic.Int32
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := do(http.MethodPost, "/api/auth/verify", `{"token": "double-clicked"}`, nil); w.Code == http.StatusOK {
+				signedIn.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if signedIn.Load() != 1 {
+		t.Errorf("Expected one request to use the link, got %d", signedIn.Load())
+	}
+
+	if w := do(http.MethodPut, "/expense", "", session); w.Code != http.StatusOK {
+		t.Errorf("Expected the editor to add expenses, got %d", w.Code)
+	}
+	if w := do(http.MethodPut, "/categories/edit", "", session); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for an admin route, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/auth/logout", "", session); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 on logout, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/expenses", "", session); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the session to end on logout, got %d", w.Code)
+	}
+
+	t.Setenv("SMTP_HOST", "")
+	if _, err := AccessControlFromEnv(store); err == nil {
+		t.Error("Expected an error for magic links without SMTP")
+	}
+}
+
+// TestSessions checks listing and revoking sessions, and that sessions rotate when a role changes
+func TestSessions(t *testing.T) {
+	t.Setenv("ACCESS_ROLES", "mom@example.com:editor,dad@example.com:admin")
+	t.Setenv("ACCESS_AUTH", "magic-link")
+	t.Setenv("SMTP_HOST", "smtp.invalid")
+	t.Setenv("PUBLIC_URL", "https://budget.example.com")
+	store := newTestStore(t)
+	serve := func() http.Handler {
+		access, err := AccessControlFromEnv(store)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/auth/verify", access.VerifyLoginLink)
+		mux.HandleFunc("/api/auth/sessions", access.Sessions)
+		mux.HandleFunc("/api/auth/sessions/revoke", access.RevokeSession)
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		return access.Middleware(mux)
+	}
+	server := serve()
+	do := func(method string, path string, cookie *http.Cookie, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("User-Agent", "test-browser")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+	signIn := func(user string, token string) *http.Cookie {
+		link := storage.LoginToken{Hash: hashLoginToken(token), Kind: storage.LoginLink, User: user, ExpiresAt: time.Now().Add(time.Minute)}
+		if err := store.AddLoginToken(link); err != nil {
+			t.Fatalf("Failed to add login link: %v", err)
+		}
+		w := do(http.MethodPost, "/api/auth/verify", nil, fmt.Sprintf(`{"token": %q}`, token))
+		if w.Code != http.StatusOK || len(w.Result().Cookies()) != 1 {
+			t.Fatalf("Failed to sign in: %d %s", w.Code, w.Body.String())
+		}
+		return w.Result().Cookies()[0]
+	}
+	list := func(path string, cookie *http.Cookie) []SessionInfo {
+		w := do(http.MethodGet, path, cookie, "")
+		var sessions []SessionInfo
+		json.NewDecoder(w.Body).Decode(&sessions)
+		return sessions
+	}
+
+	phone := signIn("mom@example.com", "link-1")
+	laptop := signIn("mom@example.com", "link-2")
+	dad := signIn("dad@example.com", "link-3")
+	sessions := list("/api/auth/sessions", laptop)
+	if len(sessions) != 2 || sessions[0].Device != "test-browser" || sessions[0].IP == "" || sessions[0].Current == sessions[1].Current {
+		t.Fatalf("Unexpected sessions: %+v", sessions)
+	}
+	if w := do(http.MethodGet, "/api/auth/sessions?all=true", laptop, ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a non-admin listing everyone's sessions, got %d", w.Code)
+	}
+	if sessions := list("/api/auth/sessions?all=true", dad); len(sessions) != 3 {
+		t.Errorf("Expected an admin to see 3 sessions, got %d", len(sessions))
+	}
+
+	// the lost phone is revoked from the laptop
+	phoneID := sessions[0].ID
+	if sessions[0].Current {
+		phoneID = sessions[1].ID
+	}
+	if w := do(http.MethodDelete, "/api/auth/sessions/revoke?id="+phoneID, laptop, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, "/expenses", phone, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked session to stop working, got %d", w.Code)
+	}
+	if w := do(http.MethodDelete, "/api/auth/sessions/revoke?others=true", dad, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/expenses", laptop, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected revoking dad's other sessions to leave mom's, got %d", w.Code)
+	}
+
+	// mom becomes an admin: the old token is replaced on the next request
+	t.Setenv("ACCESS_ROLES", "mom@example.com:admin,dad@example.com:admin")
+	server = serve()
+	w := do(http.MethodPut, "/categories/edit", laptop, "")
+	if w.Code != http.StatusOK || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("Expected the session to rotate, got %d with %d cookies", w.Code, len(w.Result().Cookies()))
+	}
+	rotated := w.Result().Cookies()[0]
+	if w := do(http.MethodGet, "/expenses", laptop, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the old session token to stop working, got %d", w.Code)
+	}
+	if w := do(http.MethodPut, "/categories/edit", rotated, ""); w.Code != http.StatusOK || len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected the rotated session to work without rotating again, got %d", w.Code)
+	}
+}
+
+// TestAPIKeys checks that bearer keys act as their user, and stop working once revoked
+func TestAPIKeys(t *testing.T) {
+	t.Setenv("ACCESS_ROLES", "alice:admin,bob:viewer")
+	store := newTestStore(t)
+	access, err := AccessControlFromEnv(store)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth/keys", access.APIKeys)
+	mux.HandleFunc("/api/auth/keys/revoke", access.RevokeAPIKey)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(requestUser(r)))
+	})
+	server := access.Middleware(mux)
+	do := func(method string, path string, user string, key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if user != "" {
+			req.Header.Set("Remote-User", user)
+		}
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/auth/keys", "bob", "", `{"name": "laptop cli"}`)
+	var created CreateAPIKeyResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || w.Code != http.StatusOK || created.Key == "" {
+		t.Fatalf("Failed to create API key: %d %+v", w.Code, created)
+	}
+	if created.ID == created.Key || created.ExpiresAt.Sub(created.CreatedAt) != defaultAPIKeyDays*24*time.Hour {
+		t.Errorf("Unexpected API key: %+v", created.APIKeyInfo)
+	}
+	if w := do(http.MethodGet, "/expenses", "", created.Key, ""); w.Code != http.StatusOK || w.Body.String() != "bob" {
+		t.Errorf("Expected the key to act as bob, got %d %q", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPut, "/expense", "", created.Key, ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the key to have bob's viewer role, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/expenses", "alice", "not-a-key", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for an unknown key, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/auth/keys", "bob", "", `{"name": "forever", "days": 100000}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for too many days, got %d", w.Code)
+	}
+
+	var keys []APIKeyInfo
+	json.NewDecoder(do(http.MethodGet, "/api/auth/keys", "", created.Key, "").Body).Decode(&keys)
+	if len(keys) != 1 || keys[0].Name != "laptop cli" || keys[0].User != "bob" {
+		t.Fatalf("Unexpected API keys: %+v", keys)
+	}
+	if w := do(http.MethodGet, "/api/auth/keys?all=true", "bob", "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a non-admin listing everyone's keys, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/auth/keys", "alice", "", ""); w.Body.String() != "[]\n" {
+		t.Errorf("Expected alice to have no keys, got %s", w.Body.String())
+	}
+
+	// an admin revokes bob's key
+	if w := do(http.MethodDelete, "/api/auth/keys/revoke?id="+created.ID, "alice", "", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, "/expenses", "", created.Key, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked key to stop working, got %d", w.Code)
+	}
+}
+
+// TestPreferences checks that each user's preferences are kept apart and returned with the config
+func TestPreferences(t *testing.T) {
+	t.Setenv("ACCESS_ROLES", "alice:viewer,bob:admin")
+	access, err := AccessControlFromEnv(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	handler := NewHandler(newTestStoreWithCategories(t, "Food", "Rent"))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", handler.GetConfig)
+	mux.HandleFunc("/api/preferences", handler.GetPreferences)
+	mux.HandleFunc("/api/preferences/edit", handler.UpdatePreferences)
+	server := access.Middleware(mux)
+	do := func(user string, method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Remote-User", user)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("alice", http.MethodPut, "/api/preferences/edit", `{"theme": "dark", "locale": "de-DE", "firstDayOfWeek": 1, "landingPage": "table", "defaultCategory": "Food"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected a viewer to save their preferences, got %d: %s", w.Code, w.Body.String())
+	}
+	var config struct {
+		Currency    string              `json:"currency"`
+		Preferences storage.Preferences `json:"preferences"`
+	}
+	json.NewDecoder(do("alice", http.MethodGet, "/config", "").Body).Decode(&config)
+	want := storage.Preferences{Theme: "dark", Locale: "de-DE", FirstDayOfWeek: 1, LandingPage: "table", DefaultCategory: "Food"}
+	if config.Preferences != want || config.Currency == "" {
+		t.Errorf("Expected alice's preferences with the config, got %+v", config)
+	}
+	var bobs storage.Preferences
+	json.NewDecoder(do("bob", http.MethodGet, "/api/preferences", "").Body).Decode(&bobs)
+	if bobs != (storage.Preferences{}) {
+		t.Errorf("Expected bob to have no preferences, got %+v", bobs)
+	}
+
+	for _, body := range []string{
+		`{"theme": "neon"}`,
+		`{"locale": "not a locale"}`,
+		`{"firstDayOfWeek": 7}`,
+		`{"landingPage": "/admin"}`,
+		`{"defaultCategory": "Travel"}`,
+	} {
+		if w := do("bob", http.MethodPut, "/api/preferences/edit", body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+}
+
+// TestConfigRedaction checks that a viewer sees neither the secrets of the config nor what only
+// admins manage, through the config or the change feed
+func TestConfigRedaction(t *testing.T) {
+	t.Setenv("ACCESS_ROLES", "alice:viewer,bob:admin")
+	access, err := AccessControlFromEnv(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store := newTestStoreWithCategories(t, "Food", "Rent")
+	now := time.Now().UTC()
+	if err := store.UpdateShares([]storage.Share{{Token: "share-token-1", Report: "year", Year: 2026, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}}); err != nil {
+		t.Fatalf("Failed to add share: %v", err)
+	}
+	if err := store.UpdateSavedReports([]storage.SavedReport{{Name: "Weekly", Schedule: "weekly", Webhook: "https://hooks.example.com/webhook-key-1"}}); err != nil {
+		t.Fatalf("Failed to add saved report: %v", err)
+	}
+	if err := store.UpdateWalletDevices([]storage.WalletDevice{{DeviceID: "phone", PushToken: "push-token-1", SerialNumber: "pass", RegisteredAt: now}}); err != nil {
+		t.Fatalf("Failed to add wallet device: %v", err)
+	}
+	if err := store.UpdateReportSettings(storage.ReportSettings{Enabled: true, Recipients: []string{"carol@example.com"}}); err != nil {
+		t.Fatalf("Failed to update report settings: %v", err)
+	}
+	if err := store.UpdatePreferences("bob", storage.Preferences{Locale: "fr-CA"}); err != nil {
+		t.Fatalf("Failed to update preferences: %v", err)
+	}
+	handler := NewHandler(store)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", handler.GetConfig)
+	mux.HandleFunc("/api/changes", handler.GetChanges)
+	mux.HandleFunc("/api/shares", handler.Shares)
+	mux.HandleFunc("/reports", handler.GetReportSettings)
+	server := access.Middleware(mux)
+	do := func(user string, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Remote-User", user)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/api/shares", "/reports"} {
+		if w := do("alice", path); w.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 for a viewer on %s, got %d", path, w.Code)
+		}
+	}
+	for _, path := range []string{"/config", "/api/changes"} {
+		w := do("alice", path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d: %s", path, w.Code, w.Body.String())
+		}
+		for _, secret := range []string{"share-token-1", "webhook-key-1", "push-token-1", "carol@example.com", "fr-CA"} {
+			if strings.Contains(w.Body.String(), secret) {
+				t.Errorf("Expected %s to leave out %s, got %s", path, secret, w.Body.String())
+			}
+		}
+	}
+	if w := do("bob", "/api/shares"); !strings.Contains(w.Body.String(), "share-token-1") {
+		t.Errorf("Expected an admin to see the shares, got %s", w.Body.String())
+	}
+}
+
+// TestBasicPages checks that the no-JavaScript pages add, list, and delete expenses through forms
+func TestBasicPages(t *testing.T) {
+	store := newTestStoreWithCategories(t, "Food", "Rent")
+	if err := store.UpdateCategoryMeta(map[string]storage.CategoryMeta{"Rent": {MaxAmount: 1000}}); err != nil {
+		t.Fatalf("Failed to set the limit: %v", err)
+	}
+	handler := NewHandler(store)
+	post := func(serve http.HandlerFunc, path string, form url.Values, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		serve(w, req)
+		return w
+	}
+	form := url.Values{"name": {"Groceries"}, "category": {"Food"}, "type": {"expense"}, "amount": {"42.5"}, "date": {"2026-03-05"}, "tags": {"weekly, market"}}
+
+	if w := post(handler.ServeBasicEdit, "/basic/edit", form, "https://evil.example"); w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a cross-site post, got %d", w.Code)
+	}
+	w := post(handler.ServeBasicEdit, "/basic/edit", form, "")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/basic/table?date=2026-03-05" {
+		t.Fatalf("Expected a redirect to the table, got %d %s: %s", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+	expenses, _ := store.GetAllExpenses()
+	if len(expenses) != 1 || expenses[0].Amount != -42.5 || !slices.Equal(expenses[0].Tags, []string{"weekly", "market"}) {
+		t.Fatalf("Expected the expense to be saved, got %+v", expenses)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/basic/table?date=2026-03-10", nil)
+	w = httptest.NewRecorder()
+	handler.ServeBasicTable(w, req)
+	if body := w.Body.String(); !strings.Contains(body, "Groceries") || !strings.Contains(body, "/basic/delete?id="+expenses[0].ID) {
+		t.Errorf("Expected the table to list the expense, got %s", body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/basic/edit?id="+expenses[0].ID, nil)
+	w = httptest.NewRecorder()
+	handler.ServeBasicEdit(w, req)
+	if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, `value="Groceries"`) || !strings.Contains(body, `value="42.5"`) {
+		t.Errorf("Expected the edit form prefilled, got %d: %s", w.Code, body)
+	}
+
+	rent := url.Values{"name": {"Rent"}, "category": {"Rent"}, "type": {"expense"}, "amount": {"1500"}, "date": {"2026-03-01"}}
+	w = post(handler.ServeBasicEdit, "/basic/edit", rent, "")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `name="confirm"`) {
+		t.Errorf("Expected the form again with a confirmation above the limit, got %d", w.Code)
+	}
+	rent.Set("confirm", "true")
+	if w := post(handler.ServeBasicEdit, "/basic/edit", rent, ""); w.Code != http.StatusSeeOther {
+		t.Errorf("Expected a confirmed amount to be saved, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/basic/summary?date=2026-03-10", nil)
+	w = httptest.NewRecorder()
+	handler.ServeBasicSummary(w, req)
+	if body := w.Body.String(); !strings.Contains(body, "$1,542.50") || !strings.Contains(body, "March 2026") {
+		t.Errorf("Expected the summary totals, got %s", body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/basic/delete?id="+expenses[0].ID, nil)
+	w = httptest.NewRecorder()
+	handler.ServeBasicDelete(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Delete Groceries") {
+		t.Errorf("Expected the delete confirmation, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := post(handler.ServeBasicDelete, "/basic/delete", url.Values{"id": {expenses[0].ID}}, ""); w.Code != http.StatusSeeOther {
+		t.Errorf("Expected a redirect after deleting, got %d", w.Code)
+	}
+	if _, err := store.GetExpense(expenses[0].ID); err == nil {
+		t.Error("Expected the expense to be deleted")
+	}
+}
+
+// TestBranding checks that the pages carry the instance's name, logo, and footer, escaped
+func TestBranding(t *testing.T) {
+	store := newTestStore(t)
+	settings := storage.DefaultRuntimeSettings()
+	settings.UI.LogoPath = "javascript:alert(1)"
+	if err := storage.ValidateRuntimeSettings(&settings); err == nil {
+		t.Error("Expected a javascript: logo to be rejected")
+	}
+	settings.UI = storage.UISettings{InstanceName: " Smith Family ", LogoPath: "/pwa/icon-512.png", FooterText: "Run by <Sam>"}
+	if err := storage.ValidateRuntimeSettings(&settings); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := store.UpdateRuntimeSettings(settings); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	handler := NewHandler(store)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeDashboard(w, req)
+	body := w.Body.String()
+	for _, want := range []string{"<title>Smith Family Dashboard</title>", `src="/pwa/icon-512.png"`, "Run by &lt;Sam&gt;", `placeholder="$"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the dashboard to contain %q", want)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/unknown", nil)
+	w = httptest.NewRecorder()
+	handler.ServeDashboard(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown path, got %d", w.Code)
+	}
+}
+
+// TestAssetOverrides checks that files in the override directory replace the embedded ones, and
+// that the rest still come from the binary
+func TestAssetOverrides(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "style.css"), []byte("body { color: hotpink; }"), 0644)
+	os.WriteFile(filepath.Join(dir, "table.html"), []byte("<title>{{.InstanceName}} custom table</title>"), 0644)
+	if err := web.UseOverrides(dir); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer web.UseOverrides("")
+	handler := NewHandler(newTestStore(t))
+
+	serve := func(serve http.HandlerFunc, path string) string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		serve(w, req)
+		return w.Body.String()
+	}
+	if body := serve(handler.ServeStaticFile, "/style.css"); body != "body { color: hotpink; }" {
+		t.Errorf("Expected the overridden stylesheet, got %q", body)
+	}
+	if body := serve(handler.ServeStaticFile, "/functions.js"); !strings.Contains(body, "function applyTranslations") {
+		t.Error("Expected files without an override to come from the binary")
+	}
+	if body := serve(handler.ServeTableView, "/table"); body != "<title>ExpenseOwl custom table</title>" {
+		t.Errorf("Expected the overridden template, got %q", body)
+	}
+	if body := serve(handler.ServeSettingsPage, "/settings"); !strings.Contains(body, "ExpenseOwl Settings") {
+		t.Error("Expected templates without an override to come from the binary")
+	}
+	if err := web.UseOverrides(filepath.Join(dir, "style.css")); err == nil {
+		t.Error("Expected a file to be rejected as the override directory")
+	}
+}
+
+// TestThemeCSS checks that /theme.css and /config carry the user's chart palette and theme
+func TestThemeCSS(t *testing.T) {
+	store := newTestStore(t)
+	if err := storage.ValidatePreferences(storage.Preferences{Palette: "rainbow"}); err == nil {
+		t.Error("Expected an unknown palette to be rejected")
+	}
+	if err := store.UpdatePreferences("", storage.Preferences{Theme: "dark", Palette: "colorblind"}); err != nil {
+		t.Fatalf("Failed to save preferences: %v", err)
+	}
+	handler := NewHandler(store)
+
+	req := httptest.NewRequest(http.MethodGet, "/theme.css", nil)
+	w := httptest.NewRecorder()
+	handler.ServeStaticFile(w, req)
+	body := w.Body.String()
+	for _, want := range []string{"color-scheme: dark;", "--chart-color-1: #E69F00;", "--chart-color-count: 8;"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the stylesheet to contain %q, got %q", want, body)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/config", nil)
+	w = httptest.NewRecorder()
+	handler.GetConfig(w, req)
+	var config ConfigResponse
+	if err := json.NewDecoder(w.Body).Decode(&config); err != nil {
+		t.Fatalf("Failed to decode config: %v", err)
+	}
+	if len(config.ChartPalette) != 8 || config.ChartPalette[0] != "#E69F00" {
+		t.Errorf("Expected the colorblind palette, got %v", config.ChartPalette)
+	}
+}
+
+// TestBudgetRollover checks that unused budget and overspending carry into later periods per category
+func TestBudgetRollover(t *testing.T) {
+	day := func(month time.Month, d int) time.Time { return time.Date(2026, month, d, 12, 0, 0, 0, time.UTC) }
+	store := newTestStore(t,
+		storage.Expense{Name: "Groceries", Category: "Food", Amount: -80, Date: day(time.January, 5)},
+		storage.Expense{Name: "Groceries", Category: "Food", Amount: -150, Date: day(time.February, 5)},
+		storage.Expense{Name: "Groceries", Category: "Food", Amount: -40, Date: day(time.March, 5)},
+		storage.Expense{Name: "Cinema", Category: "Entertainment", Amount: -70, Date: day(time.January, 10)},
+		storage.Expense{Name: "Concert", Category: "Entertainment", Amount: -20, Date: day(time.February, 10)},
+		storage.Expense{Name: "Salary", Category: "Income", Amount: 3000, Date: day(time.February, 1)},
+	)
+	if err := store.UpdateBudgets(map[string]float64{"Food": 100, "Entertainment": 50, "Travel": 200}); err != nil {
+		t.Fatalf("Failed to save budgets: %v", err)
+	}
+	if err := store.UpdateCategoryMeta(map[string]storage.CategoryMeta{"Food": {Rollover: storage.RolloverBoth}, "Entertainment": {Rollover: storage.RolloverUnused}}); err != nil {
+		t.Fatalf("Failed to save category meta: %v", err)
+	}
+	if err := storage.ValidateCategoryMeta(map[string]storage.CategoryMeta{"Food": {Rollover: "always"}}); err == nil {
+		t.Error("Expected an unknown rollover mode to be rejected")
+	}
+	handler := NewHandler(store)
+
+	req := httptest.NewRequest(http.MethodGet, "/budgets/status?date=2026-03-15", nil)
+	w := httptest.NewRecorder()
+	handler.GetBudgetStatus(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var response BudgetStatusResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Start != "2026-03-01" || response.End != "2026-03-31" || len(response.Budgets) != 3 {
+		t.Fatalf("Unexpected response: %+v", response)
+	}
+	// Food: +20 from January, then 100+20-150 = -30 from February
+	// Entertainment: nothing from January's overspending, then 50-20 = +30 from February
+	// Travel: no rollover mode and no expenses
+	want := []CategoryBudget{
+		{Category: "Entertainment", Budget: 50, Rollover: "unused", Carried: 30, Available: 80, Spent: 0, Remaining: 80},
+		{Category: "Food", Budget: 100, Rollover: "both", Carried: -30, Available: 70, Spent: 40, Remaining: 30},
+		{Category: "Travel", Budget: 200, Carried: 0, Available: 200, Spent: 0, Remaining: 200},
+	}
+	for i, budget := range response.Budgets {
+		if budget != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], budget)
+		}
+	}
+	// TRMNL shows the same amounts, with the percentage of what's available
+	if lines := getTRMNLBudgets(response.Budgets); lines[1].Carried != -30 || lines[1].Remaining != 30 || math.Abs(lines[1].Percentage-40.0/70*100) > 0.001 {
+		t.Errorf("Expected Food's carried budget in the TRMNL line, got %+v", lines[1])
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/budgets/status?date=March", nil)
+	w = httptest.NewRecorder()
+	handler.GetBudgetStatus(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid date, got %d", w.Code)
+	}
+}
+
+// TestNetWorth checks account validation and balances from snapshots and derived transactions
+func TestNetWorth(t *testing.T) {
+	day := func(month time.Month, d int) time.Time { return time.Date(2026, month, d, 12, 0, 0, 0, time.UTC) }
+	store := newTestStore(t,
+		storage.Expense{Name: "Before snapshot", Category: "Food", Amount: -999, Date: day(time.January, 20), Account: "Checking"},
+		storage.Expense{Name: "Groceries", Category: "Food", Amount: -100, Date: day(time.February, 5), Account: "checking"},
+		storage.Expense{Name: "Salary", Category: "Income", Amount: 2000, Date: day(time.February, 10), Account: "Checking"},
+		storage.Expense{Name: "Dinner", Category: "Food", Amount: -50, Date: day(time.February, 7), Account: "Visa"},
+		storage.Expense{Name: "Cash", Category: "Food", Amount: -20, Date: day(time.February, 8)},
+	)
+	handler := NewHandler(store)
+	put := func(body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/accounts/edit", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.UpdateAccounts(w, req)
+		return w.Code
+	}
+	for _, body := range []string{
+		`[{"name": "Checking", "type": "cash"}]`,
+		`[{"name": "Checking", "type": "asset"}, {"name": "checking", "type": "asset"}]`,
+		`[{"name": "Checking", "type": "asset", "snapshots": [{"date": "01/31/2026", "balance": 1}]}]`,
+	} {
+		if code := put(body); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, code)
+		}
+	}
+	body := `[
+		{"name": "Checking", "type": "asset", "derived": true, "snapshots": [{"date": "2026-01-31", "balance": 1000}]},
+		{"name": "Visa", "type": "liability", "derived": true},
+		{"name": "House", "type": "asset", "snapshots": [{"date": "2026-02-15", "balance": 300000}, {"date": "2026-01-01", "balance": 290000}]}
+	]`
+	if code := put(body); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	accounts, err := store.GetAccounts()
+	if err != nil || len(accounts) != 3 || accounts[2].Snapshots[0].Date != "2026-01-01" {
+		t.Fatalf("Expected the snapshots to be sorted, got %+v (%v)", accounts, err)
+	}
+	expenses, _ := store.GetAllExpenses()
+
+	point := netWorthAt(accounts, nil, expenses, "2026-02-28")
+	if point.Accounts["Checking"] != 2900 || point.Accounts["Visa"] != 50 || point.Accounts["House"] != 300000 {
+		t.Errorf("Unexpected balances: %v", point.Accounts)
+	}
+	if point.Assets != 302900 || point.Liabilities != 50 || point.NetWorth != 302850 {
+		t.Errorf("Unexpected totals: %+v", point)
+	}
+	point = netWorthAt(accounts, nil, expenses, "2026-01-25")
+	if point.Accounts["House"] != 290000 || point.Accounts["Checking"] != -999 {
+		t.Errorf("Expected the earlier snapshot and the transactions before the first one, got %v", point.Accounts)
+	}
+	if _, ok := netWorthAt(accounts, nil, expenses, "2025-12-31").Accounts["House"]; ok {
+		t.Error("Expected no balance before the first snapshot of a manual account")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/reports/networth?months=3", nil)
+	w := httptest.NewRecorder()
+	handler.GetNetWorthReport(w, req)
+	var report NetWorthReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(report.Points) != 3 || report.Points[2].Date != time.Now().Format("2006-01-02") {
+		t.Errorf("Expected 3 points ending today, got %+v", report.Points)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/reports/networth?months=0", nil)
+	w = httptest.NewRecorder()
+	handler.GetNetWorthReport(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for months=0, got %d", w.Code)
+	}
+}
+
+// TestHoldings checks the price history kept across edits, refreshing from a price provider, and
+// the holdings' value in the net worth
+func TestHoldings(t *testing.T) {
+	quotes := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/quote/VTI":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"price": 250}`))
+		case "/quote/BTC-USD":
+			w.Write([]byte("60000\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer quotes.Close()
+	store := newTestStore(t)
+	handler := NewHandler(store)
+	put := func(body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/holdings/edit", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.UpdateHoldings(w, req)
+		return w.Code
+	}
+	if code := put(`[{"ticker": "VTI", "quantity": 0, "costBasis": 100}]`); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a zero quantity, got %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/holdings/refresh", nil)
+	w := httptest.NewRecorder()
+	handler.RefreshPrices(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without a price provider, got %d", w.Code)
+	}
+
+	body := `[
+		{"ticker": "vti", "quantity": 10, "costBasis": 2000, "prices": [{"date": "2026-01-31", "price": 220}]},
+		{"ticker": "BTC-USD", "quantity": 0.5, "costBasis": 10000},
+		{"ticker": "XYZ", "quantity": 1, "costBasis": 5}
+	]`
+	if code := put(body); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	handler.prices = prices.HTTP{URL: quotes.URL + "/quote/{ticker}"}
+	response, err := handler.refreshPrices(context.Background(), time.Date(2026, time.March, 1, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if response.Updated != 2 || !slices.Equal(response.Failed, []string{"XYZ"}) {
+		t.Errorf("Expected 2 updated and XYZ failed, got %+v", response)
+	}
+	// a new quantity without prices keeps the history
+	if code := put(`[{"ticker": "VTI", "quantity": 12, "costBasis": 2400}, {"ticker": "BTC-USD", "quantity": 0.5, "costBasis": 10000}]`); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	holdings, _ := store.GetHoldings()
+	if len(holdings) != 2 || len(holdings[0].Prices) != 2 || holdings[0].Prices[1] != (storage.PriceQuote{Date: "2026-03-01", Price: 250}) {
+		t.Fatalf("Expected VTI to keep its prices, got %+v", holdings)
+	}
+
+	if point := netWorthAt(nil, holdings, nil, "2026-02-15"); point.Holdings != 2640 || point.NetWorth != 2640 {
+		t.Errorf("Expected 12 VTI at 220, got %+v", point)
+	}
+	if point := netWorthAt(nil, holdings, nil, "2026-03-01"); point.Holdings != 33000 || point.Assets != 33000 {
+		t.Errorf("Expected 12 VTI at 250 and 0.5 BTC at 60000, got %+v", point)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/holdings", nil)
+	w = httptest.NewRecorder()
+	handler.GetHoldings(w, req)
+	var statuses []HoldingStatus
+	if err := json.NewDecoder(w.Body).Decode(&statuses); err != nil {
+		t.Fatalf("Failed to decode holdings: %v", err)
+	}
+	if len(statuses) != 2 || statuses[0].Value != 3000 || statuses[0].Gain != 600 || statuses[0].PriceDate != "2026-03-01" {
+		t.Errorf("Unexpected holdings: %+v", statuses)
+	}
+}
+
+// TestPlannedExpenses checks the monthly set-aside and pace of planned expenses, and the yearly forecast
+func TestPlannedExpenses(t *testing.T) {
+	today := time.Date(2026, time.March, 15, 10, 0, 0, 0, time.UTC)
+	insurance := storage.PlannedExpense{Name: "Car insurance", Amount: 1200, Due: "2026-06-01", Saved: 300, Start: "2026-01-01"}
+	status := plannedStatus(insurance, today)
+	// March, April, and May are left to save the remaining 900
+	if status.MonthsLeft != 3 || status.MonthlySetAside != 300 || status.Remaining != 900 {
+		t.Errorf("Unexpected schedule: %+v", status)
+	}
+	// 73 of 151 days have passed, so about 580 should be saved
+	if status.OnTrack || math.Abs(status.Expected-580.13) > 0.01 {
+		t.Errorf("Expected to be behind an even pace, got %+v", status)
+	}
+	if status := plannedStatus(storage.PlannedExpense{Name: "Rent", Amount: 100, Due: "2026-03-20", Saved: 100, Start: "2026-03-01"}, today); status.MonthsLeft != 1 || status.MonthlySetAside != 0 || !status.OnTrack {
+		t.Errorf("Expected a fully saved expense due this month to be on track, got %+v", status)
+	}
+	if status := plannedStatus(storage.PlannedExpense{Name: "Gifts", Amount: 500, Due: "2026-01-10", Saved: 200, Start: "2025-10-01"}, today); status.MonthsLeft != 0 || status.MonthlySetAside != 300 || status.OnTrack {
+		t.Errorf("Expected an overdue expense to need the rest now, got %+v", status)
+	}
+
+	handler := NewHandler(newTestStore(t))
+	put := func(body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/planned/edit", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.UpdatePlannedExpenses(w, req)
+		return w.Code
+	}
+	for _, body := range []string{
+		`[{"name": "Gifts", "amount": 0, "due": "2026-12-01"}]`,
+		`[{"name": "Gifts", "amount": 500, "due": "December"}]`,
+		`[{"name": "Gifts", "amount": 500, "due": "2026-12-01", "start": "2027-01-01"}]`,
+	} {
+		if code := put(body); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, code)
+		}
+	}
+	nextYear := time.Now().Year() + 1
+	body := fmt.Sprintf(`[{"name": "Gifts", "amount": 600, "due": "%d-12-01"}, {"name": "Insurance", "amount": 1200, "due": "%d-06-01", "saved": 100}]`, nextYear, nextYear)
+	if code := put(body); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/planned", nil)
+	w := httptest.NewRecorder()
+	handler.GetPlannedExpenses(w, req)
+	var response PlannedResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Expenses) != 2 || response.Expenses[0].Name != "Insurance" || response.Expenses[1].Start != time.Now().Format("2006-01-02") {
+		t.Errorf("Expected the expenses by due date, starting today, got %+v", response.Expenses)
+	}
+	if total := response.Expenses[0].MonthlySetAside + response.Expenses[1].MonthlySetAside; response.MonthlySetAside != total {
+		t.Errorf("Expected a total set-aside of %v, got %v", total, response.MonthlySetAside)
+	}
+
+	forecast := &YearForecast{}
+	year := periods.FiscalYear(today, time.January)
+	applyPlannedExpenses(forecast, []storage.PlannedExpense{insurance, {Name: "Next year", Amount: 50, Due: "2027-02-01", Start: "2026-01-01"}}, year, today)
+	if forecast.PlannedExpenses != 1200 || forecast.PlannedOnTrack || forecast.PlannedSetAside <= 300 {
+		t.Errorf("Unexpected forecast: %+v", forecast)
+	}
+}
+
+func TestGetExpenses_Filters(t *testing.T) {
+	now := time.Now()
+	store := newTestStore(t,
+		storage.Expense{ID: "1", Name: "Groceries", Category: "Food", SubCategory: "Supermarket", Amount: -45, Date: now, Tags: []string{"home", "weekly"}, Account: "Checking"},
+		storage.Expense{ID: "2", Name: "Dinner", Category: "Food", SubCategory: "Restaurants", Amount: -80, Date: now, Tags: []string{"date"}, Account: "Card"},
+		storage.Expense{ID: "3", Name: "Gym", Category: "Health", Amount: -30, Date: now, RecurringID: "r1", Account: "checking"},
+		storage.Expense{ID: "4", Name: "Salary", Category: "Income", Amount: 3000, Date: now, Tags: []string{"home"}},
+		storage.Expense{ID: "5", Name: "Mystery", Category: "Gone", Amount: -12, Date: now},
+	)
+	if err := store.UpdateCategories([]string{"Food", "Health", "Income"}); err != nil {
+		t.Fatalf("Failed to set categories: %v", err)
+	}
+	handler := NewHandler(store)
+	list := func(query string) (int, []string) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses?"+query, nil)
+		w := httptest.NewRecorder()
+		handler.GetExpenses(w, req)
+		var expenses []storage.Expense
+		json.NewDecoder(w.Body).Decode(&expenses)
+		ids := []string{}
+		for _, expense := range expenses {
+			ids = append(ids, expense.ID)
+		}
+		slices.Sort(ids)
+		return w.Code, ids
+	}
+
+	for query, want := range map[string][]string{
+		"":                               {"1", "2", "3", "4", "5"},
+		"minAmount=40":                   {"1", "2", "4"},
+		"minAmount=40&maxAmount=100":     {"1", "2"},
+		"tags=home,date":                 {"1", "2", "4"},
+		"tags=home,weekly&tagMode=all":   {"1"},
+		"tags=home&maxAmount=50":         {"1"},
+		"subcategory=Restaurants":        {"2"},
+		"account=CHECKING":               {"1", "3"},
+		"recurring=true":                 {"3"},
+		"untagged=true":                  {"3", "5"},
+		"uncategorized=true":             {"5"},
+		"untagged=true&account=checking": {"3"},
+	} {
+		code, ids := list(query)
+		if code != http.StatusOK || !slices.Equal(ids, want) {
+			t.Errorf("%q: expected %v, got %d %v", query, want, code, ids)
+		}
+	}
+	for _, query := range []string{"minAmount=abc", "maxAmount=-5", "minAmount=50&maxAmount=10", "tagMode=some", "recurring=maybe", "untagged=true&tags=home"} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", query, code)
+		}
+	}
+}
+
+func TestSavedReports(t *testing.T) {
+	now := time.Now()
+	store := newTestStore(t,
+		storage.Expense{ID: "1", Name: "Groceries", Category: "Food", Amount: -40, Date: now, Tags: []string{"home"}},
+		storage.Expense{ID: "2", Name: "Dinner", Category: "Food", Amount: -60, Date: now, Tags: []string{"home", "date"}},
+		storage.Expense{ID: "3", Name: "Taxi", Category: "Travel", Amount: -80, Date: now},
+		storage.Expense{ID: "4", Name: "Refund", Category: "Food", Amount: 10, Date: now},
+		storage.Expense{ID: "5", Name: "Old", Category: "Food", Amount: -500, Date: now.AddDate(-2, 0, 0)},
+	)
+	handler := NewHandler(store)
+	put := func(body string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/reports/saved/edit", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.UpdateSavedReports(w, req)
+		return w.Code
+	}
+	for _, body := range []string{
+		`[{"name": "Food", "schedule": "daily"}]`,
+		`[{"name": "Food", "schedule": "hourly", "webhook": "https://example.com"}]`,
+		`[{"name": "Food", "schedule": "daily", "groupBy": "merchant", "webhook": "https://example.com"}]`,
+		`[{"name": "Food", "schedule": "daily", "webhook": "ftp://example.com"}]`,
+		`[{"name": "Food", "schedule": "daily", "file": "food.json"}]`,
+	} {
+		if code := put(body); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, code)
+		}
+	}
+
+	var received []SavedReportResult
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result SavedReportResult
+		json.NewDecoder(r.Body).Decode(&result)
+		received = append(received, result)
+	}))
+	defer webhook.Close()
+	handler.reportsDir = t.TempDir()
+	body := fmt.Sprintf(`[{"name": "Spending", "groupBy": "tag", "range": "current", "schedule": "daily", "webhook": %q},
+		{"name": "Big", "filter": {"minAmount": 50}, "groupBy": "category", "range": "all", "schedule": "weekly", "file": "big.json"}]`, webhook.URL)
+	if code := put(body); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/reports/saved/preview?name=spending", nil)
+	w := httptest.NewRecorder()
+	handler.PreviewSavedReport(w, req)
+	var result SavedReportResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	// Dinner counts toward both of its tags
+	want := []SavedReportGroup{{Key: "home", Count: 2, Spent: 100}, {Key: "", Count: 2, Spent: 80, Income: 10}, {Key: "date", Count: 1, Spent: 60}}
+	if result.Count != 4 || result.Spent != 180 || result.Income != 10 || !slices.Equal(result.Groups, want) {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if len(received) != 0 {
+		t.Errorf("Expected a preview not to be delivered")
+	}
+
+	if err := handler.runDueSavedReports(context.Background(), now); err != nil {
+		t.Fatalf("Failed to run saved reports: %v", err)
+	}
+	if len(received) != 1 || received[0].Name != "Spending" {
+		t.Errorf("Expected the webhook to receive the report, got %+v", received)
+	}
+	data, err := os.ReadFile(filepath.Join(handler.reportsDir, "big.json"))
+	if err != nil {
+		t.Fatalf("Expected the report file to be written: %v", err)
+	}
+	var big SavedReportResult
+	if err := json.Unmarshal(data, &big); err != nil || big.Count != 3 || big.Groups[0].Key != "Food" || big.Groups[0].Spent != 560 {
+		t.Errorf("Unexpected report file: %s", data)
+	}
+
+	// both ran today, so neither is due again until tomorrow
+	if err := handler.runDueSavedReports(context.Background(), now.Add(time.Minute)); err != nil || len(received) != 1 {
+		t.Errorf("Expected no second run on the same day, got %d", len(received))
+	}
+	if err := handler.runDueSavedReports(context.Background(), now.AddDate(0, 0, 1)); err != nil || len(received) != 2 {
+		t.Errorf("Expected the daily report to run the next day, got %d", len(received))
+	}
+	reports, _ := store.GetSavedReports()
+	if put(body) != http.StatusOK {
+		t.Fatalf("Failed to update saved reports")
+	}
+	if kept, _ := store.GetSavedReports(); len(kept) != 2 || kept[0].LastRun == "" || kept[0].LastRun != reports[0].LastRun {
+		t.Errorf("Expected edits to keep the last runs, got %+v", kept)
+	}
+}
+
+func TestGrafanaDatasource(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, time.March, d, 12, 0, 0, 0, time.Local) }
+	store := newTestStore(t,
+		storage.Expense{ID: "1", Name: "Groceries", Category: "Food", Amount: -40, Date: day(2), Tags: []string{"home"}},
+		storage.Expense{ID: "2", Name: "Dinner", Category: "Food", Amount: -60, Date: day(2)},
+		storage.Expense{ID: "3", Name: "Flight", Category: "Travel", Amount: -300, Date: day(10), Tags: []string{"trip"}},
+		storage.Expense{ID: "4", Name: "Salary", Category: "Income", Amount: 2000, Date: day(3)},
+	)
+	handler := NewHandler(store)
+	post := func(serve http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		serve(w, httptest.NewRequest(http.MethodPost, "/api/grafana", strings.NewReader(body)))
+		return w
+	}
+
+	var metrics []string
+	json.NewDecoder(post(handler.GrafanaSearch, `{"target": "tag"}`).Body).Decode(&metrics)
+	if !slices.Equal(metrics, []string{"tag:home", "tag:trip"}) {
+		t.Errorf("Unexpected metrics: %v", metrics)
+	}
+
+	from, to := day(1).Add(-12*time.Hour).UTC().Format(time.RFC3339), day(15).Add(-12*time.Hour).UTC().Format(time.RFC3339)
+	body := fmt.Sprintf(`{"range": {"from": %q, "to": %q}, "intervalMs": 86400000, "targets": [{"target": "category:Food"}, {"target": "spent"}, {"target": "income", "hide": true}]}`, from, to)
+	w := post(handler.GrafanaQuery, body)
+	var series []GrafanaSeries
+	if err := json.NewDecoder(w.Body).Decode(&series); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(series) != 2 || len(series[0].Datapoints) != 14 {
+		t.Fatalf("Expected 2 series of 14 days, got %+v", series)
+	}
+	if point := series[0].Datapoints[1]; point[0] != 100 || int64(point[1]) != day(2).Add(-12*time.Hour).UnixMilli() {
+		t.Errorf("Expected 100 on March 2, got %v", point)
+	}
+	var total float64
+	for _, point := range series[1].Datapoints {
+		total += point[0]
+	}
+	if total != 400 {
+		t.Errorf("Expected 400 spent, got %v", total)
+	}
+
+	// weekly points start on Mondays, and March 2, 2026 is one
+	body = fmt.Sprintf(`{"range": {"from": %q, "to": %q}, "intervalMs": 604800000, "targets": [{"target": "spent"}]}`, from, to)
+	json.NewDecoder(post(handler.GrafanaQuery, body).Body).Decode(&series)
+	if len(series) != 1 || len(series[0].Datapoints) != 3 || series[0].Datapoints[1][0] != 100 || series[0].Datapoints[2][0] != 300 {
+		t.Errorf("Unexpected weekly series: %+v", series)
+	}
+	if w := post(handler.GrafanaQuery, fmt.Sprintf(`{"range": {"from": %q, "to": %q}, "targets": [{"target": "rent"}]}`, from, to)); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown metric, got %d", w.Code)
+	}
+
+	body = fmt.Sprintf(`{"range": {"from": %q, "to": %q}, "annotation": {"name": "Trips", "query": "tag:trip"}}`, from, to)
+	var annotations []GrafanaAnnotation
+	json.NewDecoder(post(handler.GrafanaAnnotations, body).Body).Decode(&annotations)
+	if len(annotations) != 1 || annotations[0].Title != "Flight" || annotations[0].Time != day(10).UnixMilli() || !slices.Equal(annotations[0].Tags, []string{"Travel", "trip"}) {
+		t.Errorf("Unexpected annotations: %+v", annotations)
+	}
+}
+
+func TestExpenseMetadata(t *testing.T) {
+	store := newTestStore(t)
+	rule := storage.RecurringExpense{ID: "rent", Name: "Rent", Category: "Housing", Amount: -900, StartDate: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Interval: "monthly", Occurrences: 2}
+	if err := store.AddRecurringExpense(rule); err != nil {
+		t.Fatalf("Failed to add recurring expense: %v", err)
+	}
+	expenses, _ := store.GetAllExpenses()
+	id := expenses[0].ID
+	handler := NewHandler(store)
+	call := func(method, path, body string) (int, storage.Metadata) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.ExpenseMetadata(w, req)
+		var metadata storage.Metadata
+		json.NewDecoder(w.Body).Decode(&metadata)
+		return w.Code, metadata
+	}
+	path := "/api/expenses/" + id + "/metadata"
+
+	if code, metadata := call(http.MethodGet, path, ""); code != http.StatusOK || len(metadata) != 0 {
+		t.Errorf("Expected empty metadata, got %d %v", code, metadata)
+	}
+	call(http.MethodPut, path, `{"bank": {"transaction_id": "tx-1"}}`)
+	code, metadata := call(http.MethodPut, path, `{"splitwise": {"id": "42", "group": "flat"}}`)
+	if code != http.StatusOK || metadata["bank"]["transaction_id"] != "tx-1" || metadata["splitwise"]["id"] != "42" {
+		t.Errorf("Expected both namespaces, got %d %v", code, metadata)
+	}
+	for _, body := range []string{`{"bad namespace": {"id": "1"}}`, `{"bank": {"": "1"}}`, `[1]`} {
+		if code, _ := call(http.MethodPut, path, body); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, code)
+		}
+	}
+	if code, _ := call(http.MethodGet, "/api/expenses/missing/metadata", ""); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown expense, got %d", code)
+	}
+
+	// edits from the UI don't send metadata, and keep it
+	body := `{"name": "Rent", "category": "Housing", "amount": -950, "date": "2026-01-01T00:00:00Z"}`
+	req := httptest.NewRequest(http.MethodPut, "/expense/edit?id="+id, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	handler.EditExpense(w, req)
+	if expense, _ := store.GetExpense(id); w.Code != http.StatusOK || expense.Metadata["splitwise"]["group"] != "flat" {
+		t.Errorf("Expected the edit to keep the metadata, got %d %+v", w.Code, expense)
+	}
+	// so do edits of the recurring rule
+	rule.Amount = -1000
+	if err := store.UpdateRecurringExpense("rent", rule, true); err != nil {
+		t.Fatalf("Failed to update recurring expense: %v", err)
+	}
+	if expense, err := store.GetExpense(id); err != nil || expense.Metadata["bank"]["transaction_id"] != "tx-1" {
+		t.Errorf("Expected the rule edit to keep the metadata, got %+v", expense)
+	}
+
+	if code, metadata := call(http.MethodDelete, path+"?namespace=bank", ""); code != http.StatusOK || len(metadata) != 1 {
+		t.Errorf("Expected the namespace to be removed, got %d %v", code, metadata)
+	}
+	call(http.MethodPut, path, `{"splitwise": {}}`)
+	if expense, _ := store.GetExpense(id); len(expense.Metadata) != 0 {
+		t.Errorf("Expected no metadata left, got %v", expense.Metadata)
+	}
+
+	call(http.MethodPut, path, `{"bank": {"transaction_id": "tx-1"}}`)
+	w = httptest.NewRecorder()
+	handler.ExportCSV(w, httptest.NewRequest(http.MethodGet, "/export/csv", nil))
+	if !strings.Contains(w.Body.String(), `"{""bank"":{""transaction_id"":""tx-1""}}"`) {
+		t.Errorf("Expected the metadata in the CSV export, got %s", w.Body.String())
+	}
+}
+
+func TestImportPaymentProcessors(t *testing.T) {
+	handler := NewHandler(newTestStoreWithCategories(t, "Sales", "Fees"))
+	importCSV := func(serve http.HandlerFunc, query, csvData string) ImportResult {
+		t.Helper()
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, _ := writer.CreateFormFile("file", "export.csv")
+		part.Write([]byte(csvData))
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/import?dryRun=true&"+query, &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		serve(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var result ImportResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return result
+	}
+
+	// the 25th shows the dates are day first
+	payPal := "\ufeff\"Date\",\"Time\",\"Name\",\"Type\",\"Status\",\"Currency\",\"Gross\",\"Fee\",\"Net\",\"Transaction ID\",\"Balance Impact\"\n" +
+		"\"03/04/2026\",\"10:00:00\",\"Jane Buyer\",\"Website Payment\",\"Completed\",\"USD\",\"100.00\",\"-3.20\",\"96.80\",\"9AB12\",\"Credit\"\n" +
+		"\"25/04/2026\",\"11:00:00\",\"Web Host\",\"PreApproved Payment Bill User Payment\",\"Completed\",\"USD\",\"-12.00\",\"0.00\",\"-12.00\",\"7CD34\",\"Debit\"\n" +
+		"\"26/04/2026\",\"12:00:00\",\"\",\"General Withdrawal\",\"Completed\",\"USD\",\"-50.00\",\"0.00\",\"-50.00\",\"5EF56\",\"Debit\"\n" +
+		"\"27/04/2026\",\"12:00:00\",\"Slow Buyer\",\"Website Payment\",\"Pending\",\"USD\",\"20.00\",\"-0.90\",\"19.10\",\"3GH78\",\"Credit\"\n"
+	result := importCSV(handler.ImportPayPal, "feeCategory=Fees", payPal)
+	if result.Imported != 3 || result.Skipped != 2 || len(result.Expenses) != 3 {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	sale, fee := result.Expenses[0], result.Expenses[1]
+	if sale.Amount != 100 || sale.Date.Month() != time.April || sale.Date.Day() != 3 || sale.Metadata["paypal"]["transaction_id"] != "9AB12" {
+		t.Errorf("Unexpected sale: %+v", sale)
+	}
+	if fee.Amount != -3.2 || fee.Category != "Fees" || fee.Name != "PayPal fee for Jane Buyer" {
+		t.Errorf("Unexpected fee: %+v", fee)
+	}
+	if result := importCSV(handler.ImportPayPal, "fees=embedded", payPal); len(result.Expenses) != 2 || result.Expenses[0].Amount != 96.8 {
+		t.Errorf("Expected the fee in the amount, got %+v", result.Expenses)
+	}
+
+	stripe := "id,Type,Source,Amount,Fee,Net,Currency,Created (UTC),Description\n" +
+		"txn_1,charge,ch_1,49.00,1.72,47.28,usd,2026-03-01 14:03,Pro plan\n" +
+		"txn_2,refund,re_1,-49.00,0.00,-49.00,usd,2026-03-02 09:30,\n" +
+		"txn_3,payout,po_1,-47.28,0.00,-47.28,usd,2026-03-03 00:00,STRIPE PAYOUT\n"
+	result = importCSV(handler.ImportStripe, "", stripe)
+	if result.Imported != 3 || result.Skipped != 1 {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	if charge := result.Expenses[0]; charge.Amount != 49 || charge.Metadata["stripe"]["id"] != "txn_1" || charge.Date.Hour() != 14 {
+		t.Errorf("Unexpected charge: %+v", charge)
+	}
+	if fee := result.Expenses[1]; fee.Amount != -1.72 || fee.Name != "Stripe fee for Pro plan" {
+		t.Errorf("Unexpected fee: %+v", fee)
+	}
+	if refund := result.Expenses[2]; refund.Amount != -49 || refund.Name != "Stripe refund" {
+		t.Errorf("Unexpected refund: %+v", refund)
+	}
+}
+
+// TestPlaidSync links a bank against a fake Plaid API and syncs added, changed, and removed
+// transactions, with categories from the mapping rules
+func TestPlaidSync(t *testing.T) {
+	var cursors []string
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["client_id"] != "client" || body["secret"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error_code": "INVALID_API_KEYS", "error_message": "invalid client_id or secret"}`))
+			return
+		}
+		cursor, _ := body["cursor"].(string)
+		switch r.URL.Path {
+		case "/item/public_token/exchange":
+			w.Write([]byte(`{"access_token": "access-sandbox-1", "item_id": "item-1"}`))
+		case "/transactions/sync":
+			cursors = append(cursors, cursor)
+			switch cursor {
+			case "":
+				w.Write([]byte(`{"added": [
+					{"transaction_id": "t1", "account_id": "a1", "amount": 5.4, "iso_currency_code": "USD", "date": "2026-03-02", "name": "STARBUCKS 123", "merchant_name": "Starbucks"},
+					{"transaction_id": "t2", "account_id": "a1", "amount": 5.4, "iso_currency_code": "USD", "date": "2026-03-02", "name": "STARBUCKS 123", "merchant_name": "Starbucks"}
+				], "modified": [], "removed": [], "next_cursor": "c1", "has_more": true}`))
+			case "c1":
+				w.Write([]byte(`{"added": [
+					{"transaction_id": "t3", "account_id": "a1", "amount": -1200, "iso_currency_code": "USD", "date": "2026-03-01", "name": "PAYROLL"},
+					{"transaction_id": "t4", "account_id": "a1", "amount": 30, "iso_currency_code": "USD", "date": "2026-03-03", "name": "GAS STATION", "pending": true}
+				], "modified": [], "removed": [], "next_cursor": "c2", "has_more": false}`))
+			case "c2":
+				w.Write([]byte(`{"added": [], "modified": [
+					{"transaction_id": "t1", "account_id": "a1", "amount": 6.4, "iso_currency_code": "USD", "date": "2026-03-03", "name": "STARBUCKS 123", "merchant_name": "Starbucks"}
+				], "removed": [{"transaction_id": "t2"}], "next_cursor": "c3", "has_more": false}`))
+			default:
+				w.Write([]byte(`{"added": [], "modified": [], "removed": [], "next_cursor": "c3", "has_more": false}`))
+			}
+		case "/item/remove":
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer fake.Close()
+
+	store := newTestStoreWithCategories(t, "Food", "Income", "Miscellaneous")
+	if err := store.UpdateSubCategoryMappings([]storage.SubCategoryMappingRule{{Pattern: "starbucks", MatchType: "contains", Category: "Food"}}); err != nil {
+		t.Fatalf("Failed to set rules: %v", err)
+	}
+	handler := NewHandler(store)
+	exchange := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/plaid/exchange", strings.NewReader(`{"publicToken": "public-sandbox-1", "institution": "First Platypus Bank"}`))
+		w := httptest.NewRecorder()
+		handler.ExchangePlaidToken(w, req)
+		return w
+	}
+	if w := exchange(); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503 without Plaid credentials, got %d", w.Code)
+	}
+
+	handler.plaid = &plaidConnector{client: &plaid.Client{ClientID: "client", Secret: "secret", BaseURL: fake.URL}, webhookToken: "hook"}
+	w := exchange()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var result PlaidSyncResult
+	json.NewDecoder(w.Body).Decode(&result)
+	if result.Error != "" || result.Added != 3 {
+		t.Fatalf("Expected 3 added transactions, got %+v", result)
+	}
+	expenses, _ := store.GetAllExpenses()
+	byID := make(map[string]storage.Expense)
+	for _, expense := range expenses {
+		byID[expense.Metadata["plaid"]["transaction_id"]] = expense
+	}
+	if coffee := byID["t1"]; coffee.Name != "Starbucks" || coffee.Amount != -5.4 || coffee.Category != "Food" || !slices.Contains(coffee.Tags, "First Platypus Bank") {
+		t.Errorf("Unexpected expense for t1: %+v", coffee)
+	}
+	if _, ok := byID["t2"]; !ok {
+		t.Error("Expected the identical second transaction to be kept")
+	}
+	if payroll := byID["t3"]; payroll.Amount != 1200 || payroll.Category != "Miscellaneous" {
+		t.Errorf("Unexpected expense for t3: %+v", payroll)
+	}
+	if _, ok := byID["t4"]; ok {
+		t.Error("Expected the pending transaction to be skipped")
+	}
+	items, _ := store.GetPlaidItems()
+	if len(items) != 1 || items[0].Cursor != "c2" || items[0].LastSync == "" {
+		t.Fatalf("Expected the cursor to be saved, got %+v", items)
+	}
+
+	// the webhook syncs from the saved cursor
+	req := httptest.NewRequest(http.MethodPost, "/api/plaid/webhook?token=wrong", strings.NewReader(`{"webhook_type": "TRANSACTIONS", "webhook_code": "SYNC_UPDATES_AVAILABLE", "item_id": "item-1"}`))
+	w = httptest.NewRecorder()
+	handler.PlaidWebhook(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong token, got %d", w.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/api/plaid/webhook?token=hook", strings.NewReader(`{"webhook_type": "TRANSACTIONS", "webhook_code": "SYNC_UPDATES_AVAILABLE", "item_id": "item-1"}`))
+	w = httptest.NewRecorder()
+	handler.PlaidWebhook(w, req)
+	handler.WaitForTasks()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	expenses, _ = store.GetAllExpenses()
+	if len(expenses) != 2 {
+		t.Fatalf("Expected the removed transaction to be deleted, got %d expenses", len(expenses))
+	}
+	for _, expense := range expenses {
+		if expense.Metadata["plaid"]["transaction_id"] == "t1" && (expense.Amount != -6.4 || expense.Date.Day() != 3 || expense.Category != "Food") {
+			t.Errorf("Expected the changed amount and date, got %+v", expense)
+		}
+	}
+	if !slices.Equal(cursors, []string{"", "c1", "c2"}) {
+		t.Errorf("Unexpected sync cursors: %v", cursors)
+	}
+
+	// access tokens don't leave the server
+	req = httptest.NewRequest(http.MethodGet, "/config", nil)
+	w = httptest.NewRecorder()
+	handler.GetConfig(w, req)
+	if strings.Contains(w.Body.String(), "access-sandbox-1") {
+		t.Error("Expected the access token to be left out of the config")
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/plaid/items/remove?item=item-1", nil)
+	w = httptest.NewRecorder()
+	handler.RemovePlaidItem(w, req)
+	if items, _ := store.GetPlaidItems(); w.Code != http.StatusOK || len(items) != 0 {
+		t.Errorf("Expected the bank to be disconnected, got status %d and %+v", w.Code, items)
+	}
+	if expenses, _ := store.GetAllExpenses(); len(expenses) != 2 {
+		t.Errorf("Expected synced expenses to be kept, got %d", len(expenses))
+	}
+}
+
+// TestImportRollback imports two files and rolls one of them back, keeping the other and
+// expenses added by hand
+func TestImportRollback(t *testing.T) {
+	store := newTestStoreWithCategories(t, "Food")
+	store.AddExpense(storage.Expense{Name: "By hand", Category: "Food", Amount: -3, Date: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)})
+	handler := NewHandler(store)
+	importCSV := func(file, csvData string) ImportResult {
+		t.Helper()
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, _ := writer.CreateFormFile("file", file)
+		part.Write([]byte(csvData))
+		writer.Close()
+		req := httptest.NewRequest(http.MethodPost, "/import/csv", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		w := httptest.NewRecorder()
+		handler.ImportCSV(w, req)
+		var result ImportResult
+		json.NewDecoder(w.Body).Decode(&result)
+		if w.Code != http.StatusOK || result.Batch == "" {
+			t.Fatalf("Expected an import batch, got status %d and %+v", w.Code, result)
+		}
+		return result
+	}
+	good := importCSV("march.csv", "name,category,amount,date\nLunch,Food,-15,2026-03-02\nSalary,Food,2000,2026-03-05\n")
+	bad := importCSV("april.csv", "name,category,amount,date\nDinner,Food,-40,2026-04-01\nSnack,Food,-2.5,2026-04-09\nTea,Food,-3,2026-04-20\n")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/imports", nil)
+	w := httptest.NewRecorder()
+	handler.GetImports(w, req)
+	var batches []ImportBatch
+	json.NewDecoder(w.Body).Decode(&batches)
+	if len(batches) != 2 {
+		t.Fatalf("Expected 2 import batches, got %+v", batches)
+	}
+	var april ImportBatch
+	for _, batch := range batches {
+		if batch.ID == bad.Batch {
+			april = batch
+		}
+	}
+	if april.Source != "CSV" || april.File != "april.csv" || april.Count != 3 || april.Total != -45.5 || april.From != "2026-04-01" || april.To != "2026-04-20" {
+		t.Errorf("Unexpected batch: %+v", april)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/api/imports/rollback?batch="+bad.Batch, nil)
+	w = httptest.NewRecorder()
+	handler.RollbackImport(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	expenses, _ := store.GetAllExpenses()
+	if len(expenses) != 3 {
+		t.Fatalf("Expected the hand-added expense and the first import to be kept, got %d expenses", len(expenses))
+	}
+	for _, expense := range expenses {
+		if batch := expense.Metadata["import"]["batch"]; batch != "" && batch != good.Batch {
+			t.Errorf("Expected only the first import to be left, got %+v", expense)
+		}
+	}
+	w = httptest.NewRecorder()
+	handler.RollbackImport(w, httptest.NewRequest(http.MethodDelete, "/api/imports/rollback?batch="+bad.Batch, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for a rolled back import, got %d", w.Code)
+	}
+}
+
+func TestSyncPushAndPull(t *testing.T) {
+	coffee := storage.Expense{ID: uuid.New().String(), Name: "Coffee", Category: "Food", Amount: -4, Currency: "usd", Date: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}
+	store := newTestStore(t, coffee)
+	handler := NewHandler(store)
+	pull := func(since int64) SyncPullResponse {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.SyncPull(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/sync/pull?since=%d", since), nil))
+		var response SyncPullResponse
+		json.NewDecoder(w.Body).Decode(&response)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		return response
+	}
+	push := func(policy string, changes ...SyncChange) []SyncResult {
+		t.Helper()
+		body, _ := json.Marshal(SyncPushRequest{Policy: policy, Changes: changes})
+		w := httptest.NewRecorder()
+		handler.SyncPush(w, httptest.NewRequest(http.MethodPost, "/api/sync/push", bytes.NewReader(body)))
+		var results []SyncResult
+		json.NewDecoder(w.Body).Decode(&results)
+		if w.Code != http.StatusOK || len(results) != len(changes) {
+			t.Fatalf("Expected a result for each change, got status %d: %s", w.Code, w.Body.String())
+		}
+		return results
+	}
+
+	initial := pull(0)
+	if len(initial.Changes) != 1 || initial.Changes[0].Expense == nil || initial.Changes[0].Expense.Name != "Coffee" {
+		t.Fatalf("Expected the existing expense in the first pull, got %+v", initial)
+	}
+	base := *initial.Changes[0].Expense
+
+	// a new expense with a client ID, and an edit based on the pulled revision
+	lunchID := uuid.New().String()
+	edited := base
+	edited.Name = "Flat white"
+	results := push("", SyncChange{ID: lunchID, Expense: &storage.Expense{Name: "Lunch", Category: "Food", Amount: -12, Date: base.Date}},
+		SyncChange{ID: coffee.ID, BaseRevision: initial.Revision, Expense: &edited})
+	for _, result := range results {
+		if result.Status != "applied" || result.Revision <= initial.Revision {
+			t.Errorf("Expected the change to be applied with a new revision, got %+v", result)
+		}
+	}
+	changes := pull(initial.Revision)
+	if len(changes.Changes) != 2 || changes.Revision != results[1].Revision {
+		t.Fatalf("Expected the 2 pushed changes, got %+v", changes)
+	}
+
+	// the server changes the amount; merging the client's note keeps it
+	server, _ := store.GetExpense(coffee.ID)
+	server.Amount = -5
+	store.UpdateExpense(coffee.ID, server)
+	noted := edited
+	noted.Notes = "oat milk"
+	results = push(syncMerge, SyncChange{ID: coffee.ID, BaseRevision: results[1].Revision, Base: &edited, Expense: &noted})
+	if results[0].Status != "applied" || results[0].Expense.Amount != -5 || results[0].Expense.Notes != "oat milk" {
+		t.Errorf("Expected both changes to be merged, got %+v", results[0])
+	}
+	merged := results[0]
+
+	// both sides changing the amount is a conflict, under lww the later write wins
+	server, _ = store.GetExpense(coffee.ID)
+	server.Amount = -6
+	store.UpdateExpense(coffee.ID, server)
+	mine := *merged.Expense
+	mine.Amount = -7
+	results = push(syncMerge, SyncChange{ID: coffee.ID, BaseRevision: merged.Revision, Base: merged.Expense, Expense: &mine})
+	if results[0].Status != "conflict" || results[0].Expense.Amount != -6 {
+		t.Errorf("Expected a conflict returning the server's copy, got %+v", results[0])
+	}
+	results = push(syncLastWriteWins, SyncChange{ID: coffee.ID, BaseRevision: merged.Revision, UpdatedAt: time.Now().Add(-time.Hour), Expense: &mine})
+	if results[0].Status != "conflict" {
+		t.Errorf("Expected an older client write to lose, got %+v", results[0])
+	}
+	results = push(syncLastWriteWins, SyncChange{ID: coffee.ID, BaseRevision: merged.Revision, Expense: &mine})
+	if results[0].Status != "applied" || results[0].Expense.Amount != -7 {
+		t.Errorf("Expected the latest write to win, got %+v", results[0])
+	}
+
+	// deletes leave a tombstone, and repeating them is harmless
+	before := pull(0).Revision
+	for range 2 {
+		if results = push("", SyncChange{ID: lunchID, Deleted: true}); results[0].Status != "applied" {
+			t.Errorf("Expected the delete to be applied, got %+v", results[0])
+		}
+	}
+	changes = pull(before)
+	if len(changes.Changes) != 1 || changes.Changes[0].ID != lunchID || changes.Changes[0].DeletedAt == nil || changes.Changes[0].Expense != nil {
+		t.Errorf("Expected a tombstone for the deleted expense, got %+v", changes)
+	}
+
+	if results = push("", SyncChange{ID: "not-a-uuid", Expense: &mine}); results[0].Status != "error" {
+		t.Errorf("Expected an error for an ID that isn't a UUID, got %+v", results[0])
+	}
+}
+
+func TestChangeFeed(t *testing.T) {
+	store := newTestStore(t, storage.Expense{ID: "kept", Name: "Rent", Category: "Housing", Amount: -900, Date: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)})
+	handler := NewHandler(store)
+	changes := func(since int64, limit int) ChangeFeed {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.GetChanges(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/changes?since=%d&limit=%d", since, limit), nil))
+		var feed ChangeFeed
+		json.NewDecoder(w.Body).Decode(&feed)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		return feed
+	}
+	initial := changes(0, maxPerPage)
+	if initial.HasMore || initial.Cursor == 0 {
+		t.Fatalf("Expected the whole history in one page, got %+v", initial)
+	}
+	var types []string
+	for _, change := range initial.Changes {
+		if change.Action != "created" {
+			t.Errorf("Expected every record to be created since 0, got %+v", change)
+		}
+		if !slices.Contains(types, change.Type) {
+			types = append(types, change.Type)
+		}
+	}
+	if len(types) != 2 {
+		t.Errorf("Expected expense and config records, got %v", types)
+	}
+
+	store.AddExpense(storage.Expense{ID: "new", Name: "Lunch", Category: "Food", Amount: -12, Date: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)})
+	store.UpdateCurrency("eur")
+	kept, _ := store.GetExpense("kept")
+	kept.Amount = -950
+	store.UpdateExpense("kept", kept)
+	store.RemoveExpense("new")
+	store.UpdateCurrency("eur") // unchanged, not a change
+
+	feed := changes(initial.Cursor, maxPerPage)
+	actions := map[string]string{}
+	for _, change := range feed.Changes {
+		actions[change.Type+":"+change.ID] = change.Action
+	}
+	want := map[string]string{"config:currency": "updated", "expense:kept": "updated", "expense:new": "deleted"}
+	if fmt.Sprint(actions) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, actions)
+	}
+	for _, change := range feed.Changes {
+		if change.ID == "currency" && change.Data != "eur" {
+			t.Errorf("Expected the new currency as data, got %s", change.Data)
+		}
+		if change.Action == "deleted" && (change.Data != nil || change.DeletedAt == nil) {
+			t.Errorf("Expected a tombstone without data, got %+v", change)
+		}
+	}
+
+	// paging by the expenses keeps the cursor on the last change returned
+	first := changes(initial.Cursor, 1)
+	if !first.HasMore || len(first.Changes) == 0 {
+		t.Fatalf("Expected more changes after a page of 1, got %+v", first)
+	}
+	rest := changes(first.Cursor, maxPerPage)
+	if rest.HasMore || len(first.Changes)+len(rest.Changes) != len(feed.Changes) || rest.Cursor != feed.Cursor {
+		t.Errorf("Expected the pages to add up to %d changes, got %+v and %+v", len(feed.Changes), first, rest)
+	}
+}
+
+func TestExpenseTimestamps(t *testing.T) {
+	store := newTestStore(t)
+	handler := NewHandler(store)
+	date := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	store.AddExpense(storage.Expense{ID: "first", Name: "Rent", Category: "Housing", Amount: -900, Date: date})
+	time.Sleep(5 * time.Millisecond)
+	store.AddExpense(storage.Expense{ID: "second", Name: "Lunch", Category: "Food", Amount: -12, Date: date})
+	created, _ := store.GetExpense("first")
+	if created.CreatedAt == nil || created.UpdatedAt == nil || !created.UpdatedAt.Equal(*created.CreatedAt) {
+		t.Fatalf("Expected a new expense to be updated when created, got %v and %v", created.CreatedAt, created.UpdatedAt)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	edited := created
+	edited.Amount = -950
+	edited.CreatedAt = nil
+	store.UpdateExpense("first", edited)
+	updated, _ := store.GetExpense("first")
+	if updated.CreatedAt == nil || !updated.CreatedAt.Equal(*created.CreatedAt) {
+		t.Errorf("Expected the creation time to be kept, got %v", updated.CreatedAt)
+	}
+	if updated.UpdatedAt == nil || !updated.UpdatedAt.After(*created.CreatedAt) {
+		t.Errorf("Expected an update time after creation, got %v", updated.UpdatedAt)
+	}
+	store.UpdateExpense("first", updated) // unchanged, not an update
+	if again, _ := store.GetExpense("first"); !again.UpdatedAt.Equal(*updated.UpdatedAt) {
+		t.Errorf("Expected an unchanged write to keep the update time, got %v", again.UpdatedAt)
+	}
+
+	for sortBy, want := range map[string]string{"created": "second,first", "updated": "first,second"} {
+		w := httptest.NewRecorder()
+		handler.GetExpenses(w, httptest.NewRequest(http.MethodGet, "/expenses?sort="+sortBy, nil))
+		var expenses []storage.Expense
+		json.NewDecoder(w.Body).Decode(&expenses)
+		var ids []string
+		for _, expense := range expenses {
+			ids = append(ids, expense.ID)
+		}
+		if strings.Join(ids, ",") != want {
+			t.Errorf("Expected %s sorted by %s, got %v", want, sortBy, ids)
+		}
+	}
+	w := httptest.NewRecorder()
+	handler.GetExpenses(w, httptest.NewRequest(http.MethodGet, "/expenses?sort=amount", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown sort, got %d", w.Code)
+	}
+}
+
+func TestTenantRouter(t *testing.T) {
+	t.Setenv("PUBLIC_URL", "https://expenses.example.com")
+	t.Setenv("PUBLIC_URL_JONESES", "https://joneses.example.org")
+	if got := tenantPublicURL("smiths"); got != "https://smiths.expenses.example.com" {
+		t.Errorf("Expected the tenant's subdomain of PUBLIC_URL, got %q", got)
+	}
+	if got := tenantPublicURL("joneses"); got != "https://joneses.example.org" {
+		t.Errorf("Expected the tenant's own PUBLIC_URL, got %q", got)
+	}
+
+	servers := map[string]http.Handler{}
+	for _, tenant := range []string{"smiths", "joneses"} {
+		store := newTestStore(t, storage.Expense{ID: tenant, Name: "Rent", Category: "Housing", Amount: -900, Date: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)})
+		handler := NewTenantHandler(tenant, store)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/expenses", handler.GetExpenses)
+		servers[tenant] = mux
+	}
+	router := NewTenantRouter(servers)
+	expenses := func(r *http.Request) (int, string, *httptest.ResponseRecorder) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		var list []storage.Expense
+		json.NewDecoder(bytes.NewReader(w.Body.Bytes())).Decode(&list)
+		if len(list) != 1 {
+			return w.Code, "", w
+		}
+		return w.Code, list[0].ID, w
+	}
+
+	bySubdomain := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+	bySubdomain.Host = "joneses.expenses.example.com:8080"
+	if code, id, _ := expenses(bySubdomain); code != http.StatusOK || id != "joneses" {
+		t.Errorf("Expected the expenses of joneses by subdomain, got %d %q", code, id)
+	}
+	code, id, w := expenses(httptest.NewRequest(http.MethodGet, "/t/smiths/expenses", nil))
+	if code != http.StatusOK || id != "smiths" || len(w.Result().Cookies()) != 0 {
+		t.Errorf("Expected the expenses of smiths by path prefix, got %d %q", code, id)
+	}
+	// the pages' absolute links would leave the prefix, so browsers need the subdomain
+	page := httptest.NewRequest(http.MethodGet, "/t/smiths/", nil)
+	page.Header.Set("Accept", "text/html,application/xhtml+xml")
+	if code, _, w := expenses(page); code != http.StatusNotFound || !strings.Contains(w.Body.String(), "subdomain") {
+		t.Errorf("Expected status 404 pointing to the subdomain for a page, got %d", code)
+	}
+	stale := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+	stale.AddCookie(&http.Cookie{Name: "expenseowl_tenant", Value: "smiths"})
+	if code, _, _ := expenses(stale); code != http.StatusNotFound {
+		t.Errorf("Expected a tenant cookie not to route, got %d", code)
+	}
+
+	for _, target := range []string{"/expenses", "/t/browns/expenses"} {
+		if code, _, _ := expenses(httptest.NewRequest(http.MethodGet, target, nil)); code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for %s, got %d", target, code)
+		}
+	}
+	if code, _, w := expenses(httptest.NewRequest(http.MethodGet, "/t/smiths", nil)); code != http.StatusMovedPermanently || w.Header().Get("Location") != "/t/smiths/" {
+		t.Errorf("Expected a redirect to /t/smiths/, got %d %s", code, w.Header().Get("Location"))
+	}
+
+	t.Setenv("TENANTS", "smiths, Joneses")
+	if tenants, err := TenantsFromEnv(); err != nil || strings.Join(tenants, ",") != "smiths,joneses" {
+		t.Errorf("Expected smiths and joneses, got %v, %v", tenants, err)
+	}
+	for _, invalid := range []string{"smiths,smiths", "the_smiths", "-smiths"} {
+		t.Setenv("TENANTS", invalid)
+		if _, err := TenantsFromEnv(); err == nil {
+			t.Errorf("Expected TENANTS=%s to be rejected", invalid)
+		}
+	}
+}
+
+// TestTenantAccessControl checks that each tenant needs its own users, and doesn't share the
+// users or default role of another
+func TestTenantAccessControl(t *testing.T) {
+	t.Setenv("ACCESS_ROLES", "alice:admin")
+	t.Setenv("ACCESS_DEFAULT_ROLE", "viewer")
+	if _, err := TenantAccessControlFromEnv("smiths", nil); err == nil || !strings.Contains(err.Error(), "ACCESS_ROLES_SMITHS") {
+		t.Errorf("Expected a tenant without its own users to be an error, got %v", err)
+	}
+
+	t.Setenv("ACCESS_ROLES_SMITHS", "bob:editor")
+	t.Setenv("ACCESS_ROLES_JONES_FAMILY", "carol:viewer")
+	t.Setenv("ACCESS_DEFAULT_ROLE_JONES_FAMILY", "viewer")
+	smiths, err := TenantAccessControlFromEnv("smiths", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	jones, err := TenantAccessControlFromEnv("jones-family", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, tc := range []struct {
+		access *AccessControl
+		user   string
+		want   Role
+	}{
+		{smiths, "bob", RoleEditor},
+		{smiths, "alice", RolePublic},
+		{smiths, "carol", RolePublic},
+		{jones, "carol", RoleViewer},
+		{jones, "bob", RoleViewer},
+		{jones, "alice", RoleViewer},
+	} {
+		if got := tc.access.role(tc.user); got != tc.want {
+			t.Errorf("Expected %s to be %s, got %s", tc.user, tc.want, got)
+		}
+	}
+}
+
+func TestSuggestExpenseNames(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC) }
+	store := newTestStore(t,
+		storage.Expense{ID: "1", Name: "Starbucks", Category: "Food", SubCategory: "Coffee", Amount: -4.5, Currency: "usd", Date: day(1)},
+		storage.Expense{ID: "2", Name: "Starbucks", Category: "Food", SubCategory: "Coffee", Amount: -4.5, Currency: "usd", Date: day(2)},
+		storage.Expense{ID: "3", Name: "Starbucks", Category: "Food", SubCategory: "Coffee", Amount: -5, Currency: "usd", Date: day(3)},
+		storage.Expense{ID: "4", Name: "Starbucks", Category: "Shopping", Amount: -20, Currency: "usd", Date: day(4)},
+		storage.Expense{ID: "5", Name: "Star Market", Category: "Food", Amount: -60, Currency: "usd", Date: day(5)},
+		storage.Expense{ID: "6", Name: "Costa", Category: "Food", Amount: -3, Currency: "usd", Date: day(6)},
+	)
+	handler := NewHandler(store)
+	suggest := func(query string) (int, []storage.NameSuggestion) {
+		w := httptest.NewRecorder()
+		handler.SuggestExpenseNames(w, httptest.NewRequest(http.MethodGet, "/api/expenses/suggest?"+query, nil))
+		var suggestions []storage.NameSuggestion
+		json.NewDecoder(w.Body).Decode(&suggestions)
+		return w.Code, suggestions
+	}
+
+	code, suggestions := suggest("q=STAR")
+	if code != http.StatusOK || len(suggestions) != 2 {
+		t.Fatalf("Expected 2 suggestions, got %d %+v", code, suggestions)
+	}
+	first := suggestions[0]
+	if first.Name != "Starbucks" || first.Uses != 4 || first.Category != "Food" || first.SubCategory != "Coffee" || first.Amount != -4.5 || !first.LastUsed.Equal(day(4)) {
+		t.Errorf("Expected Starbucks with its most common fields, got %+v", first)
+	}
+	if suggestions[1].Name != "Star Market" {
+		t.Errorf("Expected Star Market second, got %+v", suggestions[1])
+	}
+	if _, suggestions := suggest("q=star&limit=1"); len(suggestions) != 1 {
+		t.Errorf("Expected 1 suggestion with limit=1, got %d", len(suggestions))
+	}
+	for _, query := range []string{"", "q=%20", "q=star&limit=0", "q=star&limit=51"} {
+		if code, _ := suggest(query); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, code)
+		}
+	}
+}
+
+func TestParseAndAddExpense(t *testing.T) {
+	store := newTestStore(t)
+	store.AddSubCategory("Food", "Restaurants")
+	store.UpdateSubCategoryMappings([]storage.SubCategoryMappingRule{{Pattern: "uber", MatchType: "contains", Category: "Transportation"}})
+	handler := NewHandler(store)
+	parse := func(text string, query string) (int, QuickEntryResponse, string) {
+		t.Helper()
+		body, _ := json.Marshal(QuickEntryRequest{Text: text})
+		w := httptest.NewRecorder()
+		handler.ParseAndAddExpense(w, httptest.NewRequest(http.MethodPost, "/api/expenses/parse-and-add"+query, bytes.NewReader(body)))
+		var response QuickEntryResponse
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response, w.Body.String()
+	}
+
+	code, response, body := parse(" Lunch ; 12,50 ; food/restaurants ; 2026-03-02 ", "")
+	expense := response.Expense
+	if code != http.StatusOK || !response.Saved || expense.ID == "" {
+		t.Fatalf("Expected the expense to be saved, got %d: %s", code, body)
+	}
+	if expense.Name != "Lunch" || expense.Amount != -12.5 || expense.Category != "Food" || expense.SubCategory != "Restaurants" || !expense.Date.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the parsed fields, got %+v", expense)
+	}
+	if len(response.Warnings) != 1 || !strings.Contains(response.Warnings[0], "decimal separator") {
+		t.Errorf("Expected a warning about the decimal comma, got %v", response.Warnings)
+	}
+	if saved, err := store.GetExpense(expense.ID); err != nil || saved.Name != "Lunch" {
+		t.Errorf("Expected the expense to be stored, got %+v, %v", saved, err)
+	}
+
+	// a comma before one or two digits is a decimal separator, otherwise it separates thousands
+	for text, want := range map[string]float64{"Rent;1,500": -1500, "Rent;12,5": -12.5, "Rent;1,500,000": -1500000, "Rent;1,500.25": -1500.25} {
+		if code, response, body := parse(text, "?dryRun=true"); code != http.StatusOK || response.Expense.Amount != want {
+			t.Errorf("Expected %q to be %v, got %d: %s", text, want, code, body)
+		}
+	}
+
+	// rules fill in an unknown category
+	code, response, body = parse("Uber home;+30;Rides;yesterday;extra", "?dryRun=true")
+	if code != http.StatusOK || response.Saved || response.Expense.Category != "Transportation" || response.Expense.Amount != 30 {
+		t.Errorf("Expected income categorized by the rules without saving, got %d: %s", code, body)
+	}
+	if len(response.Warnings) != 2 {
+		t.Errorf("Expected warnings for the extra field and the unknown category, got %v", response.Warnings)
+	}
+	if expenses, _ := store.GetAllExpenses(); len(expenses) != 1 {
+		t.Errorf("Expected a dry run not to save, got %d expenses", len(expenses))
+	}
+
+	for _, text := range []string{"", ";12", "Lunch", "Lunch;abc", "Lunch;-0", "Lunch;12;Food;someday"} {
+		if code, _, _ := parse(text, ""); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", text, code)
+		}
+	}
+}
+
+func TestTaxReport(t *testing.T) {
+	date := func(month time.Month) time.Time { return time.Date(2026, month, 10, 0, 0, 0, 0, time.UTC) }
+	// added before the rates were set, so split when reported
+	store := newTestStore(t, storage.Expense{ID: "imported", Name: "Laptop", Category: "Shopping", Amount: -1200, Date: date(time.May)})
+	store.UpdateCategoryMeta(map[string]storage.CategoryMeta{"Shopping": {TaxRate: 20}, "Income": {TaxRate: 20}})
+	handler := NewHandler(store)
+	add := func(expense storage.Expense) storage.Expense {
+		t.Helper()
+		body, _ := json.Marshal(expense)
+		w := httptest.NewRecorder()
+		handler.AddExpense(w, httptest.NewRequest(http.MethodPut, "/expense", bytes.NewReader(body)))
+		var added storage.Expense
+		json.NewDecoder(w.Body).Decode(&added)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		return added
+	}
+	monitor := add(storage.Expense{Name: "Monitor", Category: "Shopping", Amount: -240, Date: date(time.February)})
+	if monitor.Tax == nil || monitor.Tax.Rate != 20 || monitor.Tax.Amount != 40 {
+		t.Errorf("Expected the category's 20%% to be split off, got %+v", monitor.Tax)
+	}
+	add(storage.Expense{Name: "Train", Category: "Transportation", Amount: -55, Date: date(time.February), Tax: &storage.TaxDetails{Rate: 10, Amount: 5}})
+	add(storage.Expense{Name: "Invoice", Category: "Income", Amount: 3600, Date: date(time.March)})
+	add(storage.Expense{Name: "Lunch", Category: "Food", Amount: -20, Date: date(time.March)})
+
+	w := httptest.NewRecorder()
+	handler.GetTaxReport(w, httptest.NewRequest(http.MethodGet, "/api/reports/tax?year=2026&calendar=true", nil))
+	var report TaxReport
+	json.NewDecoder(w.Body).Decode(&report)
+	if w.Code != http.StatusOK || len(report.Quarters) != 4 {
+		t.Fatalf("Expected 4 quarters, got %d: %+v", w.Code, report)
+	}
+	q1, q2 := report.Quarters[0], report.Quarters[1]
+	if q1.Reclaimable != 45 || q1.Collected != 600 || q1.Net != 555 || q1.Categories["Shopping"] != 40 || q1.End != "2026-03-31" {
+		t.Errorf("Expected 45 reclaimable and 600 collected in Q1, got %+v", q1)
+	}
+	if q2.Reclaimable != 200 || q2.Collected != 0 {
+		t.Errorf("Expected the imported laptop split at 20%% in Q2, got %+v", q2)
+	}
+	if report.Total.Reclaimable != 245 || report.Total.Net != 355 || report.Total.Categories["Shopping"] != 240 {
+		t.Errorf("Expected the year's totals, got %+v", report.Total)
+	}
+
+	body, _ := json.Marshal(storage.Expense{Name: "Odd", Category: "Food", Amount: -10, Tax: &storage.TaxDetails{Rate: 20, Amount: 11}})
+	w = httptest.NewRecorder()
+	handler.AddExpense(w, httptest.NewRequest(http.MethodPut, "/expense", bytes.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for tax above the amount, got %d", w.Code)
+	}
+}
+
+func TestExpenseClasses(t *testing.T) {
+	date := func(day int) time.Time { return time.Date(2026, time.March, day, 0, 0, 0, 0, time.UTC) }
+	store := newTestStore(t,
+		storage.Expense{ID: "groceries", Name: "Groceries", Category: "Food", Amount: -80, Date: date(2)},
+		storage.Expense{ID: "software", Name: "IDE license", Category: "Shopping", Amount: -200, Date: date(3), Class: storage.ClassBusiness},
+		storage.Expense{ID: "hotel", Name: "Hotel", Category: "Travel", Amount: -300, Date: date(5), Class: storage.ClassReimbursable},
+		storage.Expense{ID: "flight", Name: "Flight", Category: "Travel", Amount: -150.5, Date: date(1), Class: storage.ClassReimbursable},
+		storage.Expense{ID: "taxi", Name: "Taxi", Category: "Transportation", Amount: -40, Date: date(4), Class: storage.ClassReimbursed},
+	)
+	handler := NewHandler(store)
+	find := func(query string) []storage.Expense {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.GetExpenses(w, httptest.NewRequest(http.MethodGet, "/expenses?"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d", query, w.Code)
+		}
+		var expenses []storage.Expense
+		json.NewDecoder(w.Body).Decode(&expenses)
+		return expenses
+	}
+	if found := find("class=business"); len(found) != 1 || found[0].ID != "software" {
+		t.Errorf("Expected the business expense, got %+v", found)
+	}
+	if found := find("class=Personal"); len(found) != 1 || found[0].ID != "groceries" {
+		t.Errorf("Expected expenses without a class to be personal, got %+v", found)
+	}
+	w := httptest.NewRecorder()
+	handler.GetExpenses(w, httptest.NewRequest(http.MethodGet, "/expenses?class=work", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown class, got %d", w.Code)
+	}
+
+	body, _ := json.Marshal(storage.Expense{Name: "Lunch", Category: "Food", Amount: -12, Date: date(6), Class: "Personal"})
+	w = httptest.NewRecorder()
+	handler.AddExpense(w, httptest.NewRequest(http.MethodPut, "/expense", bytes.NewReader(body)))
+	var added storage.Expense
+	json.NewDecoder(w.Body).Decode(&added)
+	if w.Code != http.StatusOK || added.Class != "" {
+		t.Errorf("Expected personal to be stored as no class, got %d: %q", w.Code, added.Class)
+	}
+	body, _ = json.Marshal(storage.Expense{Name: "Lunch", Category: "Food", Amount: -12, Date: date(6), Class: "shared"})
+	w = httptest.NewRecorder()
+	handler.AddExpense(w, httptest.NewRequest(http.MethodPut, "/expense", bytes.NewReader(body)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown class, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.GetReimbursementReport(w, httptest.NewRequest(http.MethodGet, "/api/reports/reimbursements", nil))
+	var report ReimbursementReport
+	json.NewDecoder(w.Body).Decode(&report)
+	if w.Code != http.StatusOK || report.Count != 2 || report.Pending != 450.5 || report.Categories["Travel"] != 450.5 {
+		t.Fatalf("Expected 450.50 pending on 2 expenses, got %d: %+v", w.Code, report)
+	}
+	if report.Oldest != "2026-03-01" || report.Expenses[0].ID != "flight" {
+		t.Errorf("Expected the flight first as the oldest, got %+v", report)
+	}
+
+	body, _ = json.Marshal(map[string][]string{"ids": {"flight", "groceries", "missing"}})
+	w = httptest.NewRecorder()
+	handler.SettleReimbursements(w, httptest.NewRequest(http.MethodPut, "/api/reports/reimbursements/settle", bytes.NewReader(body)))
+	var settled map[string]int
+	json.NewDecoder(w.Body).Decode(&settled)
+	if w.Code != http.StatusOK || settled["settled"] != 1 {
+		t.Errorf("Expected only the reimbursable flight to be settled, got %d: %v", w.Code, settled)
+	}
+	if flight, _ := store.GetExpense("flight"); flight.Class != storage.ClassReimbursed {
+		t.Errorf("Expected the flight to be reimbursed, got %q", flight.Class)
+	}
+	if groceries, _ := store.GetExpense("groceries"); groceries.Class != "" {
+		t.Errorf("Expected the groceries to stay personal, got %q", groceries.Class)
+	}
+	if found := find("class=reimbursable"); len(found) != 1 || found[0].ID != "hotel" {
+		t.Errorf("Expected only the hotel to be pending, got %+v", found)
+	}
+}
+
+func TestProjects(t *testing.T) {
+	date := func(day int) time.Time { return time.Date(2026, time.March, day, 0, 0, 0, 0, time.UTC) }
+	store := newTestStore(t,
+		storage.Expense{ID: "invoice", Name: "Invoice 12", Category: "Income", Amount: 5000, Date: date(20), Project: "Website"},
+		storage.Expense{ID: "hosting", Name: "Hosting", Category: "Utilities", Amount: -300, Date: date(2), Project: "Website"},
+		storage.Expense{ID: "fonts", Name: "Fonts", Category: "Shopping", Amount: -200, Date: date(3), Project: "Website"},
+		storage.Expense{ID: "camera", Name: "Camera rental", Category: "Shopping", Amount: -400, Date: date(4), Project: "Shoot"},
+		storage.Expense{ID: "lunch", Name: "Lunch", Category: "Food", Amount: -15, Date: date(5)},
+	)
+	handler := NewHandler(store)
+	put := func(projects string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.UpdateProjects(w, httptest.NewRequest(http.MethodPut, "/api/projects/edit", strings.NewReader(projects)))
+		return w.Code
+	}
+	if code := put(`[{"name": "Website", "client": "Acme"}, {"name": "website"}]`); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a duplicate project, got %d", code)
+	}
+	if code := put(`[{"name": "Website", "client": "Acme"}, {"name": "Shoot", "closed": true}, {"name": "App"}]`); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	w := httptest.NewRecorder()
+	handler.GetProjects(w, httptest.NewRequest(http.MethodGet, "/api/projects", nil))
+	var projects []storage.Project
+	json.NewDecoder(w.Body).Decode(&projects)
+	if len(projects) != 3 || projects[0].Name != "App" || projects[2].Client != "Acme" {
+		t.Errorf("Expected 3 projects sorted by name, got %+v", projects)
+	}
+
+	add := func(expense storage.Expense, id string) (int, storage.Expense) {
+		t.Helper()
+		body, _ := json.Marshal(expense)
+		w := httptest.NewRecorder()
+		if id == "" {
+			handler.AddExpense(w, httptest.NewRequest(http.MethodPut, "/expense", bytes.NewReader(body)))
+		} else {
+			handler.EditExpense(w, httptest.NewRequest(http.MethodPut, "/expense/edit?id="+id, bytes.NewReader(body)))
+		}
+		var saved storage.Expense
+		json.NewDecoder(w.Body).Decode(&saved)
+		return w.Code, saved
+	}
+	if code, saved := add(storage.Expense{Name: "Plugin", Category: "Shopping", Amount: -50, Date: date(6), Project: "website"}, ""); code != http.StatusOK || saved.Project != "Website" {
+		t.Errorf("Expected the project's configured name, got %d: %q", code, saved.Project)
+	}
+	if code, _ := add(storage.Expense{Name: "Plugin", Category: "Shopping", Amount: -50, Date: date(6), Project: "Unknown"}, ""); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown project, got %d", code)
+	}
+	if code, _ := add(storage.Expense{Name: "Lens", Category: "Shopping", Amount: -80, Date: date(6), Project: "Shoot"}, ""); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a new expense on a closed project, got %d", code)
+	}
+	if code, _ := add(storage.Expense{Name: "Camera rental", Category: "Shopping", Amount: -450, Date: date(4), Project: "Shoot"}, "camera"); code != http.StatusOK {
+		t.Errorf("Expected expenses already on a closed project to stay editable, got %d", code)
+	}
+
+	w = httptest.NewRecorder()
+	handler.GetProjectReport(w, httptest.NewRequest(http.MethodGet, "/api/reports/projects?from=2026-03-01&to=2026-03-31", nil))
+	var report ProjectReport
+	json.NewDecoder(w.Body).Decode(&report)
+	if w.Code != http.StatusOK || len(report.Projects) != 3 {
+		t.Fatalf("Expected Website, App, and Shoot, got %d: %+v", w.Code, report)
+	}
+	website, app, shoot := report.Projects[0], report.Projects[1], report.Projects[2]
+	if website.Name != "Website" || website.Client != "Acme" || website.Count != 4 || website.Income != 5000 || website.Expenses != 550 ||
+		website.Profit != 4450 || website.Margin != 89 || website.Categories["Shopping"] != 250 {
+		t.Errorf("Expected Website to be most profitable, got %+v", website)
+	}
+	if app.Name != "App" || app.Count != 0 || shoot.Name != "Shoot" || !shoot.Closed || shoot.Profit != -450 || shoot.Margin != 0 {
+		t.Errorf("Expected the open App without expenses and the closed Shoot at a loss, got %+v and %+v", app, shoot)
+	}
+	if report.Total.Income != 5000 || report.Total.Expenses != 1000 || report.Total.Profit != 4000 {
+		t.Errorf("Expected the totals of all projects, got %+v", report.Total)
+	}
+
+	w = httptest.NewRecorder()
+	handler.GetProjectReport(w, httptest.NewRequest(http.MethodGet, "/api/reports/projects?project=shoot", nil))
+	report = ProjectReport{}
+	json.NewDecoder(w.Body).Decode(&report)
+	if len(report.Projects) != 1 || report.Projects[0].Name != "Shoot" {
+		t.Errorf("Expected only Shoot with ?project=, got %+v", report.Projects)
+	}
+	w = httptest.NewRecorder()
+	handler.GetExpenses(w, httptest.NewRequest(http.MethodGet, "/expenses?project=WEBSITE", nil))
+	var expenses []storage.Expense
+	json.NewDecoder(w.Body).Decode(&expenses)
+	if len(expenses) != 4 {
+		t.Errorf("Expected the 4 Website expenses, got %d", len(expenses))
+	}
+}
+
+func TestDashboardLayout(t *testing.T) {
+	handler := NewHandler(newTestStore(t))
+	call := func(method, body string) (int, DashboardLayoutResponse) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.DashboardLayout(w, httptest.NewRequest(method, "/api/dashboard/layout", strings.NewReader(body)))
+		var layout DashboardLayoutResponse
+		json.NewDecoder(w.Body).Decode(&layout)
+		return w.Code, layout
+	}
+	code, layout := call(http.MethodGet, "")
+	if code != http.StatusOK || layout.Custom || layout.Columns != 2 || len(layout.Widgets) != 3 || layout.Widgets[0].Type != storage.WidgetCategories {
+		t.Fatalf("Expected the default layout, got %d: %+v", code, layout)
+	}
+	if len(layout.Available) != len(storage.DashboardWidgets) {
+		t.Errorf("Expected the available widgets, got %v", layout.Available)
+	}
+
+	for _, invalid := range []string{
+		`{"columns": 5, "widgets": [{"type": "recent"}]}`,
+		`{"columns": 2, "widgets": []}`,
+		`{"columns": 2, "widgets": [{"type": "weather"}]}`,
+		`{"columns": 2, "widgets": [{"type": "recent"}, {"type": "recent"}]}`,
+		`{"columns": 2, "widgets": [{"type": "trend", "width": 3}]}`,
+		`{"columns": 2, "widgets": [{"type": "recent", "limit": 100}]}`,
+	} {
+		if code, _ := call(http.MethodPut, invalid); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", invalid, code)
+		}
+	}
+	code, layout = call(http.MethodPut, `{"columns": 3, "widgets": [{"type": "budgets", "width": 1}, {"type": "trend", "width": 2}, {"type": "recent", "limit": 8}, {"type": "categories"}]}`)
+	if code != http.StatusOK || !layout.Custom || len(layout.Widgets) != 4 {
+		t.Fatalf("Expected the custom layout, got %d: %+v", code, layout)
+	}
+	if trend, recent, categories := layout.Widgets[1], layout.Widgets[2], layout.Widgets[3]; trend.Limit != 6 || recent.Width != 3 || recent.Limit != 8 || categories.Width != 3 || categories.Limit != 0 {
+		t.Errorf("Expected default widths and limits to be filled in, got %+v", layout.Widgets)
+	}
+
+	// saving the other preferences keeps the layout
+	w := httptest.NewRecorder()
+	handler.UpdatePreferences(w, httptest.NewRequest(http.MethodPut, "/api/preferences/edit", strings.NewReader(`{"theme": "dark"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if _, layout = call(http.MethodGet, ""); !layout.Custom || layout.Columns != 3 {
+		t.Errorf("Expected the layout to be kept, got %+v", layout)
+	}
+	if code, layout = call(http.MethodDelete, ""); code != http.StatusOK || layout.Custom || layout.Columns != 2 {
+		t.Errorf("Expected the default layout after a reset, got %d: %+v", code, layout)
+	}
+	if preferences, _ := handler.storage.GetPreferences(""); preferences.Theme != "dark" || preferences.Dashboard != nil {
+		t.Errorf("Expected the reset to keep the other preferences, got %+v", preferences)
+	}
+}
+
+func TestSpendingPatterns(t *testing.T) {
+	// March 1st 2026 is a Sunday, so two weeks hold every weekday twice
+	date := func(day int) time.Time { return time.Date(2026, time.March, day, 12, 0, 0, 0, time.UTC) }
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "rent", Name: "Rent", Category: "Rent", Amount: -1000, Date: date(1), RecurringID: "monthly-rent"},
+		storage.Expense{ID: "coffee", Name: "Coffee", Category: "Food", Amount: -20, Date: date(2)},
+		storage.Expense{ID: "lunch", Name: "Lunch", Category: "Food", Amount: -20, Date: date(3)},
+		storage.Expense{ID: "salary", Name: "Salary", Category: "Income", Amount: 500, Date: date(4)},
+		storage.Expense{ID: "bar", Name: "Bar", Category: "Entertainment", Amount: -60, Date: date(7)},
+		storage.Expense{ID: "brunch", Name: "Brunch", Category: "Food", Amount: -40, Date: date(8)},
+		storage.Expense{ID: "later", Name: "Cinema", Category: "Entertainment", Amount: -30, Date: date(27)},
+	))
+	w := httptest.NewRecorder()
+	handler.GetSpendingPatterns(w, httptest.NewRequest(http.MethodGet, "/api/insights/patterns?from=2026-03-01&to=2026-03-14", nil))
+	var patterns SpendingPatterns
+	json.NewDecoder(w.Body).Decode(&patterns)
+	if w.Code != http.StatusOK || patterns.From != "2026-03-01" || patterns.To != "2026-03-14" || len(patterns.Weekdays) != 7 {
+		t.Fatalf("Expected patterns for the two weeks, got %d: %+v", w.Code, patterns)
+	}
+	if saturday := patterns.Weekdays[time.Saturday]; saturday.Days != 2 || saturday.Spent != 60 || saturday.Average != 30 {
+		t.Errorf("Expected 60 spent over 2 Saturdays, got %+v", saturday)
+	}
+	if sunday := patterns.Weekdays[time.Sunday]; sunday.Spent != 40 {
+		t.Errorf("Expected the recurring rent to be left out, got %+v", sunday)
+	}
+	if patterns.WeekdayAverage != 4 || patterns.WeekendAverage != 25 || patterns.WeekendRatio != 6.25 {
+		t.Errorf("Expected averages of 4 and 25 with a ratio of 6.25, got %+v", patterns)
+	}
+	if patterns.Period.Early != 100 || patterns.Period.Shape != ShapeFrontLoaded {
+		t.Errorf("Expected front-loaded spending, got %+v", patterns.Period)
+	}
+
+	w = httptest.NewRecorder()
+	handler.GetSpendingPatterns(w, httptest.NewRequest(http.MethodGet, "/api/insights/patterns?from=2026-03-15&to=2026-03-31", nil))
+	patterns = SpendingPatterns{}
+	json.NewDecoder(w.Body).Decode(&patterns)
+	if patterns.Period.Late != 100 || patterns.Period.Shape != ShapeBackLoaded || patterns.WeekendRatio != 0 {
+		t.Errorf("Expected back-loaded spending without a weekend ratio, got %+v", patterns)
+	}
+}
+
+func TestCategoryTrend(t *testing.T) {
+	now := time.Now()
+	monthsAgo := func(n int) time.Time { return time.Date(now.Year(), now.Month()-time.Month(n), 10, 12, 0, 0, 0, time.UTC) }
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "now", Name: "Market", Category: "Groceries", Amount: -300, Date: monthsAgo(0)},
+		storage.Expense{ID: "last", Name: "Market", Category: "Groceries", Amount: -150, Date: monthsAgo(1)},
+		storage.Expense{ID: "before", Name: "Market", Category: "Groceries", Amount: -150, Date: monthsAgo(2)},
+		storage.Expense{ID: "year", Name: "Market", Category: "Groceries", Amount: -200, Date: monthsAgo(12)},
+		storage.Expense{ID: "other", Name: "Cinema", Category: "Entertainment", Amount: -90, Date: monthsAgo(0)},
+	))
+	for _, query := range []string{"", "?category=Groceries&months=0", "?category=Groceries&months=abc"} {
+		w := httptest.NewRecorder()
+		handler.GetCategoryTrend(w, httptest.NewRequest(http.MethodGet, "/api/reports/category-trend"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.GetCategoryTrend(w, httptest.NewRequest(http.MethodGet, "/api/reports/category-trend?category=Groceries&months=3", nil))
+	var trend CategoryTrend
+	json.NewDecoder(w.Body).Decode(&trend)
+	if w.Code != http.StatusOK || len(trend.Months) != 3 || trend.Total != 600 || trend.Average != 200 {
+		t.Fatalf("Expected 3 months totaling 600, got %d: %+v", w.Code, trend)
+	}
+	current := trend.Months[2]
+	if current.Spent != 300 || current.MovingAverage != 200 || current.LastYear != 200 || current.Change == nil || *current.Change != 50 {
+		t.Errorf("Expected 300 against a moving average of 200 and 50%% over last year, got %+v", current)
+	}
+	if trend.Months[0].Change != nil || trend.Months[0].MovingAverage != 50 {
+		t.Errorf("Expected no change without spending a year earlier and the average to reach back, got %+v", trend.Months[0])
+	}
+	if season := trend.Seasonality[now.Month()-1]; season.Average != 300 || season.Index != 1.5 {
+		t.Errorf("Expected the current month 50%% above average, got %+v", season)
+	}
+}
+
+func TestExcludeFromReports(t *testing.T) {
+	now := time.Now()
+	store := newTestStore(t,
+		storage.Expense{ID: "groceries", Name: "Groceries", Category: "Food", Amount: -100, Date: now},
+		storage.Expense{ID: "savings", Name: "Move to savings", Category: "Transfers", Amount: -500, Date: now},
+		storage.Expense{ID: "friend", Name: "Dinner paid for a friend", Category: "Food", Amount: -60, Date: now, Excluded: true},
+	)
+	if err := store.UpdateCategoryMeta(map[string]storage.CategoryMeta{"Transfers": {Excluded: true}}); err != nil {
+		t.Fatalf("Failed to set category meta: %v", err)
+	}
+	if err := store.UpdateBudgets(map[string]float64{"Food": 200, "Transfers": 100}); err != nil {
+		t.Fatalf("Failed to set budgets: %v", err)
+	}
+	handler := NewHandler(store)
+
+	w := httptest.NewRecorder()
+	handler.GetExpenses(w, httptest.NewRequest(http.MethodGet, "/expenses", nil))
+	var expenses []storage.Expense
+	json.NewDecoder(w.Body).Decode(&expenses)
+	if len(expenses) != 3 {
+		t.Errorf("Expected excluded expenses to still be listed, got %d", len(expenses))
+	}
+
+	w = httptest.NewRecorder()
+	handler.GetTRMNLData(w, httptest.NewRequest(http.MethodGet, "/api/trmnl", nil))
+	var trmnl TRMNLResponse
+	json.NewDecoder(w.Body).Decode(&trmnl)
+	if trmnl.TotalExpenses != 100 || len(trmnl.AllCategories) != 1 {
+		t.Errorf("Expected only the groceries in TRMNL totals, got %+v", trmnl)
+	}
+
+	w = httptest.NewRecorder()
+	handler.GetBudgetStatus(w, httptest.NewRequest(http.MethodGet, "/budgets/status", nil))
+	var status BudgetStatusResponse
+	json.NewDecoder(w.Body).Decode(&status)
+	for _, budget := range status.Budgets {
+		if budget.Category == "Food" && budget.Spent != 100 || budget.Category == "Transfers" && budget.Spent != 0 {
+			t.Errorf("Expected excluded expenses to leave budgets alone, got %+v", budget)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.GetMerchantReport(w, httptest.NewRequest(http.MethodGet, "/api/reports/merchants", nil))
+	var merchants MerchantReport
+	json.NewDecoder(w.Body).Decode(&merchants)
+	if len(merchants.Merchants) != 1 || merchants.Merchants[0].Name != "Groceries" {
+		t.Errorf("Expected only the groceries merchant, got %+v", merchants.Merchants)
+	}
+
+	// including the expense again counts it
+	friend, _ := store.GetExpense("friend")
+	friend.Excluded = false
+	if err := store.UpdateExpense("friend", friend); err != nil {
+		t.Fatalf("Failed to update expense: %v", err)
+	}
+	w = httptest.NewRecorder()
+	handler.GetTRMNLData(w, httptest.NewRequest(http.MethodGet, "/api/trmnl", nil))
+	trmnl = TRMNLResponse{}
+	json.NewDecoder(w.Body).Decode(&trmnl)
+	if trmnl.TotalExpenses != 160 {
+		t.Errorf("Expected the included expense to count, got %v", trmnl.TotalExpenses)
+	}
+}
+
+func TestRefunds(t *testing.T) {
+	now := time.Now()
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "shoes", Name: "Shoe Store", Category: "Shopping", Amount: -120, Date: now},
+		storage.Expense{ID: "groceries", Name: "Market", Category: "Food", Amount: -30, Date: now},
+		storage.Expense{ID: "salary", Name: "Salary", Category: "Income", Amount: 1000, Date: now},
+		storage.Expense{ID: "cashback", Name: "Card credit", Category: "Income", Amount: 70, Date: now},
+	))
+	add := func(expense storage.Expense) (int, storage.Expense) {
+		t.Helper()
+		body, _ := json.Marshal(expense)
+		w := httptest.NewRecorder()
+		handler.AddExpense(w, httptest.NewRequest(http.MethodPut, "/expense", bytes.NewReader(body)))
+		var saved storage.Expense
+		json.NewDecoder(w.Body).Decode(&saved)
+		return w.Code, saved
+	}
+	code, refund := add(storage.Expense{Name: "Returned a pair", Category: "Income", Amount: 50, Date: now, RefundOf: "shoes"})
+	if code != http.StatusOK || refund.Category != "Shopping" {
+		t.Fatalf("Expected the refund to take the expense's category, got %d: %+v", code, refund)
+	}
+	for _, invalid := range []storage.Expense{
+		{Name: "Too much", Category: "Income", Amount: 100, Date: now, RefundOf: "shoes"},
+		{Name: "Of income", Category: "Income", Amount: 10, Date: now, RefundOf: "salary"},
+		{Name: "Negative", Category: "Shopping", Amount: -10, Date: now, RefundOf: "shoes"},
+		{Name: "Unknown", Category: "Income", Amount: 10, Date: now, RefundOf: "missing"},
+	} {
+		if code, _ := add(invalid); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", invalid.Name, code)
+		}
+	}
+
+	refunds := func(method, query, body string) (int, RefundStatus) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ExpenseResource(w, httptest.NewRequest(method, "/api/expenses/shoes/refunds"+query, strings.NewReader(body)))
+		var status RefundStatus
+		json.NewDecoder(w.Body).Decode(&status)
+		return w.Code, status
+	}
+	if code, status := refunds(http.MethodGet, "", ""); code != http.StatusOK || status.Status != RefundPartial || status.Refunded != 50 || status.Remaining != 70 || len(status.Refunds) != 1 {
+		t.Errorf("Expected a partial refund of 50, got %d: %+v", code, status)
+	}
+	if code, status := refunds(http.MethodPost, "", `{"id": "cashback"}`); code != http.StatusOK || status.Status != RefundFull || status.Remaining != 0 {
+		t.Errorf("Expected linking the credit to complete the refund, got %d: %+v", code, status)
+	}
+
+	// refunds come off the spending instead of counting as income
+	w := httptest.NewRecorder()
+	handler.GetTRMNLData(w, httptest.NewRequest(http.MethodGet, "/api/trmnl?income=true", nil))
+	var trmnl TRMNLResponse
+	json.NewDecoder(w.Body).Decode(&trmnl)
+	if trmnl.TotalExpenses != 30 || trmnl.TotalIncome == nil || *trmnl.TotalIncome != 1000 {
+		t.Errorf("Expected net spending of 30 and income of 1000, got %+v", trmnl)
+	}
+	w = httptest.NewRecorder()
+	handler.GetMerchantReport(w, httptest.NewRequest(http.MethodGet, "/api/reports/merchants", nil))
+	var merchants MerchantReport
+	json.NewDecoder(w.Body).Decode(&merchants)
+	for _, merchant := range merchants.Merchants {
+		if merchant.Name == "Shoe Store" && merchant.Spent != 0 {
+			t.Errorf("Expected the refunds to net out the merchant, got %+v", merchant)
+		}
+	}
+
+	if code, status := refunds(http.MethodDelete, "?id=cashback", ""); code != http.StatusOK || status.Status != RefundPartial {
+		t.Errorf("Expected unlinking to leave a partial refund, got %d: %+v", code, status)
+	}
+	if code, _ := refunds(http.MethodDelete, "?id=salary", ""); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an entry that isn't a refund, got %d", code)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, time.March, d, 12, 0, 0, 0, time.Local) }
+	store := newTestStore(t,
+		storage.Expense{ID: "groceries", Name: "Market", Category: "Food", Amount: -100, Date: day(3), Account: "Visa", Reconciled: true},
+		storage.Expense{ID: "fuel", Name: "Fuel", Category: "Transport", Amount: -50, Date: day(15), Account: "visa"},
+		storage.Expense{ID: "payment", Name: "Card payment", Category: "Income", Amount: 30, Date: day(20), Account: "Visa"},
+		storage.Expense{ID: "april", Name: "Market", Category: "Food", Amount: -40, Date: day(31).AddDate(0, 0, 2), Account: "Visa"},
+		storage.Expense{ID: "cash", Name: "Coffee", Category: "Food", Amount: -5, Date: day(10), Account: "Wallet"},
+	)
+	if err := store.UpdateAccounts([]storage.Account{{Name: "Visa", Type: storage.AccountLiability, Snapshots: []storage.BalanceSnapshot{{Date: "2026-02-28", Balance: 200}}}}); err != nil {
+		t.Fatalf("Failed to set accounts: %v", err)
+	}
+	handler := NewHandler(store)
+	reconcile := func(query string) (int, Reconciliation) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.GetReconciliation(w, httptest.NewRequest(http.MethodGet, "/api/reconcile"+query, nil))
+		var reconciliation Reconciliation
+		json.NewDecoder(w.Body).Decode(&reconciliation)
+		return w.Code, reconciliation
+	}
+
+	// what's owed goes up with spending and down with the payment
+	code, reconciliation := reconcile("?account=visa&from=2026-03-01&to=2026-03-31&balance=300")
+	if code != http.StatusOK || reconciliation.Account != "Visa" || reconciliation.Opening != 200 || reconciliation.Recorded != 320 || reconciliation.Cleared != 300 || reconciliation.Discrepancy != -20 {
+		t.Fatalf("Expected a discrepancy of -20 against 320 recorded, got %d: %+v", code, reconciliation)
+	}
+	if reconciliation.Reconciled != 1 || len(reconciliation.Unreconciled) != 2 || reconciliation.Unreconciled[0].ID != "fuel" {
+		t.Errorf("Expected fuel and the payment to be unreconciled, got %+v", reconciliation.Unreconciled)
+	}
+	if code, reconciliation := reconcile("?account=Wallet&from=2026-03-01&to=2026-03-31&balance=95&opening=100"); code != http.StatusOK || reconciliation.Recorded != 95 || reconciliation.Discrepancy != 0 {
+		t.Errorf("Expected an account without configuration to match from the given opening, got %d: %+v", code, reconciliation)
+	}
+	for _, query := range []string{"?from=2026-03-01&to=2026-03-31&balance=1", "?account=Visa&from=2026-03-31&to=2026-03-01&balance=1", "?account=Visa&from=2026-03-01&to=2026-03-31"} {
+		if code, _ := reconcile(query); code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.MarkReconciled(w, httptest.NewRequest(http.MethodPut, "/api/reconcile/mark", strings.NewReader(`{"ids": ["fuel", "payment", "groceries", "missing"]}`)))
+	var marked map[string]int
+	json.NewDecoder(w.Body).Decode(&marked)
+	if w.Code != http.StatusOK || marked["updated"] != 2 {
+		t.Fatalf("Expected 2 expenses marked reconciled, got %d: %v", w.Code, marked)
+	}
+	if _, reconciliation := reconcile("?account=Visa&from=2026-03-01&to=2026-03-31&balance=320"); reconciliation.Cleared != 320 || reconciliation.Discrepancy != 0 || len(reconciliation.Unreconciled) != 0 {
+		t.Errorf("Expected the statement to be fully reconciled, got %+v", reconciliation)
+	}
+	w = httptest.NewRecorder()
+	handler.MarkReconciled(w, httptest.NewRequest(http.MethodPut, "/api/reconcile/mark", strings.NewReader(`{"ids": ["fuel"], "reconciled": false}`)))
+	if expense, _ := store.GetExpense("fuel"); w.Code != http.StatusOK || expense.Reconciled {
+		t.Errorf("Expected fuel to be unmarked, got %d: %+v", w.Code, expense)
+	}
+}
+
+func TestStatementReport(t *testing.T) {
+	now := time.Now()
+	cycles := periods.LastStatements(2, 14, now)
+	store := newTestStore(t,
+		storage.Expense{ID: "dinner", Name: "Dinner", Category: "Food", Amount: -80, Date: cycles[0].Start.Add(12 * time.Hour), Account: "Visa"},
+		storage.Expense{ID: "fuel", Name: "Fuel", Category: "Transport", Amount: -45.5, Date: cycles[0].End.Add(-12 * time.Hour), Account: "visa"},
+		storage.Expense{ID: "payment", Name: "Card payment", Category: "Income", Amount: 125.5, Date: cycles[1].Start.Add(12 * time.Hour), Account: "Visa"},
+		storage.Expense{ID: "cash", Name: "Coffee", Category: "Food", Amount: -5, Date: cycles[0].Start.Add(12 * time.Hour), Account: "Wallet"},
+	)
+	if err := store.UpdateAccounts([]storage.Account{{Name: "Visa", Type: storage.AccountLiability, StatementDay: 14}}); err != nil {
+		t.Fatalf("Failed to set accounts: %v", err)
+	}
+	if err := store.UpdateAccounts([]storage.Account{{Name: "Visa", Type: storage.AccountLiability, StatementDay: 32}}); err == nil {
+		t.Error("Expected an error for a statement day past 31")
+	}
+	handler := NewHandler(store)
+	w := httptest.NewRecorder()
+	handler.GetStatementReport(w, httptest.NewRequest(http.MethodGet, "/api/reports/statements?account=visa&months=2", nil))
+	var report StatementReport
+	json.NewDecoder(w.Body).Decode(&report)
+	if w.Code != http.StatusOK || report.CloseDay != 14 || len(report.Statements) != 2 {
+		t.Fatalf("Expected 2 statements closing on the 14th, got %d: %+v", w.Code, report)
+	}
+	closed, open := report.Statements[0], report.Statements[1]
+	if closed.Open || !strings.HasSuffix(closed.To, "-14") || closed.Count != 2 || closed.Charges != 125.5 || closed.Categories["Food"] != 80 {
+		t.Errorf("Expected the closed statement to hold dinner and fuel, got %+v", closed)
+	}
+	if !open.Open || open.Count != 1 || open.Credits != 125.5 || open.Charges != 0 {
+		t.Errorf("Expected the open statement to hold the payment, got %+v", open)
+	}
+
+	// accounts without a statement day follow the budget periods
+	w = httptest.NewRecorder()
+	handler.GetStatementReport(w, httptest.NewRequest(http.MethodGet, "/api/reports/statements?account=Wallet&months=3", nil))
+	report = StatementReport{}
+	json.NewDecoder(w.Body).Decode(&report)
+	if w.Code != http.StatusOK || report.CloseDay != 0 || len(report.Statements) != 3 || report.Statements[2].From != periods.Default().Current(now).Start.Format("2006-01-02") {
+		t.Errorf("Expected 3 budget periods for the wallet, got %d: %+v", w.Code, report)
+	}
+	for _, query := range []string{"", "?account=Visa&months=0"} {
+		w = httptest.NewRecorder()
+		handler.GetStatementReport(w, httptest.NewRequest(http.MethodGet, "/api/reports/statements"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, w.Code)
+		}
+	}
+
+	// reconciling without a period picks the last closed statement
+	w = httptest.NewRecorder()
+	handler.GetReconciliation(w, httptest.NewRequest(http.MethodGet, "/api/reconcile?account=Visa&balance=125.5", nil))
+	var reconciliation Reconciliation
+	json.NewDecoder(w.Body).Decode(&reconciliation)
+	if w.Code != http.StatusOK || reconciliation.From != closed.From || reconciliation.To != closed.To || reconciliation.Discrepancy != 0 {
+		t.Errorf("Expected the last closed statement to be reconciled, got %d: %+v", w.Code, reconciliation)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	now := time.Now()
+	store := newTestStore(t,
+		storage.Expense{ID: "rent", Name: "Rent", Category: "Housing", Amount: -900, Date: now, RecurringID: "deleted-rule", Occurrence: now.Format("2006-01-02")},
+		storage.Expense{ID: "lunch", Name: "Lunch", Category: "Food", SubCategory: "Takeaway", Amount: -12, Date: now},
+		storage.Expense{ID: "dinner", Name: "Dinner", Category: "Food", SubCategory: "Takeaway", Amount: -30, Date: now},
+		storage.Expense{ID: "souvenir", Name: "Souvenir", Category: "Shopping", Amount: -20, Currency: "xyz", Date: now},
+		storage.Expense{ID: "return", Name: "Return", Category: "Shopping", Amount: 15, Date: now, RefundOf: "deleted-expense"},
+		storage.Expense{ID: "coffee", Name: "Coffee", Category: "Food", Amount: -4, Date: now},
+	)
+	handler := NewHandler(store)
+	maintenance := func(method, body string) (int, MaintenanceReport) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.Maintenance(w, httptest.NewRequest(method, "/api/admin/maintenance", strings.NewReader(body)))
+		var report MaintenanceReport
+		json.NewDecoder(w.Body).Decode(&report)
+		return w.Code, report
+	}
+	found := func(report MaintenanceReport) map[string]int {
+		counts := make(map[string]int)
+		for _, check := range report.Checks {
+			counts[check.Task] = check.Found
+		}
+		return counts
+	}
+
+	code, report := maintenance(http.MethodGet, "")
+	want := map[string]int{TaskOrphanedRecurring: 1, TaskUnknownSubcategories: 2, TaskInvalidCurrencies: 1, TaskOrphanedRefunds: 1}
+	if code != http.StatusOK || !maps.Equal(found(report), want) {
+		t.Fatalf("Expected %v, got %d: %+v", want, code, report)
+	}
+	if details := report.Checks[1].Details; len(details) != 1 || details[0] != "Food / Takeaway" {
+		t.Errorf("Expected the missing subcategory once, got %v", details)
+	}
+	if expense, _ := store.GetExpense("rent"); expense.RecurringID == "" {
+		t.Error("Expected checking to leave the expenses unchanged")
+	}
+
+	if code, _ := maintenance(http.MethodPost, `{"tasks": ["defrag"]}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown task, got %d", code)
+	}
+	code, report = maintenance(http.MethodPost, `{"tasks": ["orphaned-recurring", "unknown-subcategories"]}`)
+	if code != http.StatusOK || report.Checks[0].Fixed != 1 || report.Checks[1].Fixed != 2 || report.Checks[2].Fixed != 0 || report.Vacuumed {
+		t.Fatalf("Expected only the picked tasks to be fixed, got %d: %+v", code, report)
+	}
+	if expense, _ := store.GetExpense("rent"); expense.RecurringID != "" || expense.Amount != -900 {
+		t.Errorf("Expected rent to be kept as a standalone expense, got %+v", expense)
+	}
+	if subCategories, _ := store.GetSubCategories("Food"); !slices.Contains(subCategories, "Takeaway") {
+		t.Errorf("Expected Takeaway to be configured, got %v", subCategories)
+	}
+
+	code, report = maintenance(http.MethodPost, `{}`)
+	if code != http.StatusOK || !report.Vacuumed || report.Checks[2].Fixed != 1 || report.Checks[3].Fixed != 1 {
+		t.Fatalf("Expected the remaining issues fixed and a vacuum, got %d: %+v", code, report)
+	}
+	if expense, _ := store.GetExpense("return"); expense.RefundOf != "" {
+		t.Errorf("Expected the refund to be unlinked, got %+v", expense)
+	}
+	if _, report := maintenance(http.MethodGet, ""); !maps.Equal(found(report), map[string]int{TaskOrphanedRecurring: 0, TaskUnknownSubcategories: 0, TaskInvalidCurrencies: 0, TaskOrphanedRefunds: 0}) {
+		t.Errorf("Expected no issues left, got %+v", report)
+	}
+}
+
+func TestDeleteRecurringExpenseDetachesPastInstances(t *testing.T) {
+	store := newTestStore(t)
+	start := time.Now().AddDate(0, 0, -14)
+	if err := store.AddRecurringExpense(storage.RecurringExpense{ID: "gym", Name: "Gym", Amount: -40, Category: "Health", StartDate: start, Interval: "weekly", Occurrences: 6}); err != nil {
+		t.Fatalf("Failed to add recurring expense: %v", err)
+	}
+	handler := NewHandler(store)
+	w := httptest.NewRecorder()
+	handler.DeleteRecurringExpense(w, httptest.NewRequest(http.MethodDelete, "/recurring-expense/delete?id=gym", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	expenses, _ := store.GetAllExpenses()
+	if len(expenses) != 3 {
+		t.Fatalf("Expected the 3 past instances to be kept, got %d", len(expenses))
+	}
+	for _, expense := range expenses {
+		if expense.RecurringID != "" {
+			t.Errorf("Expected %s to be detached from the deleted rule, got %q", expense.ID, expense.RecurringID)
+		}
+	}
+}
+
+func TestEditExpenseConflict(t *testing.T) {
+	store := newTestStore(t, storage.Expense{ID: "1", Name: "Lunch", Category: "Food", Amount: -12, Date: time.Now()})
+	handler := NewHandler(store)
+	edit := func(expense storage.Expense) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(expense)
+		w := httptest.NewRecorder()
+		handler.EditExpense(w, httptest.NewRequest(http.MethodPut, "/expense/edit?id=1", bytes.NewReader(body)))
+		return w
+	}
+	loaded, _ := store.GetExpense("1")
+	if loaded.UpdatedAt == nil {
+		t.Fatal("Expected the store to set updatedAt")
+	}
+
+	// the first device saves, and gets the new updatedAt back
+	first := loaded
+	first.Amount = -15
+	w := edit(first)
+	var saved storage.Expense
+	json.NewDecoder(w.Body).Decode(&saved)
+	if w.Code != http.StatusOK || saved.UpdatedAt == nil || saved.UpdatedAt.Equal(*loaded.UpdatedAt) {
+		t.Fatalf("Expected the edit to return the new updatedAt, got %d %+v", w.Code, saved)
+	}
+
+	// the second device still has the old copy, and gets the server's copy back instead
+	second := loaded
+	second.Name = "Team lunch"
+	w = edit(second)
+	var conflict ExpenseConflictResponse
+	json.NewDecoder(w.Body).Decode(&conflict)
+	if w.Code != http.StatusConflict || conflict.Current.Amount != -15 || conflict.Current.Name != "Lunch" {
+		t.Fatalf("Expected status 409 with the server's copy, got %d %+v", w.Code, conflict)
+	}
+
+	// saving on top of the server's copy goes through, as does an edit without a precondition
+	second.UpdatedAt = conflict.Current.UpdatedAt
+	if w := edit(second); w.Code != http.StatusOK {
+		t.Errorf("Expected the merged edit to be saved, got %d: %s", w.Code, w.Body.String())
+	}
+	second.UpdatedAt, second.Amount = nil, -20
+	if w := edit(second); w.Code != http.StatusOK {
+		t.Errorf("Expected an edit without updatedAt to be saved, got %d: %s", w.Code, w.Body.String())
+	}
+	if expense, _ := store.GetExpense("1"); expense.Name != "Team lunch" || expense.Amount != -20 {
+		t.Errorf("Expected both edits to be saved, got %+v", expense)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	handler := NewHandler(newTestStore(t, storage.Expense{ID: "1", Name: "Lunch", Category: "Food", Amount: -12, Date: time.Now()}))
+	for _, tc := range []struct {
+		name   string
+		serve  http.HandlerFunc
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{"edit a missing expense", handler.EditExpense, http.MethodPut, "/expense/edit?id=missing", `{"name": "Tea", "category": "Food", "amount": -3, "date": "2026-01-01T00:00:00Z"}`, http.StatusNotFound, CodeExpenseNotFound, ""},
+		{"delete a missing expense", handler.DeleteExpense, http.MethodDelete, "/expense/delete?id=missing", "", http.StatusNotFound, CodeExpenseNotFound, ""},
+		{"delete a missing recurring expense", handler.DeleteRecurringExpense, http.MethodDelete, "/recurring-expense/delete?id=missing", "", http.StatusNotFound, CodeRecurringNotFound, ""},
+		{"add an existing ID", handler.AddExpense, http.MethodPut, "/expense", `{"id": "1", "name": "Tea", "category": "Food", "amount": -3, "date": "2026-01-01T00:00:00Z"}`, http.StatusConflict, CodeDuplicate, ""},
+		{"add without an amount", handler.AddExpense, http.MethodPut, "/expense", `{"name": "Tea", "category": "Food", "date": "2026-01-01T00:00:00Z"}`, http.StatusBadRequest, CodeValidationFailed, "amount"},
+		{"wrong method", handler.AddExpense, http.MethodGet, "/expense", "", http.StatusMethodNotAllowed, CodeMethodNotAllowed, ""},
+		{"invalid body", handler.AddExpense, http.MethodPut, "/expense", "{", http.StatusBadRequest, CodeBadRequest, ""},
+	} {
+		w := httptest.NewRecorder()
+		tc.serve(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		var response ErrorResponse
+		json.NewDecoder(w.Body).Decode(&response)
+		if w.Code != tc.status || response.Code != tc.code || response.Error == "" {
+			t.Errorf("%s: expected %d %s, got %d %+v", tc.name, tc.status, tc.code, w.Code, response)
+		}
+		if _, ok := response.Fields[tc.field]; tc.field != "" && !ok {
+			t.Errorf("%s: expected the invalid field %s, got %v", tc.name, tc.field, response.Fields)
+		}
+	}
+}
+
+func TestLimitRequests(t *testing.T) {
+	limited := LimitRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	large := strings.Repeat("x", maxBodySize+1)
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"small JSON", "application/json", `{"name": "Tea"}`, http.StatusOK},
+		{"large JSON", "application/json; charset=utf-8", large, http.StatusRequestEntityTooLarge},
+		{"large upload", "multipart/form-data; boundary=x", large, http.StatusOK},
+		{"large image", "image/jpeg", large, http.StatusOK},
+		{"no content type", "", `{"name": "Tea"}`, http.StatusOK},
+		{"plain text", "text/plain", `{"name": "Tea"}`, http.StatusUnsupportedMediaType},
+	} {
+		req := httptest.NewRequest(http.MethodPut, "/expense", strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		w := httptest.NewRecorder()
+		limited.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.name, tc.status, w.Code, w.Body.String())
+		}
+	}
+
+	// a body without a length is cut off at the limit
+	req := httptest.NewRequest(http.MethodPut, "/expense", io.MultiReader(strings.NewReader(large)))
+	req.ContentLength = -1
+	w := httptest.NewRecorder()
+	limited.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a body without a length to be cut off, got %d", w.Code)
+	}
+
+	// text fields are sanitized the same way on every write
+	store := newTestStore(t)
+	body := `{"name": "Tea<script>", "category": " Food\u0007 ", "account": "Visa  <b>", "tags": ["a|b"], "amount": -3, "date": "2026-01-01T00:00:00Z"}`
+	w = httptest.NewRecorder()
+	NewHandler(store).AddExpense(w, httptest.NewRequest(http.MethodPut, "/expense", strings.NewReader(body)))
+	expenses, _ := store.GetAllExpenses()
+	if w.Code != http.StatusOK || len(expenses) != 1 {
+		t.Fatalf("Expected the expense to be added, got %d: %s", w.Code, w.Body.String())
+	}
+	if e := expenses[0]; e.Name != "Tea script" || e.Category != "Food" || e.Account != "Visa b" || !slices.Equal(e.Tags, []string{"a b"}) {
+		t.Errorf("Expected the text fields to be sanitized, got %+v", e)
+	}
+}
+
+func TestCheckCSRF(t *testing.T) {
+	var seen string
+	protected := CheckCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = csrfToken(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// a browser gets its token with the first page
+	w := httptest.NewRecorder()
+	protected.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookie || cookies[0].Value != seen || !cookies[0].HttpOnly {
+		t.Fatalf("Expected the CSRF cookie with the page's token, got %v", cookies)
+	}
+	token := seen
+
+	for _, tc := range []struct {
+		name    string
+		session bool
+		origin  string
+		header  string
+		form    string
+		status  int
+	}{
+		{"script without a session", false, "", "", "", http.StatusOK},
+		{"other site without a session", false, "https://evil.example", "", "", http.StatusForbidden},
+		{"session with the header", true, "http://example.com", token, "", http.StatusOK},
+		{"session with the form field", true, "", "", token, http.StatusOK},
+		{"session without a token", true, "", "", "", http.StatusForbidden},
+		{"session with a wrong token", true, "", strings.Repeat("0", 64), "", http.StatusForbidden},
+		{"other site with the session", true, "https://evil.example", token, "", http.StatusForbidden},
+	} {
+		body, contentType := `{"name": "Tea"}`, "application/json"
+		if tc.form != "" {
+			body, contentType = "csrf_token="+tc.form, "application/x-www-form-urlencoded"
+		}
+		req := httptest.NewRequest(http.MethodPost, "/expense/delete?id=1", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+		if tc.session {
+			req.AddCookie(&http.Cookie{Name: sessionCookie, Value: "session"})
+		}
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		if tc.header != "" {
+			req.Header.Set(csrfHeader, tc.header)
+		}
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.name, tc.status, w.Code, w.Body.String())
+		}
+		if tc.status == http.StatusForbidden && !strings.Contains(w.Body.String(), CodeCSRFFailed) {
+			t.Errorf("%s: expected the %s code, got %s", tc.name, CodeCSRFFailed, w.Body.String())
+		}
+	}
+
+	// the pages carry the token
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+	CheckCSRF(http.HandlerFunc(NewHandler(newTestStore(t)).ServeDashboard)).ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), `<meta name="csrf-token" content="`+token+`">`) {
+		t.Errorf("Expected the dashboard to carry the CSRF token")
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		env          map[string]string
+		ancestors    string
+		frameOptions string
+		err          bool
+	}{
+		{"defaults", nil, "frame-ancestors 'self'", "SAMEORIGIN", false},
+		{"embedding anywhere", map[string]string{"ALLOW_EMBEDDING": "true"}, "frame-ancestors *", "", false},
+		{"embedding in a dashboard", map[string]string{"ALLOW_EMBEDDING": "https://organizr.example.com/, http://heimdall:8080"}, "frame-ancestors 'self' https://organizr.example.com http://heimdall:8080", "", false},
+		{"custom policy", map[string]string{"CONTENT_SECURITY_POLICY": "default-src 'self';"}, "default-src 'self'; frame-ancestors 'self'", "SAMEORIGIN", false},
+		{"policy off", map[string]string{"CONTENT_SECURITY_POLICY": "off"}, "", "SAMEORIGIN", false},
+		{"invalid origin", map[string]string{"ALLOW_EMBEDDING": "organizr.example.com"}, "", "", true},
+	} {
+		for _, name := range []string{"CONTENT_SECURITY_POLICY", "REFERRER_POLICY", "ALLOW_EMBEDDING"} {
+			t.Setenv(name, tc.env[name])
+		}
+		headers, err := SecurityHeadersFromEnv()
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.name)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		w := httptest.NewRecorder()
+		headers.Middleware(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if policy := w.Header().Get("Content-Security-Policy"); !strings.HasSuffix(policy, tc.ancestors) || (tc.ancestors == "") != (policy == "") {
+			t.Errorf("%s: expected the policy to end with %q, got %q", tc.name, tc.ancestors, policy)
+		}
+		if got := w.Header().Get("X-Frame-Options"); got != tc.frameOptions {
+			t.Errorf("%s: expected X-Frame-Options %q, got %q", tc.name, tc.frameOptions, got)
+		}
+		if w.Header().Get("Referrer-Policy") != "same-origin" || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: expected the referrer policy and nosniff, got %v", tc.name, w.Header())
+		}
+	}
+}
+
+// TestMQTTConfigFromEnv checks that instances get their own client ID unless MQTT_CLIENT_ID is set
+func TestMQTTConfigFromEnv(t *testing.T) {
+	t.Setenv("MQTT_BROKER", "")
+	if cfg := mqttConfigFromEnv(); cfg != nil {
+		t.Errorf("Expected no config without a broker, got %+v", cfg)
+	}
+	t.Setenv("MQTT_BROKER", "broker:1883")
+	t.Setenv("MQTT_CLIENT_ID", "")
+	first, second := mqttConfigFromEnv(), mqttConfigFromEnv()
+	if !strings.HasPrefix(first.ClientID, "expenseowl-") || len(first.ClientID) > 23 || first.ClientID == second.ClientID {
+		t.Errorf("Expected distinct expenseowl- client IDs, got %q and %q", first.ClientID, second.ClientID)
+	}
+	t.Setenv("MQTT_CLIENT_ID", "livingroom")
+	if cfg := mqttConfigFromEnv(); cfg.ClientID != "livingroom" {
+		t.Errorf("Expected MQTT_CLIENT_ID to be used, got %q", cfg.ClientID)
+	}
+}
+
+// TestMergeSubCategory checks that merging moves the expenses and rules of a subcategory to the
+// target and removes it
+func TestMergeSubCategory(t *testing.T) {
+	date := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	store := newTestStore(t,
+		storage.Expense{ID: "1", Name: "Bistro", Category: "Food", SubCategory: "Cafes", Amount: -12, Date: date},
+		storage.Expense{ID: "2", Name: "Diner", Category: "Food", SubCategory: "Restaurants", Amount: -30, Date: date},
+		storage.Expense{ID: "3", Name: "Cafe", Category: "Food", Amount: -4, Date: date},
+	)
+	for _, name := range []string{"Cafes", "Restaurants"} {
+		if err := store.AddSubCategory("Food", name); err != nil {
+			t.Fatalf("Failed to add subcategory: %v", err)
+		}
+	}
+	if err := store.UpdateSubCategoryMappings([]storage.SubCategoryMappingRule{{Pattern: "bistro", MatchType: "contains", Category: "Food", SubCategory: "Cafes"}}); err != nil {
+		t.Fatalf("Failed to add mapping rule: %v", err)
+	}
+	handler := NewHandler(store)
+	merge := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.MergeSubCategory(w, httptest.NewRequest(http.MethodPut, "/subcategory/merge", strings.NewReader(body)))
+		return w
+	}
+
+	for _, body := range []string{
+		`{"category": "Food", "source": "Cafes", "target": "Cafes"}`,
+		`{"category": "Food", "source": "Cafes", "target": "Bakeries"}`,
+		`{"category": "Food", "source": "Bakeries", "target": "Cafes"}`,
+		`{"category": "Rent", "source": "Cafes", "target": "Restaurants"}`,
+		`{"category": "Food", "source": "Cafes"}`,
+	} {
+		if w := merge(body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	if w := merge(`{"category": "Food", "source": "Cafes", "target": "Restaurants"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	for id, want := range map[string]string{"1": "Restaurants", "2": "Restaurants", "3": ""} {
+		if expense, _ := store.GetExpense(id); expense.SubCategory != want {
+			t.Errorf("Expected expense %s in %q, got %q", id, want, expense.SubCategory)
+		}
+	}
+	if rules, _ := store.GetSubCategoryMappings(); len(rules) != 1 || rules[0].SubCategory != "Restaurants" {
+		t.Errorf("Expected the rule to point at the target, got %+v", rules)
+	}
+	if subCategories, _ := store.GetSubCategories("Food"); !slices.Equal(subCategories, []string{"Restaurants"}) {
+		t.Errorf("Expected only the target to be left, got %v", subCategories)
+	}
+}
+
+// TestMoveExpenses checks that expenses are moved to another category and subcategory together
+func TestMoveExpenses(t *testing.T) {
+	date := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	store := newTestStoreWithCategories(t, "Food", "Transportation")
+	if err := store.AddMultipleExpenses([]storage.Expense{
+		{ID: "1", Name: "Bus", Category: "Food", Amount: -3, Date: date},
+		{ID: "2", Name: "Train", Category: "Food", Amount: -20, Date: date},
+		{ID: "3", Name: "Lunch", Category: "Food", Amount: -12, Date: date},
+	}); err != nil {
+		t.Fatalf("Failed to add expenses: %v", err)
+	}
+	if err := store.AddSubCategory("Transportation", "Transit"); err != nil {
+		t.Fatalf("Failed to add subcategory: %v", err)
+	}
+	handler := NewHandler(store)
+	move := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.MoveExpenses(w, httptest.NewRequest(http.MethodPut, "/expenses/move", strings.NewReader(body)))
+		return w
+	}
+
+	for _, body := range []string{
+		`{"ids": [], "category": "Transportation"}`,
+		`{"ids": ["1"]}`,
+		`{"ids": ["1"], "category": "Teleportation"}`,
+		`{"ids": ["1"], "category": "Transportation", "subCategory": "Rockets"}`,
+		`{"ids": ["1"], "category": "Food", "subCategory": "Transit"}`,
+	} {
+		if w := move(body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+	if expense, _ := store.GetExpense("1"); expense.Category != "Food" {
+		t.Errorf("Expected a rejected move to change nothing, got %+v", expense)
+	}
+
+	if w := move(`{"ids": ["1", "2"], "category": "Transportation", "subCategory": "Transit"}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	for id, want := range map[string][2]string{"1": {"Transportation", "Transit"}, "2": {"Transportation", "Transit"}, "3": {"Food", ""}} {
+		if expense, _ := store.GetExpense(id); expense.Category != want[0] || expense.SubCategory != want[1] {
+			t.Errorf("Expected expense %s in %s/%s, got %s/%s", id, want[0], want[1], expense.Category, expense.SubCategory)
+		}
+	}
+}
+
+// TestGetFeed checks that the Atom feed needs the feed token, in the header or the query, and is
+// valid XML with the expenses' text escaped
+func TestGetFeed(t *testing.T) {
+	t.Setenv("FEED_TOKEN", "feed-secret")
+	now := time.Now()
+	handler := NewHandler(newTestStore(t,
+		storage.Expense{ID: "1", Name: "Fish & Chips <to go>", Category: "Food", Tags: []string{"friday", "r&r"}, Amount: -18.5, Date: now.AddDate(0, 0, -1)},
+		storage.Expense{ID: "2", Name: "Salary", Category: "Income", Amount: 3000, Date: now.AddDate(0, 0, -2)},
+		storage.Expense{ID: "3", Name: "Rent", Category: "Housing", Amount: -900, Date: now.AddDate(0, 1, 0)},
+	))
+	get := func(path string, header string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if header != "" {
+			req.Header.Set("X-Feed-Token", header)
+		}
+		w := httptest.NewRecorder()
+		handler.GetFeed(w, req)
+		return w
+	}
+
+	for _, tc := range []struct{ path, header string }{
+		{"/feed", ""},
+		{"/feed?token=wrong", ""},
+		{"/feed", "wrong"},
+		{"/feed?token=feed-secret", "wrong"}, // the header is checked when present
+	} {
+		if w := get(tc.path, tc.header); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for %s with header %q, got %d", tc.path, tc.header, w.Code)
+		}
+	}
+
+	for _, tc := range []struct{ path, header string }{
+		{"/feed", "feed-secret"},
+		{"/feed?token=feed-secret", ""},
+	} {
+		w := get(tc.path, tc.header)
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/atom+xml") {
+			t.Fatalf("Expected an Atom feed for %s with header %q, got %d %s", tc.path, tc.header, w.Code, w.Header().Get("Content-Type"))
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "Fish &amp; Chips &lt;to go&gt;") || !strings.Contains(body, `term="r&amp;r"`) {
+			t.Errorf("Expected the text to be escaped, got %s", body)
+		}
+		var feed atomFeed
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("Expected valid XML, got %v: %s", err, body)
+		}
+		if feed.XMLName.Space != "http://www.w3.org/2005/Atom" || len(feed.Entries) != 2 {
+			t.Fatalf("Expected an Atom feed of the 2 past expenses, got %+v", feed)
+		}
+		entry := feed.Entries[0]
+		if entry.ID != "urn:expenseowl:expense:1" || entry.Title != "Fish & Chips <to go>: -18.50" || !strings.Contains(entry.Summary, "(tags: friday, r&r)") || len(entry.Category) != 3 {
+			t.Errorf("Unexpected entry: %+v", entry)
+		}
+		if !strings.HasPrefix(feed.Entries[1].Summary, "Income of 3000.00") {
+			t.Errorf("Expected the salary as income, got %q", feed.Entries[1].Summary)
+		}
+	}
+
+	var feed atomFeed
+	xml.Unmarshal(get("/feed?limit=1", "feed-secret").Body.Bytes(), &feed)
+	if len(feed.Entries) != 1 {
+		t.Errorf("Expected 1 entry with ?limit=1, got %d", len(feed.Entries))
+	}
+}
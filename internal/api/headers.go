@@ -0,0 +1,88 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Every response carries headers that keep the browser from loading the UI's scripts from anywhere
+// else, from framing it on other sites, and from leaking its URLs to the sites it links to.
+
+// defaultContentSecurityPolicy allows the UI's own scripts and styles, inline ones included, and
+// Plaid Link; logos can be images from anywhere
+const defaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline' https://cdn.plaid.com; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data: blob: https:; font-src 'self' data:; " +
+	"connect-src 'self'; frame-src https://cdn.plaid.com; object-src 'none'; base-uri 'self'; form-action 'self'"
+
+// SecurityHeaders are the headers set on every response
+type SecurityHeaders struct {
+	contentSecurityPolicy string // with frame-ancestors, empty when turned off
+	frameOptions          string // empty when framing is allowed on other sites
+	referrerPolicy        string
+}
+
+// SecurityHeadersFromEnv configures the headers from CONTENT_SECURITY_POLICY ("off" to not send
+// one), REFERRER_POLICY, and ALLOW_EMBEDDING, which lets dashboards like Organizr or Heimdall frame
+// the UI: "true" for any site, or a comma-separated list of origins
+func SecurityHeadersFromEnv() (*SecurityHeaders, error) {
+	headers := &SecurityHeaders{
+		contentSecurityPolicy: defaultContentSecurityPolicy,
+		frameOptions:          "SAMEORIGIN",
+		referrerPolicy:        "same-origin",
+	}
+	if policy := strings.TrimSpace(os.Getenv("CONTENT_SECURITY_POLICY")); policy != "" {
+		headers.contentSecurityPolicy = strings.TrimSuffix(policy, ";")
+	}
+	if policy := strings.TrimSpace(os.Getenv("REFERRER_POLICY")); policy != "" {
+		headers.referrerPolicy = policy
+	}
+
+	ancestors := "'self'"
+	if embedding := strings.TrimSpace(os.Getenv("ALLOW_EMBEDDING")); embedding != "" {
+		if allowed, err := strconv.ParseBool(embedding); err == nil {
+			if allowed {
+				ancestors = "*"
+			}
+		} else {
+			for _, origin := range strings.Split(embedding, ",") {
+				origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+				u, err := url.Parse(origin)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+					return nil, fmt.Errorf("invalid origin '%s' in ALLOW_EMBEDDING, e.g., https://organizr.example.com", origin)
+				}
+				ancestors += " " + origin
+			}
+		}
+		// X-Frame-Options can't name other sites, so frame-ancestors alone decides
+		if ancestors != "'self'" {
+			headers.frameOptions = ""
+		}
+	}
+	switch {
+	case strings.EqualFold(headers.contentSecurityPolicy, "off"):
+		headers.contentSecurityPolicy = ""
+	case !strings.Contains(headers.contentSecurityPolicy, "frame-ancestors"):
+		headers.contentSecurityPolicy += "; frame-ancestors " + ancestors
+	}
+	return headers, nil
+}
+
+// Middleware sets the headers on every response
+func (s *SecurityHeaders) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		if s.contentSecurityPolicy != "" {
+			header.Set("Content-Security-Policy", s.contentSecurityPolicy)
+		}
+		if s.frameOptions != "" {
+			header.Set("X-Frame-Options", s.frameOptions)
+		}
+		header.Set("Referrer-Policy", s.referrerPolicy)
+		header.Set("X-Content-Type-Options", "nosniff")
+		next.ServeHTTP(w, r)
+	})
+}
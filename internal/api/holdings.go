@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/prices"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Holdings are investments valued with a daily price from the configured provider (or prices
+// entered by hand), and count as assets in the net worth report. There is no trading: a holding
+// is a ticker with a quantity and what was paid for it.
+
+// HoldingStatus is a holding with its latest price and value
+type HoldingStatus struct {
+	storage.Holding
+	Price     float64 `json:"price"`     // latest price, 0 without one
+	PriceDate string  `json:"priceDate"` // date of the latest price
+	Value     float64 `json:"value"`     // quantity times price
+	Gain      float64 `json:"gain"`      // value minus cost basis
+}
+
+// PriceRefreshResponse is the response of /api/holdings/refresh
+type PriceRefreshResponse struct {
+	Updated int      `json:"updated"`
+	Failed  []string `json:"failed"` // tickers the provider couldn't quote
+}
+
+// priceProviderFromEnv returns the price provider, or nil when prices are only entered by hand
+func priceProviderFromEnv() prices.Provider {
+	switch os.Getenv("PRICE_PROVIDER") {
+	case "":
+	case "http":
+		if url := os.Getenv("PRICE_URL"); url != "" {
+			return prices.HTTP{URL: url, Token: os.Getenv("PRICE_TOKEN")}
+		}
+		log.Println("PRICE_PROVIDER is http but PRICE_URL is not set, holding prices are not refreshed")
+	default:
+		log.Printf("Unknown PRICE_PROVIDER '%s', holding prices are not refreshed\n", os.Getenv("PRICE_PROVIDER"))
+	}
+	return nil
+}
+
+// latestPrice is the last price of the holding on or before date (YYYY-MM-DD)
+func latestPrice(holding storage.Holding, date string) (storage.PriceQuote, bool) {
+	var latest storage.PriceQuote
+	for _, quote := range holding.Prices {
+		if quote.Date > date {
+			break
+		}
+		latest = quote
+	}
+	return latest, latest.Date != ""
+}
+
+func (h *Handler) GetHoldings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	holdings, err := h.storage.GetHoldings()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get holdings"})
+		log.Printf("API ERROR: Failed to get holdings: %v\n", err)
+		return
+	}
+	today := time.Now().Format("2006-01-02")
+	statuses := make([]HoldingStatus, 0, len(holdings))
+	for _, holding := range holdings {
+		status := HoldingStatus{Holding: holding}
+		if quote, ok := latestPrice(holding, today); ok {
+			status.Price, status.PriceDate = quote.Price, quote.Date
+			status.Value = holding.Quantity * quote.Price
+			status.Gain = status.Value - holding.CostBasis
+		}
+		statuses = append(statuses, status)
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// UpdateHoldings replaces the holdings; a holding sent without prices keeps the price history of its ticker
+func (h *Handler) UpdateHoldings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var holdings []storage.Holding
+	if err := json.NewDecoder(r.Body).Decode(&holdings); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if err := storage.ValidateHoldings(holdings); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	existing, err := h.storage.GetHoldings()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get holdings"})
+		log.Printf("API ERROR: Failed to get holdings: %v\n", err)
+		return
+	}
+	history := make(map[string][]storage.PriceQuote, len(existing))
+	for _, holding := range existing {
+		history[holding.Ticker] = holding.Prices
+	}
+	for i := range holdings {
+		if len(holdings[i].Prices) == 0 && history[holdings[i].Ticker] != nil {
+			holdings[i].Prices = history[holdings[i].Ticker]
+		}
+	}
+	if err := h.storage.UpdateHoldings(holdings); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update holdings"})
+		log.Printf("API ERROR: Failed to update holdings: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// RefreshPrices quotes every holding with the price provider now, instead of waiting for the daily refresh
+func (h *Handler) RefreshPrices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if h.prices == nil {
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Error: "No price provider is configured, set PRICE_PROVIDER"})
+		return
+	}
+	response, err := h.refreshPrices(r.Context(), time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to refresh prices"})
+		log.Printf("API ERROR: Failed to refresh prices: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// refreshPrices records today's price of every holding the provider can quote
+func (h *Handler) refreshPrices(ctx context.Context, now time.Time) (PriceRefreshResponse, error) {
+	response := PriceRefreshResponse{Failed: []string{}}
+	holdings, err := h.storage.GetHoldings()
+	if err != nil || len(holdings) == 0 {
+		return response, err
+	}
+	quotes := make(map[string]float64, len(holdings))
+	for _, holding := range holdings {
+		price, err := h.prices.Quote(ctx, holding.Ticker)
+		if err != nil {
+			log.Printf("PRICE ERROR: %v\n", err)
+			response.Failed = append(response.Failed, holding.Ticker)
+			continue
+		}
+		quotes[holding.Ticker] = price
+	}
+	if len(quotes) == 0 {
+		return response, nil
+	}
+
+	// read the holdings again, as quoting takes a while and they may have been edited meanwhile
+	holdings, err = h.storage.GetHoldings()
+	if err != nil {
+		return response, err
+	}
+	today := now.Format("2006-01-02")
+	for i, holding := range holdings {
+		price, ok := quotes[holding.Ticker]
+		if !ok {
+			continue
+		}
+		if n := len(holding.Prices); n > 0 && holding.Prices[n-1].Date == today {
+			holdings[i].Prices[n-1].Price = price
+		} else {
+			holdings[i].Prices = append(holding.Prices, storage.PriceQuote{Date: today, Price: price})
+		}
+		response.Updated++
+	}
+	return response, h.storage.UpdateHoldings(holdings)
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/mqtt"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+const defaultMQTTTopic = "expenseowl/summary"
+
+// HomeAssistantSummary is shaped for Home Assistant's RESTful sensor:
+// use "spent" as the state and the remaining fields as json_attributes
+type HomeAssistantSummary struct {
+	Period          string                  `json:"period"`
+	PeriodStart     string                  `json:"period_start"`
+	PeriodEnd       string                  `json:"period_end"`
+	Currency        string                  `json:"currency"`
+	Spent           float64                 `json:"spent"`
+	Income          float64                 `json:"income"`
+	Balance         float64                 `json:"balance"`
+	Categories      map[string]float64      `json:"categories"`
+	Budgets         map[string]BudgetStatus `json:"budgets"`
+	BudgetTotal     float64                 `json:"budget_total"`
+	BudgetRemaining float64                 `json:"budget_remaining"`
+	LastUpdated     string                  `json:"last_updated"`
+}
+
+type BudgetStatus struct {
+	Budget    float64 `json:"budget"`
+	Carried   float64 `json:"carried,omitempty"` // from earlier periods with a rollover mode
+	Spent     float64 `json:"spent"`
+	Remaining float64 `json:"remaining"`
+}
+
+// GetHomeAssistantData returns the current period summary for Home Assistant
+func (h *Handler) GetHomeAssistantData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	summary, err := h.homeAssistantSummary()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to build summary"})
+		log.Printf("API ERROR: Failed to build Home Assistant summary: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+	log.Println("HTTP: Served Home Assistant data")
+}
+
+func (h *Handler) homeAssistantSummary() (*HomeAssistantSummary, error) {
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		return nil, err
+	}
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+	period := h.periodConfig().Current(time.Now())
+	budgets, err := h.budgetStatus(expenses, period)
+	if err != nil {
+		budgets = []CategoryBudget{}
+	}
+	totalIncome, totalExpenses, categoryTotals := summarizePeriod(h.reportable(expenses), period)
+
+	summary := &HomeAssistantSummary{
+		Period:      period.Label(),
+		PeriodStart: period.Start.Format("2006-01-02"),
+		PeriodEnd:   period.End.AddDate(0, 0, -1).Format("2006-01-02"),
+		Currency:    currency,
+		Spent:       totalExpenses,
+		Income:      totalIncome,
+		Balance:     totalIncome - totalExpenses,
+		Categories:  categoryTotals,
+		Budgets:     make(map[string]BudgetStatus, len(budgets)),
+		LastUpdated: time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, budget := range budgets {
+		summary.Budgets[budget.Category] = BudgetStatus{Budget: budget.Budget, Carried: budget.Carried, Spent: budget.Spent, Remaining: budget.Remaining}
+		summary.BudgetTotal += budget.Available
+		summary.BudgetRemaining += budget.Remaining
+	}
+	return summary, nil
+}
+
+// mqttConfigFromEnv returns the broker config, or nil when MQTT publishing is disabled. The client
+// ID is MQTT_CLIENT_ID, or expenseowl-<random> so two instances on one broker don't disconnect
+// each other.
+func mqttConfigFromEnv() *mqtt.Config {
+	broker := os.Getenv("MQTT_BROKER")
+	if broker == "" {
+		return nil
+	}
+	clientID := os.Getenv("MQTT_CLIENT_ID")
+	if clientID == "" {
+		b := make([]byte, 4)
+		rand.Read(b)
+		clientID = "expenseowl-" + hex.EncodeToString(b) // within the 23 bytes every broker accepts
+	}
+	return &mqtt.Config{
+		Broker:   broker,
+		ClientID: clientID,
+		Username: os.Getenv("MQTT_USER"),
+		Password: os.Getenv("MQTT_PASS"),
+	}
+}
+
+// notifyExpenseAdded publishes the refreshed summary to MQTT (if configured and switched on) after an expense is added
+func (h *Handler) notifyExpenseAdded(expense storage.Expense) {
+	if h.mqtt == nil || !h.runtimeSettings().Notifications.MQTT {
+		return
+	}
+	h.tasks.Add(1)
+	go func() {
+		defer h.tasks.Done()
+		summary, err := h.homeAssistantSummary()
+		if err != nil {
+			log.Printf("MQTT ERROR: Failed to build summary: %v\n", err)
+			return
+		}
+		payload, err := json.Marshal(summary)
+		if err != nil {
+			log.Printf("MQTT ERROR: Failed to marshal summary: %v\n", err)
+			return
+		}
+		if err := mqtt.Publish(*h.mqtt, h.mqttTopic, payload, true); err != nil {
+			log.Printf("MQTT ERROR: Failed to publish summary after adding %s: %v\n", expense.Name, err)
+		}
+	}()
+}
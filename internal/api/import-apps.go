@@ -0,0 +1,611 @@
+package api
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// ImportResult summarizes an import; on a dry run nothing is written and the
+// would-be-created expenses are returned instead
+type ImportResult struct {
+	Status         string            `json:"status"`
+	DryRun         bool              `json:"dry_run"`
+	TotalProcessed int               `json:"total_processed"`
+	Imported       int               `json:"imported"`
+	Skipped        int               `json:"skipped"`
+	NewCategories  []string          `json:"new_categories"`
+	Warnings       []string          `json:"warnings"`
+	Expenses       []storage.Expense `json:"expenses,omitempty"`
+	Batch          string            `json:"batch,omitempty"` // ID to roll the import back with, empty on a dry run
+}
+
+// importRow is a parsed row, before category resolution, dedupe, and validation
+type importRow struct {
+	Line        int
+	Name        string
+	Category    string // empty falls back to the mapping rules, then Miscellaneous
+	SubCategory string
+	Amount      float64
+	Currency    string // empty uses the configured currency
+	Date        time.Time
+	Tags        []string
+	Notes       string
+	Metadata    storage.Metadata // from an ExpenseOwl export, or the ID of a payment
+	Fee         float64          // charged by a payment processor, added to Amount to get what was paid out
+}
+
+// importer resolves rows against the current config and adds them (or collects them on a dry run)
+type importer struct {
+	h                *Handler
+	dryRun           bool
+	requireCategory  bool   // skip rows without a category instead of falling back to Miscellaneous
+	unit             string // what lines are called in warnings, "row" when empty
+	keepDuplicates   bool   // rows have IDs of their own, so identical ones are separate transactions
+	currency         string
+	currencies       []storage.Currency // custom currencies, besides ISO 4217
+	categories       []string
+	known            map[string]string // lowercase -> configured name, including archived categories
+	mapping          *MappingEngine
+	newSubCategories map[string][]string
+	batch            map[string]string // import metadata added to every expense, nil on a dry run
+	result           *ImportResult
+}
+
+// newImporter prepares an import; source and file (the uploaded file's name, or the bank of a
+// sync) are recorded with the import batch
+func (h *Handler) newImporter(dryRun bool, source string, file string) (*importer, error) {
+	categories, err := h.storage.GetCategories()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve current categories: %v", err)
+	}
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve currency: %v", err)
+	}
+	currencies, err := h.storage.GetCustomCurrencies()
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve custom currencies: %v", err)
+	}
+	im := &importer{
+		h:                h,
+		dryRun:           dryRun,
+		currency:         currency,
+		currencies:       currencies,
+		categories:       categories,
+		known:            make(map[string]string),
+		newSubCategories: make(map[string][]string),
+		result:           &ImportResult{Status: "success", DryRun: dryRun, NewCategories: []string{}, Warnings: []string{}},
+	}
+	for _, cat := range categories {
+		im.known[strings.ToLower(cat)] = cat
+	}
+	if !dryRun {
+		im.batch = newImportBatch(source, file, time.Now())
+		im.result.Batch = im.batch["batch"]
+	}
+	// archived categories are known, so importing old data doesn't restore them
+	if archived, err := h.storage.GetArchivedCategories(); err == nil {
+		for _, cat := range archived {
+			im.known[strings.ToLower(cat)] = cat
+		}
+	}
+	if rules, err := h.storage.GetSubCategoryMappings(); err == nil {
+		if engine, err := NewMappingEngine(rules); err == nil {
+			im.mapping = engine
+		}
+	}
+	return im, nil
+}
+
+// skip records a skipped row with the reason
+func (im *importer) skip(line int, format string, args ...any) {
+	im.result.Skipped++
+	im.result.Warnings = append(im.result.Warnings, fmt.Sprintf("%s %d: ", cmp.Or(im.unit, "row"), line)+fmt.Sprintf(format, args...))
+}
+
+// add resolves a row and imports it, unless it is a duplicate or invalid
+func (im *importer) add(row importRow) {
+	expense := storage.Expense{
+		Name:        row.Name,
+		Category:    strings.TrimSpace(row.Category),
+		SubCategory: strings.TrimSpace(row.SubCategory),
+		Amount:      math.Round(row.Amount*100) / 100,
+		Date:        row.Date,
+		Tags:        row.Tags,
+		Notes:       row.Notes,
+		Metadata:    row.Metadata,
+	}
+	if im.batch != nil {
+		expense.Metadata = maps.Clone(expense.Metadata)
+		if expense.Metadata == nil {
+			expense.Metadata = storage.Metadata{}
+		}
+		expense.Metadata[importMetadata] = maps.Clone(im.batch)
+	}
+	if im.mapping != nil {
+		im.mapping.Apply(&expense)
+	}
+	category, subCategory := expense.Category, expense.SubCategory
+	if category == "" && im.requireCategory {
+		im.skip(row.Line, "missing category")
+		return
+	}
+	if category == "" {
+		category = "Miscellaneous"
+		if _, ok := im.known["miscellaneous"]; !ok && len(im.categories) > 0 {
+			category = im.categories[0]
+		}
+	}
+	if known, ok := im.known[strings.ToLower(category)]; ok {
+		category = known
+	}
+
+	currency := im.currency
+	if row.Currency != "" {
+		currency = strings.ToLower(strings.TrimSpace(row.Currency))
+		if _, ok := storage.LookupCurrency(currency, im.currencies); !ok {
+			im.skip(row.Line, "unsupported currency '%s'", row.Currency)
+			return
+		}
+	}
+
+	expense.Category = category
+	expense.Currency = currency
+	if err := expense.Validate(); err != nil {
+		im.skip(row.Line, "%v", err)
+		return
+	}
+	if !im.keepDuplicates {
+		if duplicate, err := im.h.storage.FindDuplicateExpense(expense.Name, expense.Category, expense.Amount, expense.Date); err != nil {
+			log.Printf("Warning: Error checking for duplicate on row %d: %v\n", row.Line, err)
+		} else if duplicate {
+			im.skip(row.Line, "identical expense already exists (%s, %.2f, %s)", expense.Name, expense.Amount, expense.Date.Format("2006-01-02"))
+			return
+		}
+	}
+
+	if !im.dryRun {
+		if err := im.h.storage.AddExpense(expense); err != nil {
+			log.Printf("Error: Could not add expense from row %d: %v\n", row.Line, err)
+			im.skip(row.Line, "could not be saved")
+			return
+		}
+		time.Sleep(10 * time.Millisecond) // Throttle to reduce storage overhead
+	} else {
+		im.result.Expenses = append(im.result.Expenses, expense)
+	}
+	im.result.Imported++
+
+	if _, ok := im.known[strings.ToLower(category)]; !ok {
+		im.result.NewCategories = append(im.result.NewCategories, category)
+		im.known[strings.ToLower(category)] = category
+	}
+	if subCategory != "" && !slices.Contains(im.newSubCategories[category], subCategory) {
+		if err := storage.ValidateSubCategory(im.h.storage, category, subCategory); err != nil {
+			im.newSubCategories[category] = append(im.newSubCategories[category], subCategory)
+		}
+	}
+}
+
+// finish adds new categories and subcategories to the config (skipped on a dry run)
+func (im *importer) finish() *ImportResult {
+	if im.dryRun {
+		return im.result
+	}
+	if len(im.result.NewCategories) > 0 {
+		if err := im.h.storage.UpdateCategories(append(im.categories, im.result.NewCategories...)); err != nil {
+			log.Printf("Warning: Failed to add new categories to config: %v\n", err)
+		}
+	}
+	for category, subCategories := range im.newSubCategories {
+		for _, subCategory := range subCategories {
+			if err := im.h.storage.AddSubCategory(category, subCategory); err != nil {
+				log.Printf("Warning: Failed to add subcategory '%s' to category '%s': %v\n", subCategory, category, err)
+			}
+		}
+	}
+	return im.result
+}
+
+// csvRow looks up columns by name in a record
+type csvRow struct {
+	cols   map[string]int
+	record []string
+}
+
+// get returns the first non-empty value among the named columns
+func (r csvRow) get(names ...string) string {
+	for _, name := range names {
+		if i, ok := r.cols[name]; ok && i < len(r.record) {
+			if value := strings.TrimSpace(r.record[i]); value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// appImportFormat maps another app's CSV export onto expenses
+type appImportFormat struct {
+	name        string
+	required    [][]string                          // each entry lists accepted names for one required column
+	dateColumns []string                            // accepted names of the date column, "date" when empty
+	slashDates  bool                                // dates may be month/day/year or day/month/year
+	parse       func(row csvRow) (importRow, error) // an error skips the row with a warning
+}
+
+var fireflyFormat = appImportFormat{
+	name:     "Firefly III",
+	required: [][]string{{"amount"}, {"date"}, {"description"}, {"type"}},
+	parse: func(row csvRow) (importRow, error) {
+		amount, err := parseImportAmount(row.get("amount"))
+		if err != nil {
+			return importRow{}, err
+		}
+		var account string
+		switch strings.ToLower(row.get("type")) {
+		case "withdrawal":
+			amount = -math.Abs(amount)
+			account = row.get("source_name")
+		case "deposit":
+			amount = math.Abs(amount)
+			account = row.get("destination_name")
+		default:
+			return importRow{}, fmt.Errorf("%s transactions are not imported", strings.ToLower(row.get("type")))
+		}
+		return importRow{
+			Name:     row.get("description"),
+			Category: row.get("category"),
+			Amount:   amount,
+			Currency: row.get("currency_code"),
+			Tags:     appendTag(splitAndTrim(row.get("tags"), ","), account),
+			Notes:    row.get("notes"),
+		}, nil
+	},
+}
+
+var actualFormat = appImportFormat{
+	name:     "Actual Budget",
+	required: [][]string{{"amount"}, {"date"}, {"payee", "notes"}},
+	parse: func(row csvRow) (importRow, error) {
+		payee := row.get("payee")
+		category := row.get("category")
+		if category == "" && strings.HasPrefix(strings.ToLower(payee), "transfer") {
+			return importRow{}, fmt.Errorf("transfers are not imported")
+		}
+		amount, err := parseImportAmount(row.get("split_amount", "amount"))
+		if err != nil {
+			return importRow{}, err
+		}
+		name, notes := payee, row.get("notes")
+		if name == "" {
+			name, notes = notes, ""
+		}
+		return importRow{
+			Name:     name,
+			Category: category,
+			Amount:   amount,
+			Tags:     appendTag(nil, row.get("account")),
+			Notes:    notes,
+		}, nil
+	},
+}
+
+var cashewFormat = appImportFormat{
+	name:     "Cashew",
+	required: [][]string{{"amount"}, {"date"}, {"title", "note", "category name"}},
+	parse: func(row csvRow) (importRow, error) {
+		amount, err := parseImportAmount(row.get("amount"))
+		if err != nil {
+			return importRow{}, err
+		}
+		if income, err := strconv.ParseBool(row.get("income")); err == nil {
+			amount = math.Abs(amount)
+			if !income {
+				amount = -amount
+			}
+		}
+		name, notes := row.get("title"), row.get("note")
+		if name == "" {
+			name, notes = row.get("note", "category name"), ""
+		}
+		return importRow{
+			Name:        name,
+			Notes:       notes,
+			Category:    row.get("category name"),
+			SubCategory: row.get("subcategory name"),
+			Amount:      amount,
+			Currency:    row.get("currency"),
+			Tags:        appendTag(nil, row.get("account")),
+		}, nil
+	},
+}
+
+// payPalTransfers are PayPal activity types that move money between PayPal and a bank or card, or
+// only hold it, rather than pay for or receive anything
+var payPalTransfers = []string{"withdrawal", "deposit", "transfer", "currency conversion", "authorization", "hold"}
+
+var payPalFormat = appImportFormat{
+	name:       "PayPal",
+	required:   [][]string{{"gross"}, {"date"}, {"name", "type"}},
+	slashDates: true,
+	parse: func(row csvRow) (importRow, error) {
+		if status := strings.ToLower(row.get("status")); status != "" && status != "completed" {
+			return importRow{}, fmt.Errorf("%s transactions are not imported", status)
+		}
+		if strings.EqualFold(row.get("balance impact"), "memo") {
+			return importRow{}, fmt.Errorf("memo entries are not imported")
+		}
+		kind := strings.ToLower(row.get("type"))
+		if slices.ContainsFunc(payPalTransfers, func(transfer string) bool { return strings.Contains(kind, transfer) }) {
+			return importRow{}, fmt.Errorf("%s entries are not imported", kind)
+		}
+		amount, err := parseImportAmount(row.get("gross"))
+		if err != nil {
+			return importRow{}, err
+		}
+		var fee float64
+		if value := row.get("fee"); value != "" {
+			if fee, err = parseImportAmount(value); err != nil {
+				return importRow{}, err
+			}
+		}
+		parsed := importRow{
+			Name:     row.get("name", "item title", "subject", "type"),
+			Notes:    row.get("note", "subject", "item title"),
+			Amount:   amount,
+			Fee:      fee, // already negative
+			Currency: row.get("currency"),
+			Tags:     []string{"PayPal"},
+		}
+		if id := row.get("transaction id"); id != "" {
+			parsed.Metadata = storage.Metadata{"paypal": {"transaction_id": id}}
+		}
+		return parsed, nil
+	},
+}
+
+// stripeTransfers are Stripe balance types that pay the balance out or move it between accounts
+var stripeTransfers = []string{"payout", "transfer", "topup"}
+
+// stripeFormat reads the balance transaction export and the itemized payout reconciliation report
+var stripeFormat = appImportFormat{
+	name:        "Stripe",
+	required:    [][]string{{"amount", "gross"}, {"created (utc)", "created_utc", "created"}},
+	dateColumns: []string{"created (utc)", "created_utc", "created"},
+	parse: func(row csvRow) (importRow, error) {
+		kind := strings.ToLower(row.get("type", "reporting_category"))
+		if slices.ContainsFunc(stripeTransfers, func(transfer string) bool { return strings.HasPrefix(kind, transfer) }) {
+			return importRow{}, fmt.Errorf("%s entries are not imported", kind)
+		}
+		amount, err := parseImportAmount(row.get("amount", "gross"))
+		if err != nil {
+			return importRow{}, err
+		}
+		var fee float64
+		if value := row.get("fee"); value != "" {
+			if fee, err = parseImportAmount(value); err != nil {
+				return importRow{}, err
+			}
+		}
+		name := row.get("description", "customer_email", "customer email")
+		if name == "" {
+			name = "Stripe " + strings.ReplaceAll(cmp.Or(kind, "charge"), "_", " ")
+		}
+		parsed := importRow{
+			Name:     name,
+			Amount:   amount,
+			Fee:      -fee, // the net is the amount minus the fee
+			Currency: row.get("currency"),
+			Tags:     []string{"Stripe"},
+		}
+		if id := row.get("id", "balance_transaction_id"); id != "" {
+			parsed.Metadata = storage.Metadata{"stripe": {"id": id}}
+		}
+		return parsed, nil
+	},
+}
+
+// reSlashDate matches month/day/year and day/month/year dates, e.g., 03/14/2026 or 14/03/2026
+var reSlashDate = regexp.MustCompile(`^(\d{1,2})/(\d{1,2})/(\d{4})$`)
+
+// dayFirst reports whether the slash dates of a column are day/month/year, which shows in any
+// day above 12; month/day/year, the US format, is assumed otherwise
+func dayFirst(records [][]string, col int) bool {
+	for _, record := range records {
+		if col >= len(record) {
+			continue
+		}
+		if match := reSlashDate.FindStringSubmatch(strings.TrimSpace(record[col])); match != nil {
+			if first, _ := strconv.Atoi(match[1]); first > 12 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseImportAmount accepts plain and thousands-separated amounts, e.g. "-1,234.50"
+func parseImportAmount(value string) (float64, error) {
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(value, ",", ""), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount '%s'", value)
+	}
+	return amount, nil
+}
+
+// appendTag adds an account name as a tag, skipping empty names
+func appendTag(tags []string, tag string) []string {
+	if tag == "" {
+		return tags
+	}
+	return append(tags, tag)
+}
+
+// readUploadedCSV reads the CSV from the "file" form field, writing an error response on failure
+func readUploadedCSV(w http.ResponseWriter, r *http.Request) ([][]string, bool) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB max file size
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Could not parse multipart form"})
+		return nil, false
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Error retrieving the file"})
+		return nil, false
+	}
+	defer file.Close()
+	// a byte order mark in front of a quoted header (PayPal does this) trips up the CSV reader
+	buffered := bufio.NewReader(file)
+	if bom, err := buffered.Peek(3); err == nil && string(bom) == "\ufeff" {
+		buffered.Discard(3)
+	}
+	reader := csv.NewReader(buffered)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Failed to read CSV file"})
+		return nil, false
+	}
+	if len(records) < 2 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "CSV file must have a header and at least one data row"})
+		return nil, false
+	}
+	return records, true
+}
+
+// uploadedFileName is the name of the file in the "file" form field, after readUploadedCSV
+func uploadedFileName(r *http.Request) string {
+	if r.MultipartForm == nil || len(r.MultipartForm.File["file"]) == 0 {
+		return ""
+	}
+	return r.MultipartForm.File["file"][0].Filename
+}
+
+// importFromApp runs an app export through the importer; ?dryRun=true previews without writing
+func (h *Handler) importFromApp(w http.ResponseWriter, r *http.Request, format appImportFormat) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	records, ok := readUploadedCSV(w, r)
+	if !ok {
+		return
+	}
+
+	cols := make(map[string]int)
+	for i, col := range records[0] {
+		cols[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(col, "\ufeff")))] = i
+	}
+	for _, names := range format.required {
+		if !slices.ContainsFunc(names, func(name string) bool { _, ok := cols[name]; return ok }) {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Not a %s export, missing column: %s", format.name, strings.Join(names, " or "))})
+			return
+		}
+	}
+
+	fees := r.URL.Query().Get("fees")
+	if fees != "" && fees != "separate" && fees != "embedded" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'fees': must be separate or embedded"})
+		return
+	}
+	dateColumns := format.dateColumns
+	if len(dateColumns) == 0 {
+		dateColumns = []string{"date"}
+	}
+	slashLayout := "1/2/2006"
+	if format.slashDates {
+		for _, name := range dateColumns {
+			if col, ok := cols[name]; ok && dayFirst(records[1:], col) {
+				slashLayout = "2/1/2006"
+			}
+		}
+	}
+
+	im, err := h.newImporter(dryRun, format.name, uploadedFileName(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Could not prepare import"})
+		log.Printf("API ERROR: Failed to prepare %s import: %v\n", format.name, err)
+		return
+	}
+	for i, record := range records[1:] {
+		line := i + 2
+		row := csvRow{cols: cols, record: record}
+		parsed, err := format.parse(row)
+		if err != nil {
+			im.skip(line, "%v", err)
+			continue
+		}
+		parsed.Line = line
+		date := row.get(dateColumns...)
+		if format.slashDates && reSlashDate.MatchString(date) {
+			parsed.Date, err = time.Parse(slashLayout, date)
+		} else {
+			parsed.Date, err = parseDate(date)
+		}
+		if err != nil {
+			im.skip(line, "unable to parse date: %s", date)
+			continue
+		}
+		if parsed.Fee != 0 && fees == "embedded" {
+			parsed.Amount += parsed.Fee
+		}
+		im.add(parsed)
+		if parsed.Fee != 0 && fees != "embedded" {
+			im.add(importRow{
+				Line:     line,
+				Name:     fmt.Sprintf("%s fee for %s", format.name, parsed.Name),
+				Category: r.URL.Query().Get("feeCategory"),
+				Amount:   parsed.Fee,
+				Currency: parsed.Currency,
+				Date:     parsed.Date,
+				Tags:     parsed.Tags,
+				Metadata: parsed.Metadata,
+			})
+		}
+	}
+	result := im.finish()
+	result.TotalProcessed = len(records) - 1
+
+	writeJSON(w, http.StatusOK, result)
+	log.Printf("HTTP: Imported %d expenses from %s (dry run: %v). Skipped %d records.\n", result.Imported, format.name, dryRun, result.Skipped)
+}
+
+// ImportFirefly imports a Firefly III transaction CSV export
+func (h *Handler) ImportFirefly(w http.ResponseWriter, r *http.Request) {
+	h.importFromApp(w, r, fireflyFormat)
+}
+
+// ImportActual imports an Actual Budget transaction CSV export
+func (h *Handler) ImportActual(w http.ResponseWriter, r *http.Request) {
+	h.importFromApp(w, r, actualFormat)
+}
+
+// ImportCashew imports a Cashew CSV export
+func (h *Handler) ImportCashew(w http.ResponseWriter, r *http.Request) {
+	h.importFromApp(w, r, cashewFormat)
+}
+
+// ImportPayPal imports a PayPal activity CSV download; fees are separate expenses unless ?fees=embedded
+func (h *Handler) ImportPayPal(w http.ResponseWriter, r *http.Request) {
+	h.importFromApp(w, r, payPalFormat)
+}
+
+// ImportStripe imports a Stripe balance or payout reconciliation CSV; fees are separate expenses
+// unless ?fees=embedded
+func (h *Handler) ImportStripe(w http.ResponseWriter, r *http.Request) {
+	h.importFromApp(w, r, stripeFormat)
+}
@@ -2,18 +2,16 @@ package api
 
 import (
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"slices"
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/tanq16/expenseowl/internal/storage"
 )
 
-// exports all expenses to CSV
+// exports all expenses to CSV; ?archived=true includes the expenses archived by the retention policy
 func (h *Handler) ExportCSV(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
@@ -25,13 +23,22 @@ func (h *Handler) ExportCSV(w http.ResponseWriter, r *http.Request) {
 		log.Printf("API ERROR: Failed to retrieve expenses for CSV export: %v\n", err)
 		return
 	}
+	if includeArchived, _ := strconv.ParseBool(r.URL.Query().Get("archived")); includeArchived {
+		archived, err := h.storage.GetArchivedExpenses()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve archived expenses"})
+			log.Printf("API ERROR: Failed to retrieve archived expenses for CSV export: %v\n", err)
+			return
+		}
+		expenses = append(expenses, archived...)
+	}
 	w.Header().Set("Content-Type", "text/csv")
 	w.Header().Set("Content-Disposition", "attachment; filename=expenses.csv")
 	writer := csv.NewWriter(w)
 	defer writer.Flush()
 
 	// Write header
-	headers := []string{"ID", "Name", "Category", "SubCategory", "Amount", "Date", "Tags"}
+	headers := []string{"ID", "Name", "Category", "SubCategory", "Amount", "Date", "Tags", "Notes", "Metadata"}
 	if err := writer.Write(headers); err != nil {
 		log.Printf("API ERROR: Failed to write CSV header: %v\n", err)
 		return
@@ -39,6 +46,11 @@ func (h *Handler) ExportCSV(w http.ResponseWriter, r *http.Request) {
 
 	// Write records
 	for _, expense := range expenses {
+		var metadata string
+		if len(expense.Metadata) > 0 {
+			metadataJSON, _ := json.Marshal(expense.Metadata)
+			metadata = string(metadataJSON)
+		}
 		record := []string{
 			expense.ID,
 			expense.Name,
@@ -48,6 +60,8 @@ func (h *Handler) ExportCSV(w http.ResponseWriter, r *http.Request) {
 			strconv.FormatFloat(expense.Amount, 'f', 2, 64),
 			expense.Date.Format(time.RFC3339),
 			strings.Join(expense.Tags, ","),
+			expense.Notes,
+			metadata,
 		}
 		if err := writer.Write(record); err != nil {
 			log.Printf("API ERROR: Failed to write CSV record for expense ID %s: %v\n", expense.ID, err)
@@ -57,30 +71,15 @@ func (h *Handler) ExportCSV(w http.ResponseWriter, r *http.Request) {
 	log.Println("HTTP: Exported expenses to CSV")
 }
 
-// imports expenses from CSV
+// imports expenses from CSV; ?dryRun=true previews the import without writing anything
 func (h *Handler) ImportCSV(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
 		return
 	}
-	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB max file size
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Could not parse multipart form"})
-		return
-	}
-	file, _, err := r.FormFile("file")
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Error retrieving the file"})
-		return
-	}
-	defer file.Close()
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Failed to read CSV file"})
-		return
-	}
-	if len(records) < 2 {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "CSV file must have a header and at least one data row"})
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	records, ok := readUploadedCSV(w, r)
+	if !ok {
 		return
 	}
 
@@ -108,46 +107,21 @@ func (h *Handler) ImportCSV(w http.ResponseWriter, r *http.Request) {
 	currencyIdx, currencyExists := colMap["currency"]
 	categoryIdx, categoryExists := colMap["category"]
 	subCategoryIdx, subCategoryExists := colMap["subcategory"]
+	notesIdx, notesExists := colMap["notes"]
+	metadataIdx, metadataExists := colMap["metadata"]
 
-	currentCategories, err := h.storage.GetCategories()
+	im, err := h.newImporter(dryRun, "CSV", uploadedFileName(r))
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Could not retrieve current categories"})
-		return
-	}
-	categorySet := make(map[string]bool)
-	for _, cat := range currentCategories {
-		categorySet[strings.ToLower(cat)] = true
-	}
-	
-	// Load subcategory mappings and create mapping engine
-	mappingRules, err := h.storage.GetSubCategoryMappings()
-	if err != nil {
-		log.Printf("Warning: Could not retrieve subcategory mappings: %v\n", err)
-		mappingRules = []storage.SubCategoryMappingRule{}
-	}
-	mappingEngine, err := NewMappingEngine(mappingRules)
-	if err != nil {
-		log.Printf("Warning: Could not create mapping engine: %v\n", err)
-		mappingEngine = nil
-	}
-	
-	// Track new subcategories to add
-	newSubCategories := make(map[string]map[string]bool) // category -> set of subcategories
-	
-	var newCategories []string
-	var importedCount, skippedCount int
-	// TODO: might be worth setting default currency when we have currency updation behavior
-	currencyVal, err := h.storage.GetCurrency()
-	if err != nil {
-		log.Printf("Error: Could not retrieve currency, shutting down import: %v\n", err)
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Could not retrieve currency"})
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Could not prepare import"})
+		log.Printf("API ERROR: Failed to prepare CSV import: %v\n", err)
 		return
 	}
+	im.requireCategory = true // rows without a category or a matching mapping rule are skipped
 
 	for i, record := range records[1:] {
+		line := i + 2
 		if len(record) != len(header) {
-			log.Printf("Warning: Skipping row %d due to incorrect column count\n", i+2)
-			skippedCount++
+			im.skip(line, "incorrect column count")
 			continue
 		}
 
@@ -155,193 +129,69 @@ func (h *Handler) ImportCSV(w http.ResponseWriter, r *http.Request) {
 		if idExists {
 			id := record[idIdx]
 			if _, err := h.storage.GetExpense(id); err == nil {
-				log.Printf("Info: Skipping row %d because expense with ID '%s' already exists\n", i+2, id)
-				skippedCount++
+				im.skip(line, "expense with ID '%s' already exists", id)
 				continue
 			}
 		}
 
-		// Check for currency field, if provided - default is retrieved
-		localCurrency := currencyVal
-		if currencyExists {
-			currency := record[currencyIdx]
-			if !slices.Contains(storage.SupportedCurrencies, currency) {
-				log.Printf("Warning: Skipping row %d due to invalid currency: %s\n", i+2, currency)
-				skippedCount++
-				continue
-			}
-			localCurrency = strings.TrimSpace(currency)
-		}
-
 		amount, err := strconv.ParseFloat(record[colMap["amount"]], 64)
 		if err != nil {
-			log.Printf("Warning: Skipping row %d due to invalid amount: %s\n", i+2, record[colMap["amount"]])
-			skippedCount++
+			im.skip(line, "invalid amount '%s'", record[colMap["amount"]])
 			continue
 		}
 		date, err := parseDate(record[colMap["date"]])
 		if err != nil {
-			log.Printf("Warning: Skipping row %d due to invalid date: %v\n", i+2, err)
-			skippedCount++
+			im.skip(line, "%v", err)
 			continue
 		}
-		
-		name := strings.TrimSpace(record[colMap["name"]])
-		
-		// Handle category - either from CSV or from mapping rules
-		var category string
-		if categoryExists {
-			category = strings.TrimSpace(record[categoryIdx])
-		}
-		
-		// If no category from CSV and we have mapping engine, try to get category from mapping
-		if category == "" && mappingEngine != nil {
-			mappedCategory, _ := mappingEngine.ApplyMappingWithCategory(name)
-			if mappedCategory != "" {
-				category = mappedCategory
-			}
-		}
-		
-		// If still no category, skip this row
-		if category == "" {
-			log.Printf("Warning: Skipping row %d due to missing category\n", i+2)
-			skippedCount++
-			continue
+
+		row := importRow{
+			Line:   line,
+			Name:   strings.TrimSpace(record[colMap["name"]]),
+			Amount: amount,
+			Date:   date,
 		}
-		
-		// Check for duplicate based on content (name, category, amount, date)
-		isDuplicate, err := h.storage.FindDuplicateExpense(name, category, amount, date)
-		if err != nil {
-			log.Printf("Warning: Error checking for duplicate on row %d: %v\n", i+2, err)
-		} else if isDuplicate {
-			log.Printf("Info: Skipping row %d because identical expense already exists (name: %s, category: %s, amount: %.2f, date: %s)\n", 
-				i+2, name, category, amount, date.Format("2006-01-02"))
-			skippedCount++
-			continue
+		// Check for currency field, if provided - default is retrieved
+		if currencyExists {
+			row.Currency = record[currencyIdx]
 		}
-		
-		if _, ok := categorySet[strings.ToLower(category)]; !ok {
-			newCategories = append(newCategories, category)
-			categorySet[strings.ToLower(category)] = true // Add to set to handle duplicates in the same file
+		if categoryExists {
+			row.Category = record[categoryIdx]
 		}
-		
-		// Handle subcategory
-		var subCategory string
-		
-		// First, check if SubCategory column exists in CSV
-		if subCategoryExists && record[subCategoryIdx] != "" {
-			subCategory = strings.TrimSpace(record[subCategoryIdx])
+		if subCategoryExists {
+			row.SubCategory = record[subCategoryIdx]
 		}
-		
-		// If no subcategory from CSV, apply mapping engine
-		if subCategory == "" && mappingEngine != nil {
-			if categoryExists {
-				// CSV has category column - only map subcategory
-				subCategory = mappingEngine.ApplyMapping(name, category)
-			} else {
-				// CSV doesn't have category column - get subcategory from full mapping
-				_, subCategory = mappingEngine.ApplyMappingWithCategory(name)
-			}
+		if tagsExists && record[tagsIdx] != "" {
+			row.Tags = splitAndTrim(record[tagsIdx], ",")
 		}
-		
-		// If we have a subcategory, validate and track it
-		if subCategory != "" {
-			// Validate subcategory against category
-			if err := storage.ValidateSubCategory(h.storage, category, subCategory); err != nil {
-				// SubCategory doesn't exist yet, track it for creation
-				if newSubCategories[category] == nil {
-					newSubCategories[category] = make(map[string]bool)
-				}
-				newSubCategories[category][subCategory] = true
-			}
+		if notesExists {
+			row.Notes = record[notesIdx]
 		}
-		
-		var tags []string
-		if tagsExists {
-			tagsStr := record[tagsIdx]
-			if tagsStr != "" {
-				tags = strings.Split(tagsStr, ",")
-				for i := range tags {
-					tags[i] = strings.TrimSpace(tags[i])
-				}
+		if metadataExists && record[metadataIdx] != "" {
+			if err := json.Unmarshal([]byte(record[metadataIdx]), &row.Metadata); err != nil {
+				im.skip(line, "invalid metadata")
+				continue
 			}
 		}
-
-		expense := storage.Expense{
-			Name:        name,
-			Category:    category,
-			SubCategory: subCategory,
-			Amount:      amount,
-			Currency:    localCurrency,
-			Date:        date,
-			Tags:        tags,
-		}
-		if err := expense.Validate(); err != nil {
-			log.Printf("Warning: Skipping row %d due to validation error: %v\n", i+2, err)
-			skippedCount++
-			continue
-		}
-		if err := h.storage.AddExpense(expense); err != nil {
-			log.Printf("Error: Could not add expense from row %d: %v\n", i+2, err)
-			skippedCount++
-			continue
-		}
-		importedCount++
-		time.Sleep(10 * time.Millisecond) // Throttle to reduce storage overhead
+		im.add(row)
 	}
 
-	if len(newCategories) > 0 {
-		if err := h.storage.UpdateCategories(append(currentCategories, newCategories...)); err != nil {
-			log.Printf("Warning: Failed to add new categories to config: %v\n", err)
-		}
-	}
-	
-	// Add new subcategories to config
-	if len(newSubCategories) > 0 {
-		for category, subCats := range newSubCategories {
-			for subCat := range subCats {
-				if err := h.storage.AddSubCategory(category, subCat); err != nil {
-					log.Printf("Warning: Failed to add subcategory '%s' to category '%s': %v\n", subCat, category, err)
-				}
-			}
-		}
-	}
-	
-	writeJSON(w, http.StatusOK, map[string]any{
-		"status":          "success",
-		"total_processed": len(records) - 1,
-		"imported":        importedCount,
-		"skipped":         skippedCount,
-		"new_categories":  newCategories,
-	})
-	log.Printf("HTTP: Imported %d expenses from CSV file. Skipped %d records.", importedCount, skippedCount)
+	result := im.finish()
+	result.TotalProcessed = len(records) - 1
+	writeJSON(w, http.StatusOK, result)
+	log.Printf("HTTP: Imported %d expenses from CSV file (dry run: %v). Skipped %d records.\n", result.Imported, dryRun, result.Skipped)
 }
 
-// handles importing from ExpenseOwl < v4.0
+// handles importing from ExpenseOwl < v4.0; ?dryRun=true previews the import without writing anything
 // TODO: remove this in the future
 func (h *Handler) ImportOldCSV(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
 		return
 	}
-	if err := r.ParseMultipartForm(10 << 20); err != nil { // 10MB max file size
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Could not parse multipart form"})
-		return
-	}
-	file, _, err := r.FormFile("file")
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Error retrieving the file"})
-		return
-	}
-	defer file.Close()
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Failed to read CSV file"})
-		return
-	}
-	if len(records) < 2 {
-		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "CSV file must have a header and at least one data row"})
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	records, ok := readUploadedCSV(w, r)
+	if !ok {
 		return
 	}
 
@@ -358,80 +208,50 @@ func (h *Handler) ImportOldCSV(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	currentCategories, err := h.storage.GetCategories()
+	im, err := h.newImporter(dryRun, "CSV (v3.2-)", uploadedFileName(r))
 	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Could not retrieve current categories"})
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Could not prepare import"})
+		log.Printf("API ERROR: Failed to prepare CSV import: %v\n", err)
 		return
 	}
-	categorySet := make(map[string]bool)
-	for _, cat := range currentCategories {
-		categorySet[strings.ToLower(cat)] = true
-	}
-	var newCategories []string
-	var importedCount, skippedCount int
+	im.requireCategory = true
 
 	for i, record := range records[1:] {
+		line := i + 2
 		if len(record) != len(header) {
-			log.Printf("Warning: Skipping row %d due to incorrect column count\n", i+2)
-			skippedCount++
+			im.skip(line, "incorrect column count")
 			continue
 		}
 		amount, err := strconv.ParseFloat(record[colMap["amount"]], 64)
 		if err != nil {
-			log.Printf("Warning: Skipping row %d due to invalid amount: %s\n", i+2, record[colMap["amount"]])
-			skippedCount++
+			im.skip(line, "invalid amount '%s'", record[colMap["amount"]])
 			continue
 		}
 		date, err := parseDate(record[colMap["date"]])
 		if err != nil {
-			log.Printf("Warning: Skipping row %d due to invalid date: %v\n", i+2, err)
-			skippedCount++
+			im.skip(line, "%v", err)
 			continue
 		}
 		category := strings.TrimSpace(record[colMap["category"]])
-		if _, ok := categorySet[strings.ToLower(category)]; !ok {
-			newCategories = append(newCategories, category)
-			categorySet[strings.ToLower(category)] = true // Add to set to handle duplicates in the same file
-		}
 
 		// switches sign for new expenseowl
 		amountUpdated := amount
 		if category != "Income" {
 			amountUpdated = amount * -1
 		}
-		expense := storage.Expense{
+		im.add(importRow{
+			Line:     line,
 			Name:     strings.TrimSpace(record[colMap["name"]]),
 			Category: category,
 			Amount:   amountUpdated,
 			Date:     date,
-		}
-		if err := expense.Validate(); err != nil {
-			log.Printf("Warning: Skipping row %d due to validation error: %v\n", i+2, err)
-			skippedCount++
-			continue
-		}
-		if err := h.storage.AddExpense(expense); err != nil {
-			log.Printf("Error: Could not add expense from row %d: %v\n", i+2, err)
-			skippedCount++
-			continue
-		}
-		importedCount++
-		time.Sleep(10 * time.Millisecond)
+		})
 	}
 
-	if len(newCategories) > 0 {
-		if err := h.storage.UpdateCategories(append(currentCategories, newCategories...)); err != nil {
-			log.Printf("Warning: Failed to add new categories to config: %v\n", err)
-		}
-	}
-	writeJSON(w, http.StatusOK, map[string]any{
-		"status":          "success",
-		"total_processed": len(records) - 1,
-		"imported":        importedCount,
-		"skipped":         skippedCount,
-		"new_categories":  newCategories,
-	})
-	log.Printf("HTTP: Imported %d expenses from CSV file. Skipped %d records.", importedCount, skippedCount)
+	result := im.finish()
+	result.TotalProcessed = len(records) - 1
+	writeJSON(w, http.StatusOK, result)
+	log.Printf("HTTP: Imported %d expenses from CSV file (dry run: %v). Skipped %d records.\n", result.Imported, dryRun, result.Skipped)
 }
 
 func parseDate(dateStr string) (time.Time, error) {
@@ -439,6 +259,7 @@ func parseDate(dateStr string) (time.Time, error) {
 		time.RFC3339,
 		"2006-01-02T15:04:05Z07:00",
 		"2006-01-02 15:04:05",
+		"2006-01-02 15:04", // Stripe exports
 		"2006-01-02",
 		"2006-1-2",
 		"2006/01/02",
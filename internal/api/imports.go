@@ -0,0 +1,129 @@
+package api
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Every import (a CSV upload, an app export, a Plaid sync) is a batch: its expenses carry the
+// batch in the "import" metadata namespace, so a bad import can be listed and rolled back as a
+// whole. Batches aren't stored anywhere else; they exist as long as their expenses do.
+
+const importMetadata = "import" // metadata namespace of imported expenses
+
+// ImportBatch summarizes the expenses added by one import
+type ImportBatch struct {
+	ID       string  `json:"id"`
+	Source   string  `json:"source"`         // e.g., "CSV", "Firefly III", or "Plaid"
+	File     string  `json:"file,omitempty"` // uploaded file, or the bank of a Plaid sync
+	Imported string  `json:"imported"`       // RFC 3339 time of the import
+	Count    int     `json:"count"`
+	Total    float64 `json:"total"` // sum of the amounts, so income offsets spending
+	From     string  `json:"from"`  // YYYY-MM-DD of the earliest expense
+	To       string  `json:"to"`    // YYYY-MM-DD of the latest expense
+}
+
+// newImportBatch returns the metadata recorded with each expense of a new import
+func newImportBatch(source string, file string, now time.Time) map[string]string {
+	file = storage.SanitizeString(file)
+	if utf8.RuneCountInString(file) > 200 {
+		file = string([]rune(file)[:200])
+	}
+	batch := map[string]string{"batch": uuid.New().String(), "source": source, "imported": now.UTC().Format(time.RFC3339)}
+	if file != "" {
+		batch["file"] = file
+	}
+	return batch
+}
+
+// importBatches groups the imported expenses by batch, newest import first
+func importBatches(expenses []storage.Expense) []ImportBatch {
+	index := make(map[string]int)
+	batches := []ImportBatch{}
+	for _, expense := range expenses {
+		meta := expense.Metadata[importMetadata]
+		if meta["batch"] == "" {
+			continue
+		}
+		date := expense.Date.Format("2006-01-02")
+		i, ok := index[meta["batch"]]
+		if !ok {
+			i = len(batches)
+			index[meta["batch"]] = i
+			batches = append(batches, ImportBatch{ID: meta["batch"], Source: meta["source"], File: meta["file"], Imported: meta["imported"], From: date, To: date})
+		}
+		batch := &batches[i]
+		batch.Count++
+		batch.Total += expense.Amount
+		batch.From = min(batch.From, date)
+		batch.To = max(batch.To, date)
+	}
+	for i := range batches {
+		batches[i].Total = math.Round(batches[i].Total*100) / 100
+	}
+	slices.SortFunc(batches, func(a, b ImportBatch) int {
+		return cmp.Or(strings.Compare(b.Imported, a.Imported), strings.Compare(a.ID, b.ID))
+	})
+	return batches
+}
+
+// GetImports lists the import batches
+func (h *Handler) GetImports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for imports: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, importBatches(expenses))
+}
+
+// RollbackImport deletes every expense of the batch in ?batch=, including ones edited since
+func (h *Handler) RollbackImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	batch := r.URL.Query().Get("batch")
+	if batch == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "'batch' is required"})
+		return
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for rollback: %v\n", err)
+		return
+	}
+	var ids []string
+	for _, expense := range expenses {
+		if expense.Metadata[importMetadata]["batch"] == batch {
+			ids = append(ids, expense.ID)
+		}
+	}
+	if len(ids) == 0 {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Import not found"})
+		return
+	}
+	if err := h.storage.RemoveMultipleExpenses(ids); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to roll back import"})
+		log.Printf("API ERROR: Failed to roll back import %s: %v\n", batch, err)
+		return
+	}
+	log.Printf("HTTP: Rolled back import %s, removed %d expenses\n", batch, len(ids))
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success", "removed": fmt.Sprint(len(ids))})
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Spending patterns show when money goes out: on which days of the week, and how early or late in
+// the budget period. Instances of recurring expenses are left out, since rent on the 1st says
+// nothing about habits.
+
+// SpendingPatterns is the response of /api/insights/patterns
+type SpendingPatterns struct {
+	From           string         `json:"from"` // first day (YYYY-MM-DD)
+	To             string         `json:"to"`   // last day (YYYY-MM-DD)
+	Currency       string         `json:"currency"`
+	Weekdays       []WeekdaySpend `json:"weekdays"`       // Sunday first
+	WeekdayAverage float64        `json:"weekdayAverage"` // spent per day, Monday to Friday
+	WeekendAverage float64        `json:"weekendAverage"` // spent per day, Saturday and Sunday
+	WeekendRatio   float64        `json:"weekendRatio"`   // weekend over weekday average, 0 without weekday spending
+	Period         PeriodSpread   `json:"period"`
+}
+
+// WeekdaySpend is the spending on one day of the week
+type WeekdaySpend struct {
+	Weekday string  `json:"weekday"`
+	Days    int     `json:"days"` // times the day occurs in the range
+	Spent   float64 `json:"spent"`
+	Average float64 `json:"average"` // spent per occurrence of the day
+}
+
+// PeriodSpread is how spending spreads over the budget period, as percentages of the total
+type PeriodSpread struct {
+	Early  float64 `json:"early"`  // first third of the period
+	Middle float64 `json:"middle"` // second third
+	Late   float64 `json:"late"`   // last third
+	Shape  string  `json:"shape"`  // front-loaded, back-loaded, or even
+}
+
+// Period spread shapes; a third that takes this many points more than the other end tips the shape
+const (
+	ShapeFrontLoaded = "front-loaded"
+	ShapeBackLoaded  = "back-loaded"
+	ShapeEven        = "even"
+	shapeThreshold   = 10
+)
+
+// periodThird is the third (0 to 2) of its budget period a day falls in, by the middle of the day
+func periodThird(config periods.Config, date time.Time) int {
+	period := config.Containing(date)
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	length := period.End.Sub(period.Start).Hours() / 24
+	position := (day.Sub(period.Start).Hours()/24 + 0.5) / length
+	return min(int(position*3), 2)
+}
+
+// spendingPatterns works out the patterns of the expenses between start and end (exclusive), counting
+// each weekday's occurrences in that range
+func spendingPatterns(expenses []storage.Expense, config periods.Config, start, end time.Time) SpendingPatterns {
+	patterns := SpendingPatterns{Weekdays: make([]WeekdaySpend, 7)}
+	for day := range 7 {
+		patterns.Weekdays[day].Weekday = time.Weekday(day).String()
+	}
+	var thirds [3]float64
+	for _, expense := range expenses {
+		if expense.Amount >= 0 || expense.RecurringID != "" || expense.Date.Before(start) || !expense.Date.Before(end) {
+			continue
+		}
+		patterns.Weekdays[expense.Date.Weekday()].Spent -= expense.Amount
+		thirds[periodThird(config, expense.Date)] -= expense.Amount
+	}
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		patterns.Weekdays[day.Weekday()].Days++
+	}
+
+	var weekdaySpent, weekendSpent float64
+	var weekdays, weekendDays int
+	for i := range patterns.Weekdays {
+		weekday := &patterns.Weekdays[i]
+		if weekday.Days > 0 {
+			weekday.Average = math.Round(weekday.Spent/float64(weekday.Days)*100) / 100
+		}
+		if time.Weekday(i) == time.Saturday || time.Weekday(i) == time.Sunday {
+			weekendSpent, weekendDays = weekendSpent+weekday.Spent, weekendDays+weekday.Days
+		} else {
+			weekdaySpent, weekdays = weekdaySpent+weekday.Spent, weekdays+weekday.Days
+		}
+		weekday.Spent = math.Round(weekday.Spent*100) / 100
+	}
+	if weekdays > 0 {
+		patterns.WeekdayAverage = math.Round(weekdaySpent/float64(weekdays)*100) / 100
+	}
+	if weekendDays > 0 {
+		patterns.WeekendAverage = math.Round(weekendSpent/float64(weekendDays)*100) / 100
+	}
+	if patterns.WeekdayAverage > 0 {
+		patterns.WeekendRatio = math.Round(patterns.WeekendAverage/patterns.WeekdayAverage*100) / 100
+	}
+
+	patterns.Period.Shape = ShapeEven
+	if total := thirds[0] + thirds[1] + thirds[2]; total > 0 {
+		share := func(spent float64) float64 { return math.Round(spent/total*1000) / 10 }
+		patterns.Period.Early, patterns.Period.Middle, patterns.Period.Late = share(thirds[0]), share(thirds[1]), share(thirds[2])
+		switch {
+		case patterns.Period.Early-patterns.Period.Late > shapeThreshold:
+			patterns.Period.Shape = ShapeFrontLoaded
+		case patterns.Period.Late-patterns.Period.Early > shapeThreshold:
+			patterns.Period.Shape = ShapeBackLoaded
+		}
+	}
+	return patterns
+}
+
+// GetSpendingPatterns returns spending by weekday and across the budget period, in the range picked as
+// for the merchant report (the last 12 months by default); the search filters of /expenses narrow it down
+func (h *Handler) GetSpendingPatterns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	query := r.URL.Query()
+	start, end, err := h.dateRange(query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if query.Get("period") == "" && query.Get("from") == "" && query.Get("to") == "" {
+		start = end.AddDate(-1, 0, 0)
+	}
+	filter, err := expenseFilter(query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	expenses, err := h.storage.FindExpenses(filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for spending patterns: %v\n", err)
+		return
+	}
+	expenses = h.reportable(expenses)
+	if start.IsZero() {
+		// all time starts on the day of the first expense
+		start = end
+		for _, expense := range expenses {
+			if expense.Date.Before(start) {
+				start = expense.Date
+			}
+		}
+	}
+	// whole days, so each weekday is counted as often as it occurs
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, start.Location())
+	if day := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, end.Location()); day.Before(end) {
+		end = day.AddDate(0, 0, 1)
+	}
+
+	patterns := spendingPatterns(expenses, h.periodConfig(), start, end)
+	patterns.From, patterns.To = start.Format("2006-01-02"), end.AddDate(0, 0, -1).Format("2006-01-02")
+	if patterns.Currency, err = h.storage.GetCurrency(); err != nil {
+		patterns.Currency = "usd"
+	}
+	writeJSON(w, http.StatusOK, patterns)
+}
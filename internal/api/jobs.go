@@ -0,0 +1,340 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/cron"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// The background jobs of a household, like report emails and the retention policy, run on one
+// scheduler. Each job has a default schedule that schedules.jobs in the runtime settings can
+// replace with a cron expression or an interval. A failed run is retried with a growing backoff,
+// and /api/admin/jobs lists the jobs with their last run, and runs one on demand.
+
+// Jobs
+const (
+	JobReportEmails = "report-emails" // email the summary of a closed period
+	JobSavedReports = "saved-reports" // run the saved reports that are due
+	JobRetention    = "retention"     // archive expenses past the retention policy
+	JobPriceRefresh = "price-refresh" // record the prices of holdings
+	JobBankSync     = "bank-sync"     // pull new transactions from Plaid, besides its webhook
+	JobWalletPush   = "wallet-push"   // push the devices whose budget pass changed
+)
+
+const (
+	minJobBackoff = time.Minute
+	maxJobBackoff = time.Hour
+)
+
+// job is a background task and when it runs
+type job struct {
+	name        string
+	description string
+	atStartup   bool                                  // run when the server starts, before its schedule
+	schedule    func(storage.ScheduleSettings) string // the default schedule
+	enabled     func(h *Handler) bool                 // nil when always enabled
+	run         func(ctx context.Context, h *Handler, now time.Time) error
+}
+
+// jobs are the background jobs, in the order they are listed
+var jobs = []job{
+	{
+		name:        JobReportEmails,
+		description: "Email the summary of each period once it closes",
+		atStartup:   true,
+		schedule:    func(s storage.ScheduleSettings) string { return fmt.Sprintf("@every %dm", s.ReportCheckMinutes) },
+		enabled:     func(h *Handler) bool { return h.mailer != nil },
+		run:         func(ctx context.Context, h *Handler, now time.Time) error { return h.sendDueReport(now) },
+	},
+	{
+		name:        JobSavedReports,
+		description: "Run and deliver the saved reports that are due",
+		atStartup:   true,
+		schedule:    func(s storage.ScheduleSettings) string { return fmt.Sprintf("@every %dm", s.ReportCheckMinutes) },
+		run:         func(ctx context.Context, h *Handler, now time.Time) error { return h.runDueSavedReports(ctx, now) },
+	},
+	{
+		name:        JobRetention,
+		description: "Archive the expenses past the retention policy",
+		atStartup:   true,
+		schedule:    func(s storage.ScheduleSettings) string { return fmt.Sprintf("@every %dh", s.ArchiveHours) },
+		run: func(ctx context.Context, h *Handler, now time.Time) error {
+			_, err := h.applyRetention(now)
+			return err
+		},
+	},
+	{
+		name:        JobPriceRefresh,
+		description: "Record the price of every holding",
+		atStartup:   true,
+		schedule:    func(storage.ScheduleSettings) string { return "@every 24h" },
+		enabled:     func(h *Handler) bool { return h.prices != nil },
+		run: func(ctx context.Context, h *Handler, now time.Time) error {
+			response, err := h.refreshPrices(ctx, now)
+			if err == nil && response.Updated > 0 {
+				log.Printf("Refreshed the prices of %d holdings\n", response.Updated)
+			}
+			return err
+		},
+	},
+	{
+		name:        JobBankSync,
+		description: "Pull new transactions from the connected banks",
+		schedule:    func(storage.ScheduleSettings) string { return "@every 6h" },
+		enabled:     func(h *Handler) bool { return h.plaid != nil },
+		run:         func(ctx context.Context, h *Handler, now time.Time) error { return h.syncPlaidItems(ctx) },
+	},
+	{
+		name:        JobWalletPush,
+		description: "Push the budget pass to the devices it changed on",
+		schedule:    func(storage.ScheduleSettings) string { return "@every 5m" },
+		enabled:     func(h *Handler) bool { return h.wallet != nil && h.wallet.webServiceURL != "" },
+		run:         func(ctx context.Context, h *Handler, now time.Time) error { return h.pushWalletUpdates(ctx, now) },
+	},
+}
+
+func findJob(name string) (job, bool) {
+	i := slices.IndexFunc(jobs, func(j job) bool { return j.name == name })
+	if i < 0 {
+		return job{}, false
+	}
+	return jobs[i], true
+}
+
+// JobStatus is a job as /api/admin/jobs lists it
+type JobStatus struct {
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	Schedule       string     `json:"schedule"`
+	Enabled        bool       `json:"enabled"` // false when what it needs isn't configured, e.g., SMTP
+	Running        bool       `json:"running"`
+	LastRun        *time.Time `json:"lastRun,omitempty"`
+	LastDurationMS int64      `json:"lastDurationMs,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	Failures       int        `json:"failures"` // runs failed in a row, retried with a backoff
+	NextRun        *time.Time `json:"nextRun,omitempty"`
+}
+
+// jobRun is the last run of a job
+type jobRun struct {
+	running  bool
+	at       time.Time
+	duration time.Duration
+	err      string
+	failures int
+}
+
+// jobScheduler keeps the runs of a household's jobs
+type jobScheduler struct {
+	mu      sync.Mutex
+	started time.Time // zero until Run starts
+	runs    map[string]*jobRun
+	wake    chan struct{} // reschedules after a run or a change of the settings
+}
+
+func newJobScheduler() *jobScheduler {
+	return &jobScheduler{runs: make(map[string]*jobRun), wake: make(chan struct{}, 1)}
+}
+
+// reschedule wakes the scheduler to work out the next runs again
+func (s *jobScheduler) reschedule() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// jobSchedule is the schedule of a job from the settings, with the default when it's unset or invalid
+func jobSchedule(j job, settings storage.ScheduleSettings) (string, cron.Schedule) {
+	if spec, ok := settings.Jobs[j.name]; ok {
+		if schedule, err := cron.Parse(spec); err == nil {
+			return spec, schedule
+		}
+	}
+	spec := j.schedule(settings)
+	schedule, _ := cron.Parse(spec)
+	return spec, schedule
+}
+
+// jobBackoff is how long to wait before retrying after a number of failures in a row
+func jobBackoff(failures int) time.Duration {
+	backoff := minJobBackoff << min(failures-1, 10)
+	return min(backoff, maxJobBackoff)
+}
+
+// nextRun is when a job is due next; the scheduler must be locked
+func (s *jobScheduler) nextRun(j job, schedule cron.Schedule) time.Time {
+	run, ok := s.runs[j.name]
+	if !ok {
+		if j.atStartup {
+			return s.started
+		}
+		return schedule.Next(s.started)
+	}
+	next := schedule.Next(run.at)
+	if run.failures > 0 {
+		if retry := run.at.Add(jobBackoff(run.failures)); next.IsZero() || retry.Before(next) {
+			return retry
+		}
+	}
+	return next
+}
+
+// status describes a job; the scheduler must be locked
+func (s *jobScheduler) status(h *Handler, j job, settings storage.ScheduleSettings) JobStatus {
+	spec, schedule := jobSchedule(j, settings)
+	status := JobStatus{Name: j.name, Description: j.description, Schedule: spec, Enabled: j.enabled == nil || j.enabled(h)}
+	if run, ok := s.runs[j.name]; ok {
+		at := run.at
+		status.Running, status.LastRun = run.running, &at
+		status.LastDurationMS, status.LastError, status.Failures = run.duration.Milliseconds(), run.err, run.failures
+	}
+	if status.Enabled && !s.started.IsZero() && !status.Running {
+		if next := s.nextRun(j, schedule); !next.IsZero() {
+			status.NextRun = &next
+		}
+	}
+	return status
+}
+
+// startRun marks a job as running, false when it already is; the scheduler must be locked
+func (s *jobScheduler) startRun(name string, now time.Time) (*jobRun, bool) {
+	run, ok := s.runs[name]
+	if ok && run.running {
+		return nil, false
+	}
+	if !ok {
+		run = &jobRun{}
+		s.runs[name] = run
+	}
+	run.running, run.at = true, now
+	return run, true
+}
+
+// runJob runs a job marked as running and records how it went
+func (h *Handler) runJob(ctx context.Context, j job, run *jobRun) {
+	start := run.at
+	err := j.run(ctx, h, start)
+
+	h.jobs.mu.Lock()
+	run.running, run.duration = false, time.Since(start)
+	if err != nil {
+		run.err = err.Error()
+		run.failures++
+		log.Printf("JOB ERROR: %s failed (%d in a row): %v\n", j.name, run.failures, err)
+	} else {
+		run.err, run.failures = "", 0
+	}
+	h.jobs.mu.Unlock()
+	h.jobs.reschedule()
+}
+
+// RunJobs runs the enabled jobs on their schedules until the context is canceled, then waits for
+// the runs in progress
+func (h *Handler) RunJobs(ctx context.Context) {
+	s := h.jobs
+	s.mu.Lock()
+	s.started = time.Now()
+	s.mu.Unlock()
+	var running sync.WaitGroup
+	defer running.Wait()
+	for {
+		settings := h.runtimeSettings().Schedules
+		now := time.Now()
+		wait := maxJobBackoff
+		s.mu.Lock()
+		for _, j := range jobs {
+			if (j.enabled != nil && !j.enabled(h)) || (s.runs[j.name] != nil && s.runs[j.name].running) {
+				continue
+			}
+			_, schedule := jobSchedule(j, settings)
+			next := s.nextRun(j, schedule)
+			if next.IsZero() {
+				continue
+			}
+			if next.After(now) {
+				wait = min(wait, next.Sub(now))
+				continue
+			}
+			run, _ := s.startRun(j.name, now)
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				h.runJob(ctx, j, run)
+			}()
+		}
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// GetJobs lists the background jobs with their last and next runs
+func (h *Handler) GetJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	settings := h.runtimeSettings().Schedules
+	h.jobs.mu.Lock()
+	defer h.jobs.mu.Unlock()
+	statuses := make([]JobStatus, len(jobs))
+	for i, j := range jobs {
+		statuses[i] = h.jobs.status(h, j, settings)
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// RunJobNow runs the job in ?name= right away and returns its status after the run; a failed run
+// is reported in lastError, like a scheduled one
+func (h *Handler) RunJobNow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	name := r.URL.Query().Get("name")
+	j, ok := findJob(name)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("unknown job '%s'", name)})
+		return
+	}
+	if j.enabled != nil && !j.enabled(h) {
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: fmt.Sprintf("job '%s' isn't configured on this server", name)})
+		return
+	}
+	h.jobs.mu.Lock()
+	run, ok := h.jobs.startRun(name, time.Now())
+	h.jobs.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusConflict, ErrorResponse{Error: fmt.Sprintf("job '%s' is already running", name)})
+		return
+	}
+	// the run finishes even if the client goes away
+	h.runJob(context.WithoutCancel(r.Context()), j, run)
+	log.Printf("HTTP: Ran job %s on request\n", name)
+	settings := h.runtimeSettings().Schedules
+	h.jobs.mu.Lock()
+	defer h.jobs.mu.Unlock()
+	writeJSON(w, http.StatusOK, h.jobs.status(h, j, settings))
+}
+
+// unknownJob returns a name in the schedules that isn't a job
+func unknownJob(settings storage.ScheduleSettings) (string, bool) {
+	for name := range settings.Jobs {
+		if _, ok := findJob(name); !ok {
+			return name, true
+		}
+	}
+	return "", false
+}
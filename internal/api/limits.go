@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Request bodies are capped before they reach a handler, so a client can't make the server read
+// an arbitrarily large body, and writes are only accepted in the content types the API reads.
+
+const (
+	maxBodySize   = 1 << 20  // JSON and form bodies
+	maxUploadSize = 10 << 20 // files, like CSV imports, receipts, and inbound emails
+)
+
+// uploadTypes are the content types of file uploads, which get maxUploadSize; images are uploads too
+var uploadTypes = []string{"multipart/form-data", "application/octet-stream", "application/pdf"}
+
+// bodyTypes are the other content types accepted on writes
+var bodyTypes = []string{"application/json", "application/x-www-form-urlencoded"}
+
+// LimitRequests rejects request bodies above the limit of their content type with a 413, and
+// writes with a content type the API doesn't read with a 415. Bodies without a length are cut
+// off at the limit instead.
+func LimitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limit := int64(maxBodySize)
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			switch {
+			case err != nil:
+				writeJSON(w, http.StatusUnsupportedMediaType, ErrorResponse{Error: "Invalid Content-Type"})
+				return
+			case slices.Contains(uploadTypes, mediaType) || strings.HasPrefix(mediaType, "image/"):
+				limit = maxUploadSize
+			case slices.Contains(bodyTypes, mediaType) || strings.HasSuffix(mediaType, "+json"):
+			case r.Method != http.MethodGet && r.Method != http.MethodHead:
+				writeJSON(w, http.StatusUnsupportedMediaType, ErrorResponse{Error: fmt.Sprintf("Content-Type '%s' is not supported, send JSON", mediaType)})
+				return
+			}
+		}
+		if r.ContentLength > limit {
+			writeJSON(w, http.StatusRequestEntityTooLarge, ErrorResponse{Error: fmt.Sprintf("Request body is too large, the limit is %d MB", limit>>20)})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
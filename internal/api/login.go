@@ -0,0 +1,292 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/mailer"
+	"github.com/tanq16/expenseowl/internal/storage"
+	"github.com/tanq16/expenseowl/internal/web"
+)
+
+// Magic-link login (ACCESS_AUTH=magic-link) is the alternative to an authenticating proxy for
+// households that don't run one. ACCESS_ROLES then maps email addresses to roles; anyone listed
+// can ask for a one-time link by email, and opening it starts a session kept in a cookie.
+
+const (
+	authProxy     = "proxy"
+	authMagicLink = "magic-link"
+
+	sessionCookie     = "expenseowl_session"
+	loginLinkTTL      = 15 * time.Minute
+	defaultSessionTTL = 30 * 24 * time.Hour
+)
+
+// LoginRequest asks for a login link
+type LoginRequest struct {
+	Email string `json:"email"`
+}
+
+// VerifyRequest exchanges a login link's token for a session
+type VerifyRequest struct {
+	Token string `json:"token"`
+}
+
+// SessionResponse describes the signed-in user
+type SessionResponse struct {
+	User string `json:"user"`
+	Role string `json:"role"`
+}
+
+// magicLinks holds what the login routes need besides the users and roles
+type magicLinks struct {
+	tokens     storage.Storage
+	mailer     mailer.Config
+	publicURL  string        // for the links in emails, never the request's host, which the client picks; a tenant's subdomain
+	sessionTTL time.Duration // how long a browser stays signed in
+}
+
+// magicLinksFromEnv reads the SMTP settings and SESSION_DAYS for magic-link login, with publicURL
+// from PUBLIC_URL (or a tenant's own)
+func magicLinksFromEnv(publicURL string, tokens storage.Storage) (*magicLinks, error) {
+	mailerConfig := mailerConfigFromEnv()
+	if mailerConfig == nil {
+		return nil, fmt.Errorf("ACCESS_AUTH=magic-link needs SMTP_HOST to email login links")
+	}
+	if tokens == nil {
+		return nil, fmt.Errorf("ACCESS_AUTH=magic-link needs storage for login tokens")
+	}
+	// a link built from the Host header would send the token to whichever host the requester named
+	if publicURL == "" {
+		return nil, fmt.Errorf("ACCESS_AUTH=magic-link needs PUBLIC_URL for the links in emails")
+	}
+	if u, err := url.Parse(publicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid PUBLIC_URL '%s', expected e.g. https://budget.example.com", publicURL)
+	}
+	links := &magicLinks{
+		tokens:     tokens,
+		mailer:     *mailerConfig,
+		publicURL:  strings.TrimSuffix(publicURL, "/"),
+		sessionTTL: defaultSessionTTL,
+	}
+	if days := os.Getenv("SESSION_DAYS"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid SESSION_DAYS '%s', must be a number of days", days)
+		}
+		links.sessionTTL = time.Duration(n) * 24 * time.Hour
+	}
+	return links, nil
+}
+
+// hashLoginToken is how tokens are stored and looked up
+func hashLoginToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newLoginToken stores a new token with the details of loginToken and returns it; the store only keeps its hash
+func newLoginToken(tokens storage.Storage, loginToken storage.LoginToken, ttl time.Duration) (string, storage.LoginToken, error) {
+	token, err := newShareToken()
+	if err != nil {
+		return "", loginToken, fmt.Errorf("failed to generate token: %v", err)
+	}
+	now := time.Now().UTC()
+	loginToken.Hash = hashLoginToken(token)
+	loginToken.CreatedAt, loginToken.LastUsedAt, loginToken.ExpiresAt = now, now, now.Add(ttl)
+	if err := tokens.AddLoginToken(loginToken); err != nil {
+		return "", loginToken, err
+	}
+	return token, loginToken, nil
+}
+
+// startSession stores a session for the user with their current role and sets its cookie
+func (a *AccessControl) startSession(w http.ResponseWriter, r *http.Request, user string) error {
+	token, session, err := newLoginToken(a.magicLinks.tokens, storage.LoginToken{
+		Kind:   storage.LoginSession,
+		User:   user,
+		Role:   a.role(user).String(),
+		Device: r.UserAgent(),
+		IP:     clientIP(r),
+	}, a.magicLinks.sessionTTL)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// session returns the request's session, if its cookie is valid
+func (a *AccessControl) session(r *http.Request) (storage.LoginToken, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return storage.LoginToken{}, false
+	}
+	token, err := a.magicLinks.tokens.GetLoginToken(hashLoginToken(cookie.Value))
+	if err != nil || token.Kind != storage.LoginSession {
+		return storage.LoginToken{}, false
+	}
+	return token, true
+}
+
+// ServeLoginPage serves the sign-in form, and the button that uses a login link
+func (a *AccessControl) ServeLoginPage(w http.ResponseWriter, r *http.Request) {
+	if a.magicLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := web.ServeTemplate(w, "login.html", brandingOf(r, a.magicLinks.tokens)); err != nil {
+		log.Printf("HTTP ERROR: Failed to serve template: %v", err)
+		http.Error(w, "Failed to serve template", http.StatusInternalServerError)
+	}
+}
+
+// RequestLoginLink emails a login link to a known address; the response is the same for unknown
+// addresses, so the form doesn't reveal who has access
+func (a *AccessControl) RequestLoginLink(w http.ResponseWriter, r *http.Request) {
+	if a.magicLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	address, err := mail.ParseAddress(strings.TrimSpace(req.Email))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid email address"})
+		return
+	}
+	user := strings.ToLower(address.Address)
+	if a.role(user) == RolePublic {
+		log.Printf("HTTP: Login link requested for unknown address %s\n", user)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+		return
+	}
+	token, link, err := newLoginToken(a.magicLinks.tokens, storage.LoginToken{Kind: storage.LoginLink, User: user}, loginLinkTTL)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to create login link"})
+		log.Printf("API ERROR: Failed to create login link: %v\n", err)
+		return
+	}
+	loginURL := a.magicLinks.publicURL + "/login?token=" + token
+	body := fmt.Sprintf(`<p>Use this link to sign in to ExpenseOwl:</p><p><a href="%s">Sign in</a></p><p>The link works once and expires at %s. If you didn't ask for it, you can ignore this email.</p>`,
+		html.EscapeString(loginURL), link.ExpiresAt.Local().Format("15:04 MST"))
+	if err := mailer.Send(a.magicLinks.mailer, []string{user}, "Sign in to ExpenseOwl", body); err != nil {
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "Failed to send login link"})
+		log.Printf("API ERROR: Failed to send login link: %v\n", err)
+		return
+	}
+	log.Printf("Sent login link to %s\n", user)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// VerifyLoginLink uses up a login link and starts a session. It's a POST from the login page rather
+// than the link itself, so mail scanners that open links don't use them up.
+func (a *AccessControl) VerifyLoginLink(w http.ResponseWriter, r *http.Request) {
+	if a.magicLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var req VerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	hash := hashLoginToken(req.Token)
+	link, err := a.magicLinks.tokens.GetLoginToken(hash)
+	if err != nil || link.Kind != storage.LoginLink {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "This login link is invalid or has expired"})
+		return
+	}
+	// only the request that removes the link signs in, when it's used twice at once
+	removed, err := a.magicLinks.tokens.RemoveLoginToken(hash)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to use login link"})
+		log.Printf("API ERROR: Failed to remove login link: %v\n", err)
+		return
+	}
+	if !removed {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "This login link is invalid or has expired"})
+		return
+	}
+	if err := a.startSession(w, r, link.User); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to start session"})
+		log.Printf("API ERROR: Failed to start session: %v\n", err)
+		return
+	}
+	log.Printf("Signed in %s\n", link.User)
+	writeJSON(w, http.StatusOK, SessionResponse{User: link.User, Role: a.role(link.User).String()})
+}
+
+// Logout ends the request's session
+func (a *AccessControl) Logout(w http.ResponseWriter, r *http.Request) {
+	if a.magicLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if cookie, err := r.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		if _, err := a.magicLinks.tokens.RemoveLoginToken(hashLoginToken(cookie.Value)); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to end session"})
+			log.Printf("API ERROR: Failed to remove session: %v\n", err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// Session returns the signed-in user, or 401 without a session
+func (a *AccessControl) Session(w http.ResponseWriter, r *http.Request) {
+	if a.magicLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	session, ok := a.session(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Not authenticated"})
+		return
+	}
+	writeJSON(w, http.StatusOK, SessionResponse{User: session.User, Role: a.role(session.User).String()})
+}
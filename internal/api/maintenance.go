@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Maintenance finds the inconsistencies a long-lived instance builds up, like expenses left
+// pointing at a recurring rule that was deleted, and fixes them on request. Fixes keep the
+// expenses and only drop or repair the broken reference.
+
+// Maintenance tasks
+const (
+	TaskOrphanedRecurring    = "orphaned-recurring"    // instances of recurring rules that no longer exist
+	TaskUnknownSubcategories = "unknown-subcategories" // subcategories missing from the config
+	TaskInvalidCurrencies    = "invalid-currencies"    // currencies that aren't supported
+	TaskOrphanedRefunds      = "orphaned-refunds"      // refunds of expenses that no longer exist
+	TaskVacuum               = "vacuum"                // rebuild the aggregates and reclaim space
+)
+
+// maintenanceChecks are the tasks that look for inconsistencies, in the order they are reported
+var maintenanceChecks = []string{TaskOrphanedRecurring, TaskUnknownSubcategories, TaskInvalidCurrencies, TaskOrphanedRefunds}
+
+// maxMaintenanceIDs caps the expense IDs listed per check
+const maxMaintenanceIDs = 100
+
+// MaintenanceReport is the response of /api/admin/maintenance
+type MaintenanceReport struct {
+	Checks   []MaintenanceCheck `json:"checks"`
+	Vacuumed bool               `json:"vacuumed,omitempty"`
+}
+
+// MaintenanceCheck is what a task found, and fixed when it was run
+type MaintenanceCheck struct {
+	Task        string   `json:"task"`
+	Description string   `json:"description"`
+	Found       int      `json:"found"` // expenses affected
+	Fixed       int      `json:"fixed"`
+	Fix         string   `json:"fix"`               // what running the task does
+	IDs         []string `json:"ids"`               // of the expenses affected, at most 100
+	Details     []string `json:"details,omitempty"` // e.g., the missing subcategories
+}
+
+// maintenanceIssue is an expense a check found, with the change that fixes it
+type maintenanceIssue struct {
+	expense storage.Expense
+	detail  string
+	fix     func(expense *storage.Expense)
+}
+
+// findMaintenanceIssues runs the checks over the expenses
+func (h *Handler) findMaintenanceIssues(expenses []storage.Expense) (map[string][]maintenanceIssue, error) {
+	rules, err := h.storage.GetRecurringExpenses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recurring expenses: %v", err)
+	}
+	config, err := h.storage.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config: %v", err)
+	}
+	archived, err := h.storage.GetArchivedExpenses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archived expenses: %v", err)
+	}
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get currency: %v", err)
+	}
+
+	ruleIDs := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		ruleIDs[rule.ID] = true
+	}
+	expenseIDs := make(map[string]bool, len(expenses)+len(archived))
+	for _, expense := range slices.Concat(expenses, archived) {
+		expenseIDs[expense.ID] = true
+	}
+	issues := make(map[string][]maintenanceIssue)
+	for _, expense := range expenses {
+		if expense.RecurringID != "" && !ruleIDs[expense.RecurringID] {
+			issues[TaskOrphanedRecurring] = append(issues[TaskOrphanedRecurring], maintenanceIssue{expense: expense, fix: func(e *storage.Expense) {
+				e.RecurringID, e.Occurrence, e.Edited = "", "", false
+			}})
+		}
+		if expense.SubCategory != "" && !slices.Contains(config.SubCategories[expense.Category], expense.SubCategory) {
+			issues[TaskUnknownSubcategories] = append(issues[TaskUnknownSubcategories], maintenanceIssue{expense: expense, detail: expense.Category + " / " + expense.SubCategory})
+		}
+		if expense.Currency != "" {
+			if _, ok := storage.LookupCurrency(expense.Currency, config.CustomCurrencies); !ok {
+				issues[TaskInvalidCurrencies] = append(issues[TaskInvalidCurrencies], maintenanceIssue{expense: expense, detail: expense.Currency, fix: func(e *storage.Expense) {
+					e.Currency = currency
+				}})
+			}
+		}
+		if expense.IsRefund() && !expenseIDs[expense.RefundOf] {
+			issues[TaskOrphanedRefunds] = append(issues[TaskOrphanedRefunds], maintenanceIssue{expense: expense, fix: func(e *storage.Expense) {
+				e.RefundOf = ""
+			}})
+		}
+	}
+	return issues, nil
+}
+
+// maintenanceCheck describes what a task found
+func maintenanceCheck(task string, issues []maintenanceIssue) MaintenanceCheck {
+	check := MaintenanceCheck{Task: task, Found: len(issues), IDs: []string{}}
+	switch task {
+	case TaskOrphanedRecurring:
+		check.Description = "Expenses generated by a recurring expense that was deleted"
+		check.Fix = "Keep them as standalone expenses"
+	case TaskUnknownSubcategories:
+		check.Description = "Expenses with a subcategory that isn't configured for their category"
+		check.Fix = "Add the subcategories to the config"
+	case TaskInvalidCurrencies:
+		check.Description = "Expenses in a currency that isn't supported"
+		check.Fix = "Set them to the default currency"
+	case TaskOrphanedRefunds:
+		check.Description = "Refunds of an expense that was deleted"
+		check.Fix = "Unlink them, counting them as income"
+	}
+	for _, issue := range issues {
+		if len(check.IDs) < maxMaintenanceIDs {
+			check.IDs = append(check.IDs, issue.expense.ID)
+		}
+		if issue.detail != "" && !slices.Contains(check.Details, issue.detail) {
+			check.Details = append(check.Details, issue.detail)
+		}
+	}
+	return check
+}
+
+// fixMaintenanceIssues applies the fix of a task, returning how many expenses it fixed
+func (h *Handler) fixMaintenanceIssues(task string, issues []maintenanceIssue) (int, error) {
+	if task == TaskUnknownSubcategories {
+		added := make(map[string]bool)
+		for _, issue := range issues {
+			expense := issue.expense
+			if key := expense.Category + "\x00" + expense.SubCategory; !added[key] {
+				if err := h.storage.AddSubCategory(expense.Category, expense.SubCategory); err != nil {
+					return 0, err
+				}
+				added[key] = true
+			}
+		}
+		return len(issues), nil
+	}
+	for i, issue := range issues {
+		issue.fix(&issue.expense)
+		if err := h.storage.UpdateExpense(issue.expense.ID, issue.expense); err != nil {
+			return i, err
+		}
+	}
+	return len(issues), nil
+}
+
+// Maintenance reports the inconsistencies in the data (GET), or runs the tasks in {"tasks": [...]},
+// all of them when none are given, and reports what they fixed (POST)
+func (h *Handler) Maintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var tasks []string
+	if r.Method == http.MethodPost {
+		var payload struct {
+			Tasks []string `json:"tasks"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+			return
+		}
+		tasks = payload.Tasks
+		if len(tasks) == 0 {
+			tasks = append(slices.Clone(maintenanceChecks), TaskVacuum)
+		}
+		for _, task := range tasks {
+			if task != TaskVacuum && !slices.Contains(maintenanceChecks, task) {
+				writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("unknown task '%s'", task)})
+				return
+			}
+		}
+	}
+
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for maintenance: %v\n", err)
+		return
+	}
+	issues, err := h.findMaintenanceIssues(expenses)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to check the data"})
+		log.Printf("API ERROR: Failed to check the data for maintenance: %v\n", err)
+		return
+	}
+	report := MaintenanceReport{Checks: make([]MaintenanceCheck, 0, len(maintenanceChecks))}
+	for _, task := range maintenanceChecks {
+		check := maintenanceCheck(task, issues[task])
+		if slices.Contains(tasks, task) && check.Found > 0 {
+			if check.Fixed, err = h.fixMaintenanceIssues(task, issues[task]); err != nil {
+				writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: fmt.Sprintf("Failed to fix %s", task)})
+				log.Printf("API ERROR: Failed to fix %s after %d expenses: %v\n", task, check.Fixed, err)
+				return
+			}
+			log.Printf("HTTP: Maintenance fixed %d expenses for %s\n", check.Fixed, task)
+		}
+		report.Checks = append(report.Checks, check)
+	}
+	// vacuum last, so the aggregates are rebuilt with the fixes
+	if slices.Contains(tasks, TaskVacuum) {
+		if err := h.storage.Vacuum(); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to vacuum"})
+			log.Printf("API ERROR: Failed to vacuum: %v\n", err)
+			return
+		}
+		report.Vacuumed = true
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -1,18 +1,26 @@
 package api
 
 import (
+	"cmp"
 	"fmt"
+	"log"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/tanq16/expenseowl/internal/storage"
 )
 
-// MappingEngine applies subcategory mapping rules to transaction names
+// MappingEngine applies mapping rules to new expenses. Besides setting the category and
+// subcategory, a rule can rename the expense, add tags, and set the account.
 type MappingEngine struct {
-	rules []storage.SubCategoryMappingRule
+	rules   []storage.SubCategoryMappingRule
+	regexps map[int]*regexp.Regexp // compiled patterns of regex rules, by index in rules
 }
 
-// NewMappingEngine creates a new mapping engine with the provided rules
+// NewMappingEngine creates a new mapping engine with the provided rules.
+// Disabled rules are dropped and the rest are ordered by priority (highest first),
+// keeping the configured order for rules with the same priority.
 func NewMappingEngine(rules []storage.SubCategoryMappingRule) (*MappingEngine, error) {
 	// Validate rules
 	for i, rule := range rules {
@@ -20,48 +28,61 @@ func NewMappingEngine(rules []storage.SubCategoryMappingRule) (*MappingEngine, e
 			return nil, fmt.Errorf("invalid rule at index %d: %w", i, err)
 		}
 	}
-	
-	return &MappingEngine{
-		rules: rules,
-	}, nil
-}
 
-// ApplyMapping applies mapping rules to a transaction name and category
-// Returns the matched subcategory or empty string if no match
-// First matching rule wins (rule precedence)
-func (m *MappingEngine) ApplyMapping(transactionName string, category string) string {
-	for _, rule := range m.rules {
-		// Skip rules that don't match the category
-		if rule.Category != category {
-			continue
+	enabled := []storage.SubCategoryMappingRule{}
+	for _, rule := range rules {
+		if !rule.Disabled {
+			enabled = append(enabled, rule)
 		}
-		
-		// Apply pattern matching based on match type
-		if m.matchesPattern(transactionName, rule) {
-			return rule.SubCategory
+	}
+	slices.SortStableFunc(enabled, func(a, b storage.SubCategoryMappingRule) int {
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+
+	engine := &MappingEngine{rules: enabled, regexps: make(map[int]*regexp.Regexp)}
+	for i, rule := range enabled {
+		if rule.MatchType == "regex" {
+			engine.regexps[i] = regexp.MustCompile("(?i)" + rule.Pattern) // validated above
 		}
 	}
-	
-	return ""
+	return engine, nil
 }
 
-// ApplyMappingWithCategory applies mapping rules to a transaction name
-// Returns both the matched category and subcategory, or empty strings if no match
-// This is used when CSV doesn't have a category column
-// First matching rule wins (rule precedence)
-func (m *MappingEngine) ApplyMappingWithCategory(transactionName string) (string, string) {
-	for _, rule := range m.rules {
-		// Apply pattern matching based on match type
-		if m.matchesPattern(transactionName, rule) {
-			return rule.Category, rule.SubCategory
+// Apply runs the rules against an expense, matching on its original name.
+// The first matching rule wins for the category, the name, and the account, and only
+// fills them in if the expense doesn't already have one; tags from all matching rules are added.
+// A rule for a different category than the expense's doesn't set the subcategory.
+func (m *MappingEngine) Apply(expense *storage.Expense) {
+	name := expense.Name
+	renamed := false
+	for i, rule := range m.rules {
+		if !m.matchesPattern(name, i, rule) {
+			continue
+		}
+		if rule.Category != "" {
+			if expense.Category == "" {
+				expense.Category, expense.SubCategory = rule.Category, rule.SubCategory
+			} else if expense.Category == rule.Category && expense.SubCategory == "" {
+				expense.SubCategory = rule.SubCategory
+			}
+		}
+		if rule.Rename != "" && !renamed {
+			expense.Name = rule.Rename
+			renamed = true
+		}
+		if rule.Account != "" && expense.Account == "" {
+			expense.Account = rule.Account
+		}
+		for _, tag := range rule.Tags {
+			if !slices.Contains(expense.Tags, tag) {
+				expense.Tags = append(expense.Tags, tag)
+			}
 		}
 	}
-	
-	return "", ""
 }
 
 // matchesPattern checks if a transaction name matches a rule's pattern
-func (m *MappingEngine) matchesPattern(transactionName string, rule storage.SubCategoryMappingRule) bool {
+func (m *MappingEngine) matchesPattern(transactionName string, index int, rule storage.SubCategoryMappingRule) bool {
 	switch rule.MatchType {
 	case "exact":
 		return rule.Pattern == transactionName
@@ -70,6 +91,8 @@ func (m *MappingEngine) matchesPattern(transactionName string, rule storage.SubC
 			strings.ToLower(transactionName),
 			strings.ToLower(rule.Pattern),
 		)
+	case "regex":
+		return m.regexps[index].MatchString(transactionName)
 	default:
 		return false
 	}
@@ -78,22 +101,60 @@ func (m *MappingEngine) matchesPattern(transactionName string, rule storage.SubC
 // validateMappingRule validates a single mapping rule
 func validateMappingRule(rule storage.SubCategoryMappingRule) error {
 	if rule.Pattern == "" {
-		return fmt.Errorf("mapping rule missing required field: pattern")
+		return fmt.Errorf("pattern is required")
 	}
 	if rule.MatchType == "" {
-		return fmt.Errorf("mapping rule missing required field: matchType")
+		return fmt.Errorf("matchType is required")
+	}
+
+	// Validate match type
+	switch rule.MatchType {
+	case "exact", "contains":
+	case "regex":
+		if _, err := regexp.Compile("(?i)" + rule.Pattern); err != nil {
+			return fmt.Errorf("invalid regex pattern '%s': %v", rule.Pattern, err)
+		}
+	default:
+		return fmt.Errorf("invalid match type '%s', must be 'exact', 'contains', or 'regex'", rule.MatchType)
 	}
-	if rule.Category == "" {
-		return fmt.Errorf("mapping rule missing required field: category")
+
+	if rule.SubCategory != "" && rule.Category == "" {
+		return fmt.Errorf("category is required when subCategory is set")
 	}
-	if rule.SubCategory == "" {
-		return fmt.Errorf("mapping rule missing required field: subCategory")
+	for _, tag := range rule.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return fmt.Errorf("tags must not be empty")
+		}
 	}
-	
-	// Validate match type
-	if rule.MatchType != "exact" && rule.MatchType != "contains" {
-		return fmt.Errorf("invalid match type '%s', must be 'exact' or 'contains'", rule.MatchType)
+	if rule.Category == "" && rule.Rename == "" && rule.Account == "" && len(rule.Tags) == 0 {
+		return fmt.Errorf("rule must set at least one of category, rename, tags, or account")
 	}
-	
 	return nil
 }
+
+// applyRules runs the configured mapping rules against a new expense
+func (h *Handler) applyRules(expense *storage.Expense) {
+	rules, err := h.storage.GetSubCategoryMappings()
+	if err != nil {
+		log.Printf("Warning: Could not retrieve mapping rules: %v\n", err)
+		return
+	}
+	engine, err := NewMappingEngine(rules)
+	if err != nil {
+		log.Printf("Warning: Skipping invalid mapping rules: %v\n", err)
+		return
+	}
+	engine.Apply(expense)
+}
+
+// resolveCategory applies the mapping rules to an expense and falls back to
+// Miscellaneous (or the first category) when no category was set or matched
+func (h *Handler) resolveCategory(expense *storage.Expense, categories []string) {
+	h.applyRules(expense)
+	if expense.Category == "" {
+		expense.Category = "Miscellaneous"
+		if !slices.Contains(categories, expense.Category) && len(categories) > 0 {
+			expense.Category = categories[0]
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/analytics"
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Merchants group expenses by normalized name, e.g., "STARBUCKS #1234" and "Starbucks 0042" are both
+// "starbucks". Aliases merge merchants that normalize differently, e.g., "AMZN Mktp US" into "Amazon".
+
+// MerchantSummary is the spending at one merchant
+type MerchantSummary struct {
+	Name           string   `json:"name"`             // merged name, or the most common expense name
+	Names          []string `json:"names"`            // expense names grouped under this merchant
+	Category       string   `json:"category"`         // most common category
+	Spent          float64  `json:"spent"`            // absolute value
+	Visits         int      `json:"visits"`           // number of expenses
+	AverageTicket  float64  `json:"average_ticket"`   // spent / visits
+	VisitsPerMonth float64  `json:"visits_per_month"` // visits over the length of the range
+	LastVisit      string   `json:"last_visit"`       // YYYY-MM-DD
+}
+
+// MerchantReport is the response of /api/reports/merchants
+type MerchantReport struct {
+	From       string            `json:"from"` // YYYY-MM-DD
+	To         string            `json:"to"`   // YYYY-MM-DD (inclusive)
+	Currency   string            `json:"currency"`
+	TotalSpent float64           `json:"total_spent"`
+	Merchants  []MerchantSummary `json:"merchants"`
+}
+
+// MergeMerchantsRequest merges merchants into one, e.g., {"merchants": ["AMZN Mktp US", "Amazon.com"], "into": "Amazon"}
+type MergeMerchantsRequest struct {
+	Merchants []string `json:"merchants"`
+	Into      string   `json:"into"`
+}
+
+var merchantSorts = map[string]func(a, b MerchantSummary) int{
+	"spent":   func(a, b MerchantSummary) int { return cmp.Compare(b.Spent, a.Spent) },
+	"visits":  func(a, b MerchantSummary) int { return cmp.Compare(b.Visits, a.Visits) },
+	"average": func(a, b MerchantSummary) int { return cmp.Compare(b.AverageTicket, a.AverageTicket) },
+}
+
+// dateRange picks a report range from ?period=current|previous|year|all or ?from=&to= (YYYY-MM-DD, inclusive).
+// The year is the fiscal year to date.
+// The end is exclusive; a zero start means all time.
+func (h *Handler) dateRange(query url.Values) (time.Time, time.Time, error) {
+	now := time.Now()
+	var start time.Time
+	end := now
+	if query.Get("from") != "" || query.Get("to") != "" {
+		var err error
+		if raw := query.Get("from"); raw != "" {
+			if start, err = time.ParseInLocation("2006-01-02", raw, time.Local); err != nil {
+				return start, end, fmt.Errorf("invalid 'from': must be YYYY-MM-DD")
+			}
+		}
+		if raw := query.Get("to"); raw != "" {
+			to, err := time.ParseInLocation("2006-01-02", raw, time.Local)
+			if err != nil {
+				return start, end, fmt.Errorf("invalid 'to': must be YYYY-MM-DD")
+			}
+			end = to.AddDate(0, 0, 1)
+		}
+		if !start.IsZero() && !end.After(start) {
+			return start, end, fmt.Errorf("'to' must not be before 'from'")
+		}
+		return start, end, nil
+	}
+	periodConfig := h.periodConfig()
+	switch query.Get("period") {
+	case "", "all":
+	case "current":
+		start = periodConfig.Current(now).Start
+	case "previous":
+		period := periodConfig.Previous(periodConfig.Current(now))
+		start, end = period.Start, period.End
+	case "year":
+		start = periods.FiscalYear(now, h.fiscalYearStart()).Start
+	default:
+		return start, end, fmt.Errorf("invalid 'period': must be current, previous, year, or all")
+	}
+	return start, end, nil
+}
+
+// mostCommon returns the key with the highest count, alphabetically first on ties
+func mostCommon(counts map[string]int) string {
+	best := ""
+	for key, count := range counts {
+		if best == "" || count > counts[best] || (count == counts[best] && key < best) {
+			best = key
+		}
+	}
+	return best
+}
+
+// summarizeMerchants groups the expenses in [start, end) by merchant. A zero start begins at
+// the first expense; the start that was used is returned with the summaries.
+func summarizeMerchants(expenses []storage.Expense, aliases map[string]string, start time.Time, end time.Time) ([]MerchantSummary, time.Time) {
+	merged := make(map[string]string) // merchant key -> name it was merged into
+	for _, name := range aliases {
+		merged[storage.NormalizeMerchant(name)] = name
+	}
+	type merchant struct {
+		names      map[string]int
+		categories map[string]int
+		spent      float64
+		visits     int
+		last       time.Time
+	}
+	merchants := make(map[string]*merchant)
+	merchantKey := func(name string) string {
+		key := storage.NormalizeMerchant(name)
+		if name, ok := aliases[key]; ok {
+			key = storage.NormalizeMerchant(name)
+		}
+		return key
+	}
+	first := start
+	for _, expense := range expenses {
+		if expense.Amount >= 0 || expense.Date.Before(start) || !expense.Date.Before(end) {
+			continue
+		}
+		if first.IsZero() || expense.Date.Before(first) {
+			first = expense.Date
+		}
+		key := merchantKey(expense.Name)
+		m, ok := merchants[key]
+		if !ok {
+			m = &merchant{names: make(map[string]int), categories: make(map[string]int)}
+			merchants[key] = m
+		}
+		m.names[strings.TrimSpace(expense.Name)]++
+		m.categories[expense.Category]++
+		m.spent += -expense.Amount
+		m.visits++
+		if expense.Date.After(m.last) {
+			m.last = expense.Date
+		}
+	}
+
+	// refunds take money back off the merchant of the expense they refund
+	expenseNames := make(map[string]string) // expense ID -> name
+	for _, expense := range expenses {
+		expenseNames[expense.ID] = expense.Name
+	}
+	for _, expense := range expenses {
+		if !expense.IsRefund() || expense.Date.Before(start) || !expense.Date.Before(end) {
+			continue
+		}
+		name, ok := expenseNames[expense.RefundOf]
+		if !ok {
+			name = expense.Name
+		}
+		if m, ok := merchants[merchantKey(name)]; ok {
+			m.spent -= expense.Amount
+		}
+	}
+	months := end.Sub(first).Hours() / 24 / 30.44
+	if months < 1.0/30.44 {
+		months = 1.0 / 30.44 // at least a day
+	}
+	summaries := make([]MerchantSummary, 0, len(merchants))
+	for key, m := range merchants {
+		names := make([]string, 0, len(m.names))
+		for name := range m.names {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		name := merged[key]
+		if name == "" {
+			name = mostCommon(m.names)
+		}
+		summaries = append(summaries, MerchantSummary{
+			Name:           name,
+			Names:          names,
+			Category:       mostCommon(m.categories),
+			Spent:          math.Round(m.spent*100) / 100,
+			Visits:         m.visits,
+			AverageTicket:  math.Round(m.spent/float64(m.visits)*100) / 100,
+			VisitsPerMonth: math.Round(float64(m.visits)/months*100) / 100,
+			LastVisit:      m.last.Format("2006-01-02"),
+		})
+	}
+	slices.SortFunc(summaries, func(a, b MerchantSummary) int {
+		return cmp.Or(merchantSorts["spent"](a, b), cmp.Compare(a.Name, b.Name))
+	})
+	return summaries, first
+}
+
+// GetMerchantReport returns spending per merchant with visit frequency and average ticket; the
+// search filters of /expenses narrow it down, e.g., ?class=business
+func (h *Handler) GetMerchantReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	query := r.URL.Query()
+	start, end, err := h.dateRange(query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	sortBy := query.Get("sort")
+	if sortBy == "" {
+		sortBy = "spent"
+	}
+	sortFunc, ok := merchantSorts[sortBy]
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'sort': must be spent, visits, or average"})
+		return
+	}
+	limit := 0
+	if raw := query.Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'limit': must be a positive number"})
+			return
+		}
+	}
+	filter, err := expenseFilter(query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	expenses, err := h.storage.FindExpenses(filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for merchant report: %v\n", err)
+		return
+	}
+	expenses = h.reportable(expenses)
+	aliases, err := h.storage.GetMerchantAliases()
+	if err != nil {
+		log.Printf("Warning: Could not retrieve merchant aliases: %v\n", err)
+		aliases = map[string]string{}
+	}
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+
+	merchants, first := summarizeMerchants(expenses, aliases, start, end)
+	report := MerchantReport{Currency: currency, To: end.AddDate(0, 0, -1).Format("2006-01-02")}
+	if !first.IsZero() {
+		report.From = first.Format("2006-01-02")
+	}
+	for _, m := range merchants {
+		report.TotalSpent += m.Spent
+	}
+	report.TotalSpent = math.Round(report.TotalSpent*100) / 100
+	if limit == 0 {
+		limit = -1 // all
+	}
+	report.Merchants = analytics.TopN(merchants, limit, func(a, b MerchantSummary) int {
+		return cmp.Or(sortFunc(a, b), cmp.Compare(a.Name, b.Name))
+	})
+	writeJSON(w, http.StatusOK, report)
+	log.Printf("HTTP: Served merchant report (%d merchants)\n", len(report.Merchants))
+}
+
+func (h *Handler) GetMerchantAliases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	aliases, err := h.storage.GetMerchantAliases()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get merchant aliases"})
+		log.Printf("API ERROR: Failed to get merchant aliases: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, aliases)
+}
+
+func (h *Handler) UpdateMerchantAliases(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var aliases map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&aliases); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if _, err := storage.ValidateMerchantAliases(aliases); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.UpdateMerchantAliases(aliases); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update merchant aliases"})
+		log.Printf("API ERROR: Failed to update merchant aliases: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// MergeMerchants records the given merchants as aliases of another merchant
+func (h *Handler) MergeMerchants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var req MergeMerchantsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	into := storage.SanitizeString(req.Into)
+	if into == "" || len(req.Merchants) == 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "'merchants' and 'into' are required"})
+		return
+	}
+	aliases, err := h.storage.GetMerchantAliases()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get merchant aliases"})
+		log.Printf("API ERROR: Failed to get merchant aliases: %v\n", err)
+		return
+	}
+	updated := make(map[string]string, len(aliases)+len(req.Merchants))
+	for alias, merchant := range aliases {
+		updated[alias] = merchant
+	}
+	// the target stops being an alias itself, so merging back and forth doesn't loop
+	delete(updated, storage.NormalizeMerchant(into))
+	for _, merchant := range req.Merchants {
+		if storage.NormalizeMerchant(merchant) == "" {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "merchant names cannot be empty"})
+			return
+		}
+		updated[storage.NormalizeMerchant(merchant)] = into
+	}
+	if _, err := storage.ValidateMerchantAliases(updated); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.UpdateMerchantAliases(updated); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to merge merchants"})
+		log.Printf("API ERROR: Failed to merge merchants: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	log.Printf("HTTP: Merged %d merchants into %s\n", len(req.Merchants), into)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"strings"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Expense metadata lets sync tools (a bank connector, a Splitwise bridge) remember which external
+// record an expense belongs to. Each tool writes its own namespace, so tools don't clobber each
+// other, and edits from the UI leave the metadata alone.
+
+// ExpenseMetadata reads or changes the metadata of an expense at /api/expenses/{id}/metadata:
+// GET returns it, PUT replaces the namespaces in the body (an empty one removes it), and DELETE
+// removes the namespace in ?namespace=
+func (h *Handler) ExpenseMetadata(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/expenses/"), "/metadata")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Not found"})
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	expense, err := h.storage.GetExpense(id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Expense not found", Code: CodeExpenseNotFound})
+		return
+	}
+	metadata := maps.Clone(expense.Metadata)
+	if metadata == nil {
+		metadata = storage.Metadata{}
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, metadata)
+		return
+	case http.MethodPut:
+		var changes storage.Metadata
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+			return
+		}
+		maps.Copy(metadata, changes)
+	case http.MethodDelete:
+		namespace := r.URL.Query().Get("namespace")
+		if _, ok := metadata[namespace]; !ok {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Metadata namespace not found"})
+			return
+		}
+		delete(metadata, namespace)
+	}
+	if err := metadata.Validate(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	expense.Metadata = metadata
+	// a recurring instance with metadata is kept as is when its rule is edited, instead of regenerated
+	if expense.RecurringID != "" {
+		expense.Edited = true
+	}
+	if err := h.storage.UpdateExpense(id, expense); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update expense"})
+		log.Printf("API ERROR: Failed to update metadata of expense %s: %v\n", id, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, metadata)
+}
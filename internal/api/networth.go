@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// NetWorthReport is the response of /api/reports/networth
+type NetWorthReport struct {
+	Currency string          `json:"currency"`
+	Points   []NetWorthPoint `json:"points"` // oldest first
+}
+
+// NetWorthPoint is the balance of every account and the value of the holdings at the end of a budget period
+type NetWorthPoint struct {
+	Date        string             `json:"date"`     // last day of the period, today for the current one
+	Assets      float64            `json:"assets"`   // asset accounts and holdings
+	Holdings    float64            `json:"holdings"` // value of the holdings at their latest price
+	Liabilities float64            `json:"liabilities"`
+	NetWorth    float64            `json:"netWorth"` // assets minus liabilities
+	Accounts    map[string]float64 `json:"accounts"` // account -> balance, for accounts with one by then
+}
+
+func (h *Handler) GetAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	accounts, err := h.storage.GetAccounts()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get accounts"})
+		log.Printf("API ERROR: Failed to get accounts: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, accounts)
+}
+
+func (h *Handler) UpdateAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var accounts []storage.Account
+	if err := json.NewDecoder(r.Body).Decode(&accounts); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if err := storage.ValidateAccounts(accounts); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.UpdateAccounts(accounts); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update accounts"})
+		log.Printf("API ERROR: Failed to update accounts: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// GetNetWorthReport returns the net worth at the end of each of the last ?months= budget periods (default 12)
+func (h *Handler) GetNetWorthReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	months := 12
+	if value := r.URL.Query().Get("months"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 120 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'months': must be between 1 and 120"})
+			return
+		}
+		months = parsed
+	}
+	accounts, err := h.storage.GetAccounts()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get accounts"})
+		log.Printf("API ERROR: Failed to get accounts: %v\n", err)
+		return
+	}
+	holdings, err := h.storage.GetHoldings()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get holdings"})
+		log.Printf("API ERROR: Failed to get holdings: %v\n", err)
+		return
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for net worth: %v\n", err)
+		return
+	}
+	report := NetWorthReport{Points: make([]NetWorthPoint, 0, months)}
+	report.Currency, err = h.storage.GetCurrency()
+	if err != nil {
+		report.Currency = "usd"
+	}
+	now := time.Now()
+	today := now.Format("2006-01-02")
+	for _, period := range h.periodConfig().Last(months, now) {
+		date := period.End.AddDate(0, 0, -1).Format("2006-01-02")
+		if date > today {
+			date = today
+		}
+		report.Points = append(report.Points, netWorthAt(accounts, holdings, expenses, date))
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// netWorthAt adds up the account balances and holding values at the end of date (YYYY-MM-DD)
+func netWorthAt(accounts []storage.Account, holdings []storage.Holding, expenses []storage.Expense, date string) NetWorthPoint {
+	point := NetWorthPoint{Date: date, Accounts: make(map[string]float64)}
+	for _, account := range accounts {
+		balance, ok := accountBalance(account, expenses, date)
+		if !ok {
+			continue
+		}
+		point.Accounts[account.Name] = balance
+		if account.Type == storage.AccountLiability {
+			point.Liabilities += balance
+		} else {
+			point.Assets += balance
+		}
+	}
+	for _, holding := range holdings {
+		if quote, ok := latestPrice(holding, date); ok {
+			point.Holdings += holding.Quantity * quote.Price
+		}
+	}
+	point.Assets += point.Holdings
+	point.NetWorth = point.Assets - point.Liabilities
+	return point
+}
+
+// accountBalance is the latest snapshot on or before date, moved by the account's transactions after
+// it for derived accounts; false when the account has no balance yet
+func accountBalance(account storage.Account, expenses []storage.Expense, date string) (float64, bool) {
+	var balance float64
+	since := ""
+	for _, snapshot := range account.Snapshots {
+		if snapshot.Date > date {
+			break
+		}
+		balance, since = snapshot.Balance, snapshot.Date
+	}
+	if !account.Derived {
+		return balance, since != ""
+	}
+	for _, expense := range expenses {
+		day := expense.Date.Format("2006-01-02")
+		if !strings.EqualFold(expense.Account, account.Name) || day <= since || day > date {
+			continue
+		}
+		// spending lowers an asset and adds to what's owed on a liability
+		if account.Type == storage.AccountLiability {
+			balance -= expense.Amount
+		} else {
+			balance += expense.Amount
+		}
+	}
+	return balance, true
+}
@@ -0,0 +1,466 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/plaid"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Bank sync through Plaid, for US users who bring their own Plaid credentials. It stays off
+// unless PLAID_CLIENT_ID and PLAID_SECRET are set. New transactions go through the importer, so
+// the mapping rules pick their categories; the Plaid transaction ID is kept in the expense
+// metadata, which is how later changes and removals find the expense again.
+
+const plaidMetadata = "plaid" // metadata namespace of synced expenses
+
+// plaidConnector holds the Plaid client and serializes syncs, so a webhook arriving during a
+// manual sync doesn't import the same page twice
+type plaidConnector struct {
+	client       *plaid.Client
+	webhookToken string // required by the webhook, which is disabled when empty
+	webhookURL   string // passed to Plaid when linking, empty without PUBLIC_URL and a token
+	mu           sync.Mutex
+}
+
+// plaidConnectorFromEnv returns the Plaid connector, or nil when bank sync is disabled
+func plaidConnectorFromEnv() *plaidConnector {
+	clientID, secret := os.Getenv("PLAID_CLIENT_ID"), os.Getenv("PLAID_SECRET")
+	if clientID == "" || secret == "" {
+		return nil
+	}
+	client, err := plaid.New(clientID, secret, os.Getenv("PLAID_ENV"))
+	if err != nil {
+		log.Printf("%v, bank sync is disabled\n", err)
+		return nil
+	}
+	connector := &plaidConnector{client: client, webhookToken: os.Getenv("PLAID_WEBHOOK_TOKEN")}
+	if publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/"); publicURL != "" && connector.webhookToken != "" {
+		connector.webhookURL = publicURL + "/api/plaid/webhook?token=" + connector.webhookToken
+	}
+	return connector
+}
+
+// PlaidItemResponse describes a connected bank without its access token
+type PlaidItemResponse struct {
+	ID          string `json:"id"`
+	Institution string `json:"institution"`
+	LastSync    string `json:"lastSync,omitempty"`
+	LastError   string `json:"lastError,omitempty"`
+}
+
+// PlaidItemsResponse is the response of /api/plaid/items
+type PlaidItemsResponse struct {
+	Enabled bool                `json:"enabled"` // whether Plaid credentials are configured
+	Items   []PlaidItemResponse `json:"items"`
+}
+
+// PlaidExchangeRequest is what Plaid Link hands the page after a bank is connected
+type PlaidExchangeRequest struct {
+	PublicToken string `json:"publicToken"`
+	Institution string `json:"institution"`
+}
+
+// PlaidSyncResult is the outcome of syncing one item
+type PlaidSyncResult struct {
+	Item     string        `json:"item"`
+	Added    int           `json:"added"`
+	Modified int           `json:"modified"`
+	Removed  int           `json:"removed"`
+	Import   *ImportResult `json:"import,omitempty"` // what happened to the added transactions
+	Error    string        `json:"error,omitempty"`
+}
+
+// plaidWebhook is the part of a Plaid webhook the sync needs
+type plaidWebhook struct {
+	Type   string `json:"webhook_type"`
+	Code   string `json:"webhook_code"`
+	ItemID string `json:"item_id"`
+}
+
+// requirePlaid writes an error when bank sync is disabled
+func (h *Handler) requirePlaid(w http.ResponseWriter) bool {
+	if h.plaid == nil {
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Error: "Bank sync is not configured, set PLAID_CLIENT_ID and PLAID_SECRET"})
+		return false
+	}
+	return true
+}
+
+// plaidExpenseIndex maps Plaid transaction IDs to the expenses synced from them
+func (h *Handler) plaidExpenseIndex() (map[string]storage.Expense, error) {
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]storage.Expense)
+	for _, expense := range expenses {
+		if id := expense.Metadata[plaidMetadata]["transaction_id"]; id != "" {
+			index[id] = expense
+		}
+	}
+	return index, nil
+}
+
+// plaidRow turns a posted Plaid transaction into an import row; Plaid amounts are positive for
+// money leaving the account, the opposite of expenses here
+func plaidRow(line int, txn plaid.Transaction, institution string) (importRow, error) {
+	date, err := time.Parse("2006-01-02", txn.Date)
+	if err != nil {
+		return importRow{}, fmt.Errorf("unable to parse date: %s", txn.Date)
+	}
+	metadata := map[string]string{"transaction_id": txn.ID, "account_id": txn.AccountID}
+	if txn.Category.Primary != "" {
+		metadata["category"] = txn.Category.Primary
+	}
+	row := importRow{
+		Line:     line,
+		Name:     cmp.Or(txn.MerchantName, txn.Name),
+		Amount:   -txn.Amount,
+		Currency: txn.Currency,
+		Date:     date,
+		Metadata: storage.Metadata{plaidMetadata: metadata},
+	}
+	if institution != "" {
+		row.Tags = []string{institution}
+	}
+	return row, nil
+}
+
+// syncPlaidItem pulls the changes since the item's cursor and applies them: new transactions are
+// imported, changed ones update the amount and date of their expense (keeping edits such as the
+// category), and removed ones delete it. Pending transactions are skipped until they post.
+func (h *Handler) syncPlaidItem(ctx context.Context, item storage.PlaidItem) PlaidSyncResult {
+	h.plaid.mu.Lock()
+	defer h.plaid.mu.Unlock()
+	result := PlaidSyncResult{Item: item.ID}
+	// the cursor is re-read, another sync may have moved it while this one waited
+	if items, err := h.storage.GetPlaidItems(); err == nil {
+		if i := slices.IndexFunc(items, func(i storage.PlaidItem) bool { return i.ID == item.ID }); i >= 0 {
+			item = items[i]
+		}
+	}
+
+	var added, modified []plaid.Transaction
+	var removed []string
+	cursor := item.Cursor
+	for restarts := 0; ; {
+		page, err := h.plaid.client.SyncTransactions(ctx, item.AccessToken, cursor)
+		var plaidErr *plaid.Error
+		if errors.As(err, &plaidErr) && plaidErr.Code == "TRANSACTIONS_SYNC_MUTATION_DURING_PAGINATION" && restarts < 3 {
+			// the pages fetched so far are stale, start over from the stored cursor
+			restarts++
+			added, modified, removed, cursor = nil, nil, nil, item.Cursor
+			continue
+		}
+		if err != nil {
+			result.Error = err.Error()
+			h.finishPlaidSync(item.ID, "", err)
+			return result
+		}
+		added = append(added, page.Added...)
+		modified = append(modified, page.Modified...)
+		for _, r := range page.Removed {
+			removed = append(removed, r.ID)
+		}
+		cursor = page.NextCursor
+		if !page.HasMore {
+			break
+		}
+	}
+
+	existing, err := h.plaidExpenseIndex()
+	if err == nil {
+		err = h.applyPlaidChanges(&result, item, existing, added, modified, removed)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		h.finishPlaidSync(item.ID, "", err)
+		return result
+	}
+	h.finishPlaidSync(item.ID, cursor, nil)
+	log.Printf("Plaid: Synced item %s: %d added, %d modified, %d removed\n", item.ID, result.Added, result.Modified, result.Removed)
+	return result
+}
+
+func (h *Handler) applyPlaidChanges(result *PlaidSyncResult, item storage.PlaidItem, existing map[string]storage.Expense, added, modified []plaid.Transaction, removed []string) error {
+	im, err := h.newImporter(false, "Plaid", item.Institution)
+	if err != nil {
+		return fmt.Errorf("could not prepare import: %v", err)
+	}
+	im.unit = "transaction"
+	im.keepDuplicates = true
+	// a transaction that is already synced is updated instead, in case a page was applied before
+	for i, txn := range append(added, modified...) {
+		if txn.Pending {
+			continue
+		}
+		row, err := plaidRow(i+1, txn, item.Institution)
+		if err != nil {
+			im.skip(i+1, "%v", err)
+			continue
+		}
+		expense, ok := existing[txn.ID]
+		if !ok {
+			im.add(row)
+			continue
+		}
+		expense.Amount = math.Round(row.Amount*100) / 100
+		expense.Date = row.Date
+		if err := h.storage.UpdateExpense(expense.ID, expense); err != nil {
+			return fmt.Errorf("failed to update expense %s: %v", expense.ID, err)
+		}
+		result.Modified++
+	}
+	for _, id := range removed {
+		expense, ok := existing[id]
+		if !ok {
+			continue // pending when it was synced, or never synced
+		}
+		if err := h.storage.RemoveExpense(expense.ID); err != nil {
+			return fmt.Errorf("failed to remove expense %s: %v", expense.ID, err)
+		}
+		result.Removed++
+	}
+	result.Import = im.finish()
+	result.Added = result.Import.Imported
+	return nil
+}
+
+// syncPlaidItems syncs every connected bank, as the bank-sync job
+func (h *Handler) syncPlaidItems(ctx context.Context) error {
+	items, err := h.storage.GetPlaidItems()
+	if err != nil {
+		return fmt.Errorf("failed to get bank connections: %v", err)
+	}
+	var failed []string
+	for _, item := range items {
+		if result := h.syncPlaidItem(ctx, item); result.Error != "" {
+			failed = append(failed, cmp.Or(item.Institution, item.ID))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to sync %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// finishPlaidSync stores the new cursor after a successful sync, or the error of a failed one
+func (h *Handler) finishPlaidSync(id string, cursor string, syncErr error) {
+	items, err := h.storage.GetPlaidItems()
+	if err != nil {
+		log.Printf("Plaid ERROR: Failed to get items: %v\n", err)
+		return
+	}
+	i := slices.IndexFunc(items, func(item storage.PlaidItem) bool { return item.ID == id })
+	if i < 0 {
+		return // removed during the sync
+	}
+	if syncErr != nil {
+		items[i].LastError = syncErr.Error()
+		log.Printf("Plaid ERROR: Failed to sync item %s: %v\n", id, syncErr)
+	} else {
+		items[i].Cursor = cursor
+		items[i].LastSync = time.Now().UTC().Format(time.RFC3339)
+		items[i].LastError = ""
+	}
+	if err := h.storage.UpdatePlaidItems(items); err != nil {
+		log.Printf("Plaid ERROR: Failed to save item %s: %v\n", id, err)
+	}
+}
+
+// GetPlaidItems lists the connected banks; enabled tells the settings page whether to offer linking
+func (h *Handler) GetPlaidItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	items, err := h.storage.GetPlaidItems()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get bank connections"})
+		log.Printf("API ERROR: Failed to get plaid items: %v\n", err)
+		return
+	}
+	response := PlaidItemsResponse{Enabled: h.plaid != nil, Items: make([]PlaidItemResponse, len(items))}
+	for i, item := range items {
+		response.Items[i] = PlaidItemResponse{ID: item.ID, Institution: item.Institution, LastSync: item.LastSync, LastError: item.LastError}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// CreatePlaidLinkToken starts a Plaid Link session in the settings page
+func (h *Handler) CreatePlaidLinkToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if !h.requirePlaid(w) {
+		return
+	}
+	token, err := h.plaid.client.CreateLinkToken(r.Context(), cmp.Or(requestUser(r), "expenseowl"), h.plaid.webhookURL)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "Failed to start bank linking"})
+		log.Printf("API ERROR: Failed to create plaid link token: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"linkToken": token})
+}
+
+// ExchangePlaidToken stores a newly linked bank and runs its first sync
+func (h *Handler) ExchangePlaidToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if !h.requirePlaid(w) {
+		return
+	}
+	var req PlaidExchangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PublicToken == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	accessToken, itemID, err := h.plaid.client.ExchangePublicToken(r.Context(), req.PublicToken)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "Failed to connect the bank"})
+		log.Printf("API ERROR: Failed to exchange plaid public token: %v\n", err)
+		return
+	}
+	items, err := h.storage.GetPlaidItems()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get bank connections"})
+		log.Printf("API ERROR: Failed to get plaid items: %v\n", err)
+		return
+	}
+	item := storage.PlaidItem{ID: itemID, Institution: storage.SanitizeString(req.Institution), AccessToken: accessToken}
+	// linking the same bank again replaces the token and keeps the cursor
+	if i := slices.IndexFunc(items, func(existing storage.PlaidItem) bool { return existing.ID == itemID }); i >= 0 {
+		item.Cursor = items[i].Cursor
+		items[i] = item
+	} else {
+		items = append(items, item)
+	}
+	if err := h.storage.UpdatePlaidItems(items); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save bank connection"})
+		log.Printf("API ERROR: Failed to save plaid item: %v\n", err)
+		return
+	}
+	log.Printf("HTTP: Connected bank %s through Plaid\n", itemID)
+	writeJSON(w, http.StatusOK, h.syncPlaidItem(r.Context(), item))
+}
+
+// SyncPlaid syncs the bank in ?item=, or every connected bank
+func (h *Handler) SyncPlaid(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if !h.requirePlaid(w) {
+		return
+	}
+	items, err := h.storage.GetPlaidItems()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get bank connections"})
+		log.Printf("API ERROR: Failed to get plaid items: %v\n", err)
+		return
+	}
+	if id := r.URL.Query().Get("item"); id != "" {
+		items = slices.DeleteFunc(items, func(item storage.PlaidItem) bool { return item.ID != id })
+		if len(items) == 0 {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Bank connection not found"})
+			return
+		}
+	}
+	results := make([]PlaidSyncResult, 0, len(items))
+	for _, item := range items {
+		results = append(results, h.syncPlaidItem(r.Context(), item))
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// RemovePlaidItem disconnects the bank in ?item=; its synced expenses are kept
+func (h *Handler) RemovePlaidItem(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if !h.requirePlaid(w) {
+		return
+	}
+	items, err := h.storage.GetPlaidItems()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get bank connections"})
+		log.Printf("API ERROR: Failed to get plaid items: %v\n", err)
+		return
+	}
+	id := r.URL.Query().Get("item")
+	i := slices.IndexFunc(items, func(item storage.PlaidItem) bool { return item.ID == id })
+	if i < 0 {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Bank connection not found"})
+		return
+	}
+	// the connection is dropped here even if Plaid can't be reached, the token is then just unused
+	if err := h.plaid.client.RemoveItem(r.Context(), items[i].AccessToken); err != nil {
+		log.Printf("Warning: Failed to remove plaid item %s at Plaid: %v\n", id, err)
+	}
+	if err := h.storage.UpdatePlaidItems(slices.Delete(items, i, i+1)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to remove bank connection"})
+		log.Printf("API ERROR: Failed to remove plaid item: %v\n", err)
+		return
+	}
+	log.Printf("HTTP: Disconnected bank %s\n", id)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// PlaidWebhook syncs an item in the background when Plaid reports new transactions. The token
+// in the URL is the access check; the body only says which item to sync, so nothing in it is
+// trusted beyond that.
+func (h *Handler) PlaidWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if h.plaid == nil || !validToken(r, "X-Webhook-Token", h.plaid.webhookToken) {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid or missing webhook token"})
+		return
+	}
+	var webhook plaidWebhook
+	if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if webhook.Type != "TRANSACTIONS" || webhook.Code != "SYNC_UPDATES_AVAILABLE" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+	items, err := h.storage.GetPlaidItems()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get bank connections"})
+		log.Printf("API ERROR: Failed to get plaid items: %v\n", err)
+		return
+	}
+	i := slices.IndexFunc(items, func(item storage.PlaidItem) bool { return item.ID == webhook.ItemID })
+	if i < 0 {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Bank connection not found"})
+		return
+	}
+	// Plaid expects a quick answer, so the sync runs after responding
+	h.tasks.Add(1)
+	go func() {
+		defer h.tasks.Done()
+		h.syncPlaidItem(context.Background(), items[i])
+	}()
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
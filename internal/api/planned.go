@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Planned expenses are known future costs (car insurance in June, holiday gifts in December)
+// saved up for month by month. Their status tells how much to set aside each month to be ready by
+// the due date, and whether what's saved so far keeps pace with an even schedule.
+
+// PlannedStatus is a planned expense with its saving schedule as of today
+type PlannedStatus struct {
+	storage.PlannedExpense
+	Remaining       float64 `json:"remaining"`       // amount minus saved
+	MonthsLeft      int     `json:"monthsLeft"`      // months to save in, counting this one; 0 once due
+	MonthlySetAside float64 `json:"monthlySetAside"` // to save each month to be ready on time
+	Expected        float64 `json:"expected"`        // saved by now at an even pace from the start
+	OnTrack         bool    `json:"onTrack"`         // saved at least the expected amount
+}
+
+// PlannedResponse is the response of /api/planned
+type PlannedResponse struct {
+	MonthlySetAside float64         `json:"monthlySetAside"` // for all planned expenses
+	Expenses        []PlannedStatus `json:"expenses"`        // by due date
+}
+
+// plannedStatus works out the saving schedule of a planned expense on the given day
+func plannedStatus(planned storage.PlannedExpense, today time.Time) PlannedStatus {
+	status := PlannedStatus{PlannedExpense: planned, Remaining: math.Max(planned.Amount-planned.Saved, 0)}
+	due, _ := time.Parse("2006-01-02", planned.Due)
+	start, _ := time.Parse("2006-01-02", planned.Start)
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+
+	if due.Before(today) {
+		status.MonthlySetAside = status.Remaining
+	} else {
+		// the months before the one it's due in, or this month when it's due this month
+		status.MonthsLeft = max((due.Year()-today.Year())*12+int(due.Month()-today.Month()), 1)
+		status.MonthlySetAside = status.Remaining / float64(status.MonthsLeft)
+	}
+	elapsed := 1.0
+	if total := due.Sub(start); total > 0 {
+		elapsed = math.Min(math.Max(float64(today.Sub(start))/float64(total), 0), 1)
+	}
+	status.Expected = planned.Amount * elapsed
+	status.OnTrack = planned.Saved >= status.Expected
+	return status
+}
+
+func (h *Handler) GetPlannedExpenses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	planned, err := h.storage.GetPlannedExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get planned expenses"})
+		log.Printf("API ERROR: Failed to get planned expenses: %v\n", err)
+		return
+	}
+	response := PlannedResponse{Expenses: make([]PlannedStatus, 0, len(planned))}
+	now := time.Now()
+	for _, expense := range planned {
+		status := plannedStatus(expense, now)
+		response.MonthlySetAside += status.MonthlySetAside
+		response.Expenses = append(response.Expenses, status)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// UpdatePlannedExpenses replaces the planned expenses; saving starts today for those without a start date
+func (h *Handler) UpdatePlannedExpenses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var planned []storage.PlannedExpense
+	if err := json.NewDecoder(r.Body).Decode(&planned); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	today := time.Now().Format("2006-01-02")
+	for i := range planned {
+		if planned[i].Start == "" {
+			planned[i].Start = min(today, planned[i].Due)
+		}
+	}
+	if err := storage.ValidatePlannedExpenses(planned); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.UpdatePlannedExpenses(planned); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update planned expenses"})
+		log.Printf("API ERROR: Failed to update planned expenses: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
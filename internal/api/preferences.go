@@ -0,0 +1,90 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Preferences belong to the user the access control let through, so each family member can pick
+// their own theme and landing page. Without access control, everyone shares one set.
+
+// ConfigResponse is the config with the requesting user's preferences in place of everyone's
+type ConfigResponse struct {
+	*storage.Config
+	Preferences  storage.Preferences `json:"preferences"`
+	ChartPalette []string            `json:"chartPalette"` // colors of the user's palette
+}
+
+// chartPalette returns the colors of the user's palette, else of the instance's default palette
+func chartPalette(config *storage.Config, preferences storage.Preferences) []string {
+	name := cmp.Or(preferences.Palette, config.RuntimeSettings().UI.DefaultPalette)
+	if colors, ok := storage.ChartPalettes[name]; ok {
+		return colors
+	}
+	return storage.ChartPalettes[storage.DefaultPalette]
+}
+
+// GetPreferences returns the preferences of the requesting user
+func (h *Handler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	preferences, err := h.storage.GetPreferences(requestUser(r))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get preferences"})
+		log.Printf("API ERROR: Failed to get preferences: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, preferences)
+}
+
+// UpdatePreferences replaces the preferences of the requesting user; the dashboard layout is kept
+// when the update leaves it out, as it's arranged through /api/dashboard/layout
+func (h *Handler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var preferences storage.Preferences
+	if err := json.NewDecoder(r.Body).Decode(&preferences); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if err := storage.ValidatePreferences(preferences); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if preferences.DefaultCategory != "" {
+		categories, err := h.storage.GetCategories()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve categories"})
+			log.Printf("API ERROR: Failed to get categories: %v\n", err)
+			return
+		}
+		if !slices.Contains(categories, preferences.DefaultCategory) {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Default category must be one of the categories"})
+			return
+		}
+	}
+	if preferences.Dashboard == nil {
+		existing, err := h.storage.GetPreferences(requestUser(r))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get preferences"})
+			log.Printf("API ERROR: Failed to get preferences: %v\n", err)
+			return
+		}
+		preferences.Dashboard = existing.Dashboard
+	}
+	if err := h.storage.UpdatePreferences(requestUser(r), preferences); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save preferences"})
+		log.Printf("API ERROR: Failed to save preferences: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Projects attribute expenses and income to a piece of work across categories, e.g., a client
+// engagement of a freelancer. The project report puts the income of each project against its
+// costs to show which ones pay off.
+
+// ProjectReport is the profitability of each project in a range
+type ProjectReport struct {
+	From     string           `json:"from,omitempty"` // first day (YYYY-MM-DD), empty for all time
+	To       string           `json:"to"`             // last day (YYYY-MM-DD)
+	Currency string           `json:"currency"`
+	Projects []ProjectSummary `json:"projects"` // by profit
+	Total    ProjectSummary   `json:"total"`
+}
+
+// ProjectSummary totals the income and expenses of a project, or of all projects for the total
+type ProjectSummary struct {
+	Name       string             `json:"name,omitempty"`
+	Client     string             `json:"client,omitempty"`
+	Closed     bool               `json:"closed,omitempty"`
+	Count      int                `json:"count"`
+	Income     float64            `json:"income"`
+	Expenses   float64            `json:"expenses"`   // as an absolute value
+	Profit     float64            `json:"profit"`     // income minus expenses
+	Margin     float64            `json:"margin"`     // profit as a percentage of income, 0 without income
+	Categories map[string]float64 `json:"categories"` // expenses by category
+}
+
+// checkProject writes a 400 when the expense's project isn't configured, or is closed and the
+// expense wasn't already on it; the project is set to its configured name
+func (h *Handler) checkProject(w http.ResponseWriter, expense *storage.Expense, existingID string) bool {
+	if expense.Project == "" {
+		return true
+	}
+	projects, err := h.storage.GetProjects()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get projects"})
+		log.Printf("API ERROR: Failed to get projects: %v\n", err)
+		return false
+	}
+	i := slices.IndexFunc(projects, func(p storage.Project) bool { return strings.EqualFold(p.Name, expense.Project) })
+	if i < 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("project '%s' is not configured", expense.Project)})
+		return false
+	}
+	expense.Project = projects[i].Name
+	if projects[i].Closed {
+		if existing, err := h.storage.GetExpense(existingID); existingID == "" || err != nil || existing.Project != expense.Project {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("project '%s' is closed", expense.Project)})
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) GetProjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	projects, err := h.storage.GetProjects()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get projects"})
+		log.Printf("API ERROR: Failed to get projects: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, projects)
+}
+
+// UpdateProjects replaces the projects; expenses keep the project they were attributed to
+func (h *Handler) UpdateProjects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var projects []storage.Project
+	if err := json.NewDecoder(r.Body).Decode(&projects); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if err := storage.ValidateProjects(projects); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.UpdateProjects(projects); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update projects"})
+		log.Printf("API ERROR: Failed to update projects: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// summarizeProjects totals the expenses attributed to a project; open projects are listed even
+// without expenses, and projects that were removed are still reported under their name
+func summarizeProjects(expenses []storage.Expense, projects []storage.Project) ([]ProjectSummary, ProjectSummary) {
+	summaries := make(map[string]*ProjectSummary)
+	for _, project := range projects {
+		if !project.Closed {
+			summaries[strings.ToLower(project.Name)] = &ProjectSummary{Name: project.Name, Client: project.Client, Categories: map[string]float64{}}
+		}
+	}
+	for _, expense := range expenses {
+		if expense.Project == "" {
+			continue
+		}
+		key := strings.ToLower(expense.Project)
+		summary, ok := summaries[key]
+		if !ok {
+			summary = &ProjectSummary{Name: expense.Project, Categories: map[string]float64{}}
+			if i := slices.IndexFunc(projects, func(p storage.Project) bool { return strings.ToLower(p.Name) == key }); i >= 0 {
+				summary.Name, summary.Client, summary.Closed = projects[i].Name, projects[i].Client, projects[i].Closed
+			}
+			summaries[key] = summary
+		}
+		summary.Count++
+		if expense.Amount > 0 {
+			summary.Income += expense.Amount
+		} else {
+			summary.Expenses -= expense.Amount
+			summary.Categories[expense.Category] -= expense.Amount
+		}
+	}
+	total := ProjectSummary{Categories: map[string]float64{}}
+	result := make([]ProjectSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		total.Count += summary.Count
+		total.Income += summary.Income
+		total.Expenses += summary.Expenses
+		for category, spent := range summary.Categories {
+			total.Categories[category] += spent
+		}
+		roundProjectSummary(summary)
+		result = append(result, *summary)
+	}
+	roundProjectSummary(&total)
+	slices.SortFunc(result, func(a, b ProjectSummary) int {
+		return cmp.Or(cmp.Compare(b.Profit, a.Profit), strings.Compare(a.Name, b.Name))
+	})
+	return result, total
+}
+
+// roundProjectSummary rounds the sums to cents and works out the profit and margin
+func roundProjectSummary(summary *ProjectSummary) {
+	round := func(value float64) float64 { return math.Round(value*100) / 100 }
+	summary.Income, summary.Expenses = round(summary.Income), round(summary.Expenses)
+	summary.Profit = round(summary.Income - summary.Expenses)
+	if summary.Income > 0 {
+		summary.Margin = math.Round(summary.Profit/summary.Income*1000) / 10
+	}
+	for category, spent := range summary.Categories {
+		summary.Categories[category] = round(spent)
+	}
+}
+
+// GetProjectReport returns the income, expenses, and profit of each project in the range picked as
+// for the merchant report; the search filters of /expenses narrow it down, e.g., ?project=
+func (h *Handler) GetProjectReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	query := r.URL.Query()
+	start, end, err := h.dateRange(query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	filter, err := expenseFilter(query)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	expenses, err := h.storage.FindExpenses(filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for project report: %v\n", err)
+		return
+	}
+	expenses = h.reportable(expenses)
+	projects, err := h.storage.GetProjects()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get projects"})
+		log.Printf("API ERROR: Failed to get projects: %v\n", err)
+		return
+	}
+	if filter.Project != "" {
+		projects = slices.DeleteFunc(projects, func(p storage.Project) bool { return !strings.EqualFold(p.Name, filter.Project) })
+	}
+	expenses = slices.DeleteFunc(expenses, func(e storage.Expense) bool { return e.Date.Before(start) || !e.Date.Before(end) })
+
+	report := ProjectReport{To: end.AddDate(0, 0, -1).Format("2006-01-02")}
+	if !start.IsZero() {
+		report.From = start.Format("2006-01-02")
+	}
+	if report.Currency, err = h.storage.GetCurrency(); err != nil {
+		report.Currency = "usd"
+	}
+	report.Projects, report.Total = summarizeProjects(expenses, projects)
+	writeJSON(w, http.StatusOK, report)
+}
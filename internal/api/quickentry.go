@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Quick entry adds an expense from one line of shorthand, for command-palette style UIs where
+// typing beats a form: "name;amount;category;date", e.g., "Lunch;12.50;Food/Restaurants;2026-03-02".
+// Only the name and amount are required. An amount with a leading + is income, a category may
+// name its subcategory after a "/", and mapping rules fill in the category when it is left out
+// or unknown. What the parser had to assume is returned as warnings next to the expense.
+
+// QuickEntryRequest is the shorthand line to add
+type QuickEntryRequest struct {
+	Text string `json:"text"`
+}
+
+// QuickEntryResponse is the expense added (or only parsed, with ?dryRun=true) and the warnings
+type QuickEntryResponse struct {
+	Expense  storage.Expense `json:"expense"`
+	Saved    bool            `json:"saved"`
+	Warnings []string        `json:"warnings"`
+}
+
+// ParseAndAddExpense parses a shorthand line and adds the expense with the same checks as
+// AddExpense; ?dryRun=true returns the parsed expense without saving it
+func (h *Handler) ParseAndAddExpense(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var request QuickEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+	expense, warnings, err := h.parseQuickEntry(request.Text, time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	h.applyTaxRate(&expense)
+	if err := expense.Validate(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if !h.checkCategory(w, expense.Category, "") || !h.checkCurrency(w, &expense) || !h.checkAmountLimit(w, r, expense) {
+		return
+	}
+	response := QuickEntryResponse{Expense: expense, Warnings: warnings}
+	if !dryRun {
+		response.Expense.ID = uuid.New().String()
+		if err := h.storage.AddExpense(response.Expense); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save expense"})
+			log.Printf("API ERROR: Failed to save expense: %v\n", err)
+			return
+		}
+		h.notifyExpenseAdded(response.Expense)
+		response.Saved = true
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// parseQuickEntry reads "name;amount;category;date" into an expense; the date defaults to now
+func (h *Handler) parseQuickEntry(text string, now time.Time) (storage.Expense, []string, error) {
+	fields := strings.Split(text, ";")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	warnings := []string{}
+	if len(fields) > 4 {
+		warnings = append(warnings, fmt.Sprintf("ignored extra fields '%s'", strings.Join(fields[4:], ";")))
+	}
+	fields = append(fields, "", "", "")[:4]
+	name, amountStr, categoryStr, dateStr := fields[0], fields[1], fields[2], fields[3]
+	if name == "" {
+		return storage.Expense{}, nil, fmt.Errorf("a name is required, as in 'name;amount;category;date'")
+	}
+	if amountStr == "" {
+		return storage.Expense{}, nil, fmt.Errorf("an amount is required, as in 'name;amount;category;date'")
+	}
+
+	isIncome := strings.HasPrefix(amountStr, "+")
+	number := strings.TrimLeft(amountStr, "+-")
+	// a comma is a decimal separator only before cents, as in 12,5 or 12,50; otherwise (1,500) it
+	// separates thousands
+	if _, cents, ok := strings.Cut(number, ","); ok && !strings.Contains(number, ".") && !strings.Contains(cents, ",") && len(cents) >= 1 && len(cents) <= 2 {
+		number = strings.Replace(number, ",", ".", 1)
+		warnings = append(warnings, fmt.Sprintf("read the amount '%s' with ',' as the decimal separator", amountStr))
+	}
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(number, ",", ""), 64)
+	if err != nil || amount <= 0 {
+		return storage.Expense{}, nil, fmt.Errorf("could not read an amount from '%s'", amountStr)
+	}
+	if !isIncome {
+		amount = -amount
+	}
+
+	expense := storage.Expense{Name: name, Amount: amount, Date: now}
+	switch strings.ToLower(dateStr) {
+	case "", "today":
+	case "yesterday":
+		expense.Date = now.AddDate(0, 0, -1)
+	default:
+		date, err := parseDate(dateStr)
+		if err != nil {
+			return storage.Expense{}, nil, fmt.Errorf("could not read a date from '%s', use YYYY-MM-DD, today, or yesterday", dateStr)
+		}
+		expense.Date = date
+		if date.After(now) {
+			warnings = append(warnings, fmt.Sprintf("the date %s is in the future", date.Format("2006-01-02")))
+		}
+	}
+
+	categories, err := h.storage.GetCategories()
+	if err != nil {
+		return storage.Expense{}, nil, fmt.Errorf("failed to retrieve categories")
+	}
+	category, subCategory, _ := strings.Cut(categoryStr, "/")
+	category, subCategory = strings.TrimSpace(category), strings.TrimSpace(subCategory)
+	if category != "" {
+		// configured categories and subcategories match case-insensitively
+		if idx := slices.IndexFunc(categories, func(c string) bool { return strings.EqualFold(c, category) }); idx >= 0 {
+			expense.Category = categories[idx]
+		}
+	}
+	h.resolveCategory(&expense, categories)
+	if category != "" && !strings.EqualFold(expense.Category, category) {
+		warnings = append(warnings, fmt.Sprintf("unknown category '%s', used '%s'", category, expense.Category))
+	}
+	if subCategory != "" {
+		subCategories, err := h.storage.GetSubCategories(expense.Category)
+		if err != nil {
+			return storage.Expense{}, nil, fmt.Errorf("failed to retrieve subcategories")
+		}
+		if idx := slices.IndexFunc(subCategories, func(s string) bool { return strings.EqualFold(s, subCategory) }); idx >= 0 {
+			expense.SubCategory = subCategories[idx]
+		} else {
+			warnings = append(warnings, fmt.Sprintf("unknown subcategory '%s' of '%s', left out", subCategory, expense.Category))
+		}
+	}
+	return expense, warnings, nil
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/tanq16/expenseowl/internal/ocr"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Receipt scanning reads a photo of a receipt with OCR and returns an expense draft with the
+// merchant, total, and date filled in. Nothing is saved; the user confirms the draft in the form.
+
+const maxReceiptSize = 10 << 20
+
+// ReceiptScan is the draft read from a receipt, with the fields that couldn't be read listed as missing
+type ReceiptScan struct {
+	Expense storage.Expense `json:"expense"`
+	Missing []string        `json:"missing"` // merchant, total, or date
+	Text    string          `json:"text"`    // recognized text, to check the draft against
+}
+
+// ocrEngineFromEnv returns the OCR engine, or nil when receipt scanning is disabled
+func ocrEngineFromEnv() ocr.Engine {
+	switch os.Getenv("OCR_ENGINE") {
+	case "tesseract":
+		path := os.Getenv("TESSERACT_PATH")
+		if path == "" {
+			path = "tesseract"
+		}
+		return ocr.Tesseract{Path: path, Language: os.Getenv("TESSERACT_LANG")}
+	case "http":
+		if url := os.Getenv("OCR_URL"); url != "" {
+			return ocr.HTTP{URL: url, Token: os.Getenv("OCR_TOKEN")}
+		}
+		log.Println("OCR_ENGINE is http but OCR_URL is not set, receipt scanning is disabled")
+	}
+	return nil
+}
+
+// receipt totals, most specific first; a line starting with "total" skips subtotals
+var receiptTotalPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?im)^\s*(?:grand\s+)?total\b[^\d\n]{0,20}([\d,]+\.\d{2})`),
+	regexp.MustCompile(`(?i)(?:amount|balance)\s+due\b[^\d\n]{0,20}([\d,]+\.\d{2})`),
+	regexp.MustCompile(`(?i)\b(?:grand\s+)?total\b[^\d\n]{0,20}([\d,]+\.\d{2})`),
+}
+
+var (
+	reReceiptAmount    = regexp.MustCompile(`\b\d{1,3}(?:,\d{3})*\.\d{2}\b|\b\d+\.\d{2}\b`)
+	reReceiptISODate   = regexp.MustCompile(`\b(\d{4})-(\d{2})-(\d{2})\b`)
+	reReceiptDate      = regexp.MustCompile(`\b(\d{1,2})([/.])(\d{1,2})[/.](\d{4}|\d{2})\b`)
+	reReceiptMonthDate = regexp.MustCompile(`(?i)\b(\d{1,2}\s+)?(jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\.?\s+(\d{1,2},?\s+)?(\d{4})\b`)
+)
+
+// parseReceiptText reads the merchant (the first line with a word in it), total, and date of a receipt
+func parseReceiptText(text string) (merchant string, total float64, date time.Time) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		letters := 0
+		for _, r := range line {
+			if unicode.IsLetter(r) {
+				letters++
+			}
+		}
+		if letters >= 3 {
+			merchant = line
+			break
+		}
+	}
+	for _, pattern := range receiptTotalPatterns {
+		if amount, ok := matchAmount(pattern, text); ok {
+			total = amount
+			break
+		}
+	}
+	if total == 0 {
+		// the largest amount is the total on most receipts without a labeled one
+		for _, match := range reReceiptAmount.FindAllString(text, -1) {
+			if amount, err := strconv.ParseFloat(strings.ReplaceAll(match, ",", ""), 64); err == nil && amount > total {
+				total = amount
+			}
+		}
+	}
+	return merchant, total, parseReceiptDate(text)
+}
+
+// parseReceiptDate finds the first date on a receipt; 03/04/2026 is read as March 4 and 03.04.2026 as 3 April
+func parseReceiptDate(text string) time.Time {
+	if m := reReceiptISODate.FindStringSubmatch(text); m != nil {
+		if date, err := time.Parse("2006-01-02", m[0]); err == nil {
+			return date
+		}
+	}
+	if m := reReceiptDate.FindStringSubmatch(text); m != nil {
+		first, _ := strconv.Atoi(m[1])
+		second, _ := strconv.Atoi(m[3])
+		year, _ := strconv.Atoi(m[4])
+		if year < 100 {
+			year += 2000
+		}
+		month, day := first, second
+		if m[2] == "." || first > 12 {
+			month, day = second, first
+		}
+		if date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC); month <= 12 && date.Day() == day {
+			return date
+		}
+	}
+	if m := reReceiptMonthDate.FindStringSubmatch(text); m != nil {
+		day := strings.TrimSpace(m[1])
+		if day == "" {
+			day = strings.Trim(m[3], ", \t")
+		}
+		if date, err := time.Parse("2 Jan 2006", day+" "+strings.ToUpper(m[2][:1])+strings.ToLower(m[2][1:3])+" "+m[4]); err == nil {
+			return date
+		}
+	}
+	return time.Time{}
+}
+
+// ScanReceipt reads an uploaded receipt (multipart field "receipt", or the image as the body) and
+// returns a prefilled expense draft for POST /api/receipts/scan
+func (h *Handler) ScanReceipt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if h.ocr == nil {
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Error: "Receipt scanning is not configured, set OCR_ENGINE"})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxReceiptSize)
+	var image []byte
+	var err error
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		file, header, ferr := r.FormFile("receipt")
+		if ferr != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Missing 'receipt' file"})
+			return
+		}
+		defer file.Close()
+		contentType = header.Header.Get("Content-Type")
+		image, err = io.ReadAll(file)
+	} else {
+		image, err = io.ReadAll(r.Body)
+	}
+	if err != nil || len(image) == 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Could not read the receipt image"})
+		return
+	}
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(image)
+	}
+
+	text, err := h.ocr.Recognize(r.Context(), image, contentType)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "Failed to read the receipt"})
+		log.Printf("API ERROR: Failed to read receipt: %v\n", err)
+		return
+	}
+	categories, err := h.storage.GetCategories()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve categories"})
+		log.Printf("API ERROR: Failed to get categories: %v\n", err)
+		return
+	}
+
+	merchant, total, date := parseReceiptText(text)
+	scan := ReceiptScan{Missing: []string{}, Text: text}
+	if merchant == "" {
+		scan.Missing = append(scan.Missing, "merchant")
+	}
+	if total == 0 {
+		scan.Missing = append(scan.Missing, "total")
+	}
+	if date.IsZero() {
+		scan.Missing = append(scan.Missing, "date")
+		date = time.Now()
+	}
+	scan.Expense = storage.Expense{Name: merchant, Amount: -total, Date: date, Tags: []string{"receipt"}}
+	h.resolveCategory(&scan.Expense, categories)
+	writeJSON(w, http.StatusOK, scan)
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Reconciliation checks the transactions of an account against a statement. The statement's
+// ending balance is put against the opening balance moved by the transactions of the period, and
+// transactions that have been matched against a statement are marked as reconciled.
+
+// Reconciliation is the response of /api/reconcile
+type Reconciliation struct {
+	Account      string            `json:"account"`
+	Type         string            `json:"type"` // asset or liability; balances of a liability are what's owed
+	From         string            `json:"from"` // first day of the statement (YYYY-MM-DD)
+	To           string            `json:"to"`   // last day of the statement (YYYY-MM-DD)
+	Currency     string            `json:"currency"`
+	Opening      float64           `json:"opening"`      // balance before the first day
+	Ending       float64           `json:"ending"`       // balance on the statement
+	Recorded     float64           `json:"recorded"`     // opening moved by every transaction of the period
+	Cleared      float64           `json:"cleared"`      // opening moved by the reconciled transactions of the period
+	Discrepancy  float64           `json:"discrepancy"`  // ending minus recorded, 0 when the account matches the statement
+	Reconciled   int               `json:"reconciled"`   // transactions of the period already reconciled
+	Unreconciled []storage.Expense `json:"unreconciled"` // transactions of the period not reconciled yet, oldest first
+}
+
+// buildReconciliation works out the balances of the account's transactions between start and end
+// (exclusive); opening and ending are balances as the statement shows them
+func buildReconciliation(account storage.Account, expenses []storage.Expense, start, end time.Time, opening, ending float64) Reconciliation {
+	round := func(value float64) float64 { return math.Round(value*100) / 100 }
+	reconciliation := Reconciliation{Account: account.Name, Type: account.Type, Opening: round(opening), Ending: round(ending), Unreconciled: []storage.Expense{}}
+	recorded, cleared := opening, opening
+	for _, expense := range expenses {
+		if !strings.EqualFold(expense.Account, account.Name) || expense.Date.Before(start) || !expense.Date.Before(end) {
+			continue
+		}
+		// spending lowers an asset and adds to what's owed on a liability
+		change := expense.Amount
+		if account.Type == storage.AccountLiability {
+			change = -change
+		}
+		recorded += change
+		if expense.Reconciled {
+			cleared += change
+			reconciliation.Reconciled++
+		} else {
+			reconciliation.Unreconciled = append(reconciliation.Unreconciled, expense)
+		}
+	}
+	slices.SortFunc(reconciliation.Unreconciled, func(a, b storage.Expense) int {
+		return cmp.Or(a.Date.Compare(b.Date), cmp.Compare(a.ID, b.ID))
+	})
+	reconciliation.Recorded, reconciliation.Cleared = round(recorded), round(cleared)
+	reconciliation.Discrepancy = round(ending - recorded)
+	return reconciliation
+}
+
+// GetReconciliation puts the statement of ?account= for ?from= to ?to= (YYYY-MM-DD, inclusive;
+// the last closed statement of accounts with a statement day) with the ending ?balance= against
+// the recorded transactions. The opening balance is ?opening=, or the account's balance the day
+// before, from its snapshots and transactions; accounts that aren't configured are taken as
+// assets starting at 0.
+func (h *Handler) GetReconciliation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	query := r.URL.Query()
+	name := strings.TrimSpace(query.Get("account"))
+	if name == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "account is required"})
+		return
+	}
+	account, err := h.configuredAccount(name)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get accounts"})
+		log.Printf("API ERROR: Failed to get accounts: %v\n", err)
+		return
+	}
+	from, to := query.Get("from"), query.Get("to")
+	if from == "" && to == "" && account.StatementDay > 0 {
+		// the last statement that has closed
+		statement := periods.StatementContaining(time.Now(), account.StatementDay)
+		statement = periods.StatementContaining(statement.Start.AddDate(0, 0, -1), account.StatementDay)
+		from, to = statement.Start.Format("2006-01-02"), statement.End.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	start, err := time.ParseInLocation("2006-01-02", from, time.Local)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'from': must be YYYY-MM-DD"})
+		return
+	}
+	last, err := time.ParseInLocation("2006-01-02", to, time.Local)
+	if err != nil || last.Before(start) {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'to': must be YYYY-MM-DD, not before 'from'"})
+		return
+	}
+	balances := make(map[string]float64)
+	for _, param := range []string{"balance", "opening"} {
+		if raw := query.Get(param); raw != "" {
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("invalid '%s': must be a number", param)})
+				return
+			}
+			balances[param] = value
+		}
+	}
+	if _, ok := balances["balance"]; !ok {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "balance is required"})
+		return
+	}
+
+	expenses, err := h.storage.FindExpenses(storage.ExpenseFilter{Account: account.Name})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for reconciliation: %v\n", err)
+		return
+	}
+	end := last.AddDate(0, 0, 1)
+	opening, ok := balances["opening"]
+	if !ok {
+		derived := account
+		derived.Derived = true
+		opening, _ = accountBalance(derived, expenses, start.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+
+	reconciliation := buildReconciliation(account, expenses, start, end, opening, balances["balance"])
+	reconciliation.From, reconciliation.To = start.Format("2006-01-02"), last.Format("2006-01-02")
+	if reconciliation.Currency, err = h.storage.GetCurrency(); err != nil {
+		reconciliation.Currency = "usd"
+	}
+	writeJSON(w, http.StatusOK, reconciliation)
+}
+
+// MarkReconciled marks the expenses in {"ids": [...]} as reconciled, or as not reconciled with
+// "reconciled": false
+func (h *Handler) MarkReconciled(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var payload struct {
+		IDs        []string `json:"ids"`
+		Reconciled *bool    `json:"reconciled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if len(payload.IDs) == 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "ids are required"})
+		return
+	}
+	reconciled := payload.Reconciled == nil || *payload.Reconciled
+	updated := 0
+	for _, id := range payload.IDs {
+		expense, err := h.storage.GetExpense(id)
+		if err != nil || expense.Reconciled == reconciled {
+			continue
+		}
+		expense.Reconciled = reconciled
+		if err := h.storage.UpdateExpense(id, expense); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to mark expenses reconciled"})
+			log.Printf("API ERROR: Failed to mark expense %s reconciled: %v\n", id, err)
+			return
+		}
+		updated++
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"updated": updated})
+	log.Printf("HTTP: Marked %d expenses reconciled: %t\n", updated, reconciled)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// A refund is a positive entry linked to the expense it gives money back for. Reports take it off
+// the original's category and merchant instead of counting it as income, so they show net
+// spending, and the original shows how much of it was refunded.
+
+// Refund states of an expense
+const (
+	RefundNone    = "none"
+	RefundPartial = "partial"
+	RefundFull    = "refunded"
+)
+
+// RefundStatus is the response of /api/expenses/{id}/refunds
+type RefundStatus struct {
+	Expense   storage.Expense   `json:"expense"`
+	Refunds   []storage.Expense `json:"refunds"` // oldest first
+	Refunded  float64           `json:"refunded"`
+	Remaining float64           `json:"remaining"` // of the expense's amount, as an absolute value
+	Status    string            `json:"status"`    // none, partial, or refunded
+}
+
+// refundStatus totals the refunds of an expense among the given expenses
+func refundStatus(expense storage.Expense, expenses []storage.Expense) RefundStatus {
+	status := RefundStatus{Expense: expense, Refunds: []storage.Expense{}, Status: RefundNone}
+	for _, other := range expenses {
+		if other.RefundOf == expense.ID && other.ID != expense.ID {
+			status.Refunds = append(status.Refunds, other)
+			status.Refunded += other.Amount
+		}
+	}
+	slices.SortFunc(status.Refunds, func(a, b storage.Expense) int {
+		return cmp.Or(a.Date.Compare(b.Date), cmp.Compare(a.ID, b.ID))
+	})
+	status.Refunded = math.Round(status.Refunded*100) / 100
+	status.Remaining = max(0, math.Round((-expense.Amount-status.Refunded)*100)/100)
+	switch {
+	case status.Refunded > 0 && status.Remaining == 0:
+		status.Status = RefundFull
+	case status.Refunded > 0:
+		status.Status = RefundPartial
+	}
+	return status
+}
+
+// checkRefund writes a 400 when a refund is linked to something other than an expense, or gives
+// back more than is left of it; a refund takes the category and subcategory of its expense
+func (h *Handler) checkRefund(w http.ResponseWriter, expense *storage.Expense, existingID string) bool {
+	if !expense.IsRefund() {
+		return true
+	}
+	if expense.RefundOf == existingID {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "an expense cannot refund itself"})
+		return false
+	}
+	original, err := h.storage.GetExpense(expense.RefundOf)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("expense '%s' to refund not found", expense.RefundOf)})
+		return false
+	}
+	if original.Amount >= 0 || original.IsRefund() {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "only expenses can be refunded"})
+		return false
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for refund: %v\n", err)
+		return false
+	}
+	// an edited refund replaces what it refunded before
+	expenses = slices.DeleteFunc(expenses, func(e storage.Expense) bool { return e.ID == existingID })
+	if status := refundStatus(original, expenses); expense.Amount > status.Remaining+0.005 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("refund of %.2f is more than the %.2f left to refund", expense.Amount, status.Remaining)})
+		return false
+	}
+	expense.Category, expense.SubCategory = original.Category, original.SubCategory
+	return true
+}
+
+// ExpenseResource serves the routes below /api/expenses/{id}
+func (h *Handler) ExpenseResource(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/refunds") {
+		h.ExpenseRefunds(w, r)
+		return
+	}
+	h.ExpenseMetadata(w, r)
+}
+
+// ExpenseRefunds reads or changes the refunds of an expense at /api/expenses/{id}/refunds: GET
+// returns its refund status, POST links the positive entry in {"id": "..."} as a refund, and
+// DELETE unlinks the refund in ?id=, which counts as income again
+func (h *Handler) ExpenseRefunds(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/expenses/"), "/refunds")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Not found"})
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	expense, err := h.storage.GetExpense(id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Expense not found", Code: CodeExpenseNotFound})
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		var payload struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.ID == "" {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+			return
+		}
+		refund, err := h.storage.GetExpense(payload.ID)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("refund '%s' not found", payload.ID)})
+			return
+		}
+		if refund.Amount <= 0 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "a refund must be a positive amount"})
+			return
+		}
+		refund.RefundOf = id
+		if !h.checkRefund(w, &refund, refund.ID) {
+			return
+		}
+		if err := h.storage.UpdateExpense(refund.ID, refund); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to link refund"})
+			log.Printf("API ERROR: Failed to link refund %s: %v\n", refund.ID, err)
+			return
+		}
+	case http.MethodDelete:
+		refund, err := h.storage.GetExpense(r.URL.Query().Get("id"))
+		if err != nil || refund.RefundOf != id {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Refund not found"})
+			return
+		}
+		refund.RefundOf = ""
+		if err := h.storage.UpdateExpense(refund.ID, refund); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to unlink refund"})
+			log.Printf("API ERROR: Failed to unlink refund %s: %v\n", refund.ID, err)
+			return
+		}
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for refunds: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, refundStatus(expense, expenses))
+}
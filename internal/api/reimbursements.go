@@ -0,0 +1,108 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Expenses classed as reimbursable were paid on someone else's behalf (an employer, a client) and
+// are owed back. They stay pending until they are settled, which classes them as reimbursed.
+
+// ReimbursementReport lists the reimbursable expenses that haven't been paid back yet
+type ReimbursementReport struct {
+	Currency   string             `json:"currency"`
+	Count      int                `json:"count"`
+	Pending    float64            `json:"pending"`          // total owed back
+	Oldest     string             `json:"oldest,omitempty"` // YYYY-MM-DD of the oldest pending expense
+	OldestDays int                `json:"oldestDays"`       // days the oldest pending expense has waited
+	Categories map[string]float64 `json:"categories"`       // pending by category
+	Expenses   []storage.Expense  `json:"expenses"`         // oldest first
+}
+
+// buildReimbursementReport totals the pending reimbursable expenses
+func buildReimbursementReport(expenses []storage.Expense, now time.Time) ReimbursementReport {
+	report := ReimbursementReport{Categories: map[string]float64{}, Expenses: []storage.Expense{}}
+	for _, expense := range expenses {
+		if expense.Class != storage.ClassReimbursable {
+			continue
+		}
+		report.Count++
+		report.Pending -= expense.Amount
+		report.Categories[expense.Category] -= expense.Amount
+		report.Expenses = append(report.Expenses, expense)
+	}
+	slices.SortFunc(report.Expenses, func(a, b storage.Expense) int {
+		return cmp.Or(a.Date.Compare(b.Date), cmp.Compare(a.ID, b.ID))
+	})
+	if len(report.Expenses) > 0 {
+		oldest := report.Expenses[0].Date
+		report.Oldest = oldest.Format("2006-01-02")
+		report.OldestDays = max(0, int(now.Sub(oldest).Hours()/24))
+	}
+	report.Pending = math.Round(report.Pending*100) / 100
+	for category, pending := range report.Categories {
+		report.Categories[category] = math.Round(pending*100) / 100
+	}
+	return report
+}
+
+// GetReimbursementReport returns the reimbursable expenses that are still owed back
+func (h *Handler) GetReimbursementReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	expenses, err := h.storage.FindExpenses(storage.ExpenseFilter{Class: storage.ClassReimbursable})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for reimbursement report: %v\n", err)
+		return
+	}
+	report := buildReimbursementReport(expenses, time.Now())
+	if report.Currency, err = h.storage.GetCurrency(); err != nil {
+		report.Currency = "usd"
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// SettleReimbursements marks reimbursable expenses as paid back; other expenses are left as they are
+func (h *Handler) SettleReimbursements(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var payload struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if len(payload.IDs) == 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "ids are required"})
+		return
+	}
+	settled := 0
+	for _, id := range payload.IDs {
+		expense, err := h.storage.GetExpense(id)
+		if err != nil || expense.Class != storage.ClassReimbursable {
+			continue
+		}
+		expense.Class = storage.ClassReimbursed
+		if err := h.storage.UpdateExpense(id, expense); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to settle reimbursements"})
+			log.Printf("API ERROR: Failed to settle reimbursement %s: %v\n", id, err)
+			return
+		}
+		settled++
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"settled": settled})
+	log.Printf("HTTP: Settled %d reimbursements\n", settled)
+}
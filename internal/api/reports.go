@@ -0,0 +1,364 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/analytics"
+	"github.com/tanq16/expenseowl/internal/i18n"
+	"github.com/tanq16/expenseowl/internal/mailer"
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// PeriodReport is the content of a summary email or shared report
+type PeriodReport struct {
+	Title           string // e.g., the period label
+	Period          periods.Period
+	Currency        string
+	Spent           float64
+	Income          float64
+	Balance         float64
+	TopCategories   []CategorySummary
+	Budgets         []TRMNLBudget
+	BiggestExpenses []storage.Expense
+}
+
+// ReportSettingsResponse adds whether SMTP is set up to the stored settings
+type ReportSettingsResponse struct {
+	storage.ReportSettings
+	SMTPConfigured bool `json:"smtpConfigured"`
+}
+
+// mailerConfigFromEnv returns the SMTP config, or nil when email reports are disabled
+func mailerConfigFromEnv() *mailer.Config {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return nil
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = os.Getenv("SMTP_USER")
+	}
+	return &mailer.Config{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USER"),
+		Password: os.Getenv("SMTP_PASS"),
+		From:     from,
+	}
+}
+
+// buildReport summarizes a period: totals, top categories, budget status, and biggest expenses
+func (h *Handler) buildReport(period periods.Period, lang string) (*PeriodReport, error) {
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		return nil, err
+	}
+	return h.summarizeReport(expenses, period, i18n.PeriodLabel(lang, period)), nil
+}
+
+// reportLanguage is the language of reports and shared links, empty for English with the
+// currency's own number format
+func (h *Handler) reportLanguage() string {
+	settings, err := h.storage.GetReportSettings()
+	if err != nil {
+		return ""
+	}
+	return settings.Language
+}
+
+// summarizeReport builds the report from the given expenses; budgets only apply to budget periods
+func (h *Handler) summarizeReport(expenses []storage.Expense, period periods.Period, title string) *PeriodReport {
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+	expenses = h.reportable(expenses)
+	totalIncome, totalExpenses, categoryTotals := summarizePeriod(expenses, period)
+	report := &PeriodReport{
+		Title:         title,
+		Period:        period,
+		Currency:      currency,
+		Spent:         totalExpenses,
+		Income:        totalIncome,
+		Balance:       totalIncome - totalExpenses,
+		TopCategories: getTopCategories(categoryTotals, totalExpenses, 5),
+	}
+	if period.Type != periods.Yearly && period.Type != "" {
+		if budgets, err := h.storage.GetBudgets(); err == nil {
+			report.Budgets = getBudgetSummaries(budgets, categoryTotals)
+		}
+	}
+	var spending []storage.Expense
+	for _, expense := range expenses {
+		if expense.Amount < 0 && period.Contains(expense.Date) {
+			spending = append(spending, expense)
+		}
+	}
+	report.BiggestExpenses = analytics.TopN(spending, 5, func(a, b storage.Expense) int {
+		return cmp.Or(cmp.Compare(a.Amount, b.Amount), cmp.Compare(a.ID, b.ID))
+	})
+	return report
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; color: #222; max-width: 560px; margin: 0 auto; padding: 16px;">
+<h2 style="margin-bottom: 4px;">{{index .T "report.title"}}</h2>
+<p style="color: #666; margin-top: 0;">{{.Label}}</p>
+<table style="width: 100%; border-collapse: collapse; margin-bottom: 24px;">
+<tr><td>{{index .T "report.spent"}}</td><td style="text-align: right;"><strong>{{.Spent}}</strong></td></tr>
+<tr><td>{{index .T "report.income"}}</td><td style="text-align: right;">{{.Income}}</td></tr>
+<tr><td>{{index .T "report.balance"}}</td><td style="text-align: right;">{{.Balance}}</td></tr>
+</table>
+{{if .Categories}}<h3>{{index .T "report.topCategories"}}</h3>
+<table style="width: 100%; border-collapse: collapse; margin-bottom: 24px;">
+{{range .Categories}}<tr><td>{{.Name}}</td><td style="text-align: right;">{{.Amount}}</td><td style="text-align: right; color: #666;">{{.Share}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Budgets}}<h3>{{index .T "report.budgets"}}</h3>
+<table style="width: 100%; border-collapse: collapse; margin-bottom: 24px;">
+{{range .Budgets}}<tr><td>{{.Name}}</td><td style="text-align: right;">{{.Spent}}</td><td style="text-align: right; color: {{if .Over}}#c0392b{{else}}#27ae60{{end}};">{{.Share}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Expenses}}<h3>{{index .T "report.biggestExpenses"}}</h3>
+<table style="width: 100%; border-collapse: collapse;">
+{{range .Expenses}}<tr><td>{{.Date}}</td><td>{{.Name}}</td><td style="color: #666;">{{.Category}}</td><td style="text-align: right;">{{.Amount}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+type reportRow struct {
+	Name     string
+	Category string
+	Date     string
+	Amount   string
+	Spent    string
+	Share    string
+	Over     bool
+}
+
+// renderReport formats a report as an HTML email body in a language; amounts use the language's
+// separators when one is set, the currency's otherwise
+func renderReport(report *PeriodReport, lang string) (string, error) {
+	format := currencyFormat(report.Currency)
+	if lang != "" {
+		format.Thousands, format.Decimal = i18n.Separators(lang)
+	}
+	data := struct {
+		T          map[string]string
+		Label      string
+		Spent      string
+		Income     string
+		Balance    string
+		Categories []reportRow
+		Budgets    []reportRow
+		Expenses   []reportRow
+	}{
+		T:       i18n.Catalog(lang),
+		Label:   report.Title,
+		Spent:   formatAmount(report.Spent, format),
+		Income:  formatAmount(report.Income, format),
+		Balance: formatAmount(report.Balance, format),
+	}
+	for _, category := range report.TopCategories {
+		data.Categories = append(data.Categories, reportRow{
+			Name:   category.Name,
+			Amount: formatAmount(category.Amount, format),
+			Share:  fmt.Sprintf("%.0f%%", category.Percentage),
+		})
+	}
+	for _, budget := range report.Budgets {
+		data.Budgets = append(data.Budgets, reportRow{
+			Name:  budget.Name,
+			Spent: i18n.T(lang, "report.budgetOf", formatAmount(budget.Spent, format), formatAmount(budget.Budget, format)),
+			Share: fmt.Sprintf("%.0f%%", budget.Percentage),
+			Over:  budget.Remaining < 0,
+		})
+	}
+	for _, expense := range report.BiggestExpenses {
+		data.Expenses = append(data.Expenses, reportRow{
+			Name:     expense.Name,
+			Category: expense.Category,
+			Date:     i18n.Day(lang, expense.Date),
+			Amount:   formatAmount(-expense.Amount, format),
+		})
+	}
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// sendReport emails the report for a period to the given recipients
+func (h *Handler) sendReport(period periods.Period, recipients []string) error {
+	if h.mailer == nil {
+		return fmt.Errorf("SMTP is not configured")
+	}
+	lang := h.reportLanguage()
+	report, err := h.buildReport(period, lang)
+	if err != nil {
+		return fmt.Errorf("failed to build report: %v", err)
+	}
+	body, err := renderReport(report, lang)
+	if err != nil {
+		return fmt.Errorf("failed to render report: %v", err)
+	}
+	return mailer.Send(*h.mailer, recipients, i18n.T(lang, "report.subject", report.Title), body)
+}
+
+// sendDueReport emails the report for the last closed period, unless it was already sent; it
+// runs as the report-emails job
+func (h *Handler) sendDueReport(now time.Time) error {
+	settings, err := h.storage.GetReportSettings()
+	if err != nil {
+		return fmt.Errorf("failed to get report settings: %v", err)
+	}
+	if !settings.Enabled || len(settings.Recipients) == 0 {
+		return nil
+	}
+	periodConfig := h.periodConfig()
+	closed := periodConfig.Previous(periodConfig.Current(now))
+	key := closed.Start.Format(periods.AnchorLayout)
+	if settings.LastSent >= key {
+		return nil
+	}
+	if err := h.sendReport(closed, settings.Recipients); err != nil {
+		return fmt.Errorf("failed to send report for %s: %v", closed.Label(), err)
+	}
+	settings.LastSent = key
+	if err := h.storage.UpdateReportSettings(settings); err != nil {
+		return fmt.Errorf("failed to record sent report: %v", err)
+	}
+	log.Printf("Sent report for %s to %d recipient(s)\n", closed.Label(), len(settings.Recipients))
+	return nil
+}
+
+func (h *Handler) GetReportSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	settings, err := h.storage.GetReportSettings()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get report settings"})
+		log.Printf("API ERROR: Failed to get report settings: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ReportSettingsResponse{ReportSettings: settings, SMTPConfigured: h.mailer != nil})
+}
+
+func (h *Handler) UpdateReportSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var settings storage.ReportSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if err := storage.ValidateReportSettings(&settings); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	current, err := h.storage.GetReportSettings()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get report settings"})
+		log.Printf("API ERROR: Failed to get report settings: %v\n", err)
+		return
+	}
+	settings.LastSent = current.LastSent
+	if settings.Enabled && !current.Enabled {
+		// start with the period in progress instead of mailing the one that already closed
+		periodConfig := h.periodConfig()
+		closed := periodConfig.Previous(periodConfig.Current(time.Now()))
+		settings.LastSent = closed.Start.Format(periods.AnchorLayout)
+	}
+	if err := h.storage.UpdateReportSettings(settings); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update report settings"})
+		log.Printf("API ERROR: Failed to update report settings: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// reportPeriod picks the current period, or the last closed one with ?period=previous
+func (h *Handler) reportPeriod(r *http.Request) periods.Period {
+	periodConfig := h.periodConfig()
+	period := periodConfig.Current(time.Now())
+	if r.URL.Query().Get("period") == "previous" {
+		period = periodConfig.Previous(period)
+	}
+	return period
+}
+
+// PreviewReport renders the report email in the browser, in another language with ?lang=
+func (h *Handler) PreviewReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	lang := h.reportLanguage()
+	if r.URL.Query().Has("lang") {
+		lang = r.URL.Query().Get("lang")
+		if lang != "" && !i18n.Supported(lang) {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("unsupported language '%s'", lang)})
+			return
+		}
+	}
+	report, err := h.buildReport(h.reportPeriod(r), lang)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to build report"})
+		log.Printf("API ERROR: Failed to build report: %v\n", err)
+		return
+	}
+	body, err := renderReport(report, lang)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to render report"})
+		log.Printf("API ERROR: Failed to render report: %v\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(body))
+}
+
+// SendReport emails the report right away, e.g., to test the SMTP settings
+func (h *Handler) SendReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if h.mailer == nil {
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Error: "Email reports are not configured, set SMTP_HOST"})
+		return
+	}
+	settings, err := h.storage.GetReportSettings()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get report settings"})
+		log.Printf("API ERROR: Failed to get report settings: %v\n", err)
+		return
+	}
+	if len(settings.Recipients) == 0 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "No report recipients configured"})
+		return
+	}
+	if err := h.sendReport(h.reportPeriod(r), settings.Recipients); err != nil {
+		writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "Failed to send report"})
+		log.Printf("API ERROR: Failed to send report: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
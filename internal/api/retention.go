@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// The retention policy moves expenses older than N years out of the main store into an archive
+// (a gzipped file next to expenses.json, or the expenses_archive table), so the expenses every
+// page loads stay few. Archived expenses are only read by the CSV export with ?archived=true.
+
+// retentionCutoff returns the start of the day N years ago; expenses before it are archived
+func retentionCutoff(years int, now time.Time) time.Time {
+	return time.Date(now.Year()-years, now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+// applyRetention archives the expenses past the retention policy, if there is one; it runs as the
+// retention job, and when the policy is saved
+func (h *Handler) applyRetention(now time.Time) (int, error) {
+	years, err := h.storage.GetRetentionYears()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get retention policy: %v", err)
+	}
+	if years == 0 {
+		return 0, nil
+	}
+	return h.storage.ArchiveExpenses(retentionCutoff(years, now))
+}
+
+func (h *Handler) GetRetention(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	years, err := h.storage.GetRetentionYears()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get retention policy"})
+		log.Printf("API ERROR: Failed to get retention policy: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, years)
+}
+
+// UpdateRetention sets the number of years to keep (0 keeps everything) and archives right away
+func (h *Handler) UpdateRetention(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var years int
+	if err := json.NewDecoder(r.Body).Decode(&years); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if years < 0 || years > storage.MaxRetentionYears {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("Retention must be from 0 to %d years", storage.MaxRetentionYears)})
+		return
+	}
+	if err := h.storage.UpdateRetentionYears(years); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update retention policy"})
+		log.Printf("API ERROR: Failed to update retention policy: %v\n", err)
+		return
+	}
+	archived, err := h.applyRetention(time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to archive expenses"})
+		log.Printf("API ERROR: Failed to archive expenses: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success", "archived": fmt.Sprint(archived)})
+}
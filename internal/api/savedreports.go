@@ -0,0 +1,369 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Saved reports feed spending data to other tools (Grafana, a spreadsheet through Apps Script, a
+// data warehouse): a filter, a grouping, and a range that are run on a schedule, with the JSON
+// result POSTed to a webhook or written to a file that the other tool picks up.
+
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// SavedReportResult is the JSON sent to the webhook or written to the file of a saved report
+type SavedReportResult struct {
+	Name        string             `json:"name"`
+	GeneratedAt time.Time          `json:"generatedAt"`
+	From        string             `json:"from,omitempty"` // first day (YYYY-MM-DD), empty for all time
+	To          string             `json:"to"`             // last day (YYYY-MM-DD)
+	Currency    string             `json:"currency"`
+	GroupBy     string             `json:"groupBy,omitempty"`
+	Count       int                `json:"count"`
+	Spent       float64            `json:"spent"`
+	Income      float64            `json:"income"`
+	Groups      []SavedReportGroup `json:"groups"` // by month, or by spend for other groupings
+}
+
+// SavedReportGroup totals the expenses of a category, tag, month, etc.
+type SavedReportGroup struct {
+	Key    string  `json:"key"` // empty for expenses without a subcategory, tag, account, or project
+	Count  int     `json:"count"`
+	Spent  float64 `json:"spent"`
+	Income float64 `json:"income"`
+}
+
+// savedReport finds a saved report by name, ignoring case
+func (h *Handler) savedReport(name string) (storage.SavedReport, bool, error) {
+	reports, err := h.storage.GetSavedReports()
+	if err != nil {
+		return storage.SavedReport{}, false, err
+	}
+	for _, report := range reports {
+		if strings.EqualFold(report.Name, name) {
+			return report, true, nil
+		}
+	}
+	return storage.SavedReport{}, false, nil
+}
+
+// runSavedReport builds the result of a saved report from the expenses in its range
+func (h *Handler) runSavedReport(report storage.SavedReport) (SavedReportResult, error) {
+	start, end, err := h.dateRange(url.Values{"period": {report.Range}})
+	if err != nil {
+		return SavedReportResult{}, err
+	}
+	expenses, err := h.storage.FindExpenses(report.Filter)
+	if err != nil {
+		return SavedReportResult{}, fmt.Errorf("failed to find expenses: %v", err)
+	}
+	expenses = h.reportable(expenses)
+	result := SavedReportResult{
+		Name:        report.Name,
+		GeneratedAt: time.Now().UTC(),
+		To:          end.AddDate(0, 0, -1).Format("2006-01-02"),
+		GroupBy:     report.GroupBy,
+		Groups:      []SavedReportGroup{},
+	}
+	if !start.IsZero() {
+		result.From = start.Format("2006-01-02")
+	}
+	if result.Currency, err = h.storage.GetCurrency(); err != nil {
+		result.Currency = "usd"
+	}
+	groups := make(map[string]*SavedReportGroup)
+	add := func(key string, expense storage.Expense) {
+		group, ok := groups[key]
+		if !ok {
+			group = &SavedReportGroup{Key: key}
+			groups[key] = group
+		}
+		group.Count++
+		if expense.Amount < 0 || expense.IsRefund() {
+			group.Spent -= expense.Amount
+		} else {
+			group.Income += expense.Amount
+		}
+	}
+	for _, expense := range expenses {
+		if expense.Date.Before(start) || !expense.Date.Before(end) {
+			continue
+		}
+		result.Count++
+		if expense.Amount < 0 || expense.IsRefund() {
+			result.Spent -= expense.Amount
+		} else {
+			result.Income += expense.Amount
+		}
+		switch report.GroupBy {
+		case "":
+		case storage.GroupByCategory:
+			add(expense.Category, expense)
+		case storage.GroupBySubCategory:
+			add(expense.SubCategory, expense)
+		case storage.GroupByAccount:
+			add(expense.Account, expense)
+		case storage.GroupByClass:
+			add(expense.ExpenseClass(), expense)
+		case storage.GroupByProject:
+			add(expense.Project, expense)
+		case storage.GroupByMonth:
+			add(expense.Date.Format("2006-01"), expense)
+		case storage.GroupByTag:
+			// an expense counts toward each of its tags
+			if len(expense.Tags) == 0 {
+				add("", expense)
+			}
+			for _, tag := range expense.Tags {
+				add(tag, expense)
+			}
+		}
+	}
+	for _, group := range groups {
+		result.Groups = append(result.Groups, *group)
+	}
+	slices.SortFunc(result.Groups, func(a, b SavedReportGroup) int {
+		if report.GroupBy == storage.GroupByMonth {
+			return strings.Compare(a.Key, b.Key)
+		}
+		return cmp.Or(cmp.Compare(b.Spent, a.Spent), strings.Compare(a.Key, b.Key))
+	})
+	return result, nil
+}
+
+// deliverSavedReport sends the result to the webhook and writes it to the file of the report
+func (h *Handler) deliverSavedReport(ctx context.Context, report storage.SavedReport, result SavedReportResult) error {
+	body, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %v", err)
+	}
+	if report.Webhook != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, report.Webhook, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create webhook request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("webhook request failed: %v", err)
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+	}
+	if report.File != "" {
+		if h.reportsDir == "" {
+			return fmt.Errorf("REPORTS_DIR is not set")
+		}
+		if err := writeFileAtomic(filepath.Join(h.reportsDir, report.File), body); err != nil {
+			return fmt.Errorf("failed to write report file: %v", err)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic replaces a file in one step, so readers never see a partly written report
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// scheduleKey names the day, ISO week, or month a report last ran in; a report is due once the
+// key of the current time differs
+func scheduleKey(schedule string, t time.Time) string {
+	switch schedule {
+	case storage.ScheduleWeekly:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case storage.ScheduleMonthly:
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
+}
+
+// reportDue reports whether a saved report hasn't run yet in the current day, week, or month
+func reportDue(report storage.SavedReport, now time.Time) bool {
+	last, err := time.Parse(time.RFC3339, report.LastRun)
+	if err != nil {
+		return true
+	}
+	return scheduleKey(report.Schedule, last.In(now.Location())) != scheduleKey(report.Schedule, now)
+}
+
+// runDueSavedReports runs and delivers the due reports, as the saved-reports job; a report that
+// fails is retried on the next run
+func (h *Handler) runDueSavedReports(ctx context.Context, now time.Time) error {
+	reports, err := h.storage.GetSavedReports()
+	if err != nil {
+		return fmt.Errorf("failed to get saved reports: %v", err)
+	}
+	ran := make(map[string]bool)
+	var failed []string
+	for _, report := range reports {
+		if !reportDue(report, now) {
+			continue
+		}
+		result, err := h.runSavedReport(report)
+		if err == nil {
+			err = h.deliverSavedReport(ctx, report, result)
+		}
+		if err != nil {
+			log.Printf("REPORT ERROR: Failed to run saved report '%s': %v\n", report.Name, err)
+			failed = append(failed, report.Name)
+			continue
+		}
+		ran[report.Name] = true
+	}
+	if len(ran) == 0 {
+		return savedReportsFailed(failed)
+	}
+
+	// read the reports again, as delivering takes a while and they may have been edited meanwhile
+	reports, err = h.storage.GetSavedReports()
+	if err != nil {
+		return fmt.Errorf("failed to get saved reports: %v", err)
+	}
+	for i := range reports {
+		if ran[reports[i].Name] {
+			reports[i].LastRun = now.Format(time.RFC3339)
+		}
+	}
+	if err := h.storage.UpdateSavedReports(reports); err != nil {
+		return fmt.Errorf("failed to record saved report runs: %v", err)
+	}
+	log.Printf("Ran %d saved report(s)\n", len(ran))
+	return savedReportsFailed(failed)
+}
+
+func savedReportsFailed(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to run saved reports %s", strings.Join(names, ", "))
+}
+
+func (h *Handler) GetSavedReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	reports, err := h.storage.GetSavedReports()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get saved reports"})
+		log.Printf("API ERROR: Failed to get saved reports: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
+
+// UpdateSavedReports replaces the saved reports; the last run of each report is kept
+func (h *Handler) UpdateSavedReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var reports []storage.SavedReport
+	if err := json.NewDecoder(r.Body).Decode(&reports); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if err := storage.ValidateSavedReports(reports); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	existing, err := h.storage.GetSavedReports()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get saved reports"})
+		log.Printf("API ERROR: Failed to get saved reports: %v\n", err)
+		return
+	}
+	lastRuns := make(map[string]string, len(existing))
+	for _, report := range existing {
+		lastRuns[strings.ToLower(report.Name)] = report.LastRun
+	}
+	for i := range reports {
+		if reports[i].File != "" && h.reportsDir == "" {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Writing reports to files needs REPORTS_DIR"})
+			return
+		}
+		reports[i].LastRun = lastRuns[strings.ToLower(reports[i].Name)]
+	}
+	if err := h.storage.UpdateSavedReports(reports); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update saved reports"})
+		log.Printf("API ERROR: Failed to update saved reports: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// PreviewSavedReport returns the result of the saved report in ?name= without delivering it
+func (h *Handler) PreviewSavedReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	h.serveSavedReport(w, r, false)
+}
+
+// RunSavedReport delivers the saved report in ?name= now; its schedule is unchanged
+func (h *Handler) RunSavedReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	h.serveSavedReport(w, r, true)
+}
+
+func (h *Handler) serveSavedReport(w http.ResponseWriter, r *http.Request, deliver bool) {
+	report, ok, err := h.savedReport(r.URL.Query().Get("name"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get saved reports"})
+		log.Printf("API ERROR: Failed to get saved reports: %v\n", err)
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Saved report not found"})
+		return
+	}
+	result, err := h.runSavedReport(report)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to run saved report"})
+		log.Printf("API ERROR: Failed to run saved report '%s': %v\n", report.Name, err)
+		return
+	}
+	if deliver {
+		if err := h.deliverSavedReport(r.Context(), report, result); err != nil {
+			writeJSON(w, http.StatusBadGateway, ErrorResponse{Error: "Failed to deliver saved report"})
+			log.Printf("API ERROR: Failed to deliver saved report '%s': %v\n", report.Name, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
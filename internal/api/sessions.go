@@ -0,0 +1,201 @@
+package api
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Sessions from magic-link login can be listed and revoked, so a lost phone doesn't stay signed in
+// until its session expires. A session also stops working when the user's role in ACCESS_ROLES
+// changes; the browser gets a new session token for the new role on its next request.
+
+// sessionTouchInterval is how stale the last use of a session gets before it's saved again, so
+// every request doesn't write to the store
+const sessionTouchInterval = 5 * time.Minute
+
+// SessionInfo describes a signed-in browser
+type SessionInfo struct {
+	ID        string    `json:"id"` // for revoking it
+	User      string    `json:"user"`
+	Device    string    `json:"device"` // user agent
+	IP        string    `json:"ip"`     // address it was last used from
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current"` // the session making the request
+}
+
+// clientIP is the address of the client, taking the first X-Forwarded-For entry when behind a proxy
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sessionUser returns the user of the request's session, empty without a valid one. It rotates the
+// session when the user's role changed since it started, and records when and where it was used.
+func (a *AccessControl) sessionUser(w http.ResponseWriter, r *http.Request) string {
+	session, ok := a.session(r)
+	if !ok {
+		return ""
+	}
+	role := a.role(session.User)
+	if session.Role != role.String() {
+		if _, err := a.magicLinks.tokens.RemoveLoginToken(session.Hash); err != nil {
+			log.Printf("HTTP ERROR: Failed to remove session: %v\n", err)
+			return ""
+		}
+		if role == RolePublic {
+			log.Printf("HTTP: Ended session of %s, who no longer has access\n", session.User)
+			return ""
+		}
+		if err := a.startSession(w, r, session.User); err != nil {
+			log.Printf("HTTP ERROR: Failed to rotate session: %v\n", err)
+			return ""
+		}
+		log.Printf("HTTP: Rotated session of %s for the %s role\n", session.User, role)
+		return session.User
+	}
+	if time.Since(session.LastUsedAt) > sessionTouchInterval {
+		session.LastUsedAt = time.Now().UTC()
+		session.Device = r.UserAgent()
+		session.IP = clientIP(r)
+		if err := a.magicLinks.tokens.UpdateLoginToken(session); err != nil {
+			log.Printf("HTTP ERROR: Failed to update session: %v\n", err)
+		}
+	}
+	return session.User
+}
+
+// sessionsOf returns the active sessions of a user, or everyone's when user is empty
+func (a *AccessControl) sessionsOf(user string) ([]storage.LoginToken, error) {
+	tokens, err := a.magicLinks.tokens.GetLoginTokens(user)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(tokens, func(t storage.LoginToken) bool {
+		return t.Kind != storage.LoginSession
+	}), nil
+}
+
+// Sessions lists the signed-in user's sessions; admins can list another user's with ?user=, or
+// everyone's with ?all=true
+func (a *AccessControl) Sessions(w http.ResponseWriter, r *http.Request) {
+	if a.magicLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	current, ok := a.session(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Not authenticated"})
+		return
+	}
+	user := current.User
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+	if other := strings.ToLower(r.URL.Query().Get("user")); all || (other != "" && other != user) {
+		if a.role(current.User) < RoleAdmin {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "Only admins can see other users' sessions"})
+			return
+		}
+		user = other
+		if all {
+			user = ""
+		}
+	}
+	sessions, err := a.sessionsOf(user)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get sessions"})
+		log.Printf("API ERROR: Failed to get sessions: %v\n", err)
+		return
+	}
+	response := []SessionInfo{}
+	for _, session := range sessions {
+		response = append(response, SessionInfo{
+			ID:        session.Hash,
+			User:      session.User,
+			Device:    session.Device,
+			IP:        session.IP,
+			CreatedAt: session.CreatedAt,
+			LastUsed:  session.LastUsedAt,
+			ExpiresAt: session.ExpiresAt,
+			Current:   session.Hash == current.Hash,
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// RevokeSession ends a session with ?id=, or all of the user's other sessions with ?others=true;
+// admins can revoke any user's sessions
+func (a *AccessControl) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if a.magicLinks == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	current, ok := a.session(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Not authenticated"})
+		return
+	}
+	sessions, err := a.sessionsOf("")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get sessions"})
+		log.Printf("API ERROR: Failed to get sessions: %v\n", err)
+		return
+	}
+	var revoke []storage.LoginToken
+	if others, _ := strconv.ParseBool(r.URL.Query().Get("others")); others {
+		for _, session := range sessions {
+			if session.User == current.User && session.Hash != current.Hash {
+				revoke = append(revoke, session)
+			}
+		}
+	} else {
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "'id' or 'others' is required"})
+			return
+		}
+		i := slices.IndexFunc(sessions, func(s storage.LoginToken) bool {
+			return s.Hash == id
+		})
+		if i < 0 {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Session not found"})
+			return
+		}
+		if sessions[i].User != current.User && a.role(current.User) < RoleAdmin {
+			writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "Only admins can revoke other users' sessions"})
+			return
+		}
+		revoke = append(revoke, sessions[i])
+	}
+	for _, session := range revoke {
+		if _, err := a.magicLinks.tokens.RemoveLoginToken(session.Hash); err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke session"})
+			log.Printf("API ERROR: Failed to revoke session: %v\n", err)
+			return
+		}
+		log.Printf("Revoked a session of %s from %s\n", session.User, session.IP)
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/i18n"
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Share links expose a single report read-only at /share/<token> until they expire, so a
+// partner or accountant can see it without access to the rest of the app. When the app is
+// behind an authenticating proxy, only /share/ needs to be let through.
+
+const (
+	shareReportPeriod = "period" // budget period summary
+	shareReportYear   = "year"   // fiscal year summary
+	shareReportTrip   = "trip"   // summary of the expenses with a tag
+
+	defaultShareDays = 7
+	maxShareDays     = 365
+)
+
+// CreateShareRequest picks the report to share
+type CreateShareRequest struct {
+	Report        string `json:"report"`          // period, year, or trip
+	Period        string `json:"period"`          // period: current or previous (default current)
+	Date          string `json:"date"`            // period: YYYY-MM-DD of any day in the period, instead of period
+	Year          int    `json:"year"`            // year: the year it starts in (default current fiscal year)
+	Tag           string `json:"tag"`             // trip: tag of the trip's expenses
+	ExpiresInDays int    `json:"expires_in_days"` // 1 to 365, default 7
+}
+
+// ShareResponse describes a share link
+type ShareResponse struct {
+	Token     string    `json:"token"`
+	Report    string    `json:"report"`
+	Title     string    `json:"title"` // e.g., "March 2026" or "Trip: lisbon"
+	URL       string    `json:"url"`   // path of the read-only report
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// newShareToken returns a random 128-bit token, hex encoded
+func newShareToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newShare validates a share request and resolves relative periods, so the link keeps showing the same report
+func (h *Handler) newShare(req CreateShareRequest, now time.Time) (storage.Share, error) {
+	share := storage.Share{Report: req.Report, CreatedAt: now.UTC()}
+	switch req.Report {
+	case shareReportPeriod:
+		periodConfig := h.periodConfig()
+		period := periodConfig.Current(now)
+		if req.Date != "" {
+			date, err := time.Parse("2006-01-02", req.Date)
+			if err != nil {
+				return share, fmt.Errorf("invalid 'date': must be YYYY-MM-DD")
+			}
+			period = periodConfig.Containing(date)
+		} else if req.Period == "previous" {
+			period = periodConfig.Previous(period)
+		} else if req.Period != "" && req.Period != "current" {
+			return share, fmt.Errorf("invalid 'period': must be current or previous")
+		}
+		share.Start = period.Start.Format("2006-01-02")
+	case shareReportYear:
+		share.Year = req.Year
+		if share.Year == 0 {
+			share.Year = periods.FiscalYear(now, h.fiscalYearStart()).Start.Year()
+		}
+		if share.Year < 1900 || share.Year > 9999 {
+			return share, fmt.Errorf("invalid 'year': must be a year such as %d", now.Year())
+		}
+	case shareReportTrip:
+		share.Tag = strings.TrimSpace(req.Tag)
+		if share.Tag == "" {
+			return share, fmt.Errorf("'tag' is required for a trip report")
+		}
+	default:
+		return share, fmt.Errorf("invalid 'report': must be period, year, or trip")
+	}
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = defaultShareDays
+	}
+	if days < 1 || days > maxShareDays {
+		return share, fmt.Errorf("invalid 'expires_in_days': must be from 1 to %d", maxShareDays)
+	}
+	share.ExpiresAt = share.CreatedAt.AddDate(0, 0, days)
+	token, err := newShareToken()
+	if err != nil {
+		return share, fmt.Errorf("failed to generate token: %v", err)
+	}
+	share.Token = token
+	return share, nil
+}
+
+// sharePeriod returns the period and title of a shared report in a language; trips cover all time
+func (h *Handler) sharePeriod(share storage.Share, lang string) (periods.Period, string) {
+	switch share.Report {
+	case shareReportYear:
+		startMonth := h.fiscalYearStart()
+		year := periods.FiscalYear(time.Date(share.Year, startMonth, 1, 0, 0, 0, 0, time.UTC), startMonth)
+		return year, year.Label()
+	case shareReportTrip:
+		return periods.Period{End: time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)}, i18n.T(lang, "report.trip", share.Tag)
+	default:
+		start, _ := time.Parse("2006-01-02", share.Start)
+		period := h.periodConfig().Containing(start)
+		return period, i18n.PeriodLabel(lang, period)
+	}
+}
+
+func (h *Handler) shareResponse(share storage.Share) ShareResponse {
+	_, title := h.sharePeriod(share, i18n.Default)
+	return ShareResponse{
+		Token:     share.Token,
+		Report:    share.Report,
+		Title:     title,
+		URL:       "/share/" + share.Token,
+		CreatedAt: share.CreatedAt,
+		ExpiresAt: share.ExpiresAt,
+	}
+}
+
+// activeShares drops expired shares
+func activeShares(shares []storage.Share, now time.Time) []storage.Share {
+	return slices.DeleteFunc(slices.Clone(shares), func(s storage.Share) bool {
+		return !now.Before(s.ExpiresAt)
+	})
+}
+
+// Shares lists the active share links (GET) or creates one (POST)
+func (h *Handler) Shares(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.listShares(w)
+	case http.MethodPost:
+		h.createShare(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+	}
+}
+
+func (h *Handler) listShares(w http.ResponseWriter) {
+	shares, err := h.storage.GetShares()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get shares"})
+		log.Printf("API ERROR: Failed to get shares: %v\n", err)
+		return
+	}
+	response := []ShareResponse{}
+	for _, share := range activeShares(shares, time.Now()) {
+		response = append(response, h.shareResponse(share))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) createShare(w http.ResponseWriter, r *http.Request) {
+	var req CreateShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	now := time.Now()
+	share, err := h.newShare(req, now)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	shares, err := h.storage.GetShares()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get shares"})
+		log.Printf("API ERROR: Failed to get shares: %v\n", err)
+		return
+	}
+	if err := h.storage.UpdateShares(append(activeShares(shares, now), share)); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save share"})
+		log.Printf("API ERROR: Failed to save share: %v\n", err)
+		return
+	}
+	log.Printf("Created %s share link expiring %s\n", share.Report, share.ExpiresAt.Format(time.RFC3339))
+	writeJSON(w, http.StatusOK, h.shareResponse(share))
+}
+
+// RevokeShare deletes a share link before it expires
+func (h *Handler) RevokeShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	token := r.URL.Query().Get("token")
+	shares, err := h.storage.GetShares()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get shares"})
+		log.Printf("API ERROR: Failed to get shares: %v\n", err)
+		return
+	}
+	remaining := slices.DeleteFunc(slices.Clone(shares), func(s storage.Share) bool { return s.Token == token })
+	if token == "" || len(remaining) == len(shares) {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Share not found"})
+		return
+	}
+	if err := h.storage.UpdateShares(remaining); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to revoke share"})
+		log.Printf("API ERROR: Failed to revoke share: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// ViewShare renders a shared report read-only; it needs no other access than the token in the path
+func (h *Handler) ViewShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	token := strings.TrimPrefix(r.URL.Path, "/share/")
+	shares, err := h.storage.GetShares()
+	if err != nil {
+		http.Error(w, "Failed to load report", http.StatusInternalServerError)
+		log.Printf("API ERROR: Failed to get shares: %v\n", err)
+		return
+	}
+	index := slices.IndexFunc(shares, func(s storage.Share) bool {
+		return subtle.ConstantTimeCompare([]byte(s.Token), []byte(token)) == 1
+	})
+	if token == "" || index < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	share := shares[index]
+	if !time.Now().Before(share.ExpiresAt) {
+		http.Error(w, "This link has expired", http.StatusGone)
+		return
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		http.Error(w, "Failed to load report", http.StatusInternalServerError)
+		log.Printf("API ERROR: Failed to retrieve expenses for shared report: %v\n", err)
+		return
+	}
+	if share.Report == shareReportTrip {
+		expenses = slices.DeleteFunc(slices.Clone(expenses), func(e storage.Expense) bool { return !slices.Contains(e.Tags, share.Tag) })
+	}
+	lang := h.reportLanguage()
+	period, title := h.sharePeriod(share, lang)
+	body, err := renderReport(h.summarizeReport(expenses, period, title), lang)
+	if err != nil {
+		http.Error(w, "Failed to render report", http.StatusInternalServerError)
+		log.Printf("API ERROR: Failed to render shared report: %v\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Write([]byte(body))
+}
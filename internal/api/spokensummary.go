@@ -0,0 +1,196 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/i18n"
+	"github.com/tanq16/expenseowl/internal/periods"
+)
+
+// The spoken summary is one sentence about today, this week, or this month, e.g., "You've spent
+// $143 of your $600 Groceries budget this month, with $457 left", for Siri Shortcuts, Tasker, and
+// voice assistants that read out whatever a URL returns. Budgets are only mentioned when the scope
+// is the budgeting period, since limits are per period.
+
+// summaryScopes are the periods the spoken summary covers
+var summaryScopes = []string{"today", "week", "month"}
+
+// SpokenSummary is the spoken summary with format=json; amounts are rounded to the format's decimals
+type SpokenSummary struct {
+	Text      string   `json:"text"`
+	Period    string   `json:"period"` // today, week, or month
+	Spent     float64  `json:"spent"`
+	Budget    *float64 `json:"budget,omitempty"` // with budgets for the period
+	Remaining *float64 `json:"remaining,omitempty"`
+}
+
+// scopePeriod is the period of a scope: the day, the week starting on the day budget weeks do
+// (Monday otherwise), or the month, which is the budget month when budgets are monthly
+func scopePeriod(scope string, budgetConfig periods.Config, now time.Time) periods.Period {
+	switch scope {
+	case "today":
+		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		return periods.Period{Start: day, End: day.AddDate(0, 0, 1)}
+	case "week":
+		if budgetConfig.Type != periods.Weekly {
+			budgetConfig = periods.Config{Type: periods.Weekly, Anchor: budgetConfig.Anchor}
+		}
+	default:
+		if budgetConfig.Type != periods.Monthly {
+			budgetConfig = periods.Default()
+		}
+	}
+	return budgetConfig.Current(now)
+}
+
+// GetSpokenSummary returns a sentence about the spending of the scope at the end of the path
+// (/api/summary/today, week, or month), as plain text or, with format=json, a tiny JSON object
+func (h *Handler) GetSpokenSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	scope := path.Base(r.URL.Path)
+	if !slices.Contains(summaryScopes, scope) {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "unknown summary period, must be today, week, or month"})
+		return
+	}
+	query := r.URL.Query()
+	asJSON := false
+	switch query.Get("format") {
+	case "", "text":
+	case "json":
+		asJSON = true
+	default:
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'format': must be text or json"})
+		return
+	}
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+	options, err := parseWidgetOptions(query, currency)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	lang := h.reportLanguage()
+	if tag := query.Get("lang"); tag != "" {
+		lang = i18n.Match(tag)
+	}
+	if lang != "" && query.Get("locale") == "" {
+		options.Format.Thousands, options.Format.Decimal = i18n.Separators(lang)
+	}
+	category := ""
+	if name := strings.TrimSpace(query.Get("category")); name != "" {
+		found, ok, err := h.findCategory(name)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get categories"})
+			log.Printf("API ERROR: Failed to get categories for spoken summary: %v\n", err)
+			return
+		}
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("unknown category '%s'", name)})
+			return
+		}
+		category = found
+	}
+	response, err := h.spokenSummary(scope, category, lang, options.Format, time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for spoken summary: %v\n", err)
+		return
+	}
+
+	log.Printf("HTTP: Served spoken summary for %s\n", scope)
+	if !asJSON {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprintln(w, response.Text)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// findCategory returns the configured category matching a name in any case
+func (h *Handler) findCategory(name string) (string, bool, error) {
+	categories, err := h.storage.GetCategories()
+	if err != nil {
+		return "", false, err
+	}
+	i := slices.IndexFunc(categories, func(c string) bool { return strings.EqualFold(c, strings.TrimSpace(name)) })
+	if i < 0 {
+		return "", false, nil
+	}
+	return categories[i], true, nil
+}
+
+// spokenSummary builds the sentence about the spending of a scope, in one category when it's set
+func (h *Handler) spokenSummary(scope, category, lang string, format numberFormat, now time.Time) (SpokenSummary, error) {
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		return SpokenSummary{}, err
+	}
+	budgetConfig := h.periodConfig()
+	period := scopePeriod(scope, budgetConfig, now)
+	_, spent, categoryTotals := summarizePeriod(h.reportable(expenses), period)
+	if category != "" {
+		spent = categoryTotals[category]
+	}
+
+	// the budgets of the whole period, or of the category, when the scope is the budgeting period
+	var budget *float64
+	if current := budgetConfig.Current(now); current.Start.Equal(period.Start) && current.End.Equal(period.End) {
+		if budgets, err := h.budgetStatus(expenses, period); err == nil {
+			var available float64
+			found := false
+			for _, b := range budgets {
+				if category == "" || b.Category == category {
+					available += b.Available
+					found = true
+				}
+			}
+			if found {
+				budget = &available
+			}
+		}
+	}
+
+	when := i18n.T(lang, "summary."+scope)
+	amount := func(value float64) string { return formatAmount(value, format) }
+	var text string
+	switch {
+	case budget == nil && category == "":
+		text = i18n.T(lang, "summary.spent", amount(spent), when)
+	case budget == nil:
+		text = i18n.T(lang, "summary.spentOn", amount(spent), category, when)
+	case category == "":
+		key := "summary.budgetLeft"
+		if spent > *budget {
+			key = "summary.budgetOver"
+		}
+		text = i18n.T(lang, key, amount(spent), amount(*budget), when, amount(math.Abs(*budget-spent)))
+	default:
+		key := "summary.categoryBudgetLeft"
+		if spent > *budget {
+			key = "summary.categoryBudgetOver"
+		}
+		text = i18n.T(lang, key, amount(spent), amount(*budget), category, when, amount(math.Abs(*budget-spent)))
+	}
+
+	scale := math.Pow(10, float64(format.Decimals))
+	round := func(value float64) float64 { return math.Round(value*scale) / scale }
+	summary := SpokenSummary{Text: text, Period: scope, Spent: round(spent)}
+	if budget != nil {
+		total, remaining := round(*budget), round(*budget-spent)
+		summary.Budget, summary.Remaining = &total, &remaining
+	}
+	return summary, nil
+}
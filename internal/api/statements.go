@@ -0,0 +1,136 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Accounts with a statement day, like a credit card closing on the 14th, are reported by their
+// own statement cycles instead of the budget periods, so each statement can be checked as it
+// was billed.
+
+// StatementReport is the response of /api/reports/statements
+type StatementReport struct {
+	Account    string             `json:"account"`
+	CloseDay   int                `json:"closeDay,omitempty"` // 0 when the account follows the budget periods
+	Currency   string             `json:"currency"`
+	Statements []StatementSummary `json:"statements"` // oldest first, ending with the one still open
+}
+
+// StatementSummary totals the transactions on an account in one statement cycle
+type StatementSummary struct {
+	Label      string             `json:"label"`          // e.g., "Feb 15 - Mar 14, 2026"
+	From       string             `json:"from"`           // first day (YYYY-MM-DD)
+	To         string             `json:"to"`             // closing day (YYYY-MM-DD)
+	Open       bool               `json:"open,omitempty"` // not closed yet
+	Count      int                `json:"count"`
+	Charges    float64            `json:"charges"`    // spending, as an absolute value
+	Credits    float64            `json:"credits"`    // payments, refunds, and income
+	Categories map[string]float64 `json:"categories"` // charges by category
+}
+
+// statementPeriods are the last n statement cycles of the account, or budget periods without a statement day
+func (h *Handler) statementPeriods(account storage.Account, n int, now time.Time) []periods.Period {
+	if account.StatementDay > 0 {
+		return periods.LastStatements(n, account.StatementDay, now)
+	}
+	return h.periodConfig().Last(n, now)
+}
+
+// summarizeStatements totals the account's transactions in each of the periods
+func summarizeStatements(account storage.Account, expenses []storage.Expense, periodList []periods.Period, now time.Time) []StatementSummary {
+	round := func(value float64) float64 { return math.Round(value*100) / 100 }
+	statements := make([]StatementSummary, len(periodList))
+	for i, period := range periodList {
+		statement := StatementSummary{
+			Label:      period.Label(),
+			From:       period.Start.Format("2006-01-02"),
+			To:         period.End.AddDate(0, 0, -1).Format("2006-01-02"),
+			Open:       period.Contains(now),
+			Categories: map[string]float64{},
+		}
+		for _, expense := range expenses {
+			if !strings.EqualFold(expense.Account, account.Name) || !period.Contains(expense.Date) {
+				continue
+			}
+			statement.Count++
+			if expense.Amount < 0 {
+				statement.Charges -= expense.Amount
+				statement.Categories[expense.Category] -= expense.Amount
+			} else {
+				statement.Credits += expense.Amount
+			}
+		}
+		statement.Charges, statement.Credits = round(statement.Charges), round(statement.Credits)
+		for category, charged := range statement.Categories {
+			statement.Categories[category] = round(charged)
+		}
+		statements[i] = statement
+	}
+	return statements
+}
+
+// configuredAccount returns the account configured under the name (ignoring case), or an asset
+// account of that name when there is none
+func (h *Handler) configuredAccount(name string) (storage.Account, error) {
+	accounts, err := h.storage.GetAccounts()
+	if err != nil {
+		return storage.Account{}, err
+	}
+	if i := slices.IndexFunc(accounts, func(a storage.Account) bool { return strings.EqualFold(a.Name, name) }); i >= 0 {
+		return accounts[i], nil
+	}
+	return storage.Account{Name: name, Type: storage.AccountAsset}, nil
+}
+
+// GetStatementReport returns the charges and credits on ?account= in each of its last ?months=
+// statement cycles (default 12); accounts without a statement day follow the budget periods
+func (h *Handler) GetStatementReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	query := r.URL.Query()
+	name := strings.TrimSpace(query.Get("account"))
+	if name == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "account is required"})
+		return
+	}
+	months := 12
+	if value := query.Get("months"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 120 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid 'months': must be between 1 and 120"})
+			return
+		}
+		months = parsed
+	}
+	account, err := h.configuredAccount(name)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get accounts"})
+		log.Printf("API ERROR: Failed to get accounts: %v\n", err)
+		return
+	}
+	expenses, err := h.storage.FindExpenses(storage.ExpenseFilter{Account: account.Name})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for statement report: %v\n", err)
+		return
+	}
+
+	now := time.Now()
+	report := StatementReport{Account: account.Name, CloseDay: account.StatementDay}
+	report.Statements = summarizeStatements(account, expenses, h.statementPeriods(account, months, now), now)
+	if report.Currency, err = h.storage.GetCurrency(); err != nil {
+		report.Currency = "usd"
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSuggestions = 10
+	maxSuggestions     = 50
+)
+
+// SuggestExpenseNames returns the previously used names starting with ?q=, with the category,
+// subcategory, and amount each was most often recorded with, for the add-expense form to
+// autocomplete the name and fill in the rest
+func (h *Handler) SuggestExpenseNames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	prefix := strings.TrimSpace(r.URL.Query().Get("q"))
+	if prefix == "" {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "'q' is required"})
+		return
+	}
+	limit := defaultSuggestions
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > maxSuggestions {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("'limit' must be between 1 and %d", maxSuggestions)})
+			return
+		}
+	}
+	suggestions, err := h.storage.SuggestExpenseNames(prefix, limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve suggestions"})
+		log.Printf("API ERROR: Failed to suggest expense names: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, suggestions)
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Mobile and offline clients sync expenses in both directions without downloading everything:
+// they pull the changes after the last revision they saw, and push the expenses they created,
+// edited, or deleted while offline. New expenses carry an ID generated by the client, so a push
+// that is retried after a dropped connection doesn't create duplicates. Each pushed change names
+// the revision it was based on; when the expense changed on the server since, the sync policy
+// decides: "lww" keeps whichever write happened last, and "merge" keeps the fields each side
+// changed, reporting a conflict only when both changed the same field.
+
+const (
+	syncLastWriteWins = "lww"
+	syncMerge         = "merge"
+)
+
+// SyncPullResponse is a page of the expense changes after ?since=
+type SyncPullResponse struct {
+	Revision int64                   `json:"revision"` // pass as ?since= on the next pull
+	HasMore  bool                    `json:"hasMore"`  // pull again right away for the rest
+	Changes  []storage.ExpenseChange `json:"changes"`  // oldest first, deleted expenses without the expense
+}
+
+// SyncPushRequest is the changes a client made since its last sync, applied in order
+type SyncPushRequest struct {
+	Policy  string       `json:"policy"` // lww (default) or merge
+	Changes []SyncChange `json:"changes"`
+}
+
+// SyncChange is an expense a client created, edited, or deleted
+type SyncChange struct {
+	ID           string           `json:"id"`                  // a UUID, generated by the client for a new expense
+	BaseRevision int64            `json:"baseRevision"`        // revision the client last pulled, 0 for a new expense
+	Base         *storage.Expense `json:"base,omitempty"`      // the expense at baseRevision, needed to merge an edit
+	UpdatedAt    time.Time        `json:"updatedAt,omitempty"` // when the client made the change, for lww; now if unset
+	Deleted      bool             `json:"deleted,omitempty"`
+	Expense      *storage.Expense `json:"expense,omitempty"` // the expense after the change, unless deleted
+}
+
+// SyncResult is the outcome of a pushed change: applied, conflict (the server's copy is kept and
+// returned, for the client to adopt or push again), or error (the change is invalid)
+type SyncResult struct {
+	ID       string           `json:"id"`
+	Status   string           `json:"status"`
+	Revision int64            `json:"revision"`          // of the server's copy
+	Expense  *storage.Expense `json:"expense,omitempty"` // the server's copy, nil once deleted
+	Error    string           `json:"error,omitempty"`
+}
+
+// SyncPull returns the expense changes after the revision in ?since=, at most ?limit= of them
+func (h *Handler) SyncPull(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var since int64
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		var err error
+		if since, err = strconv.ParseInt(raw, 10, 64); err != nil || since < 0 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "'since' must be a revision"})
+			return
+		}
+	}
+	limit := maxPerPage
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > maxPerPage {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("'limit' must be between 1 and %d", maxPerPage)})
+			return
+		}
+	}
+	changes, latest, err := h.storage.GetExpenseChanges(since, limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve changes"})
+		log.Printf("API ERROR: Failed to retrieve expense changes: %v\n", err)
+		return
+	}
+	response := SyncPullResponse{Revision: max(since, latest), Changes: changes}
+	if n := len(changes); n == limit && changes[n-1].Revision < latest {
+		response.Revision, response.HasMore = changes[n-1].Revision, true
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// SyncPush applies the changes of a client and returns the result of each
+func (h *Handler) SyncPush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var request SyncPushRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if request.Policy == "" {
+		request.Policy = syncLastWriteWins
+	}
+	if request.Policy != syncLastWriteWins && request.Policy != syncMerge {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "'policy' must be lww or merge"})
+		return
+	}
+	if len(request.Changes) > maxPerPage {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("cannot push more than %d changes at once", maxPerPage)})
+		return
+	}
+	// a change is checked against the server's revision and written as one step
+	h.syncMu.Lock()
+	defer h.syncMu.Unlock()
+	results := make([]SyncResult, 0, len(request.Changes))
+	applied := 0
+	for _, change := range request.Changes {
+		result := h.pushChange(r, request.Policy, change)
+		if result.Status == "applied" {
+			applied++
+		}
+		results = append(results, result)
+	}
+	log.Printf("HTTP: Synced %d of %d pushed changes\n", applied, len(request.Changes))
+	writeJSON(w, http.StatusOK, results)
+}
+
+// pushChange applies one change unless it conflicts with the server's copy
+func (h *Handler) pushChange(r *http.Request, policy string, change SyncChange) SyncResult {
+	if _, err := uuid.Parse(change.ID); err != nil {
+		return SyncResult{ID: change.ID, Status: "error", Error: "'id' must be a UUID"}
+	}
+	if !change.Deleted && change.Expense == nil {
+		return SyncResult{ID: change.ID, Status: "error", Error: "'expense' is required unless deleted"}
+	}
+	current, err := h.storage.GetExpenseChange(change.ID)
+	if err != nil {
+		log.Printf("API ERROR: Failed to get revision of expense %s: %v\n", change.ID, err)
+		return SyncResult{ID: change.ID, Status: "error", Error: "Failed to retrieve expense"}
+	}
+	conflict := func(reason string) SyncResult {
+		return SyncResult{ID: change.ID, Status: "conflict", Revision: current.Revision, Expense: current.Expense, Error: reason}
+	}
+	stale := current.Revision != change.BaseRevision
+	if stale && policy == syncLastWriteWins && !change.UpdatedAt.IsZero() && change.UpdatedAt.Before(current.UpdatedAt) {
+		return conflict("the server's copy was changed later")
+	}
+	confirm := url.QueryEscape(r.URL.Query().Get("confirm"))
+	query := "?confirm=" + confirm + "&id=" + url.QueryEscape(change.ID)
+
+	var status int
+	var message string
+	switch {
+	case change.Deleted && current.Expense == nil:
+		// already deleted, or never synced
+		return SyncResult{ID: change.ID, Status: "applied", Revision: current.Revision}
+	case change.Deleted:
+		if stale && policy == syncMerge {
+			return conflict(fmt.Sprintf("changed on the server since revision %d", change.BaseRevision))
+		}
+		status, message = callAPI(r, h.DeleteExpense, http.MethodDelete, "/expense/delete"+query, nil)
+	case current.Expense == nil:
+		if current.DeletedAt != nil && stale && policy == syncMerge {
+			return conflict("deleted on the server")
+		}
+		expense := *change.Expense
+		expense.ID = change.ID
+		status, message = callAPI(r, h.AddExpense, http.MethodPut, "/expense"+query, expense)
+	default:
+		expense := *change.Expense
+		if stale && policy == syncMerge {
+			if change.Base == nil {
+				return conflict("'base' is required to merge a stale change")
+			}
+			var conflicts []string
+			if expense, conflicts, err = mergeExpense(*change.Base, expense, *current.Expense); err != nil {
+				return SyncResult{ID: change.ID, Status: "error", Error: err.Error()}
+			} else if len(conflicts) > 0 {
+				return conflict("both sides changed " + strings.Join(conflicts, ", "))
+			}
+		}
+		expense.ID, expense.UpdatedAt = change.ID, nil // conflicts are handled by revision above
+		status, message = callAPI(r, h.EditExpense, http.MethodPut, "/expense/edit"+query, expense)
+	}
+	if status >= 300 {
+		return SyncResult{ID: change.ID, Status: "error", Revision: current.Revision, Expense: current.Expense, Error: message}
+	}
+	updated, err := h.storage.GetExpenseChange(change.ID)
+	if err != nil {
+		log.Printf("API ERROR: Failed to get revision of expense %s: %v\n", change.ID, err)
+		return SyncResult{ID: change.ID, Status: "applied"}
+	}
+	return SyncResult{ID: change.ID, Status: "applied", Revision: updated.Revision, Expense: updated.Expense}
+}
+
+// mergeExpense applies the fields the client changed since base to the server's copy; fields
+// that both sides changed to different values are returned as conflicts
+func mergeExpense(base, client, server storage.Expense) (storage.Expense, []string, error) {
+	var fields [3]map[string]json.RawMessage
+	for i, expense := range []storage.Expense{base, client, server} {
+		expense.ID, expense.CreatedAt, expense.UpdatedAt = "", nil, nil // set by the store
+		content, err := json.Marshal(expense)
+		if err != nil {
+			return storage.Expense{}, nil, err
+		}
+		if err := json.Unmarshal(content, &fields[i]); err != nil {
+			return storage.Expense{}, nil, err
+		}
+	}
+	baseFields, clientFields, serverFields := fields[0], fields[1], fields[2]
+	merged := maps.Clone(serverFields)
+	var conflicts []string
+	keys := slices.Concat(slices.Collect(maps.Keys(baseFields)), slices.Collect(maps.Keys(clientFields)))
+	slices.Sort(keys)
+	for _, key := range slices.Compact(keys) {
+		value, changed := clientFields[key]
+		if bytes.Equal(value, baseFields[key]) {
+			continue
+		}
+		if serverValue := serverFields[key]; !bytes.Equal(serverValue, baseFields[key]) && !bytes.Equal(serverValue, value) {
+			conflicts = append(conflicts, key)
+			continue
+		}
+		if changed {
+			merged[key] = value
+		} else {
+			delete(merged, key)
+		}
+	}
+	if len(conflicts) > 0 {
+		return storage.Expense{}, conflicts, nil
+	}
+	content, err := json.Marshal(merged)
+	if err != nil {
+		return storage.Expense{}, nil, err
+	}
+	var expense storage.Expense
+	if err := json.Unmarshal(content, &expense); err != nil {
+		return storage.Expense{}, nil, err
+	}
+	return expense, nil, nil
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Expenses can record the tax (e.g., VAT) included in their amount, which stays the gross
+// amount everywhere else. Categories with a tax rate split it off new expenses automatically,
+// and the tax report sums it by quarter: tax paid on expenses is reclaimable (or deductible),
+// tax on income is collected, and the difference is what is owed for the quarter.
+
+// TaxReport sums the tax included in expenses and income by quarter of a fiscal or calendar year
+type TaxReport struct {
+	Year     string       `json:"year"`  // e.g., "2026" or "FY 2025-26"
+	Start    string       `json:"start"` // YYYY-MM-DD
+	End      string       `json:"end"`   // YYYY-MM-DD, inclusive
+	Currency string       `json:"currency"`
+	Quarters []TaxQuarter `json:"quarters"`
+	Total    TaxQuarter   `json:"total"`
+}
+
+// TaxQuarter is the tax of a quarter, or of the whole year for the total
+type TaxQuarter struct {
+	Quarter     int                `json:"quarter,omitempty"` // 1 to 4, left out for the total
+	Start       string             `json:"start"`             // YYYY-MM-DD
+	End         string             `json:"end"`               // YYYY-MM-DD, inclusive
+	Reclaimable float64            `json:"reclaimable"`       // included in expenses
+	Collected   float64            `json:"collected"`         // included in income
+	Net         float64            `json:"net"`               // collected minus reclaimable, owed when positive
+	Categories  map[string]float64 `json:"categories"`        // reclaimable tax by expense category
+}
+
+// applyTaxRate splits the tax rate of the expense's category off an expense without tax details
+func (h *Handler) applyTaxRate(expense *storage.Expense) {
+	if expense.Tax != nil {
+		return
+	}
+	meta, err := h.storage.GetCategoryMeta()
+	if err != nil {
+		log.Printf("Warning: Could not retrieve category metadata: %v\n", err)
+		return
+	}
+	if rate := meta[expense.Category].TaxRate; rate > 0 {
+		expense.Tax = &storage.TaxDetails{Rate: rate}
+	}
+}
+
+// expenseTax is the tax included in an expense: its own, or else split at its category's rate,
+// so imported and recurring expenses count too
+func expenseTax(expense storage.Expense, meta map[string]storage.CategoryMeta) float64 {
+	tax := expense.Tax
+	if tax == nil {
+		tax = &storage.TaxDetails{Rate: meta[expense.Category].TaxRate}
+	}
+	if tax.Validate(expense.Amount) != nil {
+		return 0
+	}
+	return tax.Amount
+}
+
+// GetTaxReport returns the tax by quarter of ?year= (fiscal, or calendar with ?calendar=true);
+// the search filters of /expenses narrow it down, e.g., ?class=business
+func (h *Handler) GetTaxReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	year, err := h.reportYear(r.URL.Query(), time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	filter, err := expenseFilter(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	expenses, err := h.storage.FindExpenses(filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for tax report: %v\n", err)
+		return
+	}
+	meta, err := h.storage.GetCategoryMeta()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get category metadata"})
+		log.Printf("API ERROR: Failed to get category metadata: %v\n", err)
+		return
+	}
+	expenses = reportableExpenses(expenses, meta)
+	report := TaxReport{
+		Year:  year.Label(),
+		Start: year.Start.Format("2006-01-02"),
+		End:   year.End.AddDate(0, 0, -1).Format("2006-01-02"),
+		Total: TaxQuarter{Start: year.Start.Format("2006-01-02"), End: year.End.AddDate(0, 0, -1).Format("2006-01-02"), Categories: map[string]float64{}},
+	}
+	if report.Currency, err = h.storage.GetCurrency(); err != nil {
+		report.Currency = "usd"
+	}
+	for i := range 4 {
+		start := year.Start.AddDate(0, 3*i, 0)
+		end := start.AddDate(0, 3, 0)
+		quarter := TaxQuarter{Quarter: i + 1, Start: start.Format("2006-01-02"), End: end.AddDate(0, 0, -1).Format("2006-01-02"), Categories: map[string]float64{}}
+		for _, expense := range expenses {
+			if expense.Date.Before(start) || !expense.Date.Before(end) {
+				continue
+			}
+			tax := expenseTax(expense, meta)
+			if tax == 0 {
+				continue
+			}
+			if expense.Amount < 0 {
+				quarter.Reclaimable += tax
+				quarter.Categories[expense.Category] += tax
+			} else {
+				quarter.Collected += tax
+			}
+		}
+		roundTaxQuarter(&quarter)
+		report.Total.Reclaimable += quarter.Reclaimable
+		report.Total.Collected += quarter.Collected
+		for category, tax := range quarter.Categories {
+			report.Total.Categories[category] += tax
+		}
+		report.Quarters = append(report.Quarters, quarter)
+	}
+	roundTaxQuarter(&report.Total)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// roundTaxQuarter rounds the sums to cents and works out the net tax
+func roundTaxQuarter(quarter *TaxQuarter) {
+	round := func(value float64) float64 { return math.Round(value*100) / 100 }
+	quarter.Reclaimable, quarter.Collected = round(quarter.Reclaimable), round(quarter.Collected)
+	quarter.Net = round(quarter.Collected - quarter.Reclaimable)
+	for category, tax := range quarter.Categories {
+		quarter.Categories[category] = round(tax)
+	}
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+const telegramAPIBase = "https://api.telegram.org/bot"
+
+// TelegramBot long-polls the Telegram Bot API to add and query expenses from chat
+type TelegramBot struct {
+	handler      *Handler
+	token        string
+	allowedChats map[int64]bool // the only chats the bot answers, never empty
+	client       *http.Client
+	offset       int64
+}
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+type telegramMessage struct {
+	Chat struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+type telegramUpdatesResponse struct {
+	OK     bool             `json:"ok"`
+	Result []telegramUpdate `json:"result"`
+}
+
+// NewTelegramBot creates a bot for the given token that only answers the chats in chatIDs, a comma
+// separated allowlist; without one, anyone who finds the bot could read and add expenses
+func NewTelegramBot(h *Handler, token string, chatIDs string) (*TelegramBot, error) {
+	allowed := make(map[int64]bool)
+	for _, idStr := range splitAndTrim(chatIDs, ",") {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Telegram chat ID '%s' in TELEGRAM_CHAT_IDS", idStr)
+		}
+		allowed[id] = true
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("TELEGRAM_CHAT_IDS must list the chats the bot answers")
+	}
+	return &TelegramBot{
+		handler:      h,
+		token:        token,
+		allowedChats: allowed,
+		client:       &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+// Run polls for updates until the context is canceled
+func (b *TelegramBot) Run(ctx context.Context) {
+	log.Println("Starting Telegram bot")
+	for ctx.Err() == nil {
+		updates, err := b.getUpdates(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("TELEGRAM ERROR: Failed to get updates: %v\n", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Second):
+			}
+			continue
+		}
+		for _, update := range updates {
+			b.offset = update.UpdateID + 1
+			if update.Message == nil || update.Message.Text == "" {
+				continue
+			}
+			chatID := update.Message.Chat.ID
+			if !b.allowedChats[chatID] {
+				log.Printf("TELEGRAM: Ignoring message from unauthorized chat %d\n", chatID)
+				continue
+			}
+			if !b.handler.runtimeSettings().Notifications.Telegram {
+				continue // switched off in the runtime settings; the message is dropped
+			}
+			for _, reply := range b.handleMessage(update.Message.Text) {
+				if err := b.sendMessage(chatID, reply); err != nil {
+					log.Printf("TELEGRAM ERROR: Failed to send message: %v\n", err)
+				}
+			}
+		}
+	}
+}
+
+// getUpdates long-polls for new messages; canceling the context ends the poll
+func (b *TelegramBot) getUpdates(ctx context.Context) ([]telegramUpdate, error) {
+	params := url.Values{}
+	params.Set("timeout", "30")
+	params.Set("offset", strconv.FormatInt(b.offset, 10))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, telegramAPIBase+b.token+"/getUpdates?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var data telegramUpdatesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	if !data.OK {
+		return nil, fmt.Errorf("telegram API returned status %d", resp.StatusCode)
+	}
+	return data.Result, nil
+}
+
+func (b *TelegramBot) sendMessage(chatID int64, text string) error {
+	body, err := json.Marshal(map[string]any{"chat_id": chatID, "text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Post(telegramAPIBase+b.token+"/sendMessage", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("telegram API returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// handleMessage processes one chat message and returns the replies to send
+func (b *TelegramBot) handleMessage(text string) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	command := strings.ToLower(strings.Fields(text)[0])
+	// commands may be addressed to the bot as /month@botname
+	command, _, _ = strings.Cut(command, "@")
+	switch command {
+	case "/start", "/help":
+		return []string{telegramHelp}
+	case "/month":
+		summary, err := b.monthSummary()
+		if err != nil {
+			log.Printf("TELEGRAM ERROR: Failed to build month summary: %v\n", err)
+			return []string{"Failed to build the summary for this period"}
+		}
+		return []string{summary}
+	}
+	expense, err := b.parseExpense(text)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if err := b.handler.storage.AddExpense(expense); err != nil {
+		log.Printf("TELEGRAM ERROR: Failed to save expense: %v\n", err)
+		return []string{"Failed to save expense"}
+	}
+	b.handler.notifyExpenseAdded(expense)
+	replies := []string{fmt.Sprintf("Added %s: %.2f (%s)", expense.Name, expense.Amount, expense.Category)}
+	if alert := b.budgetAlert(expense); alert != "" {
+		replies = append(replies, alert)
+	}
+	return replies
+}
+
+const telegramHelp = `Send an expense as "<amount> <name> [#category]", e.g. "12.50 lunch #Food".
+Prefix the amount with + to record income, e.g. "+2000 salary #Income".
+Without a #category, mapping rules are tried and then Miscellaneous is used.
+
+/month - summary for the current period`
+
+// parseExpense turns "12.50 lunch #Food" into an expense
+func (b *TelegramBot) parseExpense(text string) (storage.Expense, error) {
+	fields := strings.Fields(text)
+	amountStr := fields[0]
+	isIncome := strings.HasPrefix(amountStr, "+")
+	amount, err := strconv.ParseFloat(strings.TrimPrefix(amountStr, "+"), 64)
+	if err != nil || amount <= 0 {
+		return storage.Expense{}, fmt.Errorf("could not read an amount from '%s', send /help for the format", amountStr)
+	}
+	if !isIncome {
+		amount = -amount
+	}
+
+	var nameParts []string
+	var category string
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, "#") && len(field) > 1 {
+			category = field[1:]
+			continue
+		}
+		nameParts = append(nameParts, field)
+	}
+	name := strings.Join(nameParts, " ")
+
+	categories, err := b.handler.storage.GetCategories()
+	if err != nil {
+		return storage.Expense{}, fmt.Errorf("failed to retrieve categories")
+	}
+	if category != "" {
+		// match configured categories case-insensitively
+		idx := slices.IndexFunc(categories, func(c string) bool { return strings.EqualFold(c, category) })
+		if idx < 0 {
+			return storage.Expense{}, fmt.Errorf("unknown category '%s'", category)
+		}
+		category = categories[idx]
+	}
+
+	expense := storage.Expense{
+		Name:     name,
+		Category: category,
+		Amount:   amount,
+		Date:     time.Now(),
+	}
+	b.handler.resolveCategory(&expense, categories)
+	if expense.Name == "" {
+		expense.Name = expense.Category
+	}
+	if err := expense.Validate(); err != nil {
+		return storage.Expense{}, err
+	}
+	return expense, nil
+}
+
+func (b *TelegramBot) monthSummary() (string, error) {
+	expenses, err := b.handler.storage.GetAllExpenses()
+	if err != nil {
+		return "", err
+	}
+	currency, err := b.handler.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+	period := b.handler.periodConfig().Current(time.Now())
+	totalIncome, totalExpenses, categoryTotals := summarizePeriod(b.handler.reportable(expenses), period)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s (%s)\n", period.Label(), strings.ToUpper(currency))
+	fmt.Fprintf(&sb, "Income: %.2f\nExpenses: %.2f\nBalance: %.2f\n", totalIncome, totalExpenses, totalIncome-totalExpenses)
+	for _, category := range getTopCategories(categoryTotals, totalExpenses, 5) {
+		fmt.Fprintf(&sb, "\n%s: %.2f (%.0f%%)", category.Name, category.Amount, category.Percentage)
+	}
+	return sb.String(), nil
+}
+
+// budgetAlert reports when an expense takes its category past the configured budget
+func (b *TelegramBot) budgetAlert(expense storage.Expense) string {
+	if expense.Amount >= 0 {
+		return ""
+	}
+	budgets, err := b.handler.storage.GetBudgets()
+	if err != nil {
+		return ""
+	}
+	limit, ok := budgets[expense.Category]
+	if !ok {
+		return ""
+	}
+	expenses, err := b.handler.storage.GetAllExpenses()
+	if err != nil {
+		return ""
+	}
+	period := b.handler.periodConfig().Current(time.Now())
+	_, _, categoryTotals := summarizePeriod(b.handler.reportable(expenses), period)
+	spent := categoryTotals[expense.Category]
+	// only alert on the expense that crosses the limit
+	if spent > limit && spent+expense.Amount <= limit {
+		return fmt.Sprintf("Budget exceeded for %s: %.2f of %.2f spent this period", expense.Category, spent, limit)
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Soft multi-tenancy hosts several households on one deployment. A tenant is not a user: it is a
+// household with its own storage (config, categories, expenses, and everything else), handler,
+// and access control, so a request only ever reaches the data of the tenant it was routed to.
+// TENANTS lists them, e.g., "smiths,joneses", and requests are routed by subdomain
+// (smiths.expenses.example.com) or by path prefix (/t/smiths/...). Path prefixes only serve the
+// API: the pages' links and fetches are absolute, so the web UI of a tenant needs its subdomain.
+
+const tenantPathPrefix = "/t/"
+
+// TenantsFromEnv reads the tenant names in TENANTS; nil without multi-tenancy
+func TenantsFromEnv() ([]string, error) {
+	var tenants []string
+	for _, tenant := range splitAndTrim(os.Getenv("TENANTS"), ",") {
+		tenant = strings.ToLower(tenant)
+		if !storage.RETenantName.MatchString(tenant) {
+			return nil, fmt.Errorf("invalid tenant '%s', must be up to 32 lowercase letters, digits, and inner hyphens", tenant)
+		}
+		for _, seen := range tenants {
+			if seen == tenant {
+				return nil, fmt.Errorf("tenant '%s' is listed twice", tenant)
+			}
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, nil
+}
+
+// tenantEnv reads the setting of a tenant, NAME_<TENANT> with the tenant in upper case and "-" as
+// "_", falling back to the NAME every tenant shares
+func tenantEnv(tenant string, name string) string {
+	if value := os.Getenv(tenantEnvName(tenant, name)); value != "" {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// tenantEnvName is the name of a tenant's own setting, e.g., ACCESS_ROLES_SMITHS
+func tenantEnvName(tenant string, name string) string {
+	return name + "_" + strings.ToUpper(strings.ReplaceAll(tenant, "-", "_"))
+}
+
+// tenantPublicURL is where a tenant is reached: PUBLIC_URL_<TENANT> when set, else its subdomain
+// of PUBLIC_URL (https://smiths.budget.example.com for https://budget.example.com)
+func tenantPublicURL(tenant string) string {
+	publicURL := os.Getenv("PUBLIC_URL")
+	if own := tenantEnv(tenant, "PUBLIC_URL"); own != publicURL {
+		return own
+	}
+	u, err := url.Parse(publicURL)
+	if err != nil || u.Host == "" {
+		return publicURL
+	}
+	u.Host = tenant + "." + u.Host
+	return u.String()
+}
+
+// NewTenantHandler creates the handler of a tenant: its tokens can be set apart with
+// EMAIL_INGEST_TOKEN_<TENANT> and FEED_TOKEN_<TENANT>, it publishes to its own MQTT topic,
+// writes saved reports to its own directory, and has its own budget pass
+func NewTenantHandler(tenant string, s storage.Storage) *Handler {
+	h := NewHandler(s)
+	h.emailIngestToken = tenantEnv(tenant, "EMAIL_INGEST_TOKEN")
+	h.feedToken = tenantEnv(tenant, "FEED_TOKEN")
+	h.mqttTopic += "/" + tenant
+	if h.reportsDir != "" {
+		h.reportsDir = filepath.Join(h.reportsDir, tenant)
+	}
+	if h.wallet != nil {
+		h.wallet = h.wallet.forTenant(tenant)
+	}
+	return h
+}
+
+// TenantRouter sends each request to the handler of its tenant, picked by the first label of the
+// host, then by a /t/<tenant>/ path prefix (which is stripped)
+type TenantRouter struct {
+	tenants map[string]http.Handler
+}
+
+// NewTenantRouter routes to the handlers of tenants by name
+func NewTenantRouter(tenants map[string]http.Handler) *TenantRouter {
+	return &TenantRouter{tenants: tenants}
+}
+
+type tenantPrefixContextKey struct{}
+
+// tenantPrefix returns the path prefix a request was routed to its tenant by, empty otherwise
+func tenantPrefix(r *http.Request) string {
+	prefix, _ := r.Context().Value(tenantPrefixContextKey{}).(string)
+	return prefix
+}
+
+func (t *TenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	if label, _, ok := strings.Cut(host, "."); ok {
+		if next, ok := t.tenants[strings.ToLower(label)]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+	}
+	if rest, ok := strings.CutPrefix(r.URL.Path, tenantPathPrefix); ok {
+		tenant, path, found := strings.Cut(rest, "/")
+		next, ok := t.tenants[tenant]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Unknown tenant"})
+			return
+		}
+		if !found {
+			http.Redirect(w, r, tenantPathPrefix+tenant+"/", http.StatusMovedPermanently)
+			return
+		}
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: fmt.Sprintf("The pages of %s are on its subdomain, %s.<host>; /t/%s/ only serves the API", tenant, tenant, tenant)})
+			return
+		}
+		prefix := tenantPathPrefix + tenant
+		routed := r.WithContext(context.WithValue(r.Context(), tenantPrefixContextKey{}, prefix))
+		routed.URL = new(url.URL)
+		*routed.URL = *r.URL
+		routed.URL.Path, routed.URL.RawPath = "/"+path, ""
+		next.ServeHTTP(w, routed)
+		return
+	}
+	writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Unknown tenant"})
+}
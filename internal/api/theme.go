@@ -0,0 +1,44 @@
+package api
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// serveThemeCSS generates /theme.css: the requesting user's chart palette as CSS variables
+// (--chart-color-1 and on), and their theme as the color-scheme, so every device they sign in
+// from looks the same and custom stylesheets can use the palette
+func (h *Handler) serveThemeCSS(w http.ResponseWriter, r *http.Request) {
+	config, err := h.storage.GetConfig()
+	if err != nil {
+		http.Error(w, "Failed to get config", http.StatusInternalServerError)
+		log.Printf("API ERROR: Failed to get config: %v\n", err)
+		return
+	}
+	preferences := config.Preferences[requestUser(r)]
+	colorScheme := "light dark"
+	switch cmp.Or(preferences.Theme, config.RuntimeSettings().UI.DefaultTheme) {
+	case "light":
+		colorScheme = "light"
+	case "dark":
+		colorScheme = "dark"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("/* Generated from the theme and chart palette settings */\n:root {\n")
+	fmt.Fprintf(&sb, "    color-scheme: %s;\n", colorScheme)
+	palette := chartPalette(config, preferences)
+	for i, color := range palette {
+		fmt.Fprintf(&sb, "    --chart-color-%d: %s;\n", i+1, color)
+	}
+	fmt.Fprintf(&sb, "    --chart-color-count: %d;\n}\n", len(palette))
+	// a theme picked in the browser wins, like it does for the colors in style.css
+	sb.WriteString(":root[data-theme=\"light\"] { color-scheme: light; }\n:root[data-theme=\"dark\"] { color-scheme: dark; }\n")
+
+	w.Header().Set("Content-Type", "text/css")
+	w.Header().Set("Cache-Control", "no-cache") // changes with the settings
+	w.Write([]byte(sb.String()))
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/tanq16/expenseowl/internal/i18n"
+)
+
+// ServeTranslations serves a language's catalog for the web UI at /i18n/<lang>.json, with English
+// text for the keys it doesn't translate
+func (h *Handler) ServeTranslations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	lang, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/i18n/"), ".json")
+	if !ok || !i18n.Supported(lang) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, http.StatusOK, i18n.Catalog(lang))
+}
+
+// GetLanguages lists the languages the web UI and reports can use
+func (h *Handler) GetLanguages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, i18n.Languages)
+}
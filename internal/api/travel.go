@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Mileage and per-diem expenses compute their amount from a distance or a number of days.
+// Entries without a rate use the configured default, which is then kept on the expense.
+
+func (h *Handler) GetTravelRates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	rates, err := h.storage.GetTravelRates()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get travel rates"})
+		log.Printf("API ERROR: Failed to get travel rates: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rates)
+}
+
+func (h *Handler) UpdateTravelRates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var rates storage.TravelRates
+	if err := json.NewDecoder(r.Body).Decode(&rates); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if err := storage.ValidateTravelRates(&rates); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.UpdateTravelRates(rates); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update travel rates"})
+		log.Printf("API ERROR: Failed to update travel rates: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// applyTravelRates fills in the default rate and distance unit of a mileage or per-diem expense
+func (h *Handler) applyTravelRates(expense *storage.Expense) {
+	travel := expense.Travel
+	if travel == nil {
+		return
+	}
+	rates, err := h.storage.GetTravelRates()
+	if err != nil {
+		log.Printf("Warning: Could not retrieve travel rates: %v\n", err)
+		return
+	}
+	switch travel.Type {
+	case storage.TravelMileage:
+		if travel.Rate == 0 {
+			travel.Rate = rates.MileageRate
+		}
+		if travel.Unit == "" {
+			travel.Unit = rates.DistanceUnit
+		}
+	case storage.TravelPerDiem:
+		if travel.Rate == 0 {
+			travel.Rate = rates.PerDiemRate
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// TRMNL profiles let several displays poll different slices of the data, e.g., a kitchen display
+// showing the week's groceries and an office one the month's budgets. Each has its own token, so
+// /api/trmnl/profile doesn't need the display to get past access control, and a lost display
+// can be revoked on its own. Only a hash of the token is stored; it's shown once, when created.
+
+// TRMNLProfileRequest creates a profile or changes its options
+type TRMNLProfileRequest struct {
+	Name    string `json:"name"`
+	Options string `json:"options"` // /api/trmnl query parameters, e.g., period=week&categories=Food&budgets=true
+}
+
+// TRMNLProfileResponse describes a profile; the token and polling URL are only returned when it's created
+type TRMNLProfileResponse struct {
+	Name      string    `json:"name"`
+	Options   string    `json:"options"`
+	Token     string    `json:"token,omitempty"`
+	URL       string    `json:"url,omitempty"` // path to poll, with the token
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func trmnlProfileResponse(profile storage.TRMNLProfile) TRMNLProfileResponse {
+	return TRMNLProfileResponse{Name: profile.Name, Options: profile.Options, CreatedAt: profile.CreatedAt}
+}
+
+// normalizeTRMNLOptions checks a profile's options like /api/trmnl does and re-encodes them
+func normalizeTRMNLOptions(options string) (string, error) {
+	query, err := url.ParseQuery(strings.TrimPrefix(strings.TrimSpace(options), "?"))
+	if err != nil {
+		return "", fmt.Errorf("invalid 'options': must be query parameters, e.g., period=week&top=3")
+	}
+	if _, err := parseTRMNLOptions(query); err != nil {
+		return "", err
+	}
+	return query.Encode(), nil
+}
+
+// TRMNLProfiles lists the profiles (GET) or creates one (POST)
+func (h *Handler) TRMNLProfiles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		profiles, err := h.storage.GetTRMNLProfiles()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get TRMNL profiles"})
+			log.Printf("API ERROR: Failed to get TRMNL profiles: %v\n", err)
+			return
+		}
+		response := make([]TRMNLProfileResponse, len(profiles))
+		for i, profile := range profiles {
+			response[i] = trmnlProfileResponse(profile)
+		}
+		writeJSON(w, http.StatusOK, response)
+	case http.MethodPost:
+		h.createTRMNLProfile(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+	}
+}
+
+func (h *Handler) createTRMNLProfile(w http.ResponseWriter, r *http.Request) {
+	var req TRMNLProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	options, err := normalizeTRMNLOptions(req.Options)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	token, err := newShareToken()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate token"})
+		log.Printf("API ERROR: Failed to generate TRMNL token: %v\n", err)
+		return
+	}
+	profile := storage.TRMNLProfile{Name: storage.SanitizeString(req.Name), TokenHash: hashLoginToken(token), Options: options, CreatedAt: time.Now().UTC()}
+	profiles, err := h.storage.GetTRMNLProfiles()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get TRMNL profiles"})
+		log.Printf("API ERROR: Failed to get TRMNL profiles: %v\n", err)
+		return
+	}
+	profiles = append(profiles, profile)
+	if err := storage.ValidateTRMNLProfiles(profiles); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.UpdateTRMNLProfiles(profiles); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save TRMNL profile"})
+		log.Printf("API ERROR: Failed to save TRMNL profile: %v\n", err)
+		return
+	}
+	log.Printf("Created TRMNL profile %s\n", profile.Name)
+	response := trmnlProfileResponse(profile)
+	response.Token = token
+	response.URL = "/api/trmnl/profile?token=" + token
+	writeJSON(w, http.StatusOK, response)
+}
+
+// UpdateTRMNLProfile replaces the options of the profile named in the body, keeping its token
+func (h *Handler) UpdateTRMNLProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var req TRMNLProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	options, err := normalizeTRMNLOptions(req.Options)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	profiles, err := h.storage.GetTRMNLProfiles()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get TRMNL profiles"})
+		log.Printf("API ERROR: Failed to get TRMNL profiles: %v\n", err)
+		return
+	}
+	i := slices.IndexFunc(profiles, func(p storage.TRMNLProfile) bool { return strings.EqualFold(p.Name, strings.TrimSpace(req.Name)) })
+	if i < 0 {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "TRMNL profile not found"})
+		return
+	}
+	profiles[i].Options = options
+	if err := h.storage.UpdateTRMNLProfiles(profiles); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save TRMNL profile"})
+		log.Printf("API ERROR: Failed to save TRMNL profile: %v\n", err)
+		return
+	}
+	log.Printf("HTTP: Updated TRMNL profile %s\n", profiles[i].Name)
+	writeJSON(w, http.StatusOK, trmnlProfileResponse(profiles[i]))
+}
+
+// RemoveTRMNLProfile deletes the profile in ?name=, revoking its token
+func (h *Handler) RemoveTRMNLProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	name := r.URL.Query().Get("name")
+	profiles, err := h.storage.GetTRMNLProfiles()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get TRMNL profiles"})
+		log.Printf("API ERROR: Failed to get TRMNL profiles: %v\n", err)
+		return
+	}
+	kept := slices.DeleteFunc(slices.Clone(profiles), func(p storage.TRMNLProfile) bool { return strings.EqualFold(p.Name, name) })
+	if len(kept) == len(profiles) {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "TRMNL profile not found"})
+		return
+	}
+	if err := h.storage.UpdateTRMNLProfiles(kept); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to remove TRMNL profile"})
+		log.Printf("API ERROR: Failed to remove TRMNL profile: %v\n", err)
+		return
+	}
+	log.Printf("HTTP: Removed TRMNL profile %s\n", name)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// GetTRMNLProfileData serves the TRMNL payload of the profile whose token is in ?token=
+func (h *Handler) GetTRMNLProfileData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	profiles, err := h.storage.GetTRMNLProfiles()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get TRMNL profiles"})
+		log.Printf("API ERROR: Failed to get TRMNL profiles: %v\n", err)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	hash := hashLoginToken(token)
+	i := slices.IndexFunc(profiles, func(p storage.TRMNLProfile) bool {
+		return subtle.ConstantTimeCompare([]byte(p.TokenHash), []byte(hash)) == 1
+	})
+	if token == "" || i < 0 {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid or revoked TRMNL token"})
+		return
+	}
+	query, _ := url.ParseQuery(profiles[i].Options)
+	options, err := parseTRMNLOptions(query)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Invalid TRMNL profile options"})
+		log.Printf("API ERROR: Invalid options of TRMNL profile %s: %v\n", profiles[i].Name, err)
+		return
+	}
+	response, err := h.trmnlData(options)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for TRMNL: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+	log.Printf("HTTP: Served TRMNL data for profile %s\n", profiles[i].Name)
+}
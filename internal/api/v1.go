@@ -0,0 +1,313 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// The /api/v1 routes are the stable API for scripts and integrations. Each one serves the same
+// handler as an unversioned route, which keeps working for the web UI and existing setups (e.g.,
+// TRMNL), but JSON responses are wrapped in an Envelope. Within v1, fields are only ever added;
+// anything that breaks clients (renamed fields, path parameters, multi-user scoping) goes to /api/v2.
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// v1Routes maps each /api/v1 route to the unversioned route that serves it
+var v1Routes = map[string]string{
+	// Config
+	"/api/v1/config":               "/config",
+	"/api/v1/categories":           "/categories",
+	"/api/v1/categories/edit":      "/categories/edit",
+	"/api/v1/categories/archived":  "/categories/archived",
+	"/api/v1/categories/archive":   "/categories/archive",
+	"/api/v1/categories/meta":      "/categories/meta",
+	"/api/v1/categories/meta/edit": "/categories/meta/edit",
+	"/api/v1/categories/rename":    "/api/categories/rename",
+	"/api/v1/categories/merge":     "/api/categories/merge",
+	"/api/v1/currency":             "/currency",
+	"/api/v1/currency/edit":        "/currency/edit",
+	"/api/v1/currencies":           "/currencies",
+	"/api/v1/currencies/edit":      "/currencies/edit",
+	"/api/v1/startdate":            "/startdate",
+	"/api/v1/startdate/edit":       "/startdate/edit",
+	"/api/v1/budgets":              "/budgets",
+	"/api/v1/budgets/edit":         "/budgets/edit",
+	"/api/v1/budgets/status":       "/budgets/status",
+	"/api/v1/planned":              "/api/planned",
+	"/api/v1/planned/edit":         "/api/planned/edit",
+	"/api/v1/projects":             "/api/projects",
+	"/api/v1/projects/edit":        "/api/projects/edit",
+	"/api/v1/reports/projects":     "/api/reports/projects",
+	"/api/v1/period":               "/period",
+	"/api/v1/period/edit":          "/period/edit",
+	"/api/v1/travel-rates":         "/travel-rates",
+	"/api/v1/travel-rates/edit":    "/travel-rates/edit",
+	"/api/v1/fiscal-year":          "/fiscal-year",
+	"/api/v1/fiscal-year/edit":     "/fiscal-year/edit",
+	"/api/v1/retention":            "/retention",
+	"/api/v1/retention/edit":       "/retention/edit",
+	"/api/v1/admin/settings":       "/api/admin/settings",
+	"/api/v1/admin/maintenance":    "/api/admin/maintenance",
+	"/api/v1/admin/jobs":           "/api/admin/jobs",
+	"/api/v1/admin/jobs/run":       "/api/admin/jobs/run",
+	"/api/v1/preferences":          "/api/preferences",
+	"/api/v1/preferences/edit":     "/api/preferences/edit",
+	"/api/v1/dashboard/layout":     "/api/dashboard/layout",
+	"/api/v1/languages":            "/api/languages",
+
+	// SubCategories
+	"/api/v1/subcategories":             "/subcategories",
+	"/api/v1/subcategory":               "/subcategory",
+	"/api/v1/subcategory/delete":        "/subcategory/delete",
+	"/api/v1/subcategory/rename":        "/subcategory/rename",
+	"/api/v1/subcategory/merge":         "/subcategory/merge",
+	"/api/v1/subcategory-mappings":      "/subcategory-mappings",
+	"/api/v1/subcategory-mappings/edit": "/subcategory-mappings/edit",
+
+	// Expenses
+	"/api/v1/expense":                "/expense",
+	"/api/v1/expenses":               "/expenses",
+	"/api/v1/expense/edit":           "/expense/edit",
+	"/api/v1/expense/delete":         "/expense/delete",
+	"/api/v1/expenses/delete":        "/expenses/delete",
+	"/api/v1/expenses/move":          "/expenses/move",
+	"/api/v1/expenses/monthly":       "/api/expenses/monthly",
+	"/api/v1/expenses/suggest":       "/api/expenses/suggest",
+	"/api/v1/expenses/parse-and-add": "/api/expenses/parse-and-add",
+	"/api/v1/receipts/scan":          "/api/receipts/scan",
+
+	// Recurring Expenses
+	"/api/v1/recurring-expense":            "/recurring-expense",
+	"/api/v1/recurring-expenses":           "/recurring-expenses",
+	"/api/v1/recurring-expense/edit":       "/recurring-expense/edit",
+	"/api/v1/recurring-expense/delete":     "/recurring-expense/delete",
+	"/api/v1/recurring-expense/occurrence": "/recurring-expense/occurrence",
+
+	// Import
+	"/api/v1/import/csv":       "/import/csv",
+	"/api/v1/import/firefly":   "/api/import/firefly",
+	"/api/v1/import/actual":    "/api/import/actual",
+	"/api/v1/import/cashew":    "/api/import/cashew",
+	"/api/v1/import/paypal":    "/api/import/paypal",
+	"/api/v1/import/stripe":    "/api/import/stripe",
+	"/api/v1/imports":          "/api/imports",
+	"/api/v1/imports/rollback": "/api/imports/rollback",
+
+	// Client sync
+	"/api/v1/sync/pull": "/api/sync/pull",
+	"/api/v1/sync/push": "/api/sync/push",
+	"/api/v1/changes":   "/api/changes",
+
+	// Bank sync
+	"/api/v1/plaid/items":        "/api/plaid/items",
+	"/api/v1/plaid/sync":         "/api/plaid/sync",
+	"/api/v1/plaid/items/remove": "/api/plaid/items/remove",
+
+	// Integrations and reports
+	"/api/v1/trmnl":                         "/api/trmnl",
+	"/api/v1/trmnl/profiles":                "/api/trmnl/profiles",
+	"/api/v1/trmnl/profiles/edit":           "/api/trmnl/profiles/edit",
+	"/api/v1/trmnl/profiles/remove":         "/api/trmnl/profiles/remove",
+	"/api/v1/trmnl/profile":                 "/api/trmnl/profile",
+	"/api/v1/widgets/summary":               "/api/widgets/summary",
+	"/api/v1/widgets/summary.png":           "/api/widgets/summary.png",
+	"/api/v1/widgets/summary.bmp":           "/api/widgets/summary.bmp",
+	"/api/v1/summary/today":                 "/api/summary/today",
+	"/api/v1/summary/week":                  "/api/summary/week",
+	"/api/v1/summary/month":                 "/api/summary/month",
+	"/api/v1/voice/alexa":                   "/api/voice/alexa",
+	"/api/v1/voice/google":                  "/api/voice/google",
+	"/api/v1/voice/keys":                    "/api/voice/keys",
+	"/api/v1/voice/keys/remove":             "/api/voice/keys/remove",
+	"/api/v1/wallet/pass":                   "/api/wallet/pass",
+	"/api/v1/integrations/homeassistant":    "/api/integrations/homeassistant",
+	"/api/v1/reports":                       "/reports",
+	"/api/v1/reports/edit":                  "/reports/edit",
+	"/api/v1/reports/send":                  "/reports/send",
+	"/api/v1/reports/yearly":                "/api/reports/yearly",
+	"/api/v1/reports/category-trend":        "/api/reports/category-trend",
+	"/api/v1/reports/tax":                   "/api/reports/tax",
+	"/api/v1/insights/patterns":             "/api/insights/patterns",
+	"/api/v1/reports/reimbursements":        "/api/reports/reimbursements",
+	"/api/v1/reports/reimbursements/settle": "/api/reports/reimbursements/settle",
+	"/api/v1/reconcile":                     "/api/reconcile",
+	"/api/v1/reconcile/mark":                "/api/reconcile/mark",
+	"/api/v1/reports/statements":            "/api/reports/statements",
+	"/api/v1/reports/merchants":             "/api/reports/merchants",
+	"/api/v1/reports/networth":              "/api/reports/networth",
+	"/api/v1/reports/saved":                 "/api/reports/saved",
+	"/api/v1/reports/saved/edit":            "/api/reports/saved/edit",
+	"/api/v1/reports/saved/preview":         "/api/reports/saved/preview",
+	"/api/v1/reports/saved/run":             "/api/reports/saved/run",
+	"/api/v1/accounts":                      "/api/accounts",
+	"/api/v1/accounts/edit":                 "/api/accounts/edit",
+	"/api/v1/holdings":                      "/api/holdings",
+	"/api/v1/holdings/edit":                 "/api/holdings/edit",
+	"/api/v1/holdings/refresh":              "/api/holdings/refresh",
+	"/api/v1/merchants/aliases":             "/api/merchants/aliases",
+	"/api/v1/merchants/aliases/edit":        "/api/merchants/aliases/edit",
+	"/api/v1/merchants/merge":               "/api/merchants/merge",
+	"/api/v1/shares":                        "/api/shares",
+	"/api/v1/shares/revoke":                 "/api/shares/revoke",
+}
+
+// Envelope is the body of every JSON response under /api/v1
+type Envelope struct {
+	Data       any         `json:"data"`
+	Error      string      `json:"error,omitempty"`
+	Code       string      `json:"code,omitempty"` // of the error, see ErrorResponse
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// Pagination describes the page of a list returned for ?page= or ?per_page=
+type Pagination struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+// unversionedPath returns the route serving a path, resolving /api/v1 routes to their unversioned one
+func unversionedPath(path string) string {
+	if route, ok := v1Routes[path]; ok {
+		return route
+	}
+	return path
+}
+
+// APIv1 serves the /api/v1 routes through the unversioned routes and wraps their JSON responses
+func APIv1(routes http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := v1Routes[r.URL.Path]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, Envelope{Error: "Not found", Code: CodeNotFound})
+			return
+		}
+		page, perPage, paginate, err := parsePagination(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, Envelope{Error: err.Error(), Code: CodeBadRequest})
+			return
+		}
+
+		unversioned := r.Clone(r.Context())
+		unversioned.URL.Path = route
+		unversioned.URL.RawPath = ""
+		recorder := &envelopeRecorder{header: http.Header{}, status: http.StatusOK}
+		routes.ServeHTTP(recorder, unversioned)
+
+		for key, values := range recorder.header {
+			if key != "Content-Length" {
+				w.Header()[key] = values
+			}
+		}
+		mediaType, _, _ := mime.ParseMediaType(recorder.header.Get("Content-Type"))
+		if mediaType != "application/json" {
+			w.WriteHeader(recorder.status)
+			w.Write(recorder.body.Bytes())
+			return
+		}
+		envelope := wrapResponse(recorder.status, recorder.body.Bytes())
+		if paginate && envelope.Error == "" {
+			envelope.paginate(page, perPage)
+		}
+		writeJSON(w, recorder.status, envelope)
+	})
+}
+
+// parsePagination reads ?page= (from 1) and ?per_page=; paginate is false when neither is set
+func parsePagination(r *http.Request) (page int, perPage int, paginate bool, err error) {
+	query := r.URL.Query()
+	page, perPage = 1, defaultPerPage
+	if raw := query.Get("page"); raw != "" {
+		paginate = true
+		if page, err = strconv.Atoi(raw); err != nil || page < 1 {
+			return 0, 0, false, fmt.Errorf("page must be a positive number")
+		}
+	}
+	if raw := query.Get("per_page"); raw != "" {
+		paginate = true
+		if perPage, err = strconv.Atoi(raw); err != nil || perPage < 1 || perPage > maxPerPage {
+			return 0, 0, false, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+	}
+	return page, perPage, paginate, nil
+}
+
+// wrapResponse puts a handler's JSON body in an envelope; error bodies keep any fields besides the
+// message and code as data
+func wrapResponse(status int, body []byte) Envelope {
+	body = bytes.TrimSpace(body)
+	if status < http.StatusBadRequest {
+		if len(body) == 0 {
+			return Envelope{}
+		}
+		return Envelope{Data: json.RawMessage(body)}
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return Envelope{Error: http.StatusText(status)}
+	}
+	envelope := Envelope{Error: http.StatusText(status)}
+	if raw, ok := fields["error"]; ok {
+		json.Unmarshal(raw, &envelope.Error)
+		delete(fields, "error")
+	}
+	if raw, ok := fields["code"]; ok {
+		json.Unmarshal(raw, &envelope.Code)
+		delete(fields, "code")
+	}
+	if len(fields) > 0 {
+		envelope.Data = fields
+	}
+	return envelope
+}
+
+// paginate cuts a list down to the requested page; other data is left whole
+func (e *Envelope) paginate(page int, perPage int) {
+	raw, ok := e.Data.(json.RawMessage)
+	if !ok {
+		return
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return
+	}
+	totalPages := (len(items) + perPage - 1) / perPage
+	e.Data = []json.RawMessage{}
+	if page <= totalPages { // before multiplying, so a huge page can't overflow
+		start := (page - 1) * perPage
+		e.Data = items[start:min(start+perPage, len(items))]
+	}
+	e.Pagination = &Pagination{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      len(items),
+		TotalPages: totalPages,
+	}
+}
+
+// envelopeRecorder holds a handler's response so it can be wrapped before it is sent
+type envelopeRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (e *envelopeRecorder) Header() http.Header {
+	return e.header
+}
+
+func (e *envelopeRecorder) WriteHeader(status int) {
+	e.status = status
+}
+
+func (e *envelopeRecorder) Write(data []byte) (int, error) {
+	return e.body.Write(data)
+}
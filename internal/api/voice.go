@@ -0,0 +1,494 @@
+package api
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tanq16/expenseowl/internal/i18n"
+	"github.com/tanq16/expenseowl/internal/storage"
+	"github.com/tanq16/expenseowl/internal/web"
+)
+
+// Voice skills add expenses and answer "how much have I spent" hands-free. An Alexa skill or a
+// Google Assistant action sends what was said as an intent with slots to /api/voice/alexa or
+// /api/voice/google, which answer in the assistant's own format. The assistant is linked to the
+// household with a voice key: /api/voice/link is the authorization page of an implicit grant,
+// where the key is entered once and handed back to the assistant as its access token. Only a
+// hash of each key is stored, and removing a key unlinks every assistant using it.
+
+// Intents
+const (
+	IntentAddExpense = "AddExpenseIntent" // slots: amount, cents, name, category
+	IntentSpending   = "SpendingIntent"   // slots: category, period (today, week, or month)
+)
+
+// voiceRedirectPrefixes are where Alexa and Google send account linking, so keys only go to them
+var voiceRedirectPrefixes = []string{
+	"https://pitangui.amazon.com/",
+	"https://layla.amazon.com/",
+	"https://alexa.amazon.co.jp/",
+	"https://oauth-redirect.googleusercontent.com/",
+	"https://oauth-redirect-sandbox.googleusercontent.com/",
+}
+
+// VoiceKeyRequest creates a voice key
+type VoiceKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// VoiceKeyResponse describes a voice key; the key itself is only returned when it's created
+type VoiceKeyResponse struct {
+	Name      string    `json:"name"`
+	Key       string    `json:"key,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// voiceIntent is what was said, whichever assistant sent it
+type voiceIntent struct {
+	Name  string            // an intent above, or launch, help, or stop
+	Slots map[string]string // by lowercase name, only the filled ones
+	Lang  string
+}
+
+// voiceReply is what to say back, and whether the conversation is over
+type voiceReply struct {
+	Speech string
+	End    bool
+}
+
+// VoiceKeys lists the voice keys (GET) or creates one (POST)
+func (h *Handler) VoiceKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := h.storage.GetVoiceKeys()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get voice keys"})
+			log.Printf("API ERROR: Failed to get voice keys: %v\n", err)
+			return
+		}
+		response := make([]VoiceKeyResponse, len(keys))
+		for i, key := range keys {
+			response[i] = VoiceKeyResponse{Name: key.Name, CreatedAt: key.CreatedAt}
+		}
+		writeJSON(w, http.StatusOK, response)
+	case http.MethodPost:
+		h.createVoiceKey(w, r)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+	}
+}
+
+func (h *Handler) createVoiceKey(w http.ResponseWriter, r *http.Request) {
+	var req VoiceKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	secret, err := newShareToken()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to generate key"})
+		log.Printf("API ERROR: Failed to generate voice key: %v\n", err)
+		return
+	}
+	key := storage.VoiceKey{Name: storage.SanitizeString(req.Name), KeyHash: hashLoginToken(secret), CreatedAt: time.Now().UTC()}
+	keys, err := h.storage.GetVoiceKeys()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get voice keys"})
+		log.Printf("API ERROR: Failed to get voice keys: %v\n", err)
+		return
+	}
+	keys = append(keys, key)
+	if err := storage.ValidateVoiceKeys(keys); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err := h.storage.UpdateVoiceKeys(keys); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save voice key"})
+		log.Printf("API ERROR: Failed to save voice key: %v\n", err)
+		return
+	}
+	log.Printf("Created voice key %s\n", key.Name)
+	writeJSON(w, http.StatusOK, VoiceKeyResponse{Name: key.Name, Key: secret, CreatedAt: key.CreatedAt})
+}
+
+// RemoveVoiceKey deletes the voice key in ?name=, unlinking the assistants that use it
+func (h *Handler) RemoveVoiceKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	name := r.URL.Query().Get("name")
+	keys, err := h.storage.GetVoiceKeys()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get voice keys"})
+		log.Printf("API ERROR: Failed to get voice keys: %v\n", err)
+		return
+	}
+	kept := slices.DeleteFunc(slices.Clone(keys), func(k storage.VoiceKey) bool { return strings.EqualFold(k.Name, name) })
+	if len(kept) == len(keys) {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Voice key not found"})
+		return
+	}
+	if err := h.storage.UpdateVoiceKeys(kept); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to remove voice key"})
+		log.Printf("API ERROR: Failed to remove voice key: %v\n", err)
+		return
+	}
+	log.Printf("HTTP: Removed voice key %s\n", name)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// voiceKeyName returns the name of the voice key, false when it's unknown or revoked
+func (h *Handler) voiceKeyName(secret string) (string, bool) {
+	if secret == "" {
+		return "", false
+	}
+	keys, err := h.storage.GetVoiceKeys()
+	if err != nil {
+		log.Printf("API ERROR: Failed to get voice keys: %v\n", err)
+		return "", false
+	}
+	hash := hashLoginToken(secret)
+	i := slices.IndexFunc(keys, func(k storage.VoiceKey) bool {
+		return subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(hash)) == 1
+	})
+	if i < 0 {
+		return "", false
+	}
+	return keys[i].Name, true
+}
+
+// voiceLinkPage is the account linking form
+type voiceLinkPage struct {
+	web.Branding
+	RedirectURI string
+	State       string
+	Error       string
+}
+
+// LinkVoiceAssistant is the authorization page of account linking: GET shows a form for a voice
+// key, and POST checks it and redirects back to the assistant with the key as the access token
+func (h *Handler) LinkVoiceAssistant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	page := voiceLinkPage{Branding: brandingOf(r, h.storage), RedirectURI: r.FormValue("redirect_uri"), State: r.FormValue("state")}
+	if !slices.ContainsFunc(voiceRedirectPrefixes, func(prefix string) bool { return strings.HasPrefix(page.RedirectURI, prefix) }) {
+		http.Error(w, "Invalid redirect_uri: account linking only redirects to Alexa or Google", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodGet {
+		renderBasic(w, http.StatusOK, "voice-link.html", page)
+		return
+	}
+	secret := strings.TrimSpace(r.FormValue("key"))
+	name, ok := h.voiceKeyName(secret)
+	if !ok {
+		page.Error = "Unknown or revoked voice key"
+		renderBasic(w, http.StatusUnauthorized, "voice-link.html", page)
+		return
+	}
+	log.Printf("HTTP: Linked a voice assistant with voice key %s\n", name)
+	fragment := url.Values{"access_token": {secret}, "token_type": {"Bearer"}, "state": {page.State}}
+	http.Redirect(w, r, page.RedirectURI+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// alexaRequest is the part of an Alexa skill request the intents need
+type alexaRequest struct {
+	Session struct {
+		User struct {
+			AccessToken string `json:"accessToken"`
+		} `json:"user"`
+	} `json:"session"`
+	Context struct {
+		System struct {
+			User struct {
+				AccessToken string `json:"accessToken"`
+			} `json:"user"`
+		} `json:"System"`
+	} `json:"context"`
+	Request struct {
+		Type   string `json:"type"` // LaunchRequest, IntentRequest, or SessionEndedRequest
+		Locale string `json:"locale"`
+		Intent struct {
+			Name  string `json:"name"`
+			Slots map[string]struct {
+				Value string `json:"value"`
+			} `json:"slots"`
+		} `json:"intent"`
+	} `json:"request"`
+}
+
+// alexaResponse is a spoken Alexa response; the LinkAccount card asks to link in the Alexa app
+type alexaResponse struct {
+	Version  string `json:"version"`
+	Response struct {
+		OutputSpeech *alexaSpeech `json:"outputSpeech,omitempty"`
+		Card         *alexaCard   `json:"card,omitempty"`
+		EndSession   bool         `json:"shouldEndSession"`
+	} `json:"response"`
+}
+
+type alexaSpeech struct {
+	Type string `json:"type"` // PlainText
+	Text string `json:"text"`
+}
+
+type alexaCard struct {
+	Type string `json:"type"`
+}
+
+// AlexaSkill answers the requests of an Alexa custom skill
+func (h *Handler) AlexaSkill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var req alexaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	response := alexaResponse{Version: "1.0"}
+	if req.Request.Type == "SessionEndedRequest" {
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+	intent := voiceIntent{Lang: i18n.Match(req.Request.Locale), Slots: map[string]string{}}
+	switch req.Request.Type {
+	case "LaunchRequest":
+		intent.Name = "launch"
+	case "IntentRequest":
+		switch req.Request.Intent.Name {
+		case "AMAZON.HelpIntent":
+			intent.Name = "help"
+		case "AMAZON.StopIntent", "AMAZON.CancelIntent":
+			intent.Name = "stop"
+		default:
+			intent.Name = req.Request.Intent.Name
+		}
+		for name, slot := range req.Request.Intent.Slots {
+			if slot.Value != "" && slot.Value != "?" {
+				intent.Slots[strings.ToLower(name)] = slot.Value
+			}
+		}
+	}
+	secret := cmp.Or(req.Context.System.User.AccessToken, req.Session.User.AccessToken)
+	key, linked := h.voiceKeyName(secret)
+	var reply voiceReply
+	if linked {
+		reply = h.answerIntent(intent, key, time.Now())
+	} else {
+		reply = voiceReply{Speech: i18n.T(intent.Lang, "voice.linkAccount"), End: true}
+		response.Response.Card = &alexaCard{Type: "LinkAccount"}
+	}
+	response.Response.OutputSpeech = &alexaSpeech{Type: "PlainText", Text: reply.Speech}
+	response.Response.EndSession = reply.End
+	writeJSON(w, http.StatusOK, response)
+	log.Printf("HTTP: Answered Alexa %s\n", cmp.Or(intent.Name, req.Request.Type))
+}
+
+// googleRequest is the part of a Google Assistant conversation webhook request the intents need
+type googleRequest struct {
+	Handler struct {
+		Name string `json:"name"`
+	} `json:"handler"`
+	Intent struct {
+		Name   string `json:"name"`
+		Params map[string]struct {
+			Original string `json:"original"`
+			Resolved any    `json:"resolved"`
+		} `json:"params"`
+	} `json:"intent"`
+	Session struct {
+		ID string `json:"id"`
+	} `json:"session"`
+	User struct {
+		Locale string `json:"locale"`
+		Params struct {
+			BearerToken string `json:"bearerToken"`
+		} `json:"params"`
+	} `json:"user"`
+}
+
+// googleResponse is a spoken Google Assistant response
+type googleResponse struct {
+	Session struct {
+		ID     string         `json:"id"`
+		Params map[string]any `json:"params"`
+	} `json:"session"`
+	Prompt struct {
+		FirstSimple struct {
+			Speech string `json:"speech"`
+			Text   string `json:"text"`
+		} `json:"firstSimple"`
+	} `json:"prompt"`
+	Scene *googleScene `json:"scene,omitempty"`
+}
+
+type googleScene struct {
+	Next struct {
+		Name string `json:"name"`
+	} `json:"next"`
+}
+
+// GoogleAction answers the conversation webhook of a Google Assistant action; the access token is
+// sent as a bearer token, or in user.params.bearerToken
+func (h *Handler) GoogleAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var req googleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	intent := voiceIntent{Name: cmp.Or(req.Intent.Name, req.Handler.Name), Lang: i18n.Match(req.User.Locale), Slots: map[string]string{}}
+	switch intent.Name {
+	case "actions.intent.MAIN":
+		intent.Name = "launch"
+	case "actions.intent.HELP":
+		intent.Name = "help"
+	case "actions.intent.CANCEL":
+		intent.Name = "stop"
+	}
+	for name, param := range req.Intent.Params {
+		value := param.Original
+		switch resolved := param.Resolved.(type) {
+		case string:
+			value = resolved
+		case float64:
+			value = strconv.FormatFloat(resolved, 'f', -1, 64)
+		}
+		if value != "" {
+			intent.Slots[strings.ToLower(name)] = value
+		}
+	}
+	secret, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	key, linked := h.voiceKeyName(cmp.Or(strings.TrimSpace(secret), req.User.Params.BearerToken))
+	reply := voiceReply{Speech: i18n.T(intent.Lang, "voice.linkAccount"), End: true}
+	if linked {
+		reply = h.answerIntent(intent, key, time.Now())
+	}
+
+	var response googleResponse
+	response.Session.ID, response.Session.Params = req.Session.ID, map[string]any{}
+	response.Prompt.FirstSimple.Speech, response.Prompt.FirstSimple.Text = reply.Speech, reply.Speech
+	if reply.End {
+		response.Scene = &googleScene{}
+		response.Scene.Next.Name = "actions.scene.END_CONVERSATION"
+	}
+	writeJSON(w, http.StatusOK, response)
+	log.Printf("HTTP: Answered Google Assistant %s\n", intent.Name)
+}
+
+// answerIntent does what was asked with a linked voice key and says how it went
+func (h *Handler) answerIntent(intent voiceIntent, key string, now time.Time) voiceReply {
+	switch intent.Name {
+	case "launch", "help":
+		return voiceReply{Speech: i18n.T(intent.Lang, "voice.help")}
+	case "stop":
+		return voiceReply{Speech: i18n.T(intent.Lang, "voice.goodbye"), End: true}
+	case IntentAddExpense:
+		return h.addVoiceExpense(intent, key, now)
+	case IntentSpending:
+		category := ""
+		if name := intent.Slots["category"]; name != "" {
+			found, ok, err := h.findCategory(name)
+			if err != nil {
+				log.Printf("API ERROR: Failed to get categories for voice intent: %v\n", err)
+				return voiceReply{Speech: i18n.T(intent.Lang, "voice.failed"), End: true}
+			}
+			if !ok {
+				return voiceReply{Speech: i18n.T(intent.Lang, "voice.unknownCategory", name)}
+			}
+			category = found
+		}
+		summary, err := h.spokenSummary(voiceScope(intent.Slots["period"]), category, intent.Lang, h.voiceFormat(intent.Lang), now)
+		if err != nil {
+			log.Printf("API ERROR: Failed to retrieve expenses for voice intent: %v\n", err)
+			return voiceReply{Speech: i18n.T(intent.Lang, "voice.failed"), End: true}
+		}
+		return voiceReply{Speech: summary.Text, End: true}
+	}
+	return voiceReply{Speech: i18n.T(intent.Lang, "voice.notUnderstood")}
+}
+
+// addVoiceExpense adds the expense of an AddExpenseIntent; without a known category, mapping
+// rules pick one from the name, like for quick entry
+func (h *Handler) addVoiceExpense(intent voiceIntent, key string, now time.Time) voiceReply {
+	amount, err := strconv.ParseFloat(strings.Replace(intent.Slots["amount"], ",", ".", 1), 64)
+	if err != nil || amount <= 0 {
+		return voiceReply{Speech: i18n.T(intent.Lang, "voice.missingAmount")}
+	}
+	// assistants hear whole numbers, so "12 dollars 50" fills in cents
+	if cents, err := strconv.Atoi(intent.Slots["cents"]); err == nil && cents > 0 && cents < 100 {
+		amount += float64(cents) / 100
+	}
+	categories, err := h.storage.GetCategories()
+	if err != nil {
+		log.Printf("API ERROR: Failed to get categories for voice intent: %v\n", err)
+		return voiceReply{Speech: i18n.T(intent.Lang, "voice.failed"), End: true}
+	}
+	expense := storage.Expense{
+		ID:       uuid.New().String(),
+		Name:     storage.SanitizeString(intent.Slots["name"]),
+		Amount:   -amount,
+		Date:     now,
+		Metadata: storage.Metadata{"voice": {"key": key}},
+	}
+	if name := intent.Slots["category"]; name != "" {
+		if i := slices.IndexFunc(categories, func(c string) bool { return strings.EqualFold(c, name) }); i >= 0 {
+			expense.Category = categories[i]
+		}
+	}
+	h.resolveCategory(&expense, categories)
+	if expense.Name == "" {
+		expense.Name = expense.Category
+	}
+	h.applyTaxRate(&expense)
+	if err := expense.Validate(); err != nil {
+		log.Printf("API ERROR: Invalid expense from voice intent: %v\n", err)
+		return voiceReply{Speech: i18n.T(intent.Lang, "voice.failed"), End: true}
+	}
+	if err := h.storage.AddExpense(expense); err != nil {
+		log.Printf("API ERROR: Failed to save expense from voice intent: %v\n", err)
+		return voiceReply{Speech: i18n.T(intent.Lang, "voice.failed"), End: true}
+	}
+	h.notifyExpenseAdded(expense)
+	return voiceReply{Speech: i18n.T(intent.Lang, "voice.added", formatAmount(amount, h.voiceFormat(intent.Lang)), expense.Name, expense.Category), End: true}
+}
+
+// voiceScope reads the period slot, e.g., "this week", as a spoken summary scope; a month by default
+func voiceScope(period string) string {
+	period = strings.ToLower(period)
+	switch {
+	case strings.Contains(period, "today"):
+		return "today"
+	case strings.Contains(period, "week"):
+		return "week"
+	}
+	return "month"
+}
+
+// voiceFormat is the currency format with the language's separators
+func (h *Handler) voiceFormat(lang string) numberFormat {
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+	format := currencyFormat(currency)
+	format.Thousands, format.Decimal = i18n.Separators(lang)
+	return format
+}
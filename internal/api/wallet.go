@@ -0,0 +1,504 @@
+package api
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/i18n"
+	"github.com/tanq16/expenseowl/internal/storage"
+	"github.com/tanq16/expenseowl/internal/wallet"
+	"github.com/tanq16/expenseowl/internal/web"
+	"golang.org/x/image/draw"
+)
+
+// The budget pass is an Apple Wallet pass with what's left of the budgets this period, so it shows
+// on the lock screen. It stays off unless WALLET_PASS_CERT (a Pass Type ID certificate with its key)
+// and WALLET_WWDR_CERT are set. With PUBLIC_URL, the pass points Wallet at the pass web service
+// below /api/wallet/v1, where devices register for updates; the wallet-push job pushes the
+// devices whose pass changed, and Wallet then fetches the new pass from the web service.
+
+const walletSerialNumber = "budget" // of the one budget pass of a household
+
+// walletImageSizes are the pass images, scaled from the PWA icon, by name and width in pixels
+var walletImageSizes = map[string]int{
+	"icon.png":    29,
+	"icon@2x.png": 58,
+	"icon@3x.png": 87,
+	"logo.png":    50,
+	"logo@2x.png": 100,
+	"logo@3x.png": 150,
+}
+
+// walletPasses signs the budget pass and pushes its updates, and serializes changes of the
+// registered devices
+type walletPasses struct {
+	signer        *wallet.Signer
+	pusher        *wallet.Pusher
+	images        map[string][]byte
+	serialNumber  string
+	webServiceURL string // empty without PUBLIC_URL, which leaves passes without updates
+	mu            sync.Mutex
+}
+
+// walletPassesFromEnv returns the budget pass, or nil when Wallet passes are disabled
+func walletPassesFromEnv() *walletPasses {
+	certPath, wwdrPath := os.Getenv("WALLET_PASS_CERT"), os.Getenv("WALLET_WWDR_CERT")
+	if certPath == "" || wwdrPath == "" {
+		return nil
+	}
+	signer, err := readWalletSigner(certPath, wwdrPath)
+	if err != nil {
+		log.Printf("%v, Wallet passes are disabled\n", err)
+		return nil
+	}
+	if time.Now().After(signer.Expires()) {
+		log.Printf("The pass certificate expired on %s, Wallet passes are disabled\n", signer.Expires().Format("2006-01-02"))
+		return nil
+	}
+	images, err := walletImages()
+	if err != nil {
+		log.Printf("Failed to draw the pass icon, Wallet passes are disabled: %v\n", err)
+		return nil
+	}
+	passes := &walletPasses{signer: signer, pusher: wallet.NewPusher(signer), images: images, serialNumber: walletSerialNumber}
+	switch publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/"); {
+	case strings.HasPrefix(publicURL, "https://"):
+		passes.webServiceURL = publicURL + "/api/wallet"
+	case publicURL != "":
+		log.Println("Wallet only updates passes from an https PUBLIC_URL, the budget pass won't update")
+	}
+	return passes
+}
+
+// readWalletSigner reads the pass certificate and the WWDR certificate
+func readWalletSigner(certPath, wwdrPath string) (*wallet.Signer, error) {
+	cert, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WALLET_PASS_CERT: %v", err)
+	}
+	wwdr, err := os.ReadFile(wwdrPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WALLET_WWDR_CERT: %v", err)
+	}
+	return wallet.NewSigner(cert, wwdr)
+}
+
+// forTenant is the budget pass of a tenant, with its own serial number and web service
+func (p *walletPasses) forTenant(tenant string) *walletPasses {
+	passes := &walletPasses{signer: p.signer, pusher: p.pusher, images: p.images, serialNumber: p.serialNumber + "-" + tenant}
+	if base, ok := strings.CutSuffix(p.webServiceURL, "/api/wallet"); ok {
+		passes.webServiceURL = base + tenantPathPrefix + tenant + "/api/wallet"
+	}
+	return passes
+}
+
+// walletImages scales the PWA icon to the icon and logo of the pass
+func walletImages() (map[string][]byte, error) {
+	data, err := web.ReadStatic("pwa/icon-512.png")
+	if err != nil {
+		return nil, err
+	}
+	icon, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	images := make(map[string][]byte, len(walletImageSizes))
+	for name, size := range walletImageSizes {
+		scaled := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), icon, icon.Bounds(), draw.Over, nil)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, scaled); err != nil {
+			return nil, err
+		}
+		images[name] = buf.Bytes()
+	}
+	return images, nil
+}
+
+// requireWallet writes an error when Wallet passes are disabled
+func (h *Handler) requireWallet(w http.ResponseWriter) bool {
+	if h.wallet == nil {
+		writeJSON(w, http.StatusServiceUnavailable, ErrorResponse{Error: "Wallet passes are not configured, set WALLET_PASS_CERT and WALLET_WWDR_CERT"})
+		return false
+	}
+	return true
+}
+
+// budgetPass is the pass with the budgets of the current period, in the report language: what's
+// left in front, and each category's budget on the back
+func (h *Handler) budgetPass(now time.Time) (wallet.Pass, error) {
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		return wallet.Pass{}, err
+	}
+	period := h.periodConfig().Current(now)
+	budgets, err := h.budgetStatus(expenses, period)
+	if err != nil {
+		return wallet.Pass{}, err
+	}
+	_, spent, _ := summarizePeriod(h.reportable(expenses), period)
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+	format := currencyFormat(currency)
+	lang := h.reportLanguage()
+	if lang != "" {
+		format.Thousands, format.Decimal = i18n.Separators(lang)
+	}
+	amount := func(value float64) string { return formatAmount(value, format) }
+
+	var available float64
+	var back []wallet.Field
+	for _, b := range budgets {
+		available += b.Available
+		key := "wallet.categoryLeft"
+		if b.Remaining < 0 {
+			key = "wallet.categoryOver"
+		}
+		back = append(back, wallet.Field{Key: "budget-" + b.Category, Label: b.Category, Value: i18n.T(lang, key, amount(b.Remaining), amount(b.Available))})
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	daysLeft := int(math.Round(period.End.Sub(today).Hours() / 24))
+
+	structure := wallet.Structure{
+		HeaderFields:    []wallet.Field{{Key: "period", Label: i18n.T(lang, "wallet.period"), Value: period.Label()}},
+		AuxiliaryFields: []wallet.Field{{Key: "days", Label: i18n.T(lang, "wallet.daysLeft"), Value: fmt.Sprint(daysLeft)}},
+		BackFields:      back,
+	}
+	if len(budgets) == 0 {
+		structure.PrimaryFields = []wallet.Field{{Key: "spent", Label: i18n.T(lang, "wallet.spent"), Value: amount(spent)}}
+	} else {
+		remaining := wallet.Field{Key: "remaining", Label: i18n.T(lang, "wallet.left"), Value: amount(available - spent), ChangeMessage: i18n.T(lang, "wallet.leftChanged")}
+		if available < spent {
+			remaining.Label, remaining.ChangeMessage = i18n.T(lang, "wallet.over"), i18n.T(lang, "wallet.overChanged")
+		}
+		structure.PrimaryFields = []wallet.Field{remaining}
+		structure.SecondaryFields = []wallet.Field{
+			{Key: "spent", Label: i18n.T(lang, "wallet.spent"), Value: amount(spent)},
+			{Key: "budget", Label: i18n.T(lang, "wallet.budget"), Value: amount(available)},
+		}
+	}
+
+	name := cmp.Or(h.runtimeSettings().UI.InstanceName, defaultInstanceName)
+	pass := wallet.Pass{
+		SerialNumber:     h.wallet.serialNumber,
+		OrganizationName: name,
+		Description:      i18n.T(lang, "wallet.description"),
+		LogoText:         name,
+		ForegroundColor:  "rgb(255, 255, 255)",
+		BackgroundColor:  "rgb(33, 37, 41)",
+		LabelColor:       "rgb(173, 181, 189)",
+		Generic:          structure,
+	}
+	if h.wallet.webServiceURL != "" {
+		pass.WebServiceURL = h.wallet.webServiceURL
+		pass.AuthenticationToken = h.wallet.signer.AuthenticationToken(pass.SerialNumber)
+	}
+	return pass, nil
+}
+
+// walletTag identifies what a pass shows, so devices are only pushed when it changes
+func walletTag(pass wallet.Pass) string {
+	data, _ := json.Marshal(pass)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// GetWalletPass downloads the budget pass, which opens in Wallet on an iPhone or a Mac
+func (h *Handler) GetWalletPass(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if !h.requireWallet(w) {
+		return
+	}
+	h.serveWalletPass(w)
+	log.Println("HTTP: Served the budget pass")
+}
+
+// serveWalletPass signs and writes the current budget pass
+func (h *Handler) serveWalletPass(w http.ResponseWriter) {
+	pass, err := h.budgetPass(time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to build the budget pass"})
+		log.Printf("API ERROR: Failed to build the budget pass: %v\n", err)
+		return
+	}
+	data, err := h.wallet.signer.Build(pass, h.wallet.images)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to sign the budget pass"})
+		log.Printf("API ERROR: Failed to sign the budget pass: %v\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", wallet.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pkpass"`, pass.SerialNumber))
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// WalletService is the pass web service below /api/wallet/v1, which Wallet calls to register a
+// device for updates of a pass (POST and DELETE devices/{device}/registrations/{passType}/{serial}),
+// ask which passes changed (GET devices/{device}/registrations/{passType}), fetch the changed
+// pass (GET passes/{passType}/{serial}), and send its error logs (POST log)
+func (h *Handler) WalletService(w http.ResponseWriter, r *http.Request) {
+	if h.wallet == nil {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Not found"})
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/wallet/v1/"), "/")
+	switch {
+	case len(parts) == 5 && parts[0] == "devices" && parts[2] == "registrations":
+		h.registerWalletDevice(w, r, parts[1], parts[3], parts[4])
+	case len(parts) == 4 && parts[0] == "devices" && parts[2] == "registrations":
+		h.updatedWalletPasses(w, r, parts[1], parts[3])
+	case len(parts) == 3 && parts[0] == "passes":
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+			return
+		}
+		if !h.walletPassExists(parts[1], parts[2]) {
+			writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Unknown pass"})
+			return
+		}
+		if !h.walletAuthorized(r, parts[2]) {
+			writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid pass authentication token"})
+			return
+		}
+		h.serveWalletPass(w)
+	case len(parts) == 1 && parts[0] == "log":
+		h.walletLog(w, r)
+	default:
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Not found"})
+	}
+}
+
+// walletPassExists checks a pass type and serial number against the budget pass
+func (h *Handler) walletPassExists(passType, serialNumber string) bool {
+	return passType == h.wallet.signer.PassTypeIdentifier && serialNumber == h.wallet.serialNumber
+}
+
+// walletAuthorized checks the "ApplePass <token>" authorization Wallet sends for a pass
+func (h *Handler) walletAuthorized(r *http.Request, serialNumber string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApplePass ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(h.wallet.signer.AuthenticationToken(serialNumber))) == 1
+}
+
+// registerWalletDevice registers (POST) a device for updates of a pass with its push token in
+// {"pushToken": "..."}, or unregisters it (DELETE)
+func (h *Handler) registerWalletDevice(w http.ResponseWriter, r *http.Request, deviceID, passType, serialNumber string) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if !h.walletPassExists(passType, serialNumber) {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Unknown pass"})
+		return
+	}
+	if !h.walletAuthorized(r, serialNumber) {
+		writeJSON(w, http.StatusUnauthorized, ErrorResponse{Error: "Invalid pass authentication token"})
+		return
+	}
+	var registration struct {
+		PushToken string `json:"pushToken"`
+	}
+	tag := ""
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&registration); err != nil || registration.PushToken == "" {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Expected a pushToken"})
+			return
+		}
+		// the device just got the pass, so it's up to date
+		pass, err := h.budgetPass(time.Now())
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to build the budget pass"})
+			log.Printf("API ERROR: Failed to build the budget pass: %v\n", err)
+			return
+		}
+		tag = walletTag(pass)
+	}
+
+	h.wallet.mu.Lock()
+	defer h.wallet.mu.Unlock()
+	devices, err := h.storage.GetWalletDevices()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get the registered devices"})
+		log.Printf("API ERROR: Failed to get wallet devices: %v\n", err)
+		return
+	}
+	status := http.StatusOK
+	updated := make([]storage.WalletDevice, 0, len(devices)+1)
+	found := false
+	for _, device := range devices {
+		if device.DeviceID == deviceID && device.SerialNumber == serialNumber {
+			found = true
+			if r.Method == http.MethodDelete {
+				continue
+			}
+			device.PushToken = registration.PushToken
+		}
+		updated = append(updated, device)
+	}
+	if r.Method == http.MethodPost && !found {
+		updated = append(updated, storage.WalletDevice{DeviceID: deviceID, PushToken: registration.PushToken, SerialNumber: serialNumber, Tag: tag, RegisteredAt: time.Now().UTC()})
+		status = http.StatusCreated
+	}
+	if err := h.storage.UpdateWalletDevices(updated); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to save the registered devices"})
+		log.Printf("API ERROR: Failed to save wallet devices: %v\n", err)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		log.Println("HTTP: Unregistered a device from the budget pass")
+	} else if status == http.StatusCreated {
+		log.Println("HTTP: Registered a device for the budget pass")
+	}
+	w.WriteHeader(status)
+}
+
+// WalletPassUpdates lists the passes of a device that changed since the tag in passesUpdatedSince
+type WalletPassUpdates struct {
+	SerialNumbers []string `json:"serialNumbers"`
+	LastUpdated   string   `json:"lastUpdated"` // the tag to send next time
+}
+
+// updatedWalletPasses lists the registered passes of a device that changed since
+// ?passesUpdatedSince=, or answers 204 when none did
+func (h *Handler) updatedWalletPasses(w http.ResponseWriter, r *http.Request, deviceID, passType string) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	if passType != h.wallet.signer.PassTypeIdentifier {
+		writeJSON(w, http.StatusNotFound, ErrorResponse{Error: "Unknown pass type"})
+		return
+	}
+	devices, err := h.storage.GetWalletDevices()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get the registered devices"})
+		log.Printf("API ERROR: Failed to get wallet devices: %v\n", err)
+		return
+	}
+	registered := false
+	for _, device := range devices {
+		registered = registered || (device.DeviceID == deviceID && device.SerialNumber == h.wallet.serialNumber)
+	}
+	if !registered {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	pass, err := h.budgetPass(time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to build the budget pass"})
+		log.Printf("API ERROR: Failed to build the budget pass: %v\n", err)
+		return
+	}
+	tag := walletTag(pass)
+	if r.URL.Query().Get("passesUpdatedSince") == tag {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, WalletPassUpdates{SerialNumbers: []string{h.wallet.serialNumber}, LastUpdated: tag})
+}
+
+// walletLog writes the errors Wallet reports about the web service to the log
+func (h *Handler) walletLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var payload struct {
+		Logs []string `json:"logs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	for _, entry := range payload.Logs {
+		log.Printf("WALLET: %s\n", entry)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// pushWalletUpdates pushes the devices whose pass changed since their last push, and drops the
+// devices that no longer have the pass
+func (h *Handler) pushWalletUpdates(ctx context.Context, now time.Time) error {
+	devices, err := h.storage.GetWalletDevices()
+	if err != nil || len(devices) == 0 {
+		return err
+	}
+	pass, err := h.budgetPass(now)
+	if err != nil {
+		return err
+	}
+	tag := walletTag(pass)
+
+	// by push token, true once pushed and false when the device is gone; a device with more than
+	// one registration is pushed once
+	pushed := make(map[string]bool)
+	var failed []error
+	for _, device := range devices {
+		if _, done := pushed[device.PushToken]; done || device.Tag == tag {
+			continue
+		}
+		switch err := h.wallet.pusher.Push(ctx, device.PushToken); {
+		case err == nil:
+			pushed[device.PushToken] = true
+		case errors.Is(err, wallet.ErrUnregistered):
+			pushed[device.PushToken] = false
+		default:
+			failed = append(failed, err)
+		}
+	}
+	if len(pushed) == 0 {
+		return errors.Join(failed...)
+	}
+
+	// registrations may have changed while pushing
+	h.wallet.mu.Lock()
+	defer h.wallet.mu.Unlock()
+	devices, err = h.storage.GetWalletDevices()
+	if err != nil {
+		return err
+	}
+	kept := make([]storage.WalletDevice, 0, len(devices))
+	for _, device := range devices {
+		ok, done := pushed[device.PushToken]
+		if done && !ok {
+			continue
+		}
+		if ok {
+			device.Tag = tag
+		}
+		kept = append(kept, device)
+	}
+	if err := h.storage.UpdateWalletDevices(kept); err != nil {
+		return err
+	}
+	gone := 0
+	for _, ok := range pushed {
+		if !ok {
+			gone++
+		}
+	}
+	log.Printf("Pushed the budget pass to %d devices, %d no longer have it\n", len(pushed)-gone, gone)
+	return errors.Join(failed...)
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The widget image is the period summary drawn server-side in black and white, for e-ink devices
+// that can only fetch and show an image. Text uses a fixed bitmap font scaled to whole pixels,
+// which stays crisp without anti-aliasing but only has ASCII, so names lose their accents.
+
+// einkSizes are the resolutions of common e-ink displays, in landscape unless the device is upright
+var einkSizes = map[string]image.Point{
+	"trmnl":          {800, 480},
+	"waveshare-7in5": {800, 480},
+	"waveshare-4in2": {400, 300},
+	"waveshare-2in9": {296, 128},
+	"inkplate-6":     {800, 600},
+	"inkplate-10":    {1200, 825},
+	"kindle":         {600, 800},
+}
+
+const (
+	minImageSize = 100
+	maxImageSize = 2000
+)
+
+// einkPalette is white (the background) and black
+var einkPalette = color.Palette{color.White, color.Black}
+
+// widgetImage is what the image shows, formatted
+type widgetImage struct {
+	Period     string
+	Spent      string
+	Income     string
+	Balance    string
+	BudgetLeft string // empty without budgets
+	Top        []widgetImageBar
+	Updated    string
+}
+
+// widgetImageBar is a top category, with a bar as long as its share of the largest
+type widgetImageBar struct {
+	Name   string
+	Amount string
+	Value  float64
+}
+
+// parseImageSize reads ?size= (a display from einkSizes) or ?width= and ?height=, 800x480 by default
+func parseImageSize(query url.Values) (image.Point, error) {
+	size := einkSizes["trmnl"]
+	if name := query.Get("size"); name != "" {
+		preset, ok := einkSizes[name]
+		if !ok {
+			return size, fmt.Errorf("invalid 'size': must be trmnl, waveshare-7in5, waveshare-4in2, waveshare-2in9, inkplate-6, inkplate-10, or kindle")
+		}
+		size = preset
+	}
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"width", &size.X}, {"height", &size.Y}} {
+		raw := query.Get(p.name)
+		if raw == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < minImageSize || parsed > maxImageSize {
+			return size, fmt.Errorf("invalid '%s': must be a number from %d to %d", p.name, minImageSize, maxImageSize)
+		}
+		*p.value = parsed
+	}
+	return size, nil
+}
+
+// GetWidgetImage draws the current period's summary as a PNG, or a BMP when the path ends in .bmp
+func (h *Handler) GetWidgetImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+	options, err := parseWidgetOptions(r.URL.Query(), currency)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	size, err := parseImageSize(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for widget image: %v\n", err)
+		return
+	}
+
+	// the font has no symbols like €, so those currencies show their code
+	if strings.IndexFunc(options.Format.Symbol, func(r rune) bool { return r > unicode.MaxASCII }) >= 0 {
+		options.Format.Symbol, options.Format.Space, options.Format.Right = strings.ToUpper(currency), true, true
+	}
+	period := h.periodConfig().Current(time.Now())
+	totalIncome, totalExpenses, categoryTotals := summarizePeriod(h.reportable(expenses), period)
+	summary := widgetImage{
+		Period:  period.Label(),
+		Spent:   formatAmount(totalExpenses, options.Format),
+		Income:  formatAmount(totalIncome, options.Format),
+		Balance: formatAmount(totalIncome-totalExpenses, options.Format),
+		Updated: time.Now().Format("Jan 2 15:04"),
+	}
+	if budgets, err := h.budgetStatus(expenses, period); err == nil && len(budgets) > 0 {
+		var remaining float64
+		for _, budget := range budgets {
+			remaining += budget.Remaining
+		}
+		summary.BudgetLeft = formatAmount(remaining, options.Format)
+	}
+	for _, category := range getTopCategories(categoryTotals, totalExpenses, options.Top) {
+		summary.Top = append(summary.Top, widgetImageBar{Name: category.Name, Amount: formatAmount(category.Amount, options.Format), Value: category.Amount})
+	}
+
+	img := drawWidgetImage(summary, size)
+	w.Header().Set("Cache-Control", "no-store")
+	if strings.HasSuffix(r.URL.Path, ".bmp") {
+		w.Header().Set("Content-Type", "image/bmp")
+		err = bmp.Encode(w, img)
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+		err = png.Encode(w, img)
+	}
+	if err != nil {
+		log.Printf("API ERROR: Failed to encode widget image: %v\n", err)
+		return
+	}
+	log.Println("HTTP: Served widget image")
+}
+
+// drawWidgetImage lays out the summary: the period, the amount spent, income, balance, and budget
+// left, then a bar per top category, as many as fit, and when it was drawn
+func drawWidgetImage(summary widgetImage, size image.Point) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, size.X, size.Y), einkPalette)
+	face := basicfont.Face7x13
+	scale := max(1, min(size.X/260, size.Y/160))
+	charWidth, lineHeight := face.Advance*scale, face.Height*scale
+	pad := 6 * scale
+	columns := (size.X - 2*pad) / charWidth
+	y := pad
+
+	drawText(img, pad, y, truncateText(summary.Period, columns), scale)
+	y += lineHeight + pad/2
+	drawText(img, pad, y, truncateText(summary.Spent+" spent", columns/2), 2*scale)
+	y += 2*lineHeight + pad/2
+	details := []string{"Income " + summary.Income + "  Balance " + summary.Balance}
+	if summary.BudgetLeft != "" {
+		// on the same line when it fits
+		budget := "Budget left " + summary.BudgetLeft
+		if combined := details[0] + "  " + budget; len(asciiText(combined)) <= columns {
+			details[0] = combined
+		} else {
+			details = append(details, budget)
+		}
+	}
+	for _, line := range details {
+		drawText(img, pad, y, truncateText(line, columns), scale)
+		y += lineHeight
+	}
+	y += pad
+	fillRect(img, image.Rect(pad, y, size.X-pad, y+scale))
+	y += scale + pad
+
+	footer := size.Y - pad - lineHeight
+	updated := "Updated " + summary.Updated
+	drawText(img, size.X-pad-len(updated)*charWidth, footer, updated, scale)
+
+	// bars: the name, then the bar, then the amount, right-aligned
+	rowHeight := lineHeight + pad/2
+	var largest float64
+	amountColumns := 0
+	for _, bar := range summary.Top {
+		largest = max(largest, bar.Value)
+		amountColumns = max(amountColumns, len(asciiText(bar.Amount)))
+	}
+	nameColumns := min(16, columns/3)
+	barStart := pad + (nameColumns+1)*charWidth
+	barEnd := size.X - pad - (amountColumns+1)*charWidth
+	for _, bar := range summary.Top {
+		if y+rowHeight > footer || barEnd <= barStart {
+			break
+		}
+		drawText(img, pad, y, truncateText(bar.Name, nameColumns), scale)
+		if largest > 0 {
+			length := int(float64(barEnd-barStart) * bar.Value / largest)
+			fillRect(img, image.Rect(barStart, y+2*scale, barStart+max(length, scale), y+lineHeight-2*scale))
+		}
+		amount := asciiText(bar.Amount)
+		drawText(img, size.X-pad-len(amount)*charWidth, y, amount, scale)
+		y += rowHeight
+	}
+	return img
+}
+
+// drawText draws ASCII text in black with its top-left corner at x, y, each font pixel a scale-sized square
+func drawText(img *image.Paletted, x, y int, text string, scale int) {
+	text = asciiText(text)
+	face := basicfont.Face7x13
+	mask := image.NewAlpha(image.Rect(0, 0, len(text)*face.Advance, face.Height))
+	drawer := font.Drawer{Dst: mask, Src: image.Opaque, Face: face, Dot: fixed.P(0, face.Ascent)}
+	drawer.DrawString(text)
+	bounds := mask.Bounds()
+	for my := bounds.Min.Y; my < bounds.Max.Y; my++ {
+		for mx := bounds.Min.X; mx < bounds.Max.X; mx++ {
+			if mask.AlphaAt(mx, my).A >= 0x80 {
+				fillRect(img, image.Rect(x+mx*scale, y+my*scale, x+(mx+1)*scale, y+(my+1)*scale))
+			}
+		}
+	}
+}
+
+// fillRect paints a rectangle black, clipped to the image
+func fillRect(img *image.Paletted, r image.Rectangle) {
+	r = r.Intersect(img.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetColorIndex(x, y, 1)
+		}
+	}
+}
+
+// asciiText keeps the characters the font has, dropping accents, e.g., "Café" becomes "Cafe"
+func asciiText(text string) string {
+	var sb strings.Builder
+	for _, r := range norm.NFD.String(text) {
+		if r >= ' ' && r < unicode.MaxASCII {
+			sb.WriteRune(r)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// truncateText shortens text to a number of characters, ending with "..." when cut
+func truncateText(text string, columns int) string {
+	text = asciiText(text)
+	if len(text) <= columns {
+		return text
+	}
+	if columns <= 3 {
+		return text[:max(columns, 0)]
+	}
+	return text[:columns-3] + "..."
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// widgetFields are the fields the widget summary can return
+var widgetFields = []string{
+	"period",           // e.g., "January 2026"
+	"period_start",     // YYYY-MM-DD
+	"period_end",       // YYYY-MM-DD (inclusive)
+	"currency",         // e.g., "usd"
+	"spent",            // total expenses this period
+	"income",           // total income this period
+	"balance",          // income - spent
+	"budget",           // sum of category budgets
+	"budget_remaining", // budget - spending in budgeted categories
+	"top",              // top categories by spending
+	"updated",          // ISO timestamp
+}
+
+var defaultWidgetFields = []string{"period", "spent", "income", "balance"}
+
+// numberFormat controls how amounts are rendered for widgets
+type numberFormat struct {
+	Symbol    string
+	Thousands string
+	Decimal   string
+	Decimals  int
+	Space     bool // space between symbol and amount
+	Right     bool // symbol after the amount
+}
+
+// widgetLocales are the supported separator styles
+var widgetLocales = map[string][2]string{
+	"en": {",", "."}, // 1,234.56
+	"de": {".", ","}, // 1.234,56
+	"fr": {" ", ","}, // 1 234,56
+	"ch": {"'", "."}, // 1'234.56
+}
+
+// currencyFormats mirrors the currency behaviors of the web UI
+var currencyFormats = map[string]numberFormat{
+	"usd": {Symbol: "$", Decimals: 2},
+	"eur": {Symbol: "€", Decimals: 2, Thousands: ".", Decimal: ","},
+	"gbp": {Symbol: "£", Decimals: 2},
+	"jpy": {Symbol: "¥", Decimals: 0},
+	"cny": {Symbol: "¥", Decimals: 2},
+	"krw": {Symbol: "₩", Decimals: 0},
+	"inr": {Symbol: "₹", Decimals: 2},
+	"rub": {Symbol: "₽", Decimals: 2, Thousands: ".", Decimal: ","},
+	"brl": {Symbol: "R$", Decimals: 2, Thousands: ".", Decimal: ","},
+	"zar": {Symbol: "R", Decimals: 2, Space: true, Right: true},
+	"aed": {Symbol: "AED", Decimals: 2, Space: true, Right: true},
+	"aud": {Symbol: "A$", Decimals: 2},
+	"cad": {Symbol: "C$", Decimals: 2},
+	"chf": {Symbol: "Fr", Decimals: 2, Space: true, Right: true},
+	"hkd": {Symbol: "HK$", Decimals: 2},
+	"bdt": {Symbol: "৳", Decimals: 2},
+	"sgd": {Symbol: "S$", Decimals: 2},
+	"thb": {Symbol: "฿", Decimals: 2},
+	"try": {Symbol: "₺", Decimals: 2, Thousands: ".", Decimal: ","},
+	"mxn": {Symbol: "Mex$", Decimals: 2},
+	"php": {Symbol: "₱", Decimals: 2},
+	"pln": {Symbol: "zł", Decimals: 2, Thousands: ".", Decimal: ",", Space: true, Right: true},
+	"sek": {Symbol: "kr", Decimals: 2, Space: true, Right: true},
+	"nzd": {Symbol: "NZ$", Decimals: 2},
+	"dkk": {Symbol: "kr.", Decimals: 2, Thousands: ".", Decimal: ",", Space: true, Right: true},
+	"idr": {Symbol: "Rp", Decimals: 2, Space: true, Right: true},
+	"ils": {Symbol: "₪", Decimals: 2},
+	"vnd": {Symbol: "₫", Decimals: 0, Thousands: ".", Decimal: ",", Space: true, Right: true},
+	"myr": {Symbol: "RM", Decimals: 2},
+	"mad": {Symbol: "DH", Decimals: 2, Space: true, Right: true},
+}
+
+// widgetOptions are parsed from the query string of /api/widgets/summary
+type widgetOptions struct {
+	Fields []string
+	Top    int
+	Raw    bool // numbers instead of formatted strings
+	Format numberFormat
+}
+
+// WidgetCategory is a top category entry in the widget summary
+type WidgetCategory struct {
+	Name   string `json:"name"`
+	Amount any    `json:"amount"` // formatted string, or a number with raw=true
+}
+
+// currencyFormat returns the default number format of a currency; other ISO 4217 currencies show
+// their symbol or code after the amount
+func currencyFormat(currency string) numberFormat {
+	f, ok := currencyFormats[currency]
+	if !ok {
+		f = numberFormat{Symbol: strings.ToUpper(currency), Decimals: 2, Space: true, Right: true}
+		if iso, ok := storage.LookupCurrency(currency, nil); ok {
+			f.Decimals = min(iso.Decimals, 2)
+			if iso.Symbol != "" {
+				f.Symbol = iso.Symbol
+			}
+		}
+	}
+	if f.Thousands == "" {
+		f.Thousands, f.Decimal = ",", "."
+	}
+	return f
+}
+
+// parseWidgetOptions reads e.g. ?fields=spent,top&top=3&locale=de&symbol=none&decimals=0
+func parseWidgetOptions(query url.Values, currency string) (widgetOptions, error) {
+	options := widgetOptions{Fields: defaultWidgetFields, Top: 3, Format: currencyFormat(currency)}
+	if raw := query.Get("fields"); raw != "" {
+		options.Fields = []string{}
+		for _, field := range splitAndTrim(raw, ",") {
+			if !slices.Contains(widgetFields, field) {
+				return options, fmt.Errorf("invalid field '%s', must be one of: %s", field, strings.Join(widgetFields, ", "))
+			}
+			options.Fields = append(options.Fields, field)
+		}
+	}
+	if raw := query.Get("top"); raw != "" {
+		top, err := strconv.Atoi(raw)
+		if err != nil || top < 1 || top > 10 {
+			return options, fmt.Errorf("invalid 'top': must be a number from 1 to 10")
+		}
+		options.Top = top
+	}
+	if raw := query.Get("raw"); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return options, fmt.Errorf("invalid 'raw': must be true or false")
+		}
+		options.Raw = parsed
+	}
+	if raw := query.Get("decimals"); raw != "" {
+		decimals, err := strconv.Atoi(raw)
+		if err != nil || decimals < 0 || decimals > 2 {
+			return options, fmt.Errorf("invalid 'decimals': must be 0, 1, or 2")
+		}
+		options.Format.Decimals = decimals
+	}
+	if raw := query.Get("locale"); raw != "" {
+		separators, ok := widgetLocales[raw]
+		if !ok {
+			return options, fmt.Errorf("invalid 'locale': must be one of en, de, fr, or ch")
+		}
+		options.Format.Thousands, options.Format.Decimal = separators[0], separators[1]
+	}
+	if symbol, ok := query["symbol"]; ok {
+		switch s := strings.TrimSpace(symbol[0]); {
+		case s == "none":
+			options.Format.Symbol = ""
+		case len([]rune(s)) > 5:
+			return options, fmt.Errorf("invalid 'symbol': must be at most 5 characters")
+		case s != "":
+			options.Format.Symbol = s
+		}
+	}
+	return options, nil
+}
+
+// formatAmount renders an amount with grouping, rounding, and the currency symbol, e.g., "$1,234.56"
+func formatAmount(amount float64, f numberFormat) string {
+	scale := math.Pow(10, float64(f.Decimals))
+	rounded := math.Round(math.Abs(amount)*scale) / scale
+	digits := strconv.FormatFloat(rounded, 'f', f.Decimals, 64)
+	whole, fraction, _ := strings.Cut(digits, ".")
+
+	var sb strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			sb.WriteString(f.Thousands)
+		}
+		sb.WriteRune(digit)
+	}
+	if fraction != "" {
+		sb.WriteString(f.Decimal)
+		sb.WriteString(fraction)
+	}
+	number := sb.String()
+
+	result := number
+	if f.Symbol != "" {
+		space := ""
+		if f.Space {
+			space = " "
+		}
+		if f.Right {
+			result = number + space + f.Symbol
+		} else {
+			result = f.Symbol + space + number
+		}
+	}
+	if amount < 0 && rounded != 0 {
+		result = "-" + result
+	}
+	return result
+}
+
+// GetWidgetSummary returns a small, flat summary of the current period for low-power displays and widgets
+func (h *Handler) GetWidgetSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	currency, err := h.storage.GetCurrency()
+	if err != nil {
+		currency = "usd"
+	}
+	options, err := parseWidgetOptions(r.URL.Query(), currency)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	expenses, err := h.storage.GetAllExpenses()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for widget: %v\n", err)
+		return
+	}
+
+	period := h.periodConfig().Current(time.Now())
+	totalIncome, totalExpenses, categoryTotals := summarizePeriod(h.reportable(expenses), period)
+	amount := func(value float64) any {
+		if options.Raw {
+			scale := math.Pow(10, float64(options.Format.Decimals))
+			return math.Round(value*scale) / scale
+		}
+		return formatAmount(value, options.Format)
+	}
+
+	summary := make(map[string]any, len(options.Fields))
+	for _, field := range options.Fields {
+		switch field {
+		case "period":
+			summary[field] = period.Label()
+		case "period_start":
+			summary[field] = period.Start.Format("2006-01-02")
+		case "period_end":
+			summary[field] = period.End.AddDate(0, 0, -1).Format("2006-01-02")
+		case "currency":
+			summary[field] = currency
+		case "spent":
+			summary[field] = amount(totalExpenses)
+		case "income":
+			summary[field] = amount(totalIncome)
+		case "balance":
+			summary[field] = amount(totalIncome - totalExpenses)
+		case "budget", "budget_remaining":
+			budgets, err := h.storage.GetBudgets()
+			if err != nil {
+				budgets = map[string]float64{}
+			}
+			var total, remaining float64
+			for category, limit := range budgets {
+				total += limit
+				remaining += limit - categoryTotals[category]
+			}
+			if field == "budget" {
+				summary[field] = amount(total)
+			} else {
+				summary[field] = amount(remaining)
+			}
+		case "top":
+			top := []WidgetCategory{}
+			for _, category := range getTopCategories(categoryTotals, totalExpenses, options.Top) {
+				top = append(top, WidgetCategory{Name: category.Name, Amount: amount(category.Amount)})
+			}
+			summary[field] = top
+		case "updated":
+			summary[field] = time.Now().UTC().Format(time.RFC3339)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, summary)
+	log.Println("HTTP: Served widget summary")
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/periods"
+	"github.com/tanq16/expenseowl/internal/storage"
+)
+
+// Yearly reports follow the fiscal year, which starts on the 1st of a configurable month
+// (January by default). A year in progress also gets a forecast at its current run rate.
+
+// YearlyReport summarizes a fiscal or calendar year month by month
+type YearlyReport struct {
+	Year          string            `json:"year"`  // e.g., "2026" or "FY 2025-26"
+	Start         string            `json:"start"` // YYYY-MM-DD
+	End           string            `json:"end"`   // YYYY-MM-DD, inclusive
+	Currency      string            `json:"currency"`
+	TotalIncome   float64           `json:"total_income"`
+	TotalExpenses float64           `json:"total_expenses"`
+	Balance       float64           `json:"balance"`
+	Categories    []CategorySummary `json:"categories"`         // expense categories, largest first
+	Months        []MonthlyData     `json:"months"`             // calendar months of the year
+	Forecast      *YearForecast     `json:"forecast,omitempty"` // only for the year in progress
+}
+
+// YearForecast projects the year-end totals from the spending so far
+type YearForecast struct {
+	Elapsed           float64 `json:"elapsed"` // share of the year that has passed, 0 to 1
+	ProjectedIncome   float64 `json:"projected_income"`
+	ProjectedExpenses float64 `json:"projected_expenses"`
+	ProjectedBalance  float64 `json:"projected_balance"`
+	PlannedExpenses   float64 `json:"planned_expenses"`  // planned expenses due in the rest of the year
+	PlannedSetAside   float64 `json:"planned_set_aside"` // to save each month for all planned expenses
+	PlannedOnTrack    bool    `json:"planned_on_track"`  // every planned expense keeps pace
+}
+
+// fiscalYearStart returns the configured fiscal year start month, falling back to January
+func (h *Handler) fiscalYearStart() time.Month {
+	month, err := h.storage.GetFiscalYearStart()
+	if err != nil || month < 1 || month > 12 {
+		return time.January
+	}
+	return time.Month(month)
+}
+
+// reportYear picks the year from ?year= (the year it starts in) and ?calendar=true, defaulting to the current fiscal year
+func (h *Handler) reportYear(query url.Values, now time.Time) (periods.Period, error) {
+	startMonth := h.fiscalYearStart()
+	if raw := query.Get("calendar"); raw != "" {
+		calendar, err := strconv.ParseBool(raw)
+		if err != nil {
+			return periods.Period{}, fmt.Errorf("invalid 'calendar': must be true or false")
+		}
+		if calendar {
+			startMonth = time.January
+		}
+	}
+	if raw := query.Get("year"); raw != "" {
+		year, err := strconv.Atoi(raw)
+		if err != nil || year < 1900 || year > 9999 {
+			return periods.Period{}, fmt.Errorf("invalid 'year': must be a year such as %d", now.Year())
+		}
+		return periods.FiscalYear(time.Date(year, startMonth, 1, 0, 0, 0, 0, time.UTC), startMonth), nil
+	}
+	return periods.FiscalYear(now, startMonth), nil
+}
+
+func buildYearlyReport(summarize summarizer, year periods.Period, now time.Time) *YearlyReport {
+	totalIncome, totalExpenses, categoryTotals := summarize(year)
+	months := periods.Config{Type: periods.Monthly, StartDay: 1}.Since(year.Start, year.End.AddDate(0, 0, -1))
+	report := &YearlyReport{
+		Year:          year.Label(),
+		Start:         year.Start.Format("2006-01-02"),
+		End:           year.End.AddDate(0, 0, -1).Format("2006-01-02"),
+		TotalIncome:   totalIncome,
+		TotalExpenses: totalExpenses,
+		Balance:       totalIncome - totalExpenses,
+		Categories:    getTopCategories(categoryTotals, totalExpenses, len(categoryTotals)),
+		Months:        calculatePeriodTrend(summarize, months),
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if year.Contains(today) {
+		elapsed := (today.Sub(year.Start).Hours()/24 + 1) / (year.End.Sub(year.Start).Hours() / 24)
+		report.Forecast = &YearForecast{
+			Elapsed:           elapsed,
+			ProjectedIncome:   totalIncome / elapsed,
+			ProjectedExpenses: totalExpenses / elapsed,
+			ProjectedBalance:  (totalIncome - totalExpenses) / elapsed,
+		}
+	}
+	return report
+}
+
+// GetYearlyReport returns the totals, categories, months, and forecast of a fiscal year
+func (h *Handler) GetYearlyReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	now := time.Now()
+	year, err := h.reportYear(r.URL.Query(), now)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		return
+	}
+	// fiscal years are whole months, so this reads the monthly aggregates
+	summarize, err := h.summarizerFor([]periods.Period{year}, nil)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve expenses"})
+		log.Printf("API ERROR: Failed to retrieve expenses for yearly report: %v\n", err)
+		return
+	}
+	report := buildYearlyReport(summarize, year, now)
+	report.Currency, err = h.storage.GetCurrency()
+	if err != nil {
+		report.Currency = "usd"
+	}
+	if meta, err := h.storage.GetCategoryMeta(); err == nil {
+		applyCategoryMeta(report.Categories, meta)
+	}
+	if planned, err := h.storage.GetPlannedExpenses(); err == nil && report.Forecast != nil {
+		applyPlannedExpenses(report.Forecast, planned, year, now)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// applyPlannedExpenses adds the planned expenses still to come in the year to its forecast
+func applyPlannedExpenses(forecast *YearForecast, planned []storage.PlannedExpense, year periods.Period, now time.Time) {
+	today := now.Format("2006-01-02")
+	end := year.End.AddDate(0, 0, -1).Format("2006-01-02")
+	forecast.PlannedOnTrack = true
+	for _, expense := range planned {
+		status := plannedStatus(expense, now)
+		forecast.PlannedSetAside += status.MonthlySetAside
+		forecast.PlannedOnTrack = forecast.PlannedOnTrack && status.OnTrack
+		if expense.Due >= today && expense.Due <= end {
+			forecast.PlannedExpenses += expense.Amount
+		}
+	}
+}
+
+func (h *Handler) GetFiscalYearStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	month, err := h.storage.GetFiscalYearStart()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to get fiscal year start"})
+		log.Printf("API ERROR: Failed to get fiscal year start: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, month)
+}
+
+func (h *Handler) UpdateFiscalYearStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "Method not allowed"})
+		return
+	}
+	var month int
+	if err := json.NewDecoder(r.Body).Decode(&month); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+	if month < 1 || month > 12 {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "Fiscal year start must be a month from 1 to 12"})
+		return
+	}
+	if err := h.storage.UpdateFiscalYearStart(month); err != nil {
+		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "Failed to update fiscal year start"})
+		log.Printf("API ERROR: Failed to update fiscal year start: %v\n", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
@@ -0,0 +1,176 @@
+// Package cron parses the schedules of background jobs: standard five-field cron expressions
+// (minute, hour, day of month, month, day of week), the @hourly, @daily, @weekly, @monthly, and
+// @yearly shorthands, and fixed intervals like "@every 30m".
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule tells when a job is next due
+type Schedule interface {
+	// Next returns the first time after t the job is due
+	Next(t time.Time) time.Time
+}
+
+// Every is a fixed interval, counted from the last run
+type Every time.Duration
+
+func (e Every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// Expression is a parsed cron expression; each field is a bit set of the values it matches
+type Expression struct {
+	minute, hour, dom, month, dow uint64
+	anyDay                        bool // either day field is *, so the other alone decides
+}
+
+var shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field is the range of a cron field, and the names its values can be written as
+type field struct {
+	name     string
+	min, max int
+	names    []string // from min
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField    = field{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// Parse reads a schedule, e.g., "0 3 * * *" for 3 AM every day, "*/15 * * * *", "@daily", or "@every 6h"
+func Parse(spec string) (Schedule, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if interval, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil || d < time.Minute {
+			return nil, fmt.Errorf("invalid interval '%s': must be a duration of at least 1m", interval)
+		}
+		return Every(d), nil
+	}
+	if expanded, ok := shorthands[spec]; ok {
+		spec = expanded
+	} else if strings.HasPrefix(spec, "@") {
+		return nil, fmt.Errorf("unknown schedule '%s'", spec)
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression '%s': must have 5 fields (minute hour day month weekday)", spec)
+	}
+	var e Expression
+	var err error
+	for i, f := range []struct {
+		bits *uint64
+		field
+	}{{&e.minute, minuteField}, {&e.hour, hourField}, {&e.dom, domField}, {&e.month, monthField}, {&e.dow, dowField}} {
+		if *f.bits, err = f.parse(fields[i]); err != nil {
+			return nil, err
+		}
+	}
+	// 7 is Sunday too
+	if e.dow&(1<<7) != 0 {
+		e.dow |= 1
+	}
+	e.anyDay = fields[2] == "*" || fields[4] == "*"
+	return e, nil
+}
+
+// parse reads a comma-separated list of *, values, ranges, and steps
+func (f field) parse(spec string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(spec, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepSpec); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step '%s' in %s", stepSpec, f.name)
+			}
+		}
+		low, high := f.min, f.max
+		if rangeSpec != "*" {
+			lowSpec, highSpec, isRange := strings.Cut(rangeSpec, "-")
+			var err error
+			if low, err = f.value(lowSpec); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = f.value(highSpec); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = f.max
+			}
+			if high < low {
+				return 0, fmt.Errorf("invalid range '%s' in %s", rangeSpec, f.name)
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (f field) value(spec string) (int, error) {
+	for i, name := range f.names {
+		if spec == name {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(spec)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s '%s': must be between %d and %d", f.name, spec, f.min, f.max)
+	}
+	return v, nil
+}
+
+// maxSearch bounds the search for the next time, for expressions like "0 0 31 2 *" that never match
+const maxSearch = 5 * 366 * 24 * time.Hour
+
+// Next returns the first minute after t the expression matches, or the zero time when it never does
+func (e Expression) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxSearch)
+	for t.Before(limit) {
+		switch {
+		case e.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !e.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case e.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case e.minute&(1<<t.Minute()) == 0:
+			t = t.Truncate(time.Minute).Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron: when both day fields are restricted, a day matching either is due
+func (e Expression) dayMatches(t time.Time) bool {
+	dom, dow := e.dom&(1<<t.Day()) != 0, e.dow&(1<<int(t.Weekday())) != 0
+	if e.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
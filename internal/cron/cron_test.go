@@ -0,0 +1,48 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	from := time.Date(2026, 3, 12, 10, 17, 30, 0, time.UTC) // a Thursday
+	tests := []struct {
+		spec string
+		next string
+	}{
+		{"*/15 * * * *", "2026-03-12 10:30"},
+		{"0 3 * * *", "2026-03-13 03:00"},
+		{"@hourly", "2026-03-12 11:00"},
+		{"@daily", "2026-03-13 00:00"},
+		{"@weekly", "2026-03-15 00:00"},
+		{"@monthly", "2026-04-01 00:00"},
+		{"30 8 * * mon-fri", "2026-03-13 08:30"},
+		{"0 9 * * 7", "2026-03-15 09:00"},
+		{"0 0 1,15 * *", "2026-03-15 00:00"},
+		{"0 0 1 * mon", "2026-03-16 00:00"}, // either day field matches
+		{"0 12 * jun *", "2026-06-01 12:00"},
+		{"5-10/5 * * * *", "2026-03-12 11:05"},
+		{"@every 90m", "2026-03-12 11:47"},
+		{"0 0 31 2 *", "0001-01-01 00:00"}, // never
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			schedule, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schedule.Next(from).Format("2006-01-02 15:04"); got != tt.next {
+				t.Errorf("got %s, want %s", got, tt.next)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "@often", "@every 10s", "@every soon"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("expected '%s' to be invalid", spec)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package i18n
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/periods"
+)
+
+// dateFormat spells out dates in a language; layouts use {year}, {month} (full name), {mon}
+// (short name), and {day}
+type dateFormat struct {
+	months      [12]string
+	shortMonths [12]string
+	month       string // a month of a year, e.g., January 2026
+	day         string // a day without its year, e.g., Jan 2
+	fullDay     string // a day with its year, e.g., Jan 2, 2026
+}
+
+var dateFormats = map[string]dateFormat{
+	"en": {
+		months:      [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		shortMonths: [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+		month:       "{month} {year}",
+		day:         "{mon} {day}",
+		fullDay:     "{mon} {day}, {year}",
+	},
+	"de": {
+		months:      [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		shortMonths: [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."},
+		month:       "{month} {year}",
+		day:         "{day}. {mon}",
+		fullDay:     "{day}. {mon} {year}",
+	},
+	"fr": {
+		months:      [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		shortMonths: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		month:       "{month} {year}",
+		day:         "{day} {mon}",
+		fullDay:     "{day} {mon} {year}",
+	},
+	"ko": {
+		months:      [12]string{"1월", "2월", "3월", "4월", "5월", "6월", "7월", "8월", "9월", "10월", "11월", "12월"},
+		shortMonths: [12]string{"1월", "2월", "3월", "4월", "5월", "6월", "7월", "8월", "9월", "10월", "11월", "12월"},
+		month:       "{year}년 {month}",
+		day:         "{mon} {day}일",
+		fullDay:     "{year}년 {mon} {day}일",
+	},
+	"es": {
+		months:      [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		shortMonths: [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+		month:       "{month} de {year}",
+		day:         "{day} {mon}",
+		fullDay:     "{day} {mon} {year}",
+	},
+}
+
+// separators are the thousands and decimal separators of each language
+var separators = map[string][2]string{
+	"en": {",", "."}, // 1,234.56
+	"de": {".", ","}, // 1.234,56
+	"fr": {" ", ","}, // 1 234,56
+	"ko": {",", "."}, // 1,234.56
+	"es": {".", ","}, // 1.234,56
+}
+
+func formatDate(lang string, t time.Time, layout func(dateFormat) string) string {
+	format, ok := dateFormats[lang]
+	if !ok {
+		format = dateFormats[Default]
+	}
+	return strings.NewReplacer(
+		"{year}", strconv.Itoa(t.Year()),
+		"{month}", format.months[t.Month()-1],
+		"{mon}", format.shortMonths[t.Month()-1],
+		"{day}", strconv.Itoa(t.Day()),
+	).Replace(layout(format))
+}
+
+// Month names a month in a language, e.g., "März 2026"
+func Month(lang string, t time.Time) string {
+	return formatDate(lang, t, func(f dateFormat) string { return f.month })
+}
+
+// Day names a day without its year, e.g., "2. März"
+func Day(lang string, t time.Time) string {
+	return formatDate(lang, t, func(f dateFormat) string { return f.day })
+}
+
+// FullDay names a day with its year, e.g., "2. März 2026"
+func FullDay(lang string, t time.Time) string {
+	return formatDate(lang, t, func(f dateFormat) string { return f.fullDay })
+}
+
+// Separators returns the thousands and decimal separators of a language
+func Separators(lang string) (thousands, decimal string) {
+	s, ok := separators[lang]
+	if !ok {
+		s = separators[Default]
+	}
+	return s[0], s[1]
+}
+
+// PeriodLabel is periods.Period.Label in a language; fiscal years are numbers either way
+func PeriodLabel(lang string, p periods.Period) string {
+	switch p.Type {
+	case periods.Monthly, "":
+		return Month(lang, p.Start)
+	case periods.Yearly:
+		return p.Label()
+	}
+	last := p.End.AddDate(0, 0, -1)
+	if p.Start.Year() != last.Year() {
+		return FullDay(lang, p.Start) + " - " + FullDay(lang, last)
+	}
+	return Day(lang, p.Start) + " - " + FullDay(lang, last)
+}
@@ -0,0 +1,104 @@
+// Package i18n holds the translations of the web UI and the report emails, along with the date and
+// number formats of each language. A catalog is a flat JSON map of keys to text in locales/;
+// keys a catalog is missing fall back to English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// Default is the language used when none is chosen, and for keys a catalog doesn't have
+const Default = "en"
+
+// Language is a language with a translation catalog
+type Language struct {
+	Code string `json:"code"`
+	Name string `json:"name"` // in the language itself
+}
+
+// Languages are the supported languages
+var Languages = []Language{
+	{Code: "en", Name: "English"},
+	{Code: "de", Name: "Deutsch"},
+	{Code: "fr", Name: "Français"},
+	{Code: "ko", Name: "한국어"},
+	{Code: "es", Name: "Español"},
+}
+
+var catalogs = loadCatalogs()
+
+// loadCatalogs reads the embedded catalogs; a broken catalog is a build mistake, so it panics
+func loadCatalogs() map[string]map[string]string {
+	catalogs := make(map[string]map[string]string)
+	for _, language := range Languages {
+		data, err := localeFiles.ReadFile("locales/" + language.Code + ".json")
+		if err != nil {
+			panic(fmt.Sprintf("i18n: missing catalog for %s: %v", language.Code, err))
+		}
+		var catalog map[string]string
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalog for %s: %v", language.Code, err))
+		}
+		catalogs[language.Code] = catalog
+	}
+	return catalogs
+}
+
+// Supported reports whether a language has a catalog
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Match returns the supported language of a language tag such as de-CH, or the default
+func Match(tag string) string {
+	base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	if Supported(base) {
+		return base
+	}
+	return Default
+}
+
+// Catalog returns every key in a language, with English text for the keys it doesn't translate
+func Catalog(lang string) map[string]string {
+	catalog := maps.Clone(catalogs[Default])
+	if lang != Default {
+		maps.Copy(catalog, catalogs[lang])
+	}
+	return catalog
+}
+
+// Missing lists the keys of the English catalog that a language doesn't translate
+func Missing(lang string) []string {
+	var missing []string
+	for key := range catalogs[Default] {
+		if _, ok := catalogs[lang][key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	slices.Sort(missing)
+	return missing
+}
+
+// T translates a key, formatting args into the text like fmt.Sprintf; unknown keys return the key
+func T(lang, key string, args ...any) string {
+	text, ok := catalogs[lang][key]
+	if !ok {
+		text, ok = catalogs[Default][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
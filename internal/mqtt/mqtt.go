@@ -0,0 +1,113 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Minimal MQTT 3.1.1 publisher (QoS 0) - enough for pushing state to Home Assistant
+// without pulling in a client library. A connection is made per publish.
+
+const dialTimeout = 10 * time.Second
+
+// Config for the MQTT broker connection
+type Config struct {
+	Broker   string // host:port
+	ClientID string
+	Username string
+	Password string
+}
+
+// Publish sends a single QoS 0 message to the broker
+func Publish(cfg Config, topic string, payload []byte, retain bool) error {
+	conn, err := net.DialTimeout("tcp", cfg.Broker, dialTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to broker: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	if _, err := conn.Write(connectPacket(cfg)); err != nil {
+		return fmt.Errorf("failed to send connect: %v", err)
+	}
+	connack := make([]byte, 4)
+	if _, err := io.ReadFull(bufio.NewReader(conn), connack); err != nil {
+		return fmt.Errorf("failed to read connack: %v", err)
+	}
+	if connack[0] != 0x20 {
+		return fmt.Errorf("unexpected packet type from broker: %#x", connack[0])
+	}
+	if connack[3] != 0 {
+		return fmt.Errorf("broker refused connection with code %d", connack[3])
+	}
+
+	if _, err := conn.Write(publishPacket(topic, payload, retain)); err != nil {
+		return fmt.Errorf("failed to publish: %v", err)
+	}
+	_, err = conn.Write([]byte{0xE0, 0x00}) // DISCONNECT
+	return err
+}
+
+func connectPacket(cfg Config) []byte {
+	var body bytes.Buffer
+	body.Write(encodeString("MQTT"))
+	body.WriteByte(0x04) // protocol level 3.1.1
+	flags := byte(0x02)  // clean session
+	if cfg.Username != "" {
+		flags |= 0x80
+		if cfg.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body.WriteByte(flags)
+	body.Write([]byte{0x00, 0x3C}) // keep alive 60s
+	body.Write(encodeString(cfg.ClientID))
+	if cfg.Username != "" {
+		body.Write(encodeString(cfg.Username))
+		if cfg.Password != "" {
+			body.Write(encodeString(cfg.Password))
+		}
+	}
+	return packet(0x10, body.Bytes())
+}
+
+func publishPacket(topic string, payload []byte, retain bool) []byte {
+	var body bytes.Buffer
+	body.Write(encodeString(topic))
+	body.Write(payload)
+	header := byte(0x30)
+	if retain {
+		header |= 0x01
+	}
+	return packet(header, body.Bytes())
+}
+
+func packet(header byte, body []byte) []byte {
+	out := []byte{header}
+	out = append(out, encodeLength(len(body))...)
+	return append(out, body...)
+}
+
+func encodeString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+// encodeLength writes the MQTT variable length "remaining length" field
+func encodeLength(length int) []byte {
+	var out []byte
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		out = append(out, digit)
+		if length == 0 {
+			return out
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package mqtt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestConnectPacket checks the CONNECT bytes with and without credentials
+func TestConnectPacket(t *testing.T) {
+	got := connectPacket(Config{ClientID: "eo", Username: "ha", Password: "pw"})
+	want := []byte{
+		0x10, 22, // CONNECT, remaining length
+		0x00, 0x04, 'M', 'Q', 'T', 'T',
+		0x04,       // protocol level 3.1.1
+		0xC2,       // username, password, clean session
+		0x00, 0x3C, // keep alive 60s
+		0x00, 0x02, 'e', 'o',
+		0x00, 0x02, 'h', 'a',
+		0x00, 0x02, 'p', 'w',
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected connect packet % x, got % x", want, got)
+	}
+
+	got = connectPacket(Config{ClientID: "eo", Password: "pw"})
+	want = []byte{0x10, 14, 0x00, 0x04, 'M', 'Q', 'T', 'T', 0x04, 0x02, 0x00, 0x3C, 0x00, 0x02, 'e', 'o'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected a password without a username to be left out, got % x", got)
+	}
+}
+
+// TestPublishPacket checks the PUBLISH bytes, including a remaining length over 127 bytes
+func TestPublishPacket(t *testing.T) {
+	got := publishPacket("a/b", []byte("hi"), false)
+	want := []byte{0x30, 7, 0x00, 0x03, 'a', '/', 'b', 'h', 'i'}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected publish packet % x, got % x", want, got)
+	}
+	if got := publishPacket("a/b", []byte("hi"), true); got[0] != 0x31 {
+		t.Errorf("Expected the retain flag in the header, got %#x", got[0])
+	}
+
+	// 2 + 3 + 200 = 205 bytes remaining, which takes two length bytes
+	payload := []byte(strings.Repeat("x", 200))
+	got = publishPacket("a/b", payload, false)
+	if len(got) != 1+2+205 {
+		t.Fatalf("Expected %d bytes, got %d", 1+2+205, len(got))
+	}
+	if !bytes.Equal(got[:3], []byte{0x30, 0xCD, 0x01}) {
+		t.Errorf("Expected the header and length 30 cd 01, got % x", got[:3])
+	}
+	if !bytes.Equal(got[3:8], []byte{0x00, 0x03, 'a', '/', 'b'}) || !bytes.Equal(got[8:], payload) {
+		t.Error("Expected the topic and payload after the length")
+	}
+}
+
+// TestEncodeLength checks the variable length encoding at the boundaries of each byte count
+func TestEncodeLength(t *testing.T) {
+	for length, want := range map[int][]byte{
+		0:         {0x00},
+		127:       {0x7F},
+		128:       {0x80, 0x01},
+		16383:     {0xFF, 0x7F},
+		16384:     {0x80, 0x80, 0x01},
+		268435455: {0xFF, 0xFF, 0xFF, 0x7F},
+	} {
+		if got := encodeLength(length); !bytes.Equal(got, want) {
+			t.Errorf("Expected length %d to be % x, got % x", length, want, got)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Text recognition for receipt photos, either with a local Tesseract binary or a cloud service
+// behind a small HTTP contract, so ExpenseOwl doesn't link any OCR library.
+
+const requestTimeout = 60 * time.Second
+
+// Engine turns an image into text
+type Engine interface {
+	Recognize(ctx context.Context, image []byte, contentType string) (string, error)
+}
+
+// Tesseract runs the tesseract command line tool, which reads the image from stdin
+type Tesseract struct {
+	Path     string // binary, "tesseract" to look it up in PATH
+	Language string // e.g., "eng" or "deu+eng"; empty uses tesseract's default
+}
+
+func (t Tesseract) Recognize(ctx context.Context, image []byte, contentType string) (string, error) {
+	args := []string{"stdin", "stdout"}
+	if t.Language != "" {
+		args = append(args, "-l", t.Language)
+	}
+	cmd := exec.CommandContext(ctx, t.Path, args...)
+	cmd.Stdin = bytes.NewReader(image)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tesseract failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// HTTP posts the image to a service that answers with plain text or JSON like {"text": "..."}; an
+// adapter in front of a cloud OCR API only has to follow that contract
+type HTTP struct {
+	URL   string
+	Token string // sent as a bearer token when set
+}
+
+func (h HTTP) Recognize(ctx context.Context, image []byte, contentType string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(image))
+	if err != nil {
+		return "", fmt.Errorf("failed to create OCR request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("OCR request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read OCR response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("OCR service returned %s", resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		var result struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return "", fmt.Errorf("failed to parse OCR response: %v", err)
+		}
+		return result.Text, nil
+	}
+	return string(body), nil
+}
@@ -0,0 +1,226 @@
+// Package periods computes the budgeting periods used for reports, integrations, and budgets.
+// A period is either a month starting on a configurable day, or a week or fortnight
+// aligned to an anchor date (e.g., a payday). Yearly reports use fiscal years instead.
+package periods
+
+import (
+	"fmt"
+	"time"
+)
+
+type Type string
+
+const (
+	Monthly   Type = "monthly"
+	Weekly    Type = "weekly"
+	Biweekly  Type = "biweekly"
+	Yearly    Type = "yearly"    // fiscal years, only used for reports
+	Statement Type = "statement" // statement cycles of an account, only used for reports
+)
+
+// AnchorLayout is the date format for anchors
+const AnchorLayout = "2006-01-02"
+
+// defaultAnchor is a Monday, so weekly periods without an anchor run Monday to Sunday
+var defaultAnchor = time.Date(1970, 1, 5, 0, 0, 0, 0, time.UTC)
+
+// Config describes how time is split into periods
+type Config struct {
+	Type     Type   `json:"type"`               // monthly, weekly, biweekly
+	StartDay int    `json:"startDay,omitempty"` // monthly: day of the month a period starts on
+	Anchor   string `json:"anchor,omitempty"`   // weekly/biweekly: YYYY-MM-DD of any period start
+}
+
+// Period is a half-open range [Start, End)
+type Period struct {
+	Type  Type      `json:"type"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Default returns calendar months starting on the 1st
+func Default() Config {
+	return Config{Type: Monthly, StartDay: 1}
+}
+
+// Validate normalizes an empty type to monthly and checks the remaining fields
+func (c *Config) Validate() error {
+	if c.Type == "" {
+		c.Type = Monthly
+	}
+	switch c.Type {
+	case Monthly:
+		if c.StartDay == 0 {
+			c.StartDay = 1
+		}
+		if c.StartDay < 1 || c.StartDay > 31 {
+			return fmt.Errorf("invalid start day: %d", c.StartDay)
+		}
+	case Weekly, Biweekly:
+		if c.Anchor != "" {
+			if _, err := time.Parse(AnchorLayout, c.Anchor); err != nil {
+				return fmt.Errorf("invalid anchor date '%s', expected YYYY-MM-DD", c.Anchor)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid period type: '%s'. Must be one of 'monthly', 'weekly', or 'biweekly'", c.Type)
+	}
+	return nil
+}
+
+// Containing returns the period that includes t; periods are computed on calendar dates in UTC
+func (c Config) Containing(t time.Time) Period {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch c.Type {
+	case Weekly:
+		return c.fixedLength(day, 7)
+	case Biweekly:
+		return c.fixedLength(day, 14)
+	}
+	startDay := c.StartDay
+	if startDay < 1 {
+		startDay = 1
+	}
+	thisStart := monthStart(day.Year(), day.Month(), startDay)
+	if day.Before(thisStart) {
+		return Period{Type: Monthly, Start: monthStart(day.Year(), day.Month()-1, startDay), End: thisStart}
+	}
+	return Period{Type: Monthly, Start: thisStart, End: monthStart(day.Year(), day.Month()+1, startDay)}
+}
+
+// Current returns the period containing now
+func (c Config) Current(now time.Time) Period {
+	return c.Containing(now)
+}
+
+// Previous returns the period right before p
+func (c Config) Previous(p Period) Period {
+	return c.Containing(p.Start.AddDate(0, 0, -1))
+}
+
+// Next returns the period right after p
+func (c Config) Next(p Period) Period {
+	return c.Containing(p.End)
+}
+
+// Last returns the n periods up to and including the current one, oldest first
+func (c Config) Last(n int, now time.Time) []Period {
+	if n <= 0 {
+		return []Period{}
+	}
+	result := make([]Period, n)
+	p := c.Current(now)
+	for i := n - 1; i >= 0; i-- {
+		result[i] = p
+		p = c.Previous(p)
+	}
+	return result
+}
+
+// Since returns the periods from the one containing start up to and including the current one
+func (c Config) Since(start time.Time, now time.Time) []Period {
+	result := []Period{}
+	current := c.Current(now)
+	for p := c.Containing(start); !p.Start.After(current.Start); p = c.Next(p) {
+		result = append(result, p)
+	}
+	return result
+}
+
+// FiscalYear returns the year starting on the 1st of startMonth that includes t;
+// a January start gives calendar years
+func FiscalYear(t time.Time, startMonth time.Month) Period {
+	if startMonth < time.January || startMonth > time.December {
+		startMonth = time.January
+	}
+	year := t.Year()
+	if t.Month() < startMonth {
+		year--
+	}
+	start := time.Date(year, startMonth, 1, 0, 0, 0, 0, time.UTC)
+	return Period{Type: Yearly, Start: start, End: start.AddDate(1, 0, 0)}
+}
+
+// StatementContaining returns the statement cycle that includes t, for statements closing on
+// closeDay of each month (on the last day of shorter months); a cycle runs from the day after
+// one close through the next
+func StatementContaining(t time.Time, closeDay int) Period {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	closing := monthStart(day.Year(), day.Month(), closeDay)
+	previous := monthStart(day.Year(), day.Month()-1, closeDay)
+	if day.After(closing) {
+		previous, closing = closing, monthStart(day.Year(), day.Month()+1, closeDay)
+	}
+	return Period{Type: Statement, Start: previous.AddDate(0, 0, 1), End: closing.AddDate(0, 0, 1)}
+}
+
+// LastStatements returns the n statement cycles up to and including the one open now, oldest first
+func LastStatements(n int, closeDay int, now time.Time) []Period {
+	if n <= 0 {
+		return []Period{}
+	}
+	result := make([]Period, n)
+	p := StatementContaining(now, closeDay)
+	for i := n - 1; i >= 0; i-- {
+		result[i] = p
+		p = StatementContaining(p.Start.AddDate(0, 0, -1), closeDay)
+	}
+	return result
+}
+
+func (c Config) fixedLength(day time.Time, length int) Period {
+	anchor := defaultAnchor
+	if parsed, err := time.Parse(AnchorLayout, c.Anchor); err == nil {
+		anchor = parsed
+	}
+	days := int((day.Unix() - anchor.Unix()) / 86400)
+	offset := ((days % length) + length) % length
+	start := day.AddDate(0, 0, -offset)
+	return Period{Type: c.Type, Start: start, End: start.AddDate(0, 0, length)}
+}
+
+// monthStart clamps the start day to the length of the month (e.g., 31 becomes Feb 28)
+func monthStart(year int, month time.Month, startDay int) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	daysInMonth := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(startDay, daysInMonth)-1)
+}
+
+// Contains reports whether t falls within the period
+func (p Period) Contains(t time.Time) bool {
+	return !t.Before(p.Start) && t.Before(p.End)
+}
+
+// Label is a long description, e.g., "January 2026", "Mar 6 - Mar 19, 2026", or "FY 2025-26"
+func (p Period) Label() string {
+	if p.Type == Monthly || p.Type == "" {
+		return p.Start.Format("January 2006")
+	}
+	if p.Type == Yearly {
+		return p.yearLabel()
+	}
+	last := p.End.AddDate(0, 0, -1)
+	if p.Start.Year() != last.Year() {
+		return p.Start.Format("Jan 2, 2006") + " - " + last.Format("Jan 2, 2006")
+	}
+	return p.Start.Format("Jan 2") + " - " + last.Format("Jan 2, 2006")
+}
+
+// ShortLabel is a compact description for charts, e.g., "Jan 2026" or "Mar 6"
+func (p Period) ShortLabel() string {
+	if p.Type == Monthly || p.Type == "" {
+		return p.Start.Format("Jan 2006")
+	}
+	if p.Type == Yearly {
+		return p.yearLabel()
+	}
+	return p.Start.Format("Jan 2")
+}
+
+// yearLabel names calendar years by their year and fiscal years by both years they span
+func (p Period) yearLabel() string {
+	if p.Start.Month() == time.January {
+		return p.Start.Format("2006")
+	}
+	return fmt.Sprintf("FY %d-%02d", p.Start.Year(), (p.Start.Year()+1)%100)
+}
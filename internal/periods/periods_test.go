@@ -0,0 +1,123 @@
+package periods
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContaining(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(AnchorLayout, s)
+		return d
+	}
+	tests := []struct {
+		name   string
+		config Config
+		date   string
+		start  string
+		end    string
+	}{
+		{"calendar month", Config{Type: Monthly, StartDay: 1}, "2026-03-15", "2026-03-01", "2026-04-01"},
+		{"monthly before start day", Config{Type: Monthly, StartDay: 25}, "2026-03-10", "2026-02-25", "2026-03-25"},
+		{"monthly clamps to short month", Config{Type: Monthly, StartDay: 31}, "2026-02-28", "2026-02-28", "2026-03-31"},
+		{"weekly default monday", Config{Type: Weekly}, "2026-03-12", "2026-03-09", "2026-03-16"},
+		{"weekly anchored", Config{Type: Weekly, Anchor: "2026-01-02"}, "2026-03-12", "2026-03-06", "2026-03-13"},
+		{"biweekly payday", Config{Type: Biweekly, Anchor: "2026-01-02"}, "2026-03-12", "2026-02-27", "2026-03-13"},
+		{"biweekly before anchor", Config{Type: Biweekly, Anchor: "2026-01-02"}, "2025-12-25", "2025-12-19", "2026-01-02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.config.Containing(day(tt.date))
+			if !p.Start.Equal(day(tt.start)) || !p.End.Equal(day(tt.end)) {
+				t.Errorf("got %s - %s, want %s - %s", p.Start.Format(AnchorLayout), p.End.Format(AnchorLayout), tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestLast(t *testing.T) {
+	config := Config{Type: Biweekly, Anchor: "2026-01-02"}
+	got := config.Last(3, time.Date(2026, 3, 12, 10, 0, 0, 0, time.UTC))
+	if len(got) != 3 {
+		t.Fatalf("expected 3 periods, got %d", len(got))
+	}
+	for i := 1; i < len(got); i++ {
+		if !got[i-1].End.Equal(got[i].Start) {
+			t.Errorf("period %d does not follow period %d", i, i-1)
+		}
+	}
+	if got[0].Start.Format(AnchorLayout) != "2026-01-30" {
+		t.Errorf("expected oldest period to start 2026-01-30, got %s", got[0].Start.Format(AnchorLayout))
+	}
+}
+
+func TestFiscalYear(t *testing.T) {
+	tests := []struct {
+		name  string
+		date  time.Time
+		month time.Month
+		start string
+		label string
+	}{
+		{"calendar year", time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC), time.January, "2026-01-01", "2026"},
+		{"april start, after start", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), time.April, "2026-04-01", "FY 2026-27"},
+		{"april start, before start", time.Date(2026, 3, 31, 23, 0, 0, 0, time.UTC), time.April, "2025-04-01", "FY 2025-26"},
+		{"invalid month is calendar", time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC), 0, "2026-01-01", "2026"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := FiscalYear(tt.date, tt.month)
+			if p.Start.Format(AnchorLayout) != tt.start || !p.End.Equal(p.Start.AddDate(1, 0, 0)) || p.Label() != tt.label {
+				t.Errorf("got %s - %s (%s), want start %s (%s)", p.Start.Format(AnchorLayout), p.End.Format(AnchorLayout), p.Label(), tt.start, tt.label)
+			}
+		})
+	}
+}
+
+func TestSince(t *testing.T) {
+	config := Config{Type: Monthly, StartDay: 15}
+	got := config.Since(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 6, 20, 0, 0, 0, 0, time.UTC))
+	if len(got) != 4 || got[0].Start.Format(AnchorLayout) != "2026-03-15" || got[3].Start.Format(AnchorLayout) != "2026-06-15" {
+		t.Errorf("expected the 4 periods from Mar 15 to Jun 15, got %+v", got)
+	}
+}
+
+func TestStatementContaining(t *testing.T) {
+	tests := []struct {
+		name     string
+		date     time.Time
+		closeDay int
+		start    string
+		end      string
+	}{
+		{"on the close", time.Date(2026, 3, 14, 18, 0, 0, 0, time.UTC), 14, "2026-02-15", "2026-03-15"},
+		{"after the close", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC), 14, "2026-03-15", "2026-04-15"},
+		{"close on the last day", time.Date(2026, 2, 10, 0, 0, 0, 0, time.UTC), 31, "2026-02-01", "2026-03-01"},
+		{"close clamps to short month", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), 30, "2026-03-01", "2026-03-31"},
+		{"across the year", time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC), 20, "2025-12-21", "2026-01-21"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := StatementContaining(tt.date, tt.closeDay)
+			if p.Type != Statement || p.Start.Format(AnchorLayout) != tt.start || p.End.Format(AnchorLayout) != tt.end {
+				t.Errorf("got %s - %s, want %s - %s", p.Start.Format(AnchorLayout), p.End.Format(AnchorLayout), tt.start, tt.end)
+			}
+		})
+	}
+	got := LastStatements(3, 14, time.Date(2026, 3, 20, 0, 0, 0, 0, time.UTC))
+	if len(got) != 3 || got[0].Start.Format(AnchorLayout) != "2026-01-15" || !got[1].End.Equal(got[2].Start) || got[2].Label() != "Mar 15 - Apr 14, 2026" {
+		t.Errorf("expected the statements from Jan 15 to Apr 14, got %+v", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	c := Config{}
+	if err := c.Validate(); err != nil || c.Type != Monthly || c.StartDay != 1 {
+		t.Errorf("expected empty config to default to monthly on the 1st, got %+v (%v)", c, err)
+	}
+	for _, bad := range []Config{{Type: "daily"}, {Type: Monthly, StartDay: 32}, {Type: Biweekly, Anchor: "03/06/2026"}} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("expected error for %+v", bad)
+		}
+	}
+}
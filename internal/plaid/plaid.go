@@ -0,0 +1,167 @@
+package plaid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// A minimal client for the Plaid API, covering what bank sync needs: creating a Link token,
+// exchanging the public token Link returns for an access token, and the transactions sync
+// endpoint. Users bring their own Plaid credentials; nothing here talks to Plaid otherwise.
+
+const requestTimeout = 30 * time.Second
+
+// Plaid environments, by the value of PLAID_ENV
+var environments = map[string]string{
+	"sandbox":    "https://sandbox.plaid.com",
+	"production": "https://production.plaid.com",
+}
+
+// Client calls the Plaid API with a client ID and secret
+type Client struct {
+	ClientID string
+	Secret   string
+	BaseURL  string // e.g., https://sandbox.plaid.com
+}
+
+// New returns a client for a Plaid environment, sandbox or production
+func New(clientID, secret, env string) (*Client, error) {
+	if env == "" {
+		env = "sandbox"
+	}
+	baseURL, ok := environments[env]
+	if !ok {
+		return nil, fmt.Errorf("unknown Plaid environment '%s', must be sandbox or production", env)
+	}
+	return &Client{ClientID: clientID, Secret: secret, BaseURL: baseURL}, nil
+}
+
+// Error is an error response of the Plaid API
+type Error struct {
+	Type    string `json:"error_type"`
+	Code    string `json:"error_code"` // e.g., ITEM_LOGIN_REQUIRED
+	Message string `json:"error_message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("plaid %s: %s", e.Code, e.Message)
+}
+
+// post sends a request with the credentials added and decodes the response into out
+func (c *Client) post(ctx context.Context, path string, body map[string]any, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	body["client_id"] = c.ClientID
+	body["secret"] = c.Secret
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode plaid request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create plaid request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("plaid request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read plaid response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var plaidErr Error
+		if json.Unmarshal(data, &plaidErr) == nil && plaidErr.Code != "" {
+			return &plaidErr
+		}
+		return fmt.Errorf("plaid returned %s for %s", resp.Status, path)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to parse plaid response: %v", err)
+	}
+	return nil
+}
+
+// CreateLinkToken starts a Link session for connecting a bank; webhook receives sync
+// notifications and may be empty
+func (c *Client) CreateLinkToken(ctx context.Context, user, webhook string) (string, error) {
+	body := map[string]any{
+		"client_name":   "ExpenseOwl",
+		"user":          map[string]string{"client_user_id": user},
+		"products":      []string{"transactions"},
+		"country_codes": []string{"US"},
+		"language":      "en",
+	}
+	if webhook != "" {
+		body["webhook"] = webhook
+	}
+	var result struct {
+		LinkToken string `json:"link_token"`
+	}
+	if err := c.post(ctx, "/link/token/create", body, &result); err != nil {
+		return "", err
+	}
+	return result.LinkToken, nil
+}
+
+// ExchangePublicToken trades the public token from Link for the item's access token and ID
+func (c *Client) ExchangePublicToken(ctx context.Context, publicToken string) (accessToken string, itemID string, err error) {
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ItemID      string `json:"item_id"`
+	}
+	if err := c.post(ctx, "/item/public_token/exchange", map[string]any{"public_token": publicToken}, &result); err != nil {
+		return "", "", err
+	}
+	return result.AccessToken, result.ItemID, nil
+}
+
+// RemoveItem revokes an access token, so Plaid stops fetching the bank's data
+func (c *Client) RemoveItem(ctx context.Context, accessToken string) error {
+	var result struct{}
+	return c.post(ctx, "/item/remove", map[string]any{"access_token": accessToken}, &result)
+}
+
+// Transaction is a bank transaction; Amount is positive for money leaving the account
+type Transaction struct {
+	ID           string  `json:"transaction_id"`
+	AccountID    string  `json:"account_id"`
+	Amount       float64 `json:"amount"`
+	Currency     string  `json:"iso_currency_code"`
+	Date         string  `json:"date"` // YYYY-MM-DD it posted
+	Name         string  `json:"name"` // as on the statement
+	MerchantName string  `json:"merchant_name"`
+	Pending      bool    `json:"pending"`
+	Category     struct {
+		Primary string `json:"primary"` // e.g., FOOD_AND_DRINK
+	} `json:"personal_finance_category"`
+}
+
+// SyncPage is one page of changes since a cursor
+type SyncPage struct {
+	Added    []Transaction `json:"added"`
+	Modified []Transaction `json:"modified"`
+	Removed  []struct {
+		ID string `json:"transaction_id"`
+	} `json:"removed"`
+	NextCursor string `json:"next_cursor"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// SyncTransactions returns the changes since cursor, empty for the full history
+func (c *Client) SyncTransactions(ctx context.Context, accessToken, cursor string) (SyncPage, error) {
+	body := map[string]any{"access_token": accessToken, "count": 500}
+	if cursor != "" {
+		body["cursor"] = cursor
+	}
+	var page SyncPage
+	err := c.post(ctx, "/transactions/sync", body, &page)
+	return page, err
+}
@@ -0,0 +1,72 @@
+package prices
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Price lookups for investment holdings. ExpenseOwl doesn't bundle a market data API; a provider
+// is anything that can quote a ticker, and HTTP covers most of them with a small adapter.
+
+const requestTimeout = 30 * time.Second
+
+// Provider returns the latest price of a ticker in the display currency
+type Provider interface {
+	Quote(ctx context.Context, ticker string) (float64, error)
+}
+
+// HTTP gets a URL with {ticker} replaced by the ticker and reads the price from a plain number or
+// JSON like {"price": 123.45}
+type HTTP struct {
+	URL   string // e.g., https://quotes.example.com/price?symbol={ticker}
+	Token string // sent as a bearer token when set
+}
+
+func (h HTTP) Quote(ctx context.Context, ticker string) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	target := strings.ReplaceAll(h.URL, "{ticker}", url.QueryEscape(ticker))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create price request: %v", err)
+	}
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("price request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read price response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("price service returned %s for %s", resp.Status, ticker)
+	}
+	var price float64
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		var result struct {
+			Price float64 `json:"price"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return 0, fmt.Errorf("failed to parse price response: %v", err)
+		}
+		price = result.Price
+	} else if price, err = strconv.ParseFloat(strings.TrimSpace(string(body)), 64); err != nil {
+		return 0, fmt.Errorf("failed to parse price response: %v", err)
+	}
+	if price <= 0 {
+		return 0, fmt.Errorf("price service returned no price for %s", ticker)
+	}
+	return price, nil
+}
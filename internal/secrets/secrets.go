@@ -0,0 +1,173 @@
+// Package secrets encrypts credentials kept in storage with AES-256-GCM. The key comes from
+// SECRETS_KEY (base64) or SECRETS_KEY_FILE. After a key rotation, the old keys go in
+// SECRETS_PREVIOUS_KEYS so existing values can still be read and re-encrypted with the new key.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sealed values look like enc:v1:<key id>:<base64 nonce and ciphertext>
+const prefix = "enc:v1:"
+
+// KeySize is the size of a key in bytes, for AES-256
+const KeySize = 32
+
+type key struct {
+	id   string // first bytes of the key's SHA-256, to pick the key when opening
+	aead cipher.AEAD
+}
+
+// Box seals values with the current key and opens values sealed with any of its keys
+type Box struct {
+	current  key
+	previous []key
+}
+
+func newKey(raw []byte) (key, error) {
+	if len(raw) != KeySize {
+		return key{}, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return key{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return key{}, err
+	}
+	sum := sha256.Sum256(raw)
+	return key{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+// New creates a box from the current key and any previous keys
+func New(current []byte, previous ...[]byte) (*Box, error) {
+	k, err := newKey(current)
+	if err != nil {
+		return nil, err
+	}
+	box := &Box{current: k}
+	for i, raw := range previous {
+		k, err := newKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("previous key %d: %v", i+1, err)
+		}
+		box.previous = append(box.previous, k)
+	}
+	return box, nil
+}
+
+// ParseKey decodes a base64 key, e.g., from `openssl rand -base64 32`
+func ParseKey(encoded string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %v", err)
+	}
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	return raw, nil
+}
+
+// FromEnv reads SECRETS_KEY or SECRETS_KEY_FILE and SECRETS_PREVIOUS_KEYS (comma separated);
+// it returns nil when no key is set
+func FromEnv() (*Box, error) {
+	encoded := os.Getenv("SECRETS_KEY")
+	if path := os.Getenv("SECRETS_KEY_FILE"); encoded == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %v", err)
+		}
+		encoded = string(data)
+	}
+	if encoded == "" {
+		return nil, nil
+	}
+	current, err := ParseKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var previous [][]byte
+	for _, encoded := range strings.Split(os.Getenv("SECRETS_PREVIOUS_KEYS"), ",") {
+		if strings.TrimSpace(encoded) == "" {
+			continue
+		}
+		raw, err := ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous key: %v", err)
+		}
+		previous = append(previous, raw)
+	}
+	return New(current, previous...)
+}
+
+// IsSealed reports whether a value was sealed by a box
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Seal encrypts a value with the current key; empty values stay empty
+func (b *Box) Seal(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	nonce := make([]byte, b.current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.current.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return prefix + b.current.id + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a sealed value; values that aren't sealed, e.g., stored before encryption was
+// enabled, are returned as they are
+func (b *Box) Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !ok {
+		return "", fmt.Errorf("malformed sealed value")
+	}
+	k, ok := b.key(id)
+	if !ok {
+		return "", fmt.Errorf("value was sealed with an unknown key (%s); add it to SECRETS_PREVIOUS_KEYS", id)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(data) < k.aead.NonceSize() {
+		return "", fmt.Errorf("malformed sealed value")
+	}
+	plaintext, err := k.aead.Open(nil, data[:k.aead.NonceSize()], data[k.aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReseal reports whether a value isn't sealed with the current key yet
+func (b *Box) NeedsReseal(value string) bool {
+	if value == "" {
+		return false
+	}
+	return !strings.HasPrefix(value, prefix+b.current.id+":")
+}
+
+func (b *Box) key(id string) (key, bool) {
+	if id == b.current.id {
+		return b.current, true
+	}
+	for _, k := range b.previous {
+		if k.id == id {
+			return k, true
+		}
+	}
+	return key{}, false
+}
@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	box, err := New(bytes.Repeat([]byte{1}, KeySize))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sealed, err := box.Seal("smtp-password")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "smtp-password") {
+		t.Errorf("Expected an encrypted value, got %s", sealed)
+	}
+	again, _ := box.Seal("smtp-password")
+	if again == sealed {
+		t.Error("Expected a new nonce for every seal")
+	}
+	if opened, err := box.Open(sealed); err != nil || opened != "smtp-password" {
+		t.Errorf("Expected the original value, got %q (%v)", opened, err)
+	}
+	if opened, err := box.Open("stored-before-encryption"); err != nil || opened != "stored-before-encryption" {
+		t.Errorf("Expected plain values to pass through, got %q (%v)", opened, err)
+	}
+	i := len(sealed) - 10 // inside the authentication tag
+	tampered := sealed[:i] + "A" + sealed[i+1:]
+	if sealed[i] == 'A' {
+		tampered = sealed[:i] + "B" + sealed[i+1:]
+	}
+	if _, err := box.Open(tampered); err == nil {
+		t.Error("Expected an error for a tampered value")
+	}
+}
+
+func TestRotation(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, KeySize), bytes.Repeat([]byte{2}, KeySize)
+	oldBox, _ := New(oldKey)
+	sealed, _ := oldBox.Seal("token")
+
+	rotated, err := New(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !rotated.NeedsReseal(sealed) || !rotated.NeedsReseal("plain") || rotated.NeedsReseal("") {
+		t.Error("Expected values not sealed with the current key to need resealing")
+	}
+	if opened, err := rotated.Open(sealed); err != nil || opened != "token" {
+		t.Errorf("Expected the previous key to open the value, got %q (%v)", opened, err)
+	}
+	resealed, _ := rotated.Seal("token")
+	if rotated.NeedsReseal(resealed) {
+		t.Error("Expected a value sealed with the current key not to need resealing")
+	}
+
+	newOnly, _ := New(newKey)
+	if _, err := newOnly.Open(sealed); err == nil {
+		t.Error("Expected an error for a value sealed with an unknown key")
+	}
+	if _, err := New([]byte("short")); err == nil {
+		t.Error("Expected an error for a short key")
+	}
+}
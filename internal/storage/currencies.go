@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Currencies are identified by lowercase codes. The ISO 4217 table covers national currencies, and
+// custom currencies add units it doesn't have, e.g., loyalty points or crypto, with their own symbol
+// and number of decimal places.
+
+// Currency describes how amounts in a currency are written
+type Currency struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol,omitempty"` // the uppercase code is shown when empty
+	Decimals int    `json:"decimals"`         // digits after the decimal point, e.g., 0 for yen and 8 for bitcoin
+	Custom   bool   `json:"custom,omitempty"`
+}
+
+// MaxCurrencyDecimals is the most decimal places a custom currency can have
+const MaxCurrencyDecimals = 8
+
+// isoCurrencies lists the active ISO 4217 currencies with their minor units
+var isoCurrencies = []Currency{
+	{Code: "aed", Name: "UAE Dirham", Symbol: "AED", Decimals: 2},
+	{Code: "afn", Name: "Afghan Afghani", Decimals: 2},
+	{Code: "all", Name: "Albanian Lek", Decimals: 2},
+	{Code: "amd", Name: "Armenian Dram", Decimals: 2},
+	{Code: "ang", Name: "Netherlands Antillean Guilder", Decimals: 2},
+	{Code: "aoa", Name: "Angolan Kwanza", Decimals: 2},
+	{Code: "ars", Name: "Argentine Peso", Decimals: 2},
+	{Code: "aud", Name: "Australian Dollar", Symbol: "A$", Decimals: 2},
+	{Code: "awg", Name: "Aruban Florin", Decimals: 2},
+	{Code: "azn", Name: "Azerbaijani Manat", Decimals: 2},
+	{Code: "bam", Name: "Bosnia and Herzegovina Convertible Mark", Decimals: 2},
+	{Code: "bbd", Name: "Barbadian Dollar", Decimals: 2},
+	{Code: "bdt", Name: "Bangladeshi Taka", Symbol: "৳", Decimals: 2},
+	{Code: "bgn", Name: "Bulgarian Lev", Decimals: 2},
+	{Code: "bhd", Name: "Bahraini Dinar", Decimals: 3},
+	{Code: "bif", Name: "Burundian Franc", Decimals: 0},
+	{Code: "bmd", Name: "Bermudian Dollar", Decimals: 2},
+	{Code: "bnd", Name: "Brunei Dollar", Decimals: 2},
+	{Code: "bob", Name: "Bolivian Boliviano", Decimals: 2},
+	{Code: "brl", Name: "Brazilian Real", Symbol: "R$", Decimals: 2},
+	{Code: "bsd", Name: "Bahamian Dollar", Decimals: 2},
+	{Code: "btn", Name: "Bhutanese Ngultrum", Decimals: 2},
+	{Code: "bwp", Name: "Botswana Pula", Decimals: 2},
+	{Code: "byn", Name: "Belarusian Ruble", Decimals: 2},
+	{Code: "bzd", Name: "Belize Dollar", Decimals: 2},
+	{Code: "cad", Name: "Canadian Dollar", Symbol: "C$", Decimals: 2},
+	{Code: "cdf", Name: "Congolese Franc", Decimals: 2},
+	{Code: "chf", Name: "Swiss Franc", Symbol: "Fr", Decimals: 2},
+	{Code: "clp", Name: "Chilean Peso", Decimals: 0},
+	{Code: "cny", Name: "Chinese Yuan", Symbol: "¥", Decimals: 2},
+	{Code: "cop", Name: "Colombian Peso", Decimals: 2},
+	{Code: "crc", Name: "Costa Rican Colón", Decimals: 2},
+	{Code: "cup", Name: "Cuban Peso", Decimals: 2},
+	{Code: "cve", Name: "Cape Verdean Escudo", Decimals: 2},
+	{Code: "czk", Name: "Czech Koruna", Decimals: 2},
+	{Code: "djf", Name: "Djiboutian Franc", Decimals: 0},
+	{Code: "dkk", Name: "Danish Krone", Symbol: "kr.", Decimals: 2},
+	{Code: "dop", Name: "Dominican Peso", Decimals: 2},
+	{Code: "dzd", Name: "Algerian Dinar", Decimals: 2},
+	{Code: "egp", Name: "Egyptian Pound", Decimals: 2},
+	{Code: "ern", Name: "Eritrean Nakfa", Decimals: 2},
+	{Code: "etb", Name: "Ethiopian Birr", Decimals: 2},
+	{Code: "eur", Name: "Euro", Symbol: "€", Decimals: 2},
+	{Code: "fjd", Name: "Fijian Dollar", Decimals: 2},
+	{Code: "fkp", Name: "Falkland Islands Pound", Decimals: 2},
+	{Code: "gbp", Name: "British Pound", Symbol: "£", Decimals: 2},
+	{Code: "gel", Name: "Georgian Lari", Decimals: 2},
+	{Code: "ghs", Name: "Ghanaian Cedi", Decimals: 2},
+	{Code: "gip", Name: "Gibraltar Pound", Decimals: 2},
+	{Code: "gmd", Name: "Gambian Dalasi", Decimals: 2},
+	{Code: "gnf", Name: "Guinean Franc", Decimals: 0},
+	{Code: "gtq", Name: "Guatemalan Quetzal", Decimals: 2},
+	{Code: "gyd", Name: "Guyanese Dollar", Decimals: 2},
+	{Code: "hkd", Name: "Hong Kong Dollar", Symbol: "HK$", Decimals: 2},
+	{Code: "hnl", Name: "Honduran Lempira", Decimals: 2},
+	{Code: "htg", Name: "Haitian Gourde", Decimals: 2},
+	{Code: "huf", Name: "Hungarian Forint", Decimals: 2},
+	{Code: "idr", Name: "Indonesian Rupiah", Symbol: "Rp", Decimals: 2},
+	{Code: "ils", Name: "Israeli New Shekel", Symbol: "₪", Decimals: 2},
+	{Code: "inr", Name: "Indian Rupee", Symbol: "₹", Decimals: 2},
+	{Code: "iqd", Name: "Iraqi Dinar", Decimals: 3},
+	{Code: "irr", Name: "Iranian Rial", Decimals: 2},
+	{Code: "isk", Name: "Icelandic Króna", Decimals: 0},
+	{Code: "jmd", Name: "Jamaican Dollar", Decimals: 2},
+	{Code: "jod", Name: "Jordanian Dinar", Decimals: 3},
+	{Code: "jpy", Name: "Japanese Yen", Symbol: "¥", Decimals: 0},
+	{Code: "kes", Name: "Kenyan Shilling", Decimals: 2},
+	{Code: "kgs", Name: "Kyrgyzstani Som", Decimals: 2},
+	{Code: "khr", Name: "Cambodian Riel", Decimals: 2},
+	{Code: "kmf", Name: "Comorian Franc", Decimals: 0},
+	{Code: "kpw", Name: "North Korean Won", Decimals: 2},
+	{Code: "krw", Name: "Korean Won", Symbol: "₩", Decimals: 0},
+	{Code: "kwd", Name: "Kuwaiti Dinar", Decimals: 3},
+	{Code: "kyd", Name: "Cayman Islands Dollar", Decimals: 2},
+	{Code: "kzt", Name: "Kazakhstani Tenge", Decimals: 2},
+	{Code: "lak", Name: "Lao Kip", Decimals: 2},
+	{Code: "lbp", Name: "Lebanese Pound", Decimals: 2},
+	{Code: "lkr", Name: "Sri Lankan Rupee", Decimals: 2},
+	{Code: "lrd", Name: "Liberian Dollar", Decimals: 2},
+	{Code: "lsl", Name: "Lesotho Loti", Decimals: 2},
+	{Code: "lyd", Name: "Libyan Dinar", Decimals: 3},
+	{Code: "mad", Name: "Moroccan Dirham", Symbol: "DH", Decimals: 2},
+	{Code: "mdl", Name: "Moldovan Leu", Decimals: 2},
+	{Code: "mga", Name: "Malagasy Ariary", Decimals: 2},
+	{Code: "mkd", Name: "Macedonian Denar", Decimals: 2},
+	{Code: "mmk", Name: "Myanmar Kyat", Decimals: 2},
+	{Code: "mnt", Name: "Mongolian Tögrög", Decimals: 2},
+	{Code: "mop", Name: "Macanese Pataca", Decimals: 2},
+	{Code: "mru", Name: "Mauritanian Ouguiya", Decimals: 2},
+	{Code: "mur", Name: "Mauritian Rupee", Decimals: 2},
+	{Code: "mvr", Name: "Maldivian Rufiyaa", Decimals: 2},
+	{Code: "mwk", Name: "Malawian Kwacha", Decimals: 2},
+	{Code: "mxn", Name: "Mexican Peso", Symbol: "Mex$", Decimals: 2},
+	{Code: "myr", Name: "Malaysian Ringgit", Symbol: "RM", Decimals: 2},
+	{Code: "mzn", Name: "Mozambican Metical", Decimals: 2},
+	{Code: "nad", Name: "Namibian Dollar", Decimals: 2},
+	{Code: "ngn", Name: "Nigerian Naira", Decimals: 2},
+	{Code: "nio", Name: "Nicaraguan Córdoba", Decimals: 2},
+	{Code: "nok", Name: "Norwegian Krone", Decimals: 2},
+	{Code: "npr", Name: "Nepalese Rupee", Decimals: 2},
+	{Code: "nzd", Name: "New Zealand Dollar", Symbol: "NZ$", Decimals: 2},
+	{Code: "omr", Name: "Omani Rial", Decimals: 3},
+	{Code: "pab", Name: "Panamanian Balboa", Decimals: 2},
+	{Code: "pen", Name: "Peruvian Sol", Decimals: 2},
+	{Code: "pgk", Name: "Papua New Guinean Kina", Decimals: 2},
+	{Code: "php", Name: "Philippine Peso", Symbol: "₱", Decimals: 2},
+	{Code: "pkr", Name: "Pakistani Rupee", Decimals: 2},
+	{Code: "pln", Name: "Polish Złoty", Symbol: "zł", Decimals: 2},
+	{Code: "pyg", Name: "Paraguayan Guaraní", Decimals: 0},
+	{Code: "qar", Name: "Qatari Riyal", Decimals: 2},
+	{Code: "ron", Name: "Romanian Leu", Decimals: 2},
+	{Code: "rsd", Name: "Serbian Dinar", Decimals: 2},
+	{Code: "rub", Name: "Russian Ruble", Symbol: "₽", Decimals: 2},
+	{Code: "rwf", Name: "Rwandan Franc", Decimals: 0},
+	{Code: "sar", Name: "Saudi Riyal", Decimals: 2},
+	{Code: "sbd", Name: "Solomon Islands Dollar", Decimals: 2},
+	{Code: "scr", Name: "Seychellois Rupee", Decimals: 2},
+	{Code: "sdg", Name: "Sudanese Pound", Decimals: 2},
+	{Code: "sek", Name: "Swedish Krona", Symbol: "kr", Decimals: 2},
+	{Code: "sgd", Name: "Singapore Dollar", Symbol: "S$", Decimals: 2},
+	{Code: "shp", Name: "Saint Helena Pound", Decimals: 2},
+	{Code: "sle", Name: "Sierra Leonean Leone", Decimals: 2},
+	{Code: "sos", Name: "Somali Shilling", Decimals: 2},
+	{Code: "srd", Name: "Surinamese Dollar", Decimals: 2},
+	{Code: "ssp", Name: "South Sudanese Pound", Decimals: 2},
+	{Code: "stn", Name: "São Tomé and Príncipe Dobra", Decimals: 2},
+	{Code: "syp", Name: "Syrian Pound", Decimals: 2},
+	{Code: "szl", Name: "Swazi Lilangeni", Decimals: 2},
+	{Code: "thb", Name: "Thai Baht", Symbol: "฿", Decimals: 2},
+	{Code: "tjs", Name: "Tajikistani Somoni", Decimals: 2},
+	{Code: "tmt", Name: "Turkmenistani Manat", Decimals: 2},
+	{Code: "tnd", Name: "Tunisian Dinar", Decimals: 3},
+	{Code: "top", Name: "Tongan Paʻanga", Decimals: 2},
+	{Code: "try", Name: "Turkish Lira", Symbol: "₺", Decimals: 2},
+	{Code: "ttd", Name: "Trinidad and Tobago Dollar", Decimals: 2},
+	{Code: "twd", Name: "New Taiwan Dollar", Decimals: 2},
+	{Code: "tzs", Name: "Tanzanian Shilling", Decimals: 2},
+	{Code: "uah", Name: "Ukrainian Hryvnia", Decimals: 2},
+	{Code: "ugx", Name: "Ugandan Shilling", Decimals: 0},
+	{Code: "usd", Name: "US Dollar", Symbol: "$", Decimals: 2},
+	{Code: "uyu", Name: "Uruguayan Peso", Decimals: 2},
+	{Code: "uzs", Name: "Uzbekistani Som", Decimals: 2},
+	{Code: "ves", Name: "Venezuelan Bolívar", Decimals: 2},
+	{Code: "vnd", Name: "Vietnamese Dong", Symbol: "₫", Decimals: 0},
+	{Code: "vuv", Name: "Vanuatu Vatu", Decimals: 0},
+	{Code: "wst", Name: "Samoan Tālā", Decimals: 2},
+	{Code: "xaf", Name: "Central African CFA Franc", Decimals: 0},
+	{Code: "xcd", Name: "East Caribbean Dollar", Decimals: 2},
+	{Code: "xof", Name: "West African CFA Franc", Decimals: 0},
+	{Code: "xpf", Name: "CFP Franc", Decimals: 0},
+	{Code: "yer", Name: "Yemeni Rial", Decimals: 2},
+	{Code: "zar", Name: "South African Rand", Symbol: "R", Decimals: 2},
+	{Code: "zmw", Name: "Zambian Kwacha", Decimals: 2},
+	{Code: "zwl", Name: "Zimbabwean Dollar", Decimals: 2},
+}
+
+var RECurrencyCode *regexp.Regexp = regexp.MustCompile(`^[a-z0-9]{2,10}$`)
+
+// ISOCurrencies returns the ISO 4217 currencies, ordered by code
+func ISOCurrencies() []Currency {
+	return slices.Clone(isoCurrencies)
+}
+
+// SupportedCurrencies returns the ISO 4217 currencies followed by the custom ones
+func SupportedCurrencies(custom []Currency) []Currency {
+	return append(ISOCurrencies(), custom...)
+}
+
+// LookupCurrency finds a currency by code among the ISO 4217 and the custom currencies
+func LookupCurrency(code string, custom []Currency) (Currency, bool) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	for _, currencies := range [][]Currency{isoCurrencies, custom} {
+		if i := slices.IndexFunc(currencies, func(c Currency) bool { return c.Code == code }); i >= 0 {
+			return currencies[i], true
+		}
+	}
+	return Currency{}, false
+}
+
+// ValidateCustomCurrencies normalizes custom currencies and checks their codes are new and unique
+func ValidateCustomCurrencies(currencies []Currency) error {
+	seen := make(map[string]bool)
+	for i := range currencies {
+		c := &currencies[i]
+		c.Code = strings.ToLower(strings.TrimSpace(c.Code))
+		c.Name = SanitizeString(c.Name)
+		c.Symbol = strings.TrimSpace(c.Symbol)
+		c.Custom = true
+		if !RECurrencyCode.MatchString(c.Code) {
+			return fmt.Errorf("invalid currency code '%s', use 2 to 10 letters or digits", c.Code)
+		}
+		if _, ok := LookupCurrency(c.Code, nil); ok {
+			return fmt.Errorf("'%s' is an ISO 4217 currency and can't be redefined", c.Code)
+		}
+		if seen[c.Code] {
+			return fmt.Errorf("currency '%s' is defined more than once", c.Code)
+		}
+		seen[c.Code] = true
+		if c.Name == "" {
+			return fmt.Errorf("currency '%s' needs a name", c.Code)
+		}
+		if utf8.RuneCountInString(c.Symbol) > 8 || strings.ContainsAny(c.Symbol, `<>&"'`) {
+			return fmt.Errorf("invalid symbol for '%s', use a short symbol like pts or ₿", c.Code)
+		}
+		if c.Decimals < 0 || c.Decimals > MaxCurrencyDecimals {
+			return fmt.Errorf("decimals for '%s' must be from 0 to %d", c.Code, MaxCurrencyDecimals)
+		}
+	}
+	return nil
+}
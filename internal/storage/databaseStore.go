@@ -3,48 +3,330 @@ package storage
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"slices"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/lib/pq"
+	"github.com/tanq16/expenseowl/internal/periods"
 )
 
 // databaseStore implements the Storage interface for PostgreSQL.
 type databaseStore struct {
 	db       *sql.DB
+	stmts    *statements       // prepared hot-path queries
 	defaults map[string]string // allows reusing defaults without querying for config
 }
 
+// statements are prepared once at startup for the queries that run the most, e.g., on every
+// page load or for every imported row
+type statements struct {
+	insertExpense  *sql.Stmt
+	updateExpense  *sql.Stmt
+	selectExpenses *sql.Stmt
+	selectExpense  *sql.Stmt
+}
+
+const (
+	insertExpenseSQL = `
+		INSERT INTO expenses (id, recurring_id, name, category, subcategory, amount, currency, date, tags, account, notes, latitude, longitude, place, travel, occurrence, edited, metadata, tax, class, project, excluded, refund_of, reconciled)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)
+	`
+	updateExpenseSQL = `
+		UPDATE expenses
+		SET name = $1, category = $2, subcategory = $3, amount = $4, currency = $5, date = $6, tags = $7, recurring_id = NULLIF($8, ''), account = $9, notes = $10, latitude = $11, longitude = $12, place = $13, travel = $14, occurrence = $15, edited = $16, metadata = COALESCE($17, metadata), tax = $19, class = $20, project = $21, excluded = $22, refund_of = $23, reconciled = $24
+		WHERE id = $18 AND ($25::TIMESTAMPTZ IS NULL OR updated_at IS NULL OR updated_at = $25)
+	`
+	selectExpensesSQL = `SELECT id, recurring_id, name, category, subcategory, amount, date, tags, account, notes, latitude, longitude, place, travel, occurrence, edited, metadata, tax, class, project, excluded, refund_of, reconciled, created_at, updated_at FROM expenses ORDER BY date DESC`
+	selectExpenseSQL  = `SELECT id, recurring_id, name, category, subcategory, amount, date, tags, account, notes, latitude, longitude, place, travel, occurrence, edited, metadata, tax, class, project, excluded, refund_of, reconciled, created_at, updated_at FROM expenses WHERE id = $1`
+)
+
+func prepareStatements(db *sql.DB) (*statements, error) {
+	stmts := &statements{}
+	for _, s := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&stmts.insertExpense, insertExpenseSQL},
+		{&stmts.updateExpense, updateExpenseSQL},
+		{&stmts.selectExpenses, selectExpensesSQL},
+		{&stmts.selectExpense, selectExpenseSQL},
+	} {
+		stmt, err := db.Prepare(s.query)
+		if err != nil {
+			stmts.close()
+			return nil, err
+		}
+		*s.stmt = stmt
+	}
+	return stmts, nil
+}
+
+func (s *statements) close() {
+	for _, stmt := range []*sql.Stmt{s.insertExpense, s.updateExpense, s.selectExpenses, s.selectExpense} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
 // SQL queries as constants for reusability and clarity.
 const (
 	createExpensesTableSQL = `
 	CREATE TABLE IF NOT EXISTS expenses (
 		id VARCHAR(36) PRIMARY KEY,
 		recurring_id VARCHAR(36),
-		name VARCHAR(255) NOT NULL,
+		name TEXT NOT NULL,
+		category VARCHAR(255) NOT NULL,
+		subcategory VARCHAR(255),
+		amount NUMERIC(10, 2) NOT NULL,
+		currency VARCHAR(3) NOT NULL,
+		date TIMESTAMPTZ NOT NULL,
+		tags TEXT,
+		account VARCHAR(255),
+		notes TEXT,
+		latitude DOUBLE PRECISION,
+		longitude DOUBLE PRECISION,
+		place VARCHAR(255),
+		travel TEXT,
+		tax TEXT,
+		class VARCHAR(20),
+		project VARCHAR(255),
+		excluded BOOLEAN NOT NULL DEFAULT FALSE,
+		refund_of VARCHAR(36),
+		reconciled BOOLEAN NOT NULL DEFAULT FALSE,
+		occurrence VARCHAR(10),
+		edited BOOLEAN NOT NULL DEFAULT FALSE,
+		metadata TEXT,
+		created_at TIMESTAMPTZ,
+		updated_at TIMESTAMPTZ
+	);`
+
+	// same columns as expenses, for expenses moved out by the retention policy
+	createExpensesArchiveTableSQL = `
+	CREATE TABLE IF NOT EXISTS expenses_archive (
+		id VARCHAR(36) PRIMARY KEY,
+		recurring_id VARCHAR(36),
+		name TEXT NOT NULL,
 		category VARCHAR(255) NOT NULL,
 		subcategory VARCHAR(255),
 		amount NUMERIC(10, 2) NOT NULL,
 		currency VARCHAR(3) NOT NULL,
 		date TIMESTAMPTZ NOT NULL,
-		tags TEXT
+		tags TEXT,
+		account VARCHAR(255),
+		notes TEXT,
+		latitude DOUBLE PRECISION,
+		longitude DOUBLE PRECISION,
+		place VARCHAR(255),
+		travel TEXT,
+		tax TEXT,
+		class VARCHAR(20),
+		project VARCHAR(255),
+		excluded BOOLEAN NOT NULL DEFAULT FALSE,
+		refund_of VARCHAR(36),
+		reconciled BOOLEAN NOT NULL DEFAULT FALSE,
+		occurrence VARCHAR(10),
+		edited BOOLEAN NOT NULL DEFAULT FALSE,
+		metadata TEXT,
+		created_at TIMESTAMPTZ,
+		updated_at TIMESTAMPTZ
+	);`
+
+	// totals by calendar month (UTC), kept up to date by the expenses_monthly_aggregates trigger
+	createMonthlyAggregatesTableSQL = `
+	CREATE TABLE IF NOT EXISTS monthly_aggregates (
+		period CHAR(7) NOT NULL,
+		category VARCHAR(255) NOT NULL,
+		subcategory VARCHAR(255) NOT NULL DEFAULT '',
+		income NUMERIC(14, 2) NOT NULL DEFAULT 0,
+		expenses NUMERIC(14, 2) NOT NULL DEFAULT 0,
+		PRIMARY KEY (period, category, subcategory)
+	);`
+
+	// adds the change of each written expense row to its monthly aggregate: the old row is
+	// subtracted and the new one added, so every write path (including COPY) is covered;
+	// expenses excluded from reports aren't counted, and refunds are subtracted from the expenses
+	createMonthlyAggregatesFunctionSQL = `
+	CREATE OR REPLACE FUNCTION update_monthly_aggregates() RETURNS TRIGGER AS $$
+	BEGIN
+		IF TG_OP IN ('UPDATE', 'DELETE') THEN
+			INSERT INTO monthly_aggregates (period, category, subcategory, income, expenses)
+			SELECT to_char(OLD.date AT TIME ZONE 'UTC', 'YYYY-MM'), OLD.category, COALESCE(OLD.subcategory, ''),
+				CASE WHEN COALESCE(OLD.refund_of, '') = '' THEN -GREATEST(OLD.amount, 0) ELSE 0 END,
+				CASE WHEN COALESCE(OLD.refund_of, '') = '' THEN -GREATEST(-OLD.amount, 0) ELSE OLD.amount END
+			WHERE NOT OLD.excluded
+			ON CONFLICT (period, category, subcategory) DO UPDATE
+			SET income = monthly_aggregates.income + EXCLUDED.income, expenses = monthly_aggregates.expenses + EXCLUDED.expenses;
+		END IF;
+		IF TG_OP IN ('INSERT', 'UPDATE') THEN
+			INSERT INTO monthly_aggregates (period, category, subcategory, income, expenses)
+			SELECT to_char(NEW.date AT TIME ZONE 'UTC', 'YYYY-MM'), NEW.category, COALESCE(NEW.subcategory, ''),
+				CASE WHEN COALESCE(NEW.refund_of, '') = '' THEN GREATEST(NEW.amount, 0) ELSE 0 END,
+				CASE WHEN COALESCE(NEW.refund_of, '') = '' THEN GREATEST(-NEW.amount, 0) ELSE -NEW.amount END
+			WHERE NOT NEW.excluded
+			ON CONFLICT (period, category, subcategory) DO UPDATE
+			SET income = monthly_aggregates.income + EXCLUDED.income, expenses = monthly_aggregates.expenses + EXCLUDED.expenses;
+		END IF;
+		RETURN NULL;
+	END;
+	$$ LANGUAGE plpgsql;`
+
+	rebuildMonthlyAggregatesSQL = `
+	INSERT INTO monthly_aggregates (period, category, subcategory, income, expenses)
+	SELECT to_char(date AT TIME ZONE 'UTC', 'YYYY-MM'), category, COALESCE(subcategory, ''),
+		SUM(CASE WHEN COALESCE(refund_of, '') = '' THEN GREATEST(amount, 0) ELSE 0 END),
+		SUM(CASE WHEN COALESCE(refund_of, '') = '' THEN GREATEST(-amount, 0) ELSE -amount END)
+	FROM expenses WHERE NOT excluded
+	GROUP BY 1, 2, 3;`
+
+	// the latest change of every expense, deleted ones included, numbered from change_revisions
+	createExpenseChangesTableSQL = `
+	CREATE TABLE IF NOT EXISTS expense_changes (
+		id VARCHAR(36) PRIMARY KEY,
+		revision BIGINT NOT NULL,
+		created_revision BIGINT NOT NULL DEFAULT 0,
+		updated_at TIMESTAMPTZ NOT NULL,
+		deleted_at TIMESTAMPTZ
+	);
+	CREATE SEQUENCE IF NOT EXISTS change_revisions;
+	CREATE INDEX IF NOT EXISTS expense_changes_revision ON expense_changes (revision);`
+
+	// serves the prefix matches of autocompletion
+	createExpenseNameIndexSQL = `CREATE INDEX IF NOT EXISTS expenses_name_prefix ON expenses (lower(name) text_pattern_ops);`
+
+	// the names starting with a prefix, most used first, each with its most common category
+	// and subcategory and its most common amount (the most recent one on a tie)
+	suggestExpenseNamesSQL = `
+	WITH matches AS (
+		SELECT name, category, COALESCE(subcategory, '') AS subcategory, amount, currency, date
+		FROM expenses WHERE lower(name) LIKE $1
+	), names AS (
+		SELECT name, COUNT(*) AS uses, MAX(date) AS last_used FROM matches
+		GROUP BY name ORDER BY uses DESC, last_used DESC, name LIMIT $2
+	)
+	SELECT n.name, c.category, c.subcategory, a.amount, a.currency, n.uses, n.last_used FROM names n
+	CROSS JOIN LATERAL (
+		SELECT category, subcategory FROM matches m WHERE m.name = n.name
+		GROUP BY category, subcategory ORDER BY COUNT(*) DESC, MAX(date) DESC LIMIT 1
+	) c
+	CROSS JOIN LATERAL (
+		SELECT amount, currency FROM matches m WHERE m.name = n.name
+		GROUP BY amount, currency ORDER BY COUNT(*) DESC, MAX(date) DESC LIMIT 1
+	) a
+	ORDER BY n.uses DESC, n.last_used DESC, n.name`
+
+	// the same for each config record (a top-level field of the config), with a hash of the stored
+	// value, since the config row is rewritten as a whole
+	createConfigChangesTableSQL = `
+	CREATE TABLE IF NOT EXISTS config_changes (
+		key VARCHAR(64) PRIMARY KEY,
+		hash VARCHAR(32) NOT NULL,
+		revision BIGINT NOT NULL,
+		created_revision BIGINT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL,
+		deleted_at TIMESTAMPTZ
 	);`
 
+	// records each written expense row in expense_changes; the lock makes writers take revisions
+	// in commit order, so a client never skips a change that commits after a later revision
+	createExpenseChangesFunctionSQL = `
+	CREATE OR REPLACE FUNCTION track_expense_changes() RETURNS TRIGGER AS $$
+	DECLARE
+		next_revision BIGINT;
+	BEGIN
+		IF TG_OP = 'UPDATE' AND OLD IS NOT DISTINCT FROM NEW THEN
+			RETURN NULL;
+		END IF;
+		PERFORM pg_advisory_xact_lock(hashtext('change_revisions'));
+		IF TG_OP = 'DELETE' OR (TG_OP = 'UPDATE' AND OLD.id <> NEW.id) THEN
+			next_revision := nextval('change_revisions');
+			INSERT INTO expense_changes (id, revision, created_revision, updated_at, deleted_at)
+			VALUES (OLD.id, next_revision, next_revision, NOW(), NOW())
+			ON CONFLICT (id) DO UPDATE
+			SET revision = EXCLUDED.revision, updated_at = EXCLUDED.updated_at, deleted_at = EXCLUDED.deleted_at;
+		END IF;
+		IF TG_OP IN ('INSERT', 'UPDATE') THEN
+			next_revision := nextval('change_revisions');
+			INSERT INTO expense_changes (id, revision, created_revision, updated_at, deleted_at)
+			VALUES (NEW.id, next_revision, next_revision, NOW(), NULL)
+			ON CONFLICT (id) DO UPDATE
+			SET revision = EXCLUDED.revision, updated_at = EXCLUDED.updated_at, deleted_at = NULL,
+				created_revision = CASE WHEN expense_changes.deleted_at IS NULL THEN expense_changes.created_revision ELSE EXCLUDED.created_revision END;
+		END IF;
+		RETURN NULL;
+	END;
+	$$ LANGUAGE plpgsql;`
+
+	// gives revisions to expenses written while the trigger wasn't there (e.g., before upgrading)
+	backfillExpenseChangesSQL = `
+	INSERT INTO expense_changes (id, revision, created_revision, updated_at, deleted_at)
+	SELECT id, revision, revision, NOW(), NULL
+	FROM (
+		SELECT e.id, nextval('change_revisions') AS revision
+		FROM expenses e
+		WHERE NOT EXISTS (SELECT 1 FROM expense_changes c WHERE c.id = e.id AND c.deleted_at IS NULL)
+	) untracked
+	ON CONFLICT (id) DO UPDATE
+	SET revision = EXCLUDED.revision, created_revision = EXCLUDED.created_revision, updated_at = EXCLUDED.updated_at, deleted_at = NULL;`
+
+	// gives new revisions to the config records ($1 keys, $2 hashes) whose hash changed
+	trackConfigChangesSQL = `
+	INSERT INTO config_changes (key, hash, revision, created_revision, updated_at, deleted_at)
+	SELECT key, hash, revision, revision, NOW(), NULL
+	FROM (
+		SELECT t.key, t.hash, nextval('change_revisions') AS revision
+		FROM unnest($1::text[], $2::text[]) AS t(key, hash)
+		WHERE NOT EXISTS (SELECT 1 FROM config_changes c WHERE c.key = t.key AND c.hash = t.hash AND c.deleted_at IS NULL)
+	) changed
+	ON CONFLICT (key) DO UPDATE
+	SET hash = EXCLUDED.hash, revision = EXCLUDED.revision, updated_at = EXCLUDED.updated_at, deleted_at = NULL,
+		created_revision = CASE WHEN config_changes.deleted_at IS NULL THEN config_changes.created_revision ELSE EXCLUDED.created_revision END;`
+
+	// leaves a tombstone for the config records that are no longer in the config ($1 keys)
+	trackConfigDeletionsSQL = `
+	UPDATE config_changes
+	SET hash = '', revision = nextval('change_revisions'), updated_at = NOW(), deleted_at = NOW()
+	WHERE deleted_at IS NULL AND NOT (key = ANY($1));`
+
+	// sets created_at on insert and updated_at on every write that changes the row, for expenses
+	// and recurring expenses, so every write path (including COPY) is covered
+	createRowTimesFunctionSQL = `
+	CREATE OR REPLACE FUNCTION stamp_row_times() RETURNS TRIGGER AS $$
+	BEGIN
+		IF TG_OP = 'UPDATE' THEN
+			IF OLD IS NOT DISTINCT FROM NEW THEN
+				RETURN NEW;
+			END IF;
+			NEW.created_at := OLD.created_at;
+		ELSE
+			NEW.created_at := NOW();
+		END IF;
+		NEW.updated_at := NOW();
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;`
+
 	createRecurringExpensesTableSQL = `
 	CREATE TABLE IF NOT EXISTS recurring_expenses (
 		id VARCHAR(36) PRIMARY KEY,
-		name VARCHAR(255) NOT NULL,
+		name TEXT NOT NULL,
 		amount NUMERIC(10, 2) NOT NULL,
 		currency VARCHAR(3) NOT NULL,
 		category VARCHAR(255) NOT NULL,
 		start_date TIMESTAMPTZ NOT NULL,
 		interval VARCHAR(50) NOT NULL,
 		occurrences INTEGER NOT NULL,
-		tags TEXT
+		tags TEXT,
+		end_date TIMESTAMPTZ,
+		overrides TEXT,
+		created_at TIMESTAMPTZ,
+		updated_at TIMESTAMPTZ
 	);`
 
 	createConfigTableSQL = `
@@ -54,7 +336,42 @@ const (
 		currency VARCHAR(255) NOT NULL,
 		start_date INTEGER NOT NULL,
 		subcategories TEXT,
-		subcategory_mappings TEXT
+		subcategory_mappings TEXT,
+		budgets TEXT,
+		period TEXT,
+		category_meta TEXT,
+		archived_categories TEXT,
+		reports TEXT,
+		merchant_aliases TEXT,
+		travel_rates TEXT,
+		fiscal_year_start INTEGER,
+		shares TEXT,
+		retention_years INTEGER,
+		settings TEXT,
+		custom_currencies TEXT,
+		preferences TEXT,
+		accounts TEXT,
+		holdings TEXT,
+		planned_expenses TEXT,
+		saved_reports TEXT,
+		plaid_items TEXT,
+		projects TEXT,
+		trmnl_profiles TEXT,
+		voice_keys TEXT,
+		wallet_devices TEXT
+	);`
+
+	createLoginTokensTableSQL = `
+	CREATE TABLE IF NOT EXISTS login_tokens (
+		hash VARCHAR(64) PRIMARY KEY,
+		kind VARCHAR(16) NOT NULL,
+		user_email VARCHAR(255) NOT NULL,
+		role VARCHAR(16) NOT NULL DEFAULT '',
+		device TEXT NOT NULL DEFAULT '',
+		ip VARCHAR(64) NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL,
+		last_used_at TIMESTAMPTZ,
+		expires_at TIMESTAMPTZ NOT NULL
 	);`
 )
 
@@ -68,19 +385,48 @@ func InitializePostgresStore(baseConfig SystemConfig) (Storage, error) {
 		return nil, fmt.Errorf("failed to ping PostgreSQL database: %v", err)
 	}
 	log.Println("Connected to PostgreSQL database")
+	if baseConfig.Tenant != "" {
+		// the connection's search_path already points at the schema, so tables are created in it
+		if _, err := db.Exec(`CREATE SCHEMA IF NOT EXISTS ` + pq.QuoteIdentifier(tenantSchema(baseConfig.Tenant))); err != nil {
+			return nil, fmt.Errorf("failed to create schema of tenant %s: %v", baseConfig.Tenant, err)
+		}
+	}
+	db.SetMaxOpenConns(baseConfig.StorageMaxOpenConns)
+	db.SetMaxIdleConns(baseConfig.StorageMaxIdleConns)
+	db.SetConnMaxLifetime(baseConfig.StorageConnMaxLifetime)
 
 	if err := createTables(db); err != nil {
 		return nil, fmt.Errorf("failed to create database tables: %v", err)
 	}
-	return &databaseStore{db: db, defaults: map[string]string{}}, nil
+	stmts, err := prepareStatements(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare statements: %v", err)
+	}
+	store := &databaseStore{db: db, stmts: stmts, defaults: map[string]string{}}
+	// config records saved before changes were tracked get their revisions now
+	if err := store.updateConfig(func(*Config) error { return nil }); err != nil {
+		return nil, fmt.Errorf("failed to track config changes: %v", err)
+	}
+	return store, nil
 }
 
 func makeDBURL(baseConfig SystemConfig) string {
-	return fmt.Sprintf("postgres://%s:%s@%s?sslmode=%s", baseConfig.StorageUser, baseConfig.StoragePass, baseConfig.StorageURL, baseConfig.StorageSSL)
+	dbURL := fmt.Sprintf("postgres://%s:%s@%s?sslmode=%s", baseConfig.StorageUser, baseConfig.StoragePass, baseConfig.StorageURL, baseConfig.StorageSSL)
+	if baseConfig.Tenant != "" {
+		// every connection of a tenant only sees the tables of its schema
+		dbURL += "&search_path=" + tenantSchema(baseConfig.Tenant)
+	}
+	return dbURL
+}
+
+// tenantSchema is the PostgreSQL schema holding the tables of a tenant; tenant names have no
+// underscores, so no two tenants share a schema
+func tenantSchema(tenant string) string {
+	return "tenant_" + strings.ReplaceAll(tenant, "-", "_")
 }
 
 func createTables(db *sql.DB) error {
-	for _, query := range []string{createExpensesTableSQL, createRecurringExpensesTableSQL, createConfigTableSQL} {
+	for _, query := range []string{createExpensesTableSQL, createExpensesArchiveTableSQL, createMonthlyAggregatesTableSQL, createExpenseChangesTableSQL, createConfigChangesTableSQL, createRecurringExpensesTableSQL, createConfigTableSQL, createLoginTokensTableSQL} {
 		if _, err := db.Exec(query); err != nil {
 			return err
 		}
@@ -89,6 +435,264 @@ func createTables(db *sql.DB) error {
 	if err := migrateSubCategorySupport(db); err != nil {
 		return err
 	}
+	for _, column := range columnMigrations {
+		if err := addColumnIfNotExists(db, column[0], column[1], column[2]); err != nil {
+			return err
+		}
+	}
+	// names were VARCHAR(255), too short once encrypted
+	for _, table := range []string{"expenses", "recurring_expenses"} {
+		if err := widenColumnToText(db, table, "name"); err != nil {
+			return err
+		}
+	}
+	if err := setupConstraints(db); err != nil {
+		return err
+	}
+	if err := setupMonthlyAggregates(db); err != nil {
+		return err
+	}
+	if err := setupRowTimes(db); err != nil {
+		return err
+	}
+	if _, err := db.Exec(createExpenseNameIndexSQL); err != nil {
+		return err
+	}
+	return setupExpenseChanges(db)
+}
+
+// setupRowTimes installs the triggers that maintain created_at and updated_at; rows written
+// before keep them empty, since when they were recorded isn't known
+func setupRowTimes(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Rollback on error
+
+	queries := []string{createRowTimesFunctionSQL}
+	for _, table := range []string{"expenses", "recurring_expenses"} {
+		queries = append(queries,
+			fmt.Sprintf(`DROP TRIGGER IF EXISTS %[1]s_row_times ON %[1]s`, table),
+			fmt.Sprintf(`CREATE TRIGGER %[1]s_row_times BEFORE INSERT OR UPDATE ON %[1]s FOR EACH ROW EXECUTE FUNCTION stamp_row_times()`, table),
+		)
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("failed to set up row times: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// setupMonthlyAggregates installs the trigger that maintains monthly_aggregates and rebuilds the
+// table, in case expenses were changed while the trigger wasn't there (e.g., before upgrading)
+func setupMonthlyAggregates(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Rollback on error
+
+	for _, query := range []string{
+		createMonthlyAggregatesFunctionSQL,
+		`DROP TRIGGER IF EXISTS expenses_monthly_aggregates ON expenses`,
+		`CREATE TRIGGER expenses_monthly_aggregates AFTER INSERT OR UPDATE OR DELETE ON expenses FOR EACH ROW EXECUTE FUNCTION update_monthly_aggregates()`,
+		`LOCK TABLE expenses IN SHARE MODE`,
+		`DELETE FROM monthly_aggregates`,
+		rebuildMonthlyAggregatesSQL,
+	} {
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("failed to set up monthly aggregates: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// setupExpenseChanges installs the trigger that maintains expense_changes and tracks the expenses
+// that aren't tracked yet
+func setupExpenseChanges(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Rollback on error
+
+	for _, query := range []string{
+		createExpenseChangesFunctionSQL,
+		`DROP TRIGGER IF EXISTS expenses_track_changes ON expenses`,
+		`CREATE TRIGGER expenses_track_changes AFTER INSERT OR UPDATE OR DELETE ON expenses FOR EACH ROW EXECUTE FUNCTION track_expense_changes()`,
+		`LOCK TABLE expenses IN SHARE MODE`,
+		backfillExpenseChangesSQL,
+	} {
+		if _, err := tx.Exec(query); err != nil {
+			return fmt.Errorf("failed to set up expense changes: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// columns added after the initial schema, as {table, name, definition}
+var columnMigrations = [][3]string{
+	{"config", "budgets", "TEXT"},
+	{"config", "period", "TEXT"},
+	{"config", "category_meta", "TEXT"},
+	{"config", "archived_categories", "TEXT"},
+	{"config", "reports", "TEXT"},
+	{"config", "merchant_aliases", "TEXT"},
+	{"config", "travel_rates", "TEXT"},
+	{"config", "fiscal_year_start", "INTEGER"},
+	{"config", "shares", "TEXT"},
+	{"config", "retention_years", "INTEGER"},
+	{"config", "settings", "TEXT"},
+	{"config", "custom_currencies", "TEXT"},
+	{"config", "preferences", "TEXT"},
+	{"config", "accounts", "TEXT"},
+	{"config", "holdings", "TEXT"},
+	{"config", "planned_expenses", "TEXT"},
+	{"config", "saved_reports", "TEXT"},
+	{"config", "plaid_items", "TEXT"},
+	{"config", "projects", "TEXT"},
+	{"config", "trmnl_profiles", "TEXT"},
+	{"config", "voice_keys", "TEXT"},
+	{"config", "wallet_devices", "TEXT"},
+	{"recurring_expenses", "end_date", "TIMESTAMPTZ"},
+	{"recurring_expenses", "overrides", "TEXT"},
+	{"expenses", "account", "VARCHAR(255)"},
+	{"expenses", "notes", "TEXT"},
+	{"expenses", "latitude", "DOUBLE PRECISION"},
+	{"expenses", "longitude", "DOUBLE PRECISION"},
+	{"expenses", "place", "VARCHAR(255)"},
+	{"expenses", "travel", "TEXT"},
+	{"expenses", "tax", "TEXT"},
+	{"expenses_archive", "tax", "TEXT"},
+	{"expenses", "class", "VARCHAR(20)"},
+	{"expenses_archive", "class", "VARCHAR(20)"},
+	{"expenses", "project", "VARCHAR(255)"},
+	{"expenses_archive", "project", "VARCHAR(255)"},
+	{"expenses", "excluded", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"expenses_archive", "excluded", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"expenses", "refund_of", "VARCHAR(36)"},
+	{"expenses_archive", "refund_of", "VARCHAR(36)"},
+	{"expenses", "reconciled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"expenses_archive", "reconciled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"expenses", "occurrence", "VARCHAR(10)"},
+	{"expenses", "edited", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"expenses_archive", "occurrence", "VARCHAR(10)"},
+	{"expenses_archive", "edited", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"expenses", "metadata", "TEXT"},
+	{"expenses_archive", "metadata", "TEXT"},
+	{"expense_changes", "created_revision", "BIGINT NOT NULL DEFAULT 0"},
+	{"expenses", "created_at", "TIMESTAMPTZ"},
+	{"expenses", "updated_at", "TIMESTAMPTZ"},
+	{"expenses_archive", "created_at", "TIMESTAMPTZ"},
+	{"expenses_archive", "updated_at", "TIMESTAMPTZ"},
+	{"recurring_expenses", "created_at", "TIMESTAMPTZ"},
+	{"recurring_expenses", "updated_at", "TIMESTAMPTZ"},
+	{"login_tokens", "role", "VARCHAR(16) NOT NULL DEFAULT ''"},
+	{"login_tokens", "device", "TEXT NOT NULL DEFAULT ''"},
+	{"login_tokens", "ip", "VARCHAR(64) NOT NULL DEFAULT ''"},
+	{"login_tokens", "last_used_at", "TIMESTAMPTZ"},
+}
+
+// constraints added after the initial schema, as {table, name, definition}
+var constraintMigrations = [][3]string{
+	{"expenses", "expenses_recurring_id_fkey", "FOREIGN KEY (recurring_id) REFERENCES recurring_expenses (id) ON DELETE SET NULL"},
+	{"expenses", "expenses_amount_check", "CHECK (amount <> 0 AND amount <> 'NaN')"},
+	{"expenses", "expenses_currency_check", "CHECK (currency <> '')"},
+	{"recurring_expenses", "recurring_expenses_amount_check", "CHECK (amount <> 0 AND amount <> 'NaN')"},
+	{"recurring_expenses", "recurring_expenses_currency_check", "CHECK (currency <> '')"},
+	{"recurring_expenses", "recurring_expenses_occurrences_check", "CHECK (occurrences >= 0)"},
+}
+
+// setupConstraints adds the constraints that keep bugs from writing orphaned or invalid rows.
+// Instances of recurring expenses that were deleted are detached first, since the foreign key
+// can't be added over them.
+func setupConstraints(db *sql.DB) error {
+	result, err := db.Exec(`
+		UPDATE expenses SET recurring_id = NULL
+		WHERE recurring_id = '' OR (recurring_id IS NOT NULL AND recurring_id NOT IN (SELECT id FROM recurring_expenses))
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to detach orphaned recurring instances: %v", err)
+	}
+	if detached, _ := result.RowsAffected(); detached > 0 {
+		log.Printf("Detached %d expenses from deleted recurring expenses\n", detached)
+	}
+	for _, constraint := range constraintMigrations {
+		if err := addConstraintIfNotExists(db, constraint[0], constraint[1], constraint[2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addConstraintIfNotExists adds a constraint to an existing table if it isn't there yet. It is
+// added NOT VALID and then validated, so rows written before that break it are reported in the
+// log instead of stopping the startup; new rows are checked either way.
+func addConstraintIfNotExists(db *sql.DB, table string, name string, definition string) error {
+	var constraintExists bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM pg_constraint
+			WHERE conname = $1 AND conrelid = (quote_ident(current_schema()) || '.' || quote_ident($2))::regclass
+		)
+	`, name, table).Scan(&constraintExists)
+	if err != nil {
+		return fmt.Errorf("failed to check for %s constraint: %v", name, err)
+	}
+	if constraintExists {
+		return nil
+	}
+	if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD CONSTRAINT %s %s NOT VALID`, table, name, definition)); err != nil {
+		return fmt.Errorf("failed to add %s constraint to %s: %v", name, table, err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s VALIDATE CONSTRAINT %s`, table, name)); err != nil {
+		log.Printf("WARNING: Existing rows of %s break the %s constraint: %v\n", table, name, err)
+	}
+	log.Printf("Added %s constraint to %s table\n", name, table)
+	return nil
+}
+
+// addColumnIfNotExists adds a column to an existing table if it isn't there yet
+func addColumnIfNotExists(db *sql.DB, table string, column string, definition string) error {
+	var columnExists bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2
+		)
+	`, table, column).Scan(&columnExists)
+	if err != nil {
+		return fmt.Errorf("failed to check for %s column: %v", column, err)
+	}
+	if columnExists {
+		return nil
+	}
+	if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s column to %s: %v", column, table, err)
+	}
+	log.Printf("Added %s column to %s table\n", column, table)
+	return nil
+}
+
+// widenColumnToText changes a column of an existing table to TEXT if it isn't already
+func widenColumnToText(db *sql.DB, table string, column string) error {
+	var dataType string
+	err := db.QueryRow(`
+		SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2
+	`, table, column).Scan(&dataType)
+	if err != nil {
+		return fmt.Errorf("failed to check type of %s column: %v", column, err)
+	}
+	if dataType == "text" {
+		return nil
+	}
+	if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN %s TYPE TEXT`, table, column)); err != nil {
+		return fmt.Errorf("failed to change %s column of %s to TEXT: %v", column, table, err)
+	}
+	log.Printf("Changed %s column of %s table to TEXT\n", column, table)
 	return nil
 }
 
@@ -99,7 +703,7 @@ func migrateSubCategorySupport(db *sql.DB) error {
 	err := db.QueryRow(`
 		SELECT EXISTS (
 			SELECT 1 FROM information_schema.columns 
-			WHERE table_name = 'expenses' AND column_name = 'subcategory'
+			WHERE table_schema = current_schema() AND table_name = 'expenses' AND column_name = 'subcategory'
 		)
 	`).Scan(&columnExists)
 	if err != nil {
@@ -119,7 +723,7 @@ func migrateSubCategorySupport(db *sql.DB) error {
 	err = db.QueryRow(`
 		SELECT EXISTS (
 			SELECT 1 FROM information_schema.columns 
-			WHERE table_name = 'config' AND column_name = 'subcategories'
+			WHERE table_schema = current_schema() AND table_name = 'config' AND column_name = 'subcategories'
 		)
 	`).Scan(&columnExists)
 	if err != nil {
@@ -139,7 +743,7 @@ func migrateSubCategorySupport(db *sql.DB) error {
 	err = db.QueryRow(`
 		SELECT EXISTS (
 			SELECT 1 FROM information_schema.columns 
-			WHERE table_name = 'config' AND column_name = 'subcategory_mappings'
+			WHERE table_schema = current_schema() AND table_name = 'config' AND column_name = 'subcategory_mappings'
 		)
 	`).Scan(&columnExists)
 	if err != nil {
@@ -159,10 +763,28 @@ func migrateSubCategorySupport(db *sql.DB) error {
 }
 
 func (s *databaseStore) Close() error {
+	s.stmts.close()
 	return s.db.Close()
 }
 
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
 func (s *databaseStore) saveConfig(config *Config) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if err := s.saveConfigWith(tx, config); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *databaseStore) saveConfigWith(db execer, config *Config) error {
 	categoriesJSON, err := json.Marshal(config.Categories)
 	if err != nil {
 		return fmt.Errorf("failed to marshal categories: %v", err)
@@ -175,20 +797,152 @@ func (s *databaseStore) saveConfig(config *Config) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal subcategory map: %v", err)
 	}
+	budgetsJSON, err := json.Marshal(config.Budgets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal budgets: %v", err)
+	}
+	periodJSON, err := json.Marshal(config.Period)
+	if err != nil {
+		return fmt.Errorf("failed to marshal period: %v", err)
+	}
+	categoryMetaJSON, err := json.Marshal(config.CategoryMeta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal category metadata: %v", err)
+	}
+	archivedJSON, err := json.Marshal(config.Archived)
+	if err != nil {
+		return fmt.Errorf("failed to marshal archived categories: %v", err)
+	}
+	reportsJSON, err := json.Marshal(config.Reports)
+	if err != nil {
+		return fmt.Errorf("failed to marshal report settings: %v", err)
+	}
+	merchantAliasesJSON, err := json.Marshal(config.MerchantAliases)
+	if err != nil {
+		return fmt.Errorf("failed to marshal merchant aliases: %v", err)
+	}
+	travelRatesJSON, err := json.Marshal(config.TravelRates)
+	if err != nil {
+		return fmt.Errorf("failed to marshal travel rates: %v", err)
+	}
+	sharesJSON, err := json.Marshal(config.Shares)
+	if err != nil {
+		return fmt.Errorf("failed to marshal shares: %v", err)
+	}
+	settingsJSON, err := json.Marshal(config.Settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal runtime settings: %v", err)
+	}
+	preferencesJSON, err := json.Marshal(config.Preferences)
+	if err != nil {
+		return fmt.Errorf("failed to marshal preferences: %v", err)
+	}
+	customCurrenciesJSON, err := json.Marshal(config.CustomCurrencies)
+	if err != nil {
+		return fmt.Errorf("failed to marshal custom currencies: %v", err)
+	}
+	accountsJSON, err := json.Marshal(config.Accounts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal accounts: %v", err)
+	}
+	holdingsJSON, err := json.Marshal(config.Holdings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal holdings: %v", err)
+	}
+	plannedJSON, err := json.Marshal(config.PlannedExpenses)
+	if err != nil {
+		return fmt.Errorf("failed to marshal planned expenses: %v", err)
+	}
+	savedReportsJSON, err := json.Marshal(config.SavedReports)
+	if err != nil {
+		return fmt.Errorf("failed to marshal saved reports: %v", err)
+	}
+	plaidItemsJSON, err := json.Marshal(config.PlaidItems)
+	if err != nil {
+		return fmt.Errorf("failed to marshal plaid items: %v", err)
+	}
+	projectsJSON, err := json.Marshal(config.Projects)
+	if err != nil {
+		return fmt.Errorf("failed to marshal projects: %v", err)
+	}
+	trmnlProfilesJSON, err := json.Marshal(config.TRMNLProfiles)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trmnl profiles: %v", err)
+	}
+	voiceKeysJSON, err := json.Marshal(config.VoiceKeys)
+	if err != nil {
+		return fmt.Errorf("failed to marshal voice keys: %v", err)
+	}
+	walletDevicesJSON, err := json.Marshal(config.WalletDevices)
+	if err != nil {
+		return fmt.Errorf("failed to marshal wallet devices: %v", err)
+	}
 	query := `
-		INSERT INTO config (id, categories, currency, start_date, subcategories, subcategory_mappings)
-		VALUES ('default', $1, $2, $3, $4, $5)
+		INSERT INTO config (id, categories, currency, start_date, subcategories, subcategory_mappings, budgets, period, category_meta, archived_categories, reports, merchant_aliases, travel_rates, fiscal_year_start, shares, retention_years, settings, custom_currencies, preferences, accounts, holdings, planned_expenses, saved_reports, plaid_items, projects, trmnl_profiles, voice_keys, wallet_devices)
+		VALUES ('default', $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27)
 		ON CONFLICT (id) DO UPDATE SET
 			categories = EXCLUDED.categories,
 			currency = EXCLUDED.currency,
 			start_date = EXCLUDED.start_date,
 			subcategories = EXCLUDED.subcategories,
-			subcategory_mappings = EXCLUDED.subcategory_mappings;
+			subcategory_mappings = EXCLUDED.subcategory_mappings,
+			budgets = EXCLUDED.budgets,
+			period = EXCLUDED.period,
+			category_meta = EXCLUDED.category_meta,
+			archived_categories = EXCLUDED.archived_categories,
+			reports = EXCLUDED.reports,
+			merchant_aliases = EXCLUDED.merchant_aliases,
+			travel_rates = EXCLUDED.travel_rates,
+			fiscal_year_start = EXCLUDED.fiscal_year_start,
+			shares = EXCLUDED.shares,
+			retention_years = EXCLUDED.retention_years,
+			settings = EXCLUDED.settings,
+			custom_currencies = EXCLUDED.custom_currencies,
+			preferences = EXCLUDED.preferences,
+			accounts = EXCLUDED.accounts,
+			holdings = EXCLUDED.holdings,
+			planned_expenses = EXCLUDED.planned_expenses,
+			saved_reports = EXCLUDED.saved_reports,
+			plaid_items = EXCLUDED.plaid_items,
+			projects = EXCLUDED.projects,
+			trmnl_profiles = EXCLUDED.trmnl_profiles,
+			voice_keys = EXCLUDED.voice_keys,
+			wallet_devices = EXCLUDED.wallet_devices;
 	`
-	_, err = s.db.Exec(query, string(categoriesJSON), config.Currency, config.StartDate, string(subCategoriesJSON), string(subCategoryMapJSON))
+	_, err = db.Exec(query, string(categoriesJSON), config.Currency, config.StartDate, string(subCategoriesJSON), string(subCategoryMapJSON), string(budgetsJSON), string(periodJSON), string(categoryMetaJSON), string(archivedJSON), string(reportsJSON), string(merchantAliasesJSON), string(travelRatesJSON), config.FiscalYearStart, string(sharesJSON), config.RetentionYears, string(settingsJSON), string(customCurrenciesJSON), string(preferencesJSON), string(accountsJSON), string(holdingsJSON), string(plannedJSON), string(savedReportsJSON), string(plaidItemsJSON), string(projectsJSON), string(trmnlProfilesJSON), string(voiceKeysJSON), string(walletDevicesJSON))
+	if err != nil {
+		return err
+	}
 	s.defaults["currency"] = config.Currency
 	s.defaults["start_date"] = fmt.Sprintf("%d", config.StartDate)
-	return err
+	return trackConfigChanges(db, config)
+}
+
+// trackConfigChanges records the config records changed by a save; db is the transaction of the
+// save, which holds the same lock as expense writes until it commits
+func trackConfigChanges(db execer, config *Config) error {
+	hashes, err := configHashes(config)
+	if err != nil {
+		return fmt.Errorf("failed to hash config: %v", err)
+	}
+	keys := slices.Sorted(maps.Keys(hashes))
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = hashes[key]
+	}
+	for _, query := range []struct {
+		sql  string
+		args []any
+	}{
+		{`SELECT pg_advisory_xact_lock(hashtext('change_revisions'))`, nil},
+		{trackConfigChangesSQL, []any{pq.Array(keys), pq.Array(values)}},
+		{trackConfigDeletionsSQL, []any{pq.Array(keys)}},
+	} {
+		if _, err := db.Exec(query.sql, query.args...); err != nil {
+			return fmt.Errorf("failed to track config changes: %v", err)
+		}
+	}
+	return nil
 }
 
 func (s *databaseStore) updateConfig(updater func(c *Config) error) error {
@@ -203,11 +957,13 @@ func (s *databaseStore) updateConfig(updater func(c *Config) error) error {
 }
 
 func (s *databaseStore) GetConfig() (*Config, error) {
-	query := `SELECT categories, currency, start_date, subcategories, subcategory_mappings FROM config WHERE id = 'default'`
+	query := `SELECT categories, currency, start_date, subcategories, subcategory_mappings, budgets, period, category_meta, archived_categories, reports, merchant_aliases, travel_rates, fiscal_year_start, shares, retention_years, settings, custom_currencies, preferences, accounts, holdings, planned_expenses, saved_reports, plaid_items, projects, trmnl_profiles, voice_keys, wallet_devices FROM config WHERE id = 'default'`
 	var categoriesStr, currency string
-	var subCategoriesStr, subCategoryMapStr sql.NullString
+	var subCategoriesStr, subCategoryMapStr, budgetsStr, periodStr, categoryMetaStr, archivedStr, reportsStr, merchantAliasesStr, travelRatesStr, sharesStr, settingsStr, customCurrenciesStr, preferencesStr, accountsStr, holdingsStr, plannedStr, savedReportsStr, plaidItemsStr, projectsStr, trmnlProfilesStr, voiceKeysStr, walletDevicesStr sql.NullString
 	var startDate int
-	err := s.db.QueryRow(query).Scan(&categoriesStr, &currency, &startDate, &subCategoriesStr, &subCategoryMapStr)
+	var fiscalYearStart sql.NullInt64
+	var retentionYears sql.NullInt64
+	err := s.db.QueryRow(query).Scan(&categoriesStr, &currency, &startDate, &subCategoriesStr, &subCategoryMapStr, &budgetsStr, &periodStr, &categoryMetaStr, &archivedStr, &reportsStr, &merchantAliasesStr, &travelRatesStr, &fiscalYearStart, &sharesStr, &retentionYears, &settingsStr, &customCurrenciesStr, &preferencesStr, &accountsStr, &holdingsStr, &plannedStr, &savedReportsStr, &plaidItemsStr, &projectsStr, &trmnlProfilesStr, &voiceKeysStr, &walletDevicesStr)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -246,114 +1002,985 @@ func (s *databaseStore) GetConfig() (*Config, error) {
 		config.SubCategoryMap = []SubCategoryMappingRule{}
 	}
 
-	recurring, err := s.GetRecurringExpenses()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get recurring expenses for config: %v", err)
+	// Parse budgets (handle null/empty)
+	if budgetsStr.Valid && budgetsStr.String != "" && budgetsStr.String != "null" {
+		if err := json.Unmarshal([]byte(budgetsStr.String), &config.Budgets); err != nil {
+			return nil, fmt.Errorf("failed to parse budgets from db: %v", err)
+		}
+	} else {
+		config.Budgets = make(map[string]float64)
 	}
-	config.RecurringExpenses = recurring
 
-	return &config, nil
-}
+	// Parse period (missing means monthly)
+	if periodStr.Valid && periodStr.String != "" && periodStr.String != "null" {
+		if err := json.Unmarshal([]byte(periodStr.String), &config.Period); err != nil {
+			return nil, fmt.Errorf("failed to parse period from db: %v", err)
+		}
+	}
 
-func (s *databaseStore) GetCategories() ([]string, error) {
-	config, err := s.GetConfig()
-	if err != nil {
-		return nil, err
+	// Parse category metadata (handle null/empty)
+	if categoryMetaStr.Valid && categoryMetaStr.String != "" && categoryMetaStr.String != "null" {
+		if err := json.Unmarshal([]byte(categoryMetaStr.String), &config.CategoryMeta); err != nil {
+			return nil, fmt.Errorf("failed to parse category metadata from db: %v", err)
+		}
+	} else {
+		config.CategoryMeta = make(map[string]CategoryMeta)
 	}
-	return config.Categories, nil
-}
 
-func (s *databaseStore) UpdateCategories(categories []string) error {
-	return s.updateConfig(func(c *Config) error {
-		c.Categories = categories
-		return nil
-	})
-}
+	// Parse archived categories (handle null/empty)
+	if archivedStr.Valid && archivedStr.String != "" && archivedStr.String != "null" {
+		if err := json.Unmarshal([]byte(archivedStr.String), &config.Archived); err != nil {
+			return nil, fmt.Errorf("failed to parse archived categories from db: %v", err)
+		}
+	} else {
+		config.Archived = []string{}
+	}
 
-func (s *databaseStore) GetCurrency() (string, error) {
-	config, err := s.GetConfig()
-	if err != nil {
-		return "", err
+	// Parse report settings (missing means disabled)
+	if reportsStr.Valid && reportsStr.String != "" && reportsStr.String != "null" {
+		if err := json.Unmarshal([]byte(reportsStr.String), &config.Reports); err != nil {
+			return nil, fmt.Errorf("failed to parse report settings from db: %v", err)
+		}
+	} else {
+		config.Reports = ReportSettings{Recipients: []string{}}
 	}
-	return config.Currency, nil
-}
 
-func (s *databaseStore) UpdateCurrency(currency string) error {
-	if !slices.Contains(SupportedCurrencies, currency) {
-		return fmt.Errorf("invalid currency: %s", currency)
+	// Parse merchant aliases (handle null/empty)
+	if merchantAliasesStr.Valid && merchantAliasesStr.String != "" && merchantAliasesStr.String != "null" {
+		if err := json.Unmarshal([]byte(merchantAliasesStr.String), &config.MerchantAliases); err != nil {
+			return nil, fmt.Errorf("failed to parse merchant aliases from db: %v", err)
+		}
+	} else {
+		config.MerchantAliases = make(map[string]string)
 	}
-	return s.updateConfig(func(c *Config) error {
-		c.Currency = currency
-		return nil
-	})
+
+	// Parse travel rates (missing means no defaults yet)
+	if travelRatesStr.Valid && travelRatesStr.String != "" && travelRatesStr.String != "null" {
+		if err := json.Unmarshal([]byte(travelRatesStr.String), &config.TravelRates); err != nil {
+			return nil, fmt.Errorf("failed to parse travel rates from db: %v", err)
+		}
+	} else {
+		config.TravelRates = TravelRates{DistanceUnit: "km"}
+	}
+
+	// Parse shares (handle null/empty)
+	if sharesStr.Valid && sharesStr.String != "" && sharesStr.String != "null" {
+		if err := json.Unmarshal([]byte(sharesStr.String), &config.Shares); err != nil {
+			return nil, fmt.Errorf("failed to parse shares from db: %v", err)
+		}
+	} else {
+		config.Shares = []Share{}
+	}
+
+	// Missing fiscal year start means calendar years
+	config.FiscalYearStart = 1
+	if fiscalYearStart.Valid && fiscalYearStart.Int64 != 0 {
+		config.FiscalYearStart = int(fiscalYearStart.Int64)
+	}
+	if retentionYears.Valid {
+		config.RetentionYears = int(retentionYears.Int64)
+	}
+
+	if customCurrenciesStr.Valid && customCurrenciesStr.String != "" && customCurrenciesStr.String != "null" {
+		if err := json.Unmarshal([]byte(customCurrenciesStr.String), &config.CustomCurrencies); err != nil {
+			return nil, fmt.Errorf("failed to parse custom currencies from db: %v", err)
+		}
+	}
+
+	if preferencesStr.Valid && preferencesStr.String != "" && preferencesStr.String != "null" {
+		if err := json.Unmarshal([]byte(preferencesStr.String), &config.Preferences); err != nil {
+			return nil, fmt.Errorf("failed to parse preferences from db: %v", err)
+		}
+	}
+
+	if accountsStr.Valid && accountsStr.String != "" && accountsStr.String != "null" {
+		if err := json.Unmarshal([]byte(accountsStr.String), &config.Accounts); err != nil {
+			return nil, fmt.Errorf("failed to parse accounts from db: %v", err)
+		}
+	} else {
+		config.Accounts = []Account{}
+	}
+
+	if holdingsStr.Valid && holdingsStr.String != "" && holdingsStr.String != "null" {
+		if err := json.Unmarshal([]byte(holdingsStr.String), &config.Holdings); err != nil {
+			return nil, fmt.Errorf("failed to parse holdings from db: %v", err)
+		}
+	} else {
+		config.Holdings = []Holding{}
+	}
+
+	if plannedStr.Valid && plannedStr.String != "" && plannedStr.String != "null" {
+		if err := json.Unmarshal([]byte(plannedStr.String), &config.PlannedExpenses); err != nil {
+			return nil, fmt.Errorf("failed to parse planned expenses from db: %v", err)
+		}
+	} else {
+		config.PlannedExpenses = []PlannedExpense{}
+	}
+
+	if savedReportsStr.Valid && savedReportsStr.String != "" && savedReportsStr.String != "null" {
+		if err := json.Unmarshal([]byte(savedReportsStr.String), &config.SavedReports); err != nil {
+			return nil, fmt.Errorf("failed to parse saved reports from db: %v", err)
+		}
+	} else {
+		config.SavedReports = []SavedReport{}
+	}
+
+	if plaidItemsStr.Valid && plaidItemsStr.String != "" && plaidItemsStr.String != "null" {
+		if err := json.Unmarshal([]byte(plaidItemsStr.String), &config.PlaidItems); err != nil {
+			return nil, fmt.Errorf("failed to parse plaid items from db: %v", err)
+		}
+	} else {
+		config.PlaidItems = []PlaidItem{}
+	}
+
+	if projectsStr.Valid && projectsStr.String != "" && projectsStr.String != "null" {
+		if err := json.Unmarshal([]byte(projectsStr.String), &config.Projects); err != nil {
+			return nil, fmt.Errorf("failed to parse projects from db: %v", err)
+		}
+	} else {
+		config.Projects = []Project{}
+	}
+
+	if trmnlProfilesStr.Valid && trmnlProfilesStr.String != "" && trmnlProfilesStr.String != "null" {
+		if err := json.Unmarshal([]byte(trmnlProfilesStr.String), &config.TRMNLProfiles); err != nil {
+			return nil, fmt.Errorf("failed to parse trmnl profiles from db: %v", err)
+		}
+	} else {
+		config.TRMNLProfiles = []TRMNLProfile{}
+	}
+
+	if voiceKeysStr.Valid && voiceKeysStr.String != "" && voiceKeysStr.String != "null" {
+		if err := json.Unmarshal([]byte(voiceKeysStr.String), &config.VoiceKeys); err != nil {
+			return nil, fmt.Errorf("failed to parse voice keys from db: %v", err)
+		}
+	} else {
+		config.VoiceKeys = []VoiceKey{}
+	}
+
+	if walletDevicesStr.Valid && walletDevicesStr.String != "" && walletDevicesStr.String != "null" {
+		if err := json.Unmarshal([]byte(walletDevicesStr.String), &config.WalletDevices); err != nil {
+			return nil, fmt.Errorf("failed to parse wallet devices from db: %v", err)
+		}
+	} else {
+		config.WalletDevices = []WalletDevice{}
+	}
+
+	// Missing runtime settings are left nil and read as the defaults
+	if settingsStr.Valid && settingsStr.String != "" && settingsStr.String != "null" {
+		if err := json.Unmarshal([]byte(settingsStr.String), &config.Settings); err != nil {
+			return nil, fmt.Errorf("failed to parse runtime settings from db: %v", err)
+		}
+	}
+
+	recurring, err := s.GetRecurringExpenses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recurring expenses for config: %v", err)
+	}
+	config.RecurringExpenses = recurring
+
+	return &config, nil
+}
+
+func (s *databaseStore) GetCategories() ([]string, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Categories, nil
+}
+
+func (s *databaseStore) UpdateCategories(categories []string) error {
+	return s.updateConfig(func(c *Config) error {
+		c.SetCategories(categories)
+		return nil
+	})
+}
+
+func (s *databaseStore) RenameCategory(oldName string, newName string) error {
+	return s.reassignCategory(oldName, newName, false)
+}
+
+func (s *databaseStore) MergeCategories(source string, target string) error {
+	return s.reassignCategory(source, target, true)
+}
+
+// reassignCategory updates the config, expenses, and recurring rules in one transaction
+func (s *databaseStore) reassignCategory(from string, to string, merge bool) error {
+	config, err := s.GetConfig()
+	if err != nil {
+		return err
+	}
+	if err := config.ReassignCategory(from, to, merge); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE expenses SET category = $1 WHERE category = $2`, to, from); err != nil {
+		return fmt.Errorf("failed to update expenses: %v", err)
+	}
+	if _, err := tx.Exec(`UPDATE recurring_expenses SET category = $1 WHERE category = $2`, to, from); err != nil {
+		return fmt.Errorf("failed to update recurring expenses: %v", err)
+	}
+	if err := s.saveConfigWith(tx, config); err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	return tx.Commit()
+}
+
+func (s *databaseStore) GetArchivedCategories() ([]string, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Archived, nil
+}
+
+func (s *databaseStore) ArchiveCategory(category string, archived bool) error {
+	return s.updateConfig(func(c *Config) error {
+		return c.SetArchived(category, archived)
+	})
+}
+
+func (s *databaseStore) GetCategoryMeta() (map[string]CategoryMeta, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.CategoryMeta, nil
+}
+
+func (s *databaseStore) UpdateCategoryMeta(meta map[string]CategoryMeta) error {
+	if err := ValidateCategoryMeta(meta); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.CategoryMeta = meta
+		return nil
+	})
+}
+
+func (s *databaseStore) GetCurrency() (string, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.Currency, nil
+}
+
+func (s *databaseStore) UpdateCurrency(currency string) error {
+	return s.updateConfig(func(c *Config) error {
+		if _, ok := LookupCurrency(currency, c.CustomCurrencies); !ok {
+			return fmt.Errorf("invalid currency: %s", currency)
+		}
+		c.Currency = currency
+		return nil
+	})
+}
+
+func (s *databaseStore) GetCustomCurrencies() ([]Currency, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.CustomCurrencies == nil {
+		return []Currency{}, nil
+	}
+	return config.CustomCurrencies, nil
+}
+
+func (s *databaseStore) UpdateCustomCurrencies(currencies []Currency) error {
+	if err := ValidateCustomCurrencies(currencies); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		if _, ok := LookupCurrency(c.Currency, currencies); !ok {
+			return fmt.Errorf("'%s' is the display currency and can't be removed", c.Currency)
+		}
+		c.CustomCurrencies = currencies
+		return nil
+	})
+}
+
+func (s *databaseStore) GetStartDate() (int, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return 0, err
+	}
+	return config.StartDate, nil
+}
+
+func (s *databaseStore) UpdateStartDate(startDate int) error {
+	if startDate < 1 || startDate > 31 {
+		return fmt.Errorf("invalid start date: %d", startDate)
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.StartDate = startDate
+		return nil
+	})
+}
+
+func (s *databaseStore) GetPeriod() (periods.Config, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return periods.Config{}, err
+	}
+	return config.PeriodConfig(), nil
+}
+
+func (s *databaseStore) UpdatePeriod(period periods.Config) error {
+	return s.updateConfig(func(c *Config) error {
+		return c.SetPeriod(period)
+	})
+}
+
+func (s *databaseStore) GetReportSettings() (ReportSettings, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return ReportSettings{}, err
+	}
+	return config.Reports, nil
+}
+
+func (s *databaseStore) UpdateReportSettings(settings ReportSettings) error {
+	if err := ValidateReportSettings(&settings); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.Reports = settings
+		return nil
+	})
+}
+
+func (s *databaseStore) GetMerchantAliases() (map[string]string, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.MerchantAliases, nil
+}
+
+func (s *databaseStore) UpdateMerchantAliases(aliases map[string]string) error {
+	aliases, err := ValidateMerchantAliases(aliases)
+	if err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.MerchantAliases = aliases
+		return nil
+	})
+}
+
+func (s *databaseStore) GetTravelRates() (TravelRates, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return TravelRates{}, err
+	}
+	return config.TravelRates, nil
+}
+
+func (s *databaseStore) UpdateTravelRates(rates TravelRates) error {
+	if err := ValidateTravelRates(&rates); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.TravelRates = rates
+		return nil
+	})
+}
+
+func (s *databaseStore) GetPreferences(user string) (Preferences, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return Preferences{}, err
+	}
+	return config.Preferences[user], nil
+}
+
+func (s *databaseStore) UpdatePreferences(user string, preferences Preferences) error {
+	if err := ValidatePreferences(preferences); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		if c.Preferences == nil {
+			c.Preferences = map[string]Preferences{}
+		}
+		c.Preferences[user] = preferences
+		return nil
+	})
+}
+
+func (s *databaseStore) GetAccounts() ([]Account, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Accounts, nil
+}
+
+func (s *databaseStore) UpdateAccounts(accounts []Account) error {
+	if err := ValidateAccounts(accounts); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.Accounts = accounts
+		return nil
+	})
+}
+
+func (s *databaseStore) GetHoldings() ([]Holding, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Holdings, nil
+}
+
+func (s *databaseStore) UpdateHoldings(holdings []Holding) error {
+	if err := ValidateHoldings(holdings); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.Holdings = holdings
+		return nil
+	})
+}
+
+func (s *databaseStore) GetPlannedExpenses() ([]PlannedExpense, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.PlannedExpenses, nil
+}
+
+func (s *databaseStore) UpdatePlannedExpenses(planned []PlannedExpense) error {
+	if err := ValidatePlannedExpenses(planned); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.PlannedExpenses = planned
+		return nil
+	})
+}
+
+func (s *databaseStore) GetProjects() ([]Project, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Projects, nil
+}
+
+func (s *databaseStore) UpdateProjects(projects []Project) error {
+	if err := ValidateProjects(projects); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.Projects = projects
+		return nil
+	})
+}
+
+func (s *databaseStore) GetTRMNLProfiles() ([]TRMNLProfile, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.TRMNLProfiles, nil
+}
+
+func (s *databaseStore) UpdateTRMNLProfiles(profiles []TRMNLProfile) error {
+	if err := ValidateTRMNLProfiles(profiles); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.TRMNLProfiles = profiles
+		return nil
+	})
+}
+
+func (s *databaseStore) GetVoiceKeys() ([]VoiceKey, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.VoiceKeys, nil
+}
+
+func (s *databaseStore) UpdateVoiceKeys(keys []VoiceKey) error {
+	if err := ValidateVoiceKeys(keys); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.VoiceKeys = keys
+		return nil
+	})
+}
+
+func (s *databaseStore) GetWalletDevices() ([]WalletDevice, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.WalletDevices, nil
+}
+
+func (s *databaseStore) UpdateWalletDevices(devices []WalletDevice) error {
+	if err := ValidateWalletDevices(devices); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.WalletDevices = devices
+		return nil
+	})
+}
+
+func (s *databaseStore) GetSavedReports() ([]SavedReport, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.SavedReports, nil
+}
+
+func (s *databaseStore) UpdateSavedReports(reports []SavedReport) error {
+	if err := ValidateSavedReports(reports); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.SavedReports = reports
+		return nil
+	})
+}
+
+func (s *databaseStore) GetPlaidItems() ([]PlaidItem, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.PlaidItems, nil
+}
+
+func (s *databaseStore) UpdatePlaidItems(items []PlaidItem) error {
+	return s.updateConfig(func(c *Config) error {
+		c.PlaidItems = items
+		return nil
+	})
+}
+
+func (s *databaseStore) GetRuntimeSettings() (RuntimeSettings, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return RuntimeSettings{}, err
+	}
+	return config.RuntimeSettings(), nil
+}
+
+func (s *databaseStore) UpdateRuntimeSettings(settings RuntimeSettings) error {
+	if err := ValidateRuntimeSettings(&settings); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.Settings = &settings
+		return nil
+	})
+}
+
+func (s *databaseStore) GetFiscalYearStart() (int, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return 0, err
+	}
+	return config.FiscalYearStart, nil
+}
+
+func (s *databaseStore) UpdateFiscalYearStart(month int) error {
+	if month < 1 || month > 12 {
+		return fmt.Errorf("invalid fiscal year start month: %d", month)
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.FiscalYearStart = month
+		return nil
+	})
+}
+
+func (s *databaseStore) GetRetentionYears() (int, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return 0, err
+	}
+	return config.RetentionYears, nil
+}
+
+func (s *databaseStore) UpdateRetentionYears(years int) error {
+	if years < 0 || years > MaxRetentionYears {
+		return fmt.Errorf("invalid retention: %d years", years)
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.RetentionYears = years
+		return nil
+	})
+}
+
+func (s *databaseStore) GetShares() ([]Share, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Shares, nil
+}
+
+func (s *databaseStore) UpdateShares(shares []Share) error {
+	return s.updateConfig(func(c *Config) error {
+		c.Shares = shares
+		return nil
+	})
+}
+
+func (s *databaseStore) GetBudgets() (map[string]float64, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Budgets, nil
+}
+
+func (s *databaseStore) UpdateBudgets(budgets map[string]float64) error {
+	if err := ValidateBudgets(budgets); err != nil {
+		return err
+	}
+	return s.updateConfig(func(c *Config) error {
+		c.Budgets = budgets
+		return nil
+	})
+}
+
+func scanExpense(scanner interface{ Scan(...any) error }) (Expense, error) {
+	var expense Expense
+	var tagsStr sql.NullString
+	var recurringID sql.NullString
+	var subCategory sql.NullString
+	var account sql.NullString
+	var notes sql.NullString
+	var latitude, longitude sql.NullFloat64
+	var place sql.NullString
+	var travelStr, taxStr sql.NullString
+	var class, project, refundOf sql.NullString
+	var occurrence sql.NullString
+	var metadataStr sql.NullString
+	var createdAt, updatedAt sql.NullTime
+	err := scanner.Scan(&expense.ID, &recurringID, &expense.Name, &expense.Category, &subCategory, &expense.Amount, &expense.Date, &tagsStr, &account, &notes, &latitude, &longitude, &place, &travelStr, &occurrence, &expense.Edited, &metadataStr, &taxStr, &class, &project, &expense.Excluded, &refundOf, &expense.Reconciled, &createdAt, &updatedAt)
+	if err != nil {
+		return Expense{}, err
+	}
+	expense.CreatedAt, expense.UpdatedAt = nullTimePtr(createdAt), nullTimePtr(updatedAt)
+	if recurringID.Valid {
+		expense.RecurringID = recurringID.String
+	}
+	if subCategory.Valid {
+		expense.SubCategory = subCategory.String
+	}
+	if occurrence.Valid {
+		expense.Occurrence = occurrence.String
+	}
+	if account.Valid {
+		expense.Account = account.String
+	}
+	if class.Valid {
+		expense.Class = class.String
+	}
+	if project.Valid {
+		expense.Project = project.String
+	}
+	if refundOf.Valid {
+		expense.RefundOf = refundOf.String
+	}
+	if notes.Valid {
+		expense.Notes = notes.String
+	}
+	if (latitude.Valid && longitude.Valid) || (place.Valid && place.String != "") {
+		expense.Location = &Location{Place: place.String}
+		if latitude.Valid && longitude.Valid {
+			expense.Location.Latitude, expense.Location.Longitude = &latitude.Float64, &longitude.Float64
+		}
+	}
+	if travelStr.Valid && travelStr.String != "" && travelStr.String != "null" {
+		if err := json.Unmarshal([]byte(travelStr.String), &expense.Travel); err != nil {
+			return Expense{}, fmt.Errorf("failed to parse travel details for expense %s: %v", expense.ID, err)
+		}
+	}
+	if taxStr.Valid && taxStr.String != "" && taxStr.String != "null" {
+		if err := json.Unmarshal([]byte(taxStr.String), &expense.Tax); err != nil {
+			return Expense{}, fmt.Errorf("failed to parse tax details for expense %s: %v", expense.ID, err)
+		}
+	}
+	if metadataStr.Valid && metadataStr.String != "" && metadataStr.String != "null" {
+		if err := json.Unmarshal([]byte(metadataStr.String), &expense.Metadata); err != nil {
+			return Expense{}, fmt.Errorf("failed to parse metadata for expense %s: %v", expense.ID, err)
+		}
+	}
+	if tagsStr.Valid && tagsStr.String != "" {
+		if err := json.Unmarshal([]byte(tagsStr.String), &expense.Tags); err != nil {
+			return Expense{}, fmt.Errorf("failed to parse tags for expense %s: %v", expense.ID, err)
+		}
+	}
+	return expense, nil
+}
+
+// nullTimePtr returns a nullable time column as a pointer, nil for NULL
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// locationColumns splits an optional location into nullable latitude, longitude, and place values
+func locationColumns(location *Location) (sql.NullFloat64, sql.NullFloat64, sql.NullString) {
+	var latitude, longitude sql.NullFloat64
+	var place sql.NullString
+	if location.HasCoordinates() {
+		latitude = sql.NullFloat64{Float64: *location.Latitude, Valid: true}
+		longitude = sql.NullFloat64{Float64: *location.Longitude, Valid: true}
+	}
+	if location != nil && location.Place != "" {
+		place = sql.NullString{String: location.Place, Valid: true}
+	}
+	return latitude, longitude, place
+}
+
+// taxColumn stores optional tax details as JSON
+func taxColumn(tax *TaxDetails) sql.NullString {
+	if tax == nil {
+		return sql.NullString{}
+	}
+	taxJSON, err := json.Marshal(tax)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(taxJSON), Valid: true}
+}
+
+// travelColumn stores optional travel details as JSON
+func travelColumn(travel *TravelDetails) sql.NullString {
+	if travel == nil {
+		return sql.NullString{}
+	}
+	travelJSON, err := json.Marshal(travel)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(travelJSON), Valid: true}
+}
+
+// metadataColumn stores metadata as JSON; nil is NULL, which keeps the stored metadata on updates
+func metadataColumn(metadata Metadata) sql.NullString {
+	if metadata == nil {
+		return sql.NullString{}
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(metadataJSON), Valid: true}
+}
+
+// occurrenceColumn stores the scheduled day of a recurring expense instance, NULL for other expenses
+func occurrenceColumn(occurrence string) sql.NullString {
+	return sql.NullString{String: occurrence, Valid: occurrence != ""}
+}
+
+func (s *databaseStore) GetAllExpenses() ([]Expense, error) {
+	rows, err := s.stmts.selectExpenses.Query()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expenses: %v", err)
+	}
+	defer rows.Close()
+
+	var expenses []Expense
+	for rows.Next() {
+		expense, err := scanExpense(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan expense: %v", err)
+		}
+		expenses = append(expenses, expense)
+	}
+	return expenses, nil
+}
+
+// FindExpenses filters in the database, with the same columns and order as selectExpensesSQL
+func (s *databaseStore) FindExpenses(filter ExpenseFilter) ([]Expense, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	where, args := filter.sqlPredicates(filterCategories(config))
+	query := strings.Replace(selectExpensesSQL, " ORDER BY", " WHERE "+where+" ORDER BY", 1)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expenses: %v", err)
+	}
+	defer rows.Close()
+
+	expenses := []Expense{}
+	for rows.Next() {
+		expense, err := scanExpense(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan expense: %v", err)
+		}
+		expenses = append(expenses, expense)
+	}
+	return expenses, rows.Err()
 }
 
-func (s *databaseStore) GetStartDate() (int, error) {
-	config, err := s.GetConfig()
+func (s *databaseStore) SuggestExpenseNames(prefix string, limit int) ([]NameSuggestion, error) {
+	rows, err := s.db.Query(suggestExpenseNamesSQL, likePrefix(strings.ToLower(prefix)), limit)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("failed to query expense names: %v", err)
 	}
-	return config.StartDate, nil
+	defer rows.Close()
+	suggestions := []NameSuggestion{}
+	for rows.Next() {
+		var suggestion NameSuggestion
+		if err := rows.Scan(&suggestion.Name, &suggestion.Category, &suggestion.SubCategory, &suggestion.Amount, &suggestion.Currency, &suggestion.Uses, &suggestion.LastUsed); err != nil {
+			return nil, fmt.Errorf("failed to scan expense name: %v", err)
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions, rows.Err()
 }
 
-func (s *databaseStore) UpdateStartDate(startDate int) error {
-	if startDate < 1 || startDate > 31 {
-		return fmt.Errorf("invalid start date: %d", startDate)
+func (s *databaseStore) GetMonthlyAggregates() ([]MonthlyAggregate, error) {
+	query := `SELECT period, category, subcategory, income, expenses FROM monthly_aggregates WHERE income <> 0 OR expenses <> 0 ORDER BY period`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query monthly aggregates: %v", err)
 	}
-	return s.updateConfig(func(c *Config) error {
-		c.StartDate = startDate
-		return nil
-	})
+	defer rows.Close()
+	aggregates := []MonthlyAggregate{}
+	for rows.Next() {
+		var aggregate MonthlyAggregate
+		if err := rows.Scan(&aggregate.Period, &aggregate.Category, &aggregate.SubCategory, &aggregate.Income, &aggregate.Expenses); err != nil {
+			return nil, fmt.Errorf("failed to scan monthly aggregate: %v", err)
+		}
+		aggregates = append(aggregates, aggregate)
+	}
+	return aggregates, nil
 }
 
-func scanExpense(scanner interface{ Scan(...any) error }) (Expense, error) {
-	var expense Expense
-	var tagsStr sql.NullString
-	var recurringID sql.NullString
-	var subCategory sql.NullString
-	err := scanner.Scan(&expense.ID, &recurringID, &expense.Name, &expense.Category, &subCategory, &expense.Amount, &expense.Date, &tagsStr)
+func (s *databaseStore) GetExpenseChanges(since int64, limit int) ([]ExpenseChange, int64, error) {
+	var latest int64
+	query := `SELECT GREATEST((SELECT COALESCE(MAX(revision), 0) FROM expense_changes), (SELECT COALESCE(MAX(revision), 0) FROM config_changes))`
+	if err := s.db.QueryRow(query).Scan(&latest); err != nil {
+		return nil, 0, fmt.Errorf("failed to query latest revision: %v", err)
+	}
+	query = `SELECT id, revision, created_revision, updated_at, deleted_at FROM expense_changes WHERE revision > $1 AND revision <= $2 ORDER BY revision`
+	args := []any{since, latest}
+	if limit > 0 {
+		query += ` LIMIT $3`
+		args = append(args, limit)
+	}
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
-		return Expense{}, err
+		return nil, 0, fmt.Errorf("failed to query expense changes: %v", err)
 	}
-	if recurringID.Valid {
-		expense.RecurringID = recurringID.String
+	defer rows.Close()
+	changes := []ExpenseChange{}
+	index := make(map[string]int)
+	var ids []string
+	for rows.Next() {
+		change, err := scanExpenseChange(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan expense change: %v", err)
+		}
+		if change.DeletedAt == nil {
+			index[change.ID] = len(changes)
+			ids = append(ids, change.ID)
+		}
+		changes = append(changes, change)
 	}
-	if subCategory.Valid {
-		expense.SubCategory = subCategory.String
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to query expense changes: %v", err)
 	}
-	if tagsStr.Valid && tagsStr.String != "" {
-		if err := json.Unmarshal([]byte(tagsStr.String), &expense.Tags); err != nil {
-			return Expense{}, fmt.Errorf("failed to parse tags for expense %s: %v", expense.ID, err)
+	if len(ids) == 0 {
+		return changes, latest, nil
+	}
+	expenseRows, err := s.db.Query(strings.Replace(selectExpensesSQL, " ORDER BY", " WHERE id = ANY($1) ORDER BY", 1), pq.Array(ids))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query changed expenses: %v", err)
+	}
+	defer expenseRows.Close()
+	for expenseRows.Next() {
+		expense, err := scanExpense(expenseRows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan expense: %v", err)
 		}
+		changes[index[expense.ID]].Expense = &expense
 	}
-	return expense, nil
+	return changes, latest, expenseRows.Err()
 }
 
-func (s *databaseStore) GetAllExpenses() ([]Expense, error) {
-	query := `SELECT id, recurring_id, name, category, subcategory, amount, date, tags FROM expenses ORDER BY date DESC`
-	rows, err := s.db.Query(query)
+func (s *databaseStore) GetExpenseChange(id string) (ExpenseChange, error) {
+	change, err := scanExpenseChange(s.db.QueryRow(`SELECT id, revision, created_revision, updated_at, deleted_at FROM expense_changes WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return ExpenseChange{ID: id}, nil
+	} else if err != nil {
+		return ExpenseChange{}, fmt.Errorf("failed to get expense change: %v", err)
+	}
+	if change.DeletedAt != nil {
+		return change, nil
+	}
+	expense, err := scanExpense(s.stmts.selectExpense.QueryRow(id))
+	if err != nil && err != sql.ErrNoRows {
+		return ExpenseChange{}, fmt.Errorf("failed to get expense: %v", err)
+	} else if err == nil {
+		change.Expense = &expense
+	}
+	return change, nil
+}
+
+func scanExpenseChange(scanner interface{ Scan(...any) error }) (ExpenseChange, error) {
+	var change ExpenseChange
+	var deletedAt sql.NullTime
+	if err := scanner.Scan(&change.ID, &change.Revision, &change.CreatedRevision, &change.UpdatedAt, &deletedAt); err != nil {
+		return ExpenseChange{}, err
+	}
+	if deletedAt.Valid {
+		change.DeletedAt = &deletedAt.Time
+	}
+	return change, nil
+}
+
+func (s *databaseStore) GetConfigChanges(since int64) ([]ConfigChange, error) {
+	query := `SELECT key, revision, created_revision, updated_at, deleted_at FROM config_changes WHERE revision > $1 ORDER BY revision`
+	rows, err := s.db.Query(query, since)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query expenses: %v", err)
+		return nil, fmt.Errorf("failed to query config changes: %v", err)
 	}
 	defer rows.Close()
-
-	var expenses []Expense
+	changes := []ConfigChange{}
 	for rows.Next() {
-		expense, err := scanExpense(rows)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan expense: %v", err)
+		var change ConfigChange
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&change.Key, &change.Revision, &change.CreatedRevision, &change.UpdatedAt, &deletedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan config change: %v", err)
 		}
-		expenses = append(expenses, expense)
+		if deletedAt.Valid {
+			change.DeletedAt = &deletedAt.Time
+		}
+		changes = append(changes, change)
 	}
-	return expenses, nil
+	return changes, rows.Err()
 }
 
 func (s *databaseStore) GetExpense(id string) (Expense, error) {
-	query := `SELECT id, recurring_id, name, category, subcategory, amount, date, tags FROM expenses WHERE id = $1`
-	expense, err := scanExpense(s.db.QueryRow(query, id))
+	expense, err := scanExpense(s.stmts.selectExpense.QueryRow(id))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return Expense{}, fmt.Errorf("expense with ID %s not found", id)
+			return Expense{}, fmt.Errorf("expense with ID %s %w", id, ErrNotFound)
 		}
 		return Expense{}, fmt.Errorf("failed to get expense: %v", err)
 	}
@@ -386,6 +2013,11 @@ func (s *databaseStore) FindDuplicateExpense(name string, category string, amoun
 }
 
 func (s *databaseStore) AddExpense(expense Expense) error {
+	return s.insertExpense(s.stmts.insertExpense, expense)
+}
+
+// insertExpense fills in the defaults of a new expense and inserts it with the prepared statement
+func (s *databaseStore) insertExpense(stmt *sql.Stmt, expense Expense) error {
 	if expense.ID == "" {
 		expense.ID = uuid.New().String()
 	}
@@ -399,11 +2031,12 @@ func (s *databaseStore) AddExpense(expense Expense) error {
 	if err != nil {
 		return err
 	}
-	query := `
-		INSERT INTO expenses (id, recurring_id, name, category, subcategory, amount, currency, date, tags)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	`
-	_, err = s.db.Exec(query, expense.ID, expense.RecurringID, expense.Name, expense.Category, expense.SubCategory, expense.Amount, expense.Currency, expense.Date, string(tagsJSON))
+	latitude, longitude, place := locationColumns(expense.Location)
+	_, err = stmt.Exec(expense.ID, expense.RecurringID, expense.Name, expense.Category, expense.SubCategory, expense.Amount, expense.Currency, expense.Date, string(tagsJSON), expense.Account, expense.Notes, latitude, longitude, place, travelColumn(expense.Travel), occurrenceColumn(expense.Occurrence), expense.Edited, metadataColumn(expense.Metadata), taxColumn(expense.Tax), expense.Class, expense.Project, expense.Excluded, expense.RefundOf, expense.Reconciled)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation of the ID
+		return fmt.Errorf("expense with ID %s %w", expense.ID, ErrAlreadyExists)
+	}
 	return err
 }
 
@@ -416,12 +2049,8 @@ func (s *databaseStore) UpdateExpense(id string, expense Expense) error {
 	if expense.Currency == "" {
 		expense.Currency = s.defaults["currency"]
 	}
-	query := `
-		UPDATE expenses
-		SET name = $1, category = $2, subcategory = $3, amount = $4, currency = $5, date = $6, tags = $7, recurring_id = $8
-		WHERE id = $9
-	`
-	result, err := s.db.Exec(query, expense.Name, expense.Category, expense.SubCategory, expense.Amount, expense.Currency, expense.Date, string(tagsJSON), expense.RecurringID, id)
+	latitude, longitude, place := locationColumns(expense.Location)
+	result, err := s.stmts.updateExpense.Exec(expense.Name, expense.Category, expense.SubCategory, expense.Amount, expense.Currency, expense.Date, string(tagsJSON), expense.RecurringID, expense.Account, expense.Notes, latitude, longitude, place, travelColumn(expense.Travel), occurrenceColumn(expense.Occurrence), expense.Edited, metadataColumn(expense.Metadata), id, taxColumn(expense.Tax), expense.Class, expense.Project, expense.Excluded, expense.RefundOf, expense.Reconciled, expense.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to update expense: %v", err)
 	}
@@ -430,7 +2059,12 @@ func (s *databaseStore) UpdateExpense(id string, expense Expense) error {
 		return fmt.Errorf("failed to get rows affected: %v", err)
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("expense with ID %s not found", id)
+		// the expense is there, but was written since it was read
+		var exists bool
+		if err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM expenses WHERE id = $1)`, id).Scan(&exists); err == nil && exists {
+			return ErrExpenseChanged
+		}
+		return fmt.Errorf("expense with ID %s %w", id, ErrNotFound)
 	}
 	return nil
 }
@@ -446,7 +2080,7 @@ func (s *databaseStore) RemoveExpense(id string) error {
 		return fmt.Errorf("failed to get rows affected: %v", err)
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("expense with ID %s not found", id)
+		return fmt.Errorf("expense with ID %s %w", id, ErrNotFound)
 	}
 	return nil
 }
@@ -455,13 +2089,21 @@ func (s *databaseStore) AddMultipleExpenses(expenses []Expense) error {
 	if len(expenses) == 0 {
 		return nil
 	}
-	// use the same addexpense method
+	// one transaction, so an import holds a single connection and is all or nothing
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Rollback on error
+
+	stmt := tx.Stmt(s.stmts.insertExpense)
+	defer stmt.Close()
 	for _, exp := range expenses {
-		if err := s.AddExpense(exp); err != nil {
+		if err := s.insertExpense(stmt, exp); err != nil {
 			return err
 		}
 	}
-	return nil
+	return tx.Commit()
 }
 
 func (s *databaseStore) RemoveMultipleExpenses(ids []string) error {
@@ -476,23 +2118,57 @@ func (s *databaseStore) RemoveMultipleExpenses(ids []string) error {
 	return nil
 }
 
+func (s *databaseStore) MoveExpenses(ids []string, category string, subCategory string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	// a recurring instance moved by hand is kept as is when its rule is edited
+	query := `UPDATE expenses SET category = $1, subcategory = $2, edited = edited OR COALESCE(recurring_id, '') <> '' WHERE id = ANY($3)`
+	_, err := s.db.Exec(query, category, subCategory, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to move expenses: %v", err)
+	}
+	return nil
+}
+
 func scanRecurringExpense(scanner interface{ Scan(...any) error }) (RecurringExpense, error) {
 	var re RecurringExpense
 	var tagsStr sql.NullString
-	err := scanner.Scan(&re.ID, &re.Name, &re.Amount, &re.Currency, &re.Category, &re.StartDate, &re.Interval, &re.Occurrences, &tagsStr)
+	var overridesStr sql.NullString
+	var endDate sql.NullTime
+	var createdAt, updatedAt sql.NullTime
+	err := scanner.Scan(&re.ID, &re.Name, &re.Amount, &re.Currency, &re.Category, &re.StartDate, &re.Interval, &re.Occurrences, &tagsStr, &endDate, &overridesStr, &createdAt, &updatedAt)
 	if err != nil {
 		return RecurringExpense{}, err
 	}
+	re.CreatedAt, re.UpdatedAt = nullTimePtr(createdAt), nullTimePtr(updatedAt)
+	if endDate.Valid {
+		re.EndDate = &endDate.Time
+	}
 	if tagsStr.Valid && tagsStr.String != "" {
 		if err := json.Unmarshal([]byte(tagsStr.String), &re.Tags); err != nil {
 			return RecurringExpense{}, fmt.Errorf("failed to parse tags for recurring expense %s: %v", re.ID, err)
 		}
 	}
+	if err := parseRecurringOverrides(overridesStr, &re); err != nil {
+		return RecurringExpense{}, err
+	}
 	return re, nil
 }
 
+// parseRecurringOverrides reads the overrides column into a recurring expense
+func parseRecurringOverrides(overridesStr sql.NullString, re *RecurringExpense) error {
+	if !overridesStr.Valid || overridesStr.String == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(overridesStr.String), &re.Overrides); err != nil {
+		return fmt.Errorf("failed to parse overrides for recurring expense %s: %v", re.ID, err)
+	}
+	return nil
+}
+
 func (s *databaseStore) GetRecurringExpenses() ([]RecurringExpense, error) {
-	query := `SELECT id, name, amount, currency, category, start_date, interval, occurrences, tags FROM recurring_expenses`
+	query := `SELECT id, name, amount, currency, category, start_date, interval, occurrences, tags, end_date, overrides, created_at, updated_at FROM recurring_expenses`
 	rows, err := s.db.Query(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query recurring expenses: %v", err)
@@ -510,11 +2186,11 @@ func (s *databaseStore) GetRecurringExpenses() ([]RecurringExpense, error) {
 }
 
 func (s *databaseStore) GetRecurringExpense(id string) (RecurringExpense, error) {
-	query := `SELECT id, name, amount, category, start_date, interval, occurrences, tags FROM recurring_expenses WHERE id = $1`
+	query := `SELECT id, name, amount, currency, category, start_date, interval, occurrences, tags, end_date, overrides, created_at, updated_at FROM recurring_expenses WHERE id = $1`
 	re, err := scanRecurringExpense(s.db.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return RecurringExpense{}, fmt.Errorf("recurring expense with ID %s not found", id)
+			return RecurringExpense{}, fmt.Errorf("recurring expense with ID %s %w", id, ErrNotFound)
 		}
 		return RecurringExpense{}, fmt.Errorf("failed to get recurring expense: %v", err)
 	}
@@ -535,25 +2211,26 @@ func (s *databaseStore) AddRecurringExpense(recurringExpense RecurringExpense) e
 		recurringExpense.Currency = s.defaults["currency"]
 	}
 	tagsJSON, _ := json.Marshal(recurringExpense.Tags)
+	overridesJSON, _ := json.Marshal(recurringExpense.Overrides)
 	ruleQuery := `
-		INSERT INTO recurring_expenses (id, name, amount, currency, category, start_date, interval, occurrences, tags)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO recurring_expenses (id, name, amount, currency, category, start_date, interval, occurrences, tags, end_date, overrides)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 	`
-	_, err = tx.Exec(ruleQuery, recurringExpense.ID, recurringExpense.Name, recurringExpense.Amount, recurringExpense.Currency, recurringExpense.Category, recurringExpense.StartDate, recurringExpense.Interval, recurringExpense.Occurrences, string(tagsJSON))
+	_, err = tx.Exec(ruleQuery, recurringExpense.ID, recurringExpense.Name, recurringExpense.Amount, recurringExpense.Currency, recurringExpense.Category, recurringExpense.StartDate, recurringExpense.Interval, recurringExpense.Occurrences, string(tagsJSON), recurringExpense.EndDate, string(overridesJSON))
 	if err != nil {
 		return fmt.Errorf("failed to insert recurring expense rule: %v", err)
 	}
 
 	expensesToAdd := generateExpensesFromRecurring(recurringExpense, false)
 	if len(expensesToAdd) > 0 {
-		stmt, err := tx.Prepare(pq.CopyIn("expenses", "id", "recurring_id", "name", "category", "subcategory", "amount", "currency", "date", "tags"))
+		stmt, err := tx.Prepare(pq.CopyIn("expenses", "id", "recurring_id", "name", "category", "subcategory", "amount", "currency", "date", "tags", "occurrence"))
 		if err != nil {
 			return fmt.Errorf("failed to prepare copy in: %v", err)
 		}
 		defer stmt.Close()
 		for _, exp := range expensesToAdd {
 			expTagsJSON, _ := json.Marshal(exp.Tags)
-			_, err = stmt.Exec(exp.ID, exp.RecurringID, exp.Name, exp.Category, exp.SubCategory, exp.Amount, exp.Currency, exp.Date, string(expTagsJSON))
+			_, err = stmt.Exec(exp.ID, exp.RecurringID, exp.Name, exp.Category, exp.SubCategory, exp.Amount, exp.Currency, exp.Date, string(expTagsJSON), exp.Occurrence)
 			if err != nil {
 				return fmt.Errorf("failed to execute copy in: %v", err)
 			}
@@ -576,42 +2253,73 @@ func (s *databaseStore) UpdateRecurringExpense(id string, recurringExpense Recur
 		recurringExpense.Currency = s.defaults["currency"]
 	}
 	tagsJSON, _ := json.Marshal(recurringExpense.Tags)
+	// a rule sent without overrides keeps the ones it has
+	var overridesJSON sql.NullString
+	if recurringExpense.Overrides != nil {
+		raw, _ := json.Marshal(recurringExpense.Overrides)
+		overridesJSON = sql.NullString{String: string(raw), Valid: true}
+	}
 	ruleQuery := `
 		UPDATE recurring_expenses
-		SET name = $1, amount = $2, category = $3, start_date = $4, interval = $5, occurrences = $6, tags = $7, currency = $8
-		WHERE id = $9
+		SET name = $1, amount = $2, category = $3, start_date = $4, interval = $5, occurrences = $6, tags = $7, currency = $8, end_date = $9, overrides = COALESCE($11, overrides)
+		WHERE id = $10
+		RETURNING overrides
 	`
-	res, err := tx.Exec(ruleQuery, recurringExpense.Name, recurringExpense.Amount, recurringExpense.Category, recurringExpense.StartDate, recurringExpense.Interval, recurringExpense.Occurrences, string(tagsJSON), recurringExpense.Currency, id)
+	var storedOverrides sql.NullString
+	err = tx.QueryRow(ruleQuery, recurringExpense.Name, recurringExpense.Amount, recurringExpense.Category, recurringExpense.StartDate, recurringExpense.Interval, recurringExpense.Occurrences, string(tagsJSON), recurringExpense.Currency, recurringExpense.EndDate, id, overridesJSON).Scan(&storedOverrides)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("recurring expense with ID %s %w to update", id, ErrNotFound)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to update recurring expense rule: %v", err)
 	}
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		return fmt.Errorf("recurring expense with ID %s not found to update", id)
+	recurringExpense.Overrides = nil
+	if err := parseRecurringOverrides(storedOverrides, &recurringExpense); err != nil {
+		return err
 	}
 
+	// instances edited by hand are kept, along with their occurrence
 	var deleteQuery string
 	if updateAll {
-		deleteQuery = `DELETE FROM expenses WHERE recurring_id = $1`
+		deleteQuery = `DELETE FROM expenses WHERE recurring_id = $1 AND NOT edited`
 		_, err = tx.Exec(deleteQuery, id)
 	} else {
-		deleteQuery = `DELETE FROM expenses WHERE recurring_id = $1 AND date > $2`
+		deleteQuery = `DELETE FROM expenses WHERE recurring_id = $1 AND NOT edited AND date > $2`
 		_, err = tx.Exec(deleteQuery, id, time.Now())
 	}
 	if err != nil {
 		return fmt.Errorf("failed to delete old expense instances for update: %v", err)
 	}
+	rows, err := tx.Query(`SELECT occurrence, date FROM expenses WHERE recurring_id = $1 AND edited`, id)
+	if err != nil {
+		return fmt.Errorf("failed to query edited expense instances: %v", err)
+	}
+	var edited []Expense
+	for rows.Next() {
+		expense := Expense{RecurringID: id, Edited: true}
+		var occurrence sql.NullString
+		if err := rows.Scan(&occurrence, &expense.Date); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan edited expense instance: %v", err)
+		}
+		expense.Occurrence = occurrence.String
+		edited = append(edited, expense)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query edited expense instances: %v", err)
+	}
 
-	expensesToAdd := generateExpensesFromRecurring(recurringExpense, !updateAll)
+	expensesToAdd := withoutEditedOccurrences(recurringExpense, generateExpensesFromRecurring(recurringExpense, !updateAll), edited)
 	if len(expensesToAdd) > 0 {
-		stmt, err := tx.Prepare(pq.CopyIn("expenses", "id", "recurring_id", "name", "category", "subcategory", "amount", "currency", "date", "tags"))
+		stmt, err := tx.Prepare(pq.CopyIn("expenses", "id", "recurring_id", "name", "category", "subcategory", "amount", "currency", "date", "tags", "occurrence"))
 		if err != nil {
 			return fmt.Errorf("failed to prepare copy in for update: %v", err)
 		}
 		defer stmt.Close()
 		for _, exp := range expensesToAdd {
 			expTagsJSON, _ := json.Marshal(exp.Tags)
-			_, err = stmt.Exec(exp.ID, exp.RecurringID, exp.Name, exp.Category, exp.SubCategory, exp.Amount, exp.Currency, exp.Date, string(expTagsJSON))
+			_, err = stmt.Exec(exp.ID, exp.RecurringID, exp.Name, exp.Category, exp.SubCategory, exp.Amount, exp.Currency, exp.Date, string(expTagsJSON), exp.Occurrence)
 			if err != nil {
 				return fmt.Errorf("failed to execute copy in for update: %v", err)
 			}
@@ -629,15 +2337,7 @@ func (s *databaseStore) RemoveRecurringExpense(id string, removeAll bool) error
 		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
 	defer tx.Rollback()
-	res, err := tx.Exec(`DELETE FROM recurring_expenses WHERE id = $1`, id)
-	if err != nil {
-		return fmt.Errorf("failed to delete recurring expense rule: %v", err)
-	}
-	rowsAffected, _ := res.RowsAffected()
-	if rowsAffected == 0 {
-		return fmt.Errorf("recurring expense with ID %s not found", id)
-	}
-
+	// instances go first, since deleting the rule detaches the ones that are left
 	var deleteQuery string
 	if removeAll {
 		deleteQuery = `DELETE FROM expenses WHERE recurring_id = $1`
@@ -649,39 +2349,55 @@ func (s *databaseStore) RemoveRecurringExpense(id string, removeAll bool) error
 	if err != nil {
 		return fmt.Errorf("failed to delete expense instances: %v", err)
 	}
+	res, err := tx.Exec(`DELETE FROM recurring_expenses WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete recurring expense rule: %v", err)
+	}
+	rowsAffected, _ := res.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("recurring expense with ID %s %w", id, ErrNotFound)
+	}
 	return tx.Commit()
 }
 
+// maxRecurringOccurrences caps generation for rules that only have an end date
+const maxRecurringOccurrences = 3000
+
 func generateExpensesFromRecurring(recExp RecurringExpense, fromToday bool) []Expense {
 	var expenses []Expense
+	if recExp.Occurrences == 0 && recExp.EndDate == nil {
+		return expenses
+	}
+	// an end date includes every occurrence on that day
+	var endOfDay time.Time
+	if recExp.EndDate != nil {
+		end := recExp.EndDate.In(recExp.StartDate.Location())
+		endOfDay = time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, end.Location())
+	}
+	pastEnd := func(date time.Time) bool {
+		return !endOfDay.IsZero() && !date.Before(endOfDay)
+	}
 	currentDate := recExp.StartDate
 	today := time.Now()
 	occurrencesToGenerate := recExp.Occurrences
+	if recExp.Occurrences == 0 {
+		occurrencesToGenerate = maxRecurringOccurrences
+	}
 	if fromToday {
-		for currentDate.Before(today) && (recExp.Occurrences == 0 || occurrencesToGenerate > 0) {
-			switch recExp.Interval {
-			case "daily":
-				currentDate = currentDate.AddDate(0, 0, 1)
-			case "weekly":
-				currentDate = currentDate.AddDate(0, 0, 7)
-			case "monthly":
-				currentDate = currentDate.AddDate(0, 1, 0)
-			case "yearly":
-				currentDate = currentDate.AddDate(1, 0, 0)
-			default:
+		for currentDate.Before(today) && occurrencesToGenerate > 0 {
+			next, ok := nextOccurrence(currentDate, recExp.Interval)
+			if !ok {
 				return expenses // Stop if interval is invalid
 			}
-			if recExp.Occurrences > 0 {
-				occurrencesToGenerate--
-			}
+			currentDate = next
+			occurrencesToGenerate--
 		}
 	}
-	limit := occurrencesToGenerate
-	// if recExp.Occurrences == 0 {
-	// 	limit = 2000 // Heuristic for "indefinite"
-	// }
 
-	for range limit {
+	for range occurrencesToGenerate {
+		if pastEnd(currentDate) {
+			break
+		}
 		expense := Expense{
 			ID:          uuid.New().String(),
 			RecurringID: recExp.ID,
@@ -691,24 +2407,81 @@ func generateExpensesFromRecurring(recExp RecurringExpense, fromToday bool) []Ex
 			Currency:    recExp.Currency,
 			Date:        currentDate,
 			Tags:        recExp.Tags,
+			Occurrence:  recExp.OccurrenceDate(currentDate),
 		}
-		expenses = append(expenses, expense)
-		switch recExp.Interval {
-		case "daily":
-			currentDate = currentDate.AddDate(0, 0, 1)
-		case "weekly":
-			currentDate = currentDate.AddDate(0, 0, 7)
-		case "monthly":
-			currentDate = currentDate.AddDate(0, 1, 0)
-		case "yearly":
-			currentDate = currentDate.AddDate(1, 0, 0)
-		default:
+		if applyOverride(recExp, &expense) {
+			expenses = append(expenses, expense)
+		}
+		next, ok := nextOccurrence(currentDate, recExp.Interval)
+		if !ok {
 			return expenses
 		}
+		currentDate = next
 	}
 	return expenses
 }
 
+// applyOverride changes a scheduled occurrence by the rule's override for its day, and
+// returns false if the occurrence is skipped
+func applyOverride(recExp RecurringExpense, expense *Expense) bool {
+	override, ok := recExp.Override(expense.Occurrence)
+	if !ok {
+		return true
+	}
+	if override.Amount != nil {
+		expense.Amount = *override.Amount
+	}
+	if override.MoveTo != nil {
+		expense.Date = *override.MoveTo
+	}
+	return !override.Skip
+}
+
+// RecurringOccurrence returns the occurrence of a recurring expense scheduled on a day (YYYY-MM-DD) with
+// its override applied; scheduled is false if no occurrence falls on that day and skipped is true if
+// the override skips it
+func RecurringOccurrence(recExp RecurringExpense, date string) (expense Expense, scheduled bool, skipped bool) {
+	plain := recExp
+	plain.Overrides = nil
+	for _, occurrence := range generateExpensesFromRecurring(plain, false) {
+		if occurrence.Occurrence == date {
+			return occurrence, true, !applyOverride(recExp, &occurrence)
+		}
+	}
+	return Expense{}, false, false
+}
+
+// withoutEditedOccurrences drops the generated instances of occurrences that have an instance edited by hand
+func withoutEditedOccurrences(recExp RecurringExpense, generated []Expense, existing []Expense) []Expense {
+	edited := make(map[string]bool)
+	for _, expense := range existing {
+		if expense.RecurringID == recExp.ID && expense.Edited {
+			edited[recExp.OccurrenceOf(expense)] = true
+		}
+	}
+	return slices.DeleteFunc(generated, func(expense Expense) bool { return edited[expense.Occurrence] })
+}
+
+// RecurringOccurrences returns every occurrence a recurring expense should have, each with a new ID
+func RecurringOccurrences(recExp RecurringExpense) []Expense {
+	return generateExpensesFromRecurring(recExp, false)
+}
+
+// nextOccurrence advances date by one recurrence interval
+func nextOccurrence(date time.Time, interval string) (time.Time, bool) {
+	switch interval {
+	case "daily":
+		return date.AddDate(0, 0, 1), true
+	case "weekly":
+		return date.AddDate(0, 0, 7), true
+	case "monthly":
+		return date.AddDate(0, 1, 0), true
+	case "yearly":
+		return date.AddDate(1, 0, 0), true
+	}
+	return date, false
+}
+
 // SubCategory Management
 
 func (s *databaseStore) GetSubCategories(category string) ([]string, error) {
@@ -792,6 +2565,30 @@ func (s *databaseStore) RenameSubCategory(category string, oldName string, newNa
 	})
 }
 
+// MergeSubCategory updates the config and expenses in one transaction
+func (s *databaseStore) MergeSubCategory(category string, source string, target string) error {
+	config, err := s.GetConfig()
+	if err != nil {
+		return err
+	}
+	if err := config.MergeSubCategoryConfig(category, source, target); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	query := `UPDATE expenses SET subcategory = $1 WHERE category = $2 AND subcategory = $3`
+	if _, err := tx.Exec(query, target, category, source); err != nil {
+		return fmt.Errorf("failed to update expenses: %v", err)
+	}
+	if err := s.saveConfigWith(tx, config); err != nil {
+		return fmt.Errorf("failed to save config: %v", err)
+	}
+	return tx.Commit()
+}
+
 // SubCategory Mapping
 
 func (s *databaseStore) GetSubCategoryMappings() ([]SubCategoryMappingRule, error) {
@@ -811,3 +2608,206 @@ func (s *databaseStore) UpdateSubCategoryMappings(rules []SubCategoryMappingRule
 		return nil
 	})
 }
+
+type textRow struct {
+	id    string
+	name  string
+	notes sql.NullString
+	tags  []string
+}
+
+// rewriteExpenseText rewrites the name, notes, and tags of every expense, archived expense, and recurring expense in one transaction
+func (s *databaseStore) rewriteExpenseText(rewrite func(name *string, notes *string, tags []string) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback() // Rollback on error
+
+	for _, table := range []string{"expenses", "expenses_archive", "recurring_expenses"} {
+		columns := "id, name, notes, tags"
+		if table == "recurring_expenses" {
+			columns = "id, name, NULL, tags"
+		}
+		rows, err := tx.Query(fmt.Sprintf(`SELECT %s FROM %s`, columns, table))
+		if err != nil {
+			return fmt.Errorf("failed to query %s: %v", table, err)
+		}
+		var textRows []textRow
+		for rows.Next() {
+			var row textRow
+			var tagsStr sql.NullString
+			if err := rows.Scan(&row.id, &row.name, &row.notes, &tagsStr); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan %s: %v", table, err)
+			}
+			if tagsStr.Valid && tagsStr.String != "" {
+				if err := json.Unmarshal([]byte(tagsStr.String), &row.tags); err != nil {
+					rows.Close()
+					return fmt.Errorf("failed to parse tags for %s: %v", row.id, err)
+				}
+			}
+			textRows = append(textRows, row)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to read %s: %v", table, err)
+		}
+
+		for _, row := range textRows {
+			var notes *string
+			if table != "recurring_expenses" {
+				notes = &row.notes.String
+			}
+			if err := rewrite(&row.name, notes, row.tags); err != nil {
+				return err
+			}
+			tagsJSON, _ := json.Marshal(row.tags)
+			if notes != nil {
+				_, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET name = $1, notes = $2, tags = $3 WHERE id = $4`, table), row.name, row.notes.String, string(tagsJSON), row.id)
+			} else {
+				_, err = tx.Exec(`UPDATE recurring_expenses SET name = $1, tags = $2 WHERE id = $3`, row.name, string(tagsJSON), row.id)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to update %s: %v", table, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// Archive
+
+const expenseColumns = `id, recurring_id, name, category, subcategory, amount, currency, date, tags, account, notes, latitude, longitude, place, travel, occurrence, edited, metadata, tax, class, project, excluded, refund_of, reconciled, created_at, updated_at`
+
+// ArchiveExpenses moves expenses dated before the cutoff to the archive table in one statement
+func (s *databaseStore) ArchiveExpenses(before time.Time) (int, error) {
+	query := fmt.Sprintf(`
+		WITH moved AS (
+			DELETE FROM expenses WHERE date < $1
+			RETURNING %[1]s
+		)
+		INSERT INTO expenses_archive (%[1]s)
+		SELECT %[1]s FROM moved
+	`, expenseColumns)
+	result, err := s.db.Exec(query, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive expenses: %v", err)
+	}
+	archived, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if archived > 0 {
+		log.Printf("Archived %d expenses dated before %s\n", archived, before.Format("2006-01-02"))
+	}
+	return int(archived), nil
+}
+
+func (s *databaseStore) GetArchivedExpenses() ([]Expense, error) {
+	query := `SELECT id, recurring_id, name, category, subcategory, amount, date, tags, account, notes, latitude, longitude, place, travel, occurrence, edited, metadata, tax, class, project, excluded, refund_of, reconciled, created_at, updated_at FROM expenses_archive ORDER BY date DESC`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archived expenses: %v", err)
+	}
+	defer rows.Close()
+	var expenses []Expense
+	for rows.Next() {
+		expense, err := scanExpense(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan archived expense: %v", err)
+		}
+		expenses = append(expenses, expense)
+	}
+	return expenses, nil
+}
+
+// Maintenance
+
+// Vacuum rebuilds the monthly aggregates, then vacuums and analyzes the expense tables; it runs
+// outside a transaction, which VACUUM doesn't allow
+func (s *databaseStore) Vacuum() error {
+	if err := setupMonthlyAggregates(s.db); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`VACUUM ANALYZE expenses, expenses_archive, monthly_aggregates`); err != nil {
+		return fmt.Errorf("failed to vacuum: %v", err)
+	}
+	log.Println("Vacuumed the expense tables")
+	return nil
+}
+
+// Login Tokens
+
+const selectLoginTokensSQL = `SELECT hash, kind, user_email, role, device, ip, created_at, COALESCE(last_used_at, created_at), expires_at FROM login_tokens`
+
+func scanLoginToken(scanner interface{ Scan(...any) error }) (LoginToken, error) {
+	var token LoginToken
+	err := scanner.Scan(&token.Hash, &token.Kind, &token.User, &token.Role, &token.Device, &token.IP, &token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt)
+	return token, err
+}
+
+func (s *databaseStore) GetLoginToken(hash string) (LoginToken, error) {
+	token, err := scanLoginToken(s.db.QueryRow(selectLoginTokensSQL+` WHERE hash = $1 AND expires_at > NOW()`, hash))
+	if err == sql.ErrNoRows {
+		return LoginToken{}, fmt.Errorf("login token not found")
+	} else if err != nil {
+		return LoginToken{}, fmt.Errorf("failed to get login token: %v", err)
+	}
+	return token, nil
+}
+
+// GetLoginTokens returns the active tokens of a user, or of everyone when user is empty
+func (s *databaseStore) GetLoginTokens(user string) ([]LoginToken, error) {
+	rows, err := s.db.Query(selectLoginTokensSQL+` WHERE expires_at > NOW() AND ($1 = '' OR user_email = $1) ORDER BY created_at`, user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query login tokens: %v", err)
+	}
+	defer rows.Close()
+	tokens := []LoginToken{}
+	for rows.Next() {
+		token, err := scanLoginToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan login token: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// AddLoginToken stores a token, dropping the ones that have expired
+func (s *databaseStore) AddLoginToken(token LoginToken) error {
+	if _, err := s.db.Exec(`DELETE FROM login_tokens WHERE expires_at <= NOW()`); err != nil {
+		return fmt.Errorf("failed to delete expired login tokens: %v", err)
+	}
+	query := `INSERT INTO login_tokens (hash, kind, user_email, role, device, ip, created_at, last_used_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	if _, err := s.db.Exec(query, token.Hash, token.Kind, token.User, token.Role, token.Device, token.IP, token.CreatedAt, token.LastUsedAt, token.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to add login token: %v", err)
+	}
+	return nil
+}
+
+// UpdateLoginToken saves the role, device, address, and last use of a token
+func (s *databaseStore) UpdateLoginToken(token LoginToken) error {
+	query := `UPDATE login_tokens SET role = $2, device = $3, ip = $4, last_used_at = $5 WHERE hash = $1`
+	result, err := s.db.Exec(query, token.Hash, token.Role, token.Device, token.IP, token.LastUsedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update login token: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("login token not found")
+	}
+	return nil
+}
+
+func (s *databaseStore) RemoveLoginToken(hash string) (bool, error) {
+	result, err := s.db.Exec(`DELETE FROM login_tokens WHERE hash = $1`, hash)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove login token: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to remove login token: %v", err)
+	}
+	return rows > 0, nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ExpenseFilter narrows down the expenses listed by FindExpenses; every set field has to match
+type ExpenseFilter struct {
+	MinAmount     *float64 `json:"minAmount,omitempty"` // on the absolute amount, so 10 matches both -10 and 10
+	MaxAmount     *float64 `json:"maxAmount,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	AllTags       bool     `json:"allTags,omitempty"`       // match expenses with every one of Tags instead of any of them
+	SubCategory   string   `json:"subCategory,omitempty"`   // exact subcategory
+	Account       string   `json:"account,omitempty"`       // ignoring case
+	Class         string   `json:"class,omitempty"`         // personal, business, reimbursable, or reimbursed
+	Project       string   `json:"project,omitempty"`       // ignoring case
+	RecurringOnly bool     `json:"recurringOnly,omitempty"` // instances of recurring expenses
+	UntaggedOnly  bool     `json:"untaggedOnly,omitempty"`
+	Uncategorized bool     `json:"uncategorized,omitempty"` // category isn't configured, neither active nor archived
+}
+
+// IsEmpty reports whether the filter matches every expense
+func (f ExpenseFilter) IsEmpty() bool {
+	return f.MinAmount == nil && f.MaxAmount == nil && len(f.Tags) == 0 && f.SubCategory == "" && f.Account == "" && f.Class == "" && f.Project == "" &&
+		!f.RecurringOnly && !f.UntaggedOnly && !f.Uncategorized
+}
+
+// ValidateExpenseFilter checks the amount range and cleans up the text fields
+func ValidateExpenseFilter(f *ExpenseFilter) error {
+	for _, bound := range []*float64{f.MinAmount, f.MaxAmount} {
+		if bound != nil && (*bound < 0 || math.IsNaN(*bound) || math.IsInf(*bound, 0)) {
+			return fmt.Errorf("amount bounds must be non-negative numbers")
+		}
+	}
+	if f.MinAmount != nil && f.MaxAmount != nil && *f.MinAmount > *f.MaxAmount {
+		return fmt.Errorf("invalid amount range: minimum is above maximum")
+	}
+	tags := make([]string, 0, len(f.Tags))
+	for _, tag := range f.Tags {
+		if tag = SanitizeString(tag); tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	f.Tags = tags
+	if f.UntaggedOnly && len(f.Tags) > 0 {
+		return fmt.Errorf("untagged expenses can't be filtered by tags")
+	}
+	f.SubCategory = SanitizeString(f.SubCategory)
+	f.Account = SanitizeString(f.Account)
+	f.Project = SanitizeString(f.Project)
+	if f.Class = strings.ToLower(strings.TrimSpace(f.Class)); f.Class != "" && !slices.Contains(ExpenseClasses, f.Class) {
+		return fmt.Errorf("invalid 'class': must be personal, business, reimbursable, or reimbursed")
+	}
+	return nil
+}
+
+// Matches checks an expense against the filter in memory; categories are the configured ones
+func (f ExpenseFilter) Matches(expense Expense, categories []string) bool {
+	amount := math.Abs(expense.Amount)
+	switch {
+	case f.MinAmount != nil && amount < *f.MinAmount,
+		f.MaxAmount != nil && amount > *f.MaxAmount,
+		f.SubCategory != "" && expense.SubCategory != f.SubCategory,
+		f.Account != "" && !strings.EqualFold(expense.Account, f.Account),
+		f.Class != "" && expense.ExpenseClass() != f.Class,
+		f.Project != "" && !strings.EqualFold(expense.Project, f.Project),
+		f.RecurringOnly && expense.RecurringID == "",
+		f.UntaggedOnly && len(expense.Tags) > 0,
+		f.Uncategorized && slices.Contains(categories, expense.Category):
+		return false
+	}
+	if len(f.Tags) > 0 {
+		has := func(tag string) bool { return slices.Contains(expense.Tags, tag) }
+		if f.AllTags && !allOf(f.Tags, has) || !f.AllTags && !slices.ContainsFunc(f.Tags, has) {
+			return false
+		}
+	}
+	return true
+}
+
+func allOf(values []string, match func(string) bool) bool {
+	for _, value := range values {
+		if !match(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterCategories are the categories an expense needs to not count as uncategorized
+func filterCategories(config *Config) []string {
+	return append(slices.Clone(config.Categories), config.Archived...)
+}
+
+// sqlPredicates turns the filter into a WHERE clause on the expenses table and its arguments;
+// tags are stored as a JSON array, so they are matched with jsonb operators
+func (f ExpenseFilter) sqlPredicates(categories []string) (string, []any) {
+	var clauses []string
+	var args []any
+	add := func(clause string, arg any) {
+		args = append(args, arg)
+		clauses = append(clauses, fmt.Sprintf(clause, len(args)))
+	}
+	const tagsJSON = `COALESCE(NULLIF(tags, ''), 'null')::jsonb`
+	if f.MinAmount != nil {
+		add("ABS(amount) >= $%d", *f.MinAmount)
+	}
+	if f.MaxAmount != nil {
+		add("ABS(amount) <= $%d", *f.MaxAmount)
+	}
+	if len(f.Tags) > 0 {
+		if f.AllTags {
+			add(tagsJSON+" ?& $%d", pq.Array(f.Tags))
+		} else {
+			add(tagsJSON+" ?| $%d", pq.Array(f.Tags))
+		}
+	}
+	if f.SubCategory != "" {
+		add("subcategory = $%d", f.SubCategory)
+	}
+	if f.Account != "" {
+		add("LOWER(account) = LOWER($%d)", f.Account)
+	}
+	if f.Project != "" {
+		add("LOWER(project) = LOWER($%d)", f.Project)
+	}
+	if f.Class != "" {
+		add("COALESCE(NULLIF(class, ''), 'personal') = $%d", f.Class)
+	}
+	if f.RecurringOnly {
+		clauses = append(clauses, "COALESCE(recurring_id, '') <> ''")
+	}
+	if f.UntaggedOnly {
+		clauses = append(clauses, "COALESCE(jsonb_array_length(NULLIF("+tagsJSON+", 'null'::jsonb)), 0) = 0")
+	}
+	if f.Uncategorized {
+		add("NOT (category = ANY($%d))", pq.Array(categories))
+	}
+	if len(clauses) == 0 {
+		return "TRUE", nil
+	}
+	return strings.Join(clauses, " AND "), args
+}
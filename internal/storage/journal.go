@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The JSON backend journals every mutation before making it. The files a mutation writes (e.g.,
+// the expenses and config of RenameCategory) are held until it's done, then their new contents
+// are appended to journal.log as one record and synced to disk, then the files are replaced, then
+// the journal is cleared. Opening the store replays whatever the journal still holds, so a crash
+// or power loss can't lose a mutation that was acknowledged, or leave it half applied. A record
+// torn by a crash while it was being appended is dropped, as none of its files were touched yet.
+
+// journalRecord is a line of the journal: the full new contents of the files of a mutation
+type journalRecord struct {
+	Files []journalFile `json:"files"`
+	Sum   string        `json:"sum"` // sha256 of the files, to tell a torn record apart
+}
+
+type journalFile struct {
+	Path    string `json:"path"`
+	Content []byte `json:"content"`
+}
+
+// renameFile replaces a file with its written temporary file; tests swap it to crash between files
+var renameFile = os.Rename
+
+// journaledFiles keeps the files on disk like diskFiles, holding the writes of a mutation until
+// commit journals and applies them together
+type journaledFiles struct {
+	diskFiles
+	mu        sync.Mutex
+	journal   *os.File
+	pending   []journalFile // written since the last commit, latest contents only
+	unapplied bool          // the journal holds a record whose files weren't all replaced
+}
+
+// openJournal replays the journal in dir, left over from a crash, and opens it for the next writes
+func openJournal(dir string) (*journaledFiles, error) {
+	path := filepath.Join(dir, "journal.log")
+	replayed, err := replayJournal(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay journal: %v", err)
+	}
+	if replayed > 0 {
+		log.Printf("Replayed %d journaled mutations after an unclean shutdown\n", replayed)
+	}
+	journal, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := journal.Truncate(0); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	return &journaledFiles{journal: journal}, nil
+}
+
+// replayJournal writes the files of every complete record in the journal, in order, returning
+// how many records it applied; the records are full contents, so replaying a mutation that was
+// made is harmless
+func replayJournal(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	replayed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, len(content)+1)
+	for scanner.Scan() {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Sum != journalSum(record.Files) {
+			log.Printf("Dropped a torn journal record after %d complete ones\n", replayed)
+			break
+		}
+		if _, err := applyFiles(record.Files); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, scanner.Err()
+}
+
+// applyFiles writes every file to a temporary file before renaming any into place, so a failure
+// before the renames leaves the files as they were; renamed reports whether any was replaced
+func applyFiles(files []journalFile) (renamed bool, err error) {
+	temps := make([]string, len(files))
+	defer func() {
+		for _, tmp := range temps {
+			if tmp != "" {
+				os.Remove(tmp) // no-op once renamed
+			}
+		}
+	}()
+	for i, file := range files {
+		if temps[i], err = writeTempFile(file.Path, file.Content); err != nil {
+			return false, fmt.Errorf("failed to write %s: %v", file.Path, err)
+		}
+	}
+	dirs := make(map[string]bool)
+	for i, file := range files {
+		if err := renameFile(temps[i], file.Path); err != nil {
+			return i > 0, fmt.Errorf("failed to replace %s: %v", file.Path, err)
+		}
+		dirs[filepath.Dir(file.Path)] = true
+	}
+	// the renames have to be on disk before the journal is cleared
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func (j *journaledFiles) readFile(path string) ([]byte, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.finish(); err != nil {
+		return nil, err
+	}
+	for _, file := range j.pending {
+		if file.Path == path {
+			return file.Content, nil
+		}
+	}
+	return j.diskFiles.readFile(path)
+}
+
+func (j *journaledFiles) writeFile(path string, content []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	content = bytes.Clone(content)
+	for i := range j.pending {
+		if j.pending[i].Path == path {
+			j.pending[i].Content = content
+			return nil
+		}
+	}
+	j.pending = append(j.pending, journalFile{Path: path, Content: content})
+	return nil
+}
+
+// commit journals the files written since the last commit as one record and replaces them
+func (j *journaledFiles) commit() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	files := j.pending
+	j.pending = nil
+	if err := j.finish(); err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	line, err := json.Marshal(journalRecord{Files: files, Sum: journalSum(files)})
+	if err != nil {
+		return err
+	}
+	if _, err := j.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to journal write: %v", err)
+	}
+	if err := j.journal.Sync(); err != nil {
+		return fmt.Errorf("failed to journal write: %v", err)
+	}
+	if renamed, err := applyFiles(files); err != nil {
+		if renamed {
+			// some files were replaced, so the record stays to finish the mutation
+			j.unapplied = true
+			return fmt.Errorf("%v; the write is finished before the files are read again", err)
+		}
+		// nothing was replaced and the write is reported as failed, so it mustn't be replayed either
+		if err := j.clear(); err != nil {
+			log.Printf("Failed to clear the journal: %v\n", err)
+		}
+		return err
+	}
+	return j.clear()
+}
+
+// finish applies the record a failed commit left in the journal, before the files are used again
+func (j *journaledFiles) finish() error {
+	if !j.unapplied {
+		return nil
+	}
+	if _, err := replayJournal(j.journal.Name()); err != nil {
+		return fmt.Errorf("failed to apply an earlier write: %v", err)
+	}
+	if err := j.clear(); err != nil {
+		return err
+	}
+	j.unapplied = false
+	return nil
+}
+
+// discard drops the files written since the last commit, for a mutation that failed
+func (j *journaledFiles) discard() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.pending = nil
+}
+
+// clear empties the journal once its writes are on disk
+func (j *journaledFiles) clear() error {
+	if err := j.journal.Truncate(0); err != nil {
+		return err
+	}
+	return j.journal.Sync()
+}
+
+// Close closes the journal, which is empty between mutations
+func (j *journaledFiles) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.pending = nil
+	return j.journal.Close()
+}
+
+func journalSum(files []journalFile) string {
+	sum := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(sum, "%s\x00%d\x00", file.Path, len(file.Content))
+		sum.Write(file.Content)
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// syncDir flushes a directory, making the renames in it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func journalLine(t *testing.T, files ...journalFile) []byte {
+	t.Helper()
+	line, err := json.Marshal(journalRecord{Files: files, Sum: journalSum(files)})
+	if err != nil {
+		t.Fatalf("Failed to marshal journal record: %v", err)
+	}
+	return append(line, '\n')
+}
+
+// TestJournalReplay checks that opening the store applies every complete record and drops a torn one
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	a, b, c := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json"), filepath.Join(dir, "c.json")
+	if err := os.WriteFile(a, []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	journal := journalLine(t, journalFile{Path: a, Content: []byte("new a")}, journalFile{Path: b, Content: []byte("new b")})
+	torn := journalLine(t, journalFile{Path: c, Content: []byte("new c")})
+	journal = append(journal, torn[:len(torn)/2]...)
+	if err := os.WriteFile(filepath.Join(dir, "journal.log"), journal, 0644); err != nil {
+		t.Fatalf("Failed to write journal: %v", err)
+	}
+
+	files, err := openJournal(dir)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer files.Close()
+	for path, want := range map[string]string{a: "new a", b: "new b"} {
+		if content, err := os.ReadFile(path); err != nil || string(content) != want {
+			t.Errorf("Expected %s to be replayed as %q, got %q, %v", filepath.Base(path), want, content, err)
+		}
+	}
+	if _, err := os.Stat(c); !os.IsNotExist(err) {
+		t.Errorf("Expected the torn record to be dropped, got %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "journal.log")); err != nil || info.Size() != 0 {
+		t.Errorf("Expected the journal to be cleared after replaying, got %v, %v", info, err)
+	}
+}
+
+// TestJournalBatch checks that a mutation's files reach the disk together on commit, or not at all
+func TestJournalBatch(t *testing.T) {
+	dir := t.TempDir()
+	files, err := openJournal(dir)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	defer files.Close()
+	a, b := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")
+	files.writeFile(a, []byte("a1"))
+	files.writeFile(b, []byte("b1"))
+	files.writeFile(a, []byte("a2"))
+	if content, err := files.readFile(a); err != nil || string(content) != "a2" {
+		t.Errorf("Expected the mutation to read its own write, got %q, %v", content, err)
+	}
+	if _, err := os.Stat(a); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing on disk before the commit, got %v", err)
+	}
+	if err := files.commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	for path, want := range map[string]string{a: "a2", b: "b1"} {
+		if content, err := os.ReadFile(path); err != nil || string(content) != want {
+			t.Errorf("Expected %s to be %q, got %q, %v", filepath.Base(path), want, content, err)
+		}
+	}
+
+	files.writeFile(a, []byte("a3"))
+	files.discard()
+	if err := files.commit(); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if content, _ := os.ReadFile(a); string(content) != "a2" {
+		t.Errorf("Expected a discarded write to stay off disk, got %q", content)
+	}
+}
+
+// TestJournalCrashBetweenFiles checks that a rename of a category is finished on restart when the
+// process dies after replacing the expenses file but before the config file
+func TestJournalCrashBetweenFiles(t *testing.T) {
+	dir := t.TempDir()
+	store, err := InitializeJsonStore(SystemConfig{StorageURL: dir})
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if err := store.AddExpense(Expense{ID: "1", Name: "Pizza", Category: "Food", Amount: -20, Date: time.Now()}); err != nil {
+		t.Fatalf("Failed to add expense: %v", err)
+	}
+
+	// nothing is replaced when the first rename fails, and the journal is cleared
+	crashOn := func(name string) {
+		renameFile = func(from string, to string) error {
+			if filepath.Base(to) == name {
+				return errors.New("crashed")
+			}
+			return os.Rename(from, to)
+		}
+	}
+	t.Cleanup(func() { renameFile = os.Rename })
+	crashOn("expenses.json")
+	if err := store.RenameCategory("Food", "Dining"); err == nil {
+		t.Fatal("Expected the failed rename to be reported")
+	}
+	renameFile = os.Rename
+	if config, _ := store.GetConfig(); !strings.Contains(strings.Join(config.Categories, ","), "Food") {
+		t.Errorf("Expected the config to keep Food, got %v", config.Categories)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "journal.log")); info.Size() != 0 {
+		t.Errorf("Expected a write that changed nothing not to stay in the journal, got %d bytes", info.Size())
+	}
+
+	// the expenses file is replaced, then the process dies before the config file
+	crashOn("config.json")
+	if err := store.RenameCategory("Food", "Dining"); err == nil {
+		t.Fatal("Expected the failed rename to be reported")
+	}
+	renameFile = os.Rename
+	store.files.(*journaledFiles).journal.Close() // the crash, without finishing the write
+	config, _ := os.ReadFile(filepath.Join(dir, "config.json"))
+	expenses, _ := os.ReadFile(filepath.Join(dir, "expenses.json"))
+	if !strings.Contains(string(config), `"Food"`) || !strings.Contains(string(expenses), `"Dining"`) {
+		t.Fatalf("Expected the files to be torn between the old and new category")
+	}
+	journal, _ := os.ReadFile(filepath.Join(dir, "journal.log"))
+	if strings.Count(string(journal), "\n") != 1 {
+		t.Errorf("Expected the rename to be one journal record, got %d", strings.Count(string(journal), "\n"))
+	}
+
+	store, err = InitializeJsonStore(SystemConfig{StorageURL: dir})
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	defer store.Close()
+	categories, _ := store.GetCategories()
+	all, _ := store.GetAllExpenses()
+	if !strings.Contains(strings.Join(categories, ","), "Dining") || strings.Contains(strings.Join(categories, ","), "Food") || len(all) != 1 || all[0].Category != "Dining" {
+		t.Errorf("Expected the replay to finish the rename, got %v and %+v", categories, all)
+	}
+}
@@ -1,9 +1,14 @@
 package storage
 
 import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
@@ -12,12 +17,19 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/tanq16/expenseowl/internal/periods"
 )
 
 // JSONStore implementats Storage interface - for JSON file storage
 type jsonStore struct {
-	configPath string
-	filePath   string
+	files       jsonFiles
+	configPath  string
+	filePath    string
+	archivePath string             // gzipped expensesFileData with archived expenses
+	tokensPath  string             // loginTokensFileData, created on the first login
+	changesPath string             // changesFileData, the revisions of the expenses file
+	aggregates  []MonthlyAggregate // of the expenses file, updated on every write
+	changes     *changesFileData   // updated on every write
 	mu         sync.RWMutex
 	defaults   map[string]string // allows reusing defaults without querying for config
 }
@@ -26,21 +38,80 @@ type expensesFileData struct {
 	Expenses []Expense `json:"expenses"`
 }
 
+// changesFileData tracks the latest change of every expense in the expenses file and every record
+// in the config file, deleted ones included
+type changesFileData struct {
+	Revision int64                     `json:"revision"` // of the latest change
+	Expenses map[string]*trackedChange `json:"expenses"`
+	Config   map[string]*trackedChange `json:"config"`
+}
+
+// trackedChange is an ExpenseChange without the expense; the hash of the record tells an edit
+// apart from the file being rewritten with the record unchanged
+type trackedChange struct {
+	Revision  int64      `json:"revision"`
+	Created   int64      `json:"created"` // revision that added the record
+	Hash      string     `json:"hash,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+type loginTokensFileData struct {
+	Tokens []LoginToken `json:"tokens"`
+}
+
+// jsonFiles is where a jsonStore keeps its files: the data directory, or memory for a memoryStore
+type jsonFiles interface {
+	readFile(path string) ([]byte, error) // errors with os.ErrNotExist for a missing file
+	writeFile(path string, content []byte) error
+}
+
+// batchFiles are jsonFiles that hold the writes of a mutation until it's done, to apply them together
+type batchFiles interface {
+	commit() error // applies the writes since the last commit
+	discard()      // drops them, for a mutation that failed
+}
+
+// diskFiles keeps the files on disk, replacing each file atomically
+type diskFiles struct{}
+
+func (diskFiles) readFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (diskFiles) writeFile(path string, content []byte) error {
+	return writeFileAtomic(path, content)
+}
+
 func InitializeJsonStore(baseConfig SystemConfig) (*jsonStore, error) {
-	configPath := filepath.Join(baseConfig.StorageURL, "config.json")
-	filePath := filepath.Join(baseConfig.StorageURL, "expenses.json")
-	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+	if err := os.MkdirAll(baseConfig.StorageURL, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create storage directory: %v", err)
 	}
+	files, err := openJournal(baseConfig.StorageURL)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newJSONStore(files, baseConfig.StorageURL)
+	if err != nil {
+		files.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// newJSONStore opens the store in dir, creating the expenses and config files if they don't exist
+func newJSONStore(files jsonFiles, dir string) (*jsonStore, error) {
+	configPath := filepath.Join(dir, "config.json")
+	filePath := filepath.Join(dir, "expenses.json")
 
 	// create expenses file if it doesn't exist
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	if _, err := files.readFile(filePath); os.IsNotExist(err) {
 		initialData := expensesFileData{Expenses: []Expense{}}
 		data, err := json.Marshal(initialData)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal initial data: %v", err)
 		}
-		if err := os.WriteFile(filePath, data, 0644); err != nil {
+		if err := files.writeFile(filePath, data); err != nil {
 			return nil, fmt.Errorf("failed to create storage file: %v", err)
 		}
 		log.Println("Created expense storage file")
@@ -49,14 +120,14 @@ func InitializeJsonStore(baseConfig SystemConfig) (*jsonStore, error) {
 	}
 
 	// create config file if it doesn't exist
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+	if _, err := files.readFile(configPath); os.IsNotExist(err) {
 		initialConfig := Config{}
 		initialConfig.SetBaseConfig()
 		data, err := json.Marshal(initialConfig)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal initial config: %v", err)
 		}
-		if err := os.WriteFile(configPath, data, 0644); err != nil {
+		if err := files.writeFile(configPath, data); err != nil {
 			return nil, fmt.Errorf("failed to create config file: %v", err)
 		}
 		log.Println("Created expense storage config")
@@ -64,137 +135,1013 @@ func InitializeJsonStore(baseConfig SystemConfig) (*jsonStore, error) {
 		log.Println("Found existing expense storage config")
 	}
 
-	return &jsonStore{
-		configPath: configPath,
-		filePath:   filePath,
-		defaults:   map[string]string{},
-	}, nil
+	store := &jsonStore{
+		files:       files,
+		configPath:  configPath,
+		filePath:    filePath,
+		archivePath: filepath.Join(dir, "expenses-archive.json.gz"),
+		tokensPath:  filepath.Join(dir, "login-tokens.json"),
+		changesPath: filepath.Join(dir, "expense-changes.json"),
+		defaults:    map[string]string{},
+	}
+	data, err := store.readExpensesFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage file: %v", err)
+	}
+	store.aggregates = AggregateMonthly(data.Expenses)
+	// expenses written before changes were tracked, or by hand, get their revisions now
+	if store.changes, err = store.readChangesFile(store.changesPath); err != nil {
+		return nil, fmt.Errorf("failed to read changes file: %v", err)
+	}
+	config, err := store.readConfigFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	configHashes, err := configHashes(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	now := time.Now()
+	expensesChanged := store.changes.track(store.changes.Expenses, expenseHashes(data.Expenses), now)
+	if configChanged := store.changes.track(store.changes.Config, configHashes, now); expensesChanged || configChanged {
+		if err := store.writeChangesFile(store.changesPath, store.changes); err != nil {
+			return nil, fmt.Errorf("failed to write changes file: %v", err)
+		}
+	}
+	if batch, ok := files.(batchFiles); ok {
+		if err := batch.commit(); err != nil {
+			return nil, fmt.Errorf("failed to write storage files: %v", err)
+		}
+	}
+	return store, nil
 }
 
 // primitive methods
 
 func (s *jsonStore) readExpensesFile(path string) (*expensesFileData, error) {
-	content, err := os.ReadFile(path)
+	content, err := s.files.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var data expensesFileData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	log.Println("Read expenses file")
+	return &data, nil
+}
+
+func (s *jsonStore) writeExpensesFile(path string, data *expensesFileData) error {
+	now := time.Now()
+	hashes := expenseHashes(data.Expenses)
+	for i := range data.Expenses {
+		s.changes.stamp(&data.Expenses[i], hashes[data.Expenses[i].ID], now)
+	}
+	content, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := s.files.writeFile(path, content); err != nil {
+		return err
+	}
+	log.Println("Wrote expenses file")
+	s.aggregates = AggregateMonthly(data.Expenses)
+	if s.changes.track(s.changes.Expenses, hashes, now) {
+		return s.writeChangesFile(s.changesPath, s.changes)
+	}
+	return nil
+}
+
+// readChangesFile reads the tracked changes, which don't exist until the store is first opened
+func (s *jsonStore) readChangesFile(path string) (*changesFileData, error) {
+	content, err := s.files.readFile(path)
+	if os.IsNotExist(err) {
+		return &changesFileData{Expenses: map[string]*trackedChange{}, Config: map[string]*trackedChange{}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var data changesFileData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	if data.Expenses == nil {
+		data.Expenses = map[string]*trackedChange{}
+	}
+	if data.Config == nil {
+		data.Config = map[string]*trackedChange{}
+	}
+	return &data, nil
+}
+
+func (s *jsonStore) writeChangesFile(path string, data *changesFileData) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return s.files.writeFile(path, content)
+}
+
+// track compares the records (ID or key -> hash) with the tracked ones, giving a new revision to
+// every record that was added or edited and a tombstone to every record that's gone, and reports
+// whether anything changed
+func (c *changesFileData) track(tracked map[string]*trackedChange, records map[string]string, now time.Time) bool {
+	changed := false
+	for _, id := range slices.Sorted(maps.Keys(records)) {
+		previous, ok := tracked[id]
+		if ok && previous.DeletedAt == nil && previous.Hash == records[id] {
+			continue
+		}
+		c.Revision++
+		created := c.Revision
+		if ok && previous.DeletedAt == nil {
+			created = previous.Created
+		}
+		tracked[id] = &trackedChange{Revision: c.Revision, Created: created, Hash: records[id], UpdatedAt: now}
+		changed = true
+	}
+	for _, id := range slices.Sorted(maps.Keys(tracked)) {
+		if previous := tracked[id]; previous.DeletedAt == nil {
+			if _, ok := records[id]; !ok {
+				c.Revision++
+				tracked[id] = &trackedChange{Revision: c.Revision, Created: previous.Created, UpdatedAt: now, DeletedAt: &now}
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// stamp sets when an expense was last written, and when it was recorded for a new one, unless
+// the write leaves it unchanged
+func (c *changesFileData) stamp(expense *Expense, hash string, now time.Time) {
+	tracked, ok := c.Expenses[expense.ID]
+	if ok && tracked.DeletedAt == nil && tracked.Hash == hash {
+		return
+	}
+	if !ok || tracked.DeletedAt != nil {
+		expense.CreatedAt = &now
+	}
+	expense.UpdatedAt = &now
+}
+
+// expenseHashes fingerprints the stored form of each expense by ID, without the times the store sets
+func expenseHashes(expenses []Expense) map[string]string {
+	hashes := make(map[string]string, len(expenses))
+	for _, expense := range expenses {
+		expense.CreatedAt, expense.UpdatedAt = nil, nil
+		content, _ := json.Marshal(expense)
+		hashes[expense.ID] = changeHash(content)
+	}
+	return hashes
+}
+
+// change returns the tracked change of an expense, without the expense
+func (t *trackedChange) change(id string) ExpenseChange {
+	return ExpenseChange{ID: id, Revision: t.Revision, CreatedRevision: t.Created, UpdatedAt: t.UpdatedAt, DeletedAt: t.DeletedAt}
+}
+
+// readArchiveFile reads the archive, which doesn't exist until something is archived
+func (s *jsonStore) readArchiveFile(path string) (*expensesFileData, error) {
+	content, err := s.files.readFile(path)
+	if os.IsNotExist(err) {
+		return &expensesFileData{Expenses: []Expense{}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	var data expensesFileData
+	if err := json.NewDecoder(reader).Decode(&data); err != nil {
+		return nil, err
+	}
+	log.Println("Read archive file")
+	return &data, nil
+}
+
+func (s *jsonStore) writeArchiveFile(path string, data *expensesFileData) error {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(writer).Encode(data); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	log.Println("Wrote archive file")
+	return s.files.writeFile(path, buf.Bytes())
+}
+
+// readLoginTokensFile reads the login tokens, which don't exist until someone requests a link
+func (s *jsonStore) readLoginTokensFile(path string) (*loginTokensFileData, error) {
+	content, err := s.files.readFile(path)
+	if os.IsNotExist(err) {
+		return &loginTokensFileData{Tokens: []LoginToken{}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var data loginTokensFileData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func (s *jsonStore) writeLoginTokensFile(path string, data *loginTokensFileData) error {
+	content, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		return err
+	}
+	return s.files.writeFile(path, content)
+}
+
+func (s *jsonStore) readConfigFile(path string) (*Config, error) {
+	content, err := s.files.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var data Config
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, err
+	}
+	log.Println("Read config file")
+	return &data, nil
+}
+
+func (s *jsonStore) writeConfigFile(path string, data *Config) error {
+	content, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		return err
+	}
+	log.Println("Wrote config file")
+	if err := s.files.writeFile(path, content); err != nil {
+		return err
+	}
+	hashes, err := configHashes(data)
+	if err != nil {
+		return err
+	}
+	if s.changes.track(s.changes.Config, hashes, time.Now()) {
+		return s.writeChangesFile(s.changesPath, s.changes)
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file in the same directory and renames it into place,
+// so a crash or restart mid-write leaves the previous contents intact instead of a truncated file
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := writeTempFile(path, content)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // no-op once renamed
+	return os.Rename(tmp, path)
+}
+
+// writeTempFile writes content to a synced temporary file next to path, to be renamed into place
+func writeTempFile(path string, content []byte) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// commit applies the files a mutation wrote together, or drops them when it failed; every
+// mutation defers it under the write lock. The aggregates and changes kept in memory are reread
+// when the files don't end up as the mutation left them.
+func (s *jsonStore) commit(err *error) {
+	batch, ok := s.files.(batchFiles)
+	if !ok {
+		return
+	}
+	if *err != nil {
+		batch.discard()
+		s.reload()
+		return
+	}
+	if *err = batch.commit(); *err != nil {
+		s.reload()
+	}
+}
+
+// reload rereads the aggregates and changes from the files
+func (s *jsonStore) reload() {
+	data, err := s.readExpensesFile(s.filePath)
+	if err != nil {
+		log.Printf("Failed to reread expenses file: %v\n", err)
+		return
+	}
+	s.aggregates = AggregateMonthly(data.Expenses)
+	changes, err := s.readChangesFile(s.changesPath)
+	if err != nil {
+		log.Printf("Failed to reread changes file: %v\n", err)
+		return
+	}
+	s.changes = changes
+}
+
+// ------------------------------------------------------------
+// JSONStore interface methods
+// ------------------------------------------------------------
+
+// Close waits for any in-flight write to finish, and closes the journal
+func (s *jsonStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if closer, ok := s.files.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (s *jsonStore) GetConfig() (*Config, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readConfigFile(s.configPath)
+}
+
+// Basic Config Updates
+
+func (s *jsonStore) GetCategories() ([]string, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.Categories, nil
+}
+
+func (s *jsonStore) UpdateCategories(categories []string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.SetCategories(categories)
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) RenameCategory(oldName string, newName string) error {
+	return s.reassignCategory(oldName, newName, false)
+}
+
+func (s *jsonStore) MergeCategories(source string, target string) error {
+	return s.reassignCategory(source, target, true)
+}
+
+func (s *jsonStore) reassignCategory(from string, to string, merge bool) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	config, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := config.ReassignCategory(from, to, merge); err != nil {
+		return err
+	}
+	data, err := s.readExpensesFile(s.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read storage file: %v", err)
+	}
+	for i := range data.Expenses {
+		if data.Expenses[i].Category == from {
+			data.Expenses[i].Category = to
+		}
+	}
+	if err := s.writeExpensesFile(s.filePath, data); err != nil {
+		return fmt.Errorf("failed to write storage file: %v", err)
+	}
+	return s.writeConfigFile(s.configPath, config)
+}
+
+func (s *jsonStore) GetArchivedCategories() ([]string, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Archived == nil {
+		return []string{}, nil
+	}
+	return config.Archived, nil
+}
+
+func (s *jsonStore) ArchiveCategory(category string, archived bool) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := data.SetArchived(category, archived); err != nil {
+		return err
+	}
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetCategoryMeta() (map[string]CategoryMeta, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.CategoryMeta == nil {
+		return map[string]CategoryMeta{}, nil
+	}
+	return config.CategoryMeta, nil
+}
+
+func (s *jsonStore) UpdateCategoryMeta(meta map[string]CategoryMeta) (err error) {
+	if err := ValidateCategoryMeta(meta); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.CategoryMeta = meta
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetCurrency() (string, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.Currency, nil
+}
+
+func (s *jsonStore) UpdateCurrency(currency string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if _, ok := LookupCurrency(currency, data.CustomCurrencies); !ok {
+		return fmt.Errorf("invalid currency: %s", currency)
+	}
+	data.Currency = currency
+	s.defaults["currency"] = currency
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetCustomCurrencies() ([]Currency, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.CustomCurrencies == nil {
+		return []Currency{}, nil
+	}
+	return config.CustomCurrencies, nil
+}
+
+func (s *jsonStore) UpdateCustomCurrencies(currencies []Currency) (err error) {
+	if err := ValidateCustomCurrencies(currencies); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if _, ok := LookupCurrency(data.Currency, currencies); !ok {
+		return fmt.Errorf("'%s' is the display currency and can't be removed", data.Currency)
+	}
+	data.CustomCurrencies = currencies
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetStartDate() (int, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return 0, err
+	}
+	return config.StartDate, nil
+}
+
+func (s *jsonStore) UpdateStartDate(startDate int) (err error) {
+	if startDate < 1 || startDate > 31 {
+		return fmt.Errorf("invalid start date: %d", startDate)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.StartDate = startDate
+	s.defaults["start_date"] = fmt.Sprintf("%d", startDate)
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetPeriod() (periods.Config, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return periods.Config{}, err
+	}
+	return config.PeriodConfig(), nil
+}
+
+func (s *jsonStore) UpdatePeriod(period periods.Config) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := data.SetPeriod(period); err != nil {
+		return err
+	}
+	s.defaults["start_date"] = fmt.Sprintf("%d", data.StartDate)
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetReportSettings() (ReportSettings, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return ReportSettings{}, err
+	}
+	if config.Reports.Recipients == nil {
+		config.Reports.Recipients = []string{}
+	}
+	return config.Reports, nil
+}
+
+func (s *jsonStore) UpdateReportSettings(settings ReportSettings) (err error) {
+	if err := ValidateReportSettings(&settings); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.Reports = settings
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetMerchantAliases() (map[string]string, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.MerchantAliases == nil {
+		return map[string]string{}, nil
+	}
+	return config.MerchantAliases, nil
+}
+
+func (s *jsonStore) UpdateMerchantAliases(aliases map[string]string) (err error) {
+	aliases, err = ValidateMerchantAliases(aliases)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.MerchantAliases = aliases
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetTravelRates() (TravelRates, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return TravelRates{}, err
+	}
+	if config.TravelRates.DistanceUnit == "" {
+		config.TravelRates.DistanceUnit = "km"
+	}
+	return config.TravelRates, nil
+}
+
+func (s *jsonStore) UpdateTravelRates(rates TravelRates) (err error) {
+	if err := ValidateTravelRates(&rates); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.TravelRates = rates
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetRuntimeSettings() (RuntimeSettings, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return RuntimeSettings{}, err
+	}
+	return config.RuntimeSettings(), nil
+}
+
+func (s *jsonStore) UpdateRuntimeSettings(settings RuntimeSettings) (err error) {
+	if err := ValidateRuntimeSettings(&settings); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.Settings = &settings
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetFiscalYearStart() (int, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return 0, err
+	}
+	if config.FiscalYearStart == 0 {
+		return 1, nil
+	}
+	return config.FiscalYearStart, nil
+}
+
+func (s *jsonStore) UpdateFiscalYearStart(month int) (err error) {
+	if month < 1 || month > 12 {
+		return fmt.Errorf("invalid fiscal year start month: %d", month)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.FiscalYearStart = month
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetRetentionYears() (int, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return 0, err
+	}
+	return config.RetentionYears, nil
+}
+
+func (s *jsonStore) UpdateRetentionYears(years int) (err error) {
+	if years < 0 || years > MaxRetentionYears {
+		return fmt.Errorf("invalid retention: %d years", years)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.RetentionYears = years
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetShares() ([]Share, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Shares == nil {
+		return []Share{}, nil
+	}
+	return config.Shares, nil
+}
+
+func (s *jsonStore) UpdateShares(shares []Share) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.Shares = shares
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetBudgets() (map[string]float64, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Budgets == nil {
+		return map[string]float64{}, nil
+	}
+	return config.Budgets, nil
+}
+
+func (s *jsonStore) UpdateBudgets(budgets map[string]float64) (err error) {
+	if err := ValidateBudgets(budgets); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.Budgets = budgets
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetPreferences(user string) (Preferences, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return Preferences{}, err
+	}
+	return config.Preferences[user], nil
+}
+
+func (s *jsonStore) UpdatePreferences(user string, preferences Preferences) (err error) {
+	if err := ValidatePreferences(preferences); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if data.Preferences == nil {
+		data.Preferences = map[string]Preferences{}
+	}
+	data.Preferences[user] = preferences
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetAccounts() ([]Account, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Accounts == nil {
+		return []Account{}, nil
+	}
+	return config.Accounts, nil
+}
+
+func (s *jsonStore) UpdateAccounts(accounts []Account) (err error) {
+	if err := ValidateAccounts(accounts); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.Accounts = accounts
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetHoldings() ([]Holding, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Holdings == nil {
+		return []Holding{}, nil
+	}
+	return config.Holdings, nil
+}
+
+func (s *jsonStore) UpdateHoldings(holdings []Holding) (err error) {
+	if err := ValidateHoldings(holdings); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.Holdings = holdings
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetPlannedExpenses() ([]PlannedExpense, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.PlannedExpenses == nil {
+		return []PlannedExpense{}, nil
+	}
+	return config.PlannedExpenses, nil
+}
+
+func (s *jsonStore) UpdatePlannedExpenses(planned []PlannedExpense) (err error) {
+	if err := ValidatePlannedExpenses(planned); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.PlannedExpenses = planned
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetSavedReports() ([]SavedReport, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.SavedReports == nil {
+		return []SavedReport{}, nil
+	}
+	return config.SavedReports, nil
+}
+
+func (s *jsonStore) UpdateSavedReports(reports []SavedReport) (err error) {
+	if err := ValidateSavedReports(reports); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.SavedReports = reports
+	return s.writeConfigFile(s.configPath, data)
+}
+
+func (s *jsonStore) GetPlaidItems() ([]PlaidItem, error) {
+	config, err := s.GetConfig()
 	if err != nil {
 		return nil, err
 	}
-	var data expensesFileData
-	if err := json.Unmarshal(content, &data); err != nil {
-		return nil, err
+	if config.PlaidItems == nil {
+		return []PlaidItem{}, nil
 	}
-	log.Println("Read expenses file")
-	return &data, nil
+	return config.PlaidItems, nil
 }
 
-func (s *jsonStore) writeExpensesFile(path string, data *expensesFileData) error {
-	content, err := json.MarshalIndent(data, "", "    ")
+func (s *jsonStore) UpdatePlaidItems(items []PlaidItem) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read config file: %v", err)
 	}
-	log.Println("Wrote expenses file")
-	return os.WriteFile(path, content, 0644)
+	data.PlaidItems = items
+	return s.writeConfigFile(s.configPath, data)
 }
 
-func (s *jsonStore) readConfigFile(path string) (*Config, error) {
-	content, err := os.ReadFile(path)
+func (s *jsonStore) GetProjects() ([]Project, error) {
+	config, err := s.GetConfig()
 	if err != nil {
 		return nil, err
 	}
-	var data Config
-	if err := json.Unmarshal(content, &data); err != nil {
-		return nil, err
+	if config.Projects == nil {
+		return []Project{}, nil
 	}
-	log.Println("Read config file")
-	return &data, nil
+	return config.Projects, nil
 }
 
-func (s *jsonStore) writeConfigFile(path string, data *Config) error {
-	content, err := json.MarshalIndent(data, "", "    ")
-	if err != nil {
+func (s *jsonStore) UpdateProjects(projects []Project) (err error) {
+	if err := ValidateProjects(projects); err != nil {
 		return err
 	}
-	log.Println("Wrote config file")
-	return os.WriteFile(path, content, 0644)
-}
-
-// ------------------------------------------------------------
-// JSONStore interface methods
-// ------------------------------------------------------------
-
-func (s *jsonStore) Close() error {
-	return nil
-}
-
-func (s *jsonStore) GetConfig() (*Config, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.readConfigFile(s.configPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	data.Projects = projects
+	return s.writeConfigFile(s.configPath, data)
 }
 
-// Basic Config Updates
-
-func (s *jsonStore) GetCategories() ([]string, error) {
+func (s *jsonStore) GetTRMNLProfiles() ([]TRMNLProfile, error) {
 	config, err := s.GetConfig()
 	if err != nil {
 		return nil, err
 	}
-	return config.Categories, nil
+	if config.TRMNLProfiles == nil {
+		return []TRMNLProfile{}, nil
+	}
+	return config.TRMNLProfiles, nil
 }
 
-func (s *jsonStore) UpdateCategories(categories []string) error {
+func (s *jsonStore) UpdateTRMNLProfiles(profiles []TRMNLProfile) (err error) {
+	if err := ValidateTRMNLProfiles(profiles); err != nil {
+		return err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	data, err := s.readConfigFile(s.configPath)
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %v", err)
 	}
-	data.Categories = categories
+	data.TRMNLProfiles = profiles
 	return s.writeConfigFile(s.configPath, data)
 }
 
-func (s *jsonStore) GetCurrency() (string, error) {
+func (s *jsonStore) GetVoiceKeys() ([]VoiceKey, error) {
 	config, err := s.GetConfig()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return config.Currency, nil
+	if config.VoiceKeys == nil {
+		return []VoiceKey{}, nil
+	}
+	return config.VoiceKeys, nil
 }
 
-func (s *jsonStore) UpdateCurrency(currency string) error {
-	if !slices.Contains(SupportedCurrencies, currency) {
-		return fmt.Errorf("invalid currency: %s", currency)
+func (s *jsonStore) UpdateVoiceKeys(keys []VoiceKey) (err error) {
+	if err := ValidateVoiceKeys(keys); err != nil {
+		return err
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	data, err := s.readConfigFile(s.configPath)
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %v", err)
 	}
-	data.Currency = currency
-	s.defaults["currency"] = currency
+	data.VoiceKeys = keys
 	return s.writeConfigFile(s.configPath, data)
 }
 
-func (s *jsonStore) GetStartDate() (int, error) {
+func (s *jsonStore) GetWalletDevices() ([]WalletDevice, error) {
 	config, err := s.GetConfig()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return config.StartDate, nil
+	if config.WalletDevices == nil {
+		return []WalletDevice{}, nil
+	}
+	return config.WalletDevices, nil
 }
 
-func (s *jsonStore) UpdateStartDate(startDate int) error {
-	if startDate < 1 || startDate > 31 {
-		return fmt.Errorf("invalid start date: %d", startDate)
+func (s *jsonStore) UpdateWalletDevices(devices []WalletDevice) (err error) {
+	if err := ValidateWalletDevices(devices); err != nil {
+		return err
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	data, err := s.readConfigFile(s.configPath)
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %v", err)
 	}
-	data.StartDate = startDate
-	s.defaults["start_date"] = fmt.Sprintf("%d", startDate)
+	data.WalletDevices = devices
 	return s.writeConfigFile(s.configPath, data)
 }
 
@@ -216,12 +1163,13 @@ func (s *jsonStore) GetRecurringExpense(id string) (RecurringExpense, error) {
 			return r, nil
 		}
 	}
-	return RecurringExpense{}, fmt.Errorf("recurring expense with ID %s not found", id)
+	return RecurringExpense{}, fmt.Errorf("recurring expense with ID %s %w", id, ErrNotFound)
 }
 
-func (s *jsonStore) AddRecurringExpense(recurringExpense RecurringExpense) error {
+func (s *jsonStore) AddRecurringExpense(recurringExpense RecurringExpense) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	config, err := s.readConfigFile(s.configPath)
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %v", err)
@@ -232,17 +1180,20 @@ func (s *jsonStore) AddRecurringExpense(recurringExpense RecurringExpense) error
 	if recurringExpense.Currency == "" {
 		recurringExpense.Currency = s.defaults["currency"]
 	}
+	now := time.Now()
+	recurringExpense.CreatedAt, recurringExpense.UpdatedAt = &now, &now
 	config.RecurringExpenses = append(config.RecurringExpenses, recurringExpense)
 	if err := s.writeConfigFile(s.configPath, config); err != nil {
 		return fmt.Errorf("failed to write config file: %v", err)
 	}
 	expensesToAdd := generateExpensesFromRecurring(recurringExpense, false)
-	return s.AddMultipleExpenses(expensesToAdd)
+	return s.addExpenses(expensesToAdd)
 }
 
-func (s *jsonStore) RemoveRecurringExpense(id string, removeAll bool) error {
+func (s *jsonStore) RemoveRecurringExpense(id string, removeAll bool) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	config, err := s.readConfigFile(s.configPath)
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %v", err)
@@ -257,7 +1208,7 @@ func (s *jsonStore) RemoveRecurringExpense(id string, removeAll bool) error {
 		}
 	}
 	if !found {
-		return fmt.Errorf("recurring expense with ID %s not found", id)
+		return fmt.Errorf("recurring expense with ID %s %w", id, ErrNotFound)
 	}
 	config.RecurringExpenses = updatedRecurringExpenses
 	expensesData, err := s.readExpensesFile(s.filePath)
@@ -271,7 +1222,9 @@ func (s *jsonStore) RemoveRecurringExpense(id string, removeAll bool) error {
 			updatedExpenses = append(updatedExpenses, exp)
 			continue
 		}
+		// instances that are kept are detached from the rule, as the database's foreign key does
 		if !removeAll && !exp.Date.After(today) {
+			exp.RecurringID = ""
 			updatedExpenses = append(updatedExpenses, exp)
 		}
 	}
@@ -282,9 +1235,10 @@ func (s *jsonStore) RemoveRecurringExpense(id string, removeAll bool) error {
 	return s.writeConfigFile(s.configPath, config)
 }
 
-func (s *jsonStore) UpdateRecurringExpense(id string, recurringExpense RecurringExpense, updateAll bool) error {
+func (s *jsonStore) UpdateRecurringExpense(id string, recurringExpense RecurringExpense, updateAll bool) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	config, err := s.readConfigFile(s.configPath)
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %v", err)
@@ -296,13 +1250,18 @@ func (s *jsonStore) UpdateRecurringExpense(id string, recurringExpense Recurring
 			if recurringExpense.Currency == "" {
 				recurringExpense.Currency = s.defaults["currency"]
 			}
+			if recurringExpense.Overrides == nil {
+				recurringExpense.Overrides = r.Overrides // a rule sent without overrides keeps the ones it has
+			}
+			now := time.Now()
+			recurringExpense.CreatedAt, recurringExpense.UpdatedAt = r.CreatedAt, &now
 			config.RecurringExpenses[i] = recurringExpense
 			found = true
 			break
 		}
 	}
 	if !found {
-		return fmt.Errorf("recurring expense with ID %s not found", id)
+		return fmt.Errorf("recurring expense with ID %s %w", id, ErrNotFound)
 	}
 	expensesData, err := s.readExpensesFile(s.filePath)
 	if err != nil {
@@ -311,8 +1270,8 @@ func (s *jsonStore) UpdateRecurringExpense(id string, recurringExpense Recurring
 	var remainingExpenses []Expense
 	today := time.Now()
 	for _, exp := range expensesData.Expenses {
-		if exp.RecurringID != id {
-			remainingExpenses = append(remainingExpenses, exp)
+		if exp.RecurringID != id || exp.Edited {
+			remainingExpenses = append(remainingExpenses, exp) // instances edited by hand are kept, along with their occurrence
 			continue
 		}
 		if !updateAll && !exp.Date.After(today) {
@@ -320,7 +1279,7 @@ func (s *jsonStore) UpdateRecurringExpense(id string, recurringExpense Recurring
 		}
 	}
 	expensesData.Expenses = remainingExpenses
-	expensesToAdd := generateExpensesFromRecurring(recurringExpense, !updateAll)
+	expensesToAdd := withoutEditedOccurrences(recurringExpense, generateExpensesFromRecurring(recurringExpense, !updateAll), remainingExpenses)
 	expensesData.Expenses = append(expensesData.Expenses, expensesToAdd...)
 	if err := s.writeExpensesFile(s.filePath, expensesData); err != nil {
 		return err
@@ -340,6 +1299,33 @@ func (s *jsonStore) GetAllExpenses() ([]Expense, error) {
 	return data.Expenses, nil
 }
 
+func (s *jsonStore) FindExpenses(filter ExpenseFilter) ([]Expense, error) {
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+	expenses, err := s.GetAllExpenses()
+	if err != nil {
+		return nil, err
+	}
+	categories := filterCategories(config)
+	matches := []Expense{}
+	for _, expense := range expenses {
+		if filter.Matches(expense, categories) {
+			matches = append(matches, expense)
+		}
+	}
+	return matches, nil
+}
+
+func (s *jsonStore) SuggestExpenseNames(prefix string, limit int) ([]NameSuggestion, error) {
+	expenses, err := s.GetAllExpenses()
+	if err != nil {
+		return nil, err
+	}
+	return suggestNames(expenses, prefix, limit), nil
+}
+
 func (s *jsonStore) GetExpense(id string) (Expense, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -353,7 +1339,83 @@ func (s *jsonStore) GetExpense(id string) (Expense, error) {
 			return data.Expenses[i], nil
 		}
 	}
-	return Expense{}, fmt.Errorf("expense with ID %s not found", id)
+	return Expense{}, fmt.Errorf("expense with ID %s %w", id, ErrNotFound)
+}
+
+func (s *jsonStore) GetMonthlyAggregates() ([]MonthlyAggregate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.aggregates), nil
+}
+
+func (s *jsonStore) GetExpenseChanges(since int64, limit int) ([]ExpenseChange, int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, err := s.readExpensesFile(s.filePath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read storage file: %v", err)
+	}
+	changes := []ExpenseChange{}
+	for id, tracked := range s.changes.Expenses {
+		if tracked.Revision > since {
+			changes = append(changes, tracked.change(id))
+		}
+	}
+	slices.SortFunc(changes, func(a, b ExpenseChange) int {
+		return cmp.Compare(a.Revision, b.Revision)
+	})
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	index := make(map[string]int, len(changes))
+	for i, change := range changes {
+		index[change.ID] = i
+	}
+	for _, expense := range data.Expenses {
+		if i, ok := index[expense.ID]; ok && changes[i].DeletedAt == nil {
+			changes[i].Expense = &expense
+		}
+	}
+	return changes, s.changes.Revision, nil
+}
+
+func (s *jsonStore) GetExpenseChange(id string) (ExpenseChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tracked, ok := s.changes.Expenses[id]
+	if !ok {
+		return ExpenseChange{ID: id}, nil
+	}
+	change := tracked.change(id)
+	if change.DeletedAt != nil {
+		return change, nil
+	}
+	data, err := s.readExpensesFile(s.filePath)
+	if err != nil {
+		return ExpenseChange{}, fmt.Errorf("failed to read storage file: %v", err)
+	}
+	for _, expense := range data.Expenses {
+		if expense.ID == id {
+			change.Expense = &expense
+			break
+		}
+	}
+	return change, nil
+}
+
+func (s *jsonStore) GetConfigChanges(since int64) ([]ConfigChange, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	changes := []ConfigChange{}
+	for key, tracked := range s.changes.Config {
+		if tracked.Revision > since {
+			changes = append(changes, ConfigChange{Key: key, Revision: tracked.Revision, CreatedRevision: tracked.Created, UpdatedAt: tracked.UpdatedAt, DeletedAt: tracked.DeletedAt})
+		}
+	}
+	slices.SortFunc(changes, func(a, b ConfigChange) int {
+		return cmp.Compare(a.Revision, b.Revision)
+	})
+	return changes, nil
 }
 
 func (s *jsonStore) FindDuplicateExpense(name string, category string, amount float64, date time.Time) (bool, error) {
@@ -386,15 +1448,18 @@ func (s *jsonStore) FindDuplicateExpense(name string, category string, amount fl
 	return false, nil
 }
 
-func (s *jsonStore) AddExpense(expense Expense) error {
+func (s *jsonStore) AddExpense(expense Expense) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	data, err := s.readExpensesFile(s.filePath)
 	if err != nil {
 		return fmt.Errorf("failed to read storage file: %v", err)
 	}
 	if expense.ID == "" {
 		expense.ID = uuid.New().String()
+	} else if slices.ContainsFunc(data.Expenses, func(e Expense) bool { return e.ID == expense.ID }) {
+		return fmt.Errorf("expense with ID %s %w", expense.ID, ErrAlreadyExists)
 	}
 	if expense.Currency == "" {
 		expense.Currency = s.defaults["currency"]
@@ -407,9 +1472,10 @@ func (s *jsonStore) AddExpense(expense Expense) error {
 	return s.writeExpensesFile(s.filePath, data)
 }
 
-func (s *jsonStore) RemoveExpense(id string) error {
+func (s *jsonStore) RemoveExpense(id string) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	data, err := s.readExpensesFile(s.filePath)
 	if err != nil {
 		return fmt.Errorf("failed to read storage file: %v", err)
@@ -425,14 +1491,22 @@ func (s *jsonStore) RemoveExpense(id string) error {
 	}
 	if !found {
 		log.Printf("Expense with ID %s not found\n", id)
-		return fmt.Errorf("expense with ID %s not found", id)
+		return fmt.Errorf("expense with ID %s %w", id, ErrNotFound)
 	}
 	log.Printf("Deleted expense with ID %s\n", id)
 	data.Expenses = newExpenses
 	return s.writeExpensesFile(s.filePath, data)
 }
 
-func (s *jsonStore) AddMultipleExpenses(expensesToAdd []Expense) error {
+func (s *jsonStore) AddMultipleExpenses(expensesToAdd []Expense) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	return s.addExpenses(expensesToAdd)
+}
+
+// addExpenses appends to the expenses file; the caller holds the lock
+func (s *jsonStore) addExpenses(expensesToAdd []Expense) error {
 	if len(expensesToAdd) == 0 {
 		return nil
 	}
@@ -445,9 +1519,10 @@ func (s *jsonStore) AddMultipleExpenses(expensesToAdd []Expense) error {
 	return s.writeExpensesFile(s.filePath, data)
 }
 
-func (s *jsonStore) RemoveMultipleExpenses(ids []string) error {
+func (s *jsonStore) RemoveMultipleExpenses(ids []string) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	if len(ids) == 0 {
 		return nil
 	}
@@ -475,9 +1550,38 @@ func (s *jsonStore) RemoveMultipleExpenses(ids []string) error {
 	return s.writeExpensesFile(s.filePath, data)
 }
 
-func (s *jsonStore) UpdateExpense(id string, expense Expense) error {
+func (s *jsonStore) MoveExpenses(ids []string, category string, subCategory string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	if len(ids) == 0 {
+		return nil
+	}
+	data, err := s.readExpensesFile(s.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read storage file: %v", err)
+	}
+	idsToMove := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		idsToMove[id] = struct{}{}
+	}
+	moved := 0
+	for i := range data.Expenses {
+		if _, found := idsToMove[data.Expenses[i].ID]; found {
+			data.Expenses[i].Category = category
+			data.Expenses[i].SubCategory = subCategory
+			data.Expenses[i].Edited = data.Expenses[i].Edited || data.Expenses[i].RecurringID != ""
+			moved++
+		}
+	}
+	log.Printf("Moved %d expenses to %s/%s\n", moved, category, subCategory)
+	return s.writeExpensesFile(s.filePath, data)
+}
+
+func (s *jsonStore) UpdateExpense(id string, expense Expense) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	data, err := s.readExpensesFile(s.filePath)
 	if err != nil {
 		return fmt.Errorf("failed to read storage file: %v", err)
@@ -485,6 +1589,13 @@ func (s *jsonStore) UpdateExpense(id string, expense Expense) error {
 	found := false
 	for i, exp := range data.Expenses {
 		if exp.ID == id {
+			if expense.UpdatedAt != nil && exp.UpdatedAt != nil && !expense.UpdatedAt.Equal(*exp.UpdatedAt) {
+				return ErrExpenseChanged
+			}
+			if expense.Metadata == nil {
+				expense.Metadata = exp.Metadata
+			}
+			expense.CreatedAt, expense.UpdatedAt = exp.CreatedAt, exp.UpdatedAt // the store sets them on write
 			data.Expenses[i] = expense
 			data.Expenses[i].ID = id
 			if data.Expenses[i].Currency == "" {
@@ -496,12 +1607,76 @@ func (s *jsonStore) UpdateExpense(id string, expense Expense) error {
 	}
 	if !found {
 		log.Printf("expense with ID %s not found\n", id)
-		return fmt.Errorf("expense with ID %s not found", id)
+		return fmt.Errorf("expense with ID %s %w", id, ErrNotFound)
 	}
 	log.Printf("Edited expense with ID %s\n", id)
 	return s.writeExpensesFile(s.filePath, data)
 }
 
+// Archive
+
+// ArchiveExpenses moves expenses dated before the cutoff to the archive file; the archive is
+// written first, so an interrupted run leaves expenses in both files rather than in neither
+func (s *jsonStore) ArchiveExpenses(before time.Time) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readExpensesFile(s.filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read storage file: %v", err)
+	}
+	var keep, archived []Expense
+	for _, exp := range data.Expenses {
+		if exp.Date.Before(before) {
+			archived = append(archived, exp)
+		} else {
+			keep = append(keep, exp)
+		}
+	}
+	if len(archived) == 0 {
+		return 0, nil
+	}
+	archive, err := s.readArchiveFile(s.archivePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive file: %v", err)
+	}
+	archive.Expenses = append(archive.Expenses, archived...)
+	if err := s.writeArchiveFile(s.archivePath, archive); err != nil {
+		return 0, fmt.Errorf("failed to write archive file: %v", err)
+	}
+	data.Expenses = keep
+	if data.Expenses == nil {
+		data.Expenses = []Expense{}
+	}
+	log.Printf("Archived %d expenses dated before %s\n", len(archived), before.Format("2006-01-02"))
+	return len(archived), s.writeExpensesFile(s.filePath, data)
+}
+
+func (s *jsonStore) GetArchivedExpenses() ([]Expense, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	archive, err := s.readArchiveFile(s.archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive file: %v", err)
+	}
+	return archive.Expenses, nil
+}
+
+// Maintenance
+
+// Vacuum rewrites the expenses file, which rebuilds the monthly aggregates from it; there is no
+// space to reclaim, since every write replaces the whole file
+func (s *jsonStore) Vacuum() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readExpensesFile(s.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read storage file: %v", err)
+	}
+	return s.writeExpensesFile(s.filePath, data)
+}
+
 // SubCategory Management
 
 func (s *jsonStore) GetSubCategories(category string) ([]string, error) {
@@ -519,9 +1694,10 @@ func (s *jsonStore) GetSubCategories(category string) ([]string, error) {
 	return subCategories, nil
 }
 
-func (s *jsonStore) AddSubCategory(category string, subCategory string) error {
+func (s *jsonStore) AddSubCategory(category string, subCategory string) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	config, err := s.readConfigFile(s.configPath)
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %v", err)
@@ -539,9 +1715,10 @@ func (s *jsonStore) AddSubCategory(category string, subCategory string) error {
 	return s.writeConfigFile(s.configPath, config)
 }
 
-func (s *jsonStore) RemoveSubCategory(category string, subCategory string) error {
+func (s *jsonStore) RemoveSubCategory(category string, subCategory string) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	config, err := s.readConfigFile(s.configPath)
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %v", err)
@@ -569,9 +1746,10 @@ func (s *jsonStore) RemoveSubCategory(category string, subCategory string) error
 	return s.writeConfigFile(s.configPath, config)
 }
 
-func (s *jsonStore) RenameSubCategory(category string, oldName string, newName string) error {
+func (s *jsonStore) RenameSubCategory(category string, oldName string, newName string) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	config, err := s.readConfigFile(s.configPath)
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %v", err)
@@ -597,6 +1775,32 @@ func (s *jsonStore) RenameSubCategory(category string, oldName string, newName s
 	return s.writeConfigFile(s.configPath, config)
 }
 
+func (s *jsonStore) MergeSubCategory(category string, source string, target string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	config, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := config.MergeSubCategoryConfig(category, source, target); err != nil {
+		return err
+	}
+	data, err := s.readExpensesFile(s.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read storage file: %v", err)
+	}
+	for i := range data.Expenses {
+		if data.Expenses[i].Category == category && data.Expenses[i].SubCategory == source {
+			data.Expenses[i].SubCategory = target
+		}
+	}
+	if err := s.writeExpensesFile(s.filePath, data); err != nil {
+		return fmt.Errorf("failed to write storage file: %v", err)
+	}
+	return s.writeConfigFile(s.configPath, config)
+}
+
 // SubCategory Mapping
 
 func (s *jsonStore) GetSubCategoryMappings() ([]SubCategoryMappingRule, error) {
@@ -610,9 +1814,10 @@ func (s *jsonStore) GetSubCategoryMappings() ([]SubCategoryMappingRule, error) {
 	return config.SubCategoryMap, nil
 }
 
-func (s *jsonStore) UpdateSubCategoryMappings(rules []SubCategoryMappingRule) error {
+func (s *jsonStore) UpdateSubCategoryMappings(rules []SubCategoryMappingRule) (err error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	defer s.commit(&err)
 	config, err := s.readConfigFile(s.configPath)
 	if err != nil {
 		return fmt.Errorf("failed to read config file: %v", err)
@@ -620,3 +1825,138 @@ func (s *jsonStore) UpdateSubCategoryMappings(rules []SubCategoryMappingRule) er
 	config.SubCategoryMap = rules
 	return s.writeConfigFile(s.configPath, config)
 }
+
+// rewriteExpenseText rewrites the name, notes, and tags of every expense, archived expense, and recurring expense
+func (s *jsonStore) rewriteExpenseText(rewrite func(name *string, notes *string, tags []string) error) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readExpensesFile(s.filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read storage file: %v", err)
+	}
+	for i := range data.Expenses {
+		if err := rewrite(&data.Expenses[i].Name, &data.Expenses[i].Notes, data.Expenses[i].Tags); err != nil {
+			return err
+		}
+	}
+	archive, err := s.readArchiveFile(s.archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to read archive file: %v", err)
+	}
+	for i := range archive.Expenses {
+		if err := rewrite(&archive.Expenses[i].Name, &archive.Expenses[i].Notes, archive.Expenses[i].Tags); err != nil {
+			return err
+		}
+	}
+	config, err := s.readConfigFile(s.configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	for i := range config.RecurringExpenses {
+		if err := rewrite(&config.RecurringExpenses[i].Name, nil, config.RecurringExpenses[i].Tags); err != nil {
+			return err
+		}
+	}
+	if err := s.writeExpensesFile(s.filePath, data); err != nil {
+		return err
+	}
+	if len(archive.Expenses) > 0 {
+		if err := s.writeArchiveFile(s.archivePath, archive); err != nil {
+			return err
+		}
+	}
+	return s.writeConfigFile(s.configPath, config)
+}
+
+// Login Tokens
+
+func (s *jsonStore) GetLoginToken(hash string) (LoginToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, err := s.readLoginTokensFile(s.tokensPath)
+	if err != nil {
+		return LoginToken{}, fmt.Errorf("failed to read login tokens: %v", err)
+	}
+	for _, token := range data.Tokens {
+		if token.Hash == hash && time.Now().Before(token.ExpiresAt) {
+			return token, nil
+		}
+	}
+	return LoginToken{}, fmt.Errorf("login token not found")
+}
+
+// GetLoginTokens returns the active tokens of a user, or of everyone when user is empty
+func (s *jsonStore) GetLoginTokens(user string) ([]LoginToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, err := s.readLoginTokensFile(s.tokensPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read login tokens: %v", err)
+	}
+	now := time.Now()
+	tokens := []LoginToken{}
+	for _, token := range data.Tokens {
+		if now.Before(token.ExpiresAt) && (user == "" || token.User == user) {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, nil
+}
+
+// AddLoginToken stores a token, dropping the ones that have expired
+func (s *jsonStore) AddLoginToken(token LoginToken) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readLoginTokensFile(s.tokensPath)
+	if err != nil {
+		return fmt.Errorf("failed to read login tokens: %v", err)
+	}
+	now := time.Now()
+	data.Tokens = slices.DeleteFunc(data.Tokens, func(t LoginToken) bool {
+		return !now.Before(t.ExpiresAt)
+	})
+	data.Tokens = append(data.Tokens, token)
+	return s.writeLoginTokensFile(s.tokensPath, data)
+}
+
+// UpdateLoginToken saves the role, device, address, and last use of a token
+func (s *jsonStore) UpdateLoginToken(token LoginToken) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readLoginTokensFile(s.tokensPath)
+	if err != nil {
+		return fmt.Errorf("failed to read login tokens: %v", err)
+	}
+	i := slices.IndexFunc(data.Tokens, func(t LoginToken) bool {
+		return t.Hash == token.Hash
+	})
+	if i < 0 {
+		return fmt.Errorf("login token not found")
+	}
+	data.Tokens[i].Role = token.Role
+	data.Tokens[i].Device = token.Device
+	data.Tokens[i].IP = token.IP
+	data.Tokens[i].LastUsedAt = token.LastUsedAt
+	return s.writeLoginTokensFile(s.tokensPath, data)
+}
+
+func (s *jsonStore) RemoveLoginToken(hash string) (removed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.commit(&err)
+	data, err := s.readLoginTokensFile(s.tokensPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read login tokens: %v", err)
+	}
+	i := slices.IndexFunc(data.Tokens, func(t LoginToken) bool {
+		return t.Hash == hash
+	})
+	if i < 0 {
+		return false, nil
+	}
+	data.Tokens = slices.Delete(data.Tokens, i, i+1)
+	return true, s.writeLoginTokensFile(s.tokensPath, data)
+}
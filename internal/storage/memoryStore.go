@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"sync"
+)
+
+// A memory store is the JSON store with its files kept in memory instead of the data directory, so
+// it behaves exactly like the default backend while nothing outlives the process. It backs the demo
+// mode (STORAGE_TYPE=memory), the API tests, and programs that embed ExpenseOwl.
+
+// memoryFiles keeps the JSON store's files in a map
+type memoryFiles struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (m *memoryFiles) readFile(path string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content, ok := m.files[path]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrNotExist}
+	}
+	return content, nil
+}
+
+func (m *memoryFiles) writeFile(path string, content []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[path] = bytes.Clone(content)
+	return nil
+}
+
+// NewMemoryStore returns an empty store with the default config that keeps everything in memory
+func NewMemoryStore() (Storage, error) {
+	store, err := newJSONStore(&memoryFiles{files: map[string][]byte{}}, "memory")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create memory store: %v", err)
+	}
+	return store, nil
+}
@@ -0,0 +1,477 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/secrets"
+)
+
+// sealedStore encrypts the credentials kept in the config (share and Plaid access tokens) before they
+// reach the underlying store, and decrypts them on the way back. With ENCRYPT_EXPENSES, it does
+// the same for the names, notes, and tags of expenses and recurring expenses.
+type sealedStore struct {
+	Storage
+	box      *secrets.Box
+	expenses bool // encrypt expense text
+}
+
+// expenseTextRewriter is implemented by stores that can rewrite the text of all expenses at once;
+// notes is nil for recurring expenses, and tags are changed in place
+type expenseTextRewriter interface {
+	rewriteExpenseText(rewrite func(name *string, notes *string, tags []string) error) error
+}
+
+// withSecrets wraps a store with encryption when a secrets key is configured, re-encrypting
+// values that are plain or sealed with a previous key
+func withSecrets(store Storage) (Storage, error) {
+	encryptExpenses, _ := strconv.ParseBool(os.Getenv("ENCRYPT_EXPENSES"))
+	box, err := secrets.FromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secrets key: %v", err)
+	}
+	if box == nil {
+		if encryptExpenses {
+			return nil, fmt.Errorf("ENCRYPT_EXPENSES needs a key in SECRETS_KEY or SECRETS_KEY_FILE")
+		}
+		return store, nil
+	}
+	sealed := &sealedStore{Storage: store, box: box, expenses: encryptExpenses}
+	if err := sealed.reseal(); err != nil {
+		return nil, fmt.Errorf("failed to re-encrypt secrets: %v", err)
+	}
+	if err := sealed.resealExpenses(); err != nil {
+		return nil, fmt.Errorf("failed to re-encrypt expenses: %v", err)
+	}
+	log.Println("Encrypting stored secrets")
+	if encryptExpenses {
+		log.Println("Encrypting expense names, notes, and tags")
+	}
+	return sealed, nil
+}
+
+func (s *sealedStore) reseal() error {
+	shares, err := s.Storage.GetShares()
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(shares, func(share Share) bool { return s.box.NeedsReseal(share.Token) }) {
+		opened, err := s.openShares(shares)
+		if err != nil {
+			return err
+		}
+		log.Println("Re-encrypting stored secrets with the current key")
+		if err := s.UpdateShares(opened); err != nil {
+			return err
+		}
+	}
+	items, err := s.Storage.GetPlaidItems()
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(items, func(item PlaidItem) bool { return s.box.NeedsReseal(item.AccessToken) }) {
+		opened, err := s.openPlaidItems(items)
+		if err != nil {
+			return err
+		}
+		log.Println("Re-encrypting Plaid access tokens with the current key")
+		return s.UpdatePlaidItems(opened)
+	}
+	return nil
+}
+
+// resealExpenses brings the stored expense text in line with the setting: encrypted with the
+// current key when expense encryption is on, and decrypted back to plain text when it's off
+func (s *sealedStore) resealExpenses() error {
+	rewriter, ok := s.Storage.(expenseTextRewriter)
+	if !ok {
+		return nil
+	}
+	expenses, err := s.Storage.GetAllExpenses()
+	if err != nil {
+		return err
+	}
+	archived, err := s.Storage.GetArchivedExpenses()
+	if err != nil {
+		return err
+	}
+	expenses = append(expenses, archived...)
+	recurring, err := s.Storage.GetRecurringExpenses()
+	if err != nil {
+		return err
+	}
+	stale := func(values ...string) bool { return slices.ContainsFunc(values, s.needsRewrite) }
+	if !slices.ContainsFunc(expenses, func(e Expense) bool { return stale(append([]string{e.Name, e.Notes}, e.Tags...)...) }) &&
+		!slices.ContainsFunc(recurring, func(r RecurringExpense) bool { return stale(append([]string{r.Name}, r.Tags...)...) }) {
+		return nil
+	}
+	if s.expenses {
+		log.Println("Encrypting stored expenses with the current key")
+	} else {
+		log.Println("Decrypting stored expenses, ENCRYPT_EXPENSES is off")
+	}
+	return rewriter.rewriteExpenseText(func(name *string, notes *string, tags []string) error {
+		return rewriteText(name, notes, tags, func(value string) (string, error) {
+			opened, err := s.box.Open(value)
+			if err != nil {
+				return "", err
+			}
+			return s.sealText(opened)
+		})
+	})
+}
+
+// needsRewrite reports whether a stored expense value doesn't match the expense encryption setting
+func (s *sealedStore) needsRewrite(value string) bool {
+	if s.expenses {
+		return s.box.NeedsReseal(value)
+	}
+	return secrets.IsSealed(value)
+}
+
+// sealText encrypts expense text when expense encryption is on
+func (s *sealedStore) sealText(value string) (string, error) {
+	if !s.expenses {
+		return value, nil
+	}
+	sealed, err := s.box.Seal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt expense: %v", err)
+	}
+	return sealed, nil
+}
+
+func (s *sealedStore) openText(value string) (string, error) {
+	opened, err := s.box.Open(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt expense: %v", err)
+	}
+	return opened, nil
+}
+
+// rewriteText applies fn to a name, optional notes, and tags (in place)
+func rewriteText(name *string, notes *string, tags []string, fn func(string) (string, error)) error {
+	var err error
+	if *name, err = fn(*name); err != nil {
+		return err
+	}
+	if notes != nil {
+		if *notes, err = fn(*notes); err != nil {
+			return err
+		}
+	}
+	for i := range tags {
+		if tags[i], err = fn(tags[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sealedStore) sealExpense(expense Expense) (Expense, error) {
+	expense.Tags = slices.Clone(expense.Tags)
+	err := rewriteText(&expense.Name, &expense.Notes, expense.Tags, s.sealText)
+	return expense, err
+}
+
+func (s *sealedStore) openExpense(expense Expense) (Expense, error) {
+	expense.Tags = slices.Clone(expense.Tags)
+	err := rewriteText(&expense.Name, &expense.Notes, expense.Tags, s.openText)
+	return expense, err
+}
+
+func (s *sealedStore) openChange(change ExpenseChange) (ExpenseChange, error) {
+	if change.Expense == nil {
+		return change, nil
+	}
+	expense, err := s.openExpense(*change.Expense)
+	change.Expense = &expense
+	return change, err
+}
+
+func (s *sealedStore) sealRecurring(recurring RecurringExpense) (RecurringExpense, error) {
+	recurring.Tags = slices.Clone(recurring.Tags)
+	err := rewriteText(&recurring.Name, nil, recurring.Tags, s.sealText)
+	return recurring, err
+}
+
+func (s *sealedStore) openRecurring(recurring RecurringExpense) (RecurringExpense, error) {
+	recurring.Tags = slices.Clone(recurring.Tags)
+	err := rewriteText(&recurring.Name, nil, recurring.Tags, s.openText)
+	return recurring, err
+}
+
+func (s *sealedStore) openRecurringList(recurring []RecurringExpense) ([]RecurringExpense, error) {
+	for i := range recurring {
+		opened, err := s.openRecurring(recurring[i])
+		if err != nil {
+			return nil, err
+		}
+		recurring[i] = opened
+	}
+	return recurring, nil
+}
+
+func (s *sealedStore) openShares(shares []Share) ([]Share, error) {
+	opened := make([]Share, len(shares))
+	for i, share := range shares {
+		token, err := s.box.Open(share.Token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt share token: %v", err)
+		}
+		share.Token = token
+		opened[i] = share
+	}
+	return opened, nil
+}
+
+func (s *sealedStore) openPlaidItems(items []PlaidItem) ([]PlaidItem, error) {
+	opened := make([]PlaidItem, len(items))
+	for i, item := range items {
+		token, err := s.box.Open(item.AccessToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt plaid access token: %v", err)
+		}
+		item.AccessToken = token
+		opened[i] = item
+	}
+	return opened, nil
+}
+
+func (s *sealedStore) GetConfig() (*Config, error) {
+	config, err := s.Storage.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Shares, err = s.openShares(config.Shares); err != nil {
+		return nil, err
+	}
+	if config.PlaidItems, err = s.openPlaidItems(config.PlaidItems); err != nil {
+		return nil, err
+	}
+	if config.RecurringExpenses, err = s.openRecurringList(config.RecurringExpenses); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (s *sealedStore) GetShares() ([]Share, error) {
+	shares, err := s.Storage.GetShares()
+	if err != nil {
+		return nil, err
+	}
+	return s.openShares(shares)
+}
+
+func (s *sealedStore) UpdateShares(shares []Share) error {
+	sealed := make([]Share, len(shares))
+	for i, share := range shares {
+		token, err := s.box.Seal(share.Token)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt share token: %v", err)
+		}
+		share.Token = token
+		sealed[i] = share
+	}
+	return s.Storage.UpdateShares(sealed)
+}
+
+func (s *sealedStore) GetPlaidItems() ([]PlaidItem, error) {
+	items, err := s.Storage.GetPlaidItems()
+	if err != nil {
+		return nil, err
+	}
+	return s.openPlaidItems(items)
+}
+
+func (s *sealedStore) UpdatePlaidItems(items []PlaidItem) error {
+	sealed := make([]PlaidItem, len(items))
+	for i, item := range items {
+		token, err := s.box.Seal(item.AccessToken)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt plaid access token: %v", err)
+		}
+		item.AccessToken = token
+		sealed[i] = item
+	}
+	return s.Storage.UpdatePlaidItems(sealed)
+}
+
+func (s *sealedStore) GetRecurringExpenses() ([]RecurringExpense, error) {
+	recurring, err := s.Storage.GetRecurringExpenses()
+	if err != nil {
+		return nil, err
+	}
+	return s.openRecurringList(recurring)
+}
+
+func (s *sealedStore) GetRecurringExpense(id string) (RecurringExpense, error) {
+	recurring, err := s.Storage.GetRecurringExpense(id)
+	if err != nil {
+		return RecurringExpense{}, err
+	}
+	return s.openRecurring(recurring)
+}
+
+// AddRecurringExpense seals the rule, so the expenses generated from it are sealed too
+func (s *sealedStore) AddRecurringExpense(recurringExpense RecurringExpense) error {
+	sealed, err := s.sealRecurring(recurringExpense)
+	if err != nil {
+		return err
+	}
+	return s.Storage.AddRecurringExpense(sealed)
+}
+
+func (s *sealedStore) UpdateRecurringExpense(id string, recurringExpense RecurringExpense, updateAll bool) error {
+	sealed, err := s.sealRecurring(recurringExpense)
+	if err != nil {
+		return err
+	}
+	return s.Storage.UpdateRecurringExpense(id, sealed, updateAll)
+}
+
+func (s *sealedStore) GetAllExpenses() ([]Expense, error) {
+	expenses, err := s.Storage.GetAllExpenses()
+	if err != nil {
+		return nil, err
+	}
+	for i := range expenses {
+		if expenses[i], err = s.openExpense(expenses[i]); err != nil {
+			return nil, err
+		}
+	}
+	return expenses, nil
+}
+
+func (s *sealedStore) GetArchivedExpenses() ([]Expense, error) {
+	expenses, err := s.Storage.GetArchivedExpenses()
+	if err != nil {
+		return nil, err
+	}
+	for i := range expenses {
+		if expenses[i], err = s.openExpense(expenses[i]); err != nil {
+			return nil, err
+		}
+	}
+	return expenses, nil
+}
+
+func (s *sealedStore) GetExpense(id string) (Expense, error) {
+	expense, err := s.Storage.GetExpense(id)
+	if err != nil {
+		return Expense{}, err
+	}
+	return s.openExpense(expense)
+}
+
+func (s *sealedStore) GetExpenseChanges(since int64, limit int) ([]ExpenseChange, int64, error) {
+	changes, latest, err := s.Storage.GetExpenseChanges(since, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range changes {
+		if changes[i], err = s.openChange(changes[i]); err != nil {
+			return nil, 0, err
+		}
+	}
+	return changes, latest, nil
+}
+
+func (s *sealedStore) GetExpenseChange(id string) (ExpenseChange, error) {
+	change, err := s.Storage.GetExpenseChange(id)
+	if err != nil {
+		return ExpenseChange{}, err
+	}
+	return s.openChange(change)
+}
+
+// FindExpenses filters decrypted expenses in memory, since encrypted tags never match
+func (s *sealedStore) FindExpenses(filter ExpenseFilter) ([]Expense, error) {
+	if !s.expenses {
+		return s.Storage.FindExpenses(filter)
+	}
+	config, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	expenses, err := s.GetAllExpenses()
+	if err != nil {
+		return nil, err
+	}
+	categories := filterCategories(config)
+	matches := []Expense{}
+	for _, expense := range expenses {
+		if filter.Matches(expense, categories) {
+			matches = append(matches, expense)
+		}
+	}
+	return matches, nil
+}
+
+// SuggestExpenseNames matches decrypted names, since encrypted ones never match
+func (s *sealedStore) SuggestExpenseNames(prefix string, limit int) ([]NameSuggestion, error) {
+	if !s.expenses {
+		return s.Storage.SuggestExpenseNames(prefix, limit)
+	}
+	expenses, err := s.GetAllExpenses()
+	if err != nil {
+		return nil, err
+	}
+	return suggestNames(expenses, prefix, limit), nil
+}
+
+// FindDuplicateExpense compares decrypted names, since encrypted ones never match
+func (s *sealedStore) FindDuplicateExpense(name string, category string, amount float64, date time.Time) (bool, error) {
+	if !s.expenses {
+		return s.Storage.FindDuplicateExpense(name, category, amount, date)
+	}
+	expenses, err := s.GetAllExpenses()
+	if err != nil {
+		return false, err
+	}
+	targetDate := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	normalizedName := strings.ToLower(strings.TrimSpace(name))
+	normalizedCategory := strings.ToLower(strings.TrimSpace(category))
+	for _, exp := range expenses {
+		expDate := time.Date(exp.Date.Year(), exp.Date.Month(), exp.Date.Day(), 0, 0, 0, 0, time.UTC)
+		if strings.ToLower(strings.TrimSpace(exp.Name)) == normalizedName &&
+			strings.ToLower(strings.TrimSpace(exp.Category)) == normalizedCategory &&
+			exp.Amount == amount &&
+			expDate.Equal(targetDate) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *sealedStore) AddExpense(expense Expense) error {
+	sealed, err := s.sealExpense(expense)
+	if err != nil {
+		return err
+	}
+	return s.Storage.AddExpense(sealed)
+}
+
+func (s *sealedStore) AddMultipleExpenses(expenses []Expense) error {
+	sealed := make([]Expense, len(expenses))
+	for i, expense := range expenses {
+		var err error
+		if sealed[i], err = s.sealExpense(expense); err != nil {
+			return err
+		}
+	}
+	return s.Storage.AddMultipleExpenses(sealed)
+}
+
+func (s *sealedStore) UpdateExpense(id string, expense Expense) error {
+	sealed, err := s.sealExpense(expense)
+	if err != nil {
+		return err
+	}
+	return s.Storage.UpdateExpense(id, sealed)
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanq16/expenseowl/internal/secrets"
+)
+
+// TestSealedStore checks that expense text is sealed at rest and opened on the way back, and that
+// reopening the store follows the key and ENCRYPT_EXPENSES
+func TestSealedStore(t *testing.T) {
+	oldKey, newKey := bytes.Repeat([]byte{1}, secrets.KeySize), bytes.Repeat([]byte{2}, secrets.KeySize)
+	t.Setenv("SECRETS_KEY", base64.StdEncoding.EncodeToString(oldKey))
+	t.Setenv("ENCRYPT_EXPENSES", "true")
+	files := &memoryFiles{files: map[string][]byte{}}
+	memory, err := newJSONStore(files, "memory")
+	if err != nil {
+		t.Fatalf("Failed to create memory store: %v", err)
+	}
+	open := func() Storage {
+		t.Helper()
+		store, err := withSecrets(memory)
+		if err != nil {
+			t.Fatalf("Failed to open sealed store: %v", err)
+		}
+		return store
+	}
+	stored := func() string {
+		return string(files.files["memory/expenses.json"])
+	}
+	store := open()
+
+	date := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	therapy := Expense{ID: "1", Name: "Therapy", Notes: "Dr. Alvarez", Tags: []string{"health", "private"}, Category: "Food", Amount: -80, Date: date}
+	if err := store.AddExpense(therapy); err != nil {
+		t.Fatalf("Failed to add expense: %v", err)
+	}
+	if err := store.AddExpense(Expense{ID: "2", Name: "Coffee", Category: "Food", Amount: -4, Date: date}); err != nil {
+		t.Fatalf("Failed to add expense: %v", err)
+	}
+	expense, err := store.GetExpense("1")
+	if err != nil || expense.Name != therapy.Name || expense.Notes != therapy.Notes || !slices.Equal(expense.Tags, therapy.Tags) {
+		t.Errorf("Expected the expense back as it was added, got %+v, %v", expense, err)
+	}
+	for _, plain := range []string{"Therapy", "Dr. Alvarez", "private", "Coffee"} {
+		if strings.Contains(stored(), plain) {
+			t.Errorf("Expected %q to be sealed in the stored file", plain)
+		}
+	}
+	if !strings.Contains(stored(), "enc:v1:") {
+		t.Error("Expected sealed values in the stored file")
+	}
+
+	// lookups work on the opened text, since sealed values never match
+	if found, _ := memory.FindExpenses(ExpenseFilter{Tags: []string{"private"}}); len(found) != 0 {
+		t.Errorf("Expected the underlying store not to match sealed tags, got %d", len(found))
+	}
+	if found, err := store.FindExpenses(ExpenseFilter{Tags: []string{"private"}}); err != nil || len(found) != 1 || found[0].Name != "Therapy" {
+		t.Errorf("Expected the expense tagged private, got %+v, %v", found, err)
+	}
+	if suggestions, err := store.SuggestExpenseNames("ther", 5); err != nil || len(suggestions) != 1 || suggestions[0].Name != "Therapy" {
+		t.Errorf("Expected Therapy to be suggested, got %+v, %v", suggestions, err)
+	}
+	if duplicate, err := store.FindDuplicateExpense(" therapy", "Food", -80, date.Add(time.Hour)); err != nil || !duplicate {
+		t.Errorf("Expected a duplicate of Therapy, got %v, %v", duplicate, err)
+	}
+	if duplicate, _ := store.FindDuplicateExpense("Therapy", "Food", -81, date); duplicate {
+		t.Error("Expected a different amount not to be a duplicate")
+	}
+
+	// a new key, with the old one kept to read existing values, reseals everything
+	t.Setenv("SECRETS_KEY", base64.StdEncoding.EncodeToString(newKey))
+	t.Setenv("SECRETS_PREVIOUS_KEYS", base64.StdEncoding.EncodeToString(oldKey))
+	store = open()
+	if expense, err := store.GetExpense("1"); err != nil || expense.Name != "Therapy" {
+		t.Errorf("Expected the expense to open after the rotation, got %+v, %v", expense, err)
+	}
+	onlyNew, _ := secrets.New(newKey)
+	raw, _ := memory.GetExpense("1")
+	if name, err := onlyNew.Open(raw.Name); err != nil || name != "Therapy" {
+		t.Errorf("Expected the name to be resealed with the new key, got %q, %v", name, err)
+	}
+
+	// turning expense encryption off stores plain text again, and back on seals it
+	t.Setenv("ENCRYPT_EXPENSES", "false")
+	store = open()
+	if raw, _ := memory.GetExpense("1"); raw.Name != "Therapy" || raw.Notes != "Dr. Alvarez" || !slices.Equal(raw.Tags, therapy.Tags) {
+		t.Errorf("Expected plain text in the store, got %+v", raw)
+	}
+	if strings.Contains(stored(), "enc:v1:") {
+		t.Error("Expected no sealed values left in the stored file")
+	}
+	if found, _ := store.FindExpenses(ExpenseFilter{Tags: []string{"private"}}); len(found) != 1 {
+		t.Errorf("Expected plain tags to match, got %d", len(found))
+	}
+	t.Setenv("ENCRYPT_EXPENSES", "true")
+	store = open()
+	if strings.Contains(stored(), "Therapy") || !strings.Contains(stored(), "enc:v1:") {
+		t.Error("Expected the expenses to be sealed again")
+	}
+	if expense, err := store.GetExpense("1"); err != nil || expense.Name != "Therapy" {
+		t.Errorf("Expected the expense to open, got %+v, %v", expense, err)
+	}
+}
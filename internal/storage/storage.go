@@ -1,13 +1,55 @@
 package storage
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math"
+	"net/mail"
+	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/tanq16/expenseowl/internal/cron"
+	"github.com/tanq16/expenseowl/internal/i18n"
+	"github.com/tanq16/expenseowl/internal/periods"
+)
+
+var (
+	// ErrNotFound is wrapped in the error for an expense or recurring expense that doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is wrapped in the error for adding an expense with the ID of an existing one
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrExpenseChanged is returned by UpdateExpense when the expense was written since the copy being
+	// saved was read, so that concurrent edits don't silently overwrite each other
+	ErrExpenseChanged = errors.New("expense was changed since it was read")
 )
 
+// ValidationError is the error for an invalid field of a record
+type ValidationError struct {
+	Field string // as named in JSON, e.g., "amount" or "tax"
+	Err   error
+}
+
+func (e *ValidationError) Error() string { return e.Err.Error() }
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// invalidField returns err as a ValidationError of the field
+func invalidField(field string, err error) error {
+	return &ValidationError{Field: field, Err: err}
+}
+
 // Storage interface for all storage types
 type Storage interface {
 	Close() error
@@ -16,12 +58,65 @@ type Storage interface {
 	// Basic Config Updates
 	GetCategories() ([]string, error)
 	UpdateCategories(categories []string) error
+	RenameCategory(oldName string, newName string) error
+	MergeCategories(source string, target string) error
+	GetArchivedCategories() ([]string, error)
+	ArchiveCategory(category string, archived bool) error
+	GetCategoryMeta() (map[string]CategoryMeta, error)
+	UpdateCategoryMeta(meta map[string]CategoryMeta) error
 	// GetTags() ([]string, error)
 	// UpdateTags(tags []string) error
 	GetCurrency() (string, error)
 	UpdateCurrency(currency string) error
+	GetCustomCurrencies() ([]Currency, error)
+	UpdateCustomCurrencies(currencies []Currency) error
 	GetStartDate() (int, error)
 	UpdateStartDate(startDate int) error
+	GetBudgets() (map[string]float64, error)
+	UpdateBudgets(budgets map[string]float64) error
+	GetPeriod() (periods.Config, error)
+	UpdatePeriod(period periods.Config) error
+	GetReportSettings() (ReportSettings, error)
+	UpdateReportSettings(settings ReportSettings) error
+	GetMerchantAliases() (map[string]string, error)
+	UpdateMerchantAliases(aliases map[string]string) error
+	GetTravelRates() (TravelRates, error)
+	UpdateTravelRates(rates TravelRates) error
+	GetFiscalYearStart() (int, error)
+	UpdateFiscalYearStart(month int) error
+	GetShares() ([]Share, error)
+	UpdateShares(shares []Share) error
+	GetRetentionYears() (int, error)
+	UpdateRetentionYears(years int) error
+	GetRuntimeSettings() (RuntimeSettings, error)
+	UpdateRuntimeSettings(settings RuntimeSettings) error
+	GetPreferences(user string) (Preferences, error)
+	UpdatePreferences(user string, preferences Preferences) error
+	GetAccounts() ([]Account, error)
+	UpdateAccounts(accounts []Account) error
+	GetHoldings() ([]Holding, error)
+	UpdateHoldings(holdings []Holding) error
+	GetPlannedExpenses() ([]PlannedExpense, error)
+	UpdatePlannedExpenses(planned []PlannedExpense) error
+	GetSavedReports() ([]SavedReport, error)
+	UpdateSavedReports(reports []SavedReport) error
+	GetPlaidItems() ([]PlaidItem, error)
+	UpdatePlaidItems(items []PlaidItem) error
+	GetProjects() ([]Project, error)
+	UpdateProjects(projects []Project) error
+	GetTRMNLProfiles() ([]TRMNLProfile, error)
+	UpdateTRMNLProfiles(profiles []TRMNLProfile) error
+	GetVoiceKeys() ([]VoiceKey, error)
+	UpdateVoiceKeys(keys []VoiceKey) error
+	GetWalletDevices() ([]WalletDevice, error)
+	UpdateWalletDevices(devices []WalletDevice) error
+
+	// Login Tokens
+	GetLoginToken(hash string) (LoginToken, error)
+	GetLoginTokens(user string) ([]LoginToken, error)
+	AddLoginToken(token LoginToken) error
+	UpdateLoginToken(token LoginToken) error
+	RemoveLoginToken(hash string) (bool, error) // reports whether this call removed it, so a link is used once
 
 	// Recurring Expenses
 	GetRecurringExpenses() ([]RecurringExpense, error)
@@ -32,19 +127,34 @@ type Storage interface {
 
 	// Expenses
 	GetAllExpenses() ([]Expense, error)
+	FindExpenses(filter ExpenseFilter) ([]Expense, error)
+	SuggestExpenseNames(prefix string, limit int) ([]NameSuggestion, error)
+	GetMonthlyAggregates() ([]MonthlyAggregate, error)
+	GetExpenseChanges(since int64, limit int) ([]ExpenseChange, int64, error)
+	GetExpenseChange(id string) (ExpenseChange, error)
+	GetConfigChanges(since int64) ([]ConfigChange, error)
 	GetExpense(id string) (Expense, error)
 	FindDuplicateExpense(name string, category string, amount float64, date time.Time) (bool, error)
 	AddExpense(expense Expense) error
 	RemoveExpense(id string) error
 	AddMultipleExpenses(expenses []Expense) error
 	RemoveMultipleExpenses(ids []string) error
-	UpdateExpense(id string, expense Expense) error
+	MoveExpenses(ids []string, category string, subCategory string) error
+	UpdateExpense(id string, expense Expense) error // fails with ErrExpenseChanged when expense.UpdatedAt is set and out of date
+
+	// Archive
+	ArchiveExpenses(before time.Time) (int, error)
+	GetArchivedExpenses() ([]Expense, error)
+
+	// Maintenance
+	Vacuum() error // rebuilds the monthly aggregates and reclaims space left by deleted data
 
 	// SubCategory Management
 	GetSubCategories(category string) ([]string, error)
 	AddSubCategory(category string, subCategory string) error
 	RemoveSubCategory(category string, subCategory string) error
 	RenameSubCategory(category string, oldName string, newName string) error
+	MergeSubCategory(category string, source string, target string) error
 
 	// SubCategory Mapping
 	GetSubCategoryMappings() ([]SubCategoryMappingRule, error)
@@ -58,31 +168,608 @@ type Storage interface {
 // config for expense data
 type Config struct {
 	Categories        []string                   `json:"categories"`
+	CategoryMeta      map[string]CategoryMeta    `json:"categoryMeta"`
+	Archived          []string                   `json:"archivedCategories"` // hidden from pickers, kept for history
 	SubCategories     map[string][]string        `json:"subCategories"`
 	SubCategoryMap    []SubCategoryMappingRule   `json:"subCategoryMap"`
 	Currency          string                     `json:"currency"`
+	CustomCurrencies  []Currency                 `json:"customCurrencies"` // units besides ISO 4217, e.g., points or crypto
 	StartDate         int                        `json:"startDate"`
 	RecurringExpenses []RecurringExpense         `json:"recurringExpenses"`
+	Budgets           map[string]float64         `json:"budgets"` // category -> limit per period
+	Period            periods.Config             `json:"period"`  // start day is kept in StartDate
+	Reports           ReportSettings             `json:"reports"`
+	MerchantAliases   map[string]string          `json:"merchantAliases"` // normalized merchant -> merchant it was merged into
+	TravelRates       TravelRates                `json:"travelRates"`
+	FiscalYearStart   int                        `json:"fiscalYearStart"` // month the fiscal year starts in, 1 for January
+	Shares            []Share                    `json:"shares"`          // read-only report links
+	RetentionYears    int                        `json:"retentionYears"`  // expenses older than this are archived, 0 keeps all
+	Settings          *RuntimeSettings           `json:"settings"`        // nil until first saved, read as the defaults
+	Preferences       map[string]Preferences     `json:"preferences"`     // user -> their preferences, "" without access control
+	Accounts          []Account                  `json:"accounts"`        // assets and liabilities for net worth
+	Holdings          []Holding                  `json:"holdings"`        // investments, valued with their price history
+	PlannedExpenses   []PlannedExpense           `json:"plannedExpenses"` // future expenses saved up for
+	SavedReports      []SavedReport              `json:"savedReports"`    // run on a schedule and sent to a webhook or file
+	PlaidItems        []PlaidItem                `json:"plaidItems"`      // bank logins synced through Plaid
+	Projects          []Project                  `json:"projects"`        // client projects expenses and income are attributed to
+	TRMNLProfiles     []TRMNLProfile             `json:"trmnlProfiles"`   // TRMNL displays with their own token and payload
+	VoiceKeys         []VoiceKey                 `json:"voiceKeys"`       // API keys voice assistant skills are linked with
+	WalletDevices     []WalletDevice             `json:"walletDevices"`   // devices with the budget pass in Apple Wallet
 	// Tags              []string           `json:"tags"`
 }
 
+// MaxRetentionYears is the longest retention policy; 0 turns archiving off
+const MaxRetentionYears = 100
+
+// CategoryMeta holds display settings for a category
+type CategoryMeta struct {
+	Color     string  `json:"color,omitempty"`     // hex, e.g., #FF6B6B
+	Icon      string  `json:"icon,omitempty"`      // emoji or short symbol
+	Order     int     `json:"order,omitempty"`     // display position, lowest first
+	MaxAmount float64 `json:"maxAmount,omitempty"` // largest single amount saved without confirmation, 0 for no limit
+	Rollover  string  `json:"rollover,omitempty"`  // what of the budget carries into the next period, see the Rollover constants
+	TaxRate   float64 `json:"taxRate,omitempty"`   // percent of tax (e.g., VAT) included in amounts, split off new expenses
+	Excluded  bool    `json:"excluded,omitempty"`  // expenses of the category are left out of reports and budgets
+}
+
+// Budget rollover modes: what a period's leftover (or overspend) does to the next period's budget
+const (
+	RolloverNone      = ""          // every period starts from the plain budget
+	RolloverUnused    = "unused"    // unused budget is added to the next period
+	RolloverOverspend = "overspend" // overspending is deducted from the next period
+	RolloverBoth      = "both"      // both of the above
+)
+
+// ReportSettings controls the summary email sent when a period closes
+type ReportSettings struct {
+	Enabled    bool     `json:"enabled"`
+	Recipients []string `json:"recipients"`         // opted-in addresses
+	Language   string   `json:"language,omitempty"` // of the reports and shared links, English when empty
+	LastSent   string   `json:"lastSent,omitempty"` // start date (YYYY-MM-DD) of the last reported period
+}
+
+// TravelRates are the default rates for mileage and per-diem expenses
+type TravelRates struct {
+	MileageRate  float64 `json:"mileageRate"`  // per distance unit, e.g., 0.30 per km
+	DistanceUnit string  `json:"distanceUnit"` // km or mi
+	PerDiemRate  float64 `json:"perDiemRate"`  // per day
+}
+
+// Account types
+const (
+	AccountAsset     = "asset"     // e.g., checking, savings, property
+	AccountLiability = "liability" // e.g., credit cards, loans
+)
+
+// Account is an asset or a liability tracked for net worth. Its balance is the latest snapshot,
+// plus (for derived accounts) the expenses and income on the account since that snapshot.
+type Account struct {
+	Name         string            `json:"name"`                   // matched against the account of expenses for derived balances
+	Type         string            `json:"type"`                   // asset or liability
+	Derived      bool              `json:"derived,omitempty"`      // follow the account's transactions after the latest snapshot
+	StatementDay int               `json:"statementDay,omitempty"` // day of the month its statement closes (the last day in shorter months), 0 without statements
+	Snapshots    []BalanceSnapshot `json:"snapshots"`              // sorted by date
+}
+
+// BalanceSnapshot is a balance entered by hand, as of the end of the day
+type BalanceSnapshot struct {
+	Date    string  `json:"date"`    // YYYY-MM-DD
+	Balance float64 `json:"balance"` // the amount owned, or owed for liabilities
+}
+
+// Holding is an investment position, valued at the latest price on or before a date
+type Holding struct {
+	Ticker    string       `json:"ticker"`    // upper case, as the price provider knows it
+	Quantity  float64      `json:"quantity"`  // shares or units held
+	CostBasis float64      `json:"costBasis"` // total paid for the position
+	Prices    []PriceQuote `json:"prices"`    // one per day, sorted by date
+}
+
+// PriceQuote is the price of one unit of a holding on a day
+type PriceQuote struct {
+	Date  string  `json:"date"` // YYYY-MM-DD
+	Price float64 `json:"price"`
+}
+
+// PlannedExpense is a future expense that is saved up for ahead of time, like a sinking fund
+type PlannedExpense struct {
+	Name     string  `json:"name"`               // e.g., "Car insurance"
+	Category string  `json:"category,omitempty"` // where it will be spent
+	Amount   float64 `json:"amount"`             // target to have saved by the due date
+	Due      string  `json:"due"`                // YYYY-MM-DD
+	Saved    float64 `json:"saved"`              // set aside so far
+	Start    string  `json:"start"`              // YYYY-MM-DD saving started
+}
+
+// Project is a piece of work (e.g., a client engagement) that expenses and income are attributed
+// to across categories, so its profitability can be reported
+type Project struct {
+	Name   string `json:"name"`
+	Client string `json:"client,omitempty"`
+	Closed bool   `json:"closed,omitempty"` // kept for reports, but no longer offered for new expenses
+}
+
+// TRMNLProfile is a TRMNL display polling with its own token, and the payload it's sent
+type TRMNLProfile struct {
+	Name      string    `json:"name"`              // e.g., kitchen
+	TokenHash string    `json:"tokenHash"`         // SHA-256 of the display's token; the token itself isn't stored
+	Options   string    `json:"options,omitempty"` // /api/trmnl query parameters, e.g., period=week&categories=Food
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// VoiceKey is an API key a voice assistant skill is linked with, e.g., one per household member
+type VoiceKey struct {
+	Name      string    `json:"name"`    // e.g., Alice's Echo
+	KeyHash   string    `json:"keyHash"` // SHA-256 of the key; the key itself isn't stored
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WalletDevice is a device that added a budget pass to Apple Wallet, registered through the pass
+// web service so updates of the pass are pushed to it
+type WalletDevice struct {
+	DeviceID     string    `json:"deviceId"` // the device library identifier Wallet sends
+	PushToken    string    `json:"pushToken"`
+	SerialNumber string    `json:"serialNumber"` // of the pass
+	Tag          string    `json:"tag"`          // of the pass the device was last pushed, see api.walletTag
+	RegisteredAt time.Time `json:"registeredAt"`
+}
+
+// SavedReport is a report of filtered and grouped expenses that is run on a schedule, with its JSON
+// result POSTed to a webhook or written to a file
+type SavedReport struct {
+	Name     string        `json:"name"`
+	Filter   ExpenseFilter `json:"filter"`
+	GroupBy  string        `json:"groupBy,omitempty"` // category, subcategory, tag, account, class, project, or month; the totals only when empty
+	Range    string        `json:"range"`             // current, previous, year, or all, as for the merchant report
+	Schedule string        `json:"schedule"`          // daily, weekly, or monthly
+	Webhook  string        `json:"webhook,omitempty"` // http(s) URL the result is POSTed to
+	File     string        `json:"file,omitempty"`    // file name in REPORTS_DIR the result is written to
+	LastRun  string        `json:"lastRun,omitempty"` // RFC 3339 time of the last scheduled run
+}
+
+// Saved report groupings and schedules
+const (
+	GroupByCategory    = "category"
+	GroupBySubCategory = "subcategory"
+	GroupByTag         = "tag"
+	GroupByAccount     = "account"
+	GroupByMonth       = "month"
+	GroupByClass       = "class"
+	GroupByProject     = "project"
+
+	ScheduleDaily   = "daily"
+	ScheduleWeekly  = "weekly"
+	ScheduleMonthly = "monthly"
+)
+
+// PlaidItem is a bank login connected through Plaid; its transactions are synced incrementally,
+// starting after Cursor
+type PlaidItem struct {
+	ID          string `json:"id"`                    // Plaid item_id
+	Institution string `json:"institution,omitempty"` // e.g., "Chase"
+	AccessToken string `json:"accessToken"`
+	Cursor      string `json:"cursor,omitempty"`    // next_cursor of the last sync, empty before the first
+	LastSync    string `json:"lastSync,omitempty"`  // RFC 3339 time of the last successful sync
+	LastError   string `json:"lastError,omitempty"` // of the last sync, cleared when one succeeds
+}
+
+// Share is a read-only link to a report, identified by a random token
+type Share struct {
+	Token     string    `json:"token"`
+	Report    string    `json:"report"`          // period, year, or trip
+	Start     string    `json:"start,omitempty"` // period: YYYY-MM-DD start of the budget period
+	Year      int       `json:"year,omitempty"`  // year: the year the fiscal year starts in
+	Tag       string    `json:"tag,omitempty"`   // trip: tag shared by the trip's expenses
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Login token kinds
+const (
+	LoginLink    = "link"    // emailed one-time link
+	LoginSession = "session" // signed-in browser, started by a link
+	LoginAPIKey  = "api-key" // bearer token of a script or the CLI, acting as its user
+)
+
+// LoginToken is a one-time login link, the session it starts, or an API key. Only a hash of the
+// token is stored, so a copy of the data can't be used to sign in.
+type LoginToken struct {
+	Hash       string    `json:"hash"` // hex SHA-256 of the token
+	Kind       string    `json:"kind"` // link or session
+	User       string    `json:"user"` // email address
+	Role       string    `json:"role,omitempty"`   // session: the user's role when it started
+	Device     string    `json:"device,omitempty"` // session: user agent of the browser; API key: its name
+	IP         string    `json:"ip,omitempty"`     // session: address it was last used from
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Travel expense types
+const (
+	TravelMileage = "mileage"
+	TravelPerDiem = "perdiem"
+)
+
+// TaxDetails is the tax (e.g., VAT) included in an expense's amount, which stays the gross amount.
+// With only the rate, the tax is split from the gross amount; a receipt's exact tax can be given instead.
+type TaxDetails struct {
+	Rate   float64 `json:"rate"`   // percent, e.g., 20
+	Amount float64 `json:"amount"` // the part of the gross amount that is tax, always positive
+}
+
+// Validate checks the rate and amount against the gross amount, splitting the amount off when unset
+func (t *TaxDetails) Validate(gross float64) error {
+	if t.Rate < 0 || t.Rate > 100 || math.IsNaN(t.Rate) {
+		return fmt.Errorf("tax 'rate' must be a percent from 0 to 100")
+	}
+	if t.Amount == 0 {
+		t.Amount = math.Round(math.Abs(gross)*t.Rate/(100+t.Rate)*100) / 100
+	}
+	if t.Amount < 0 || t.Amount > math.Abs(gross) || math.IsNaN(t.Amount) {
+		return fmt.Errorf("tax 'amount' must be positive and at most the amount")
+	}
+	return nil
+}
+
+// TravelDetails make an expense a mileage or per-diem entry, whose amount is computed from
+// the distance or days and the rate. The rate is kept, so later rate changes don't alter it.
+type TravelDetails struct {
+	Type     string  `json:"type"`               // mileage or perdiem
+	Distance float64 `json:"distance,omitempty"` // for mileage, in Unit
+	Unit     string  `json:"unit,omitempty"`     // km or mi, for mileage
+	Days     float64 `json:"days,omitempty"`     // for per diem, half days allowed
+	Rate     float64 `json:"rate"`               // per unit or per day
+}
+
+// Amount returns the computed amount of the entry, rounded to cents
+func (t *TravelDetails) Amount() float64 {
+	quantity := t.Distance
+	if t.Type == TravelPerDiem {
+		quantity = t.Days
+	}
+	return math.Round(quantity*t.Rate*100) / 100
+}
+
+// Validate checks the fields required by the travel expense type
+func (t *TravelDetails) Validate() error {
+	switch t.Type {
+	case TravelMileage:
+		if t.Distance <= 0 {
+			return fmt.Errorf("mileage 'distance' must be greater than 0")
+		}
+		if t.Unit != "km" && t.Unit != "mi" {
+			return fmt.Errorf("mileage 'unit' must be km or mi")
+		}
+		t.Days = 0
+	case TravelPerDiem:
+		if t.Days <= 0 {
+			return fmt.Errorf("per diem 'days' must be greater than 0")
+		}
+		t.Distance, t.Unit = 0, ""
+	default:
+		return fmt.Errorf("travel 'type' must be mileage or perdiem")
+	}
+	if t.Rate <= 0 {
+		return fmt.Errorf("%s 'rate' must be greater than 0; set a default rate in settings", t.Type)
+	}
+	return nil
+}
+
+// ValidateTravelRates checks that rates are not negative and the distance unit is known
+func ValidateTravelRates(rates *TravelRates) error {
+	if rates.MileageRate < 0 || rates.PerDiemRate < 0 {
+		return fmt.Errorf("rates cannot be negative")
+	}
+	if rates.DistanceUnit == "" {
+		rates.DistanceUnit = "km"
+	}
+	if rates.DistanceUnit != "km" && rates.DistanceUnit != "mi" {
+		return fmt.Errorf("distance unit must be km or mi")
+	}
+	return nil
+}
+
+// RuntimeSettings are operator toggles that take effect without restarting the server
+type RuntimeSettings struct {
+	Notifications NotificationSettings `json:"notifications"`
+	Schedules     ScheduleSettings     `json:"schedules"`
+	UI            UISettings           `json:"ui"`
+}
+
+// NotificationSettings switch outbound channels on or off; each still needs its environment variables
+type NotificationSettings struct {
+	MQTT     bool `json:"mqtt"`     // publish the Home Assistant summary when an expense is added
+	Telegram bool `json:"telegram"` // answer Telegram messages
+}
+
+// ScheduleSettings set how often the background jobs run
+type ScheduleSettings struct {
+	ReportCheckMinutes int               `json:"reportCheckMinutes"` // how often to look for a closed period to email
+	ArchiveHours       int               `json:"archiveHours"`       // how often to apply the retention policy
+	Jobs               map[string]string `json:"jobs,omitempty"`     // cron expressions or "@every" intervals by job, replacing their defaults
+}
+
+// UISettings are defaults for the web UI; a browser's own choice wins. The branding fields let an
+// instance carry its own name and logo.
+type UISettings struct {
+	DefaultTheme   string `json:"defaultTheme"`             // system, light, or dark
+	DefaultPalette string `json:"defaultPalette,omitempty"` // chart colors, one of ChartPalettes
+	InstanceName string `json:"instanceName,omitempty"` // in page titles, ExpenseOwl when empty
+	LogoPath     string `json:"logoPath,omitempty"`     // path or http(s) URL of the header logo, the owl when empty
+	FooterText   string `json:"footerText,omitempty"`   // shown at the bottom of every page
+}
+
+const (
+	maxInstanceNameLength = 40
+	maxFooterTextLength   = 200
+)
+
+// DefaultRuntimeSettings match the behavior before runtime settings existed
+func DefaultRuntimeSettings() RuntimeSettings {
+	return RuntimeSettings{
+		Notifications: NotificationSettings{MQTT: true, Telegram: true},
+		Schedules:     ScheduleSettings{ReportCheckMinutes: 60, ArchiveHours: 24},
+		UI:            UISettings{DefaultTheme: "system", DefaultPalette: DefaultPalette},
+	}
+}
+
+// ValidateRuntimeSettings checks the schedule bounds and theme, filling in defaults for unset values
+func ValidateRuntimeSettings(settings *RuntimeSettings) error {
+	defaults := DefaultRuntimeSettings()
+	if settings.Schedules.ReportCheckMinutes == 0 {
+		settings.Schedules.ReportCheckMinutes = defaults.Schedules.ReportCheckMinutes
+	}
+	if settings.Schedules.ReportCheckMinutes < 5 || settings.Schedules.ReportCheckMinutes > 1440 {
+		return fmt.Errorf("report check interval must be between 5 and 1440 minutes")
+	}
+	if settings.Schedules.ArchiveHours == 0 {
+		settings.Schedules.ArchiveHours = defaults.Schedules.ArchiveHours
+	}
+	if settings.Schedules.ArchiveHours < 1 || settings.Schedules.ArchiveHours > 168 {
+		return fmt.Errorf("archive interval must be between 1 and 168 hours")
+	}
+	for job, spec := range settings.Schedules.Jobs {
+		if _, err := cron.Parse(spec); err != nil {
+			return fmt.Errorf("schedule of job '%s': %v", job, err)
+		}
+	}
+	if settings.UI.DefaultTheme == "" {
+		settings.UI.DefaultTheme = defaults.UI.DefaultTheme
+	}
+	if !slices.Contains([]string{"system", "light", "dark"}, settings.UI.DefaultTheme) {
+		return fmt.Errorf("default theme must be system, light, or dark")
+	}
+	if settings.UI.DefaultPalette == "" {
+		settings.UI.DefaultPalette = defaults.UI.DefaultPalette
+	}
+	if _, ok := ChartPalettes[settings.UI.DefaultPalette]; !ok {
+		return fmt.Errorf("unknown chart palette '%s'", settings.UI.DefaultPalette)
+	}
+	settings.UI.InstanceName = strings.TrimSpace(settings.UI.InstanceName)
+	if utf8.RuneCountInString(settings.UI.InstanceName) > maxInstanceNameLength {
+		return fmt.Errorf("instance name must be at most %d characters", maxInstanceNameLength)
+	}
+	settings.UI.LogoPath = strings.TrimSpace(settings.UI.LogoPath)
+	if logo := settings.UI.LogoPath; logo != "" {
+		u, err := url.Parse(logo)
+		local := strings.HasPrefix(logo, "/") && !strings.HasPrefix(logo, "//")
+		if err != nil || !(local || (u.Scheme == "https" || u.Scheme == "http") && u.Host != "") {
+			return fmt.Errorf("logo must be a path such as /pwa/icon-192.png or an http(s) URL")
+		}
+	}
+	settings.UI.FooterText = strings.TrimSpace(settings.UI.FooterText)
+	if utf8.RuneCountInString(settings.UI.FooterText) > maxFooterTextLength {
+		return fmt.Errorf("footer text must be at most %d characters", maxFooterTextLength)
+	}
+	return nil
+}
+
+// Preferences are one user's choices for the web UI; unset values fall back to the runtime
+// settings and the browser
+type Preferences struct {
+	Theme           string           `json:"theme,omitempty"`           // system, light, or dark
+	Language        string           `json:"language,omitempty"`        // of the UI text, the browser's when empty
+	Palette         string           `json:"palette,omitempty"`         // chart colors, one of ChartPalettes
+	Locale          string           `json:"locale,omitempty"`          // BCP 47 tag for dates and numbers, e.g., de-DE
+	FirstDayOfWeek  int              `json:"firstDayOfWeek"`            // 0 for Sunday to 6 for Saturday
+	LandingPage     string           `json:"landingPage,omitempty"`     // dashboard, table, monthly-chart, or settings
+	DefaultCategory string           `json:"defaultCategory,omitempty"` // preselected when adding an expense
+	Dashboard       *DashboardLayout `json:"dashboard,omitempty"`       // nil for the default layout
+}
+
+// Dashboard widgets
+const (
+	WidgetCategories = "categories" // pie chart of the period's spending by category
+	WidgetCashflow   = "cashflow"   // income, expenses, and balance of the period
+	WidgetTrend      = "trend"      // spending of the last months
+	WidgetBudgets    = "budgets"    // budget bars of the period
+	WidgetRecent     = "recent"     // latest expenses
+	WidgetPlanned    = "planned"    // planned expenses and their saving goals
+)
+
+// DashboardWidgets are the widgets a dashboard can show, each at most once
+var DashboardWidgets = []string{WidgetCategories, WidgetCashflow, WidgetTrend, WidgetBudgets, WidgetRecent, WidgetPlanned}
+
+// MaxDashboardColumns is the widest dashboard grid
+const MaxDashboardColumns = 4
+
+// DashboardLayout is a user's grid of dashboard widgets, placed in order, left to right
+type DashboardLayout struct {
+	Columns int               `json:"columns"` // 1 to MaxDashboardColumns
+	Widgets []DashboardWidget `json:"widgets"`
+}
+
+// DashboardWidget is a widget placed on the dashboard grid
+type DashboardWidget struct {
+	Type  string `json:"type"`
+	Width int    `json:"width"`           // columns spanned, all of them when 0
+	Limit int    `json:"limit,omitempty"` // expenses listed by recent, months shown by trend
+}
+
+// DefaultDashboardLayout is the dashboard of users who haven't arranged their own
+func DefaultDashboardLayout() DashboardLayout {
+	return DashboardLayout{Columns: 2, Widgets: []DashboardWidget{
+		{Type: WidgetCategories, Width: 2},
+		{Type: WidgetCashflow, Width: 2},
+		{Type: WidgetPlanned, Width: 2},
+	}}
+}
+
+// widgetLimits are the default and the range of the limit of widgets that take one
+var widgetLimits = map[string][3]int{WidgetRecent: {5, 1, 50}, WidgetTrend: {6, 2, 36}}
+
+// ValidateDashboardLayout checks the grid and widgets, filling in the default widths and limits
+func ValidateDashboardLayout(layout *DashboardLayout) error {
+	if layout.Columns < 1 || layout.Columns > MaxDashboardColumns {
+		return fmt.Errorf("dashboard columns must be from 1 to %d", MaxDashboardColumns)
+	}
+	if len(layout.Widgets) == 0 {
+		return fmt.Errorf("dashboard needs at least one widget")
+	}
+	seen := make(map[string]bool, len(layout.Widgets))
+	for i := range layout.Widgets {
+		widget := &layout.Widgets[i]
+		if !slices.Contains(DashboardWidgets, widget.Type) {
+			return fmt.Errorf("unknown widget '%s', expected one of %s", widget.Type, strings.Join(DashboardWidgets, ", "))
+		}
+		if seen[widget.Type] {
+			return fmt.Errorf("widget '%s' is on the dashboard more than once", widget.Type)
+		}
+		seen[widget.Type] = true
+		if widget.Width == 0 {
+			widget.Width = layout.Columns
+		}
+		if widget.Width < 0 || widget.Width > layout.Columns {
+			return fmt.Errorf("width of widget '%s' must be from 1 to %d columns", widget.Type, layout.Columns)
+		}
+		limits, ok := widgetLimits[widget.Type]
+		switch {
+		case !ok:
+			widget.Limit = 0
+		case widget.Limit == 0:
+			widget.Limit = limits[0]
+		case widget.Limit < limits[1] || widget.Limit > limits[2]:
+			return fmt.Errorf("limit of widget '%s' must be from %d to %d", widget.Type, limits[1], limits[2])
+		}
+	}
+	return nil
+}
+
+// LandingPages maps the landing page preferences to their paths
+var LandingPages = map[string]string{"dashboard": "/", "table": "/table", "monthly-chart": "/monthly-chart", "settings": "/settings"}
+
+// DefaultPalette is the chart palette used when none is picked
+const DefaultPalette = "default"
+
+// ChartPalettes are the color sets for charts and category colors
+var ChartPalettes = map[string][]string{
+	DefaultPalette: {"#FF6B6B", "#4ECDC4", "#45B7D1", "#FFA07A", "#98D8C8", "#F7DC6F", "#BB8FCE", "#85C1E2", "#F8B739", "#52B788", "#E76F51", "#A8DADC"},
+	"colorblind":   {"#E69F00", "#56B4E9", "#009E73", "#F0E442", "#0072B2", "#D55E00", "#CC79A7", "#999999"}, // Okabe-Ito
+	"pastel":       {"#FFB3BA", "#FFDFBA", "#FFFFBA", "#BAFFC9", "#BAE1FF", "#D7BAFF", "#FFBAF2", "#C9C9FF", "#B5EAD7", "#E2F0CB"},
+	"vivid":        {"#E63946", "#F4A261", "#2A9D8F", "#264653", "#E9C46A", "#8338EC", "#3A86FF", "#FB5607", "#FF006E", "#06D6A0"},
+}
+
+var reLocale = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// ValidatePreferences checks the values of the preferences; the default category is checked against
+// the categories when it's used
+func ValidatePreferences(preferences Preferences) error {
+	if preferences.Theme != "" && !slices.Contains([]string{"system", "light", "dark"}, preferences.Theme) {
+		return fmt.Errorf("theme must be system, light, or dark")
+	}
+	if preferences.Locale != "" && !reLocale.MatchString(preferences.Locale) {
+		return fmt.Errorf("invalid locale '%s', expected a language tag such as en-US", preferences.Locale)
+	}
+	if _, ok := ChartPalettes[preferences.Palette]; preferences.Palette != "" && !ok {
+		return fmt.Errorf("unknown chart palette '%s'", preferences.Palette)
+	}
+	if preferences.Language != "" && !i18n.Supported(preferences.Language) {
+		return fmt.Errorf("unsupported language '%s'", preferences.Language)
+	}
+	if preferences.FirstDayOfWeek < 0 || preferences.FirstDayOfWeek > 6 {
+		return fmt.Errorf("first day of week must be from 0 (Sunday) to 6 (Saturday)")
+	}
+	if _, ok := LandingPages[preferences.LandingPage]; preferences.LandingPage != "" && !ok {
+		return fmt.Errorf("landing page must be dashboard, table, monthly-chart, or settings")
+	}
+	if preferences.Dashboard != nil {
+		return ValidateDashboardLayout(preferences.Dashboard)
+	}
+	return nil
+}
+
+// RuntimeSettings returns the saved settings, or the defaults if none were saved
+func (c *Config) RuntimeSettings() RuntimeSettings {
+	if c.Settings == nil {
+		return DefaultRuntimeSettings()
+	}
+	return *c.Settings
+}
+
+// SubCategoryMappingRule is a rule applied to new expenses whose name matches the pattern.
+// A rule can set the category and subcategory, rename the expense, add tags, and set the account.
 type SubCategoryMappingRule struct {
-	Pattern     string `json:"pattern"`
-	MatchType   string `json:"matchType"`
-	Category    string `json:"category"`
-	SubCategory string `json:"subCategory"`
+	Pattern     string   `json:"pattern"`
+	MatchType   string   `json:"matchType"` // exact, contains, or regex
+	Category    string   `json:"category"`
+	SubCategory string   `json:"subCategory"`
+	Rename      string   `json:"rename,omitempty"`   // e.g., "AMZN Mktp US*2A4" -> "Amazon"
+	Tags        []string `json:"tags,omitempty"`     // added to the expense's tags
+	Account     string   `json:"account,omitempty"`  // e.g., "Visa", "Checking"
+	Priority    int      `json:"priority,omitempty"` // higher priority rules are applied first
+	Disabled    bool     `json:"disabled,omitempty"`
 }
 
 type RecurringExpense struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Amount      float64   `json:"amount"`
-	Currency    string    `json:"currency"`
-	Tags        []string  `json:"tags"`
-	Category    string    `json:"category"`
-	StartDate   time.Time `json:"startDate"`   // date of the first occurrence
-	Interval    string    `json:"interval"`    // daily, weekly, monthly, yearly
-	Occurrences int       `json:"occurrences"` // 0 for 3000 occurrences (heuristic)
+	ID          string              `json:"id"`
+	Name        string              `json:"name"`
+	Amount      float64             `json:"amount"`
+	Currency    string              `json:"currency"`
+	Tags        []string            `json:"tags"`
+	Category    string              `json:"category"`
+	StartDate   time.Time           `json:"startDate"`           // date of the first occurrence
+	Interval    string              `json:"interval"`            // daily, weekly, monthly, yearly
+	Occurrences int                 `json:"occurrences"`         // 0 to generate until the end date
+	EndDate     *time.Time          `json:"endDate,omitempty"`   // optional date of the last possible occurrence
+	Overrides   []RecurringOverride `json:"overrides,omitempty"` // per-occurrence changes kept across edits
+	CreatedAt   *time.Time          `json:"createdAt,omitempty"` // set by the store, nil for rules older than tracking
+	UpdatedAt   *time.Time          `json:"updatedAt,omitempty"`
+}
+
+// RecurringOverride changes a single occurrence of a recurring expense without detaching it from the rule
+type RecurringOverride struct {
+	Date   string     `json:"date"`             // YYYY-MM-DD the occurrence is scheduled on, in the start date's location
+	Skip   bool       `json:"skip,omitempty"`   // the occurrence is not generated
+	Amount *float64   `json:"amount,omitempty"` // replaces the rule's amount
+	MoveTo *time.Time `json:"moveTo,omitempty"` // replaces the scheduled date
+}
+
+// occurrenceDateLayout formats the scheduled day that identifies an occurrence
+const occurrenceDateLayout = "2006-01-02"
+
+// OccurrenceDate returns the scheduled day a recurring expense's occurrence is identified by
+func (e *RecurringExpense) OccurrenceDate(date time.Time) string {
+	return date.In(e.StartDate.Location()).Format(occurrenceDateLayout)
+}
+
+// OccurrenceOf returns the scheduled day of an instance of the recurring expense; instances from before
+// it was recorded use their date
+func (e *RecurringExpense) OccurrenceOf(expense Expense) string {
+	if expense.Occurrence != "" {
+		return expense.Occurrence
+	}
+	return e.OccurrenceDate(expense.Date)
+}
+
+// Override returns the override for the occurrence scheduled on date, if there is one
+func (e *RecurringExpense) Override(date string) (RecurringOverride, bool) {
+	for _, o := range e.Overrides {
+		if o.Date == date {
+			return o, true
+		}
+	}
+	return RecurringOverride{}, false
 }
 
 type BackendType string
@@ -90,6 +777,7 @@ type BackendType string
 const (
 	BackendTypeJSON     BackendType = "json"
 	BackendTypePostgres BackendType = "postgres"
+	BackendTypeMemory   BackendType = "memory" // demo mode, nothing is saved
 )
 
 // config for the storage backend
@@ -99,29 +787,355 @@ type SystemConfig struct {
 	StorageUser string
 	StoragePass string
 	StorageSSL  string
+	Tenant      string // household whose data this is, empty without multi-tenancy
+	// Postgres connection pool
+	StorageMaxOpenConns    int           // 0 for no limit
+	StorageMaxIdleConns    int           // kept open between bursts
+	StorageConnMaxLifetime time.Duration // 0 to keep connections open
 }
 
 // expense struct
 type Expense struct {
-	ID          string    `json:"id"`
-	RecurringID string    `json:"recurringID"`
-	Name        string    `json:"name"`
-	Tags        []string  `json:"tags"`
-	Category    string    `json:"category"`
-	SubCategory string    `json:"subCategory"`
-	Amount      float64   `json:"amount"`
-	Currency    string    `json:"currency"`
-	Date        time.Time `json:"date"`
+	ID          string         `json:"id"`
+	RecurringID string         `json:"recurringID"`
+	Name        string         `json:"name"`
+	Tags        []string       `json:"tags"`
+	Category    string         `json:"category"`
+	SubCategory string         `json:"subCategory"`
+	Account     string         `json:"account,omitempty"`
+	Notes       string         `json:"notes,omitempty"` // free-text details, kept separate from the name
+	Location    *Location      `json:"location,omitempty"`
+	Travel      *TravelDetails `json:"travel,omitempty"` // mileage or per-diem details; the amount is computed from them
+	Tax         *TaxDetails    `json:"tax,omitempty"`    // tax (e.g., VAT) included in the amount
+	Class       string         `json:"class,omitempty"`  // business, reimbursable, or reimbursed; personal when empty
+	Project     string         `json:"project,omitempty"`
+	Excluded    bool           `json:"excluded,omitempty"` // left out of reports and budgets, e.g., a transfer
+	RefundOf    string         `json:"refundOf,omitempty"` // ID of the expense a positive amount gives money back for
+	Reconciled  bool           `json:"reconciled,omitempty"` // matched against a statement of its account
+	Amount      float64        `json:"amount"`
+	Currency    string         `json:"currency"`
+	Date        time.Time      `json:"date"`
+	Occurrence  string         `json:"occurrence,omitempty"` // YYYY-MM-DD an instance of a recurring expense is scheduled on
+	Edited      bool           `json:"edited,omitempty"`     // an instance changed by hand, kept as is when its rule is edited
+	Metadata    Metadata       `json:"metadata,omitempty"`   // set by external tools, kept when an update leaves it out
+	CreatedAt   *time.Time     `json:"createdAt,omitempty"`  // when it was recorded, set by the store; nil for expenses older than tracking
+	UpdatedAt   *time.Time     `json:"updatedAt,omitempty"`  // when it was last written, set by the store
+}
+
+// Expense classes keep business and personal spending apart in one instance
+const (
+	ClassPersonal     = "personal"
+	ClassBusiness     = "business"
+	ClassReimbursable = "reimbursable" // paid on someone else's behalf and owed back
+	ClassReimbursed   = "reimbursed"   // reimbursable and paid back
+)
+
+// ExpenseClasses are the valid classes of an expense
+var ExpenseClasses = []string{ClassPersonal, ClassBusiness, ClassReimbursable, ClassReimbursed}
+
+// IsRefund reports whether the expense is money back for another expense; reports count refunds
+// as negative spending of the original's category rather than as income
+func (e Expense) IsRefund() bool {
+	return e.RefundOf != ""
+}
+
+// ExpenseClass is the class of an expense, personal when it has none
+func (e Expense) ExpenseClass() string {
+	if e.Class == "" {
+		return ClassPersonal
+	}
+	return e.Class
+}
+
+// Metadata is what external tools attach to an expense (e.g., a bank transaction ID), as
+// namespace -> key -> value so tools don't overwrite each other
+type Metadata map[string]map[string]string
+
+// MonthlyAggregate totals the expenses of a category and subcategory in a calendar month (UTC);
+// stores keep these up to date on every write, so summaries don't have to scan all expenses
+type MonthlyAggregate struct {
+	Period      string  `json:"period"` // YYYY-MM
+	Category    string  `json:"category"`
+	SubCategory string  `json:"subCategory"`
+	Income      float64 `json:"income"`   // positive amounts
+	Expenses    float64 `json:"expenses"` // negative amounts, as an absolute value
+}
+
+// AggregateMonthly totals expenses by calendar month, category, and subcategory, leaving out the
+// expenses excluded from reports; refunds are subtracted from the expenses
+func AggregateMonthly(expenses []Expense) []MonthlyAggregate {
+	index := make(map[[3]string]int)
+	aggregates := []MonthlyAggregate{}
+	for _, expense := range expenses {
+		if expense.Excluded {
+			continue
+		}
+		key := [3]string{expense.Date.UTC().Format("2006-01"), expense.Category, expense.SubCategory}
+		i, ok := index[key]
+		if !ok {
+			i = len(aggregates)
+			index[key] = i
+			aggregates = append(aggregates, MonthlyAggregate{Period: key[0], Category: key[1], SubCategory: key[2]})
+		}
+		if expense.Amount >= 0 && !expense.IsRefund() {
+			aggregates[i].Income += expense.Amount
+		} else {
+			aggregates[i].Expenses -= expense.Amount
+		}
+	}
+	return aggregates
+}
+
+// ExpenseChange is the latest change of an expense. Stores give every write a revision from one
+// counter, so clients can ask for what changed after the last revision they saw; a deleted
+// expense keeps its change as a tombstone, so the deletion reaches clients too.
+type ExpenseChange struct {
+	ID              string     `json:"id"`
+	Revision        int64      `json:"revision"`        // 0 for an expense the store never saw
+	CreatedRevision int64      `json:"createdRevision"` // of the change that added the expense
+	UpdatedAt       time.Time  `json:"updatedAt"`
+	DeletedAt       *time.Time `json:"deletedAt,omitempty"`
+	Expense         *Expense   `json:"expense,omitempty"` // nil for a deleted expense
+}
+
+// ConfigChange is the latest change of a config record, a top-level field of the config such as
+// "categories" or "budgets", numbered from the same counter as expense changes. Recurring
+// expenses aren't tracked, since the database backend keeps them outside the config.
+type ConfigChange struct {
+	Key             string     `json:"key"`
+	Revision        int64      `json:"revision"`
+	CreatedRevision int64      `json:"createdRevision"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+	DeletedAt       *time.Time `json:"deletedAt,omitempty"`
+}
+
+// configHashes fingerprints the stored form of each config record, to tell which ones a write changed
+func configHashes(config *Config) (map[string]string, error) {
+	content, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var records map[string]json.RawMessage
+	if err := json.Unmarshal(content, &records); err != nil {
+		return nil, err
+	}
+	delete(records, "recurringExpenses")
+	hashes := make(map[string]string, len(records))
+	for key, value := range records {
+		hashes[key] = changeHash(value)
+	}
+	return hashes, nil
+}
+
+// changeHash fingerprints a record for change tracking
+func changeHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:16])
+}
+
+// Location is where an expense happened: coordinates, a place name, or both
+type Location struct {
+	Latitude  *float64 `json:"lat,omitempty"`
+	Longitude *float64 `json:"lon,omitempty"`
+	Place     string   `json:"place,omitempty"` // e.g., "Lisbon" or "Blue Bottle, Hayes Valley"
+}
+
+// HasCoordinates reports whether the location can be placed on a map
+func (l *Location) HasCoordinates() bool {
+	return l != nil && l.Latitude != nil && l.Longitude != nil
+}
+
+// Validate checks coordinate ranges and sanitizes the place name
+func (l *Location) Validate() error {
+	if (l.Latitude == nil) != (l.Longitude == nil) {
+		return fmt.Errorf("location needs both 'lat' and 'lon'")
+	}
+	if l.Latitude != nil && (*l.Latitude < -90 || *l.Latitude > 90) {
+		return fmt.Errorf("location 'lat' must be between -90 and 90")
+	}
+	if l.Longitude != nil && (*l.Longitude < -180 || *l.Longitude > 180) {
+		return fmt.Errorf("location 'lon' must be between -180 and 180")
+	}
+	l.Place = SanitizeString(l.Place)
+	if utf8.RuneCountInString(l.Place) > 255 {
+		return fmt.Errorf("location 'place' cannot be longer than 255 characters")
+	}
+	return nil
 }
 
 func (c *Config) SetBaseConfig() {
-	c.Categories = defaultCategories
+	c.Categories = slices.Clone(defaultCategories)
+	c.CategoryMeta = make(map[string]CategoryMeta)
+	c.Archived = []string{}
 	c.SubCategories = make(map[string][]string)
 	c.SubCategoryMap = []SubCategoryMappingRule{}
 	c.Currency = "usd"
 	c.StartDate = 1
 	// c.Tags = []string{}
 	c.RecurringExpenses = []RecurringExpense{}
+	c.Budgets = make(map[string]float64)
+	c.Period = periods.Config{Type: periods.Monthly}
+	c.Reports = ReportSettings{Recipients: []string{}}
+	c.MerchantAliases = make(map[string]string)
+	c.TravelRates = TravelRates{DistanceUnit: "km"}
+	c.FiscalYearStart = 1
+	c.Shares = []Share{}
+	c.Accounts = []Account{}
+	c.Holdings = []Holding{}
+	c.PlannedExpenses = []PlannedExpense{}
+	c.SavedReports = []SavedReport{}
+	c.PlaidItems = []PlaidItem{}
+	c.Projects = []Project{}
+	c.TRMNLProfiles = []TRMNLProfile{}
+	c.VoiceKeys = []VoiceKey{}
+	c.WalletDevices = []WalletDevice{}
+}
+
+// SetCategories replaces the active categories; re-added categories are no longer archived
+func (c *Config) SetCategories(categories []string) {
+	c.Categories = categories
+	c.Archived = slices.DeleteFunc(slices.Clone(c.Archived), func(a string) bool {
+		return slices.Contains(categories, a)
+	})
+}
+
+// SetArchived moves a category between the active and archived lists
+func (c *Config) SetArchived(category string, archived bool) error {
+	active := slices.Contains(c.Categories, category)
+	isArchived := slices.Contains(c.Archived, category)
+	if archived {
+		if isArchived {
+			return nil
+		}
+		if !active {
+			return fmt.Errorf("category '%s' not found", category)
+		}
+		if len(c.Categories) == 1 {
+			return fmt.Errorf("at least one active category is required")
+		}
+		c.Categories = slices.DeleteFunc(c.Categories, func(a string) bool { return a == category })
+		c.Archived = append(c.Archived, category)
+		return nil
+	}
+	if !isArchived {
+		if active {
+			return nil
+		}
+		return fmt.Errorf("archived category '%s' not found", category)
+	}
+	c.Archived = slices.DeleteFunc(c.Archived, func(a string) bool { return a == category })
+	c.Categories = append(c.Categories, category)
+	return nil
+}
+
+// ReassignCategory moves everything in the config from one category to another. With merge,
+// the target must already exist and the source is removed; otherwise the source is renamed in place.
+// Expenses are updated by the caller.
+func (c *Config) ReassignCategory(from string, to string, merge bool) error {
+	if from == to {
+		return fmt.Errorf("source and target category are the same")
+	}
+	fromIdx := slices.Index(c.Categories, from)
+	fromArchived := slices.Index(c.Archived, from)
+	if fromIdx < 0 && fromArchived < 0 {
+		return fmt.Errorf("category '%s' not found", from)
+	}
+	targetExists := slices.Contains(c.Categories, to) || slices.Contains(c.Archived, to)
+	if merge && !targetExists {
+		return fmt.Errorf("category '%s' not found", to)
+	}
+	if !merge && targetExists {
+		return fmt.Errorf("category '%s' already exists, merge instead", to)
+	}
+
+	if merge {
+		if fromIdx >= 0 {
+			c.Categories = slices.Delete(c.Categories, fromIdx, fromIdx+1)
+		} else {
+			c.Archived = slices.Delete(c.Archived, fromArchived, fromArchived+1)
+		}
+	} else if fromIdx >= 0 {
+		c.Categories[fromIdx] = to
+	} else {
+		c.Archived[fromArchived] = to
+	}
+
+	if subCategories, ok := c.SubCategories[from]; ok {
+		for _, sc := range subCategories {
+			if !slices.Contains(c.SubCategories[to], sc) {
+				c.SubCategories[to] = append(c.SubCategories[to], sc)
+			}
+		}
+		delete(c.SubCategories, from)
+	}
+	for i := range c.SubCategoryMap {
+		if c.SubCategoryMap[i].Category == from {
+			c.SubCategoryMap[i].Category = to
+		}
+	}
+	// the target keeps its own budget and display settings when merging
+	if limit, ok := c.Budgets[from]; ok {
+		if _, exists := c.Budgets[to]; !exists {
+			c.Budgets[to] = limit
+		}
+		delete(c.Budgets, from)
+	}
+	if meta, ok := c.CategoryMeta[from]; ok {
+		if _, exists := c.CategoryMeta[to]; !exists {
+			c.CategoryMeta[to] = meta
+		}
+		delete(c.CategoryMeta, from)
+	}
+	for i := range c.RecurringExpenses {
+		if c.RecurringExpenses[i].Category == from {
+			c.RecurringExpenses[i].Category = to
+		}
+	}
+	return nil
+}
+
+// MergeSubCategoryConfig removes source from the category's subcategories and points its mapping rules at target
+func (c *Config) MergeSubCategoryConfig(category string, source string, target string) error {
+	if source == target {
+		return fmt.Errorf("source and target subcategory are the same")
+	}
+	subCategories := c.SubCategories[category]
+	if !slices.Contains(subCategories, source) {
+		return fmt.Errorf("subcategory '%s' not found in category '%s'", source, category)
+	}
+	if !slices.Contains(subCategories, target) {
+		return fmt.Errorf("subcategory '%s' not found in category '%s'", target, category)
+	}
+	c.SubCategories[category] = slices.DeleteFunc(subCategories, func(sc string) bool { return sc == source })
+	for i := range c.SubCategoryMap {
+		if c.SubCategoryMap[i].Category == category && c.SubCategoryMap[i].SubCategory == source {
+			c.SubCategoryMap[i].SubCategory = target
+		}
+	}
+	return nil
+}
+
+// PeriodConfig returns the period settings with the monthly start day filled in
+func (c *Config) PeriodConfig() periods.Config {
+	period := c.Period
+	if period.Type == "" {
+		period.Type = periods.Monthly
+	}
+	period.StartDay = c.StartDate
+	return period
+}
+
+// SetPeriod stores a validated period, moving a monthly start day into StartDate
+func (c *Config) SetPeriod(period periods.Config) error {
+	if err := period.Validate(); err != nil {
+		return err
+	}
+	if period.Type == periods.Monthly {
+		c.StartDate = period.StartDay
+	}
+	period.StartDay = 0
+	c.Period = period
+	return nil
 }
 
 func (c *SystemConfig) SetStorageConfig() {
@@ -130,6 +1144,27 @@ func (c *SystemConfig) SetStorageConfig() {
 	c.StorageSSL = backendSSLFromEnv(os.Getenv("STORAGE_SSL"))
 	c.StorageUser = os.Getenv("STORAGE_USER")
 	c.StoragePass = os.Getenv("STORAGE_PASS")
+	c.StorageMaxOpenConns = intFromEnv(os.Getenv("STORAGE_MAX_OPEN_CONNS"), 10)
+	c.StorageMaxIdleConns = intFromEnv(os.Getenv("STORAGE_MAX_IDLE_CONNS"), 5)
+	c.StorageConnMaxLifetime = durationFromEnv(os.Getenv("STORAGE_CONN_MAX_LIFETIME"), 30*time.Minute)
+}
+
+// intFromEnv parses a non-negative number, falling back to the default when unset or invalid
+func intFromEnv(env string, fallback int) int {
+	value, err := strconv.Atoi(env)
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// durationFromEnv parses a duration such as 30m, falling back to the default when unset or invalid
+func durationFromEnv(env string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(env)
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
 }
 
 func backendTypeFromEnv(env string) BackendType {
@@ -138,6 +1173,8 @@ func backendTypeFromEnv(env string) BackendType {
 		return BackendTypeJSON
 	case "postgres":
 		return BackendTypePostgres
+	case "memory":
+		return BackendTypeMemory
 	default:
 		return BackendTypeJSON
 	}
@@ -163,13 +1200,57 @@ func backendSSLFromEnv(env string) string {
 func InitializeStorage() (Storage, error) {
 	baseConfig := SystemConfig{}
 	baseConfig.SetStorageConfig()
+	return initializeStorage(baseConfig)
+}
+
+// RETenantName matches tenant names, which are also used as subdomains, directories, and schemas
+var RETenantName *regexp.Regexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,30}[a-z0-9])?$`)
+
+// InitializeTenantStorage initializes the storage of one tenant, isolated from the others: the
+// JSON backend keeps its files in tenants/<name> under STORAGE_URL, and PostgreSQL keeps its
+// tables in the schema tenant_<name> (with "-" as "_") of the same database. Each tenant has its
+// own connection pool, so the limits are split between the tenants, of which there are count.
+func InitializeTenantStorage(tenant string, count int) (Storage, error) {
+	if !RETenantName.MatchString(tenant) {
+		return nil, fmt.Errorf("invalid tenant name '%s'", tenant)
+	}
+	baseConfig := SystemConfig{}
+	baseConfig.SetStorageConfig()
+	baseConfig.Tenant = tenant
+	baseConfig.StorageMaxOpenConns = splitConnLimit(baseConfig.StorageMaxOpenConns, count)
+	baseConfig.StorageMaxIdleConns = splitConnLimit(baseConfig.StorageMaxIdleConns, count)
+	if baseConfig.StorageType == BackendTypeJSON {
+		baseConfig.StorageURL = filepath.Join(baseConfig.StorageURL, "tenants", tenant)
+	}
+	return initializeStorage(baseConfig)
+}
+
+// splitConnLimit is a tenant's share of a connection limit, at least one; 0 stays no limit
+func splitConnLimit(limit int, count int) int {
+	if limit == 0 || count < 2 {
+		return limit
+	}
+	return max(1, limit/count)
+}
+
+func initializeStorage(baseConfig SystemConfig) (Storage, error) {
+	var store Storage
+	var err error
 	switch baseConfig.StorageType {
 	case BackendTypeJSON:
-		return InitializeJsonStore(baseConfig)
+		store, err = InitializeJsonStore(baseConfig)
 	case BackendTypePostgres:
-		return InitializePostgresStore(baseConfig)
+		store, err = InitializePostgresStore(baseConfig)
+	case BackendTypeMemory:
+		log.Println("Using in-memory storage, data is lost when the server stops")
+		store, err = NewMemoryStore()
+	default:
+		return nil, fmt.Errorf("invalid data store: %s", baseConfig.StorageType)
+	}
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("invalid data store: %s", baseConfig.StorageType)
+	return withSecrets(store)
 }
 
 var REInvalidChars *regexp.Regexp = regexp.MustCompile(`[^\p{L}\p{N}\s.,\-'_!"&]`)
@@ -190,20 +1271,83 @@ func ValidateCategory(category string) (string, error) {
 	return sanitized, nil
 }
 
+// MaxNotesLength is the maximum length of expense notes, in characters
+const MaxNotesLength = 1000
+
+// Limits of expense metadata
+const (
+	MaxMetadataNamespaces  = 16
+	MaxMetadataKeys        = 32 // per namespace
+	MaxMetadataValueLength = 1000
+)
+
+// REMetadataName matches metadata namespaces and keys, e.g., "splitwise" or "transaction_id"
+var REMetadataName *regexp.Regexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+// Validate checks the names and sizes, and drops empty namespaces
+func (m Metadata) Validate() error {
+	for namespace, values := range m {
+		if !REMetadataName.MatchString(namespace) {
+			return fmt.Errorf("invalid metadata namespace '%s'", namespace)
+		}
+		if len(values) == 0 {
+			delete(m, namespace)
+			continue
+		}
+		if len(values) > MaxMetadataKeys {
+			return fmt.Errorf("metadata namespace '%s' cannot have more than %d keys", namespace, MaxMetadataKeys)
+		}
+		for key, value := range values {
+			if !REMetadataName.MatchString(key) {
+				return fmt.Errorf("invalid metadata key '%s' in '%s'", key, namespace)
+			}
+			if utf8.RuneCountInString(value) > MaxMetadataValueLength {
+				return fmt.Errorf("metadata '%s.%s' cannot be longer than %d characters", namespace, key, MaxMetadataValueLength)
+			}
+		}
+	}
+	if len(m) > MaxMetadataNamespaces {
+		return fmt.Errorf("an expense cannot have more than %d metadata namespaces", MaxMetadataNamespaces)
+	}
+	return nil
+}
+
 func (e *Expense) Validate() error {
 	e.Name = SanitizeString(e.Name)
 	if e.Name == "" {
-		return fmt.Errorf("expense 'name' cannot be empty")
+		return invalidField("name", fmt.Errorf("expense 'name' cannot be empty"))
 	}
+	e.Category = SanitizeString(e.Category)
 	if e.Category == "" {
-		return fmt.Errorf("expense 'category' cannot be empty")
+		return invalidField("category", fmt.Errorf("expense 'category' cannot be empty"))
+	}
+	if e.Travel != nil {
+		if err := e.Travel.Validate(); err != nil {
+			return invalidField("travel", err)
+		}
+		e.Amount = -e.Travel.Amount()
 	}
 	if e.Amount == 0 {
-		return fmt.Errorf("expense 'amount' cannot be 0")
+		return invalidField("amount", fmt.Errorf("expense 'amount' cannot be 0"))
+	}
+	if e.Tax != nil && e.Tax.Rate == 0 && e.Tax.Amount == 0 {
+		e.Tax = nil
+	}
+	if e.Tax != nil {
+		if err := e.Tax.Validate(e.Amount); err != nil {
+			return invalidField("tax", err)
+		}
 	}
 	// if e.Currency == "" {
 	// 	return fmt.Errorf("expense 'currency' cannot be empty")
 	// }
+	// personal is the default, so it isn't stored
+	if e.Class = strings.ToLower(strings.TrimSpace(e.Class)); e.Class == ClassPersonal {
+		e.Class = ""
+	}
+	if e.Class != "" && !slices.Contains(ExpenseClasses, e.Class) {
+		return invalidField("class", fmt.Errorf("invalid expense 'class' '%s', expected personal, business, reimbursable, or reimbursed", e.Class))
+	}
 	if len(e.Tags) > 0 {
 		var cleanedTags []string
 		for _, tag := range e.Tags {
@@ -218,8 +1362,29 @@ func (e *Expense) Validate() error {
 	if e.SubCategory != "" {
 		e.SubCategory = SanitizeString(e.SubCategory)
 	}
+	e.Project = SanitizeString(e.Project)
+	e.Account = SanitizeString(e.Account)
+	if e.RefundOf = strings.TrimSpace(e.RefundOf); e.RefundOf != "" && e.Amount < 0 {
+		return invalidField("amount", fmt.Errorf("a refund must be a positive amount"))
+	}
+	// Notes are free text, so only surrounding whitespace is removed
+	e.Notes = strings.TrimSpace(e.Notes)
+	if utf8.RuneCountInString(e.Notes) > MaxNotesLength {
+		return invalidField("notes", fmt.Errorf("expense 'notes' cannot be longer than %d characters", MaxNotesLength))
+	}
+	if e.Location != nil {
+		if err := e.Location.Validate(); err != nil {
+			return invalidField("location", err)
+		}
+		if !e.Location.HasCoordinates() && e.Location.Place == "" {
+			e.Location = nil
+		}
+	}
 	if e.Date.IsZero() {
-		return fmt.Errorf("expense 'date' cannot be empty")
+		return invalidField("date", fmt.Errorf("expense 'date' cannot be empty"))
+	}
+	if err := e.Metadata.Validate(); err != nil {
+		return invalidField("metadata", err)
 	}
 	return nil
 }
@@ -227,10 +1392,11 @@ func (e *Expense) Validate() error {
 func (e *RecurringExpense) Validate() error {
 	e.Name = SanitizeString(e.Name)
 	if e.Name == "" {
-		return fmt.Errorf("recurring expense 'name' cannot be empty")
+		return invalidField("name", fmt.Errorf("recurring expense 'name' cannot be empty"))
 	}
+	e.Category = SanitizeString(e.Category)
 	if e.Category == "" {
-		return fmt.Errorf("recurring expense 'category' cannot be empty")
+		return invalidField("category", fmt.Errorf("recurring expense 'category' cannot be empty"))
 	}
 	if len(e.Tags) > 0 {
 		var cleanedTags []string
@@ -242,11 +1408,20 @@ func (e *RecurringExpense) Validate() error {
 		}
 		e.Tags = cleanedTags
 	}
-	if e.Occurrences < 2 {
-		return fmt.Errorf("at least 2 occurences required to recur")
+	if e.EndDate != nil && e.EndDate.IsZero() {
+		e.EndDate = nil
+	}
+	if e.EndDate == nil && e.Occurrences < 2 {
+		return invalidField("occurrences", fmt.Errorf("at least 2 occurences required to recur"))
+	}
+	if e.EndDate != nil && (e.Occurrences < 0 || e.Occurrences == 1) {
+		return invalidField("occurrences", fmt.Errorf("occurrences must be 0 (until end date) or at least 2"))
 	}
 	if e.StartDate.IsZero() {
-		return fmt.Errorf("start date for recurring expense must be specified")
+		return invalidField("startDate", fmt.Errorf("start date for recurring expense must be specified"))
+	}
+	if e.EndDate != nil && !e.EndDate.After(e.StartDate) {
+		return invalidField("endDate", fmt.Errorf("end date for recurring expense must be after the start date"))
 	}
 	validIntervals := map[string]bool{
 		"daily":   true,
@@ -255,11 +1430,415 @@ func (e *RecurringExpense) Validate() error {
 		"yearly":  true,
 	}
 	if !validIntervals[e.Interval] {
-		return fmt.Errorf("invalid interval: '%s'. Must be one of 'daily', 'weekly', 'monthly', or 'yearly'", e.Interval)
+		return invalidField("interval", fmt.Errorf("invalid interval: '%s'. Must be one of 'daily', 'weekly', 'monthly', or 'yearly'", e.Interval))
+	}
+	seen := make(map[string]bool)
+	for _, o := range e.Overrides {
+		if _, err := time.Parse(occurrenceDateLayout, o.Date); err != nil {
+			return invalidField("overrides", fmt.Errorf("invalid override date '%s', expected YYYY-MM-DD", o.Date))
+		}
+		if seen[o.Date] {
+			return invalidField("overrides", fmt.Errorf("more than one override for %s", o.Date))
+		}
+		seen[o.Date] = true
+		if o.MoveTo != nil && o.MoveTo.IsZero() {
+			return invalidField("overrides", fmt.Errorf("override for %s has an empty date to move to", o.Date))
+		}
+	}
+	return nil
+}
+
+var REHexColor *regexp.Regexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ValidateCategoryMeta checks colors are hex codes and icons are short and free of markup
+func ValidateCategoryMeta(meta map[string]CategoryMeta) error {
+	for category, m := range meta {
+		if SanitizeString(category) == "" {
+			return fmt.Errorf("category name cannot be empty")
+		}
+		if m.Color != "" && !REHexColor.MatchString(m.Color) {
+			return fmt.Errorf("invalid color '%s' for '%s', expected a hex code like #FF6B6B", m.Color, category)
+		}
+		if utf8.RuneCountInString(m.Icon) > 8 || strings.ContainsAny(m.Icon, `<>&"'`) {
+			return fmt.Errorf("invalid icon for '%s', use an emoji or a short symbol", category)
+		}
+		if m.Order < 0 {
+			return fmt.Errorf("order for '%s' cannot be negative", category)
+		}
+		switch m.Rollover {
+		case RolloverNone, RolloverUnused, RolloverOverspend, RolloverBoth:
+		default:
+			return fmt.Errorf("invalid rollover '%s' for '%s', must be unused, overspend, or both", m.Rollover, category)
+		}
+		if m.MaxAmount < 0 {
+			return fmt.Errorf("transaction limit for '%s' cannot be negative", category)
+		}
+		if m.TaxRate < 0 || m.TaxRate > 100 {
+			return fmt.Errorf("tax rate for '%s' must be a percent from 0 to 100", category)
+		}
 	}
 	return nil
 }
 
+// ValidateBudgets checks that every budget has a category and a positive limit
+func ValidateBudgets(budgets map[string]float64) error {
+	for category, limit := range budgets {
+		if SanitizeString(category) == "" {
+			return fmt.Errorf("budget category cannot be empty")
+		}
+		if limit <= 0 {
+			return fmt.Errorf("budget for '%s' must be greater than 0", category)
+		}
+	}
+	return nil
+}
+
+// ValidateReportSettings checks recipient addresses and normalizes them to bare addresses
+func ValidateReportSettings(settings *ReportSettings) error {
+	recipients := make([]string, 0, len(settings.Recipients))
+	for _, recipient := range settings.Recipients {
+		if strings.TrimSpace(recipient) == "" {
+			continue
+		}
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("invalid email address '%s'", recipient)
+		}
+		if !slices.Contains(recipients, address.Address) {
+			recipients = append(recipients, address.Address)
+		}
+	}
+	if settings.Enabled && len(recipients) == 0 {
+		return fmt.Errorf("at least one recipient is required to enable reports")
+	}
+	if settings.Language != "" && !i18n.Supported(settings.Language) {
+		return fmt.Errorf("unsupported language '%s'", settings.Language)
+	}
+	settings.Recipients = recipients
+	return nil
+}
+
+// payment processor prefixes on card statements, e.g., "SQ *Blue Bottle"
+var merchantPrefixes = []string{"sq", "tst", "sp", "pp", "paypal", "dd", "ic"}
+
+// NormalizeMerchant reduces an expense name to a merchant key by dropping payment processor
+// prefixes, reference numbers, and punctuation, e.g., "AMZN Mktp US*2A4" -> "amzn mktp us"
+func NormalizeMerchant(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if prefix, rest, ok := strings.Cut(name, "*"); ok && slices.Contains(merchantPrefixes, strings.TrimSpace(prefix)) {
+		name = rest
+	}
+	words := []string{}
+	fields := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '&' && r != '\''
+	})
+	for _, word := range fields {
+		// store numbers and references like "#1234" or "2A4", but keep short numbers as in "7-Eleven"
+		digits := 0
+		for _, r := range word {
+			if unicode.IsDigit(r) {
+				digits++
+			}
+		}
+		if digits > 0 && (digits < utf8.RuneCountInString(word) || digits > 2) {
+			continue
+		}
+		words = append(words, word)
+	}
+	if len(words) == 0 {
+		return strings.Join(strings.Fields(name), " ")
+	}
+	return strings.Join(words, " ")
+}
+
+// ValidateMerchantAliases normalizes alias keys and resolves chains, so every alias points at a merchant that isn't an alias itself
+func ValidateMerchantAliases(aliases map[string]string) (map[string]string, error) {
+	normalized := make(map[string]string, len(aliases))
+	for alias, merchant := range aliases {
+		key := NormalizeMerchant(alias)
+		merchant = SanitizeString(merchant)
+		if key == "" {
+			return nil, fmt.Errorf("merchant alias cannot be empty")
+		}
+		if merchant == "" {
+			return nil, fmt.Errorf("merchant for alias '%s' cannot be empty", alias)
+		}
+		if key != NormalizeMerchant(merchant) {
+			normalized[key] = merchant
+		}
+	}
+	for key, merchant := range normalized {
+		seen := []string{key}
+		for {
+			next, ok := normalized[NormalizeMerchant(merchant)]
+			if !ok {
+				break
+			}
+			if slices.Contains(seen, NormalizeMerchant(merchant)) {
+				return nil, fmt.Errorf("merchant aliases for '%s' form a loop", merchant)
+			}
+			seen = append(seen, NormalizeMerchant(merchant))
+			merchant = next
+		}
+		normalized[key] = merchant
+	}
+	return normalized, nil
+}
+
+// ValidateAccounts checks the account names, types, and snapshots, and sorts the snapshots by date
+func ValidateAccounts(accounts []Account) error {
+	seen := make(map[string]bool, len(accounts))
+	for i := range accounts {
+		account := &accounts[i]
+		account.Name = SanitizeString(account.Name)
+		if account.Name == "" {
+			return fmt.Errorf("account name cannot be empty")
+		}
+		if seen[strings.ToLower(account.Name)] {
+			return fmt.Errorf("duplicate account '%s'", account.Name)
+		}
+		seen[strings.ToLower(account.Name)] = true
+		if account.Type != AccountAsset && account.Type != AccountLiability {
+			return fmt.Errorf("invalid type '%s' for account '%s', must be asset or liability", account.Type, account.Name)
+		}
+		if account.StatementDay < 0 || account.StatementDay > 31 {
+			return fmt.Errorf("invalid statement day %d for account '%s', must be between 1 and 31", account.StatementDay, account.Name)
+		}
+		if account.Snapshots == nil {
+			account.Snapshots = []BalanceSnapshot{}
+		}
+		dates := make(map[string]bool, len(account.Snapshots))
+		for _, snapshot := range account.Snapshots {
+			if _, err := time.Parse("2006-01-02", snapshot.Date); err != nil {
+				return fmt.Errorf("invalid snapshot date '%s' for account '%s', expected YYYY-MM-DD", snapshot.Date, account.Name)
+			}
+			if dates[snapshot.Date] {
+				return fmt.Errorf("account '%s' has more than one snapshot on %s", account.Name, snapshot.Date)
+			}
+			dates[snapshot.Date] = true
+			if math.IsNaN(snapshot.Balance) || math.IsInf(snapshot.Balance, 0) {
+				return fmt.Errorf("invalid balance on %s for account '%s'", snapshot.Date, account.Name)
+			}
+		}
+		slices.SortFunc(account.Snapshots, func(a, b BalanceSnapshot) int { return strings.Compare(a.Date, b.Date) })
+	}
+	return nil
+}
+
+// ValidateHoldings upper-cases the tickers, checks the amounts and prices, and sorts the prices by date
+func ValidateHoldings(holdings []Holding) error {
+	seen := make(map[string]bool, len(holdings))
+	for i := range holdings {
+		holding := &holdings[i]
+		holding.Ticker = strings.ToUpper(SanitizeString(holding.Ticker))
+		if holding.Ticker == "" || len(holding.Ticker) > 20 || strings.ContainsAny(holding.Ticker, " /?#") {
+			return fmt.Errorf("invalid ticker '%s'", holding.Ticker)
+		}
+		if seen[holding.Ticker] {
+			return fmt.Errorf("duplicate holding '%s'", holding.Ticker)
+		}
+		seen[holding.Ticker] = true
+		if holding.Quantity <= 0 || math.IsInf(holding.Quantity, 0) {
+			return fmt.Errorf("quantity of '%s' must be greater than 0", holding.Ticker)
+		}
+		if holding.CostBasis < 0 || math.IsInf(holding.CostBasis, 0) {
+			return fmt.Errorf("cost basis of '%s' cannot be negative", holding.Ticker)
+		}
+		if holding.Prices == nil {
+			holding.Prices = []PriceQuote{}
+		}
+		dates := make(map[string]bool, len(holding.Prices))
+		for _, quote := range holding.Prices {
+			if _, err := time.Parse("2006-01-02", quote.Date); err != nil {
+				return fmt.Errorf("invalid price date '%s' for '%s', expected YYYY-MM-DD", quote.Date, holding.Ticker)
+			}
+			if dates[quote.Date] {
+				return fmt.Errorf("'%s' has more than one price on %s", holding.Ticker, quote.Date)
+			}
+			dates[quote.Date] = true
+			if quote.Price <= 0 || math.IsInf(quote.Price, 0) {
+				return fmt.Errorf("price of '%s' on %s must be greater than 0", holding.Ticker, quote.Date)
+			}
+		}
+		slices.SortFunc(holding.Prices, func(a, b PriceQuote) int { return strings.Compare(a.Date, b.Date) })
+	}
+	return nil
+}
+
+// ValidatePlannedExpenses checks the names, amounts, and dates, and sorts the expenses by due date
+func ValidatePlannedExpenses(planned []PlannedExpense) error {
+	seen := make(map[string]bool, len(planned))
+	for i := range planned {
+		expense := &planned[i]
+		expense.Name = SanitizeString(expense.Name)
+		expense.Category = SanitizeString(expense.Category)
+		if expense.Name == "" {
+			return fmt.Errorf("planned expense name cannot be empty")
+		}
+		if seen[strings.ToLower(expense.Name)] {
+			return fmt.Errorf("duplicate planned expense '%s'", expense.Name)
+		}
+		seen[strings.ToLower(expense.Name)] = true
+		if expense.Amount <= 0 || math.IsInf(expense.Amount, 0) {
+			return fmt.Errorf("amount of '%s' must be greater than 0", expense.Name)
+		}
+		if expense.Saved < 0 || math.IsInf(expense.Saved, 0) {
+			return fmt.Errorf("saved amount of '%s' cannot be negative", expense.Name)
+		}
+		if _, err := time.Parse("2006-01-02", expense.Due); err != nil {
+			return fmt.Errorf("invalid due date '%s' for '%s', expected YYYY-MM-DD", expense.Due, expense.Name)
+		}
+		if _, err := time.Parse("2006-01-02", expense.Start); err != nil {
+			return fmt.Errorf("invalid start date '%s' for '%s', expected YYYY-MM-DD", expense.Start, expense.Name)
+		}
+		if expense.Start > expense.Due {
+			return fmt.Errorf("'%s' is due before saving for it starts", expense.Name)
+		}
+	}
+	slices.SortStableFunc(planned, func(a, b PlannedExpense) int { return strings.Compare(a.Due, b.Due) })
+	return nil
+}
+
+// ValidateProjects checks the project names and sorts the projects by name
+func ValidateProjects(projects []Project) error {
+	seen := make(map[string]bool, len(projects))
+	for i := range projects {
+		project := &projects[i]
+		project.Name = SanitizeString(project.Name)
+		project.Client = SanitizeString(project.Client)
+		if project.Name == "" {
+			return fmt.Errorf("project name cannot be empty")
+		}
+		if seen[strings.ToLower(project.Name)] {
+			return fmt.Errorf("duplicate project '%s'", project.Name)
+		}
+		seen[strings.ToLower(project.Name)] = true
+	}
+	slices.SortStableFunc(projects, func(a, b Project) int { return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) })
+	return nil
+}
+
+// ValidateTRMNLProfiles checks the profile names and tokens, and sorts the profiles by name; the
+// options are checked by the API, which parses them
+func ValidateTRMNLProfiles(profiles []TRMNLProfile) error {
+	seen := make(map[string]bool, len(profiles))
+	for i := range profiles {
+		profile := &profiles[i]
+		profile.Name = SanitizeString(profile.Name)
+		if profile.Name == "" {
+			return fmt.Errorf("profile name cannot be empty")
+		}
+		if len(profile.Name) > 40 {
+			return fmt.Errorf("profile name '%s' is longer than 40 characters", profile.Name)
+		}
+		if seen[strings.ToLower(profile.Name)] {
+			return fmt.Errorf("duplicate profile '%s'", profile.Name)
+		}
+		seen[strings.ToLower(profile.Name)] = true
+		if profile.TokenHash == "" {
+			return fmt.Errorf("profile '%s' has no token", profile.Name)
+		}
+	}
+	slices.SortStableFunc(profiles, func(a, b TRMNLProfile) int { return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) })
+	return nil
+}
+
+// ValidateVoiceKeys checks the key names and hashes, and sorts the keys by name
+func ValidateVoiceKeys(keys []VoiceKey) error {
+	seen := make(map[string]bool, len(keys))
+	for i := range keys {
+		key := &keys[i]
+		key.Name = SanitizeString(key.Name)
+		if key.Name == "" {
+			return fmt.Errorf("voice key name cannot be empty")
+		}
+		if len(key.Name) > 40 {
+			return fmt.Errorf("voice key name '%s' is longer than 40 characters", key.Name)
+		}
+		if seen[strings.ToLower(key.Name)] {
+			return fmt.Errorf("duplicate voice key '%s'", key.Name)
+		}
+		seen[strings.ToLower(key.Name)] = true
+		if key.KeyHash == "" {
+			return fmt.Errorf("voice key '%s' has no key", key.Name)
+		}
+	}
+	slices.SortStableFunc(keys, func(a, b VoiceKey) int { return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) })
+	return nil
+}
+
+// ValidateWalletDevices checks that each device and pass is registered once with a push token
+func ValidateWalletDevices(devices []WalletDevice) error {
+	seen := make(map[[2]string]bool, len(devices))
+	for _, device := range devices {
+		if device.DeviceID == "" || device.SerialNumber == "" || device.PushToken == "" {
+			return fmt.Errorf("wallet device registrations need a device, a pass, and a push token")
+		}
+		key := [2]string{device.DeviceID, device.SerialNumber}
+		if seen[key] {
+			return fmt.Errorf("duplicate registration of pass '%s' on device '%s'", device.SerialNumber, device.DeviceID)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// ValidateSavedReports checks the filters, options, and outputs, and sorts the reports by name
+func ValidateSavedReports(reports []SavedReport) error {
+	seen := make(map[string]bool, len(reports))
+	for i := range reports {
+		report := &reports[i]
+		report.Name = SanitizeString(report.Name)
+		if report.Name == "" {
+			return fmt.Errorf("report name cannot be empty")
+		}
+		if seen[strings.ToLower(report.Name)] {
+			return fmt.Errorf("duplicate report '%s'", report.Name)
+		}
+		seen[strings.ToLower(report.Name)] = true
+		if err := ValidateExpenseFilter(&report.Filter); err != nil {
+			return fmt.Errorf("invalid filter for '%s': %v", report.Name, err)
+		}
+		switch report.GroupBy {
+		case "", GroupByCategory, GroupBySubCategory, GroupByTag, GroupByAccount, GroupByMonth, GroupByClass, GroupByProject:
+		default:
+			return fmt.Errorf("invalid grouping '%s' for '%s'", report.GroupBy, report.Name)
+		}
+		if report.Range == "" {
+			report.Range = "current"
+		}
+		if !slices.Contains([]string{"current", "previous", "year", "all"}, report.Range) {
+			return fmt.Errorf("invalid range '%s' for '%s', expected current, previous, year, or all", report.Range, report.Name)
+		}
+		if !slices.Contains([]string{ScheduleDaily, ScheduleWeekly, ScheduleMonthly}, report.Schedule) {
+			return fmt.Errorf("invalid schedule '%s' for '%s', expected daily, weekly, or monthly", report.Schedule, report.Name)
+		}
+		report.Webhook = strings.TrimSpace(report.Webhook)
+		report.File = strings.TrimSpace(report.File)
+		if report.Webhook == "" && report.File == "" {
+			return fmt.Errorf("'%s' needs a webhook or a file to send the report to", report.Name)
+		}
+		if report.Webhook != "" {
+			target, err := url.Parse(report.Webhook)
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				return fmt.Errorf("invalid webhook URL for '%s'", report.Name)
+			}
+		}
+		if report.File != "" && (report.File != filepath.Base(report.File) || report.File == "." || report.File == "..") {
+			return fmt.Errorf("file of '%s' must be a plain file name", report.Name)
+		}
+		if report.LastRun != "" {
+			if _, err := time.Parse(time.RFC3339, report.LastRun); err != nil {
+				report.LastRun = ""
+			}
+		}
+	}
+	slices.SortFunc(reports, func(a, b SavedReport) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	})
+	return nil
+}
+
 // ValidateSubCategory validates that a subcategory belongs to the specified category
 func ValidateSubCategory(storage Storage, category string, subCategory string) error {
 	if subCategory == "" {
@@ -293,36 +1872,3 @@ var defaultCategories = []string{
 	"Miscellaneous",
 	"Income",
 }
-
-var SupportedCurrencies = []string{
-	"usd", // US Dollar
-	"eur", // Euro
-	"gbp", // British Pound
-	"jpy", // Japanese Yen
-	"cny", // Chinese Yuan
-	"krw", // Korean Won
-	"inr", // Indian Rupee
-	"rub", // Russian Ruble
-	"brl", // Brazilian Real
-	"zar", // South African Rand
-	"aed", // UAE Dirham
-	"aud", // Australian Dollar
-	"cad", // Canadian Dollar
-	"chf", // Swiss Franc
-	"hkd", // Hong Kong Dollar
-	"bdt", // Bangladeshi Taka
-	"sgd", // Singapore Dollar
-	"thb", // Thai Baht
-	"try", // Turkish Lira
-	"mxn", // Mexican Peso
-	"php", // Philippine Peso
-	"pln", // Polish Złoty
-	"sek", // Swedish Krona
-	"nzd", // New Zealand Dollar
-	"dkk", // Danish Krone
-	"idr", // Indonesian Rupiah
-	"ils", // Israeli New Shekel
-	"vnd", // Vietnamese Dong
-	"myr", // Malaysian Ringgit
-	"mad", // Moroccan Dirham
-}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// testStores returns a memory JSON store, and a PostgreSQL store when the tests run with
+// STORAGE_TYPE=postgres and the STORAGE_* settings of a test database. The PostgreSQL store is a
+// tenant of its own, whose schema is dropped after the test.
+func testStores(t *testing.T) map[string]Storage {
+	t.Helper()
+	memory, err := NewMemoryStore()
+	if err != nil {
+		t.Fatalf("Failed to create memory store: %v", err)
+	}
+	stores := map[string]Storage{"json": memory}
+	if os.Getenv("STORAGE_TYPE") != "postgres" {
+		return stores
+	}
+	baseConfig := SystemConfig{}
+	baseConfig.SetStorageConfig()
+	baseConfig.Tenant = fmt.Sprintf("test-%d", time.Now().UnixNano())
+	store, err := InitializePostgresStore(baseConfig)
+	if err != nil {
+		t.Fatalf("Failed to open PostgreSQL store: %v", err)
+	}
+	t.Cleanup(func() {
+		db := store.(*databaseStore).db
+		if _, err := db.Exec(`DROP SCHEMA ` + pq.QuoteIdentifier(tenantSchema(baseConfig.Tenant)) + ` CASCADE`); err != nil {
+			t.Errorf("Failed to drop test schema: %v", err)
+		}
+		store.Close()
+	})
+	stores["postgres"] = store
+	return stores
+}
+
+// TestRecurringEndDate checks that a rule with an end date stops on that day, including it, and
+// that an open-ended rule stops at the cap
+func TestRecurringEndDate(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	start := day(2026, 1, 15).Add(9 * time.Hour)
+	for name, store := range testStores(t) {
+		occurrences := func(id string) int {
+			expenses, _ := store.GetAllExpenses()
+			n := 0
+			for _, expense := range expenses {
+				if expense.RecurringID == id {
+					n++
+				}
+			}
+			return n
+		}
+		// only an end date, which falls on the day of an occurrence and includes it
+		end := day(2026, 4, 15)
+		loan := RecurringExpense{ID: "loan", Name: "Loan", Category: "Rent", Amount: -300, StartDate: start, Interval: "monthly", EndDate: &end}
+		if err := loan.Validate(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if err := store.AddRecurringExpense(loan); err != nil {
+			t.Fatalf("%s: failed to add recurring expense: %v", name, err)
+		}
+		rule, err := store.GetRecurringExpense("loan")
+		if err != nil || rule.EndDate == nil || !rule.EndDate.Equal(end) || rule.Occurrences != 0 {
+			t.Errorf("%s: expected the rule to keep its end date, got %+v, %v", name, rule, err)
+		}
+		expenses, _ := store.GetAllExpenses()
+		if len(expenses) != 4 || occurrences("loan") != 4 {
+			t.Fatalf("%s: expected 4 occurrences through the end date, got %d", name, len(expenses))
+		}
+		last := expenses[0]
+		for _, expense := range expenses {
+			if expense.Date.After(last.Date) {
+				last = expense
+			}
+		}
+		if !last.Date.Equal(day(2026, 4, 15).Add(9 * time.Hour)) {
+			t.Errorf("%s: expected the last occurrence on the end date, got %v", name, last.Date)
+		}
+
+		// whichever of the occurrences and the end date comes first ends the rule
+		promo := RecurringExpense{ID: "promo", Name: "Promo", Category: "Utilities", Amount: -10, StartDate: start, Interval: "monthly", Occurrences: 2, EndDate: &end}
+		if err := store.AddRecurringExpense(promo); err != nil {
+			t.Fatalf("%s: failed to add recurring expense: %v", name, err)
+		}
+		if n := occurrences("promo"); n != 2 {
+			t.Errorf("%s: expected 2 occurrences, got %d", name, n)
+		}
+
+		// an end date far away is capped
+		far := day(2060, 1, 1)
+		daily := RecurringExpense{ID: "coffee", Name: "Coffee", Category: "Food", Amount: -3, StartDate: start, Interval: "daily", EndDate: &far}
+		if err := store.AddRecurringExpense(daily); err != nil {
+			t.Fatalf("%s: failed to add recurring expense: %v", name, err)
+		}
+		if n := occurrences("coffee"); n != maxRecurringOccurrences {
+			t.Errorf("%s: expected %d occurrences, got %d", name, maxRecurringOccurrences, n)
+		}
+	}
+}
+
+// TestRecurringExpenseValidate checks the occurrences and end date a rule needs
+func TestRecurringExpenseValidate(t *testing.T) {
+	start := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	before, after := start.AddDate(0, 0, -1), start.AddDate(1, 0, 0)
+	for _, tc := range []struct {
+		name        string
+		occurrences int
+		endDate     *time.Time
+		field       string // of the error, none when empty
+	}{
+		{"occurrences only", 12, nil, ""},
+		{"end date only", 0, &after, ""},
+		{"both", 12, &after, ""},
+		{"neither", 0, nil, "occurrences"},
+		{"one occurrence", 1, nil, "occurrences"},
+		{"end date before the start", 0, &before, "endDate"},
+		{"end date on the start", 0, &start, "endDate"},
+		{"one occurrence with an end date", 1, &after, "occurrences"},
+		{"negative occurrences with an end date", -1, &after, "occurrences"},
+	} {
+		rule := RecurringExpense{Name: "Loan", Category: "Rent", Amount: -300, StartDate: start, Interval: "monthly", Occurrences: tc.occurrences, EndDate: tc.endDate}
+		err := rule.Validate()
+		var invalid *ValidationError
+		switch {
+		case tc.field == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		case tc.field != "" && (!errors.As(err, &invalid) || invalid.Field != tc.field):
+			t.Errorf("%s: expected an error for %s, got %v", tc.name, tc.field, err)
+		}
+	}
+}
+
+// TestMergeSubCategory checks that a store moves the expenses and rules of the source to the
+// target, and refuses a target that isn't a subcategory of the category
+func TestMergeSubCategory(t *testing.T) {
+	date := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	for name, store := range testStores(t) {
+		for _, sub := range []string{"Cafes", "Restaurants"} {
+			if err := store.AddSubCategory("Food", sub); err != nil {
+				t.Fatalf("%s: failed to add subcategory: %v", name, err)
+			}
+		}
+		if err := store.AddMultipleExpenses([]Expense{
+			{ID: "1", Name: "Bistro", Category: "Food", SubCategory: "Cafes", Amount: -12, Date: date},
+			{ID: "2", Name: "Bus", Category: "Travel", SubCategory: "Cafes", Amount: -3, Date: date},
+		}); err != nil {
+			t.Fatalf("%s: failed to add expenses: %v", name, err)
+		}
+		if err := store.UpdateSubCategoryMappings([]SubCategoryMappingRule{{Pattern: "bistro", MatchType: "contains", Category: "Food", SubCategory: "Cafes"}}); err != nil {
+			t.Fatalf("%s: failed to add mapping rule: %v", name, err)
+		}
+		for _, target := range []string{"Cafes", "Bakeries"} {
+			if err := store.MergeSubCategory("Food", "Cafes", target); err == nil {
+				t.Errorf("%s: expected merging into %q to fail", name, target)
+			}
+		}
+
+		if err := store.MergeSubCategory("Food", "Cafes", "Restaurants"); err != nil {
+			t.Fatalf("%s: failed to merge: %v", name, err)
+		}
+		if expense, _ := store.GetExpense("1"); expense.SubCategory != "Restaurants" {
+			t.Errorf("%s: expected the expense in the target, got %q", name, expense.SubCategory)
+		}
+		if expense, _ := store.GetExpense("2"); expense.SubCategory != "Cafes" {
+			t.Errorf("%s: expected other categories to be left alone, got %q", name, expense.SubCategory)
+		}
+		if rules, _ := store.GetSubCategoryMappings(); len(rules) != 1 || rules[0].SubCategory != "Restaurants" {
+			t.Errorf("%s: expected the rule to point at the target, got %+v", name, rules)
+		}
+		if subCategories, _ := store.GetSubCategories("Food"); len(subCategories) != 1 || subCategories[0] != "Restaurants" {
+			t.Errorf("%s: expected the source to be removed, got %v", name, subCategories)
+		}
+	}
+}
+
+// TestMoveExpenses checks that a store re-categorizes only the given expenses, marking recurring
+// instances as edited so rule updates leave them alone
+func TestMoveExpenses(t *testing.T) {
+	date := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	for name, store := range testStores(t) {
+		if err := store.AddMultipleExpenses([]Expense{
+			{ID: "1", Name: "Bus", Category: "Food", Amount: -3, Date: date},
+			{ID: "2", Name: "Pass", Category: "Food", Amount: -50, Date: date, RecurringID: "pass"},
+			{ID: "3", Name: "Lunch", Category: "Food", Amount: -12, Date: date},
+		}); err != nil {
+			t.Fatalf("%s: failed to add expenses: %v", name, err)
+		}
+		if err := store.MoveExpenses([]string{"1", "2"}, "Travel", "Transit"); err != nil {
+			t.Fatalf("%s: failed to move expenses: %v", name, err)
+		}
+		for id, want := range map[string][2]string{"1": {"Travel", "Transit"}, "2": {"Travel", "Transit"}, "3": {"Food", ""}} {
+			if expense, _ := store.GetExpense(id); expense.Category != want[0] || expense.SubCategory != want[1] {
+				t.Errorf("%s: expected expense %s in %s/%s, got %s/%s", name, id, want[0], want[1], expense.Category, expense.SubCategory)
+			}
+		}
+		if expense, _ := store.GetExpense("2"); !expense.Edited {
+			t.Errorf("%s: expected the moved recurring instance to be marked edited", name)
+		}
+	}
+}
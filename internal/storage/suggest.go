@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// NameSuggestion is a previously used expense name with what it was most often recorded as, for
+// autocompleting the add-expense form
+type NameSuggestion struct {
+	Name        string    `json:"name"`
+	Category    string    `json:"category"`              // with SubCategory, the most common pair
+	SubCategory string    `json:"subCategory,omitempty"`
+	Amount      float64   `json:"amount"`                // with Currency, the most common amount
+	Currency    string    `json:"currency"`
+	Uses        int       `json:"uses"`
+	LastUsed    time.Time `json:"lastUsed"`
+}
+
+// tally counts how often a value was used and when last, to pick the most common one
+type tally[K comparable] struct {
+	counts map[K]int
+	last   map[K]time.Time
+}
+
+func (t *tally[K]) add(key K, date time.Time) {
+	if t.counts == nil {
+		t.counts, t.last = map[K]int{}, map[K]time.Time{}
+	}
+	t.counts[key]++
+	if date.After(t.last[key]) {
+		t.last[key] = date
+	}
+}
+
+// top is the most used value, the most recently used of those on a tie
+func (t *tally[K]) top() K {
+	var best K
+	first := true
+	for key, count := range t.counts {
+		if first || count > t.counts[best] || count == t.counts[best] && t.last[key].After(t.last[best]) {
+			best, first = key, false
+		}
+	}
+	return best
+}
+
+type amountKey struct {
+	amount   float64
+	currency string
+}
+
+// suggestNames matches names starting with prefix (ignoring case) in memory, most used first
+func suggestNames(expenses []Expense, prefix string, limit int) []NameSuggestion {
+	prefix = strings.ToLower(prefix)
+	type usage struct {
+		categories tally[[2]string]
+		amounts    tally[amountKey]
+		suggestion NameSuggestion
+	}
+	byName := map[string]*usage{}
+	for _, expense := range expenses {
+		if !strings.HasPrefix(strings.ToLower(expense.Name), prefix) {
+			continue
+		}
+		u, ok := byName[expense.Name]
+		if !ok {
+			u = &usage{suggestion: NameSuggestion{Name: expense.Name}}
+			byName[expense.Name] = u
+		}
+		u.categories.add([2]string{expense.Category, expense.SubCategory}, expense.Date)
+		u.amounts.add(amountKey{expense.Amount, expense.Currency}, expense.Date)
+		u.suggestion.Uses++
+		if expense.Date.After(u.suggestion.LastUsed) {
+			u.suggestion.LastUsed = expense.Date
+		}
+	}
+	suggestions := make([]NameSuggestion, 0, len(byName))
+	for _, u := range byName {
+		category, amount := u.categories.top(), u.amounts.top()
+		u.suggestion.Category, u.suggestion.SubCategory = category[0], category[1]
+		u.suggestion.Amount, u.suggestion.Currency = amount.amount, amount.currency
+		suggestions = append(suggestions, u.suggestion)
+	}
+	slices.SortFunc(suggestions, compareSuggestions)
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// compareSuggestions orders the most used names first, then the most recently used
+func compareSuggestions(a, b NameSuggestion) int {
+	return cmp.Or(cmp.Compare(b.Uses, a.Uses), b.LastUsed.Compare(a.LastUsed), cmp.Compare(a.Name, b.Name))
+}
+
+// likePrefix is a LIKE pattern matching strings that start with prefix
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+}
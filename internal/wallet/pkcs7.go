@@ -0,0 +1,146 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+	"slices"
+	"time"
+)
+
+// The signature of a pass is a detached PKCS #7 (CMS) SignedData of the manifest (RFC 5652),
+// with the signing and WWDR certificates, and signed attributes that include the signing time,
+// which Wallet requires. Only what signing needs is encoded here; Go has no PKCS #7 package.
+
+var (
+	oidData            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           algorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue // [0] IMPLICIT SET OF Attribute
+	DigestEncryptionAlgorithm algorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"` // [0] EXPLICIT, left out when detached
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue // SET OF AlgorithmIdentifier
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue // [0] IMPLICIT SET OF Certificate
+	SignerInfos      asn1.RawValue // SET OF SignerInfo
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue // SET OF the value
+}
+
+// set encodes DER SET OF elements, which are sorted by their encoding
+func set(class, tag int, elements ...[]byte) asn1.RawValue {
+	slices.SortFunc(elements, bytes.Compare)
+	return asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: bytes.Join(elements, nil)}
+}
+
+// newAttribute encodes an attribute with one value
+func newAttribute(oid asn1.ObjectIdentifier, value any) ([]byte, error) {
+	encoded, err := asn1.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(attribute{Type: oid, Values: set(asn1.ClassUniversal, asn1.TagSet, encoded)})
+}
+
+// sign returns the detached signature of content
+func (s *Signer) sign(content []byte, now time.Time) ([]byte, error) {
+	digest := sha256.Sum256(content)
+	var attributes [][]byte
+	for _, a := range []struct {
+		oid   asn1.ObjectIdentifier
+		value any
+	}{
+		{oidContentType, oidData},
+		{oidSigningTime, now.UTC()},
+		{oidMessageDigest, digest[:]},
+	} {
+		encoded, err := newAttribute(a.oid, a.value)
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, encoded)
+	}
+
+	// the signature covers the attributes encoded as a SET, though they are sent tagged [0]
+	signed := set(asn1.ClassUniversal, asn1.TagSet, attributes...)
+	signedDER, err := asn1.Marshal(signed)
+	if err != nil {
+		return nil, err
+	}
+	attributesDigest := sha256.Sum256(signedDER)
+	signature, err := s.key.Sign(rand.Reader, attributesDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	signatureAlgorithm := algorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	if _, ok := s.key.(*ecdsa.PrivateKey); ok {
+		signatureAlgorithm = algorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	}
+	sha256Algorithm := algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	info, err := asn1.Marshal(signerInfo{
+		Version:                   1,
+		IssuerAndSerialNumber:     issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: s.cert.RawIssuer}, SerialNumber: s.cert.SerialNumber},
+		DigestAlgorithm:           sha256Algorithm,
+		AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed.Bytes},
+		DigestEncryptionAlgorithm: signatureAlgorithm,
+		EncryptedDigest:           signature,
+	})
+	if err != nil {
+		return nil, err
+	}
+	digestAlgorithm, err := asn1.Marshal(sha256Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	data, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: set(asn1.ClassUniversal, asn1.TagSet, digestAlgorithm),
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: slices.Concat(s.cert.Raw, s.wwdr.Raw)},
+		SignerInfos:      set(asn1.ClassUniversal, asn1.TagSet, info),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: data},
+	})
+}
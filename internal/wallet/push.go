@@ -0,0 +1,65 @@
+package wallet
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APNsHost is where pass updates are pushed; passes only use the production service
+const APNsHost = "https://api.push.apple.com"
+
+// ErrUnregistered is returned for a push token the device no longer uses
+var ErrUnregistered = errors.New("the device is no longer registered for the pass")
+
+// Pusher tells devices a pass changed, so they fetch it again from the web service
+type Pusher struct {
+	client *http.Client
+	host   string
+	topic  string // the pass type
+}
+
+// NewPusher pushes with the signer's certificate, which APNs accepts for its pass type
+func NewPusher(s *Signer) *Pusher {
+	return &Pusher{
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{Certificates: []tls.Certificate{s.TLSCertificate()}},
+				ForceAttemptHTTP2: true, // APNs only speaks HTTP/2
+			},
+		},
+		host:  APNsHost,
+		topic: s.PassTypeIdentifier,
+	}
+}
+
+// Push sends the empty notification of a pass update to a device's push token
+func (p *Pusher) Push(ctx context.Context, pushToken string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.host+"/3/device/"+pushToken, strings.NewReader("{}"))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("apns-topic", p.topic)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusGone:
+		return ErrUnregistered
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "BadDeviceToken") {
+		return ErrUnregistered
+	}
+	return fmt.Errorf("APNs returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+}
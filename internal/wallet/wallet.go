@@ -0,0 +1,199 @@
+// Package wallet builds Apple Wallet passes (.pkpass files) and pushes pass updates. A pass is a
+// zip of pass.json, its images, a manifest of their SHA-1 hashes, and a detached PKCS #7
+// signature of the manifest made with a Pass Type ID certificate from the Apple Developer portal.
+package wallet
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ContentType is the media type of a .pkpass file
+const ContentType = "application/vnd.apple.pkpass"
+
+// Pass is pass.json of a generic pass; see Apple's PassKit Package Format Reference
+type Pass struct {
+	FormatVersion       int       `json:"formatVersion"` // always 1
+	PassTypeIdentifier  string    `json:"passTypeIdentifier"`
+	SerialNumber        string    `json:"serialNumber"`
+	TeamIdentifier      string    `json:"teamIdentifier"`
+	OrganizationName    string    `json:"organizationName"`
+	Description         string    `json:"description"` // read out by VoiceOver
+	LogoText            string    `json:"logoText,omitempty"`
+	WebServiceURL       string    `json:"webServiceURL,omitempty"` // https, where devices register for updates
+	AuthenticationToken string    `json:"authenticationToken,omitempty"`
+	ForegroundColor     string    `json:"foregroundColor,omitempty"` // e.g., rgb(255, 255, 255)
+	BackgroundColor     string    `json:"backgroundColor,omitempty"`
+	LabelColor          string    `json:"labelColor,omitempty"`
+	Generic             Structure `json:"generic"`
+}
+
+// Structure is the fields of a pass, by where they are shown
+type Structure struct {
+	HeaderFields    []Field `json:"headerFields,omitempty"` // also visible when passes are stacked
+	PrimaryFields   []Field `json:"primaryFields,omitempty"`
+	SecondaryFields []Field `json:"secondaryFields,omitempty"`
+	AuxiliaryFields []Field `json:"auxiliaryFields,omitempty"`
+	BackFields      []Field `json:"backFields,omitempty"`
+}
+
+// Field is a label and value on a pass; a change message, e.g., "Budget left: %@", is shown as a
+// notification when an update changes the value
+type Field struct {
+	Key           string `json:"key"`
+	Label         string `json:"label,omitempty"`
+	Value         string `json:"value"`
+	ChangeMessage string `json:"changeMessage,omitempty"`
+}
+
+// Signer signs passes with a Pass Type ID certificate, whose subject names the pass type and team
+type Signer struct {
+	cert *x509.Certificate
+	wwdr *x509.Certificate // Apple's Worldwide Developer Relations intermediate, included in signatures
+	key  crypto.Signer
+
+	PassTypeIdentifier string // e.g., pass.com.example.budget
+	TeamIdentifier     string
+}
+
+// uidOID is the userID attribute, which holds the pass type of a Pass Type ID certificate
+var uidOID = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}
+
+// NewSigner reads the certificate and its unencrypted private key from PEM, and the WWDR
+// certificate from PEM or DER
+func NewSigner(certPEM, wwdrCert []byte) (*Signer, error) {
+	s := &Signer{}
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			if s.cert == nil {
+				s.cert, err = x509.ParseCertificate(block.Bytes)
+			}
+		case "PRIVATE KEY":
+			var key any
+			if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+				s.key, _ = key.(crypto.Signer)
+			}
+		case "RSA PRIVATE KEY":
+			s.key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			s.key, err = x509.ParseECPrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in the pass certificate: %v", block.Type, err)
+		}
+	}
+	if s.cert == nil || s.key == nil {
+		return nil, fmt.Errorf("the pass certificate must be a PEM file with the certificate and its unencrypted private key")
+	}
+	switch s.key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, fmt.Errorf("the pass certificate's key must be RSA or ECDSA")
+	}
+	if block, _ := pem.Decode(wwdrCert); block != nil {
+		wwdrCert = block.Bytes
+	}
+	var err error
+	if s.wwdr, err = x509.ParseCertificate(wwdrCert); err != nil {
+		return nil, fmt.Errorf("invalid WWDR certificate: %v", err)
+	}
+	for _, name := range s.cert.Subject.Names {
+		if value, ok := name.Value.(string); ok && name.Type.Equal(uidOID) {
+			s.PassTypeIdentifier = value
+		}
+	}
+	if len(s.cert.Subject.OrganizationalUnit) > 0 {
+		s.TeamIdentifier = s.cert.Subject.OrganizationalUnit[0]
+	}
+	if s.PassTypeIdentifier == "" || s.TeamIdentifier == "" {
+		return nil, fmt.Errorf("the pass certificate doesn't name a pass type and team, is it a Pass Type ID certificate?")
+	}
+	return s, nil
+}
+
+// Expires is when the certificate runs out, after which passes no longer install
+func (s *Signer) Expires() time.Time {
+	return s.cert.NotAfter
+}
+
+// TLSCertificate is the certificate as a client certificate, which is how updates are pushed
+func (s *Signer) TLSCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{s.cert.Raw}, PrivateKey: s.key, Leaf: s.cert}
+}
+
+// AuthenticationToken derives the token devices send for a pass from the private key, so it
+// doesn't have to be stored and changes with the certificate
+func (s *Signer) AuthenticationToken(serialNumber string) string {
+	der, _ := x509.MarshalPKCS8PrivateKey(s.key)
+	mac := hmac.New(sha256.New, der)
+	mac.Write([]byte("pass:" + serialNumber))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Build packs and signs a pass with its images, e.g., icon.png (required), icon@2x.png, and logo.png
+func (s *Signer) Build(pass Pass, images map[string][]byte) ([]byte, error) {
+	pass.FormatVersion, pass.PassTypeIdentifier, pass.TeamIdentifier = 1, s.PassTypeIdentifier, s.TeamIdentifier
+	passJSON, err := json.Marshal(pass)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := images["icon.png"]; !ok {
+		return nil, fmt.Errorf("a pass needs an icon.png")
+	}
+	files := map[string][]byte{"pass.json": passJSON}
+	for name, data := range images {
+		files[name] = data
+	}
+	manifest := make(map[string]string, len(files))
+	for name, data := range files {
+		sum := sha1.Sum(data)
+		manifest[name] = hex.EncodeToString(sum[:])
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := s.sign(manifestJSON, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign the pass: %v", err)
+	}
+	files["manifest.json"], files["signature"] = manifestJSON, signature
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		w, err := archive.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,214 @@
+package wallet
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestSigner signs with a pass certificate issued by a made-up WWDR certificate
+func newTestSigner(t *testing.T, key crypto.Signer) *Signer {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test WWDR"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ = x509.ParseCertificate(caDER)
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject: pkix.Name{
+			CommonName:         "Pass Type ID: pass.com.example.budget",
+			OrganizationalUnit: []string{"TEAM123"},
+			ExtraNames:         []pkix.AttributeTypeAndValue{{Type: uidOID, Value: "pass.com.example.budget"}},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...)
+	signer, err := NewSigner(certPEM, caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestBuild(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, key := range map[string]crypto.Signer{"rsa": rsaKey, "ecdsa": ecKey} {
+		t.Run(name, func(t *testing.T) {
+			signer := newTestSigner(t, key)
+			if signer.PassTypeIdentifier != "pass.com.example.budget" || signer.TeamIdentifier != "TEAM123" {
+				t.Fatalf("Expected the pass type and team from the certificate, got %q and %q", signer.PassTypeIdentifier, signer.TeamIdentifier)
+			}
+			pass := Pass{SerialNumber: "budget", OrganizationName: "ExpenseOwl", Description: "Budget", Generic: Structure{
+				PrimaryFields: []Field{{Key: "remaining", Label: "LEFT", Value: "$457.00", ChangeMessage: "Budget left: %@"}},
+			}}
+			data, err := signer.Build(pass, map[string][]byte{"icon.png": []byte("icon")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := readZip(t, data)
+			var got Pass
+			if err := json.Unmarshal(files["pass.json"], &got); err != nil || got.FormatVersion != 1 || got.PassTypeIdentifier != "pass.com.example.budget" || got.TeamIdentifier != "TEAM123" {
+				t.Errorf("Unexpected pass.json: %s", files["pass.json"])
+			}
+			var manifest map[string]string
+			if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil || len(manifest) != 2 {
+				t.Fatalf("Expected the manifest to list pass.json and icon.png, got %s", files["manifest.json"])
+			}
+			for name, hash := range manifest {
+				sum := sha1.Sum(files[name])
+				if hex.EncodeToString(sum[:]) != hash {
+					t.Errorf("Wrong hash of %s in the manifest", name)
+				}
+			}
+			verifySignature(t, files["signature"], files["manifest.json"], signer)
+		})
+	}
+
+	signer := newTestSigner(t, ecKey)
+	if _, err := signer.Build(Pass{}, nil); err == nil {
+		t.Error("Expected a pass without an icon to be rejected")
+	}
+	if signer.AuthenticationToken("budget") == signer.AuthenticationToken("other") || len(signer.AuthenticationToken("budget")) < 16 {
+		t.Error("Expected a long authentication token per serial number")
+	}
+	if _, err := NewSigner([]byte("not a certificate"), nil); err == nil {
+		t.Error("Expected an invalid certificate to be rejected")
+	}
+}
+
+func readZip(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+	return files
+}
+
+// verifySignature parses the SignedData and checks its signature and message digest
+func verifySignature(t *testing.T, signature, content []byte, signer *Signer) {
+	t.Helper()
+	var outer contentInfo
+	if _, err := asn1.Unmarshal(signature, &outer); err != nil || !outer.ContentType.Equal(oidSignedData) {
+		t.Fatalf("Expected a SignedData: %v", err)
+	}
+	var data signedData
+	if _, err := asn1.Unmarshal(outer.Content.Bytes, &data); err != nil {
+		t.Fatal(err)
+	}
+	var certificate asn1.RawValue
+	rest, _ := asn1.Unmarshal(data.Certificates.Bytes, &certificate)
+	if !bytes.Equal(certificate.FullBytes, signer.cert.Raw) || len(rest) == 0 {
+		t.Error("Expected the signing and WWDR certificates")
+	}
+	var info signerInfo
+	if _, err := asn1.Unmarshal(data.SignerInfos.Bytes, &info); err != nil {
+		t.Fatal(err)
+	}
+	signed := append([]byte{0x31}, info.AuthenticatedAttributes.FullBytes[1:]...)
+	digest := sha256.Sum256(signed)
+	switch key := signer.cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], info.EncryptedDigest); err != nil {
+			t.Errorf("Invalid signature: %v", err)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], info.EncryptedDigest) {
+			t.Error("Invalid signature")
+		}
+	}
+	contentDigest := sha256.Sum256(content)
+	if !bytes.Contains(info.AuthenticatedAttributes.Bytes, contentDigest[:]) {
+		t.Error("Expected the message digest of the manifest in the signed attributes")
+	}
+}
+
+func TestPush(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("apns-topic") != "pass.com.example.budget" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/3/device/current":
+			w.WriteHeader(http.StatusOK)
+		case "/3/device/gone":
+			w.WriteHeader(http.StatusGone)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"reason": "BadDeviceToken"}`)
+		}
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	pusher := &Pusher{client: server.Client(), host: server.URL, topic: "pass.com.example.budget"}
+
+	if err := pusher.Push(context.Background(), "current"); err != nil {
+		t.Errorf("Expected the push to go through, got %v", err)
+	}
+	for _, token := range []string{"gone", "bad"} {
+		if err := pusher.Push(context.Background(), token); !errors.Is(err, ErrUnregistered) {
+			t.Errorf("Expected %s to be unregistered, got %v", token, err)
+		}
+	}
+}
@@ -2,28 +2,60 @@ package web
 
 import (
 	"embed"
+	"html/template"
+	"io"
 	"net/http"
 	"path/filepath"
+	"strings"
 )
 
 //go:embed templates
 var content embed.FS
 
+// pageTemplates are the pages of the web UI, rendered with the instance's Branding
+var pageTemplates = template.Must(parseTemplates("*.html"))
+
+// basicTemplates are the server-rendered pages of the no-JavaScript mode
+var basicTemplates = template.Must(parseTemplates("basic/*.html"))
+
+// Branding is what the pages are rendered with, so self-hosters can name and brand their instance
+// without changing the templates
+type Branding struct {
+	InstanceName   string // in page titles
+	CurrencySymbol string // of the display currency
+	LogoPath       string // of the header logo
+	FooterText     string // at the bottom of the page, none when empty
+	CSRFToken      string // sent back with writes, see api.CheckCSRF
+}
+
 func GetTemplates() *embed.FS {
 	return &content
 }
 
-func ServeTemplate(w http.ResponseWriter, templateName string) error {
-	templateContent, err := content.ReadFile("templates/" + templateName)
+func ServeTemplate(w http.ResponseWriter, templateName string, branding Branding) error {
+	set, err := templates(pageTemplates, "*.html")
 	if err != nil {
 		return err
 	}
-	_, err = w.Write(templateContent)
-	return err
+	return set.ExecuteTemplate(w, templateName, branding)
+}
+
+// RenderTemplate executes one of the server-rendered pages in templates/basic with the given data
+func RenderTemplate(w io.Writer, templateName string, data any) error {
+	set, err := templates(basicTemplates, "basic/*.html")
+	if err != nil {
+		return err
+	}
+	return set.ExecuteTemplate(w, templateName, data)
+}
+
+// ReadStatic returns a static file, e.g., pwa/icon-512.png, from the overrides when they have it
+func ReadStatic(staticPath string) ([]byte, error) {
+	return readFile(strings.TrimPrefix(staticPath, "/"))
 }
 
 func ServeStatic(w http.ResponseWriter, staticPath string) error {
-	staticContent, err := content.ReadFile("templates" + staticPath)
+	staticContent, err := readFile(strings.TrimPrefix(staticPath, "/"))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// An override directory (ASSETS_DIR) takes the place of the embedded pages and static files that
+// have the same path in it, e.g., style.css or basic/table.html, so the web UI can be customized
+// without rebuilding. Overrides are read on every request, so edits show up on the next reload.
+
+// overrides is the override directory, nil without one
+var overrides fs.FS
+
+// UseOverrides serves the files in dir in place of the embedded files of the same path; an empty dir
+// goes back to the embedded files
+func UseOverrides(dir string) error {
+	if dir == "" {
+		overrides = nil
+		return nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	overrides = os.DirFS(dir)
+	return nil
+}
+
+// readFile reads a file of the web UI, from the override directory when it has one
+func readFile(name string) ([]byte, error) {
+	if overrides != nil {
+		data, err := fs.ReadFile(overrides, name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return data, err
+		}
+	}
+	return content.ReadFile("templates/" + name)
+}
+
+// parseTemplates parses the embedded templates matching pattern, each from the override directory
+// when it has one
+func parseTemplates(pattern string) (*template.Template, error) {
+	names, err := fs.Glob(content, "templates/"+pattern)
+	if err != nil {
+		return nil, err
+	}
+	set := template.New("")
+	for _, name := range names {
+		name = strings.TrimPrefix(name, "templates/")
+		data, err := readFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := set.New(path.Base(name)).Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+	}
+	return set, nil
+}
+
+// templates returns the embedded set, or the set parsed again with the overrides when there are any
+func templates(embedded *template.Template, pattern string) (*template.Template, error) {
+	if overrides == nil {
+		return embedded, nil
+	}
+	return parseTemplates(pattern)
+}
@@ -1,4 +1,5 @@
-const colorPalette = [
+// Chart colors, replaced by the palette picked in the settings once /config is loaded
+let colorPalette = [
     '#FF6B6B', // Coral Red
     '#4ECDC4', // Turquoise
     '#45B7D1', // Sky Blue
@@ -45,33 +46,152 @@ const currencyBehaviors = {
     mad: {symbol: "DH", useComma: false, useDecimals: true, useSpace: true, right: true},
 };
 
-function formatCurrency(amount) {
-    const behavior = currencyBehaviors[currentCurrency] || {
-        symbol: "$",
+// Adds the custom currencies from the config, e.g., points or crypto, to the known formats
+function registerCustomCurrencies(config) {
+    (config.customCurrencies || []).forEach(currency => {
+        currencyBehaviors[currency.code] = {
+            symbol: currency.symbol || currency.code.toUpperCase(),
+            useComma: false,
+            useDecimals: currency.decimals > 0,
+            decimals: currency.decimals,
+            useSpace: true,
+            right: true,
+        };
+    });
+}
+
+// Currencies without a known format show their code after the amount
+function currencyBehavior(code) {
+    return currencyBehaviors[code] || {
+        symbol: (code || 'usd').toUpperCase(),
         useComma: false,
         useDecimals: true,
-        useSpace: false,
-        right: false,
+        useSpace: true,
+        right: true,
     };
+}
+
+function formatCurrency(amount) {
+    const behavior = currencyBehavior(currentCurrency);
     const isNegative = amount < 0;
     const absAmount = Math.abs(amount);
+    const decimals = behavior.decimals ?? (behavior.useDecimals ? 2 : 0);
     const options = {
-        minimumFractionDigits: behavior.useDecimals ? 2 : 0,
-        maximumFractionDigits: behavior.useDecimals ? 2 : 0,
+        minimumFractionDigits: decimals,
+        maximumFractionDigits: decimals,
     };
-    let formattedAmount = new Intl.NumberFormat(behavior.useComma ? "de-DE" : "en-US",options).format(absAmount);
+    let formattedAmount = new Intl.NumberFormat(userLocale(behavior.useComma ? "de-DE" : "en-US"),options).format(absAmount);
     let result = behavior.right
         ? `${formattedAmount}${behavior.useSpace ? " " : ""}${behavior.symbol}`
         : `${behavior.symbol}${behavior.useSpace ? " " : ""}${formattedAmount}`;
     return isNegative ? `-${result}` : result;
 }
 
+// Sends the page's CSRF token with every write to this server, which rejects signed-in writes without it
+(function() {
+    const token = document.querySelector('meta[name="csrf-token"]')?.content;
+    if (!token) return;
+    const originalFetch = window.fetch;
+    window.fetch = function(resource, options = {}) {
+        const isRequest = resource instanceof Request;
+        const method = (options.method || (isRequest ? resource.method : 'GET')).toUpperCase();
+        const url = new URL(isRequest ? resource.url : resource, location.href);
+        if (url.origin === location.origin && !['GET', 'HEAD', 'OPTIONS'].includes(method)) {
+            const headers = new Headers(options.headers || (isRequest ? resource.headers : undefined));
+            headers.set('X-CSRF-Token', token);
+            options = {...options, headers};
+        }
+        return originalFetch.call(this, resource, options);
+    };
+})();
+
+// Saves an expense, asking before saving an amount above its category's single-transaction limit
+async function saveExpenseRequest(url, options) {
+    const response = await fetch(url, options);
+    if (response.status !== 409) return response;
+    const error = await response.clone().json();
+    // only an amount above its category's limit can be confirmed; other conflicts are the caller's
+    if (error.code !== 'CONFIRM_REQUIRED' || !confirm(error.error)) return response;
+    return fetch(url + (url.includes('?') ? '&' : '?') + 'confirm=true', options);
+}
+
+// The signed-in user's preferences from /config; unset values fall back to the server and browser
+let userPreferences = {};
+
+// Locale for dates and numbers, from the user's preferences
+function userLocale(fallback = 'en-US') {
+    return userPreferences.locale || userPreferences.language || fallback;
+}
+
+// Catalog of the UI language from /i18n; the markup is in English, which needs none
+let translations = {};
+
+// Language of the UI text: the user's preference, else the browser's
+function uiLanguage() {
+    return userPreferences.language || (navigator.language || 'en').split('-')[0].toLowerCase();
+}
+
+// Text of a translation key, or the fallback when the catalog doesn't have it
+function t(key, fallback) {
+    return translations[key] || fallback || key;
+}
+
+// Translates the elements marked with data-i18n (text), data-i18n-tooltip, and data-i18n-placeholder,
+// keeping the English text to switch back to
+function applyTranslations(root = document) {
+    root.querySelectorAll('[data-i18n]').forEach(el => {
+        if (el.dataset.i18nDefault === undefined) el.dataset.i18nDefault = el.textContent;
+        el.textContent = t(el.dataset.i18n, el.dataset.i18nDefault);
+    });
+    root.querySelectorAll('[data-i18n-tooltip]').forEach(el => {
+        if (el.dataset.i18nTooltipDefault === undefined) el.dataset.i18nTooltipDefault = el.dataset.tooltip;
+        el.dataset.tooltip = t(el.dataset.i18nTooltip, el.dataset.i18nTooltipDefault);
+    });
+    root.querySelectorAll('[data-i18n-placeholder]').forEach(el => {
+        if (el.dataset.i18nPlaceholderDefault === undefined) el.dataset.i18nPlaceholderDefault = el.placeholder;
+        el.placeholder = t(el.dataset.i18nPlaceholder, el.dataset.i18nPlaceholderDefault);
+    });
+}
+
+// Loads the catalog of the UI language and translates the page; unsupported languages stay English
+async function applyLanguage() {
+    const lang = uiLanguage();
+    translations = {};
+    if (lang !== 'en') {
+        try {
+            const response = await fetch(`/i18n/${lang}.json`);
+            if (response.ok) translations = await response.json();
+        } catch (error) {
+            console.error('Error loading translations:', error);
+        }
+    }
+    document.documentElement.lang = Object.keys(translations).length ? lang : 'en';
+    applyTranslations();
+}
+
+// Remembers the user's theme, or else the server's default, and applies it when this browser hasn't picked one
+function applyDefaultTheme(config) {
+    if (config.preferences) {
+        userPreferences = config.preferences;
+        applyLanguage();
+    }
+    if (config.chartPalette && config.chartPalette.length) colorPalette = config.chartPalette;
+    const theme = userPreferences.theme || (config.settings && config.settings.ui && config.settings.ui.defaultTheme) || 'system';
+    localStorage.setItem('defaultTheme', theme);
+    if (localStorage.getItem('theme')) return;
+    if (theme === 'light' || theme === 'dark') {
+        document.documentElement.setAttribute('data-theme', theme);
+    } else {
+        document.documentElement.removeAttribute('data-theme');
+    }
+}
+
 function getUserTimeZone() {
     return Intl.DateTimeFormat().resolvedOptions().timeZone;
 }
 
 function formatMonth(date) {
-    return date.toLocaleDateString('en-US', {
+    return date.toLocaleDateString(userLocale(), {
         year: 'numeric',
         month: 'long',
         timeZone: getUserTimeZone()
@@ -90,7 +210,7 @@ function getISODateWithLocalTime(dateInput) {
 
 function formatDateFromUTC(utcDateString) {
     const date = new Date(utcDateString);
-    return date.toLocaleDateString('en-US', {
+    return date.toLocaleDateString(userLocale(), {
         month: 'short',
         day: 'numeric',
         year: 'numeric',
@@ -103,11 +223,48 @@ function formatDateFromUTC(utcDateString) {
 function updateMonthDisplay() {
     const currentMonthEl = document.getElementById('currentMonth');
     if (currentMonthEl) {
-        currentMonthEl.textContent = formatMonth(currentDate);
+        currentMonthEl.textContent = getPeriodLength() ? formatPeriodRange(getMonthBounds(currentDate)) : formatMonth(currentDate);
+    }
+}
+
+// getPeriodLength returns the length in days of weekly/biweekly periods, or 0 for monthly
+function getPeriodLength() {
+    if (typeof periodConfig === 'undefined' || !periodConfig) return 0;
+    if (periodConfig.type === 'weekly') return 7;
+    if (periodConfig.type === 'biweekly') return 14;
+    return 0;
+}
+
+function shiftPeriod(date, direction) {
+    const length = getPeriodLength();
+    if (length) {
+        date.setDate(date.getDate() + direction * length);
+    } else {
+        date.setMonth(date.getMonth() + direction);
     }
 }
 
+function formatPeriodRange({ start, end }) {
+    const opts = { month: 'short', day: 'numeric' };
+    return `${start.toLocaleDateString(userLocale(), opts)} - ${end.toLocaleDateString(userLocale(), { ...opts, year: 'numeric' })}`;
+}
+
+// getFixedPeriodBounds aligns weekly/biweekly periods to the anchor date (defaults to a Monday)
+function getFixedPeriodBounds(date, length) {
+    const [year, month, day] = (periodConfig.anchor || '1970-01-05').split('-').map(Number);
+    const localDate = new Date(date);
+    const days = Math.round((Date.UTC(localDate.getFullYear(), localDate.getMonth(), localDate.getDate()) - Date.UTC(year, month - 1, day)) / 86400000);
+    const offset = ((days % length) + length) % length;
+    const startLocal = new Date(localDate.getFullYear(), localDate.getMonth(), localDate.getDate() - offset);
+    const endLocal = new Date(startLocal.getFullYear(), startLocal.getMonth(), startLocal.getDate() + length - 1, 23, 59, 59, 999);
+    return { start: startLocal, end: endLocal };
+}
+
 function getMonthBounds(date) {
+    const periodLength = getPeriodLength();
+    if (periodLength) {
+        return getFixedPeriodBounds(date, periodLength);
+    }
     const localDate = new Date(date);
     if (startDate === 1) {
         const startLocal = new Date(localDate.getFullYear(), localDate.getMonth(), 1);
@@ -161,3 +318,55 @@ function escapeHTML(str) {
         }[tag] || tag)
     );
 }
+
+// Fills the project picker of the expense form with the open projects from /config, hiding it
+// when there are none; the picked project is kept across refreshes
+function fillProjectSelect(projects) {
+    const select = document.getElementById('project');
+    const selected = select.value;
+    const open = (projects || []).filter(project => !project.closed);
+    select.innerHTML = '<option value="">None</option>' + open.map(project =>
+        `<option value="${escapeHTML(project.name)}">${escapeHTML(project.name)}${project.client ? ` (${escapeHTML(project.client)})` : ''}</option>`
+    ).join('');
+    if (open.some(project => project.name === selected)) select.value = selected;
+    document.getElementById('projectGroup').style.display = open.length ? '' : 'none';
+}
+
+// Calls onChange when expenses or settings change on the server (debounced, since
+// bulk operations like imports can send several events in a row)
+function subscribeToUpdates(onChange) {
+    if (!window.EventSource) return;
+    let timer = null;
+    const schedule = () => {
+        clearTimeout(timer);
+        timer = setTimeout(onChange, 300);
+    };
+    const source = new EventSource('/api/events');
+    source.addEventListener('expenses', schedule);
+    source.addEventListener('config', schedule);
+    window.addEventListener('beforeunload', () => source.close());
+}
+
+// Resolves to {lat, lon} from the device location, or null if it is unavailable or denied
+function getCurrentCoordinates(timeout = 5000) {
+    if (!navigator.geolocation) return Promise.resolve(null);
+    return new Promise(resolve => {
+        navigator.geolocation.getCurrentPosition(
+            pos => resolve({
+                lat: Math.round(pos.coords.latitude * 1e6) / 1e6,
+                lon: Math.round(pos.coords.longitude * 1e6) / 1e6
+            }),
+            () => resolve(null),
+            { timeout, maximumAge: 60000 }
+        );
+    });
+}
+
+// Combines optional coordinates and a place name into an expense location (undefined if both are empty)
+function buildLocation(coords, place) {
+    place = (place || '').trim();
+    if (!coords && !place) return undefined;
+    const location = coords ? { lat: coords.lat, lon: coords.lon } : {};
+    if (place) location.place = place;
+    return location;
+}
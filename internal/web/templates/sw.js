@@ -1,4 +1,6 @@
 self.addEventListener('fetch', (event) => {
+    // Leave the live update stream to the browser
+    if (event.request.headers.get('Accept') === 'text/event-stream') return;
     // Pass through all requests directly to network
     event.respondWith(fetch(event.request));
 });